@@ -2,24 +2,27 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
-	"os"
 	"reflect"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
 	"github.com/flynnletford/mediamtx/src/auth"
+	"github.com/flynnletford/mediamtx/src/cluster"
 	"github.com/flynnletford/mediamtx/src/conf"
 	"github.com/flynnletford/mediamtx/src/conf/jsonwrapper"
 	"github.com/flynnletford/mediamtx/src/defs"
+	"github.com/flynnletford/mediamtx/src/events"
 	"github.com/flynnletford/mediamtx/src/logger"
 	"github.com/flynnletford/mediamtx/src/protocols/httpp"
 	"github.com/flynnletford/mediamtx/src/recordstore"
@@ -29,6 +32,7 @@ import (
 	"github.com/flynnletford/mediamtx/src/servers/rtsp"
 	"github.com/flynnletford/mediamtx/src/servers/srt"
 	"github.com/flynnletford/mediamtx/src/servers/webrtc"
+	"github.com/flynnletford/mediamtx/src/uploader"
 )
 
 func interfaceIsEmpty(i interface{}) bool {
@@ -81,6 +85,18 @@ func recordingsOfPath(
 type PathManager interface {
 	APIPathsList() (*defs.APIPathList, error)
 	APIPathsGet(string) (*defs.APIPath, error)
+	APIPathsKeyframe(context.Context, string) (*defs.APIPathKeyframe, error)
+	APIRecordersList() (*defs.APIRecorderList, error)
+	APIRecordersGet(uuid.UUID) (*defs.APIRecorder, error)
+	APIRecordersAdd(string, *conf.Path) (*defs.APIRecorder, error)
+	APIRecordersDelete(uuid.UUID) error
+}
+
+// Cluster contains methods used by the API.
+type Cluster interface {
+	Name() string
+	Owner(string) string
+	AlivePeers() []cluster.Peer
 }
 
 // HLSServer contains methods used by the API and Metrics server.
@@ -119,6 +135,11 @@ type WebRTCServer interface {
 	APISessionsKick(uuid.UUID) error
 }
 
+// Uploader contains methods used by the API and Metrics server.
+type Uploader interface {
+	QueueLen() int
+}
+
 type apiAuthManager interface {
 	Authenticate(req *auth.Request) error
 }
@@ -147,6 +168,10 @@ type API struct {
 	HLSServer      HLSServer
 	WebRTCServer   WebRTCServer
 	SRTServer      SRTServer
+	Cluster        Cluster
+	Catalog        Catalog
+	Uploader       Uploader
+	EventBus       *events.Bus
 	Parent         apiParent
 
 	httpServer *httpp.Server
@@ -159,6 +184,12 @@ func (a *API) Initialize() error {
 	router.SetTrustedProxies(a.TrustedProxies.ToTrustedProxies()) //nolint:errcheck
 
 	router.Use(a.middlewareOrigin)
+
+	// probes are registered before the authentication middleware,
+	// in order to allow orchestrators to use them without credentials.
+	router.GET("/healthz", a.onHealthz)
+	router.GET("/readyz", a.onReadyz)
+
 	router.Use(a.middlewareAuth)
 
 	group := router.Group("/v3")
@@ -169,6 +200,9 @@ func (a *API) Initialize() error {
 	group.GET("/config/pathdefaults/get", a.onConfigPathDefaultsGet)
 	group.PATCH("/config/pathdefaults/patch", a.onConfigPathDefaultsPatch)
 
+	group.GET("/logging/get", a.onLoggingGet)
+	group.PATCH("/logging/patch", a.onLoggingPatch)
+
 	group.GET("/config/paths/list", a.onConfigPathsList)
 	group.GET("/config/paths/get/*name", a.onConfigPathsGet)
 	group.POST("/config/paths/add/*name", a.onConfigPathsAdd)
@@ -178,6 +212,7 @@ func (a *API) Initialize() error {
 
 	group.GET("/paths/list", a.onPathsList)
 	group.GET("/paths/get/*name", a.onPathsGet)
+	group.GET("/paths/keyframe/*name", a.onPathsKeyframe)
 
 	if !interfaceIsEmpty(a.HLSServer) {
 		group.GET("/hlsmuxers/list", a.onHLSMuxersList)
@@ -227,6 +262,24 @@ func (a *API) Initialize() error {
 	group.GET("/recordings/list", a.onRecordingsList)
 	group.GET("/recordings/get/*name", a.onRecordingsGet)
 	group.DELETE("/recordings/deletesegment", a.onRecordingDeleteSegment)
+	group.GET("/recordings/verifysegment", a.onRecordingVerifySegment)
+
+	if !interfaceIsEmpty(a.Cluster) {
+		group.GET("/cluster/recordings/get/*name", a.onClusterRecordingsGet)
+	}
+
+	if !interfaceIsEmpty(a.Catalog) {
+		group.GET("/catalog/segments/list", a.onCatalogSegmentsList)
+		group.POST("/catalog/segments/settags", a.onCatalogSegmentsSetTags)
+		group.GET("/catalog/annotations/list", a.onCatalogAnnotationsList)
+		group.POST("/catalog/annotations/add", a.onCatalogAnnotationsAdd)
+		group.DELETE("/catalog/annotations/delete/:id", a.onCatalogAnnotationsDelete)
+	}
+
+	group.GET("/recorders/list", a.onRecordersList)
+	group.GET("/recorders/get/:id", a.onRecordersGet)
+	group.POST("/recorders/add/*name", a.onRecordersAdd)
+	group.DELETE("/recorders/delete/:id", a.onRecordersDelete)
 
 	network, address := restrictnetwork.Restrict("tcp", a.Address)
 
@@ -616,6 +669,40 @@ func (a *API) onPathsGet(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, data)
 }
 
+func (a *API) onPathsKeyframe(ctx *gin.Context) {
+	pathName, ok := paramName(ctx)
+	if !ok {
+		a.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid name"))
+		return
+	}
+
+	rctx, rctxCancel := context.WithTimeout(ctx.Request.Context(), time.Duration(a.ReadTimeout))
+	defer rctxCancel()
+
+	kf, err := a.PathManager.APIPathsKeyframe(rctx, pathName)
+	if err != nil {
+		var err2 defs.PathNoStreamAvailableError
+		switch {
+		case errors.Is(err, conf.ErrPathNotFound), errors.As(err, &err2):
+			a.writeError(ctx, http.StatusNotFound, err)
+		case errors.Is(err, context.DeadlineExceeded):
+			a.writeError(ctx, http.StatusNotFound, fmt.Errorf("no key frame received"))
+		default:
+			a.writeError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	byts, err := h264.AnnexB(kf.AU).Marshal()
+	if err != nil {
+		a.writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.Header("X-Keyframe-Time", kf.Time.Format(time.RFC3339Nano))
+	ctx.Data(http.StatusOK, "video/"+kf.Codec, byts)
+}
+
 func (a *API) onRTSPConnsList(ctx *gin.Context) {
 	data, err := a.RTSPServer.APIConnsList()
 	if err != nil {
@@ -1083,7 +1170,18 @@ func (a *API) onRecordingsList(ctx *gin.Context) {
 	c := a.Conf
 	a.mutex.RUnlock()
 
-	pathNames := recordstore.FindAllPathsWithSegments(c.Paths)
+	var pathNames []string
+
+	if !interfaceIsEmpty(a.Catalog) {
+		var err error
+		pathNames, err = a.Catalog.APIRecordingsList()
+		if err != nil {
+			a.writeError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+	} else {
+		pathNames = recordstore.FindAllPathsWithSegments(c.Paths)
+	}
 
 	data := defs.APIRecordingList{}
 
@@ -1098,6 +1196,15 @@ func (a *API) onRecordingsList(ctx *gin.Context) {
 	data.Items = make([]*defs.APIRecording, len(pathNames))
 
 	for i, pathName := range pathNames {
+		if !interfaceIsEmpty(a.Catalog) {
+			data.Items[i], err = a.Catalog.APIRecordingsGet(pathName)
+			if err != nil {
+				a.writeError(ctx, http.StatusInternalServerError, err)
+				return
+			}
+			continue
+		}
+
 		pathConf, _, _ := conf.FindPathConf(c.Paths, pathName)
 		data.Items[i] = recordingsOfPath(pathConf, pathName)
 	}
@@ -1122,6 +1229,18 @@ func (a *API) onRecordingsGet(ctx *gin.Context) {
 		return
 	}
 
+	if !interfaceIsEmpty(a.Catalog) {
+		var rec *defs.APIRecording
+		rec, err = a.Catalog.APIRecordingsGet(pathName)
+		if err != nil {
+			a.writeError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, rec)
+		return
+	}
+
 	ctx.JSON(http.StatusOK, recordingsOfPath(pathConf, pathName))
 }
 
@@ -1144,20 +1263,197 @@ func (a *API) onRecordingDeleteSegment(ctx *gin.Context) {
 		return
 	}
 
+	segmentPath := segmentPathOf(pathConf, pathName, start)
+
+	err = recordstore.RemoveSegment(segmentPath)
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	a.EventBus.Publish(events.TypeSegmentDelete, pathName, map[string]interface{}{
+		"segmentPath": segmentPath,
+	})
+
+	ctx.Status(http.StatusOK)
+}
+
+func (a *API) onRecordingVerifySegment(ctx *gin.Context) {
+	pathName := ctx.Query("path")
+
+	start, err := time.Parse(time.RFC3339, ctx.Query("start"))
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid 'start' parameter: %w", err))
+		return
+	}
+
+	a.mutex.RLock()
+	c := a.Conf
+	a.mutex.RUnlock()
+
+	pathConf, _, err := conf.FindPathConf(c.Paths, pathName)
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	segmentPath := segmentPathOf(pathConf, pathName, start)
+
+	checksum, valid, err := recordstore.VerifySegment(segmentPath)
+	if err != nil {
+		a.writeError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	data := &defs.APIRecordingSegmentVerification{
+		Start:    start,
+		Checksum: checksum,
+		Valid:    valid,
+	}
+
+	if ctx.Query("remote") == "true" {
+		remoteChecksum, err := uploader.RemoteChecksum(ctx.Request.Context(), pathConf, pathName, segmentPath)
+		if err != nil {
+			a.writeError(ctx, http.StatusInternalServerError, fmt.Errorf("unable to verify remote copy: %w", err))
+			return
+		}
+
+		remoteValid := (remoteChecksum == checksum)
+		data.RemoteValid = &remoteValid
+	}
+
+	ctx.JSON(http.StatusOK, data)
+}
+
+func segmentPathOf(pathConf *conf.Path, pathName string, start time.Time) string {
 	pathFormat := recordstore.PathAddExtension(
 		strings.ReplaceAll(pathConf.RecordPath, "%path", pathName),
 		pathConf.RecordFormat,
 	)
 
-	segmentPath := recordstore.Path{
+	return recordstore.Path{
 		Start: start,
 	}.Encode(pathFormat)
+}
+
+func (a *API) onRecordersList(ctx *gin.Context) {
+	data, err := a.PathManager.APIRecordersList()
+	if err != nil {
+		a.writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
 
-	err = os.Remove(segmentPath)
+	data.ItemCount = len(data.Items)
+	pageCount, err := paginate(&data.Items, ctx.Query("itemsPerPage"), ctx.Query("page"))
 	if err != nil {
 		a.writeError(ctx, http.StatusBadRequest, err)
 		return
 	}
+	data.PageCount = pageCount
+
+	ctx.JSON(http.StatusOK, data)
+}
+
+func (a *API) onRecordersGet(ctx *gin.Context) {
+	uuid, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	data, err := a.PathManager.APIRecordersGet(uuid)
+	if err != nil {
+		if errors.Is(err, defs.ErrRecorderNotFound) {
+			a.writeError(ctx, http.StatusNotFound, err)
+		} else {
+			a.writeError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, data)
+}
+
+func (a *API) onRecordersAdd(ctx *gin.Context) {
+	pathName, ok := paramName(ctx)
+	if !ok {
+		a.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid name"))
+		return
+	}
+
+	var req defs.APIRecorderAdd
+	err := jsonwrapper.Decode(ctx.Request.Body, &req)
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	a.mutex.RLock()
+	c := a.Conf
+	a.mutex.RUnlock()
+
+	pathConf, _, err := conf.FindPathConf(c.Paths, pathName)
+	if err != nil {
+		a.writeError(ctx, http.StatusNotFound, err)
+		return
+	}
+
+	recordConf := pathConf.Clone()
+
+	if req.RecordPath != nil {
+		recordConf.RecordPath = *req.RecordPath
+	}
+	if req.RecordFormat != nil {
+		recordConf.RecordFormat = *req.RecordFormat
+	}
+	if req.RecordPartDuration != nil {
+		recordConf.RecordPartDuration = *req.RecordPartDuration
+	}
+	if req.RecordSegmentDuration != nil {
+		recordConf.RecordSegmentDuration = *req.RecordSegmentDuration
+	}
+	if req.RecordSegmentIndex != nil {
+		recordConf.RecordSegmentIndex = *req.RecordSegmentIndex
+	}
+	if req.RecordRestartPause != nil {
+		recordConf.RecordRestartPause = *req.RecordRestartPause
+	}
+
+	err = recordConf.ValidateRecord(c)
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	data, err := a.PathManager.APIRecordersAdd(pathName, recordConf)
+	if err != nil {
+		if errors.Is(err, conf.ErrPathNotFound) {
+			a.writeError(ctx, http.StatusNotFound, err)
+		} else {
+			a.writeError(ctx, http.StatusBadRequest, err)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, data)
+}
+
+func (a *API) onRecordersDelete(ctx *gin.Context) {
+	uuid, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	err = a.PathManager.APIRecordersDelete(uuid)
+	if err != nil {
+		if errors.Is(err, defs.ErrRecorderNotFound) {
+			a.writeError(ctx, http.StatusNotFound, err)
+		} else {
+			a.writeError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
 
 	ctx.Status(http.StatusOK)
 }
@@ -13,6 +13,7 @@ import (
 
 	"github.com/flynnletford/mediamtx/src/conf"
 	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/recordstore"
 	"github.com/flynnletford/mediamtx/src/test"
 	"github.com/stretchr/testify/require"
 )
@@ -718,3 +719,63 @@ func TestRecordingsDeleteSegment(t *testing.T) {
 	defer res.Body.Close()
 	require.Equal(t, http.StatusOK, res.StatusCode)
 }
+
+func TestRecordingsVerifySegment(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-playback")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cnf := tempConf(t, "pathDefaults:\n"+
+		"  recordPath: "+filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f")+"\n"+
+		"paths:\n"+
+		"  all_others:\n")
+
+	api := API{
+		Address:     "localhost:9997",
+		ReadTimeout: conf.Duration(10 * time.Second),
+		Conf:        cnf,
+		AuthManager: test.NilAuthManager,
+		Parent:      &testParent{},
+	}
+	err = api.Initialize()
+	require.NoError(t, err)
+	defer api.Close()
+
+	err = os.Mkdir(filepath.Join(dir, "mypath1"), 0o755)
+	require.NoError(t, err)
+
+	segmentPath := filepath.Join(dir, "mypath1", "2008-11-07_11-22-00-900000.mp4")
+
+	err = os.WriteFile(segmentPath, []byte("abc"), 0o644)
+	require.NoError(t, err)
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+	hc := &http.Client{Transport: tr}
+
+	u, err := url.Parse("http://localhost:9997/v3/recordings/verifysegment")
+	require.NoError(t, err)
+
+	v := url.Values{}
+	v.Set("path", "mypath1")
+	v.Set("start", time.Date(2008, 11, 0o7, 11, 22, 0, 900000000, time.Local).Format(time.RFC3339Nano))
+	u.RawQuery = v.Encode()
+
+	res, err := hc.Get(u.String())
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	checkError(t, "checksum not found", res.Body)
+
+	err = recordstore.WriteChecksum(segmentPath, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+	require.NoError(t, err)
+
+	var out map[string]interface{}
+	httpRequest(t, hc, http.MethodGet, u.String(), nil, &out)
+	require.Equal(t, map[string]interface{}{
+		"start":       time.Date(2008, 11, 0o7, 11, 22, 0, 900000000, time.Local).Format(time.RFC3339Nano),
+		"checksum":    "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"valid":       true,
+		"remoteValid": nil,
+	}, out)
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/flynnletford/mediamtx/src/catalog"
+	"github.com/flynnletford/mediamtx/src/conf/jsonwrapper"
+	"github.com/flynnletford/mediamtx/src/defs"
+)
+
+// Catalog contains methods used by the API.
+type Catalog interface {
+	APISegmentsList(*catalog.Query) (*defs.APICatalogSegmentList, error)
+	APISegmentsSetTags(string, time.Time, map[string]string) error
+	APIAnnotationsList(*catalog.AnnotationQuery) ([]*defs.APICatalogAnnotation, error)
+	APIAnnotationsAdd(*defs.APICatalogAnnotation) error
+	APIAnnotationsDelete(uuid.UUID) error
+	APIRecordingsList() ([]string, error)
+	APIRecordingsGet(string) (*defs.APIRecording, error)
+}
+
+func parseOptionalTime(ctx *gin.Context, key string) (*time.Time, error) {
+	v := ctx.Query(key)
+	if v == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid '%s' parameter: %w", key, err)
+	}
+
+	return &t, nil
+}
+
+// catalogQuery converts parameters of the request into a query.
+// Pagination is performed by the catalog, therefore only the requested page is read.
+func catalogQuery(ctx *gin.Context) (*catalog.Query, error) {
+	q := &catalog.Query{
+		Path:        ctx.Query("path"),
+		Tag:         ctx.Query("tag"),
+		UploadState: ctx.Query("uploadState"),
+		Limit:       100,
+	}
+
+	var err error
+
+	q.Start, err = parseOptionalTime(ctx, "start")
+	if err != nil {
+		return nil, err
+	}
+
+	q.End, err = parseOptionalTime(ctx, "end")
+	if err != nil {
+		return nil, err
+	}
+
+	if v := ctx.Query("itemsPerPage"); v != "" {
+		tmp, err2 := strconv.ParseUint(v, 10, 31)
+		if err2 != nil {
+			return nil, err2
+		}
+		if tmp == 0 {
+			return nil, fmt.Errorf("invalid items per page")
+		}
+		q.Limit = int(tmp)
+	}
+
+	if v := ctx.Query("page"); v != "" {
+		tmp, err2 := strconv.ParseUint(v, 10, 31)
+		if err2 != nil {
+			return nil, err2
+		}
+		q.Offset = int(tmp) * q.Limit
+	}
+
+	return q, nil
+}
+
+func (a *API) onCatalogSegmentsList(ctx *gin.Context) {
+	q, err := catalogQuery(ctx)
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	data, err := a.Catalog.APISegmentsList(q)
+	if err != nil {
+		a.writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	data.PageCount = data.ItemCount / q.Limit
+	if (data.ItemCount % q.Limit) != 0 {
+		data.PageCount++
+	}
+
+	ctx.JSON(http.StatusOK, data)
+}
+
+func (a *API) onCatalogSegmentsSetTags(ctx *gin.Context) {
+	pathName := ctx.Query("path")
+
+	start, err := time.Parse(time.RFC3339, ctx.Query("start"))
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid 'start' parameter: %w", err))
+		return
+	}
+
+	var in defs.APICatalogSegmentTags
+	err = jsonwrapper.Decode(ctx.Request.Body, &in)
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	for key := range in.Tags {
+		if key == "" || strings.Contains(key, "=") {
+			a.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid tag key: '%s'", key))
+			return
+		}
+	}
+
+	err = a.Catalog.APISegmentsSetTags(pathName, start, in.Tags)
+	if err != nil {
+		if errors.Is(err, catalog.ErrSegmentNotFound) {
+			a.writeError(ctx, http.StatusNotFound, err)
+		} else {
+			a.writeError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+func (a *API) onCatalogAnnotationsList(ctx *gin.Context) {
+	q := &catalog.AnnotationQuery{
+		Path:  ctx.Query("path"),
+		Label: ctx.Query("label"),
+	}
+
+	var err error
+
+	q.Start, err = parseOptionalTime(ctx, "start")
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	q.End, err = parseOptionalTime(ctx, "end")
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	items, err := a.Catalog.APIAnnotationsList(q)
+	if err != nil {
+		a.writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	data := &defs.APICatalogAnnotationList{
+		ItemCount: len(items),
+		Items:     items,
+	}
+
+	pageCount, err := paginate(&data.Items, ctx.Query("itemsPerPage"), ctx.Query("page"))
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	data.PageCount = pageCount
+
+	ctx.JSON(http.StatusOK, data)
+}
+
+func (a *API) onCatalogAnnotationsAdd(ctx *gin.Context) {
+	var in defs.APICatalogAnnotation
+	err := jsonwrapper.Decode(ctx.Request.Body, &in)
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	err = a.Catalog.APIAnnotationsAdd(&in)
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &in)
+}
+
+func (a *API) onCatalogAnnotationsDelete(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	err = a.Catalog.APIAnnotationsDelete(id)
+	if err != nil {
+		if errors.Is(err, catalog.ErrAnnotationNotFound) {
+			a.writeError(ctx, http.StatusNotFound, err)
+		} else {
+			a.writeError(ctx, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/flynnletford/mediamtx/src/cluster"
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/defs"
+	"github.com/flynnletford/mediamtx/src/logger"
+)
+
+// fetchPeerRecording fetches the recording of a path from a peer.
+// Credentials of the original request are forwarded to the peer.
+func (a *API) fetchPeerRecording(
+	ctx *gin.Context,
+	peer cluster.Peer,
+	pathName string,
+) (*defs.APIRecording, error) {
+	u := strings.TrimSuffix(peer.APIURL, "/") + "/v3/recordings/get/" + pathName
+	if ctx.Request.URL.RawQuery != "" {
+		u += "?" + ctx.Request.URL.RawQuery
+	}
+
+	req, err := http.NewRequestWithContext(ctx.Request.Context(), http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if h := ctx.Request.Header.Get("Authorization"); h != "" {
+		req.Header.Set("Authorization", h)
+	}
+
+	hc := &http.Client{
+		Timeout: time.Duration(a.ReadTimeout),
+	}
+
+	res, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	var rec defs.APIRecording
+	err = json.NewDecoder(res.Body).Decode(&rec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rec, nil
+}
+
+func (a *API) onClusterRecordingsGet(ctx *gin.Context) {
+	pathName, ok := paramName(ctx)
+	if !ok {
+		a.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid name"))
+		return
+	}
+
+	a.mutex.RLock()
+	c := a.Conf
+	a.mutex.RUnlock()
+
+	pathConf, _, err := conf.FindPathConf(c.Paths, pathName)
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	out := &defs.APIClusterRecording{
+		Name:             pathName,
+		Owner:            a.Cluster.Owner(pathName),
+		UnreachableNodes: []string{},
+		Segments:         []*defs.APIClusterRecordingSegment{},
+	}
+
+	for _, seg := range recordingsOfPath(pathConf, pathName).Segments {
+		out.Segments = append(out.Segments, &defs.APIClusterRecordingSegment{
+			Start: seg.Start,
+			Node:  a.Cluster.Name(),
+		})
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+
+	for _, peer := range a.Cluster.AlivePeers() {
+		wg.Add(1)
+		go func(peer cluster.Peer) {
+			defer wg.Done()
+
+			rec, err2 := a.fetchPeerRecording(ctx, peer, pathName)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err2 != nil {
+				a.Log(logger.Warn, "unable to get recordings of '%s' from peer '%s': %v", pathName, peer.Name, err2)
+				out.UnreachableNodes = append(out.UnreachableNodes, peer.Name)
+				return
+			}
+
+			for _, seg := range rec.Segments {
+				out.Segments = append(out.Segments, &defs.APIClusterRecordingSegment{
+					Start: seg.Start,
+					Node:  peer.Name,
+				})
+			}
+		}(peer)
+	}
+
+	wg.Wait()
+
+	sort.Strings(out.UnreachableNodes)
+
+	sort.SliceStable(out.Segments, func(i, j int) bool {
+		if !out.Segments[i].Start.Equal(out.Segments[j].Start) {
+			return out.Segments[i].Start.Before(out.Segments[j].Start)
+		}
+		return out.Segments[i].Node < out.Segments[j].Node
+	})
+
+	ctx.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/cluster"
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/test"
+	"github.com/stretchr/testify/require"
+)
+
+type dummyCluster struct {
+	peers []cluster.Peer
+}
+
+func (c *dummyCluster) Name() string {
+	return "node0"
+}
+
+func (c *dummyCluster) Owner(string) string {
+	return "node1"
+}
+
+func (c *dummyCluster) AlivePeers() []cluster.Peer {
+	return c.peers
+}
+
+func TestClusterRecordingsGet(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-playback")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cnf := tempConf(t, "pathDefaults:\n"+
+		"  recordPath: "+filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f")+"\n"+
+		"paths:\n"+
+		"  all_others:\n")
+
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v3/recordings/get/mypath", r.URL.Path)
+		require.Equal(t, "Basic bXl1c2VyOm15cGFzcw==", r.Header.Get("Authorization"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"mypath","segments":[{"start":"` + //nolint:errcheck
+			time.Date(2008, 11, 7, 11, 22, 0, 0, time.UTC).Format(time.RFC3339Nano) + `"}]}`))
+	}))
+	defer peer.Close()
+
+	api := API{
+		Address:     "localhost:9997",
+		ReadTimeout: conf.Duration(10 * time.Second),
+		Conf:        cnf,
+		AuthManager: test.NilAuthManager,
+		Cluster: &dummyCluster{
+			peers: []cluster.Peer{
+				{Name: "node1", APIURL: peer.URL},
+				{Name: "node2", APIURL: "http://127.0.0.1:1"},
+			},
+		},
+		Parent: &testParent{},
+	}
+	err = api.Initialize()
+	require.NoError(t, err)
+	defer api.Close()
+
+	err = os.Mkdir(filepath.Join(dir, "mypath"), 0o755)
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, "mypath", "2009-11-07_11-22-00-900000.mp4"), []byte(""), 0o644)
+	require.NoError(t, err)
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+	hc := &http.Client{Transport: tr}
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:9997/v3/cluster/recordings/get/mypath", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("myuser", "mypass")
+
+	res, err := hc.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var out interface{}
+	err = json.NewDecoder(res.Body).Decode(&out)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]interface{}{
+		"name":             "mypath",
+		"owner":            "node1",
+		"unreachableNodes": []interface{}{"node2"},
+		"segments": []interface{}{
+			map[string]interface{}{
+				"start": time.Date(2008, 11, 7, 11, 22, 0, 0, time.UTC).Format(time.RFC3339Nano),
+				"node":  "node1",
+			},
+			map[string]interface{}{
+				"start": time.Date(2009, 11, 7, 11, 22, 0, 900000000, time.Local).Format(time.RFC3339Nano),
+				"node":  "node0",
+			},
+		},
+	}, out)
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/defs"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+)
+
+const (
+	// minimum time without writes after which a recording is considered stalled.
+	recordingMinStall = 30 * time.Second
+)
+
+func writeHealth(ctx *gin.Context, checks []defs.APIHealthCheck) {
+	h := &defs.APIHealth{
+		OK:     true,
+		Checks: checks,
+	}
+
+	for _, c := range checks {
+		if !c.OK {
+			h.OK = false
+			break
+		}
+	}
+
+	if h.OK {
+		ctx.JSON(http.StatusOK, h)
+	} else {
+		ctx.JSON(http.StatusServiceUnavailable, h)
+	}
+}
+
+func newHealthCheck(name string, err error) defs.APIHealthCheck {
+	if err != nil {
+		return defs.APIHealthCheck{Name: name, OK: false, Error: err.Error()}
+	}
+	return defs.APIHealthCheck{Name: name, OK: true}
+}
+
+// checkDirWritable checks whether files can be created inside a directory.
+func checkDirWritable(dir string) error {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, ".mediamtx-healthcheck-*")
+	if err != nil {
+		return err
+	}
+
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func (a *API) listenersChecks(c *conf.Conf) []defs.APIHealthCheck {
+	var ret []defs.APIHealthCheck
+
+	add := func(name string, enabled bool, server interface{}) {
+		if !enabled {
+			return
+		}
+
+		var err error
+		if interfaceIsEmpty(server) {
+			err = fmt.Errorf("listener is not running")
+		}
+
+		ret = append(ret, newHealthCheck("listener:"+name, err))
+	}
+
+	add("rtsp", c.RTSP && c.RTSPEncryption != conf.EncryptionStrict, a.RTSPServer)
+	add("rtsps", c.RTSP && c.RTSPEncryption != conf.EncryptionNo, a.RTSPSServer)
+	add("rtmp", c.RTMP && c.RTMPEncryption != conf.EncryptionStrict, a.RTMPServer)
+	add("rtmps", c.RTMP && c.RTMPEncryption != conf.EncryptionNo, a.RTMPSServer)
+	add("hls", c.HLS, a.HLSServer)
+	add("webrtc", c.WebRTC, a.WebRTCServer)
+	add("srt", c.SRT, a.SRTServer)
+
+	return ret
+}
+
+func disksChecks(c *conf.Conf) []defs.APIHealthCheck {
+	dirs := make(map[string]struct{})
+
+	for _, pathConf := range c.Paths {
+		if pathConf.Record {
+			dir := recordstore.CommonPath(pathConf.RecordPath)
+			if dir == "" {
+				dir = "."
+			}
+			dirs[filepath.Clean(dir)] = struct{}{}
+		}
+	}
+
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+
+	ret := make([]defs.APIHealthCheck, 0, len(sorted))
+
+	for _, dir := range sorted {
+		ret = append(ret, newHealthCheck("disk:"+dir, checkDirWritable(dir)))
+	}
+
+	return ret
+}
+
+func recordingsChecks(c *conf.Conf, paths *defs.APIPathList) []defs.APIHealthCheck {
+	var ret []defs.APIHealthCheck
+
+	now := time.Now()
+
+	for _, pa := range paths.Items {
+		if !pa.Ready || pa.ReadyTime == nil {
+			continue
+		}
+
+		maxStall := recordingMinStall
+		if pathConf, ok := c.Paths[pa.ConfName]; ok {
+			if v := 3 * time.Duration(pathConf.RecordPartDuration); v > maxStall {
+				maxStall = v
+			}
+		}
+
+		for _, r := range pa.Recorders {
+			last := *pa.ReadyTime
+			if r.LastWrite != nil && r.LastWrite.After(last) {
+				last = *r.LastWrite
+			}
+
+			name := "recording:" + pa.Name
+			if r.ID != nil {
+				name += ":" + r.ID.String()
+			}
+
+			var err error
+			if age := now.Sub(last); age > maxStall {
+				err = fmt.Errorf("last segment write is %v old", age.Truncate(time.Second))
+			}
+
+			ret = append(ret, newHealthCheck(name, err))
+		}
+	}
+
+	return ret
+}
+
+func uploaderChecks(c *conf.Conf, u Uploader) []defs.APIHealthCheck {
+	if c.UploadMaxBacklog == 0 || interfaceIsEmpty(u) {
+		return nil
+	}
+
+	var err error
+	if n := u.QueueLen(); n > c.UploadMaxBacklog {
+		err = fmt.Errorf("%d segments are waiting to be uploaded", n)
+	}
+
+	return []defs.APIHealthCheck{newHealthCheck("uploader", err)}
+}
+
+// onHealthz is a liveness probe: it checks whether the server is able to reply to requests.
+func (a *API) onHealthz(ctx *gin.Context) {
+	_, err := a.PathManager.APIPathsList()
+	writeHealth(ctx, []defs.APIHealthCheck{newHealthCheck("pathManager", err)})
+}
+
+// onReadyz is a readiness probe: it checks whether listeners are running,
+// whether recording directories are writable, whether recordings are progressing
+// and whether the upload backlog does not exceed uploadMaxBacklog.
+func (a *API) onReadyz(ctx *gin.Context) {
+	a.mutex.RLock()
+	c := a.Conf
+	a.mutex.RUnlock()
+
+	checks := a.listenersChecks(c)
+	checks = append(checks, disksChecks(c)...)
+
+	paths, err := a.PathManager.APIPathsList()
+	if err != nil {
+		checks = append(checks, newHealthCheck("pathManager", err))
+	} else {
+		checks = append(checks, recordingsChecks(c, paths)...)
+	}
+
+	checks = append(checks, uploaderChecks(c, a.Uploader)...)
+
+	if checks == nil {
+		checks = []defs.APIHealthCheck{}
+	}
+
+	writeHealth(ctx, checks)
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/auth"
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/defs"
+)
+
+type denyAuthManager struct{}
+
+func (denyAuthManager) Authenticate(_ *auth.Request) error {
+	return auth.Error{Message: "denied", AskCredentials: true}
+}
+
+type dummyPathManager struct {
+	paths *defs.APIPathList
+}
+
+func (pm *dummyPathManager) APIPathsList() (*defs.APIPathList, error) {
+	return pm.paths, nil
+}
+
+func (pm *dummyPathManager) APIPathsGet(string) (*defs.APIPath, error) {
+	panic("unused")
+}
+
+func (pm *dummyPathManager) APIPathsKeyframe(context.Context, string) (*defs.APIPathKeyframe, error) {
+	panic("unused")
+}
+
+func (pm *dummyPathManager) APIRecordersList() (*defs.APIRecorderList, error) {
+	panic("unused")
+}
+
+func (pm *dummyPathManager) APIRecordersGet(uuid.UUID) (*defs.APIRecorder, error) {
+	panic("unused")
+}
+
+func (pm *dummyPathManager) APIRecordersAdd(string, *conf.Path) (*defs.APIRecorder, error) {
+	panic("unused")
+}
+
+func (pm *dummyPathManager) APIRecordersDelete(uuid.UUID) error {
+	panic("unused")
+}
+
+type dummyUploader struct {
+	queueLen int
+}
+
+func (u *dummyUploader) QueueLen() int {
+	return u.queueLen
+}
+
+func TestHealth(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-health")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cnf := tempConf(t, "rtmp: no\n"+
+		"hls: no\n"+
+		"webrtc: no\n"+
+		"srt: no\n"+
+		"uploadMaxBacklog: 2\n"+
+		"paths:\n"+
+		"  mypath1:\n"+
+		"    record: yes\n"+
+		"    recordPath: "+filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f")+"\n"+
+		"  mypath2:\n"+
+		"    record: yes\n"+
+		"    recordPath: "+filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f")+"\n")
+
+	readyTime := time.Now().Add(-1 * time.Hour)
+	lastWrite1 := time.Now()
+	lastWrite2 := time.Now().Add(-5 * time.Minute)
+
+	api := API{
+		Address:     "localhost:9997",
+		ReadTimeout: conf.Duration(10 * time.Second),
+		Conf:        cnf,
+		AuthManager: &denyAuthManager{},
+		PathManager: &dummyPathManager{
+			paths: &defs.APIPathList{
+				Items: []*defs.APIPath{
+					{
+						Name:      "mypath1",
+						ConfName:  "mypath1",
+						Ready:     true,
+						ReadyTime: &readyTime,
+						Recorders: []defs.APIPathRecorderStats{{LastWrite: &lastWrite1}},
+					},
+					{
+						Name:      "mypath2",
+						ConfName:  "mypath2",
+						Ready:     true,
+						ReadyTime: &readyTime,
+						Recorders: []defs.APIPathRecorderStats{{LastWrite: &lastWrite2}},
+					},
+				},
+			},
+		},
+		Uploader: &dummyUploader{queueLen: 3},
+		Parent:   &testParent{},
+	}
+	err = api.Initialize()
+	require.NoError(t, err)
+	defer api.Close()
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+	hc := &http.Client{Transport: tr}
+
+	t.Run("healthz", func(t *testing.T) {
+		res, err := hc.Get("http://localhost:9997/healthz")
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		var out defs.APIHealth
+		err = json.NewDecoder(res.Body).Decode(&out)
+		require.NoError(t, err)
+		require.Equal(t, defs.APIHealth{
+			OK: true,
+			Checks: []defs.APIHealthCheck{
+				{Name: "pathManager", OK: true},
+			},
+		}, out)
+	})
+
+	t.Run("readyz", func(t *testing.T) {
+		res, err := hc.Get("http://localhost:9997/readyz")
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+		var out defs.APIHealth
+		err = json.NewDecoder(res.Body).Decode(&out)
+		require.NoError(t, err)
+		require.Equal(t, defs.APIHealth{
+			OK: false,
+			Checks: []defs.APIHealthCheck{
+				{Name: "listener:rtsp", OK: false, Error: "listener is not running"},
+				{Name: "disk:" + dir, OK: true},
+				{Name: "recording:mypath1", OK: true},
+				{Name: "recording:mypath2", OK: false, Error: "last segment write is 5m0s old"},
+				{Name: "uploader", OK: false, Error: "3 segments are waiting to be uploaded"},
+			},
+		}, out)
+	})
+
+	t.Run("authentication is still required by other endpoints", func(t *testing.T) {
+		res, err := hc.Get("http://localhost:9997/v3/paths/list")
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+}
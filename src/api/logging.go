@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/conf/jsonwrapper"
+	"github.com/flynnletford/mediamtx/src/defs"
+)
+
+type loggingPatch struct {
+	LogLevel        *conf.LogLevel   `json:"logLevel"`
+	LogDebugModules *conf.LogModules `json:"logDebugModules"`
+}
+
+func (a *API) onLoggingGet(ctx *gin.Context) {
+	a.mutex.RLock()
+	c := a.Conf
+	a.mutex.RUnlock()
+
+	ctx.JSON(http.StatusOK, &defs.APILogging{
+		LogLevel:        c.LogLevel,
+		LogDebugModules: c.LogDebugModules,
+	})
+}
+
+// onLoggingPatch changes logging parameters.
+// They are applied without closing any component.
+func (a *API) onLoggingPatch(ctx *gin.Context) {
+	var in loggingPatch
+	err := jsonwrapper.Decode(ctx.Request.Body, &in)
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	newConf := a.Conf.Clone()
+
+	if in.LogLevel != nil {
+		newConf.LogLevel = *in.LogLevel
+	}
+	if in.LogDebugModules != nil {
+		newConf.LogDebugModules = *in.LogDebugModules
+	}
+
+	err = newConf.Validate(nil)
+	if err != nil {
+		a.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	a.Conf = newConf
+
+	go a.Parent.APIConfigSet(newConf)
+
+	ctx.Status(http.StatusOK)
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/test"
+	"github.com/stretchr/testify/require"
+)
+
+type testLoggingParent struct {
+	testParent
+	confs chan *conf.Conf
+}
+
+func (p *testLoggingParent) APIConfigSet(c *conf.Conf) {
+	p.confs <- c
+}
+
+func TestLogging(t *testing.T) {
+	cnf := tempConf(t, "api: yes\n")
+
+	parent := &testLoggingParent{confs: make(chan *conf.Conf, 1)}
+
+	api := API{
+		Address:     "localhost:9997",
+		ReadTimeout: conf.Duration(10 * time.Second),
+		Conf:        cnf,
+		AuthManager: test.NilAuthManager,
+		Parent:      parent,
+	}
+	err := api.Initialize()
+	require.NoError(t, err)
+	defer api.Close()
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+	hc := &http.Client{Transport: tr}
+
+	var out map[string]interface{}
+	httpRequest(t, hc, http.MethodGet, "http://localhost:9997/v3/logging/get", nil, &out)
+	require.Equal(t, map[string]interface{}{
+		"logLevel":        "info",
+		"logDebugModules": []interface{}{},
+	}, out)
+
+	httpRequest(t, hc, http.MethodPatch, "http://localhost:9997/v3/logging/patch",
+		map[string]interface{}{
+			"logDebugModules": []string{"recorder", "webrtc"},
+		}, nil)
+
+	newConf := <-parent.confs
+	require.Equal(t, conf.LogModules{"recorder", "webrtc"}, newConf.LogDebugModules)
+
+	httpRequest(t, hc, http.MethodGet, "http://localhost:9997/v3/logging/get", nil, &out)
+	require.Equal(t, map[string]interface{}{
+		"logLevel":        "info",
+		"logDebugModules": []interface{}{"recorder", "webrtc"},
+	}, out)
+
+	req, err := http.NewRequest(http.MethodPatch, "http://localhost:9997/v3/logging/patch",
+		strings.NewReader(`{"logLevel":"invalid"}`))
+	require.NoError(t, err)
+
+	res, err := hc.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	checkError(t, "invalid log level: 'invalid'", res.Body)
+}
@@ -136,6 +136,15 @@ func (m *Manager) authenticateWithUser(
 		return false
 	}
 
+	// users of a tenant can only access paths of the tenant,
+	// therefore actions that are not bound to a path are not allowed.
+	if u.Tenant != "" {
+		tenant := conf.Tenant{Name: u.Tenant}
+		if !tenant.Owns(req.Path) {
+			return false
+		}
+	}
+
 	if !matchesPermission(u.Permissions, req) {
 		return false
 	}
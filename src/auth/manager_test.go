@@ -187,6 +187,51 @@ func TestAuthInternalCustomVerifyFunc(t *testing.T) {
 	}
 }
 
+func TestAuthInternalTenant(t *testing.T) {
+	m := Manager{
+		Method: conf.AuthMethodInternal,
+		InternalUsers: []conf.AuthInternalUser{
+			{
+				User:   "myuser",
+				Pass:   "mypass",
+				IPs:    conf.IPNetworks{mustParseCIDR("127.1.1.1/32")},
+				Tenant: "acme",
+				Permissions: []conf.AuthInternalUserPermission{
+					{Action: conf.AuthActionPublish},
+					{Action: conf.AuthActionAPI},
+				},
+			},
+		},
+	}
+
+	for _, ca := range []struct {
+		name   string
+		action conf.AuthAction
+		path   string
+		ok     bool
+	}{
+		{"tenant path", conf.AuthActionPublish, "acme/cam1", true},
+		{"other tenant path", conf.AuthActionPublish, "other/cam1", false},
+		{"prefix of tenant name", conf.AuthActionPublish, "acme2/cam1", false},
+		{"api", conf.AuthActionAPI, "", false},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			err := m.Authenticate(&Request{
+				User:   "myuser",
+				Pass:   "mypass",
+				IP:     net.ParseIP("127.1.1.1"),
+				Action: ca.action,
+				Path:   ca.path,
+			})
+			if ca.ok {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
 func TestAuthInternalCredentialsInBearer(t *testing.T) {
 	m := Manager{
 		Method: conf.AuthMethodInternal,
@@ -0,0 +1,525 @@
+// Package catalog contains the recording catalog.
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/defs"
+	"github.com/flynnletford/mediamtx/src/events"
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+)
+
+// ErrSegmentNotFound is returned when a segment is not in the catalog.
+var ErrSegmentNotFound = errors.New("segment not found")
+
+// ErrAnnotationNotFound is returned when an annotation is not in the catalog.
+var ErrAnnotationNotFound = errors.New("annotation not found")
+
+// Query is a query of segments.
+// Empty fields are not used as filters.
+type Query struct {
+	Path        string
+	Start       *time.Time
+	End         *time.Time
+	Tag         string
+	UploadState string
+
+	// when Limit is zero, all segments are returned.
+	Limit  int
+	Offset int
+}
+
+// AnnotationQuery is a query of annotations.
+// Empty fields are not used as filters.
+type AnnotationQuery struct {
+	Path  string
+	Start *time.Time
+	End   *time.Time
+	Label string
+}
+
+type segment struct {
+	fpath       string
+	pathName    string
+	start       time.Time
+	duration    time.Duration
+	size        uint64
+	tracks      []string
+	checksum    string
+	uploadState string
+	remote      bool
+}
+
+type store interface {
+	close()
+	upsert(seg *segment) error
+	setUploadState(fpath string, uploadState string) error
+	setRemote(fpath string, uploadState string) (bool, error)
+	remove(fpath string) error
+	paths() ([]string, error)
+	pathSegments(pathName string) (map[string]bool, error)
+	list(q *Query) ([]*defs.APICatalogSegment, int, error)
+	setTags(pathName string, start time.Time, tags map[string]string) error
+	addAnnotation(a *defs.APICatalogAnnotation) error
+	removeAnnotation(id uuid.UUID) error
+	listAnnotations(q *AnnotationQuery) ([]*defs.APICatalogAnnotation, error)
+}
+
+// Catalog is a sink that stores metadata of recording segments into a database,
+// in order to allow queries without scanning directories.
+// Segments are added when they are completed, and updated when they are uploaded or removed.
+// When the catalog starts, it is synchronized with the segments that are on disk.
+type Catalog struct {
+	Database    string
+	QueueSize   int
+	PathConfs   map[string]*conf.Path
+	ReadTimeout conf.Duration
+	Parent      logger.Writer
+
+	ctx        context.Context
+	ctxCancel  func()
+	store      store
+	storeMutex sync.Mutex
+	mutex      sync.RWMutex
+	queue      chan *events.Event
+
+	done chan struct{}
+}
+
+// Initialize initializes Catalog.
+func (c *Catalog) Initialize() error {
+	pc, err := parseDatabaseURL(c.Database)
+	if err != nil {
+		return fmt.Errorf("invalid 'catalogDatabase': %w", err)
+	}
+
+	c.store = &pgStore{
+		conf:    pc,
+		timeout: time.Duration(c.ReadTimeout),
+	}
+
+	c.ctx, c.ctxCancel = context.WithCancel(context.Background())
+	c.queue = make(chan *events.Event, c.QueueSize)
+	c.done = make(chan struct{})
+
+	c.Log(logger.Info, "storing segments into %s", pc.address)
+
+	go c.run()
+
+	return nil
+}
+
+// Close closes Catalog.
+// Events that are still in queue are discarded, and are recovered by the
+// synchronization that takes place after a restart.
+func (c *Catalog) Close() {
+	c.Log(logger.Info, "closing")
+	c.ctxCancel()
+	<-c.done
+
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+	c.store.close()
+}
+
+// Log implements logger.Writer.
+func (c *Catalog) Log(level logger.Level, format string, args ...interface{}) {
+	c.Parent.Log(level, "[catalog] "+format, args...)
+}
+
+// ReloadPathConfs is called by core.Core.
+func (c *Catalog) ReloadPathConfs(pathConfs map[string]*conf.Path) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.PathConfs = pathConfs
+}
+
+// Push implements events.Sink.
+func (c *Catalog) Push(e *events.Event) {
+	switch e.Type {
+	case events.TypeSegmentComplete, events.TypeSegmentUpload, events.TypeSegmentDelete:
+	default:
+		return
+	}
+
+	select {
+	case c.queue <- e:
+	default:
+		c.Log(logger.Warn, "queue is full, discarding %s event", e.Type)
+	}
+}
+
+func (c *Catalog) run() {
+	defer close(c.done)
+
+	c.sync()
+
+	for {
+		select {
+		case e := <-c.queue:
+			c.process(e)
+
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Catalog) safePathConfs() map[string]*conf.Path {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.PathConfs
+}
+
+func (c *Catalog) withStore(cb func(s store) error) error {
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+	return cb(c.store)
+}
+
+// sync adds segments that are on disk and not in the catalog,
+// and removes segments that are in the catalog and not on disk anymore.
+func (c *Catalog) sync() {
+	pathConfs := c.safePathConfs()
+	pathNames := recordstore.FindAllPathsWithSegments(pathConfs)
+
+	var storedPaths []string
+	err := c.withStore(func(s store) error {
+		var err error
+		storedPaths, err = s.paths()
+		return err
+	})
+	if err != nil {
+		c.Log(logger.Warn, "unable to synchronize: %v", err)
+		return
+	}
+
+	// paths that are in the catalog and not on disk anymore are synchronized too,
+	// in order to remove their segments.
+	pathNames = append(pathNames, storedPaths...)
+	synced := make(map[string]struct{})
+
+	for _, pathName := range pathNames {
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		if _, ok := synced[pathName]; ok {
+			continue
+		}
+		synced[pathName] = struct{}{}
+
+		err = c.syncPath(pathConfs, pathName)
+		if err != nil {
+			c.Log(logger.Warn, "unable to synchronize '%s': %v", pathName, err)
+		}
+	}
+
+	c.Log(logger.Info, "synchronized %d paths", len(synced))
+}
+
+func (c *Catalog) syncPath(pathConfs map[string]*conf.Path, pathName string) error {
+	var stored map[string]bool
+	err := c.withStore(func(s store) error {
+		var err error
+		stored, err = s.pathSegments(pathName)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var segments []*recordstore.Segment
+
+	if pathConf, _, err2 := conf.FindPathConf(pathConfs, pathName); err2 == nil {
+		segments, _ = recordstore.FindSegments(pathConf, pathName, nil, nil)
+	}
+
+	for _, seg := range segments {
+		if c.ctx.Err() != nil {
+			return nil
+		}
+
+		remote, ok := stored[seg.Fpath]
+		delete(stored, seg.Fpath)
+
+		if ok && remote == seg.Remote {
+			continue
+		}
+
+		err = c.index(pathName, seg)
+		if err != nil {
+			c.Log(logger.Warn, "unable to index %s: %v", seg.Fpath, err)
+		}
+	}
+
+	for fpath := range stored {
+		err = c.withStore(func(s store) error {
+			return s.remove(fpath)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// index reads the metadata of a segment and stores it into the catalog.
+func (c *Catalog) index(pathName string, seg *recordstore.Segment) error {
+	entry := &segment{
+		fpath:    seg.Fpath,
+		pathName: pathName,
+		start:    seg.Start,
+		tracks:   []string{},
+		remote:   seg.Remote,
+	}
+
+	st, err := recordstore.ReadUploadStatus(seg.Fpath)
+	if err == nil {
+		entry.uploadState = string(st.State)
+		entry.checksum = st.Checksum
+	}
+
+	if seg.Remote {
+		if st == nil {
+			return err
+		}
+
+		// the duration is saved into the upload status when the local copy is removed.
+		entry.duration = time.Duration(st.Duration * float64(time.Second))
+	} else {
+		var info *recordstore.SegmentInfo
+		info, err = recordstore.InspectSegment(seg.Fpath)
+
+		if err == nil {
+			entry.size = info.Size
+
+			if info.Finalized() {
+				entry.duration = info.Duration
+			} else {
+				entry.duration = info.PartsDuration
+			}
+
+			for _, track := range info.Tracks {
+				entry.tracks = append(entry.tracks, track.Codec)
+			}
+		} else {
+			// metadata of MPEG-TS segments is not available, store the size only.
+			var fi os.FileInfo
+			fi, err = os.Stat(seg.Fpath)
+			if err != nil {
+				return err
+			}
+
+			entry.size = uint64(fi.Size())
+		}
+
+		if checksum, err2 := recordstore.ReadChecksum(seg.Fpath); err2 == nil {
+			entry.checksum = checksum
+		}
+	}
+
+	return c.withStore(func(s store) error {
+		return s.upsert(entry)
+	})
+}
+
+func (c *Catalog) process(e *events.Event) {
+	segmentPath, ok := e.Data["segmentPath"].(string)
+	if !ok {
+		return
+	}
+
+	// segments are identified by their absolute path,
+	// since this is what directory scans return.
+	segmentPath, _ = filepath.Abs(segmentPath)
+
+	var err error
+
+	switch e.Type {
+	case events.TypeSegmentComplete:
+		err = c.onSegmentComplete(e.Path, segmentPath)
+
+	case events.TypeSegmentUpload:
+		state, _ := e.Data["state"].(string)
+		err = c.withStore(func(s store) error {
+			return s.setUploadState(segmentPath, state)
+		})
+
+	case events.TypeSegmentDelete:
+		err = c.onSegmentDelete(e, segmentPath)
+	}
+
+	if err != nil {
+		c.Log(logger.Warn, "unable to process %s event of %s: %v", e.Type, segmentPath, err)
+	}
+}
+
+func (c *Catalog) onSegmentComplete(pathName string, segmentPath string) error {
+	pathConf, _, err := conf.FindPathConf(c.safePathConfs(), pathName)
+	if err != nil {
+		return err
+	}
+
+	// segments may have been written into the overflow directory.
+	for _, recordPath := range []string{pathConf.RecordPath, pathConf.RecordOverflowPath} {
+		if recordPath == "" {
+			continue
+		}
+
+		pathFormat := recordstore.PathAddExtension(
+			strings.ReplaceAll(recordPath, "%path", pathName),
+			pathConf.RecordFormat,
+		)
+		pathFormat, _ = filepath.Abs(pathFormat)
+
+		var pa recordstore.Path
+		if pa.Decode(pathFormat, segmentPath) {
+			return c.index(pathName, &recordstore.Segment{
+				Fpath: segmentPath,
+				Start: pa.Start,
+			})
+		}
+	}
+
+	return fmt.Errorf("unable to decode segment path")
+}
+
+func (c *Catalog) onSegmentDelete(e *events.Event, segmentPath string) error {
+	// the local copy has been removed and the segment is available in the upload storage.
+	if remote, _ := e.Data["remote"].(bool); remote {
+		return c.withStore(func(s store) error {
+			_, err := s.setRemote(segmentPath, string(recordstore.UploadStateUploaded))
+			return err
+		})
+	}
+
+	err := c.withStore(func(s store) error {
+		return s.remove(segmentPath)
+	})
+	if err != nil {
+		return err
+	}
+
+	// when a segment is trimmed, its content changes and a new segment may be created.
+	if trimmed, _ := e.Data["trimmed"].(bool); trimmed {
+		return c.syncPath(c.safePathConfs(), e.Path)
+	}
+
+	return nil
+}
+
+// APISegmentsList is called by api.API.
+func (c *Catalog) APISegmentsList(q *Query) (*defs.APICatalogSegmentList, error) {
+	var items []*defs.APICatalogSegment
+	var count int
+
+	err := c.withStore(func(s store) error {
+		var err error
+		items, count, err = s.list(q)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &defs.APICatalogSegmentList{
+		ItemCount: count,
+		Items:     items,
+	}, nil
+}
+
+// APISegmentsSetTags is called by api.API.
+func (c *Catalog) APISegmentsSetTags(pathName string, start time.Time, tags map[string]string) error {
+	return c.withStore(func(s store) error {
+		return s.setTags(pathName, start, tags)
+	})
+}
+
+// APIAnnotationsList is called by api.API.
+func (c *Catalog) APIAnnotationsList(q *AnnotationQuery) ([]*defs.APICatalogAnnotation, error) {
+	var out []*defs.APICatalogAnnotation
+
+	err := c.withStore(func(s store) error {
+		var err error
+		out, err = s.listAnnotations(q)
+		return err
+	})
+
+	return out, err
+}
+
+// APIAnnotationsAdd is called by api.API.
+func (c *Catalog) APIAnnotationsAdd(a *defs.APICatalogAnnotation) error {
+	if a.Path == "" {
+		return fmt.Errorf("path is empty")
+	}
+	if a.Label == "" {
+		return fmt.Errorf("label is empty")
+	}
+	if !a.End.After(a.Start) {
+		return fmt.Errorf("end is not after start")
+	}
+
+	a.ID = uuid.New()
+	if a.Tags == nil {
+		a.Tags = map[string]string{}
+	}
+
+	return c.withStore(func(s store) error {
+		return s.addAnnotation(a)
+	})
+}
+
+// APIAnnotationsDelete is called by api.API.
+func (c *Catalog) APIAnnotationsDelete(id uuid.UUID) error {
+	return c.withStore(func(s store) error {
+		return s.removeAnnotation(id)
+	})
+}
+
+// APIRecordingsList is called by api.API.
+func (c *Catalog) APIRecordingsList() ([]string, error) {
+	var out []string
+
+	err := c.withStore(func(s store) error {
+		var err error
+		out, err = s.paths()
+		return err
+	})
+
+	return out, err
+}
+
+// APIRecordingsGet is called by api.API.
+func (c *Catalog) APIRecordingsGet(pathName string) (*defs.APIRecording, error) {
+	list, err := c.APISegmentsList(&Query{Path: pathName})
+	if err != nil {
+		return nil, err
+	}
+
+	out := &defs.APIRecording{
+		Name:     pathName,
+		Segments: make([]*defs.APIRecordingSegment, len(list.Items)),
+	}
+
+	for i, seg := range list.Items {
+		out.Segments[i] = &defs.APIRecordingSegment{
+			Start: seg.Start,
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,251 @@
+// Package cluster contains the recorder cluster.
+package cluster
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/logger"
+)
+
+// Listener is notified when the assignment of recordings changes.
+type Listener interface {
+	ClusterAssignmentsChanged()
+}
+
+// Peer is an alive peer.
+type Peer struct {
+	Name   string
+	APIURL string
+}
+
+func score(node string, pathName string) uint64 {
+	h := sha256.New()
+	h.Write([]byte(node))
+	h.Write([]byte{0})
+	h.Write([]byte(pathName))
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// Cluster is a set of nodes that share recordings.
+// Every node knows the other nodes through a static list of peers,
+// whose liveness is checked periodically through their /healthz endpoint.
+// The recording of every path is assigned to a single alive node
+// through rendezvous hashing, therefore when a node goes down,
+// its recordings are re-assigned to the remaining nodes.
+type Cluster struct {
+	NodeName      string
+	Peers         conf.ClusterPeers
+	CheckInterval conf.Duration
+	ReadTimeout   conf.Duration
+	Parent        logger.Writer
+
+	ctx        context.Context
+	ctxCancel  func()
+	httpClient *http.Client
+
+	mutex     sync.RWMutex
+	alive     map[string]bool
+	listeners []Listener
+
+	done chan struct{}
+}
+
+// Initialize initializes Cluster.
+func (c *Cluster) Initialize() {
+	c.ctx, c.ctxCancel = context.WithCancel(context.Background())
+
+	c.httpClient = &http.Client{
+		Timeout: time.Duration(c.ReadTimeout),
+	}
+
+	// peers are considered down until the first check.
+	c.alive = make(map[string]bool)
+
+	c.done = make(chan struct{})
+
+	c.Log(logger.Info, "node '%s' joined a cluster with %d peers", c.NodeName, len(c.Peers))
+
+	go c.run()
+}
+
+// Close closes Cluster.
+func (c *Cluster) Close() {
+	c.ctxCancel()
+	<-c.done
+}
+
+// Log implements logger.Writer.
+func (c *Cluster) Log(level logger.Level, format string, args ...interface{}) {
+	c.Parent.Log(level, "[cluster] "+format, args...)
+}
+
+// AddListener adds a listener.
+func (c *Cluster) AddListener(l Listener) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.listeners = append(c.listeners, l)
+}
+
+// RemoveListener removes a listener.
+func (c *Cluster) RemoveListener(l Listener) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i, cur := range c.listeners {
+		if cur == l {
+			c.listeners = append(c.listeners[:i], c.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// Name returns the name of this node.
+func (c *Cluster) Name() string {
+	return c.NodeName
+}
+
+// Owner returns the name of the node that is in charge of recording a path.
+func (c *Cluster) Owner(pathName string) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	owner := c.NodeName
+	best := score(c.NodeName, pathName)
+
+	for _, p := range c.Peers {
+		if !c.alive[p.Name] {
+			continue
+		}
+
+		s := score(p.Name, pathName)
+		if s > best || (s == best && p.Name < owner) {
+			owner = p.Name
+			best = s
+		}
+	}
+
+	return owner
+}
+
+// IsOwner checks whether this node is in charge of recording a path.
+// It can be called on a nil Cluster, in which case it returns true.
+func (c *Cluster) IsOwner(pathName string) bool {
+	if c == nil {
+		return true
+	}
+	return c.Owner(pathName) == c.NodeName
+}
+
+// AlivePeers returns peers that are alive.
+func (c *Cluster) AlivePeers() []Peer {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	var out []Peer
+
+	for _, p := range c.Peers {
+		if c.alive[p.Name] {
+			out = append(out, Peer{
+				Name:   p.Name,
+				APIURL: p.APIURL,
+			})
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out
+}
+
+func (c *Cluster) run() {
+	defer close(c.done)
+
+	for {
+		c.checkPeers()
+
+		select {
+		case <-time.After(time.Duration(c.CheckInterval)):
+
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Cluster) checkPeers() {
+	alive := make(map[string]bool)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+
+	for _, p := range c.Peers {
+		wg.Add(1)
+		go func(p conf.ClusterPeer) {
+			defer wg.Done()
+			ok := c.checkPeer(p)
+			mutex.Lock()
+			alive[p.Name] = ok
+			mutex.Unlock()
+		}(p)
+	}
+
+	wg.Wait()
+
+	if c.ctx.Err() != nil {
+		return
+	}
+
+	c.mutex.Lock()
+
+	changed := false
+
+	for _, p := range c.Peers {
+		if alive[p.Name] != c.alive[p.Name] {
+			changed = true
+
+			if alive[p.Name] {
+				c.Log(logger.Info, "peer '%s' is up", p.Name)
+			} else {
+				c.Log(logger.Warn, "peer '%s' is down", p.Name)
+			}
+		}
+	}
+
+	c.alive = alive
+	listeners := append([]Listener(nil), c.listeners...)
+
+	c.mutex.Unlock()
+
+	if changed {
+		for _, l := range listeners {
+			l.ClusterAssignmentsChanged()
+		}
+	}
+}
+
+func (c *Cluster) checkPeer(p conf.ClusterPeer) bool {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet,
+		strings.TrimSuffix(p.APIURL, "/")+"/healthz", nil)
+	if err != nil {
+		return false
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+
+	return res.StatusCode == http.StatusOK
+}
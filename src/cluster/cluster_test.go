@@ -0,0 +1,99 @@
+package cluster
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/test"
+	"github.com/stretchr/testify/require"
+)
+
+type testListener struct {
+	changed chan struct{}
+}
+
+func (l *testListener) ClusterAssignmentsChanged() {
+	l.changed <- struct{}{}
+}
+
+func TestCluster(t *testing.T) {
+	var peer2Up int32 = 1
+
+	peer1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/healthz", r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer peer1.Close()
+
+	peer2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.LoadInt32(&peer2Up) == 1 {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer peer2.Close()
+
+	c := &Cluster{
+		NodeName: "node0",
+		Peers: conf.ClusterPeers{
+			{Name: "node1", APIURL: peer1.URL},
+			{Name: "node2", APIURL: peer2.URL},
+			{Name: "node3", APIURL: "http://127.0.0.1:1"},
+		},
+		CheckInterval: conf.Duration(100 * time.Millisecond),
+		ReadTimeout:   conf.Duration(1 * time.Second),
+		Parent:        test.NilLogger,
+	}
+
+	l := &testListener{changed: make(chan struct{}, 10)}
+	c.AddListener(l)
+
+	c.Initialize()
+	defer c.Close()
+
+	<-l.changed
+
+	require.Equal(t, []Peer{
+		{Name: "node1", APIURL: peer1.URL},
+		{Name: "node2", APIURL: peer2.URL},
+	}, c.AlivePeers())
+
+	// every path has a single owner, and paths are spread among alive nodes
+	owners := make(map[string]int)
+	node2Paths := []string{}
+
+	for i := 0; i < 300; i++ {
+		pathName := fmt.Sprintf("cam%d", i)
+		owner := c.Owner(pathName)
+		require.NotEqual(t, "node3", owner)
+		require.Equal(t, owner == "node0", c.IsOwner(pathName))
+		owners[owner]++
+
+		if owner == "node2" {
+			node2Paths = append(node2Paths, pathName)
+		}
+	}
+
+	require.Len(t, owners, 3)
+
+	// when a peer goes down, its paths are assigned to the remaining nodes
+	atomic.StoreInt32(&peer2Up, 0)
+
+	<-l.changed
+
+	for _, pathName := range node2Paths {
+		owner := c.Owner(pathName)
+		require.True(t, owner == "node0" || owner == "node1")
+	}
+}
+
+func TestClusterNil(t *testing.T) {
+	var c *Cluster
+	require.True(t, c.IsOwner("mypath"))
+}
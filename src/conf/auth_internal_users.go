@@ -17,6 +17,7 @@ type AuthInternalUser struct {
 	User        Credential                   `json:"user"`
 	Pass        Credential                   `json:"pass"`
 	IPs         IPNetworks                   `json:"ips"`
+	Tenant      string                       `json:"tenant"`
 	Permissions []AuthInternalUserPermission `json:"permissions"`
 }
 
@@ -0,0 +1,47 @@
+package conf
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/flynnletford/mediamtx/src/conf/jsonwrapper"
+)
+
+// ClusterPeer is another node of the cluster.
+type ClusterPeer struct {
+	Name   string `json:"name"`
+	APIURL string `json:"apiURL"`
+}
+
+// ClusterPeers is a list of ClusterPeer.
+type ClusterPeers []ClusterPeer
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *ClusterPeers) UnmarshalJSON(b []byte) error {
+	// remove default value before loading new value
+	// https://github.com/golang/go/issues/21092
+	*s = nil
+	return jsonwrapper.Unmarshal(b, (*[]ClusterPeer)(s))
+}
+
+func (s ClusterPeers) validate(nodeName string) error {
+	names := map[string]struct{}{nodeName: {}}
+
+	for _, p := range s {
+		if p.Name == "" {
+			return fmt.Errorf("empty names of cluster peers are not supported")
+		}
+
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("cluster node name '%s' is used twice", p.Name)
+		}
+		names[p.Name] = struct{}{}
+
+		u, err := url.Parse(p.APIURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid 'apiURL' of cluster peer '%s'", p.Name)
+		}
+	}
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"reflect"
 	"sort"
@@ -159,7 +160,9 @@ var defaultAuthInternalUsers = AuthInternalUsers{
 type Conf struct {
 	// General
 	LogLevel            LogLevel        `json:"logLevel"`
+	LogDebugModules     LogModules      `json:"logDebugModules"`
 	LogDestinations     LogDestinations `json:"logDestinations"`
+	LogFormat           LogFormat       `json:"logFormat"`
 	LogFile             string          `json:"logFile"`
 	SysLogPrefix        string          `json:"sysLogPrefix"`
 	ReadTimeout         Duration        `json:"readTimeout"`
@@ -167,6 +170,7 @@ type Conf struct {
 	ReadBufferCount     *int            `json:"readBufferCount,omitempty"` // deprecated
 	WriteQueueSize      int             `json:"writeQueueSize"`
 	UDPMaxPayloadSize   int             `json:"udpMaxPayloadSize"`
+	ShutdownTimeout     Duration        `json:"shutdownTimeout"`
 	RunOnConnect        string          `json:"runOnConnect"`
 	RunOnConnectRestart bool            `json:"runOnConnectRestart"`
 	RunOnDisconnect     string          `json:"runOnDisconnect"`
@@ -292,6 +296,63 @@ type Conf struct {
 	SRT        bool   `json:"srt"`
 	SRTAddress string `json:"srtAddress"`
 
+	// ONVIF
+	ONVIF                  bool     `json:"onvif"`
+	ONVIFDiscoveryInterval Duration `json:"onvifDiscoveryInterval"`
+	ONVIFUser              string   `json:"onvifUser"`
+	ONVIFPass              string   `json:"onvifPass"`
+	ONVIFPathPrefix        string   `json:"onvifPathPrefix"`
+	ONVIFRecord            bool     `json:"onvifRecord"`
+
+	// Webhook
+	Webhook           bool   `json:"webhook"`
+	WebhookURL        string `json:"webhookURL"`
+	WebhookSecret     string `json:"webhookSecret"`
+	WebhookQueueSize  int    `json:"webhookQueueSize"`
+	WebhookMaxRetries int    `json:"webhookMaxRetries"`
+
+	// MQTT
+	MQTT            bool   `json:"mqtt"`
+	MQTTAddress     string `json:"mqttAddress"`
+	MQTTClientID    string `json:"mqttClientID"`
+	MQTTUser        string `json:"mqttUser"`
+	MQTTPass        string `json:"mqttPass"`
+	MQTTTopicPrefix string `json:"mqttTopicPrefix"`
+	MQTTQoS         int    `json:"mqttQoS"`
+	MQTTRetain      bool   `json:"mqttRetain"`
+
+	// Tracing
+	Tracing            bool            `json:"tracing"`
+	TracingExporter    TracingExporter `json:"tracingExporter"`
+	TracingEndpoint    string          `json:"tracingEndpoint"`
+	TracingServiceName string          `json:"tracingServiceName"`
+	TracingSampleRatio float64         `json:"tracingSampleRatio"`
+	TracingQueueSize   int             `json:"tracingQueueSize"`
+
+	// Upload
+	Upload                  bool       `json:"upload"`
+	UploadPartSize          StringSize `json:"uploadPartSize"`
+	UploadQueueSize         int        `json:"uploadQueueSize"`
+	UploadQueuePath         string     `json:"uploadQueuePath"`
+	UploadMaxRetries        int        `json:"uploadMaxRetries"`
+	UploadDeleteAfterUpload bool       `json:"uploadDeleteAfterUpload"`
+	UploadStreaming         bool       `json:"uploadStreaming"`
+	UploadMaxBacklog        int        `json:"uploadMaxBacklog"`
+
+	// Catalog
+	Catalog          bool   `json:"catalog"`
+	CatalogDatabase  string `json:"catalogDatabase"`
+	CatalogQueueSize int    `json:"catalogQueueSize"`
+
+	// Tenants
+	Tenants Tenants `json:"tenants"`
+
+	// Cluster
+	Cluster              bool         `json:"cluster"`
+	ClusterNodeName      string       `json:"clusterNodeName"`
+	ClusterPeers         ClusterPeers `json:"clusterPeers"`
+	ClusterCheckInterval Duration     `json:"clusterCheckInterval"`
+
 	// Record (deprecated)
 	Record                *bool         `json:"record,omitempty"`                // deprecated
 	RecordPath            *string       `json:"recordPath,omitempty"`            // deprecated
@@ -300,6 +361,14 @@ type Conf struct {
 	RecordSegmentDuration *Duration     `json:"recordSegmentDuration,omitempty"` // deprecated
 	RecordDeleteAfter     *Duration     `json:"recordDeleteAfter,omitempty"`     // deprecated
 
+	// Upload (deprecated)
+	UploadEndpoint        *string `json:"uploadEndpoint,omitempty"`        // deprecated
+	UploadRegion          *string `json:"uploadRegion,omitempty"`          // deprecated
+	UploadBucket          *string `json:"uploadBucket,omitempty"`          // deprecated
+	UploadAccessKeyID     *string `json:"uploadAccessKeyID,omitempty"`     // deprecated
+	UploadSecretAccessKey *string `json:"uploadSecretAccessKey,omitempty"` // deprecated
+	UploadPrefix          *string `json:"uploadPrefix,omitempty"`          // deprecated
+
 	// Path defaults
 	PathDefaults Path `json:"pathDefaults"`
 
@@ -311,13 +380,16 @@ type Conf struct {
 func (conf *Conf) setDefaults() {
 	// General
 	conf.LogLevel = LogLevel(logger.Info)
+	conf.LogDebugModules = LogModules{}
 	conf.LogDestinations = LogDestinations{logger.DestinationStdout}
+	conf.LogFormat = LogFormat(logger.FormatConsole)
 	conf.LogFile = "mediamtx.log"
 	conf.SysLogPrefix = "mediamtx"
 	conf.ReadTimeout = 10 * Duration(time.Second)
 	conf.WriteTimeout = 10 * Duration(time.Second)
 	conf.WriteQueueSize = 512
 	conf.UDPMaxPayloadSize = 1472
+	conf.ShutdownTimeout = 30 * Duration(time.Second)
 
 	// Authentication
 	conf.AuthInternalUsers = defaultAuthInternalUsers
@@ -416,6 +488,42 @@ func (conf *Conf) setDefaults() {
 	conf.SRT = true
 	conf.SRTAddress = ":8890"
 
+	// ONVIF
+	conf.ONVIFDiscoveryInterval = 60 * Duration(time.Second)
+	conf.ONVIFPathPrefix = "onvif_"
+
+	// Webhook
+	conf.WebhookQueueSize = 1024
+	conf.WebhookMaxRetries = 5
+
+	// MQTT
+	conf.MQTTAddress = "localhost:1883"
+	conf.MQTTClientID = "mediamtx"
+	conf.MQTTTopicPrefix = "mediamtx"
+
+	// Tracing
+	conf.TracingEndpoint = "http://localhost:4318/v1/traces"
+	conf.TracingServiceName = "mediamtx"
+	conf.TracingSampleRatio = 0.01
+	conf.TracingQueueSize = 2048
+
+	// Upload
+	conf.UploadPartSize = 16 * 1024 * 1024
+	conf.UploadQueueSize = 1024
+	conf.UploadQueuePath = "./upload_queue.json"
+	conf.UploadMaxRetries = 5
+
+	// Catalog
+	conf.CatalogDatabase = "postgres://mediamtx@localhost:5432/mediamtx"
+	conf.CatalogQueueSize = 1024
+
+	// Tenants
+	conf.Tenants = Tenants{}
+
+	// Cluster
+	conf.ClusterPeers = ClusterPeers{}
+	conf.ClusterCheckInterval = 5 * Duration(time.Second)
+
 	conf.PathDefaults.setDefaults()
 }
 
@@ -477,7 +585,7 @@ func (conf *Conf) loadFromFile(fpath string, defaultConfPaths []string) (string,
 		}
 	}
 
-	err = yamlwrapper.Unmarshal(byts, conf)
+	err = yamlwrapper.UnmarshalTransform(byts, conf, interpolate)
 	if err != nil {
 		return "", err
 	}
@@ -514,6 +622,11 @@ func (conf *Conf) Validate(l logger.Writer) error {
 
 	// General
 
+	for _, m := range conf.LogDebugModules {
+		if m == "" {
+			return fmt.Errorf("empty modules in 'logDebugModules' are not supported")
+		}
+	}
 	if conf.ReadTimeout <= 0 {
 		return fmt.Errorf("'readTimeout' must be greater than zero")
 	}
@@ -530,6 +643,9 @@ func (conf *Conf) Validate(l logger.Writer) error {
 	if conf.UDPMaxPayloadSize > 1472 {
 		return fmt.Errorf("'udpMaxPayloadSize' must be less than 1472")
 	}
+	if conf.ShutdownTimeout <= 0 {
+		return fmt.Errorf("'shutdownTimeout' must be greater than zero")
+	}
 
 	// Authentication
 
@@ -723,6 +839,136 @@ func (conf *Conf) Validate(l logger.Writer) error {
 		}
 	}
 
+	// ONVIF
+
+	if conf.ONVIF {
+		if conf.ONVIFDiscoveryInterval <= 0 {
+			return fmt.Errorf("'onvifDiscoveryInterval' must be greater than zero")
+		}
+		if err := IsValidPathName(conf.ONVIFPathPrefix + "0.0.0.0"); err != nil {
+			return fmt.Errorf("invalid 'onvifPathPrefix': %w", err)
+		}
+	}
+
+	// Webhook
+
+	if conf.Webhook {
+		u, err := url.Parse(conf.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("'webhookURL' must be a valid HTTP or HTTPS URL")
+		}
+		if conf.WebhookQueueSize <= 0 {
+			return fmt.Errorf("'webhookQueueSize' must be greater than zero")
+		}
+		if conf.WebhookMaxRetries < 0 {
+			return fmt.Errorf("'webhookMaxRetries' must be greater than or equal to zero")
+		}
+	}
+
+	// MQTT
+
+	if conf.MQTT {
+		if _, _, err := net.SplitHostPort(conf.MQTTAddress); err != nil {
+			return fmt.Errorf("invalid 'mqttAddress': %w", err)
+		}
+		if conf.MQTTClientID == "" {
+			return fmt.Errorf("'mqttClientID' must not be empty")
+		}
+		if conf.MQTTTopicPrefix == "" || strings.ContainsAny(conf.MQTTTopicPrefix, "#+") {
+			return fmt.Errorf("invalid 'mqttTopicPrefix'")
+		}
+		if conf.MQTTQoS != 0 && conf.MQTTQoS != 1 {
+			return fmt.Errorf("'mqttQoS' must be 0 or 1")
+		}
+	}
+
+	// Tracing
+
+	if conf.Tracing {
+		if conf.TracingExporter == TracingExporterOTLP {
+			u, err := url.Parse(conf.TracingEndpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("'tracingEndpoint' must be a valid HTTP or HTTPS URL")
+			}
+		}
+		if conf.TracingServiceName == "" {
+			return fmt.Errorf("'tracingServiceName' must not be empty")
+		}
+		if conf.TracingSampleRatio < 0 || conf.TracingSampleRatio > 1 {
+			return fmt.Errorf("'tracingSampleRatio' must be between 0 and 1")
+		}
+		if conf.TracingQueueSize <= 0 {
+			return fmt.Errorf("'tracingQueueSize' must be greater than zero")
+		}
+	}
+
+	// Upload
+
+	if conf.Upload {
+		if conf.UploadPartSize < 5*1024*1024 {
+			return fmt.Errorf("'uploadPartSize' must be greater than or equal to 5MiB")
+		}
+		if conf.UploadQueueSize <= 0 {
+			return fmt.Errorf("'uploadQueueSize' must be greater than zero")
+		}
+		if conf.UploadMaxRetries < 0 {
+			return fmt.Errorf("'uploadMaxRetries' must be greater than or equal to zero")
+		}
+		if conf.UploadMaxBacklog < 0 {
+			return fmt.Errorf("'uploadMaxBacklog' must be greater than or equal to zero")
+		}
+	}
+
+	// Catalog
+
+	if conf.Catalog {
+		if conf.CatalogDatabase == "" {
+			return fmt.Errorf("'catalogDatabase' must not be empty")
+		}
+		if conf.CatalogQueueSize <= 0 {
+			return fmt.Errorf("'catalogQueueSize' must be greater than zero")
+		}
+	}
+
+	// Tenants
+
+	for i, t := range conf.Tenants {
+		err := t.validate()
+		if err != nil {
+			return err
+		}
+
+		for _, t2 := range conf.Tenants[:i] {
+			if t2.Name == t.Name {
+				return fmt.Errorf("tenant '%s' is defined twice", t.Name)
+			}
+		}
+	}
+
+	for _, user := range conf.AuthInternalUsers {
+		if user.Tenant != "" && conf.Tenants.Find(user.Tenant) == nil {
+			return fmt.Errorf("user '%s' refers to tenant '%s', that does not exist", user.User, user.Tenant)
+		}
+	}
+
+	// Cluster
+
+	if conf.Cluster {
+		if !conf.API {
+			return fmt.Errorf("the cluster requires 'api' to be enabled, since peers use it to communicate")
+		}
+		if conf.ClusterNodeName == "" {
+			return fmt.Errorf("'clusterNodeName' must not be empty")
+		}
+		err := conf.ClusterPeers.validate(conf.ClusterNodeName)
+		if err != nil {
+			return err
+		}
+		if conf.ClusterCheckInterval <= 0 {
+			return fmt.Errorf("'clusterCheckInterval' must be greater than zero")
+		}
+	}
+
 	// Record (deprecated)
 
 	if conf.Record != nil {
@@ -756,6 +1002,39 @@ func (conf *Conf) Validate(l logger.Writer) error {
 		conf.PathDefaults.RecordDeleteAfter = *conf.RecordDeleteAfter
 	}
 
+	// Upload (deprecated)
+
+	if conf.UploadEndpoint != nil {
+		l.Log(logger.Warn, "parameter 'uploadEndpoint' is deprecated "+
+			"and has been replaced with 'pathDefaults.uploadEndpoint'")
+		conf.PathDefaults.UploadEndpoint = *conf.UploadEndpoint
+	}
+	if conf.UploadRegion != nil {
+		l.Log(logger.Warn, "parameter 'uploadRegion' is deprecated "+
+			"and has been replaced with 'pathDefaults.uploadRegion'")
+		conf.PathDefaults.UploadRegion = *conf.UploadRegion
+	}
+	if conf.UploadBucket != nil {
+		l.Log(logger.Warn, "parameter 'uploadBucket' is deprecated "+
+			"and has been replaced with 'pathDefaults.uploadBucket'")
+		conf.PathDefaults.UploadBucket = *conf.UploadBucket
+	}
+	if conf.UploadAccessKeyID != nil {
+		l.Log(logger.Warn, "parameter 'uploadAccessKeyID' is deprecated "+
+			"and has been replaced with 'pathDefaults.uploadAccessKeyID'")
+		conf.PathDefaults.UploadAccessKeyID = *conf.UploadAccessKeyID
+	}
+	if conf.UploadSecretAccessKey != nil {
+		l.Log(logger.Warn, "parameter 'uploadSecretAccessKey' is deprecated "+
+			"and has been replaced with 'pathDefaults.uploadSecretAccessKey'")
+		conf.PathDefaults.UploadSecretAccessKey = *conf.UploadSecretAccessKey
+	}
+	if conf.UploadPrefix != nil {
+		l.Log(logger.Warn, "parameter 'uploadPrefix' is deprecated "+
+			"and has been replaced with 'pathDefaults.uploadPrefix'")
+		conf.PathDefaults.UploadPrefix = *conf.UploadPrefix
+	}
+
 	hasAllOthers := false
 	for name := range conf.OptionalPaths {
 		if name == "all" || name == "all_others" || name == "~^.*$" {
@@ -788,6 +1067,19 @@ func (conf *Conf) Validate(l logger.Writer) error {
 		}
 	}
 
+	for _, t := range conf.Tenants {
+		found := false
+		for _, pconf := range conf.Paths {
+			if pconf.Tenant != nil && pconf.Tenant.Name == t.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("tenant '%s' has no paths, add at least a path named '%s/...'", t.Name, t.Name)
+		}
+	}
+
 	return nil
 }
 
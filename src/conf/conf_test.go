@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -52,11 +53,18 @@ func TestConfFromFile(t *testing.T) {
 			Source:                     "publisher",
 			SourceOnDemandStartTimeout: 10 * Duration(time.Second),
 			SourceOnDemandCloseAfter:   10 * Duration(time.Second),
+			SourceRetryPause:           5 * Duration(time.Second),
+			SourceRetryMaxPause:        5 * Duration(time.Second),
+			RTPJitterBufferSize:        64,
+			RTCPFeedbackPeriod:         Duration(1 * time.Second),
 			RecordPath:                 "./recordings/%path/%Y-%m-%d_%H-%M-%S-%f",
 			RecordFormat:               RecordFormatFMP4,
 			RecordPartDuration:         Duration(1 * time.Second),
 			RecordSegmentDuration:      3600000000000,
+			RecordRestartPause:         Duration(2 * time.Second),
 			RecordDeleteAfter:          86400000000000,
+			RecordMaxWriteLatency:      Duration(10 * time.Second),
+			UploadRegion:               "us-east-1",
 			OverridePublisher:          true,
 			RPICameraWidth:             1920,
 			RPICameraHeight:            1080,
@@ -328,6 +336,81 @@ func TestConfErrors(t *testing.T) {
 				"    source: publisher\n",
 			"invalid path name '': cannot be empty",
 		},
+		{
+			"invalid rtp jitter buffer size",
+			"paths:\n" +
+				"  cam1:\n" +
+				"    rtpJitterBufferSize: 100\n",
+			"'rtpJitterBufferSize' must be zero or a power of two",
+		},
+		{
+			"invalid record part duration",
+			"paths:\n" +
+				"  cam1:\n" +
+				"    recordPartDuration: 0s\n",
+			"'recordPartDuration' must be greater than zero",
+		},
+		{
+			"invalid record segment duration",
+			"paths:\n" +
+				"  cam1:\n" +
+				"    recordPartDuration: 10s\n" +
+				"    recordSegmentDuration: 5s\n",
+			"'recordSegmentDuration' must be greater than or equal to 'recordPartDuration'",
+		},
+		{
+			"invalid record restart pause",
+			"paths:\n" +
+				"  cam1:\n" +
+				"    recordRestartPause: 0s\n",
+			"'recordRestartPause' must be greater than zero",
+		},
+		{
+			"cluster without api",
+			"cluster: yes\n" +
+				"clusterNodeName: node1\n",
+			"the cluster requires 'api' to be enabled, since peers use it to communicate",
+		},
+		{
+			"duplicate cluster node name",
+			"api: yes\n" +
+				"cluster: yes\n" +
+				"clusterNodeName: node1\n" +
+				"clusterPeers:\n" +
+				"- name: node1\n" +
+				"  apiURL: http://node1:9997\n",
+			"cluster node name 'node1' is used twice",
+		},
+		{
+			"tenant without paths",
+			"tenants:\n" +
+				"- name: acme\n",
+			"tenant 'acme' has no paths, add at least a path named 'acme/...'",
+		},
+		{
+			"user of non existent tenant",
+			"authInternalUsers:\n" +
+				"- user: myuser\n" +
+				"  tenant: acme\n",
+			"user 'myuser' refers to tenant 'acme', that does not exist",
+		},
+		{
+			"record path outside tenant storage root",
+			"tenants:\n" +
+				"- name: acme\n" +
+				"  storageRoot: /storage/acme\n" +
+				"paths:\n" +
+				"  acme/*:\n" +
+				"    recordPath: /storage/other/%path/%Y-%m-%d_%H-%M-%S-%f\n",
+			"'recordPath' must be inside the storage root of tenant 'acme'",
+		},
+		{
+			"invalid wildcard",
+			"paths:\n" +
+				"  'cameras/***':\n" +
+				"    source: publisher\n",
+			"invalid path name 'cameras/***': wildcards can be '*' or '**'",
+		},
 		{
 			"double raspberry pi camera",
 			"paths:\n" +
@@ -447,6 +530,64 @@ func TestConfErrors(t *testing.T) {
 				"    recordDeleteAfter: 20m\n",
 			`'recordDeleteAfter' cannot be lower than 'recordSegmentDuration'`,
 		},
+		{
+			"missing record overflow path",
+			"paths:\n" +
+				"  my_path:\n" +
+				"    recordEmergencyPolicy: overflow\n",
+			`'recordOverflowPath' is required when 'recordEmergencyPolicy' is 'overflow'`,
+		},
+		{
+			"invalid record emergency policy",
+			"paths:\n" +
+				"  my_path:\n" +
+				"    recordEmergencyPolicy: panic\n",
+			`invalid record emergency policy: 'panic'`,
+		},
+		{
+			"invalid source retry max pause",
+			"paths:\n" +
+				"  my_path:\n" +
+				"    source: rtsp://localhost:8554/mypath\n" +
+				"    sourceRetryPause: 10s\n" +
+				"    sourceRetryMaxPause: 5s\n",
+			`'sourceRetryMaxPause' must be greater than or equal to 'sourceRetryPause'`,
+		},
+		{
+			"invalid webhook url",
+			"webhook: yes\n" +
+				"webhookURL: ftp://localhost/events\n",
+			"'webhookURL' must be a valid HTTP or HTTPS URL",
+		},
+		{
+			"invalid mqtt qos",
+			"mqtt: yes\n" +
+				"mqttQoS: 2\n",
+			"'mqttQoS' must be 0 or 1",
+		},
+		{
+			"invalid log format",
+			"logFormat: xml\n",
+			"invalid log format: 'xml'",
+		},
+		{
+			"invalid tracing endpoint",
+			"tracing: yes\n" +
+				"tracingEndpoint: localhost:4318\n",
+			"'tracingEndpoint' must be a valid HTTP or HTTPS URL",
+		},
+		{
+			"invalid tracing sample ratio",
+			"tracing: yes\n" +
+				"tracingSampleRatio: 1.5\n",
+			"'tracingSampleRatio' must be between 0 and 1",
+		},
+		{
+			"invalid tracing exporter",
+			"tracing: yes\n" +
+				"tracingExporter: zipkin\n",
+			"invalid tracing exporter: 'zipkin'",
+		},
 	} {
 		t.Run(ca.name, func(t *testing.T) {
 			tmpf, err := createTempFile([]byte(ca.conf))
@@ -491,6 +632,75 @@ func TestSampleConfFile(t *testing.T) {
 	}()
 }
 
+func TestFindPathConfWildcard(t *testing.T) {
+	tmpf, err := createTempFile([]byte(
+		"paths:\n" +
+			"  cameras/*:\n" +
+			"    record: yes\n" +
+			"    recordPath: /storage/cameras/%path/%Y-%m-%d_%H-%M-%S-%f\n" +
+			"  sites/**/live:\n" +
+			"  all_others:\n"))
+	require.NoError(t, err)
+	defer os.Remove(tmpf)
+
+	conf, _, err := Load(tmpf, nil, nil)
+	require.NoError(t, err)
+
+	pathConf, m, err := FindPathConf(conf.Paths, "cameras/cam1")
+	require.NoError(t, err)
+	require.Equal(t, "cameras/*", pathConf.Name)
+	require.Equal(t, []string{"cameras/cam1", "cam1"}, m)
+	require.Equal(t, true, pathConf.Record)
+	require.Equal(t, "/storage/cameras/%path/%Y-%m-%d_%H-%M-%S-%f", pathConf.RecordPath)
+
+	pathConf, _, err = FindPathConf(conf.Paths, "cameras/site1/cam1")
+	require.NoError(t, err)
+	require.Equal(t, "all_others", pathConf.Name)
+
+	pathConf, m, err = FindPathConf(conf.Paths, "sites/a/b/live")
+	require.NoError(t, err)
+	require.Equal(t, "sites/**/live", pathConf.Name)
+	require.Equal(t, []string{"sites/a/b/live", "a/b"}, m)
+}
+
+func TestConfTenants(t *testing.T) {
+	tmpf, err := createTempFile([]byte(
+		"tenants:\n" +
+			"- name: acme\n" +
+			"  storageRoot: /storage/acme\n" +
+			"  maxDiskBytes: 10G\n" +
+			"paths:\n" +
+			"  acme/*:\n" +
+			"    record: yes\n" +
+			"  all_others:\n"))
+	require.NoError(t, err)
+	defer os.Remove(tmpf)
+
+	conf, _, err := Load(tmpf, nil, nil)
+	require.NoError(t, err)
+
+	pathConf, _, err := FindPathConf(conf.Paths, "acme/cam1")
+	require.NoError(t, err)
+	require.Equal(t, "acme/*", pathConf.Name)
+	require.Equal(t, "acme", pathConf.Tenant.Name)
+	require.Equal(t, filepath.Join("/storage/acme", "recordings/%path/%Y-%m-%d_%H-%M-%S-%f"), pathConf.RecordPath)
+
+	// paths of a tenant are not matched by entries outside the tenant
+	_, _, err = FindPathConf(conf.Paths, "acme/site1/cam1")
+	require.EqualError(t, err, "path 'acme/site1/cam1' is not configured")
+
+	pathConf, _, err = FindPathConf(conf.Paths, "other/cam1")
+	require.NoError(t, err)
+	require.Equal(t, "all_others", pathConf.Name)
+	require.Nil(t, pathConf.Tenant)
+
+	// validation is idempotent
+	conf2 := conf.Clone()
+	err = conf2.Validate(nil)
+	require.NoError(t, err)
+	require.Equal(t, conf.Paths, conf2.Paths)
+}
+
 // needed due to https://github.com/golang/go/issues/21092
 func TestConfOverrideDefaultSlices(t *testing.T) {
 	tmpf, err := createTempFile([]byte(
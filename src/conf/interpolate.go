@@ -0,0 +1,113 @@
+package conf
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const secretFilePrefix = "file://"
+
+// interpolateValue replaces ${VAR} with the value of environment variable VAR,
+// and a value in the format file://path with the content of the file.
+// $${ can be used to insert a literal ${.
+func interpolateValue(v string) (string, error) {
+	var b strings.Builder
+
+	for {
+		i := strings.Index(v, "${")
+		if i < 0 {
+			b.WriteString(v)
+			break
+		}
+
+		if i > 0 && v[i-1] == '$' {
+			b.WriteString(v[:i-1])
+			b.WriteString("${")
+			v = v[i+2:]
+			continue
+		}
+
+		end := strings.IndexByte(v[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable reference")
+		}
+
+		name := v[i+2 : i+end]
+		if name == "" {
+			return "", fmt.Errorf("empty variable reference")
+		}
+
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable '%s' is not set", name)
+		}
+
+		b.WriteString(v[:i])
+		b.WriteString(val)
+		v = v[i+end+1:]
+	}
+
+	out := b.String()
+
+	if strings.HasPrefix(out, secretFilePrefix) {
+		byts, err := os.ReadFile(out[len(secretFilePrefix):])
+		if err != nil {
+			return "", fmt.Errorf("unable to read secret file: %w", err)
+		}
+
+		out = strings.TrimRight(string(byts), "\r\n")
+	}
+
+	return out, nil
+}
+
+func interpolateWithKey(i interface{}, key string) (interface{}, error) {
+	switch x := i.(type) {
+	case map[string]interface{}:
+		for k, v := range x {
+			// commands are expanded when they are launched,
+			// with additional variables that are not available here.
+			if strings.HasPrefix(k, "runOn") {
+				continue
+			}
+
+			subKey := k
+			if key != "" {
+				subKey = key + "." + k
+			}
+
+			var err error
+			x[k], err = interpolateWithKey(v, subKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return x, nil
+
+	case []interface{}:
+		for j, v := range x {
+			var err error
+			x[j], err = interpolateWithKey(v, key+"["+strconv.Itoa(j)+"]")
+			if err != nil {
+				return nil, err
+			}
+		}
+		return x, nil
+
+	case string:
+		v, err := interpolateValue(x)
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %w", key, err)
+		}
+		return v, nil
+	}
+
+	return i, nil
+}
+
+// interpolate replaces environment variables and secret files in every value of the configuration.
+func interpolate(i interface{}) (interface{}, error) {
+	return interpolateWithKey(i, "")
+}
@@ -0,0 +1,119 @@
+package conf
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterpolateValue(t *testing.T) {
+	t.Setenv("MTX_TEST_USER", "myuser")
+
+	tmpf, err := createTempFile([]byte("mysecret\n"))
+	require.NoError(t, err)
+	defer os.Remove(tmpf)
+
+	for _, ca := range []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			"no variables",
+			"rtsp://localhost:8554/mypath",
+			"rtsp://localhost:8554/mypath",
+		},
+		{
+			"variable",
+			"rtsp://${MTX_TEST_USER}@localhost:8554/mypath",
+			"rtsp://myuser@localhost:8554/mypath",
+		},
+		{
+			"escape",
+			"$${MTX_TEST_USER}",
+			"${MTX_TEST_USER}",
+		},
+		{
+			"plain dollar",
+			"$MTX_TEST_USER",
+			"$MTX_TEST_USER",
+		},
+		{
+			"secret file",
+			"file://" + tmpf,
+			"mysecret",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			out, err := interpolateValue(ca.in)
+			require.NoError(t, err)
+			require.Equal(t, ca.out, out)
+		})
+	}
+}
+
+func TestInterpolateErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		conf string
+		err  string
+	}{
+		{
+			"missing variable",
+			"paths:\n" +
+				"  cam1:\n" +
+				"    source: rtsp://${MTX_TEST_MISSING}@localhost\n",
+			"'paths.cam1.source': environment variable 'MTX_TEST_MISSING' is not set",
+		},
+		{
+			"unterminated variable",
+			"authInternalUsers:\n" +
+				"- user: ${MTX_TEST_USER\n",
+			"'authInternalUsers[0].user': unterminated variable reference",
+		},
+		{
+			"missing secret file",
+			"pathDefaults:\n" +
+				"  recordEncryptionKey: file:///nonexisting/secret\n",
+			"'pathDefaults.recordEncryptionKey': unable to read secret file: " +
+				"open /nonexisting/secret: no such file or directory",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			tmpf, err := createTempFile([]byte(ca.conf))
+			require.NoError(t, err)
+			defer os.Remove(tmpf)
+
+			_, _, err = Load(tmpf, nil, nil)
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestConfInterpolation(t *testing.T) {
+	t.Setenv("MTX_TEST_USER", "myuser")
+
+	secretf, err := createTempFile([]byte("mypass\n"))
+	require.NoError(t, err)
+	defer os.Remove(secretf)
+
+	tmpf, err := createTempFile([]byte(
+		"authInternalUsers:\n" +
+			"- user: ${MTX_TEST_USER}\n" +
+			"  pass: file://" + secretf + "\n" +
+			"  permissions:\n" +
+			"  - action: publish\n" +
+			"paths:\n" +
+			"  cam1:\n" +
+			"    runOnReady: ffmpeg -i rtsp://localhost:${RTSP_PORT}/${MTX_PATH}\n"))
+	require.NoError(t, err)
+	defer os.Remove(tmpf)
+
+	conf, _, err := Load(tmpf, nil, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, Credential("myuser"), conf.AuthInternalUsers[0].User)
+	require.Equal(t, Credential("mypass"), conf.AuthInternalUsers[0].Pass)
+	require.Equal(t, "ffmpeg -i rtsp://localhost:${RTSP_PORT}/${MTX_PATH}", conf.Paths["cam1"].RunOnReady)
+}
@@ -0,0 +1,53 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/flynnletford/mediamtx/src/conf/jsonwrapper"
+	"github.com/flynnletford/mediamtx/src/logger"
+)
+
+// LogFormat is the logFormat parameter.
+type LogFormat logger.Format
+
+// MarshalJSON implements json.Marshaler.
+func (d LogFormat) MarshalJSON() ([]byte, error) {
+	var out string
+
+	switch d {
+	case LogFormat(logger.FormatJSON):
+		out = "json"
+
+	default:
+		out = "console"
+	}
+
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *LogFormat) UnmarshalJSON(b []byte) error {
+	var in string
+	if err := jsonwrapper.Unmarshal(b, &in); err != nil {
+		return err
+	}
+
+	switch in {
+	case "console":
+		*d = LogFormat(logger.FormatConsole)
+
+	case "json":
+		*d = LogFormat(logger.FormatJSON)
+
+	default:
+		return fmt.Errorf("invalid log format: '%s'", in)
+	}
+
+	return nil
+}
+
+// UnmarshalEnv implements env.Unmarshaler.
+func (d *LogFormat) UnmarshalEnv(_ string, v string) error {
+	return d.UnmarshalJSON([]byte(`"` + v + `"`))
+}
@@ -0,0 +1,16 @@
+package conf
+
+import (
+	"github.com/flynnletford/mediamtx/src/conf/jsonwrapper"
+)
+
+// LogModules is the logDebugModules parameter.
+type LogModules []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *LogModules) UnmarshalJSON(b []byte) error {
+	// remove default value before loading new value
+	// https://github.com/golang/go/issues/21092
+	*d = nil
+	return jsonwrapper.Unmarshal(b, (*[]string)(d))
+}
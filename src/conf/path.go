@@ -1,12 +1,15 @@
 package conf
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net"
 	gourl "net/url"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -37,6 +40,47 @@ func IsValidPathName(name string) error {
 	return nil
 }
 
+// wildcardToRegexp converts a path name containing wildcards into a regular expression.
+// "*" matches a single path segment, "**" matches any number of segments.
+// Each wildcard becomes a regular expression group.
+func wildcardToRegexp(name string) (*regexp.Regexp, error) {
+	err := IsValidPathName(strings.ReplaceAll(name, "*", "a"))
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+
+	for {
+		i := strings.IndexByte(name, '*')
+		if i < 0 {
+			b.WriteString(regexp.QuoteMeta(name))
+			break
+		}
+
+		b.WriteString(regexp.QuoteMeta(name[:i]))
+		name = name[i:]
+
+		switch {
+		case strings.HasPrefix(name, "***"):
+			return nil, fmt.Errorf("wildcards can be '*' or '**'")
+
+		case strings.HasPrefix(name, "**"):
+			b.WriteString("(.+)")
+			name = name[2:]
+
+		default:
+			b.WriteString("([^/]+)")
+			name = name[1:]
+		}
+	}
+
+	b.WriteString("$")
+
+	return regexp.MustCompile(b.String()), nil
+}
+
 func checkSRTPassphrase(passphrase string) error {
 	switch {
 	case len(passphrase) < 10 || len(passphrase) > 79:
@@ -63,6 +107,22 @@ func checkRedirect(v string) error {
 	return nil
 }
 
+func tenantName(t *Tenant) string {
+	if t == nil {
+		return ""
+	}
+	return t.Name
+}
+
+func findPathTenant(pathConfs map[string]*Path, name string) string {
+	for _, pathConf := range pathConfs {
+		if pathConf.Tenant != nil && pathConf.Tenant.Owns(name) {
+			return pathConf.Tenant.Name
+		}
+	}
+	return ""
+}
+
 // FindPathConf returns the configuration corresponding to the given path name.
 func FindPathConf(pathConfs map[string]*Path, name string) (*Path, []string, error) {
 	// normal path
@@ -70,9 +130,13 @@ func FindPathConf(pathConfs map[string]*Path, name string) (*Path, []string, err
 		return pathConf, nil, nil
 	}
 
+	// paths of a tenant can only be matched by configurations of the same tenant
+	tenant := findPathTenant(pathConfs, name)
+
 	// regular expression-based path
 	for pathConfName, pathConf := range pathConfs {
-		if pathConf.Regexp != nil && pathConfName != "all" && pathConfName != "all_others" {
+		if pathConf.Regexp != nil && pathConfName != "all" && pathConfName != "all_others" &&
+			tenantName(pathConf.Tenant) == tenant {
 			err := IsValidPathName(name)
 			if err != nil {
 				return nil, nil, fmt.Errorf("invalid path name: %w (%s)", err, name)
@@ -87,7 +151,7 @@ func FindPathConf(pathConfs map[string]*Path, name string) (*Path, []string, err
 
 	// process all_others after every other entry
 	for pathConfName, pathConf := range pathConfs {
-		if pathConfName == "all" || pathConfName == "all_others" {
+		if (pathConfName == "all" || pathConfName == "all_others") && tenant == "" {
 			err := IsValidPathName(name)
 			if err != nil {
 				return nil, nil, fmt.Errorf("invalid path name: %w (%s)", err, name)
@@ -108,6 +172,7 @@ func FindPathConf(pathConfs map[string]*Path, name string) (*Path, []string, err
 type Path struct {
 	Regexp *regexp.Regexp `json:"-"`    // filled by Check()
 	Name   string         `json:"name"` // filled by Check()
+	Tenant *Tenant        `json:"-"`    // filled by Check()
 
 	// General
 	Source                     string   `json:"source"`
@@ -115,19 +180,54 @@ type Path struct {
 	SourceOnDemand             bool     `json:"sourceOnDemand"`
 	SourceOnDemandStartTimeout Duration `json:"sourceOnDemandStartTimeout"`
 	SourceOnDemandCloseAfter   Duration `json:"sourceOnDemandCloseAfter"`
+	SourceRetryPause           Duration `json:"sourceRetryPause"`
+	SourceRetryMaxPause        Duration `json:"sourceRetryMaxPause"`
 	MaxReaders                 int      `json:"maxReaders"`
 	SRTReadPassphrase          string   `json:"srtReadPassphrase"`
 	Fallback                   string   `json:"fallback"`
 	UseAbsoluteTimestamp       bool     `json:"useAbsoluteTimestamp"`
+	InjectParameterSets        bool     `json:"injectParameterSets"`
+	DropMalformed              bool     `json:"dropMalformed"`
+	CacheGOP                   bool     `json:"cacheGOP"`
+	DecodeToLPCM               bool     `json:"decodeToLPCM"`
+	RTPJitterBufferSize        int      `json:"rtpJitterBufferSize"`
+	RTCPFeedback               bool     `json:"rtcpFeedback"`
+	RTCPFeedbackPeriod         Duration `json:"rtcpFeedbackPeriod"`
 
 	// Record
-	Record                bool         `json:"record"`
-	Playback              *bool        `json:"playback,omitempty"` // deprecated
-	RecordPath            string       `json:"recordPath"`
-	RecordFormat          RecordFormat `json:"recordFormat"`
-	RecordPartDuration    Duration     `json:"recordPartDuration"`
-	RecordSegmentDuration Duration     `json:"recordSegmentDuration"`
-	RecordDeleteAfter     Duration     `json:"recordDeleteAfter"`
+	Record                bool                  `json:"record"`
+	Playback              *bool                 `json:"playback,omitempty"` // deprecated
+	RecordPath            string                `json:"recordPath"`
+	RecordFormat          RecordFormat          `json:"recordFormat"`
+	RecordPartDuration    Duration              `json:"recordPartDuration"`
+	RecordSegmentDuration Duration              `json:"recordSegmentDuration"`
+	RecordSegmentIndex    bool                  `json:"recordSegmentIndex"`
+	RecordChecksum        bool                  `json:"recordChecksum"`
+	RecordRestartPause    Duration              `json:"recordRestartPause"`
+	RecordDeleteAfter     Duration              `json:"recordDeleteAfter"`
+	RecordEncryption      RecordEncryption      `json:"recordEncryption"`
+	RecordEncryptionKeyID string                `json:"recordEncryptionKeyID"`
+	RecordEncryptionKey   string                `json:"recordEncryptionKey"`
+	RecordMaxWriteLatency Duration              `json:"recordMaxWriteLatency"`
+	RecordEmergencyPolicy RecordEmergencyPolicy `json:"recordEmergencyPolicy"`
+	RecordOverflowPath    string                `json:"recordOverflowPath"`
+
+	// Upload
+	UploadStorage          UploadStorage `json:"uploadStorage"`
+	UploadEndpoint         string        `json:"uploadEndpoint"`
+	UploadBucket           string        `json:"uploadBucket"`
+	UploadPrefix           string        `json:"uploadPrefix"`
+	UploadRegion           string        `json:"uploadRegion"`
+	UploadAccessKeyID      string        `json:"uploadAccessKeyID"`
+	UploadSecretAccessKey  string        `json:"uploadSecretAccessKey"`
+	UploadGCSCredentials   string        `json:"uploadGCSCredentials"`
+	UploadAzureAccountName string        `json:"uploadAzureAccountName"`
+	UploadAzureAccountKey  string        `json:"uploadAzureAccountKey"`
+	UploadUser             string        `json:"uploadUser"`
+	UploadPass             string        `json:"uploadPass"`
+	UploadSFTPKey          string        `json:"uploadSFTPKey"`
+	UploadSFTPFingerprint  string        `json:"uploadSFTPFingerprint"`
+	UploadLocalRetention   Duration      `json:"uploadLocalRetention"`
 
 	// Authentication (deprecated)
 	PublishUser *Credential `json:"publishUser,omitempty"` // deprecated
@@ -220,13 +320,22 @@ func (pconf *Path) setDefaults() {
 	pconf.Source = "publisher"
 	pconf.SourceOnDemandStartTimeout = 10 * Duration(time.Second)
 	pconf.SourceOnDemandCloseAfter = 10 * Duration(time.Second)
+	pconf.SourceRetryPause = 5 * Duration(time.Second)
+	pconf.SourceRetryMaxPause = 5 * Duration(time.Second)
+	pconf.RTPJitterBufferSize = 64
+	pconf.RTCPFeedbackPeriod = 1 * Duration(time.Second)
 
 	// Record
 	pconf.RecordPath = "./recordings/%path/%Y-%m-%d_%H-%M-%S-%f"
 	pconf.RecordFormat = RecordFormatFMP4
 	pconf.RecordPartDuration = Duration(1 * time.Second)
 	pconf.RecordSegmentDuration = 3600 * Duration(time.Second)
+	pconf.RecordRestartPause = 2 * Duration(time.Second)
 	pconf.RecordDeleteAfter = 24 * 3600 * Duration(time.Second)
+	pconf.RecordMaxWriteLatency = 10 * Duration(time.Second)
+
+	// Upload
+	pconf.UploadRegion = "us-east-1"
 
 	// Publisher source
 	pconf.OverridePublisher = true
@@ -280,6 +389,7 @@ func (pconf Path) Clone() *Path {
 	}
 
 	dest.Regexp = pconf.Regexp
+	dest.Tenant = pconf.Tenant
 	dest.RPICameraPrimaryName = pconf.RPICameraPrimaryName
 	dest.RPICameraSecondaryWidth = pconf.RPICameraSecondaryWidth
 	dest.RPICameraSecondaryHeight = pconf.RPICameraSecondaryHeight
@@ -301,12 +411,19 @@ func (pconf *Path) validate(
 	case name == "all_others", name == "all":
 		pconf.Regexp = regexp.MustCompile("^.*$")
 
-	case name == "" || name[0] != '~': // normal path
+	case name == "" || (name[0] != '~' && !strings.Contains(name, "*")): // normal path
 		err := IsValidPathName(name)
 		if err != nil {
 			return fmt.Errorf("invalid path name '%s': %w", name, err)
 		}
 
+	case name[0] != '~': // wildcard-based path
+		re, err := wildcardToRegexp(name)
+		if err != nil {
+			return fmt.Errorf("invalid path name '%s': %w", name, err)
+		}
+		pconf.Regexp = re
+
 	default: // regular expression-based path
 		regexp, err := regexp.Compile(name[1:])
 		if err != nil {
@@ -315,11 +432,18 @@ func (pconf *Path) validate(
 		pconf.Regexp = regexp
 	}
 
+	if tenant := conf.Tenants.pathConfTenant(name); tenant != nil {
+		t := *tenant
+		pconf.Tenant = &t
+	} else {
+		pconf.Tenant = nil
+	}
+
 	// common configuration errors
 
 	if pconf.Source != "publisher" && pconf.Source != "redirect" &&
 		pconf.Regexp != nil && !pconf.SourceOnDemand {
-		return fmt.Errorf("a path with a regular expression (or path 'all') and a static source" +
+		return fmt.Errorf("a path with a regular expression or a wildcard (or path 'all') and a static source" +
 			" must have 'sourceOnDemand' set to true")
 	}
 
@@ -335,6 +459,22 @@ func (pconf *Path) validate(
 		return fmt.Errorf("'sourceRedirect' is useless when source is not 'redirect'")
 	}
 
+	if pconf.SourceRetryPause <= 0 {
+		return fmt.Errorf("'sourceRetryPause' must be greater than zero")
+	}
+
+	if pconf.SourceRetryMaxPause < pconf.SourceRetryPause {
+		return fmt.Errorf("'sourceRetryMaxPause' must be greater than or equal to 'sourceRetryPause'")
+	}
+
+	if pconf.RTPJitterBufferSize < 0 || (pconf.RTPJitterBufferSize&(pconf.RTPJitterBufferSize-1)) != 0 {
+		return fmt.Errorf("'rtpJitterBufferSize' must be zero or a power of two")
+	}
+
+	if pconf.RTCPFeedbackPeriod <= 0 {
+		return fmt.Errorf("'rtcpFeedbackPeriod' must be greater than zero")
+	}
+
 	// source-dependent settings
 
 	switch {
@@ -411,12 +551,34 @@ func (pconf *Path) validate(
 			return fmt.Errorf("'%s' is not a valid UDP URL", pconf.Source)
 		}
 
+	case strings.HasPrefix(pconf.Source, "rist://"):
+		u, err := gourl.Parse(pconf.Source)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid URL", pconf.Source)
+		}
+
+		_, port, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid RIST URL", pconf.Source)
+		}
+
+		tmp, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || (tmp%2) != 0 {
+			return fmt.Errorf("RIST port must be an even number")
+		}
+
 	case strings.HasPrefix(pconf.Source, "srt://"):
-		_, err := gourl.Parse(pconf.Source)
+		u, err := gourl.Parse(pconf.Source)
 		if err != nil {
 			return fmt.Errorf("'%s' is not a valid URL", pconf.Source)
 		}
 
+		switch u.Query().Get("mode") {
+		case "", "caller", "listener":
+		default:
+			return fmt.Errorf("invalid SRT mode: '%s'", u.Query().Get("mode"))
+		}
+
 	case strings.HasPrefix(pconf.Source, "whep://") ||
 		strings.HasPrefix(pconf.Source, "wheps://"):
 		_, err := gourl.Parse(pconf.Source)
@@ -568,30 +730,18 @@ func (pconf *Path) validate(
 		l.Log(logger.Warn, "parameter 'playback' is deprecated and has no effect")
 	}
 
-	if !strings.Contains(pconf.RecordPath, "%path") {
-		return fmt.Errorf("'recordPath' must contain %%path")
-	}
-
-	if !strings.Contains(pconf.RecordPath, "%s") &&
-		(!strings.Contains(pconf.RecordPath, "%Y") ||
-			!strings.Contains(pconf.RecordPath, "%m") ||
-			!strings.Contains(pconf.RecordPath, "%d") ||
-			!strings.Contains(pconf.RecordPath, "%H") ||
-			!strings.Contains(pconf.RecordPath, "%M") ||
-			!strings.Contains(pconf.RecordPath, "%S")) {
-		return fmt.Errorf("'recordPath' must contain either %%s or %%Y %%m %%d %%H %%M %%S")
+	err := pconf.ValidateRecord(conf)
+	if err != nil {
+		return err
 	}
 
-	if conf.Playback && !strings.Contains(pconf.RecordPath, "%f") {
-		return fmt.Errorf("'recordPath' must contain %%f")
-	}
+	// Upload
 
-	if pconf.RecordSegmentDuration > Duration(24*time.Hour) { // avoid overflowing DurationV0 of mvhd
-		return fmt.Errorf("maximum segment duration is 1 day")
-	}
-
-	if pconf.RecordDeleteAfter != 0 && pconf.RecordDeleteAfter < pconf.RecordSegmentDuration {
-		return fmt.Errorf("'recordDeleteAfter' cannot be lower than 'recordSegmentDuration'")
+	if conf.Upload {
+		err = pconf.validateUpload()
+		if err != nil {
+			return err
+		}
 	}
 
 	// Authentication (deprecated)
@@ -665,7 +815,7 @@ func (pconf *Path) validate(
 	// Hooks
 
 	if pconf.RunOnInit != "" && pconf.Regexp != nil {
-		return fmt.Errorf("a path with a regular expression (or path 'all')" +
+		return fmt.Errorf("a path with a regular expression or a wildcard (or path 'all')" +
 			" does not support option 'runOnInit'; use another path")
 	}
 	if (pconf.RunOnDemand != "" || pconf.RunOnUnDemand != "") && pconf.Source != "publisher" {
@@ -694,3 +844,168 @@ func (pconf Path) HasOnDemandStaticSource() bool {
 func (pconf Path) HasOnDemandPublisher() bool {
 	return pconf.RunOnDemand != ""
 }
+
+func (pconf *Path) validateUpload() error {
+	switch pconf.UploadStorage {
+	case UploadStorageWebDAV:
+		u, err := gourl.Parse(pconf.UploadEndpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("'uploadEndpoint' must be a valid HTTP or HTTPS URL")
+		}
+		return nil
+
+	case UploadStorageSFTP:
+		u, err := gourl.Parse(pconf.UploadEndpoint)
+		if err != nil || u.Scheme != "sftp" || u.Host == "" {
+			return fmt.Errorf("'uploadEndpoint' must be a valid SFTP URL")
+		}
+		if pconf.UploadUser == "" {
+			return fmt.Errorf("'uploadUser' must not be empty")
+		}
+		if pconf.UploadPass == "" && pconf.UploadSFTPKey == "" {
+			return fmt.Errorf("either 'uploadPass' or 'uploadSFTPKey' must be set")
+		}
+		if !strings.HasPrefix(pconf.UploadSFTPFingerprint, "SHA256:") {
+			return fmt.Errorf("'uploadSFTPFingerprint' must be a SHA256 fingerprint of the host key")
+		}
+		return nil
+	}
+
+	if pconf.UploadEndpoint != "" {
+		u, err := gourl.Parse(pconf.UploadEndpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("'uploadEndpoint' must be a valid HTTP or HTTPS URL")
+		}
+	}
+
+	if pconf.UploadBucket == "" {
+		return fmt.Errorf("'uploadBucket' must not be empty")
+	}
+
+	switch pconf.UploadStorage {
+	case UploadStorageS3:
+		if pconf.UploadRegion == "" {
+			return fmt.Errorf("'uploadRegion' must not be empty")
+		}
+		if pconf.UploadAccessKeyID == "" || pconf.UploadSecretAccessKey == "" {
+			return fmt.Errorf("'uploadAccessKeyID' and 'uploadSecretAccessKey' must not be empty")
+		}
+
+	case UploadStorageGCS:
+		if pconf.UploadGCSCredentials == "" {
+			return fmt.Errorf("'uploadGCSCredentials' must not be empty")
+		}
+
+	case UploadStorageAzure:
+		if pconf.UploadAzureAccountName == "" {
+			return fmt.Errorf("'uploadAzureAccountName' must not be empty")
+		}
+		if _, err := base64.StdEncoding.DecodeString(pconf.UploadAzureAccountKey); err != nil ||
+			pconf.UploadAzureAccountKey == "" {
+			return fmt.Errorf("'uploadAzureAccountKey' must be a valid base64 string")
+		}
+	}
+
+	return nil
+}
+
+func validateRecordPath(name string, recordPath string, conf *Conf) error {
+	if !strings.Contains(recordPath, "%path") {
+		return fmt.Errorf("'%s' must contain %%path", name)
+	}
+
+	if !strings.Contains(recordPath, "%s") &&
+		(!strings.Contains(recordPath, "%Y") ||
+			!strings.Contains(recordPath, "%m") ||
+			!strings.Contains(recordPath, "%d") ||
+			!strings.Contains(recordPath, "%H") ||
+			!strings.Contains(recordPath, "%M") ||
+			!strings.Contains(recordPath, "%S")) {
+		return fmt.Errorf("'%s' must contain either %%s or %%Y %%m %%d %%H %%M %%S", name)
+	}
+
+	if conf.Playback && !strings.Contains(recordPath, "%f") {
+		return fmt.Errorf("'%s' must contain %%f", name)
+	}
+
+	return nil
+}
+
+// ValidateRecord validates recording parameters.
+func (pconf *Path) ValidateRecord(conf *Conf) error {
+	if pconf.Tenant != nil {
+		recordPath, err := pconf.Tenant.scopeRecordPath(pconf.RecordPath)
+		if err != nil {
+			return err
+		}
+		pconf.RecordPath = recordPath
+	}
+
+	err := validateRecordPath("recordPath", pconf.RecordPath, conf)
+	if err != nil {
+		return err
+	}
+
+	if pconf.RecordPartDuration <= 0 {
+		return fmt.Errorf("'recordPartDuration' must be greater than zero")
+	}
+
+	if pconf.RecordSegmentDuration < pconf.RecordPartDuration {
+		return fmt.Errorf("'recordSegmentDuration' must be greater than or equal to 'recordPartDuration'")
+	}
+
+	if pconf.RecordSegmentDuration > Duration(24*time.Hour) { // avoid overflowing DurationV0 of mvhd
+		return fmt.Errorf("maximum segment duration is 1 day")
+	}
+
+	if pconf.RecordRestartPause <= 0 {
+		return fmt.Errorf("'recordRestartPause' must be greater than zero")
+	}
+
+	if pconf.RecordDeleteAfter != 0 && pconf.RecordDeleteAfter < pconf.RecordSegmentDuration {
+		return fmt.Errorf("'recordDeleteAfter' cannot be lower than 'recordSegmentDuration'")
+	}
+
+	if pconf.RecordMaxWriteLatency < 0 {
+		return fmt.Errorf("'recordMaxWriteLatency' must be greater than or equal to zero")
+	}
+
+	if pconf.RecordEmergencyPolicy == RecordEmergencyPolicyOverflow {
+		if pconf.RecordOverflowPath == "" {
+			return fmt.Errorf("'recordOverflowPath' is required when 'recordEmergencyPolicy' is 'overflow'")
+		}
+
+		if pconf.Tenant != nil {
+			overflowPath, err2 := pconf.Tenant.scopeRecordPath(pconf.RecordOverflowPath)
+			if err2 != nil {
+				return fmt.Errorf("invalid 'recordOverflowPath': %w", err2)
+			}
+			pconf.RecordOverflowPath = overflowPath
+		}
+
+		err = validateRecordPath("recordOverflowPath", pconf.RecordOverflowPath, conf)
+		if err != nil {
+			return err
+		}
+
+		if pconf.RecordOverflowPath == pconf.RecordPath {
+			return fmt.Errorf("'recordOverflowPath' must be different from 'recordPath'")
+		}
+	}
+
+	if pconf.RecordEncryption != RecordEncryptionNo {
+		if pconf.RecordFormat != RecordFormatFMP4 {
+			return fmt.Errorf("'recordEncryption' is supported by the fmp4 record format only")
+		}
+
+		if b, err := hex.DecodeString(pconf.RecordEncryptionKeyID); err != nil || len(b) != 16 {
+			return fmt.Errorf("'recordEncryptionKeyID' must be a 16-byte hex string")
+		}
+
+		if b, err := hex.DecodeString(pconf.RecordEncryptionKey); err != nil || len(b) != 16 {
+			return fmt.Errorf("'recordEncryptionKey' must be a 16-byte hex string")
+		}
+	}
+
+	return nil
+}
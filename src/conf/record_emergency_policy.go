@@ -0,0 +1,73 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/flynnletford/mediamtx/src/conf/jsonwrapper"
+)
+
+// RecordEmergencyPolicy is the recordEmergencyPolicy parameter.
+type RecordEmergencyPolicy int
+
+// supported values.
+const (
+	RecordEmergencyPolicyNone RecordEmergencyPolicy = iota
+	RecordEmergencyPolicyKeyframes
+	RecordEmergencyPolicyOverflow
+	RecordEmergencyPolicyStop
+)
+
+// String implements fmt.Stringer.
+func (d RecordEmergencyPolicy) String() string {
+	switch d {
+	case RecordEmergencyPolicyKeyframes:
+		return "keyframes"
+
+	case RecordEmergencyPolicyOverflow:
+		return "overflow"
+
+	case RecordEmergencyPolicyStop:
+		return "stop"
+
+	default:
+		return "none"
+	}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d RecordEmergencyPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *RecordEmergencyPolicy) UnmarshalJSON(b []byte) error {
+	var in string
+	if err := jsonwrapper.Unmarshal(b, &in); err != nil {
+		return err
+	}
+
+	switch in {
+	case "none":
+		*d = RecordEmergencyPolicyNone
+
+	case "keyframes":
+		*d = RecordEmergencyPolicyKeyframes
+
+	case "overflow":
+		*d = RecordEmergencyPolicyOverflow
+
+	case "stop":
+		*d = RecordEmergencyPolicyStop
+
+	default:
+		return fmt.Errorf("invalid record emergency policy: '%s'", in)
+	}
+
+	return nil
+}
+
+// UnmarshalEnv implements env.Unmarshaler.
+func (d *RecordEmergencyPolicy) UnmarshalEnv(_ string, v string) error {
+	return d.UnmarshalJSON([]byte(`"` + v + `"`))
+}
@@ -0,0 +1,65 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/flynnletford/mediamtx/src/conf/jsonwrapper"
+)
+
+// RecordEncryption is the recordEncryption parameter.
+type RecordEncryption int
+
+// supported values.
+const (
+	RecordEncryptionNo RecordEncryption = iota
+	RecordEncryptionCENC
+	RecordEncryptionCBCS
+)
+
+// MarshalJSON implements json.Marshaler.
+func (d RecordEncryption) MarshalJSON() ([]byte, error) {
+	var out string
+
+	switch d {
+	case RecordEncryptionCENC:
+		out = "cenc"
+
+	case RecordEncryptionCBCS:
+		out = "cbcs"
+
+	default:
+		out = "no"
+	}
+
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *RecordEncryption) UnmarshalJSON(b []byte) error {
+	var in string
+	if err := jsonwrapper.Unmarshal(b, &in); err != nil {
+		return err
+	}
+
+	switch in {
+	case "no", "false":
+		*d = RecordEncryptionNo
+
+	case "cenc":
+		*d = RecordEncryptionCENC
+
+	case "cbcs":
+		*d = RecordEncryptionCBCS
+
+	default:
+		return fmt.Errorf("invalid record encryption: '%s'", in)
+	}
+
+	return nil
+}
+
+// UnmarshalEnv implements env.Unmarshaler.
+func (d *RecordEncryption) UnmarshalEnv(_ string, v string) error {
+	return d.UnmarshalJSON([]byte(`"` + v + `"`))
+}
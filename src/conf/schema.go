@@ -0,0 +1,157 @@
+package conf
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+var optionalPathType = reflect.TypeOf(OptionalPath{})
+
+// jsonName returns the name of a struct field in the configuration,
+// or an empty string if the field is not part of it.
+func jsonName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+
+	if name == "" {
+		return f.Name
+	}
+
+	return name
+}
+
+func schemaStruct(t reflect.Type, defaults *reflect.Value) map[string]interface{} {
+	properties := make(map[string]interface{})
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		name := jsonName(f)
+		if name == "" {
+			continue
+		}
+
+		prop := schemaType(f.Type)
+
+		if defaults != nil {
+			byts, err := json.Marshal(defaults.Field(i).Interface())
+			if err == nil && string(byts) != "null" {
+				prop["default"] = json.RawMessage(byts)
+			}
+		}
+
+		properties[name] = prop
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// schemaType returns the schema of a type.
+// The JSON type is inferred by encoding the zero value,
+// since most parameters are encoded differently than their Go type.
+func schemaType(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// paths can be declared without any parameter
+	if t == optionalPathType {
+		var defaults Path
+		defaults.setDefaults()
+		v := reflect.ValueOf(defaults)
+
+		s := schemaStruct(reflect.TypeOf(defaults), &v)
+		s["type"] = []string{"object", "null"}
+		return s
+	}
+
+	byts, err := json.Marshal(reflect.New(t).Elem().Interface())
+	if err != nil || len(byts) == 0 {
+		byts = []byte("null")
+	}
+
+	switch {
+	case byts[0] == '"':
+		return map[string]interface{}{"type": "string"}
+
+	case byts[0] == 't' || byts[0] == 'f':
+		return map[string]interface{}{"type": "boolean"}
+
+	// sets are encoded as arrays
+	case byts[0] == '[' && t.Kind() == reflect.Map:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": map[string]interface{}{"type": "string"},
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return schemaStruct(t, nil)
+
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": schemaType(t.Elem()),
+		}
+
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": schemaSliceItems(t),
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+
+	return map[string]interface{}{}
+}
+
+// schemaSliceItems returns the schema of items of a slice.
+// Items are inferred by encoding a slice that contains a single zero value,
+// since slices can be encoded differently than their items.
+func schemaSliceItems(t reflect.Type) map[string]interface{} {
+	byts, err := json.Marshal(reflect.MakeSlice(t, 1, 1).Interface())
+	if err == nil && len(byts) >= 2 && byts[0] == '[' {
+		switch byts[1] {
+		case '"':
+			return map[string]interface{}{"type": "string"}
+
+		case 't', 'f':
+			return map[string]interface{}{"type": "boolean"}
+		}
+	}
+
+	return schemaType(t.Elem())
+}
+
+// Schema returns a JSON Schema that describes the configuration file,
+// including default values of parameters.
+func Schema() ([]byte, error) {
+	var defaults Conf
+	defaults.setDefaults()
+	defaults.OptionalPaths = nil
+	v := reflect.ValueOf(defaults)
+
+	s := schemaStruct(reflect.TypeOf(defaults), &v)
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "MediaMTX configuration"
+
+	return json.MarshalIndent(s, "", "  ")
+}
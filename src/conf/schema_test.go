@@ -0,0 +1,57 @@
+package conf
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+type testSchema struct {
+	Properties map[string]struct {
+		Type                 interface{}     `json:"type"`
+		Default              json.RawMessage `json:"default"`
+		Properties           map[string]interface{}
+		AdditionalProperties json.RawMessage `json:"additionalProperties"`
+	} `json:"properties"`
+}
+
+func TestSchema(t *testing.T) {
+	byts, err := Schema()
+	require.NoError(t, err)
+
+	var s testSchema
+	err = json.Unmarshal(byts, &s)
+	require.NoError(t, err)
+
+	require.Equal(t, "boolean", s.Properties["rtsp"].Type)
+	require.Equal(t, `"10s"`, string(s.Properties["readTimeout"].Default))
+	require.Equal(t, "array", s.Properties["tenants"].Type)
+	require.Equal(t, "string", s.Properties["pathDefaults"].Properties["recordSegmentDuration"].(map[string]interface{})["type"])
+
+	var path struct {
+		Type       []string               `json:"type"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+	err = json.Unmarshal(s.Properties["paths"].AdditionalProperties, &path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"object", "null"}, path.Type)
+
+	// every parameter of the sample configuration must be described.
+	sample, err := os.ReadFile("../../mediamtx.yml")
+	require.NoError(t, err)
+
+	var sampleConf map[string]interface{}
+	err = yaml.Unmarshal(sample, &sampleConf)
+	require.NoError(t, err)
+
+	for key := range sampleConf {
+		require.Contains(t, s.Properties, key)
+	}
+
+	for key := range sampleConf["pathDefaults"].(map[interface{}]interface{}) {
+		require.Contains(t, path.Properties, key)
+	}
+}
@@ -0,0 +1,100 @@
+package conf
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/flynnletford/mediamtx/src/conf/jsonwrapper"
+)
+
+// Tenant is a tenant.
+// Paths whose name is equal to the tenant name, or starts with the tenant name
+// followed by a slash, belong to the tenant.
+type Tenant struct {
+	Name                 string     `json:"name"`
+	StorageRoot          string     `json:"storageRoot"`
+	MaxRecordingDuration Duration   `json:"maxRecordingDuration"`
+	MaxDiskBytes         StringSize `json:"maxDiskBytes"`
+}
+
+// Owns checks whether a path belongs to the tenant.
+func (t *Tenant) Owns(pathName string) bool {
+	return pathName == t.Name || strings.HasPrefix(pathName, t.Name+"/")
+}
+
+func (t *Tenant) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("empty tenant names are not supported")
+	}
+
+	if strings.Contains(t.Name, "/") {
+		return fmt.Errorf("invalid tenant name '%s': can't contain a slash", t.Name)
+	}
+
+	if err := IsValidPathName(t.Name); err != nil {
+		return fmt.Errorf("invalid tenant name '%s': %w", t.Name, err)
+	}
+
+	if t.MaxRecordingDuration < 0 {
+		return fmt.Errorf("'maxRecordingDuration' of tenant '%s' must be greater than or equal to zero", t.Name)
+	}
+
+	return nil
+}
+
+// scopeRecordPath places the recording path inside the storage root of the tenant.
+// Relative recording paths are resolved against the storage root,
+// absolute ones must be inside it.
+func (t *Tenant) scopeRecordPath(recordPath string) (string, error) {
+	if t.StorageRoot == "" {
+		return recordPath, nil
+	}
+
+	rel, err := filepath.Rel(t.StorageRoot, recordPath)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return recordPath, nil
+	}
+
+	if filepath.IsAbs(recordPath) {
+		return "", fmt.Errorf("'recordPath' must be inside the storage root of tenant '%s'", t.Name)
+	}
+
+	return filepath.Join(t.StorageRoot, recordPath), nil
+}
+
+// Tenants is a list of Tenant.
+type Tenants []Tenant
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Tenants) UnmarshalJSON(b []byte) error {
+	// remove default value before loading new value
+	// https://github.com/golang/go/issues/21092
+	*s = nil
+	return jsonwrapper.Unmarshal(b, (*[]Tenant)(s))
+}
+
+// Find returns the tenant with the given name.
+func (s Tenants) Find(name string) *Tenant {
+	for i := range s {
+		if s[i].Name == name {
+			return &s[i]
+		}
+	}
+	return nil
+}
+
+// pathConfTenant returns the tenant a path configuration belongs to.
+// Regular expressions and 'all_others' never belong to a tenant.
+func (s Tenants) pathConfTenant(name string) *Tenant {
+	if name == "" || name[0] == '~' || name == "all" || name == "all_others" {
+		return nil
+	}
+
+	for i := range s {
+		if s[i].Owns(name) {
+			return &s[i]
+		}
+	}
+	return nil
+}
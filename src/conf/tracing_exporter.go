@@ -0,0 +1,56 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/flynnletford/mediamtx/src/conf/jsonwrapper"
+)
+
+// TracingExporter is the tracingExporter parameter.
+type TracingExporter int
+
+// supported values.
+const (
+	TracingExporterOTLP TracingExporter = iota
+	TracingExporterStdout
+)
+
+// String implements fmt.Stringer.
+func (d TracingExporter) String() string {
+	if d == TracingExporterStdout {
+		return "stdout"
+	}
+	return "otlp"
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d TracingExporter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *TracingExporter) UnmarshalJSON(b []byte) error {
+	var in string
+	if err := jsonwrapper.Unmarshal(b, &in); err != nil {
+		return err
+	}
+
+	switch in {
+	case "otlp":
+		*d = TracingExporterOTLP
+
+	case "stdout":
+		*d = TracingExporterStdout
+
+	default:
+		return fmt.Errorf("invalid tracing exporter: '%s'", in)
+	}
+
+	return nil
+}
+
+// UnmarshalEnv implements env.Unmarshaler.
+func (d *TracingExporter) UnmarshalEnv(_ string, v string) error {
+	return d.UnmarshalJSON([]byte(`"` + v + `"`))
+}
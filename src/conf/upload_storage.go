@@ -0,0 +1,80 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/flynnletford/mediamtx/src/conf/jsonwrapper"
+)
+
+// UploadStorage is the uploadStorage parameter.
+type UploadStorage int
+
+// supported values.
+const (
+	UploadStorageS3 UploadStorage = iota
+	UploadStorageGCS
+	UploadStorageAzure
+	UploadStorageWebDAV
+	UploadStorageSFTP
+)
+
+// String implements fmt.Stringer.
+func (d UploadStorage) String() string {
+	switch d {
+	case UploadStorageGCS:
+		return "gcs"
+
+	case UploadStorageAzure:
+		return "azure"
+
+	case UploadStorageWebDAV:
+		return "webdav"
+
+	case UploadStorageSFTP:
+		return "sftp"
+
+	default:
+		return "s3"
+	}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d UploadStorage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *UploadStorage) UnmarshalJSON(b []byte) error {
+	var in string
+	if err := jsonwrapper.Unmarshal(b, &in); err != nil {
+		return err
+	}
+
+	switch in {
+	case "s3":
+		*d = UploadStorageS3
+
+	case "gcs":
+		*d = UploadStorageGCS
+
+	case "azure":
+		*d = UploadStorageAzure
+
+	case "webdav":
+		*d = UploadStorageWebDAV
+
+	case "sftp":
+		*d = UploadStorageSFTP
+
+	default:
+		return fmt.Errorf("invalid upload storage '%s'", in)
+	}
+
+	return nil
+}
+
+// UnmarshalEnv implements env.Unmarshaler.
+func (d *UploadStorage) UnmarshalEnv(_ string, v string) error {
+	return d.UnmarshalJSON([]byte(`"` + v + `"`))
+}
@@ -41,8 +41,18 @@ func convertKeys(i interface{}) (interface{}, error) {
 	return i, nil
 }
 
+// TransformFunc is a function that is applied to the generic representation
+// of the YAML document before it is loaded into the destination.
+type TransformFunc func(interface{}) (interface{}, error)
+
 // Unmarshal loads the configuration from YAML.
 func Unmarshal(buf []byte, dest interface{}) error {
+	return UnmarshalTransform(buf, dest, nil)
+}
+
+// UnmarshalTransform loads the configuration from YAML,
+// applying a transform function before decoding.
+func UnmarshalTransform(buf []byte, dest interface{}, transform TransformFunc) error {
 	// load YAML into a generic map
 	// from documentation:
 	// "UnmarshalStrict is like Unmarshal except that any fields that are found in the data
@@ -59,6 +69,13 @@ func Unmarshal(buf []byte, dest interface{}) error {
 		return err
 	}
 
+	if transform != nil {
+		temp, err = transform(temp)
+		if err != nil {
+			return err
+		}
+	}
+
 	// convert the generic map into JSON
 	buf, err = json.Marshal(temp)
 	if err != nil {
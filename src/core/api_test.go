@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -342,6 +343,63 @@ func TestAPIPathsGet(t *testing.T) {
 	}
 }
 
+func TestAPIPathsKeyframe(t *testing.T) {
+	p, ok := newInstance("api: yes\n" +
+		"paths:\n" +
+		"  all_others:\n")
+	require.Equal(t, true, ok)
+	defer p.Close()
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+	hc := &http.Client{Transport: tr}
+
+	media0 := test.UniqueMediaH264()
+
+	source := gortsplib.Client{}
+	err := source.StartRecording("rtsp://localhost:8554/mypath",
+		&description.Session{Medias: []*description.Media{media0}})
+	require.NoError(t, err)
+	defer source.Close()
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+
+		err2 := source.WritePacketRTP(media0, &rtp.Packet{
+			Header: rtp.Header{
+				Version:     2,
+				Marker:      true,
+				PayloadType: 96,
+			},
+			Payload: []byte{5, 1, 2, 3, 4},
+		})
+		require.NoError(t, err2)
+	}()
+
+	// the request waits for the first key frame
+	res, err := hc.Get("http://localhost:9997/v3/paths/keyframe/mypath")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "video/H264", res.Header.Get("Content-Type"))
+	require.NotEmpty(t, res.Header.Get("X-Keyframe-Time"))
+
+	byts, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, append(append(append(append(append(
+		[]byte{0, 0, 0, 1}, test.FormatH264.SPS...),
+		0, 0, 0, 1), test.FormatH264.PPS...),
+		0, 0, 0, 1), 5, 1, 2, 3, 4), byts)
+
+	res2, err := hc.Get("http://localhost:9997/v3/paths/keyframe/nonexisting")
+	require.NoError(t, err)
+	defer res2.Body.Close()
+
+	require.Equal(t, http.StatusNotFound, res2.StatusCode)
+	checkError(t, "path not found", res2.Body)
+}
+
 func TestAPIProtocolListGet(t *testing.T) {
 	serverCertFpath, err := test.CreateTempFile(test.TLSCertPub)
 	require.NoError(t, err)
@@ -1183,3 +1241,207 @@ func TestAPIProtocolKickNotFound(t *testing.T) {
 		})
 	}
 }
+
+func TestAPIRecorders(t *testing.T) {
+	recordDir, err := os.MkdirTemp("", "mediamtx-api-recorders")
+	require.NoError(t, err)
+	defer os.RemoveAll(recordDir)
+
+	p, ok := newInstance("api: yes\n" +
+		"paths:\n" +
+		"  mypath:\n")
+	require.Equal(t, true, ok)
+	defer p.Close()
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+	hc := &http.Client{Transport: tr}
+
+	type recorder struct {
+		ID                uuid.UUID `json:"id"`
+		Path              string    `json:"path"`
+		State             string    `json:"state"`
+		RecordPath        string    `json:"recordPath"`
+		RecordFormat      string    `json:"recordFormat"`
+		SegmentsCreated   uint64    `json:"segmentsCreated"`
+		SegmentsCompleted uint64    `json:"segmentsCompleted"`
+	}
+
+	type recorderList struct {
+		ItemCount int        `json:"itemCount"`
+		PageCount int        `json:"pageCount"`
+		Items     []recorder `json:"items"`
+	}
+
+	recordPath := filepath.Join(recordDir, "%path/%Y-%m-%d_%H-%M-%S-%f")
+
+	var added recorder
+	httpRequest(t, hc, http.MethodPost, "http://localhost:9997/v3/recorders/add/mypath", map[string]interface{}{
+		"recordPath":   recordPath,
+		"recordFormat": "mpegts",
+	}, &added)
+	require.Equal(t, recorder{
+		ID:           added.ID,
+		Path:         "mypath",
+		State:        "waiting",
+		RecordPath:   recordPath,
+		RecordFormat: "mpegts",
+	}, added)
+
+	media0 := test.UniqueMediaH264()
+
+	source := gortsplib.Client{}
+	err = source.StartRecording(
+		"rtsp://localhost:8554/mypath",
+		&description.Session{Medias: []*description.Media{media0}})
+	require.NoError(t, err)
+	defer source.Close()
+
+	for i := 0; i < 4; i++ {
+		err = source.WritePacketRTP(media0, &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         true,
+				PayloadType:    96,
+				SequenceNumber: 1123 + uint16(i),
+				Timestamp:      45343 + 90000*uint32(i),
+				SSRC:           563423,
+			},
+			Payload: []byte{5},
+		})
+		require.NoError(t, err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	var list recorderList
+	httpRequest(t, hc, http.MethodGet, "http://localhost:9997/v3/recorders/list", nil, &list)
+	require.Equal(t, recorderList{
+		ItemCount: 1,
+		PageCount: 1,
+		Items: []recorder{{
+			ID:              added.ID,
+			Path:            "mypath",
+			State:           "recording",
+			RecordPath:      recordPath,
+			RecordFormat:    "mpegts",
+			SegmentsCreated: 1,
+		}},
+	}, list)
+
+	func() {
+		res, err2 := hc.Post("http://localhost:9997/v3/recorders/add/mypath",
+			"application/json", bytes.NewReader([]byte(`{"recordPath":"`+recordPath+`","recordFormat":"mpegts"}`)))
+		require.NoError(t, err2)
+		defer res.Body.Close()
+
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+		checkError(t, "path is already being recorded into '"+recordPath+"'", res.Body)
+	}()
+
+	httpRequest(t, hc, http.MethodDelete, "http://localhost:9997/v3/recorders/delete/"+added.ID.String(), nil, nil)
+
+	files, err := os.ReadDir(filepath.Join(recordDir, "mypath"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	func() {
+		res, err2 := hc.Get("http://localhost:9997/v3/recorders/get/" + added.ID.String())
+		require.NoError(t, err2)
+		defer res.Body.Close()
+
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+		checkError(t, "recorder not found", res.Body)
+	}()
+}
+
+func TestAPIRecordersRegexpPath(t *testing.T) {
+	recordDir, err := os.MkdirTemp("", "mediamtx-api-recorders")
+	require.NoError(t, err)
+	defer os.RemoveAll(recordDir)
+
+	p, ok := newInstance("api: yes\n" +
+		"paths:\n" +
+		"  '~^cam.*$':\n")
+	require.Equal(t, true, ok)
+	defer p.Close()
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+	hc := &http.Client{Transport: tr}
+
+	type recorder struct {
+		ID    uuid.UUID `json:"id"`
+		Path  string    `json:"path"`
+		State string    `json:"state"`
+	}
+
+	type recorderList struct {
+		Items []recorder `json:"items"`
+	}
+
+	recordPath := filepath.Join(recordDir, "%path/%Y-%m-%d_%H-%M-%S-%f")
+
+	// the path doesn't exist yet
+	var added recorder
+	httpRequest(t, hc, http.MethodPost, "http://localhost:9997/v3/recorders/add/cam1", map[string]interface{}{
+		"recordPath":   recordPath,
+		"recordFormat": "mpegts",
+	}, &added)
+	require.Equal(t, "cam1", added.Path)
+	require.Equal(t, "waiting", added.State)
+
+	media0 := test.UniqueMediaH264()
+
+	func() {
+		source := gortsplib.Client{}
+		err = source.StartRecording(
+			"rtsp://localhost:8554/cam1",
+			&description.Session{Medias: []*description.Media{media0}})
+		require.NoError(t, err)
+		defer source.Close()
+
+		for i := 0; i < 4; i++ {
+			err = source.WritePacketRTP(media0, &rtp.Packet{
+				Header: rtp.Header{
+					Version:        2,
+					Marker:         true,
+					PayloadType:    96,
+					SequenceNumber: 1123 + uint16(i),
+					Timestamp:      45343 + 90000*uint32(i),
+					SSRC:           563423,
+				},
+				Payload: []byte{5},
+			})
+			require.NoError(t, err)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+
+	// the recorder survives the publisher
+	var list recorderList
+	httpRequest(t, hc, http.MethodGet, "http://localhost:9997/v3/recorders/list", nil, &list)
+	require.Equal(t, recorderList{
+		Items: []recorder{{
+			ID:    added.ID,
+			Path:  "cam1",
+			State: "waiting",
+		}},
+	}, list)
+
+	httpRequest(t, hc, http.MethodDelete, "http://localhost:9997/v3/recorders/delete/"+added.ID.String(), nil, nil)
+
+	time.Sleep(500 * time.Millisecond)
+
+	// the path is closed together with its last recorder
+	func() {
+		res, err2 := hc.Get("http://localhost:9997/v3/paths/get/cam1")
+		require.NoError(t, err2)
+		defer res.Body.Close()
+
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+	}()
+}
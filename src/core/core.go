@@ -4,12 +4,14 @@ package core
 import (
 	"context"
 	_ "embed"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kong"
@@ -18,11 +20,15 @@ import (
 
 	"github.com/flynnletford/mediamtx/src/api"
 	"github.com/flynnletford/mediamtx/src/auth"
+	"github.com/flynnletford/mediamtx/src/catalog"
+	"github.com/flynnletford/mediamtx/src/cluster"
 	"github.com/flynnletford/mediamtx/src/conf"
 	"github.com/flynnletford/mediamtx/src/confwatcher"
+	"github.com/flynnletford/mediamtx/src/events"
 	"github.com/flynnletford/mediamtx/src/externalcmd"
 	"github.com/flynnletford/mediamtx/src/logger"
 	"github.com/flynnletford/mediamtx/src/metrics"
+	"github.com/flynnletford/mediamtx/src/onvif"
 	"github.com/flynnletford/mediamtx/src/playback"
 	"github.com/flynnletford/mediamtx/src/pprof"
 	"github.com/flynnletford/mediamtx/src/recordcleaner"
@@ -32,6 +38,9 @@ import (
 	"github.com/flynnletford/mediamtx/src/servers/rtsp"
 	"github.com/flynnletford/mediamtx/src/servers/srt"
 	"github.com/flynnletford/mediamtx/src/servers/webrtc"
+	"github.com/flynnletford/mediamtx/src/service"
+	"github.com/flynnletford/mediamtx/src/tracing"
+	"github.com/flynnletford/mediamtx/src/uploader"
 )
 
 //go:generate go run ./versiongetter
@@ -47,19 +56,96 @@ var defaultConfPaths = []string{
 }
 
 var cli struct {
-	Version  bool   `help:"print version"`
-	Confpath string `arg:"" default:""`
+	Version bool `help:"print version"`
+
+	Run struct {
+		Confpath string `arg:"" default:""`
+	} `cmd:"" default:"withargs" help:"run the server (default)"`
+
+	Remux struct {
+		Capture string `arg:"" help:"pcap or rtpdump capture that contains RTP packets"`
+		SDP     string `arg:"" help:"SDP that describes the captured session"`
+		Output  string `arg:"" help:"MP4 file to write"`
+	} `cmd:"" help:"remux a RTP capture into a MP4 file"`
+
+	Record struct {
+		URL      string        `arg:"" help:"URL of the stream to record (RTSP, WHEP or SRT)"`
+		Output   string        `arg:"" help:"MP4 file to write"`
+		Duration time.Duration `help:"stop recording after this duration. The default is recording until interrupted."`
+	} `cmd:"" help:"record a stream into a MP4 file"`
+
+	Repair struct {
+		Segments []string `arg:"" help:"fMP4 segments to repair"`
+	} `cmd:"" help:"finalize recording segments that have not been closed properly"`
+
+	Export struct {
+		Path     string `arg:"" help:"name of the path"`
+		Start    string `arg:"" help:"start of the clip, in RFC3339 format"`
+		End      string `arg:"" help:"end of the clip, in RFC3339 format"`
+		Output   string `arg:"" help:"MP4 file to write"`
+		Confpath string `default:"" help:"path to a config file. The default is mediamtx.yml."`
+	} `cmd:"" help:"export a clip of the recordings of a path into a MP4 file"`
+
+	Bundle struct {
+		Start    string   `arg:"" help:"start of the time range, in RFC3339 format"`
+		End      string   `arg:"" help:"end of the time range, in RFC3339 format"`
+		Output   string   `arg:"" help:"archive to write"`
+		Paths    []string `arg:"" help:"names of the paths"`
+		Format   string   `default:"zip" enum:"zip,tar" help:"format of the archive (zip or tar)"`
+		Confpath string   `default:"" help:"path to a config file. The default is mediamtx.yml."`
+	} `cmd:"" help:"package the recordings of one or more paths in a time range into an archive with a manifest"`
+
+	Inspect struct {
+		Segment string `arg:"" help:"fMP4 segment to inspect"`
+	} `cmd:"" help:"print metadata of a recording segment"`
+
+	Verify struct {
+		Path     string `arg:"" help:"name of the path"`
+		Remote   bool   `help:"verify also the copies uploaded to the storage of the path"`
+		Confpath string `default:"" help:"path to a config file. The default is mediamtx.yml."`
+	} `cmd:"" help:"verify checksums of the recording segments of a path"`
+
+	Validate struct {
+		Config string `default:"" help:"path to a config file. The default is mediamtx.yml."`
+	} `cmd:"" help:"check a configuration file for errors"`
+
+	Schema struct{} `cmd:"" help:"print the JSON Schema of the configuration"`
 }
 
-func atLeastOneRecordDeleteAfter(pathConfs map[string]*conf.Path) bool {
+func recordCleanerNeeded(pathConfs map[string]*conf.Path) bool {
 	for _, e := range pathConfs {
-		if e.RecordDeleteAfter != 0 {
+		if e.RecordDeleteAfter != 0 || e.UploadLocalRetention != 0 {
 			return true
 		}
 	}
 	return false
 }
 
+// catalogAnnotations allows the playback server to include annotations of the catalog into timelines.
+func catalogAnnotations(c *catalog.Catalog) playback.AnnotationsFunc {
+	return func(pathName string, start *time.Time, end *time.Time) ([]playback.TimelineAnnotation, error) {
+		items, err := c.APIAnnotationsList(&catalog.AnnotationQuery{
+			Path:  pathName,
+			Start: start,
+			End:   end,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		out := make([]playback.TimelineAnnotation, len(items))
+		for i, a := range items {
+			out[i] = playback.TimelineAnnotation{
+				Start: a.Start,
+				End:   a.End,
+				Label: a.Label,
+				Tags:  a.Tags,
+			}
+		}
+		return out, nil
+	}
+}
+
 // Core is an instance of MediaMTX.
 type Core struct {
 	ctx             context.Context
@@ -73,6 +159,14 @@ type Core struct {
 	pprof           *pprof.PPROF
 	recordCleaner   *recordcleaner.Cleaner
 	playbackServer  *playback.Server
+	eventBus        *events.Bus
+	tracer          *tracing.Tracer
+	tracingExporter *tracing.BatchExporter
+	webhook         *events.Webhook
+	mqtt            *events.MQTT
+	uploader        *uploader.Uploader
+	catalog         *catalog.Catalog
+	cluster         *cluster.Cluster
 	pathManager     *pathManager
 	rtspServer      *rtsp.Server
 	rtspsServer     *rtsp.Server
@@ -82,10 +176,15 @@ type Core struct {
 	webRTCServer    *webrtc.Server
 	srtServer       *srt.Server
 	api             *api.API
+	onvifDiscoverer *onvif.Discoverer
 	confWatcher     *confwatcher.ConfWatcher
+	service         *service.Service
 
 	// in
-	chAPIConfigSet chan *conf.Conf
+	chAPIConfigSet      chan *conf.Conf
+	chONVIFCamerasFound chan []*onvif.Camera
+	chServiceStop       chan struct{}
+	chCheckHealth       chan chan error
 
 	// out
 	done chan struct{}
@@ -109,7 +208,7 @@ func New(args []string) (*Core, bool) {
 		panic(err)
 	}
 
-	_, err = parser.Parse(args)
+	kctx, err := parser.Parse(args)
 	parser.FatalIfErrorf(err)
 
 	if cli.Version {
@@ -117,18 +216,37 @@ func New(args []string) (*Core, bool) {
 		os.Exit(0)
 	}
 
+	if tool, ok := tools[kctx.Command()]; ok {
+		err = tool()
+		if err != nil {
+			fmt.Printf("ERR: %s\n", err)
+			return nil, false
+		}
+		os.Exit(0)
+	}
+
+	return newCore(cli.Run.Confpath)
+}
+
+func newCore(confPath string) (*Core, bool) {
 	ctx, ctxCancel := context.WithCancel(context.Background())
 
 	p := &Core{
-		ctx:            ctx,
-		ctxCancel:      ctxCancel,
-		chAPIConfigSet: make(chan *conf.Conf),
-		done:           make(chan struct{}),
+		ctx:                 ctx,
+		ctxCancel:           ctxCancel,
+		chAPIConfigSet:      make(chan *conf.Conf),
+		chONVIFCamerasFound: make(chan []*onvif.Camera),
+		chServiceStop:       make(chan struct{}),
+		chCheckHealth:       make(chan chan error),
+		eventBus:            &events.Bus{},
+		tracer:              &tracing.Tracer{},
+		done:                make(chan struct{}),
 	}
 
 	tempLogger, _ := logger.New(logger.Warn, []logger.Destination{logger.DestinationStdout}, "", "")
 
-	p.conf, p.confPath, err = conf.Load(cli.Confpath, defaultConfPaths, tempLogger)
+	var err error
+	p.conf, p.confPath, err = conf.Load(confPath, defaultConfPaths, tempLogger)
 	if err != nil {
 		fmt.Printf("ERR: %s\n", err)
 		return nil, false
@@ -145,6 +263,17 @@ func New(args []string) (*Core, bool) {
 		return nil, false
 	}
 
+	// the service manager is notified once resources are ready.
+	p.service = &service.Service{
+		CheckHealth: p.checkHealth,
+		OnStop:      p.serviceStop,
+		Parent:      p,
+	}
+	err = p.service.Initialize()
+	if err != nil {
+		p.Log(logger.Warn, "unable to notify the service manager: %v", err)
+	}
+
 	go p.run()
 
 	return p, true
@@ -177,7 +306,12 @@ func (p *Core) run() {
 	}()
 
 	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
 
 outer:
 	for {
@@ -185,13 +319,21 @@ outer:
 		case <-confChanged:
 			p.Log(logger.Info, "reloading configuration (file changed)")
 
-			newConf, _, err := conf.Load(p.confPath, nil, p.logger)
+			err := p.reloadConfFromFile()
 			if err != nil {
 				p.Log(logger.Error, "%s", err)
 				break outer
 			}
 
-			err = p.reloadConf(newConf, false)
+		case <-hangup:
+			if p.confPath == "" {
+				p.Log(logger.Warn, "received SIGHUP, but there's no configuration file to reload")
+				break
+			}
+
+			p.Log(logger.Info, "reloading configuration (SIGHUP)")
+
+			err := p.reloadConfFromFile()
 			if err != nil {
 				p.Log(logger.Error, "%s", err)
 				break outer
@@ -206,10 +348,28 @@ outer:
 				break outer
 			}
 
+		case cameras := <-p.chONVIFCamerasFound:
+			err := p.addONVIFPaths(cameras)
+			if err != nil {
+				p.Log(logger.Error, "%s", err)
+				break outer
+			}
+
 		case <-interrupt:
 			p.Log(logger.Info, "shutting down gracefully")
 			break outer
 
+		case <-p.chServiceStop:
+			p.Log(logger.Info, "shutting down gracefully (service manager request)")
+			break outer
+
+		case res := <-p.chCheckHealth:
+			// the path manager is queried in a separate routine in order not to block the loop.
+			go func(pm *pathManager) {
+				_, err := pm.APIPathsList()
+				res <- err
+			}(p.pathManager)
+
 		case <-p.ctx.Done():
 			break outer
 		}
@@ -217,7 +377,45 @@ outer:
 
 	p.ctxCancel()
 
-	p.closeResources(nil, false)
+	// resources are closed in order, therefore servers stop accepting new sources
+	// before paths are closed and their recordings are finalized.
+	l := p.logger
+	shutdownTimeout := time.Duration(p.conf.ShutdownTimeout)
+
+	closeDone := make(chan struct{})
+	go func() {
+		defer close(closeDone)
+		p.closeResources(nil, false)
+	}()
+
+	select {
+	case <-closeDone:
+	case <-time.After(shutdownTimeout):
+		l.Log(logger.Error, "shutdown timeout exceeded, exiting without waiting for remaining resources")
+	}
+
+	p.service.Close()
+}
+
+// checkHealth checks whether the main loop and the path manager are responsive.
+func (p *Core) checkHealth() error {
+	res := make(chan error, 1)
+
+	select {
+	case p.chCheckHealth <- res:
+		return <-res
+
+	// shutdown is bounded by shutdownTimeout.
+	case <-p.ctx.Done():
+		return nil
+	}
+}
+
+func (p *Core) serviceStop() {
+	select {
+	case p.chServiceStop <- struct{}{}:
+	case <-p.ctx.Done():
+	}
 }
 
 func (p *Core) createResources(initial bool) error {
@@ -233,6 +431,8 @@ func (p *Core) createResources(initial bool) error {
 		if err != nil {
 			return err
 		}
+		p.logger.SetDebugModules(p.conf.LogDebugModules)
+		p.logger.SetFormat(logger.Format(p.conf.LogFormat))
 	}
 
 	if initial {
@@ -320,14 +520,32 @@ func (p *Core) createResources(initial bool) error {
 	}
 
 	if p.recordCleaner == nil &&
-		atLeastOneRecordDeleteAfter(p.conf.Paths) {
+		recordCleanerNeeded(p.conf.Paths) {
 		p.recordCleaner = &recordcleaner.Cleaner{
 			PathConfs: p.conf.Paths,
+			EventBus:  p.eventBus,
 			Parent:    p,
 		}
 		p.recordCleaner.Initialize()
 	}
 
+	if p.conf.Catalog &&
+		p.catalog == nil {
+		i := &catalog.Catalog{
+			Database:    p.conf.CatalogDatabase,
+			QueueSize:   p.conf.CatalogQueueSize,
+			PathConfs:   p.conf.Paths,
+			ReadTimeout: p.conf.ReadTimeout,
+			Parent:      p,
+		}
+		err = i.Initialize()
+		if err != nil {
+			return err
+		}
+		p.catalog = i
+		p.eventBus.AddSink(p.catalog)
+	}
+
 	if p.conf.Playback &&
 		p.playbackServer == nil {
 		i := &playback.Server{
@@ -341,6 +559,15 @@ func (p *Core) createResources(initial bool) error {
 			PathConfs:      p.conf.Paths,
 			AuthManager:    p.authManager,
 			Parent:         p,
+			OnSegmentDelete: func(pathName string, segmentPath string, trimmed bool) {
+				p.eventBus.Publish(events.TypeSegmentDelete, pathName, map[string]interface{}{
+					"segmentPath": segmentPath,
+					"trimmed":     trimmed,
+				})
+			},
+		}
+		if p.catalog != nil {
+			i.Annotations = catalogAnnotations(p.catalog)
 		}
 		err = i.Initialize()
 		if err != nil {
@@ -349,6 +576,88 @@ func (p *Core) createResources(initial bool) error {
 		p.playbackServer = i
 	}
 
+	if p.conf.Webhook &&
+		p.webhook == nil {
+		p.webhook = &events.Webhook{
+			URL:         p.conf.WebhookURL,
+			Secret:      p.conf.WebhookSecret,
+			QueueSize:   p.conf.WebhookQueueSize,
+			MaxRetries:  p.conf.WebhookMaxRetries,
+			ReadTimeout: p.conf.ReadTimeout,
+			Parent:      p,
+		}
+		p.webhook.Initialize()
+		p.eventBus.AddSink(p.webhook)
+	}
+
+	if p.conf.Tracing &&
+		p.tracingExporter == nil {
+		p.tracingExporter = &tracing.BatchExporter{
+			Type:        p.conf.TracingExporter,
+			Endpoint:    p.conf.TracingEndpoint,
+			ServiceName: p.conf.TracingServiceName,
+			QueueSize:   p.conf.TracingQueueSize,
+			ReadTimeout: p.conf.ReadTimeout,
+			Parent:      p,
+		}
+		p.tracingExporter.Initialize()
+		p.tracer.SetExporter(p.tracingExporter, p.conf.TracingSampleRatio)
+	}
+
+	if p.conf.MQTT &&
+		p.mqtt == nil {
+		p.mqtt = &events.MQTT{
+			Address:      p.conf.MQTTAddress,
+			ClientID:     p.conf.MQTTClientID,
+			User:         p.conf.MQTTUser,
+			Pass:         p.conf.MQTTPass,
+			TopicPrefix:  p.conf.MQTTTopicPrefix,
+			QoS:          p.conf.MQTTQoS,
+			Retain:       p.conf.MQTTRetain,
+			ReadTimeout:  p.conf.ReadTimeout,
+			WriteTimeout: p.conf.WriteTimeout,
+			Parent:       p,
+		}
+		p.mqtt.Initialize()
+		p.eventBus.AddSink(p.mqtt)
+	}
+
+	if p.conf.Upload &&
+		p.uploader == nil {
+		p.uploader = &uploader.Uploader{
+			PartSize:          p.conf.UploadPartSize,
+			QueueSize:         p.conf.UploadQueueSize,
+			QueuePath:         p.conf.UploadQueuePath,
+			MaxRetries:        p.conf.UploadMaxRetries,
+			DeleteAfterUpload: p.conf.UploadDeleteAfterUpload,
+			Streaming:         p.conf.UploadStreaming,
+			PathConfs:         p.conf.Paths,
+			ReadTimeout:       p.conf.ReadTimeout,
+			ShutdownTimeout:   p.conf.ShutdownTimeout,
+			EventBus:          p.eventBus,
+			Tracer:            p.tracer,
+			Parent:            p,
+		}
+		p.uploader.Initialize()
+		p.eventBus.AddSink(p.uploader)
+
+		if p.metrics != nil {
+			p.metrics.SetUploader(p.uploader)
+		}
+	}
+
+	if p.conf.Cluster &&
+		p.cluster == nil {
+		p.cluster = &cluster.Cluster{
+			NodeName:      p.conf.ClusterNodeName,
+			Peers:         p.conf.ClusterPeers,
+			CheckInterval: p.conf.ClusterCheckInterval,
+			ReadTimeout:   p.conf.ReadTimeout,
+			Parent:        p,
+		}
+		p.cluster.Initialize()
+	}
+
 	if p.pathManager == nil {
 		p.pathManager = &pathManager{
 			logLevel:          p.conf.LogLevel,
@@ -360,6 +669,9 @@ func (p *Core) createResources(initial bool) error {
 			udpMaxPayloadSize: p.conf.UDPMaxPayloadSize,
 			pathConfs:         p.conf.Paths,
 			externalCmdPool:   p.externalCmdPool,
+			eventBus:          p.eventBus,
+			tracer:            p.tracer,
+			cluster:           p.cluster,
 			parent:            p,
 		}
 		p.pathManager.initialize()
@@ -626,6 +938,10 @@ func (p *Core) createResources(initial bool) error {
 			HLSServer:      p.hlsServer,
 			WebRTCServer:   p.webRTCServer,
 			SRTServer:      p.srtServer,
+			Cluster:        p.cluster,
+			Catalog:        p.catalog,
+			Uploader:       p.uploader,
+			EventBus:       p.eventBus,
 			Parent:         p,
 		}
 		err = i.Initialize()
@@ -635,6 +951,18 @@ func (p *Core) createResources(initial bool) error {
 		p.api = i
 	}
 
+	if p.conf.ONVIF &&
+		p.onvifDiscoverer == nil {
+		p.onvifDiscoverer = &onvif.Discoverer{
+			Interval:    p.conf.ONVIFDiscoveryInterval,
+			ReadTimeout: p.conf.ReadTimeout,
+			User:        p.conf.ONVIFUser,
+			Pass:        p.conf.ONVIFPass,
+			Parent:      p,
+		}
+		p.onvifDiscoverer.Initialize()
+	}
+
 	if initial && p.confPath != "" {
 		p.confWatcher = &confwatcher.ConfWatcher{FilePath: p.confPath}
 		err = p.confWatcher.Initialize()
@@ -648,10 +976,15 @@ func (p *Core) createResources(initial bool) error {
 
 func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 	closeLogger := newConf == nil ||
-		newConf.LogLevel != p.conf.LogLevel ||
 		!reflect.DeepEqual(newConf.LogDestinations, p.conf.LogDestinations) ||
 		newConf.LogFile != p.conf.LogFile ||
 		newConf.SysLogPrefix != p.conf.SysLogPrefix
+	if !closeLogger {
+		// the level, modules and format can be changed at runtime without closing components.
+		p.logger.SetLevel(logger.Level(newConf.LogLevel))
+		p.logger.SetDebugModules(newConf.LogDebugModules)
+		p.logger.SetFormat(logger.Format(newConf.LogFormat))
+	}
 
 	closeAuthManager := newConf == nil ||
 		newConf.AuthMethod != p.conf.AuthMethod ||
@@ -690,12 +1023,22 @@ func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 		closeLogger
 
 	closeRecorderCleaner := newConf == nil ||
-		atLeastOneRecordDeleteAfter(newConf.Paths) != atLeastOneRecordDeleteAfter(p.conf.Paths) ||
+		recordCleanerNeeded(newConf.Paths) != recordCleanerNeeded(p.conf.Paths) ||
 		closeLogger
 	if !closeRecorderCleaner && p.recordCleaner != nil && !reflect.DeepEqual(newConf.Paths, p.conf.Paths) {
 		p.recordCleaner.ReloadPathConfs(newConf.Paths)
 	}
 
+	closeCatalog := newConf == nil ||
+		newConf.Catalog != p.conf.Catalog ||
+		newConf.CatalogDatabase != p.conf.CatalogDatabase ||
+		newConf.CatalogQueueSize != p.conf.CatalogQueueSize ||
+		newConf.ReadTimeout != p.conf.ReadTimeout ||
+		closeLogger
+	if !closeCatalog && p.catalog != nil && !reflect.DeepEqual(newConf.Paths, p.conf.Paths) {
+		p.catalog.ReloadPathConfs(newConf.Paths)
+	}
+
 	closePlaybackServer := newConf == nil ||
 		newConf.Playback != p.conf.Playback ||
 		newConf.PlaybackAddress != p.conf.PlaybackAddress ||
@@ -706,19 +1049,76 @@ func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 		!reflect.DeepEqual(newConf.PlaybackTrustedProxies, p.conf.PlaybackTrustedProxies) ||
 		newConf.ReadTimeout != p.conf.ReadTimeout ||
 		closeAuthManager ||
+		closeCatalog ||
 		closeLogger
 	if !closePlaybackServer && p.playbackServer != nil && !reflect.DeepEqual(newConf.Paths, p.conf.Paths) {
 		p.playbackServer.ReloadPathConfs(newConf.Paths)
 	}
 
+	closeWebhook := newConf == nil ||
+		newConf.Webhook != p.conf.Webhook ||
+		newConf.WebhookURL != p.conf.WebhookURL ||
+		newConf.WebhookSecret != p.conf.WebhookSecret ||
+		newConf.WebhookQueueSize != p.conf.WebhookQueueSize ||
+		newConf.WebhookMaxRetries != p.conf.WebhookMaxRetries ||
+		newConf.ReadTimeout != p.conf.ReadTimeout ||
+		closeLogger
+
+	closeMQTT := newConf == nil ||
+		newConf.MQTT != p.conf.MQTT ||
+		newConf.MQTTAddress != p.conf.MQTTAddress ||
+		newConf.MQTTClientID != p.conf.MQTTClientID ||
+		newConf.MQTTUser != p.conf.MQTTUser ||
+		newConf.MQTTPass != p.conf.MQTTPass ||
+		newConf.MQTTTopicPrefix != p.conf.MQTTTopicPrefix ||
+		newConf.MQTTQoS != p.conf.MQTTQoS ||
+		newConf.MQTTRetain != p.conf.MQTTRetain ||
+		newConf.ReadTimeout != p.conf.ReadTimeout ||
+		newConf.WriteTimeout != p.conf.WriteTimeout ||
+		closeLogger
+
+	closeTracing := newConf == nil ||
+		newConf.Tracing != p.conf.Tracing ||
+		newConf.TracingExporter != p.conf.TracingExporter ||
+		newConf.TracingEndpoint != p.conf.TracingEndpoint ||
+		newConf.TracingServiceName != p.conf.TracingServiceName ||
+		newConf.TracingSampleRatio != p.conf.TracingSampleRatio ||
+		newConf.TracingQueueSize != p.conf.TracingQueueSize ||
+		newConf.ReadTimeout != p.conf.ReadTimeout ||
+		closeLogger
+
+	closeUploader := newConf == nil ||
+		newConf.Upload != p.conf.Upload ||
+		newConf.UploadPartSize != p.conf.UploadPartSize ||
+		newConf.UploadQueueSize != p.conf.UploadQueueSize ||
+		newConf.UploadQueuePath != p.conf.UploadQueuePath ||
+		newConf.UploadMaxRetries != p.conf.UploadMaxRetries ||
+		newConf.UploadDeleteAfterUpload != p.conf.UploadDeleteAfterUpload ||
+		newConf.UploadStreaming != p.conf.UploadStreaming ||
+		newConf.ReadTimeout != p.conf.ReadTimeout ||
+		newConf.ShutdownTimeout != p.conf.ShutdownTimeout ||
+		closeMetrics ||
+		closeLogger
+	if !closeUploader && p.uploader != nil && !reflect.DeepEqual(newConf.Paths, p.conf.Paths) {
+		p.uploader.ReloadPathConfs(newConf.Paths)
+	}
+
+	closeCluster := newConf == nil ||
+		newConf.Cluster != p.conf.Cluster ||
+		newConf.ClusterNodeName != p.conf.ClusterNodeName ||
+		!reflect.DeepEqual(newConf.ClusterPeers, p.conf.ClusterPeers) ||
+		newConf.ClusterCheckInterval != p.conf.ClusterCheckInterval ||
+		newConf.ReadTimeout != p.conf.ReadTimeout ||
+		closeLogger
+
 	closePathManager := newConf == nil ||
-		newConf.LogLevel != p.conf.LogLevel ||
 		newConf.RTSPAddress != p.conf.RTSPAddress ||
 		newConf.ReadTimeout != p.conf.ReadTimeout ||
 		newConf.WriteTimeout != p.conf.WriteTimeout ||
 		newConf.WriteQueueSize != p.conf.WriteQueueSize ||
 		newConf.UDPMaxPayloadSize != p.conf.UDPMaxPayloadSize ||
 		closeMetrics ||
+		closeCluster ||
 		closeAuthManager ||
 		closeLogger
 	if !closePathManager && !reflect.DeepEqual(newConf.Paths, p.conf.Paths) {
@@ -869,6 +1269,16 @@ func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 		closeHLSServer ||
 		closeWebRTCServer ||
 		closeSRTServer ||
+		closeCatalog ||
+		closeUploader ||
+		closeLogger
+
+	closeONVIFDiscoverer := newConf == nil ||
+		newConf.ONVIF != p.conf.ONVIF ||
+		newConf.ONVIFDiscoveryInterval != p.conf.ONVIFDiscoveryInterval ||
+		newConf.ONVIFUser != p.conf.ONVIFUser ||
+		newConf.ONVIFPass != p.conf.ONVIFPass ||
+		newConf.ReadTimeout != p.conf.ReadTimeout ||
 		closeLogger
 
 	if newConf == nil && p.confWatcher != nil {
@@ -876,6 +1286,11 @@ func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 		p.confWatcher = nil
 	}
 
+	if closeONVIFDiscoverer && p.onvifDiscoverer != nil {
+		p.onvifDiscoverer.Close()
+		p.onvifDiscoverer = nil
+	}
+
 	if p.api != nil {
 		if closeAPI {
 			p.api.Close()
@@ -959,11 +1374,50 @@ func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 		p.pathManager = nil
 	}
 
+	if closeCluster && p.cluster != nil {
+		p.cluster.Close()
+		p.cluster = nil
+	}
+
+	if closeWebhook && p.webhook != nil {
+		p.eventBus.RemoveSink(p.webhook)
+		p.webhook.Close()
+		p.webhook = nil
+	}
+
+	if closeMQTT && p.mqtt != nil {
+		p.eventBus.RemoveSink(p.mqtt)
+		p.mqtt.Close()
+		p.mqtt = nil
+	}
+
+	if closeUploader && p.uploader != nil {
+		if p.metrics != nil {
+			p.metrics.SetUploader(nil)
+		}
+
+		p.eventBus.RemoveSink(p.uploader)
+		p.uploader.Close()
+		p.uploader = nil
+	}
+
+	if closeTracing && p.tracingExporter != nil {
+		p.tracer.SetExporter(nil, 0)
+		p.tracingExporter.Close()
+		p.tracingExporter = nil
+	}
+
 	if closePlaybackServer && p.playbackServer != nil {
 		p.playbackServer.Close()
 		p.playbackServer = nil
 	}
 
+	if closeCatalog && p.catalog != nil {
+		p.eventBus.RemoveSink(p.catalog)
+		p.catalog.Close()
+		p.catalog = nil
+	}
+
 	if closeRecorderCleaner && p.recordCleaner != nil {
 		p.recordCleaner.Close()
 		p.recordCleaner = nil
@@ -994,12 +1448,86 @@ func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 	}
 }
 
+func (p *Core) reloadConfFromFile() error {
+	newConf, _, err := conf.Load(p.confPath, nil, p.logger)
+	if err != nil {
+		return err
+	}
+
+	return p.reloadConf(newConf, false)
+}
+
 func (p *Core) reloadConf(newConf *conf.Conf, calledByAPI bool) error {
 	p.closeResources(newConf, calledByAPI)
 	p.conf = newConf
 	return p.createResources(false)
 }
 
+// addONVIFPaths adds a path for each discovered camera that doesn't have one yet.
+// Paths are added to the configuration, therefore they are lost when the configuration file is reloaded,
+// and added again by the following discovery.
+func (p *Core) addONVIFPaths(cameras []*onvif.Camera) error {
+	newConf := p.conf.Clone()
+	added := false
+
+	for _, cam := range cameras {
+		name := p.conf.ONVIFPathPrefix + cam.Host
+
+		if _, ok := newConf.OptionalPaths[name]; ok {
+			continue
+		}
+
+		values := map[string]interface{}{
+			"source": cam.StreamURL,
+		}
+		if p.conf.ONVIFRecord {
+			values["record"] = true
+		}
+
+		byts, err := json.Marshal(values)
+		if err != nil {
+			return err
+		}
+
+		var optional conf.OptionalPath
+		err = optional.UnmarshalJSON(byts)
+		if err != nil {
+			return err
+		}
+
+		err = newConf.AddPath(name, &optional)
+		if err != nil {
+			return err
+		}
+
+		p.Log(logger.Info, "[ONVIF] adding path '%s' for camera %s", name, cam.Host)
+		added = true
+	}
+
+	if !added {
+		return nil
+	}
+
+	err := newConf.Validate(nil)
+	if err != nil {
+		// a camera provided an invalid configuration. Do not stop the server.
+		p.Log(logger.Error, "[ONVIF] unable to add paths: %v", err)
+		return nil
+	}
+
+	return p.reloadConf(newConf, false)
+}
+
+// ONVIFCamerasFound is called by onvif.Discoverer.
+func (p *Core) ONVIFCamerasFound(ctx context.Context, cameras []*onvif.Camera) {
+	select {
+	case p.chONVIFCamerasFound <- cameras:
+	case <-p.ctx.Done():
+	// this avoids deadlocks caused by <-done inside Discoverer.Close()
+	case <-ctx.Done():
+	}
+}
+
 // APIConfigSet is called by api.
 func (p *Core) APIConfigSet(conf *conf.Conf) {
 	select {
@@ -75,6 +75,12 @@ func TestMetrics(t *testing.T) {
 		bo := httpPullFile(t, hc, "http://localhost:9998/metrics")
 
 		require.Equal(t, `paths 0
+recorders 0
+recorders_segments_written 0
+recorders_bytes_written 0
+recorders_restarts 0
+recorders_queued_units 0
+recorders_queue_size 0
 hls_muxers 0
 hls_muxers_bytes_sent 0
 rtsp_conns 0
@@ -324,21 +330,51 @@ webrtc_sessions_bytes_sent 0
 			`^paths\{name=".*?",state="ready"\} 1`+"\n"+
 				`paths_bytes_received\{name=".*?",state="ready"\} [0-9]+`+"\n"+
 				`paths_bytes_sent\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_duplicated_timestamps\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_non_monotonic_timestamps\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_missing_reference_frames\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_tracks_packets_lost\{name=".*?",state="ready",track="0",codec=".*?"\} [0-9]+`+"\n"+
 				`paths\{name=".*?",state="ready"\} 1`+"\n"+
 				`paths_bytes_received\{name=".*?",state="ready"\} [0-9]+`+"\n"+
 				`paths_bytes_sent\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_duplicated_timestamps\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_non_monotonic_timestamps\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_missing_reference_frames\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_tracks_packets_lost\{name=".*?",state="ready",track="0",codec=".*?"\} [0-9]+`+"\n"+
 				`paths\{name=".*?",state="ready"\} 1`+"\n"+
 				`paths_bytes_received\{name=".*?",state="ready"\} [0-9]+`+"\n"+
 				`paths_bytes_sent\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_duplicated_timestamps\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_non_monotonic_timestamps\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_missing_reference_frames\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_tracks_packets_lost\{name=".*?",state="ready",track="0",codec=".*?"\} [0-9]+`+"\n"+
 				`paths\{name=".*?",state="ready"\} 1`+"\n"+
 				`paths_bytes_received\{name=".*?",state="ready"\} [0-9]+`+"\n"+
 				`paths_bytes_sent\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_duplicated_timestamps\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_non_monotonic_timestamps\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_missing_reference_frames\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_tracks_packets_lost\{name=".*?",state="ready",track="0",codec=".*?"\} [0-9]+`+"\n"+
 				`paths\{name=".*?",state="ready"\} 1`+"\n"+
 				`paths_bytes_received\{name=".*?",state="ready"\} [0-9]+`+"\n"+
 				`paths_bytes_sent\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_duplicated_timestamps\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_non_monotonic_timestamps\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_missing_reference_frames\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_tracks_packets_lost\{name=".*?",state="ready",track="0",codec=".*?"\} [0-9]+`+"\n"+
 				`paths\{name=".*?",state="ready"\} 1`+"\n"+
 				`paths_bytes_received\{name=".*?",state="ready"\} [0-9]+`+"\n"+
 				`paths_bytes_sent\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_duplicated_timestamps\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_non_monotonic_timestamps\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_missing_reference_frames\{name=".*?",state="ready"\} [0-9]+`+"\n"+
+				`paths_tracks_packets_lost\{name=".*?",state="ready",track="0",codec=".*?"\} [0-9]+`+"\n"+
+				`recorders 0`+"\n"+
+				`recorders_segments_written 0`+"\n"+
+				`recorders_bytes_written 0`+"\n"+
+				`recorders_restarts 0`+"\n"+
+				`recorders_queued_units 0`+"\n"+
+				`recorders_queue_size 0`+"\n"+
 				`hls_muxers\{name=".*?"\} 1`+"\n"+
 				`hls_muxers_bytes_sent\{name=".*?"\} 0`+"\n"+
 				`hls_muxers\{name=".*?"\} 1`+"\n"+
@@ -460,6 +496,12 @@ webrtc_sessions_bytes_sent 0
 
 		bo := httpPullFile(t, hc, "http://localhost:9998/metrics")
 
-		require.Equal(t, "paths 0\n", string(bo))
+		require.Equal(t, "paths 0\n"+
+			"recorders 0\n"+
+			"recorders_segments_written 0\n"+
+			"recorders_bytes_written 0\n"+
+			"recorders_restarts 0\n"+
+			"recorders_queued_units 0\n"+
+			"recorders_queue_size 0\n", string(bo))
 	})
 }
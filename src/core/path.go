@@ -2,22 +2,33 @@ package core
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"net"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/google/uuid"
 
+	"github.com/flynnletford/mediamtx/src/cluster"
 	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/counterdumper"
 	"github.com/flynnletford/mediamtx/src/defs"
+	"github.com/flynnletford/mediamtx/src/events"
 	"github.com/flynnletford/mediamtx/src/externalcmd"
 	"github.com/flynnletford/mediamtx/src/hooks"
 	"github.com/flynnletford/mediamtx/src/logger"
 	"github.com/flynnletford/mediamtx/src/recorder"
+	"github.com/flynnletford/mediamtx/src/recordstore"
 	"github.com/flynnletford/mediamtx/src/staticsources"
 	"github.com/flynnletford/mediamtx/src/stream"
+	"github.com/flynnletford/mediamtx/src/tracing"
+	"github.com/flynnletford/mediamtx/src/unit"
 )
 
 func emptyTimer() *time.Timer {
@@ -26,6 +37,15 @@ func emptyTimer() *time.Timer {
 	return t
 }
 
+// copyAU copies an access unit, since the buffers of units are reused after they are released.
+func copyAU(au [][]byte) [][]byte {
+	ret := make([][]byte, len(au))
+	for i, nalu := range au {
+		ret[i] = append([]byte(nil), nalu...)
+	}
+	return ret
+}
+
 type pathParent interface {
 	logger.Writer
 	pathReady(*path)
@@ -59,8 +79,56 @@ type pathAPIPathsGetRes struct {
 }
 
 type pathAPIPathsGetReq struct {
-	name string
-	res  chan pathAPIPathsGetRes
+	name   string
+	create bool
+	res    chan pathAPIPathsGetRes
+}
+
+type pathAPIPathsKeyframeRes struct {
+	stream *stream.Stream
+	medi   *description.Media
+	err    error
+}
+
+type pathAPIPathsKeyframeReq struct {
+	res chan pathAPIPathsKeyframeRes
+}
+
+type pathAPIRecordersListRes struct {
+	data []*defs.APIRecorder
+}
+
+type pathAPIRecordersListReq struct {
+	res chan pathAPIRecordersListRes
+}
+
+type pathAPIRecordersGetRes struct {
+	data *defs.APIRecorder
+	err  error
+}
+
+type pathAPIRecordersGetReq struct {
+	id  uuid.UUID
+	res chan pathAPIRecordersGetRes
+}
+
+type pathAPIRecordersAddRes struct {
+	data *defs.APIRecorder
+	err  error
+}
+
+type pathAPIRecordersAddReq struct {
+	conf *conf.Path
+	res  chan pathAPIRecordersAddRes
+}
+
+type pathAPIRecordersDeleteRes struct {
+	err error
+}
+
+type pathAPIRecordersDeleteReq struct {
+	id  uuid.UUID
+	res chan pathAPIRecordersDeleteRes
 }
 
 type path struct {
@@ -76,6 +144,9 @@ type path struct {
 	matches           []string
 	wg                *sync.WaitGroup
 	externalCmdPool   *externalcmd.Pool
+	eventBus          *events.Bus
+	tracer            *tracing.Tracer
+	cluster           *cluster.Cluster
 	parent            pathParent
 
 	ctx                            context.Context
@@ -84,7 +155,10 @@ type path struct {
 	source                         defs.Source
 	publisherQuery                 string
 	stream                         *stream.Stream
+	readerOverflows                *counterdumper.CounterDumper
 	recorder                       *recorder.Recorder
+	recorderConf                   *conf.Path
+	apiRecorders                   map[uuid.UUID]*pathAPIRecorder
 	readyTime                      time.Time
 	onUnDemandHook                 func(string)
 	onNotReadyHook                 func()
@@ -110,6 +184,12 @@ type path struct {
 	chAddReader               chan defs.PathAddReaderReq
 	chRemoveReader            chan defs.PathRemoveReaderReq
 	chAPIPathsGet             chan pathAPIPathsGetReq
+	chAPIPathsKeyframe        chan pathAPIPathsKeyframeReq
+	chAPIRecordersList        chan pathAPIRecordersListReq
+	chAPIRecordersGet         chan pathAPIRecordersGetReq
+	chAPIRecordersAdd         chan pathAPIRecordersAddReq
+	chAPIRecordersDelete      chan pathAPIRecordersDeleteReq
+	chClusterAssignments      chan struct{}
 
 	// out
 	done chan struct{}
@@ -121,11 +201,13 @@ func (pa *path) initialize() {
 	pa.ctx = ctx
 	pa.ctxCancel = ctxCancel
 	pa.readers = make(map[defs.Reader]struct{})
+	pa.apiRecorders = make(map[uuid.UUID]*pathAPIRecorder)
 	pa.onDemandStaticSourceReadyTimer = emptyTimer()
 	pa.onDemandStaticSourceCloseTimer = emptyTimer()
 	pa.onDemandPublisherReadyTimer = emptyTimer()
 	pa.onDemandPublisherCloseTimer = emptyTimer()
 	pa.chReloadConf = make(chan *conf.Path)
+	pa.chClusterAssignments = make(chan struct{})
 	pa.chStaticSourceSetReady = make(chan defs.PathSourceStaticSetReadyReq)
 	pa.chStaticSourceSetNotReady = make(chan defs.PathSourceStaticSetNotReadyReq)
 	pa.chDescribe = make(chan defs.PathDescribeReq)
@@ -136,6 +218,11 @@ func (pa *path) initialize() {
 	pa.chAddReader = make(chan defs.PathAddReaderReq)
 	pa.chRemoveReader = make(chan defs.PathRemoveReaderReq)
 	pa.chAPIPathsGet = make(chan pathAPIPathsGetReq)
+	pa.chAPIPathsKeyframe = make(chan pathAPIPathsKeyframeReq)
+	pa.chAPIRecordersList = make(chan pathAPIRecordersListReq)
+	pa.chAPIRecordersGet = make(chan pathAPIRecordersGetReq)
+	pa.chAPIRecordersAdd = make(chan pathAPIRecordersAddReq)
+	pa.chAPIRecordersDelete = make(chan pathAPIRecordersDeleteReq)
 	pa.done = make(chan struct{})
 
 	pa.Log(logger.Debug, "created")
@@ -265,6 +352,9 @@ func (pa *path) runInner() error {
 		case newConf := <-pa.chReloadConf:
 			pa.doReloadConf(newConf)
 
+		case <-pa.chClusterAssignments:
+			pa.doClusterAssignmentsChanged()
+
 		case req := <-pa.chStaticSourceSetReady:
 			pa.doSourceStaticSetReady(req)
 
@@ -315,6 +405,25 @@ func (pa *path) runInner() error {
 		case req := <-pa.chAPIPathsGet:
 			pa.doAPIPathsGet(req)
 
+		case req := <-pa.chAPIPathsKeyframe:
+			pa.doAPIPathsKeyframe(req)
+
+		case req := <-pa.chAPIRecordersList:
+			pa.doAPIRecordersList(req)
+
+		case req := <-pa.chAPIRecordersGet:
+			pa.doAPIRecordersGet(req)
+
+		case req := <-pa.chAPIRecordersAdd:
+			pa.doAPIRecordersAdd(req)
+
+		case req := <-pa.chAPIRecordersDelete:
+			pa.doAPIRecordersDelete(req)
+
+			if pa.shouldClose() {
+				return fmt.Errorf("not in use")
+			}
+
 		case <-pa.ctx.Done():
 			return fmt.Errorf("terminated")
 		}
@@ -359,6 +468,8 @@ func (pa *path) doOnDemandPublisherCloseTimer() {
 }
 
 func (pa *path) doReloadConf(newConf *conf.Path) {
+	oldConf := pa.conf
+
 	pa.confMutex.Lock()
 	pa.conf = newConf
 	pa.confMutex.Unlock()
@@ -367,13 +478,30 @@ func (pa *path) doReloadConf(newConf *conf.Path) {
 		pa.source.(*staticsources.Handler).ReloadConf(newConf)
 	}
 
-	if pa.conf.Record {
+	if pa.shouldRecord() {
+		// restart the recorder in order to apply new recording parameters.
+		if pa.recorder != nil && recorderConfChanged(oldConf, newConf) {
+			pa.Log(logger.Info, "recording parameters changed, restarting recorder")
+			pa.stopRecording()
+		}
+
 		if pa.stream != nil && pa.recorder == nil {
 			pa.startRecording()
 		}
 	} else if pa.recorder != nil {
-		pa.recorder.Close()
-		pa.recorder = nil
+		pa.stopRecording()
+	}
+}
+
+func (pa *path) doClusterAssignmentsChanged() {
+	switch {
+	case pa.shouldRecord() && pa.stream != nil && pa.recorder == nil:
+		pa.Log(logger.Info, "recording has been assigned to this node")
+		pa.startRecording()
+
+	case !pa.shouldRecord() && pa.recorder != nil:
+		pa.Log(logger.Info, "recording has been assigned to node '%s'", pa.cluster.Owner(pa.name))
+		pa.stopRecording()
 	}
 }
 
@@ -557,6 +685,22 @@ func (pa *path) doRemoveReader(req defs.PathRemoveReaderReq) {
 }
 
 func (pa *path) doAPIPathsGet(req pathAPIPathsGetReq) {
+	var stats *stream.Stats
+	if pa.stream != nil {
+		stats = pa.stream.Stats()
+	}
+
+	sumFormats := func(cb func(fi *stream.FormatInfo) uint64) uint64 {
+		if stats == nil {
+			return 0
+		}
+		var n uint64
+		for _, fi := range stats.Formats {
+			n += cb(&fi)
+		}
+		return n
+	}
+
 	req.res <- pathAPIPathsGetRes{
 		data: &defs.APIPath{
 			Name:     pa.name,
@@ -601,10 +745,166 @@ func (pa *path) doAPIPathsGet(req pathAPIPathsGetReq) {
 				}
 				return ret
 			}(),
+			DuplicatedTimestamps: sumFormats(func(fi *stream.FormatInfo) uint64 {
+				return fi.DuplicatedTimestamps
+			}),
+			NonMonotonicTimestamps: sumFormats(func(fi *stream.FormatInfo) uint64 {
+				return fi.NonMonotonicTimestamps
+			}),
+			MissingReferenceFrames: sumFormats(func(fi *stream.FormatInfo) uint64 {
+				return fi.MissingReferenceFrames
+			}),
+			TrackStats: func() []defs.APIPathTrackStats {
+				ret := []defs.APIPathTrackStats{}
+				if stats != nil {
+					for _, fi := range stats.Formats {
+						ret = append(ret, defs.APIPathTrackStats{
+							Codec:       fi.Codec,
+							PacketsLost: fi.PacketsLost,
+						})
+					}
+				}
+				return ret
+			}(),
+			Recorders: pa.apiRecordersStats(),
 		},
 	}
 }
 
+func (pa *path) apiRecordersStats() []defs.APIPathRecorderStats {
+	ret := []defs.APIPathRecorderStats{}
+
+	item := func(id *uuid.UUID, rec *recorder.Recorder) defs.APIPathRecorderStats {
+		stats := rec.Stats()
+		return defs.APIPathRecorderStats{
+			ID:              id,
+			SegmentsWritten: stats.SegmentsWritten,
+			BytesWritten:    stats.BytesWritten,
+			LastWrite: func() *time.Time {
+				if stats.LastWrite.IsZero() {
+					return nil
+				}
+				return &stats.LastWrite
+			}(),
+			Restarts:    stats.Restarts,
+			QueuedUnits: stats.QueuedUnits,
+			QueueSize:   stats.QueueSize,
+		}
+	}
+
+	if pa.recorder != nil {
+		ret = append(ret, item(nil, pa.recorder))
+	}
+
+	for id, r := range pa.apiRecorders {
+		if r.rec != nil {
+			ret = append(ret, item(&id, r.rec))
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].ID == nil || ret[j].ID == nil {
+			return ret[i].ID == nil
+		}
+		return ret[i].ID.String() < ret[j].ID.String()
+	})
+
+	return ret
+}
+
+func (pa *path) doAPIRecordersList(req pathAPIRecordersListReq) {
+	data := make([]*defs.APIRecorder, 0, len(pa.apiRecorders))
+
+	for _, r := range pa.apiRecorders {
+		data = append(data, r.apiItem(pa.name))
+	}
+
+	req.res <- pathAPIRecordersListRes{data: data}
+}
+
+func (pa *path) doAPIPathsKeyframe(req pathAPIPathsKeyframeReq) {
+	if pa.stream == nil {
+		req.res <- pathAPIPathsKeyframeRes{err: defs.PathNoStreamAvailableError{PathName: pa.name}}
+		return
+	}
+
+	for _, medi := range pa.stream.Desc.Medias {
+		for _, forma := range medi.Formats {
+			switch forma.(type) {
+			case *format.H264, *format.H265:
+				req.res <- pathAPIPathsKeyframeRes{stream: pa.stream, medi: medi}
+				return
+			}
+		}
+	}
+
+	req.res <- pathAPIPathsKeyframeRes{err: fmt.Errorf("path doesn't contain any H264 or H265 track")}
+}
+
+func (pa *path) doAPIRecordersGet(req pathAPIRecordersGetReq) {
+	r, ok := pa.apiRecorders[req.id]
+	if !ok {
+		req.res <- pathAPIRecordersGetRes{err: defs.ErrRecorderNotFound}
+		return
+	}
+
+	req.res <- pathAPIRecordersGetRes{data: r.apiItem(pa.name)}
+}
+
+func (pa *path) doAPIRecordersAdd(req pathAPIRecordersAddReq) {
+	// avoid writing the same segments twice
+	if pa.isRecordedInto(req.conf) {
+		req.res <- pathAPIRecordersAddRes{
+			err: fmt.Errorf("path is already being recorded into '%s'", req.conf.RecordPath),
+		}
+		return
+	}
+
+	r := &pathAPIRecorder{
+		id:      uuid.New(),
+		created: time.Now(),
+		conf:    req.conf,
+	}
+	pa.apiRecorders[r.id] = r
+
+	if pa.stream != nil {
+		r.start(pa)
+	}
+
+	req.res <- pathAPIRecordersAddRes{data: r.apiItem(pa.name)}
+}
+
+func (pa *path) isRecordedInto(recordConf *conf.Path) bool {
+	sameDestination := func(c *conf.Path) bool {
+		return c.RecordPath == recordConf.RecordPath && c.RecordFormat == recordConf.RecordFormat
+	}
+
+	if pa.shouldRecord() && sameDestination(pa.conf) {
+		return true
+	}
+
+	for _, r := range pa.apiRecorders {
+		if sameDestination(r.conf) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (pa *path) doAPIRecordersDelete(req pathAPIRecordersDeleteReq) {
+	r, ok := pa.apiRecorders[req.id]
+	if !ok {
+		req.res <- pathAPIRecordersDeleteRes{err: defs.ErrRecorderNotFound}
+		return
+	}
+
+	r.stop(pa)
+	delete(pa.apiRecorders, req.id)
+
+	req.res <- pathAPIRecordersDeleteRes{}
+}
+
 func (pa *path) SafeConf() *conf.Path {
 	pa.confMutex.RLock()
 	defer pa.confMutex.RUnlock()
@@ -632,6 +932,7 @@ func (pa *path) shouldClose() bool {
 	return pa.conf.Regexp != nil &&
 		pa.source == nil &&
 		len(pa.readers) == 0 &&
+		len(pa.apiRecorders) == 0 &&
 		len(pa.describeRequestsOnHold) == 0 &&
 		len(pa.readerAddRequestsOnHold) == 0
 }
@@ -698,22 +999,56 @@ func (pa *path) onDemandPublisherStop(reason string) {
 }
 
 func (pa *path) setReady(desc *description.Session, allocateEncoder bool) error {
+	pa.readerOverflows = &counterdumper.CounterDumper{
+		OnReport: func(val uint64) {
+			pa.eventBus.Publish(events.TypeReaderOverflow, pa.name, map[string]interface{}{
+				"count": val,
+			})
+		},
+	}
+	pa.readerOverflows.Start()
+
 	pa.stream = &stream.Stream{
-		WriteQueueSize:     pa.writeQueueSize,
-		UDPMaxPayloadSize:  pa.udpMaxPayloadSize,
-		Desc:               desc,
-		GenerateRTPPackets: allocateEncoder,
-		Parent:             pa.source,
+		WriteQueueSize:      pa.writeQueueSize,
+		UDPMaxPayloadSize:   pa.udpMaxPayloadSize,
+		Desc:                desc,
+		GenerateRTPPackets:  allocateEncoder,
+		InjectParameterSets: pa.conf.InjectParameterSets,
+		DecodeToLPCM:        pa.conf.DecodeToLPCM,
+		DropMalformed:       pa.conf.DropMalformed,
+		CacheGOP:            pa.conf.CacheGOP,
+		JitterBufferSize:    pa.conf.RTPJitterBufferSize,
+		OnReaderOverflow: func(_ stream.Reader, _ stream.OverflowPolicy) {
+			pa.readerOverflows.Increase()
+		},
+		Tracer:   pa.tracer,
+		PathName: pa.name,
+		Parent:   pa.source,
+	}
+
+	if pa.conf.RTCPFeedback {
+		if w, ok := pa.source.(defs.RTCPFeedbackWriter); ok {
+			pa.stream.RTCPFeedbackPeriod = time.Duration(pa.conf.RTCPFeedbackPeriod)
+			pa.stream.WriteRTCPFeedback = w.WriteRTCPFeedback
+		}
 	}
+
 	err := pa.stream.Initialize()
 	if err != nil {
+		pa.stream = nil
+		pa.readerOverflows.Stop()
+		pa.readerOverflows = nil
 		return err
 	}
 
-	if pa.conf.Record {
+	if pa.shouldRecord() {
 		pa.startRecording()
 	}
 
+	for _, r := range pa.apiRecorders {
+		r.start(pa)
+	}
+
 	pa.readyTime = time.Now()
 
 	pa.onNotReadyHook = hooks.OnReady(hooks.OnReadyParams{
@@ -727,6 +1062,10 @@ func (pa *path) setReady(desc *description.Session, allocateEncoder bool) error
 
 	pa.parent.pathReady(pa)
 
+	pa.eventBus.Publish(events.TypeSourceReady, pa.name, map[string]interface{}{
+		"source": pa.source.APISourceDescribe(),
+	})
+
 	return nil
 }
 
@@ -755,24 +1094,98 @@ func (pa *path) setNotReady() {
 	pa.onNotReadyHook()
 
 	if pa.recorder != nil {
-		pa.recorder.Close()
-		pa.recorder = nil
+		pa.stopRecording()
+	}
+
+	for _, r := range pa.apiRecorders {
+		r.stop(pa)
 	}
 
 	if pa.stream != nil {
 		pa.stream.Close()
 		pa.stream = nil
+
+		pa.readerOverflows.Stop()
+		pa.readerOverflows = nil
 	}
+
+	pa.eventBus.Publish(events.TypeSourceNotReady, pa.name, nil)
+}
+
+// shouldRecord checks whether the path must be recorded by this node.
+func (pa *path) shouldRecord() bool {
+	return pa.conf.Record && pa.cluster.IsOwner(pa.name)
+}
+
+func recorderConfChanged(oldConf *conf.Path, newConf *conf.Path) bool {
+	return newConf.RecordPath != oldConf.RecordPath ||
+		newConf.RecordFormat != oldConf.RecordFormat ||
+		newConf.RecordPartDuration != oldConf.RecordPartDuration ||
+		newConf.RecordSegmentDuration != oldConf.RecordSegmentDuration ||
+		newConf.RecordSegmentIndex != oldConf.RecordSegmentIndex ||
+		newConf.RecordChecksum != oldConf.RecordChecksum ||
+		newConf.RecordRestartPause != oldConf.RecordRestartPause ||
+		newConf.RecordEncryption != oldConf.RecordEncryption ||
+		newConf.RecordEncryptionKeyID != oldConf.RecordEncryptionKeyID ||
+		newConf.RecordEncryptionKey != oldConf.RecordEncryptionKey ||
+		newConf.RecordMaxWriteLatency != oldConf.RecordMaxWriteLatency ||
+		newConf.RecordEmergencyPolicy != oldConf.RecordEmergencyPolicy ||
+		newConf.RecordOverflowPath != oldConf.RecordOverflowPath
 }
 
 func (pa *path) startRecording() {
-	pa.recorder = &recorder.Recorder{
-		PathFormat:      pa.conf.RecordPath,
-		Format:          pa.conf.RecordFormat,
-		PartDuration:    time.Duration(pa.conf.RecordPartDuration),
-		SegmentDuration: time.Duration(pa.conf.RecordSegmentDuration),
-		PathName:        pa.name,
-		Stream:          pa.stream,
+	// keep the configuration used to start the recorder, since pa.conf
+	// is replaced before the recorder is stopped when the configuration is reloaded.
+	pa.recorderConf = pa.conf
+
+	pa.recorder = pa.newRecorder(pa.recorderConf)
+	pa.recorder.Initialize()
+
+	pa.eventBus.Publish(events.TypeRecordingStart, pa.name, map[string]interface{}{
+		"recordPath": pa.recorderConf.RecordPath,
+	})
+}
+
+func (pa *path) stopRecording() {
+	pa.recorder.Close()
+	pa.recorder = nil
+
+	pa.eventBus.Publish(events.TypeRecordingStop, pa.name, map[string]interface{}{
+		"recordPath": pa.recorderConf.RecordPath,
+	})
+
+	pa.recorderConf = nil
+}
+
+func (pa *path) newRecorder(recordConf *conf.Path) *recorder.Recorder {
+	var encryption *recorder.Encryption
+
+	if recordConf.RecordEncryption != conf.RecordEncryptionNo {
+		// key ID and key have already been validated
+		encryption = &recorder.Encryption{
+			Scheme: recordConf.RecordEncryption,
+		}
+		keyID, _ := hex.DecodeString(recordConf.RecordEncryptionKeyID)
+		copy(encryption.KeyID[:], keyID)
+		key, _ := hex.DecodeString(recordConf.RecordEncryptionKey)
+		copy(encryption.Key[:], key)
+	}
+
+	return &recorder.Recorder{
+		ID:                 uuid.New().String(),
+		PathFormat:         recordConf.RecordPath,
+		Format:             recordConf.RecordFormat,
+		PartDuration:       time.Duration(recordConf.RecordPartDuration),
+		SegmentDuration:    time.Duration(recordConf.RecordSegmentDuration),
+		SegmentIndex:       recordConf.RecordSegmentIndex,
+		Checksum:           recordConf.RecordChecksum,
+		RestartPause:       time.Duration(recordConf.RecordRestartPause),
+		Encryption:         encryption,
+		MaxWriteLatency:    time.Duration(recordConf.RecordMaxWriteLatency),
+		EmergencyPolicy:    recordConf.RecordEmergencyPolicy,
+		OverflowPathFormat: recordConf.RecordOverflowPath,
+		PathName:           pa.name,
+		Stream:             pa.stream,
 		OnSegmentCreate: func(segmentPath string) {
 			if pa.conf.RunOnRecordSegmentCreate != "" {
 				env := pa.ExternalCmdEnv()
@@ -787,7 +1200,21 @@ func (pa *path) startRecording() {
 					nil)
 			}
 		},
+		OnSegmentStream: func(segmentPath string) io.WriteCloser {
+			return pa.eventBus.NewSegmentStream(pa.name, segmentPath)
+		},
 		OnSegmentComplete: func(segmentPath string, segmentDuration time.Duration) {
+			data := map[string]interface{}{
+				"segmentPath":     segmentPath,
+				"segmentDuration": segmentDuration.Seconds(),
+			}
+			if recordConf.RecordChecksum {
+				if checksum, err := recordstore.ReadChecksum(segmentPath); err == nil {
+					data["segmentChecksum"] = checksum
+				}
+			}
+			pa.eventBus.Publish(events.TypeSegmentComplete, pa.name, data)
+
 			if pa.conf.RunOnRecordSegmentComplete != "" {
 				env := pa.ExternalCmdEnv()
 				env["MTX_SEGMENT_PATH"] = segmentPath
@@ -802,9 +1229,19 @@ func (pa *path) startRecording() {
 					nil)
 			}
 		},
+		OnEmergency: func(reason recorder.EmergencyReason, err error) {
+			pa.eventBus.Publish(events.TypeRecordEmergency, pa.name, map[string]interface{}{
+				"reason": string(reason),
+				"policy": recordConf.RecordEmergencyPolicy.String(),
+				"error":  err.Error(),
+			})
+		},
+		OnRecovery: func() {
+			pa.eventBus.Publish(events.TypeRecordRecovered, pa.name, nil)
+		},
+		Tracer: pa.tracer,
 		Parent: pa,
 	}
-	pa.recorder.Initialize()
 }
 
 func (pa *path) executeRemoveReader(r defs.Reader) {
@@ -855,6 +1292,14 @@ func (pa *path) addReaderPost(req defs.PathAddReaderReq) {
 	}
 }
 
+// clusterAssignmentsChanged is called by pathManager.
+func (pa *path) clusterAssignmentsChanged() {
+	select {
+	case pa.chClusterAssignments <- struct{}{}:
+	case <-pa.ctx.Done():
+	}
+}
+
 // reloadConf is called by pathManager.
 func (pa *path) reloadConf(newConf *conf.Path) {
 	select {
@@ -985,3 +1430,113 @@ func (pa *path) APIPathsGet(req pathAPIPathsGetReq) (*defs.APIPath, error) {
 		return nil, fmt.Errorf("terminated")
 	}
 }
+
+// APIPathsKeyframe is called by api.
+func (pa *path) APIPathsKeyframe(ctx context.Context) (*defs.APIPathKeyframe, error) {
+	req := pathAPIPathsKeyframeReq{
+		res: make(chan pathAPIPathsKeyframeRes),
+	}
+
+	var res pathAPIPathsKeyframeRes
+
+	select {
+	case pa.chAPIPathsKeyframe <- req:
+		res = <-req.res
+		if res.err != nil {
+			return nil, res.err
+		}
+
+	case <-pa.ctx.Done():
+		return nil, fmt.Errorf("terminated")
+	}
+
+	// wait for the key frame outside of the path goroutine
+	u, err := res.stream.RequestKeyframeSample(ctx, res.medi)
+	if err != nil {
+		return nil, err
+	}
+	defer unit.Release(u)
+
+	kf := &defs.APIPathKeyframe{
+		Time: u.GetNTP(),
+	}
+
+	switch tu := u.(type) {
+	case *unit.H264:
+		kf.Codec = "H264"
+		kf.AU = copyAU(tu.AU)
+
+	case *unit.H265:
+		kf.Codec = "H265"
+		kf.AU = copyAU(tu.AU)
+	}
+
+	return kf, nil
+}
+
+// APIRecordersList is called by api.
+func (pa *path) APIRecordersList() ([]*defs.APIRecorder, error) {
+	req := pathAPIRecordersListReq{
+		res: make(chan pathAPIRecordersListRes),
+	}
+
+	select {
+	case pa.chAPIRecordersList <- req:
+		res := <-req.res
+		return res.data, nil
+
+	case <-pa.ctx.Done():
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+// APIRecordersGet is called by api.
+func (pa *path) APIRecordersGet(id uuid.UUID) (*defs.APIRecorder, error) {
+	req := pathAPIRecordersGetReq{
+		id:  id,
+		res: make(chan pathAPIRecordersGetRes),
+	}
+
+	select {
+	case pa.chAPIRecordersGet <- req:
+		res := <-req.res
+		return res.data, res.err
+
+	case <-pa.ctx.Done():
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+// APIRecordersAdd is called by api.
+func (pa *path) APIRecordersAdd(recordConf *conf.Path) (*defs.APIRecorder, error) {
+	req := pathAPIRecordersAddReq{
+		conf: recordConf,
+		res:  make(chan pathAPIRecordersAddRes),
+	}
+
+	select {
+	case pa.chAPIRecordersAdd <- req:
+		res := <-req.res
+		return res.data, res.err
+
+	case <-pa.ctx.Done():
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+// APIRecordersDelete is called by api.
+func (pa *path) APIRecordersDelete(id uuid.UUID) error {
+	req := pathAPIRecordersDeleteReq{
+		id:  id,
+		res: make(chan pathAPIRecordersDeleteRes),
+	}
+
+	select {
+	case pa.chAPIRecordersDelete <- req:
+		res := <-req.res
+		return res.err
+
+	case <-pa.ctx.Done():
+		return fmt.Errorf("terminated")
+	}
+}
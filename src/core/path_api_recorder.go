@@ -0,0 +1,101 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/defs"
+	"github.com/flynnletford/mediamtx/src/events"
+	"github.com/flynnletford/mediamtx/src/recorder"
+)
+
+// pathAPIRecorder is a recorder created through the API.
+// It is attached to the stream of the path every time the path becomes ready.
+type pathAPIRecorder struct {
+	id      uuid.UUID
+	created time.Time
+	conf    *conf.Path
+
+	rec *recorder.Recorder
+
+	mutex             sync.Mutex
+	segmentsCreated   uint64
+	segmentsCompleted uint64
+	currentSegment    *string
+	recordedDuration  time.Duration
+}
+
+func (r *pathAPIRecorder) start(pa *path) {
+	r.rec = pa.newRecorder(r.conf)
+	r.rec.ID = r.id.String()
+
+	onSegmentCreate := r.rec.OnSegmentCreate
+	r.rec.OnSegmentCreate = func(segmentPath string) {
+		r.mutex.Lock()
+		r.segmentsCreated++
+		r.currentSegment = &segmentPath
+		r.mutex.Unlock()
+
+		onSegmentCreate(segmentPath)
+	}
+
+	onSegmentComplete := r.rec.OnSegmentComplete
+	r.rec.OnSegmentComplete = func(segmentPath string, segmentDuration time.Duration) {
+		r.mutex.Lock()
+		r.segmentsCompleted++
+		r.currentSegment = nil
+		r.recordedDuration += segmentDuration
+		r.mutex.Unlock()
+
+		onSegmentComplete(segmentPath, segmentDuration)
+	}
+
+	r.rec.Initialize()
+
+	pa.eventBus.Publish(events.TypeRecordingStart, pa.name, map[string]interface{}{
+		"recordPath": r.conf.RecordPath,
+		"recorderID": r.id,
+	})
+}
+
+func (r *pathAPIRecorder) stop(pa *path) {
+	if r.rec != nil {
+		r.rec.Close()
+		r.rec = nil
+
+		pa.eventBus.Publish(events.TypeRecordingStop, pa.name, map[string]interface{}{
+			"recordPath": r.conf.RecordPath,
+			"recorderID": r.id,
+		})
+	}
+}
+
+func (r *pathAPIRecorder) apiItem(pathName string) *defs.APIRecorder {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return &defs.APIRecorder{
+		ID:      r.id,
+		Created: r.created,
+		Path:    pathName,
+		State: func() defs.APIRecorderState {
+			if r.rec != nil {
+				return defs.APIRecorderStateRecording
+			}
+			return defs.APIRecorderStateWaiting
+		}(),
+		RecordPath:            r.conf.RecordPath,
+		RecordFormat:          r.conf.RecordFormat,
+		RecordPartDuration:    r.conf.RecordPartDuration,
+		RecordSegmentDuration: r.conf.RecordSegmentDuration,
+		RecordSegmentIndex:    r.conf.RecordSegmentIndex,
+		RecordRestartPause:    r.conf.RecordRestartPause,
+		SegmentsCreated:       r.segmentsCreated,
+		SegmentsCompleted:     r.segmentsCompleted,
+		CurrentSegment:        r.currentSegment,
+		RecordedDuration:      conf.Duration(r.recordedDuration),
+	}
+}
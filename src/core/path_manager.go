@@ -2,22 +2,45 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"sync"
 
+	"github.com/google/uuid"
+
 	"github.com/flynnletford/mediamtx/src/auth"
+	"github.com/flynnletford/mediamtx/src/cluster"
 	"github.com/flynnletford/mediamtx/src/conf"
 	"github.com/flynnletford/mediamtx/src/defs"
+	"github.com/flynnletford/mediamtx/src/events"
 	"github.com/flynnletford/mediamtx/src/externalcmd"
 	"github.com/flynnletford/mediamtx/src/logger"
 	"github.com/flynnletford/mediamtx/src/stream"
+	"github.com/flynnletford/mediamtx/src/tracing"
 )
 
 func pathConfCanBeUpdated(oldPathConf *conf.Path, newPathConf *conf.Path) bool {
 	clone := oldPathConf.Clone()
 
+	clone.Tenant = newPathConf.Tenant
 	clone.Record = newPathConf.Record
+	clone.RecordPath = newPathConf.RecordPath
+	clone.RecordFormat = newPathConf.RecordFormat
+	clone.RecordPartDuration = newPathConf.RecordPartDuration
+	clone.RecordSegmentDuration = newPathConf.RecordSegmentDuration
+	clone.RecordSegmentIndex = newPathConf.RecordSegmentIndex
+	clone.RecordChecksum = newPathConf.RecordChecksum
+	clone.RecordRestartPause = newPathConf.RecordRestartPause
+	clone.RecordDeleteAfter = newPathConf.RecordDeleteAfter
+	clone.RecordEncryption = newPathConf.RecordEncryption
+	clone.RecordEncryptionKeyID = newPathConf.RecordEncryptionKeyID
+	clone.RecordEncryptionKey = newPathConf.RecordEncryptionKey
+	clone.RecordMaxWriteLatency = newPathConf.RecordMaxWriteLatency
+	clone.RecordEmergencyPolicy = newPathConf.RecordEmergencyPolicy
+	clone.RecordOverflowPath = newPathConf.RecordOverflowPath
+	clone.RunOnRecordSegmentCreate = newPathConf.RunOnRecordSegmentCreate
+	clone.RunOnRecordSegmentComplete = newPathConf.RunOnRecordSegmentComplete
 
 	clone.RPICameraBrightness = newPathConf.RPICameraBrightness
 	clone.RPICameraContrast = newPathConf.RPICameraContrast
@@ -58,6 +81,9 @@ type pathManager struct {
 	udpMaxPayloadSize int
 	pathConfs         map[string]*conf.Path
 	externalCmdPool   *externalcmd.Pool
+	eventBus          *events.Bus
+	tracer            *tracing.Tracer
+	cluster           *cluster.Cluster
 	parent            pathManagerParent
 
 	ctx         context.Context
@@ -79,6 +105,8 @@ type pathManager struct {
 	chAddPublisher chan defs.PathAddPublisherReq
 	chAPIPathsList chan pathAPIPathsListReq
 	chAPIPathsGet  chan pathAPIPathsGetReq
+
+	chClusterAssignmentsChanged chan struct{}
 }
 
 func (pm *pathManager) initialize() {
@@ -99,6 +127,7 @@ func (pm *pathManager) initialize() {
 	pm.chAddPublisher = make(chan defs.PathAddPublisherReq)
 	pm.chAPIPathsList = make(chan pathAPIPathsListReq)
 	pm.chAPIPathsGet = make(chan pathAPIPathsGetReq)
+	pm.chClusterAssignmentsChanged = make(chan struct{})
 
 	for _, pathConf := range pm.pathConfs {
 		if pathConf.Regexp == nil {
@@ -106,6 +135,10 @@ func (pm *pathManager) initialize() {
 		}
 	}
 
+	if pm.cluster != nil {
+		pm.cluster.AddListener(pm)
+	}
+
 	pm.Log(logger.Debug, "path manager created")
 
 	pm.wg.Add(1)
@@ -114,6 +147,11 @@ func (pm *pathManager) initialize() {
 
 func (pm *pathManager) close() {
 	pm.Log(logger.Debug, "path manager is shutting down")
+
+	if pm.cluster != nil {
+		pm.cluster.RemoveListener(pm)
+	}
+
 	pm.ctxCancel()
 	pm.wg.Wait()
 }
@@ -162,6 +200,9 @@ outer:
 		case req := <-pm.chAPIPathsGet:
 			pm.doAPIPathsGet(req)
 
+		case <-pm.chClusterAssignmentsChanged:
+			pm.doClusterAssignmentsChanged()
+
 		case <-pm.ctx.Done():
 			break outer
 		}
@@ -230,6 +271,12 @@ func (pm *pathManager) doPathNotReady(pa *path) {
 	}
 }
 
+func (pm *pathManager) doClusterAssignmentsChanged() {
+	for _, pa := range pm.paths {
+		go pa.clusterAssignmentsChanged()
+	}
+}
+
 func (pm *pathManager) doFindPathConf(req defs.PathFindPathConfReq) {
 	pathConf, _, err := conf.FindPathConf(pm.pathConfs, req.AccessRequest.Name)
 	if err != nil {
@@ -326,8 +373,19 @@ func (pm *pathManager) doAPIPathsList(req pathAPIPathsListReq) {
 func (pm *pathManager) doAPIPathsGet(req pathAPIPathsGetReq) {
 	path, ok := pm.paths[req.name]
 	if !ok {
-		req.res <- pathAPIPathsGetRes{err: conf.ErrPathNotFound}
-		return
+		if !req.create {
+			req.res <- pathAPIPathsGetRes{err: conf.ErrPathNotFound}
+			return
+		}
+
+		pathConf, pathMatches, err := conf.FindPathConf(pm.pathConfs, req.name)
+		if err != nil {
+			req.res <- pathAPIPathsGetRes{err: err}
+			return
+		}
+
+		pm.createPath(pathConf, req.name, pathMatches)
+		path = pm.paths[req.name]
 	}
 
 	req.res <- pathAPIPathsGetRes{path: path}
@@ -351,6 +409,9 @@ func (pm *pathManager) createPath(
 		matches:           matches,
 		wg:                &pm.wg,
 		externalCmdPool:   pm.externalCmdPool,
+		eventBus:          pm.eventBus,
+		tracer:            pm.tracer,
+		cluster:           pm.cluster,
 		parent:            pm,
 	}
 	pa.initialize()
@@ -371,6 +432,14 @@ func (pm *pathManager) removePath(pa *path) {
 	delete(pm.paths, pa.name)
 }
 
+// ClusterAssignmentsChanged implements cluster.Listener.
+func (pm *pathManager) ClusterAssignmentsChanged() {
+	select {
+	case pm.chClusterAssignmentsChanged <- struct{}{}:
+	case <-pm.ctx.Done():
+	}
+}
+
 // ReloadPathConfs is called by core.
 func (pm *pathManager) ReloadPathConfs(pathConfs map[string]*conf.Path) {
 	select {
@@ -537,3 +606,124 @@ func (pm *pathManager) APIPathsGet(name string) (*defs.APIPath, error) {
 		return nil, fmt.Errorf("terminated")
 	}
 }
+
+// APIPathsKeyframe is called by api.
+func (pm *pathManager) APIPathsKeyframe(ctx context.Context, name string) (*defs.APIPathKeyframe, error) {
+	req := pathAPIPathsGetReq{
+		name: name,
+		res:  make(chan pathAPIPathsGetRes),
+	}
+
+	select {
+	case pm.chAPIPathsGet <- req:
+		res := <-req.res
+		if res.err != nil {
+			return nil, res.err
+		}
+
+		return res.path.APIPathsKeyframe(ctx)
+
+	case <-pm.ctx.Done():
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+func (pm *pathManager) allPaths() (map[string]*path, error) {
+	req := pathAPIPathsListReq{
+		res: make(chan pathAPIPathsListRes),
+	}
+
+	select {
+	case pm.chAPIPathsList <- req:
+		res := <-req.res
+		return res.paths, nil
+
+	case <-pm.ctx.Done():
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+// APIRecordersList is called by api.
+func (pm *pathManager) APIRecordersList() (*defs.APIRecorderList, error) {
+	paths, err := pm.allPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	data := &defs.APIRecorderList{
+		Items: []*defs.APIRecorder{},
+	}
+
+	for _, pa := range paths {
+		var items []*defs.APIRecorder
+		items, err = pa.APIRecordersList()
+		if err == nil {
+			data.Items = append(data.Items, items...)
+		}
+	}
+
+	sort.Slice(data.Items, func(i, j int) bool {
+		return data.Items[i].Created.Before(data.Items[j].Created)
+	})
+
+	return data, nil
+}
+
+// APIRecordersGet is called by api.
+func (pm *pathManager) APIRecordersGet(id uuid.UUID) (*defs.APIRecorder, error) {
+	paths, err := pm.allPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pa := range paths {
+		var data *defs.APIRecorder
+		data, err = pa.APIRecordersGet(id)
+		if !errors.Is(err, defs.ErrRecorderNotFound) {
+			return data, err
+		}
+	}
+
+	return nil, defs.ErrRecorderNotFound
+}
+
+// APIRecordersAdd is called by api.
+// Paths that match a regular expression are created if they don't exist,
+// and are kept alive until all their recorders are deleted.
+func (pm *pathManager) APIRecordersAdd(pathName string, recordConf *conf.Path) (*defs.APIRecorder, error) {
+	req := pathAPIPathsGetReq{
+		name:   pathName,
+		create: true,
+		res:    make(chan pathAPIPathsGetRes),
+	}
+
+	select {
+	case pm.chAPIPathsGet <- req:
+		res := <-req.res
+		if res.err != nil {
+			return nil, res.err
+		}
+
+		return res.path.APIRecordersAdd(recordConf)
+
+	case <-pm.ctx.Done():
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
+// APIRecordersDelete is called by api.
+func (pm *pathManager) APIRecordersDelete(id uuid.UUID) error {
+	paths, err := pm.allPaths()
+	if err != nil {
+		return err
+	}
+
+	for _, pa := range paths {
+		err = pa.APIRecordersDelete(id)
+		if !errors.Is(err, defs.ErrRecorderNotFound) {
+			return err
+		}
+	}
+
+	return defs.ErrRecorderNotFound
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
@@ -24,6 +25,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/flynnletford/mediamtx/src/defs"
+	"github.com/flynnletford/mediamtx/src/events"
 	"github.com/flynnletford/mediamtx/src/protocols/rtmp"
 	"github.com/flynnletford/mediamtx/src/protocols/whip"
 	"github.com/flynnletford/mediamtx/src/test"
@@ -741,6 +743,185 @@ func TestPathRecord(t *testing.T) {
 	require.Equal(t, 2, len(files))
 }
 
+func TestPathRecordReloadParameters(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rtsp-path-record")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:9064")
+	require.NoError(t, err)
+
+	received := make(chan *events.Event, 64)
+
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			var e events.Event
+			err2 := json.NewDecoder(r.Body).Decode(&e)
+			require.NoError(t, err2)
+			if e.Type == events.TypeRecordingStart || e.Type == events.TypeRecordingStop {
+				received <- &e
+			}
+		}),
+	}
+	go s.Serve(ln)
+	defer s.Shutdown(context.Background())
+
+	p, ok := newInstance("api: yes\n" +
+		"webhook: yes\n" +
+		"webhookURL: http://127.0.0.1:9064/events\n" +
+		"paths:\n" +
+		"  all_others:\n" +
+		"    record: yes\n" +
+		"    recordPath: " + filepath.Join(dir, "first", "%path/%Y-%m-%d_%H-%M-%S-%f") + "\n")
+	require.Equal(t, true, ok)
+	defer p.Close()
+
+	media0 := test.UniqueMediaH264()
+
+	source := gortsplib.Client{}
+
+	err = source.StartRecording(
+		"rtsp://localhost:8554/mystream",
+		&description.Session{Medias: []*description.Media{media0}})
+	require.NoError(t, err)
+	defer source.Close()
+
+	writePackets := func(start int) {
+		for i := start; i < start+4; i++ {
+			err = source.WritePacketRTP(media0, &rtp.Packet{
+				Header: rtp.Header{
+					Version:        2,
+					Marker:         true,
+					PayloadType:    96,
+					SequenceNumber: 1123 + uint16(i),
+					Timestamp:      45343 + 90000*uint32(i),
+					SSRC:           563423,
+				},
+				Payload: []byte{5},
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	writePackets(0)
+
+	time.Sleep(500 * time.Millisecond)
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+	hc := &http.Client{Transport: tr}
+
+	httpRequest(t, hc, http.MethodPatch, "http://localhost:9997/v3/config/paths/patch/all_others", map[string]interface{}{
+		"recordPath": filepath.Join(dir, "second", "%path/%Y-%m-%d_%H-%M-%S-%f"),
+	}, nil)
+
+	time.Sleep(500 * time.Millisecond)
+
+	// the publisher is still connected
+	writePackets(4)
+
+	time.Sleep(500 * time.Millisecond)
+
+	for _, sub := range []string{"first", "second"} {
+		files, err := os.ReadDir(filepath.Join(dir, sub, "mystream"))
+		require.NoError(t, err)
+		require.Equal(t, 1, len(files))
+	}
+
+	var out defs.APIPathList
+	httpRequest(t, hc, http.MethodGet, "http://localhost:9997/v3/paths/list", nil, &out)
+	require.Equal(t, 1, len(out.Items))
+	require.Equal(t, true, out.Items[0].Ready)
+	require.Equal(t, 1, len(out.Items[0].Recorders))
+	require.Nil(t, out.Items[0].Recorders[0].ID)
+	require.NotZero(t, out.Items[0].Recorders[0].BytesWritten)
+
+	// the stop event contains the path that was being recorded
+	for _, ca := range []struct {
+		typ events.Type
+		sub string
+	}{
+		{events.TypeRecordingStart, "first"},
+		{events.TypeRecordingStop, "first"},
+		{events.TypeRecordingStart, "second"},
+	} {
+		e := <-received
+		require.Equal(t, ca.typ, e.Type)
+		require.Equal(t, filepath.Join(dir, ca.sub, "%path/%Y-%m-%d_%H-%M-%S-%f"), e.Data["recordPath"])
+	}
+}
+
+func TestPathWebhook(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rtsp-path-webhook")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:9061")
+	require.NoError(t, err)
+
+	received := make(chan *events.Event, 16)
+
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			var e events.Event
+			err2 := json.NewDecoder(r.Body).Decode(&e)
+			require.NoError(t, err2)
+			received <- &e
+		}),
+	}
+	go s.Serve(ln)
+	defer s.Shutdown(context.Background())
+
+	p, ok := newInstance("webhook: yes\n" +
+		"webhookURL: http://127.0.0.1:9061/events\n" +
+		"paths:\n" +
+		"  all_others:\n" +
+		"    record: yes\n" +
+		"    recordPath: " + filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f") + "\n")
+	require.Equal(t, true, ok)
+	defer p.Close()
+
+	media0 := test.UniqueMediaH264()
+
+	source := gortsplib.Client{}
+
+	err = source.StartRecording(
+		"rtsp://localhost:8554/mystream",
+		&description.Session{Medias: []*description.Media{media0}})
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		err = source.WritePacketRTP(media0, &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         true,
+				PayloadType:    96,
+				SequenceNumber: 1123 + uint16(i),
+				Timestamp:      45343 + 90000*uint32(i),
+				SSRC:           563423,
+			},
+			Payload: []byte{5},
+		})
+		require.NoError(t, err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	source.Close()
+
+	for _, typ := range []events.Type{
+		events.TypeRecordingStart,
+		events.TypeSourceReady,
+		events.TypeSegmentComplete,
+		events.TypeRecordingStop,
+		events.TypeSourceNotReady,
+	} {
+		e := <-received
+		require.Equal(t, typ, e.Type)
+		require.Equal(t, "mystream", e.Path)
+	}
+}
+
 func TestPathFallback(t *testing.T) {
 	for _, ca := range []string{
 		"absolute",
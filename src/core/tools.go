@@ -0,0 +1,348 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/playback"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+	"github.com/flynnletford/mediamtx/src/rtptomp4"
+	"github.com/flynnletford/mediamtx/src/uploader"
+)
+
+// tools are commands that perform a single task and exit, indexed by kong command.
+var tools = map[string]func() error{
+	"remux <capture> <sdp> <output>": func() error {
+		return remuxCapture(cli.Remux.Capture, cli.Remux.SDP, cli.Remux.Output)
+	},
+	"record <url> <output>": func() error {
+		return recordStream(cli.Record.URL, cli.Record.Output, cli.Record.Duration)
+	},
+	"repair <segments>": func() error {
+		return repairSegments(cli.Repair.Segments)
+	},
+	"export <path> <start> <end> <output>": func() error {
+		return exportClip(cli.Export.Confpath, cli.Export.Path, cli.Export.Start, cli.Export.End, cli.Export.Output)
+	},
+	"bundle <start> <end> <output> <paths>": func() error {
+		return bundleRecordings(cli.Bundle.Confpath, cli.Bundle.Paths, cli.Bundle.Start, cli.Bundle.End,
+			cli.Bundle.Format, cli.Bundle.Output)
+	},
+	"inspect <segment>": func() error {
+		return inspectSegment(cli.Inspect.Segment)
+	},
+	"verify <path>": func() error {
+		return verifySegments(cli.Verify.Confpath, cli.Verify.Path, cli.Verify.Remote)
+	},
+	"validate": func() error {
+		return validateConf(cli.Validate.Config)
+	},
+	"schema": printSchema,
+}
+
+func remuxCapture(capture string, sdp string, output string) error {
+	ctx, ctxCancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer ctxCancel()
+	return rtptomp4.RemuxFile(ctx, capture, sdp, output, nil)
+}
+
+// recordStream runs an instance with a single path that pulls and records the stream,
+// then concatenates the recorded segments into output.
+func recordStream(u string, output string, duration time.Duration) error {
+	dir, err := os.MkdirTemp("", "mediamtx-record")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	byts, err := json.Marshal(map[string]interface{}{
+		"rtsp":   false,
+		"rtmp":   false,
+		"hls":    false,
+		"webrtc": false,
+		"srt":    false,
+		"paths": map[string]interface{}{
+			"record": map[string]interface{}{
+				"source":       u,
+				"record":       true,
+				"recordFormat": "fmp4",
+				"recordPath":   filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f"),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	confPath := filepath.Join(dir, "mediamtx.yml")
+
+	err = os.WriteFile(confPath, byts, 0o644)
+	if err != nil {
+		return err
+	}
+
+	p, ok := newCore(confPath)
+	if !ok {
+		return fmt.Errorf("unable to start recording")
+	}
+
+	pathConf := p.conf.Paths["record"]
+
+	var timeout <-chan time.Time
+	if duration != 0 {
+		timeout = time.After(duration)
+	}
+
+	// the instance closes itself when interrupted.
+	select {
+	case <-timeout:
+		p.Close()
+	case <-p.done:
+	}
+
+	segments, err := recordstore.FindSegments(pathConf, "record", nil, nil)
+	if err != nil {
+		return fmt.Errorf("nothing has been recorded")
+	}
+
+	fpaths := make([]string, len(segments))
+	for i, seg := range segments {
+		fpaths[i] = seg.Fpath
+	}
+
+	return writeFile(output, func(f *os.File) error {
+		return playback.ConcatSegments(fpaths, f)
+	})
+}
+
+func repairSegments(fpaths []string) error {
+	for _, fpath := range fpaths {
+		repaired, err := playback.RepairSegment(fpath)
+		if err != nil {
+			return fmt.Errorf("unable to repair %s: %w", fpath, err)
+		}
+
+		if repaired {
+			fmt.Printf("%s: repaired\n", fpath)
+		} else {
+			fmt.Printf("%s: already finalized\n", fpath)
+		}
+	}
+
+	return nil
+}
+
+func exportClip(confPath string, pathName string, start string, end string, output string) error {
+	startTime, err := time.Parse(time.RFC3339Nano, start)
+	if err != nil {
+		return fmt.Errorf("invalid start: %w", err)
+	}
+
+	endTime, err := time.Parse(time.RFC3339Nano, end)
+	if err != nil {
+		return fmt.Errorf("invalid end: %w", err)
+	}
+
+	tempLogger, _ := logger.New(logger.Warn, []logger.Destination{logger.DestinationStdout}, "", "")
+
+	cnf, _, err := conf.Load(confPath, defaultConfPaths, tempLogger)
+	if err != nil {
+		return err
+	}
+
+	s := &playback.Server{
+		PathConfs: cnf.Paths,
+		Parent:    tempLogger,
+	}
+
+	return writeFile(output, func(f *os.File) error {
+		return s.Export(pathName, startTime, endTime, f)
+	})
+}
+
+func bundleRecordings(
+	confPath string,
+	pathNames []string,
+	start string,
+	end string,
+	format string,
+	output string,
+) error {
+	startTime, err := time.Parse(time.RFC3339Nano, start)
+	if err != nil {
+		return fmt.Errorf("invalid start: %w", err)
+	}
+
+	endTime, err := time.Parse(time.RFC3339Nano, end)
+	if err != nil {
+		return fmt.Errorf("invalid end: %w", err)
+	}
+
+	tempLogger, _ := logger.New(logger.Warn, []logger.Destination{logger.DestinationStdout}, "", "")
+
+	cnf, _, err := conf.Load(confPath, defaultConfPaths, tempLogger)
+	if err != nil {
+		return err
+	}
+
+	s := &playback.Server{
+		PathConfs: cnf.Paths,
+		Parent:    tempLogger,
+	}
+
+	return writeFile(output, func(f *os.File) error {
+		return s.Bundle(pathNames, startTime, endTime, format, f)
+	})
+}
+
+func inspectSegment(fpath string) error {
+	info, err := recordstore.InspectSegment(fpath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("size: %d\n", info.Size)
+	fmt.Printf("finalized: %v\n", info.Finalized())
+	fmt.Printf("duration: %v\n", info.Duration)
+	fmt.Printf("parts: %d\n", info.PartCount)
+	fmt.Printf("parts duration: %v\n", info.PartsDuration)
+
+	for _, track := range info.Tracks {
+		fmt.Printf("track %d: %s, time scale %d\n", track.ID, track.Codec, track.TimeScale)
+	}
+
+	return nil
+}
+
+// verifySegments compares the checksums of the segments of a path with the stored ones.
+func verifySegments(confPath string, pathName string, remote bool) error {
+	tempLogger, _ := logger.New(logger.Warn, []logger.Destination{logger.DestinationStdout}, "", "")
+
+	cnf, _, err := conf.Load(confPath, defaultConfPaths, tempLogger)
+	if err != nil {
+		return err
+	}
+
+	pathConf, _, err := conf.FindPathConf(cnf.Paths, pathName)
+	if err != nil {
+		return err
+	}
+
+	segments, err := recordstore.FindSegments(pathConf, pathName, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	ctx, ctxCancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer ctxCancel()
+
+	failed := 0
+
+	for _, seg := range segments {
+		var checksum string
+
+		if seg.Remote {
+			// the local copy has been removed, therefore only the remote copy can be verified.
+			if !remote {
+				fmt.Printf("%s: available in the upload storage only, skipped\n", seg.Fpath)
+				continue
+			}
+
+			checksum, err = recordstore.ReadChecksum(seg.Fpath)
+			if err != nil {
+				fmt.Printf("%s: %v\n", seg.Fpath, err)
+				failed++
+				continue
+			}
+		} else {
+			var ok bool
+			checksum, ok, err = recordstore.VerifySegment(seg.Fpath)
+			if err != nil {
+				fmt.Printf("%s: %v\n", seg.Fpath, err)
+				failed++
+				continue
+			}
+
+			if !ok {
+				fmt.Printf("%s: checksum mismatch\n", seg.Fpath)
+				failed++
+				continue
+			}
+		}
+
+		if remote {
+			remoteChecksum, err := uploader.RemoteChecksum(ctx, pathConf, pathName, seg.Fpath)
+			if err != nil {
+				fmt.Printf("%s: unable to verify remote copy: %v\n", seg.Fpath, err)
+				failed++
+				continue
+			}
+
+			if remoteChecksum != checksum {
+				fmt.Printf("%s: checksum of remote copy mismatch\n", seg.Fpath)
+				failed++
+				continue
+			}
+		}
+
+		fmt.Printf("%s: ok\n", seg.Fpath)
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%d segments out of %d failed verification", failed, len(segments))
+	}
+
+	return nil
+}
+
+func validateConf(confPath string) error {
+	tempLogger, _ := logger.New(logger.Warn, []logger.Destination{logger.DestinationStdout}, "", "")
+
+	_, confPath, err := conf.Load(confPath, defaultConfPaths, tempLogger)
+	if err != nil {
+		return err
+	}
+
+	if confPath == "" {
+		return fmt.Errorf("configuration file not found")
+	}
+
+	fmt.Printf("%s: configuration is valid\n", confPath)
+	return nil
+}
+
+func printSchema() error {
+	byts, err := conf.Schema()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(byts))
+	return nil
+}
+
+// writeFile creates a file and fills it with cb.
+// The file is removed in case of errors.
+func writeFile(fpath string, cb func(f *os.File) error) error {
+	f, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+
+	err = cb(f)
+	f.Close()
+
+	if err != nil {
+		os.Remove(fpath)
+		return err
+	}
+
+	return nil
+}
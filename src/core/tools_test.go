@@ -0,0 +1,69 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/flynnletford/mediamtx/src/test"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToolRecord(t *testing.T) {
+	p, ok := newInstance("paths:\n" +
+		"  test:\n")
+	require.Equal(t, true, ok)
+	defer p.Close()
+
+	media0 := test.UniqueMediaH264()
+
+	source := gortsplib.Client{}
+
+	err := source.StartRecording(
+		"rtsp://localhost:8554/test",
+		&description.Session{Medias: []*description.Media{media0}})
+	require.NoError(t, err)
+	defer source.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-done:
+				return
+			}
+
+			source.WritePacketRTP(media0, &rtp.Packet{ //nolint:errcheck
+				Header: rtp.Header{
+					Version:        2,
+					Marker:         true,
+					PayloadType:    96,
+					SequenceNumber: 1123 + uint16(i),
+					Timestamp:      45343 + 9000*uint32(i),
+					SSRC:           563423,
+				},
+				Payload: []byte{5},
+			})
+		}
+	}()
+
+	dir, err := os.MkdirTemp("", "mediamtx-tool-record")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "output.mp4")
+
+	err = recordStream("rtsp://localhost:8554/test", output, 2*time.Second)
+	require.NoError(t, err)
+
+	fi, err := os.Stat(output)
+	require.NoError(t, err)
+	require.NotZero(t, fi.Size())
+}
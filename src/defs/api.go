@@ -1,6 +1,7 @@
 package defs
 
 import (
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,6 +9,9 @@ import (
 	"github.com/flynnletford/mediamtx/src/conf"
 )
 
+// ErrRecorderNotFound is returned when a recorder is not found.
+var ErrRecorderNotFound = errors.New("recorder not found")
+
 // APIError is a generic error.
 type APIError struct {
 	Error string `json:"error"`
@@ -37,6 +41,56 @@ type APIPath struct {
 	BytesReceived uint64                  `json:"bytesReceived"`
 	BytesSent     uint64                  `json:"bytesSent"`
 	Readers       []APIPathSourceOrReader `json:"readers"`
+
+	DuplicatedTimestamps   uint64 `json:"duplicatedTimestamps"`
+	NonMonotonicTimestamps uint64 `json:"nonMonotonicTimestamps"`
+	MissingReferenceFrames uint64 `json:"missingReferenceFrames"`
+
+	TrackStats []APIPathTrackStats    `json:"trackStats"`
+	Recorders  []APIPathRecorderStats `json:"recorders"`
+}
+
+// APIPathTrackStats contains statistics of a track of a path.
+type APIPathTrackStats struct {
+	Codec       string `json:"codec"`
+	PacketsLost uint64 `json:"packetsLost"`
+}
+
+// APIPathKeyframe is the last key frame of a path.
+type APIPathKeyframe struct {
+	// codec of the key frame, either "H264" or "H265".
+	Codec string
+
+	// time of the key frame.
+	Time time.Time
+
+	// access unit, preceded by parameter sets.
+	AU [][]byte
+}
+
+// APIPathRecorderStats contains statistics of a running recorder of a path.
+type APIPathRecorderStats struct {
+	// ID of the recorder, nil when the recorder has been enabled with the path configuration.
+	ID              *uuid.UUID `json:"id"`
+	SegmentsWritten uint64     `json:"segmentsWritten"`
+	BytesWritten    uint64     `json:"bytesWritten"`
+	LastWrite       *time.Time `json:"lastWrite"`
+	Restarts        uint64     `json:"restarts"`
+	QueuedUnits     int        `json:"queuedUnits"`
+	QueueSize       int        `json:"queueSize"`
+}
+
+// APIHealthCheck is the result of a health check.
+type APIHealthCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// APIHealth is the health status of the server.
+type APIHealth struct {
+	OK     bool             `json:"ok"`
+	Checks []APIHealthCheck `json:"checks"`
 }
 
 // APIPathList is a list of paths.
@@ -329,15 +383,130 @@ type APIRecordingSegment struct {
 	Start time.Time `json:"start"`
 }
 
+// APIRecordingSegmentVerification is the result of the verification of a recording segment.
+type APIRecordingSegmentVerification struct {
+	Start       time.Time `json:"start"`
+	Checksum    string    `json:"checksum"`
+	Valid       bool      `json:"valid"`
+	RemoteValid *bool     `json:"remoteValid"`
+}
+
 // APIRecording is a recording.
 type APIRecording struct {
 	Name     string                 `json:"name"`
 	Segments []*APIRecordingSegment `json:"segments"`
 }
 
+// APIClusterRecordingSegment is a segment of a recording stored in a cluster node.
+type APIClusterRecordingSegment struct {
+	Start time.Time `json:"start"`
+	Node  string    `json:"node"`
+}
+
+// APIClusterRecording is a recording whose segments are merged from all cluster nodes.
+type APIClusterRecording struct {
+	Name             string                        `json:"name"`
+	Owner            string                        `json:"owner"`
+	UnreachableNodes []string                      `json:"unreachableNodes"`
+	Segments         []*APIClusterRecordingSegment `json:"segments"`
+}
+
+// APICatalogSegment is a segment stored in the recording catalog.
+type APICatalogSegment struct {
+	Path        string            `json:"path"`
+	Start       time.Time         `json:"start"`
+	Duration    float64           `json:"duration"`
+	Size        uint64            `json:"size"`
+	Tracks      []string          `json:"tracks"`
+	Checksum    string            `json:"checksum"`
+	UploadState string            `json:"uploadState"`
+	Remote      bool              `json:"remote"`
+	Tags        map[string]string `json:"tags"`
+}
+
+// APICatalogSegmentList is a list of segments stored in the recording catalog.
+type APICatalogSegmentList struct {
+	ItemCount int                  `json:"itemCount"`
+	PageCount int                  `json:"pageCount"`
+	Items     []*APICatalogSegment `json:"items"`
+}
+
+// APICatalogSegmentTags contains the tags of a segment.
+type APICatalogSegmentTags struct {
+	Tags map[string]string `json:"tags"`
+}
+
+// APICatalogAnnotation is a label attached to a time range of a recording.
+type APICatalogAnnotation struct {
+	ID    uuid.UUID         `json:"id"`
+	Path  string            `json:"path"`
+	Start time.Time         `json:"start"`
+	End   time.Time         `json:"end"`
+	Label string            `json:"label"`
+	Tags  map[string]string `json:"tags"`
+}
+
+// APICatalogAnnotationList is a list of annotations.
+type APICatalogAnnotationList struct {
+	ItemCount int                     `json:"itemCount"`
+	PageCount int                     `json:"pageCount"`
+	Items     []*APICatalogAnnotation `json:"items"`
+}
+
+// APILogging is the logging configuration, that can be changed at runtime.
+type APILogging struct {
+	LogLevel        conf.LogLevel   `json:"logLevel"`
+	LogDebugModules conf.LogModules `json:"logDebugModules"`
+}
+
 // APIRecordingList is a list of recordings.
 type APIRecordingList struct {
 	ItemCount int             `json:"itemCount"`
 	PageCount int             `json:"pageCount"`
 	Items     []*APIRecording `json:"items"`
 }
+
+// APIRecorderAdd contains the parameters of a recorder created through the API.
+// Missing parameters are filled with the ones of the path configuration.
+type APIRecorderAdd struct {
+	RecordPath            *string            `json:"recordPath"`
+	RecordFormat          *conf.RecordFormat `json:"recordFormat"`
+	RecordPartDuration    *conf.Duration     `json:"recordPartDuration"`
+	RecordSegmentDuration *conf.Duration     `json:"recordSegmentDuration"`
+	RecordSegmentIndex    *bool              `json:"recordSegmentIndex"`
+	RecordRestartPause    *conf.Duration     `json:"recordRestartPause"`
+}
+
+// APIRecorderState is the state of a recorder.
+type APIRecorderState string
+
+// states.
+const (
+	APIRecorderStateWaiting   APIRecorderState = "waiting"
+	APIRecorderStateRecording APIRecorderState = "recording"
+)
+
+// APIRecorder is a recorder created through the API.
+type APIRecorder struct {
+	ID                    uuid.UUID         `json:"id"`
+	Created               time.Time         `json:"created"`
+	Path                  string            `json:"path"`
+	State                 APIRecorderState  `json:"state"`
+	RecordPath            string            `json:"recordPath"`
+	RecordFormat          conf.RecordFormat `json:"recordFormat"`
+	RecordPartDuration    conf.Duration     `json:"recordPartDuration"`
+	RecordSegmentDuration conf.Duration     `json:"recordSegmentDuration"`
+	RecordSegmentIndex    bool              `json:"recordSegmentIndex"`
+	RecordRestartPause    conf.Duration     `json:"recordRestartPause"`
+	SegmentsCreated       uint64            `json:"segmentsCreated"`
+	SegmentsCompleted     uint64            `json:"segmentsCompleted"`
+	CurrentSegment        *string           `json:"currentSegment"`
+	RecordedDuration      conf.Duration     `json:"recordedDuration"`
+}
+
+// APIRecorderList is a list of recorders.
+type APIRecorderList struct {
+	ItemCount int            `json:"itemCount"`
+	PageCount int            `json:"pageCount"`
+	Items     []*APIRecorder `json:"items"`
+}
@@ -1,7 +1,18 @@
 package defs
 
+import (
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/pion/rtcp"
+)
+
 // Publisher is an entity that can publish a stream.
 type Publisher interface {
 	Source
 	Close()
 }
+
+// RTCPFeedbackWriter is implemented by publishers that are able to send
+// RTCP packets generated by the stream back to the source.
+type RTCPFeedbackWriter interface {
+	WriteRTCPFeedback(medi *description.Media, pkts []rtcp.Packet)
+}
@@ -0,0 +1,124 @@
+// Package events contains the runtime event bus.
+package events
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Type is the type of an event.
+type Type string
+
+// event types.
+const (
+	TypeSourceReady     Type = "sourceReady"
+	TypeSourceNotReady  Type = "sourceNotReady"
+	TypeRecordingStart  Type = "recordingStart"
+	TypeRecordingStop   Type = "recordingStop"
+	TypeSegmentComplete Type = "segmentComplete"
+	TypeSegmentUpload   Type = "segmentUpload"
+	TypeSegmentDelete   Type = "segmentDelete"
+	TypeReaderOverflow  Type = "readerOverflow"
+	TypeRecordEmergency Type = "recordEmergency"
+	TypeRecordRecovered Type = "recordRecovered"
+)
+
+// Event is a runtime event.
+type Event struct {
+	ID   uuid.UUID              `json:"id"`
+	Type Type                   `json:"type"`
+	Time time.Time              `json:"time"`
+	Path string                 `json:"path"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// Sink receives events from the bus.
+// Push is called by the routine that published the event, therefore it must not block.
+type Sink interface {
+	Push(e *Event)
+}
+
+// SegmentStreamer is implemented by sinks that receive the content of
+// segments while they are written.
+type SegmentStreamer interface {
+	NewSegmentStream(pathName string, segmentPath string) io.WriteCloser
+}
+
+// Bus dispatches events to sinks.
+type Bus struct {
+	mutex sync.RWMutex
+	sinks []Sink
+}
+
+// AddSink adds a sink.
+func (b *Bus) AddSink(s Sink) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.sinks = append(b.sinks, s)
+}
+
+// RemoveSink removes a sink.
+func (b *Bus) RemoveSink(s Sink) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for i, cur := range b.sinks {
+		if cur == s {
+			b.sinks = append(b.sinks[:i], b.sinks[i+1:]...)
+			return
+		}
+	}
+}
+
+// Publish publishes an event.
+// It can be called on a nil Bus, in which case the event is discarded.
+func (b *Bus) Publish(typ Type, path string, data map[string]interface{}) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if len(b.sinks) == 0 {
+		return
+	}
+
+	e := &Event{
+		ID:   uuid.New(),
+		Type: typ,
+		Time: time.Now(),
+		Path: path,
+		Data: data,
+	}
+
+	for _, s := range b.sinks {
+		s.Push(e)
+	}
+}
+
+// NewSegmentStream returns a writer that receives the content of a segment
+// while it is written, or nil if no sink is interested in it.
+// It can be called on a nil Bus.
+func (b *Bus) NewSegmentStream(pathName string, segmentPath string) io.WriteCloser {
+	if b == nil {
+		return nil
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, s := range b.sinks {
+		if ss, ok := s.(SegmentStreamer); ok {
+			if w := ss.NewSegmentStream(pathName, segmentPath); w != nil {
+				return w
+			}
+		}
+	}
+
+	return nil
+}
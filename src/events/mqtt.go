@@ -0,0 +1,197 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/protocols/mqtt"
+)
+
+const (
+	mqttQueueSize  = 1024
+	mqttKeepAlive  = 30 * time.Second
+	mqttRetryPause = 5 * time.Second
+)
+
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+// MQTT is a sink that publishes events to a MQTT broker.
+// Every event is published to <TopicPrefix>/paths/<path>/<event type>.
+// The status of paths is published to <TopicPrefix>/paths/<path>/ready and
+// <TopicPrefix>/paths/<path>/recording as retained messages,
+// in order to allow new subscribers to receive the current state.
+// When the connection with the broker is lost, it is established again
+// and queued events are sent.
+type MQTT struct {
+	Address      string
+	ClientID     string
+	User         string
+	Pass         string
+	TopicPrefix  string
+	QoS          int
+	Retain       bool
+	ReadTimeout  conf.Duration
+	WriteTimeout conf.Duration
+	Parent       logger.Writer
+
+	ctx       context.Context
+	ctxCancel func()
+	queue     chan *mqttMessage
+
+	done chan struct{}
+}
+
+// Initialize initializes MQTT.
+func (m *MQTT) Initialize() {
+	m.ctx, m.ctxCancel = context.WithCancel(context.Background())
+	m.queue = make(chan *mqttMessage, mqttQueueSize)
+	m.done = make(chan struct{})
+
+	m.Log(logger.Info, "publishing events to %s", m.Address)
+
+	go m.run()
+}
+
+// Close closes MQTT.
+// Events that are still in queue are discarded.
+func (m *MQTT) Close() {
+	m.Log(logger.Info, "closing")
+	m.ctxCancel()
+	<-m.done
+}
+
+// Log implements logger.Writer.
+func (m *MQTT) Log(level logger.Level, format string, args ...interface{}) {
+	m.Parent.Log(level, "[MQTT] "+format, args...)
+}
+
+// Push implements Sink.
+func (m *MQTT) Push(e *Event) {
+	byts, err := json.Marshal(e)
+	if err != nil {
+		m.Log(logger.Error, "%v", err)
+		return
+	}
+
+	pathTopic := m.TopicPrefix + "/paths/" + e.Path
+
+	m.enqueue(&mqttMessage{
+		topic:   pathTopic + "/" + string(e.Type),
+		payload: byts,
+		retain:  m.Retain,
+	})
+
+	switch e.Type {
+	case TypeSourceReady:
+		m.enqueue(&mqttMessage{topic: pathTopic + "/ready", payload: []byte("true"), retain: true})
+
+	case TypeSourceNotReady:
+		m.enqueue(&mqttMessage{topic: pathTopic + "/ready", payload: []byte("false"), retain: true})
+
+	case TypeRecordingStart:
+		m.enqueue(&mqttMessage{topic: pathTopic + "/recording", payload: []byte("true"), retain: true})
+
+	case TypeRecordingStop:
+		m.enqueue(&mqttMessage{topic: pathTopic + "/recording", payload: []byte("false"), retain: true})
+	}
+}
+
+func (m *MQTT) enqueue(msg *mqttMessage) {
+	select {
+	case m.queue <- msg:
+	default:
+		m.Log(logger.Warn, "queue is full, discarding message to %s", msg.topic)
+	}
+}
+
+func (m *MQTT) run() {
+	defer close(m.done)
+
+	var pending *mqttMessage
+
+	for {
+		var err error
+		pending, err = m.runInner(pending)
+		if m.ctx.Err() != nil {
+			return
+		}
+
+		m.Log(logger.Warn, "%v, retrying in %v", err, mqttRetryPause)
+
+		select {
+		case <-time.After(mqttRetryPause):
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// runInner connects to the broker and publishes messages until an error occurs.
+// It returns the message that was being published when the error occurred.
+func (m *MQTT) runInner(pending *mqttMessage) (*mqttMessage, error) {
+	dialer := &net.Dialer{Timeout: time.Duration(m.ReadTimeout)}
+	nconn, err := dialer.DialContext(m.ctx, "tcp", m.Address)
+	if err != nil {
+		return pending, err
+	}
+
+	connClose := make(chan struct{})
+	defer close(connClose)
+
+	go func() {
+		select {
+		case <-m.ctx.Done():
+			nconn.Close()
+		case <-connClose:
+			nconn.Close()
+		}
+	}()
+
+	c := &mqtt.Conn{RW: nconn}
+	c.Initialize()
+
+	nconn.SetDeadline(time.Now().Add(time.Duration(m.ReadTimeout))) //nolint:errcheck
+	err = c.Connect(m.ClientID, m.User, m.Pass, uint16(mqttKeepAlive/time.Second))
+	if err != nil {
+		return pending, err
+	}
+
+	m.Log(logger.Debug, "connected")
+
+	pingTicker := time.NewTicker(mqttKeepAlive / 2)
+	defer pingTicker.Stop()
+
+	for {
+		if pending != nil {
+			nconn.SetDeadline(time.Now().Add(time.Duration(m.WriteTimeout))) //nolint:errcheck
+			err = c.Publish(pending.topic, pending.payload, byte(m.QoS), pending.retain)
+			if err != nil {
+				return pending, err
+			}
+			pending = nil
+		}
+
+		select {
+		case pending = <-m.queue:
+
+		case <-pingTicker.C:
+			nconn.SetDeadline(time.Now().Add(time.Duration(m.ReadTimeout))) //nolint:errcheck
+			err = c.Ping()
+			if err != nil {
+				return nil, err
+			}
+
+		case <-m.ctx.Done():
+			c.Disconnect() //nolint:errcheck
+			return nil, nil
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package events
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/test"
+)
+
+func readMQTTPacket(br *bufio.Reader) (byte, []byte, error) {
+	header, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	l := 0
+	mult := 1
+
+	for {
+		var b byte
+		b, err = br.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		l += int(b&0x7F) * mult
+		mult *= 128
+
+		if (b & 0x80) == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, l)
+	_, err = io.ReadFull(br, body)
+	return header, body, err
+}
+
+func TestMQTT(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:9062")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	type message struct {
+		topic   string
+		payload []byte
+		retain  bool
+	}
+
+	received := make(chan message)
+
+	go func() {
+		nconn, err2 := ln.Accept()
+		require.NoError(t, err2)
+		defer nconn.Close()
+
+		br := bufio.NewReader(nconn)
+
+		header, _, err2 := readMQTTPacket(br)
+		require.NoError(t, err2)
+		require.Equal(t, byte(0x10), header)
+
+		_, err2 = nconn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		require.NoError(t, err2)
+
+		for {
+			header, body, err2 := readMQTTPacket(br)
+			if err2 != nil {
+				return
+			}
+
+			if header>>4 != 3 {
+				continue
+			}
+
+			require.Equal(t, byte(1), (header>>1)&0x03)
+
+			tl := int(binary.BigEndian.Uint16(body))
+			topic := string(body[2 : 2+tl])
+			packetID := body[2+tl : 4+tl]
+
+			_, err2 = nconn.Write([]byte{0x40, 0x02, packetID[0], packetID[1]})
+			require.NoError(t, err2)
+
+			received <- message{
+				topic:   topic,
+				payload: body[4+tl:],
+				retain:  (header & 0x01) != 0,
+			}
+		}
+	}()
+
+	m := &MQTT{
+		Address:      "127.0.0.1:9062",
+		ClientID:     "mediamtx",
+		TopicPrefix:  "mediamtx",
+		QoS:          1,
+		ReadTimeout:  conf.Duration(10 * time.Second),
+		WriteTimeout: conf.Duration(10 * time.Second),
+		Parent:       test.NilLogger,
+	}
+	m.Initialize()
+	defer m.Close()
+
+	b := &Bus{}
+	b.AddSink(m)
+
+	b.Publish(TypeRecordingStart, "mypath", map[string]interface{}{
+		"recordPath": "./recordings/%path/%Y-%m-%d_%H-%M-%S-%f",
+	})
+
+	msg := <-received
+	require.Equal(t, "mediamtx/paths/mypath/recordingStart", msg.topic)
+	require.Equal(t, false, msg.retain)
+
+	var e Event
+	err = json.Unmarshal(msg.payload, &e)
+	require.NoError(t, err)
+	require.Equal(t, TypeRecordingStart, e.Type)
+	require.Equal(t, "mypath", e.Path)
+
+	msg = <-received
+	require.Equal(t, message{
+		topic:   "mediamtx/paths/mypath/recording",
+		payload: []byte("true"),
+		retain:  true,
+	}, msg)
+}
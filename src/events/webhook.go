@@ -0,0 +1,170 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/logger"
+)
+
+const (
+	webhookRetryPause    = 1 * time.Second
+	webhookMaxRetryPause = 30 * time.Second
+)
+
+// Webhook is a sink that delivers events to a HTTP endpoint.
+// Events are queued and sent in order, one at a time. When delivery fails,
+// it is retried with an exponential back-off up to MaxRetries times.
+// When Secret is not empty, the body of every request is signed with HMAC-SHA256
+// and the signature is put into the X-MediaMTX-Signature header.
+type Webhook struct {
+	URL         string
+	Secret      string
+	QueueSize   int
+	MaxRetries  int
+	ReadTimeout conf.Duration
+	Parent      logger.Writer
+
+	ctx        context.Context
+	ctxCancel  func()
+	httpClient *http.Client
+	queue      chan *Event
+
+	done chan struct{}
+}
+
+// Initialize initializes Webhook.
+func (w *Webhook) Initialize() {
+	w.ctx, w.ctxCancel = context.WithCancel(context.Background())
+
+	w.httpClient = &http.Client{
+		Timeout: time.Duration(w.ReadTimeout),
+	}
+
+	w.queue = make(chan *Event, w.QueueSize)
+	w.done = make(chan struct{})
+
+	w.Log(logger.Info, "delivering events to %s", w.URL)
+
+	go w.run()
+}
+
+// Close closes Webhook.
+// Events that are still in queue are discarded.
+func (w *Webhook) Close() {
+	w.Log(logger.Info, "closing")
+	w.ctxCancel()
+	<-w.done
+	w.httpClient.CloseIdleConnections()
+}
+
+// Log implements logger.Writer.
+func (w *Webhook) Log(level logger.Level, format string, args ...interface{}) {
+	w.Parent.Log(level, "[webhook] "+format, args...)
+}
+
+// Push implements Sink.
+func (w *Webhook) Push(e *Event) {
+	select {
+	case w.queue <- e:
+	default:
+		w.Log(logger.Warn, "queue is full, discarding %s event", e.Type)
+	}
+}
+
+func (w *Webhook) run() {
+	defer close(w.done)
+
+	for {
+		select {
+		case e := <-w.queue:
+			w.deliver(e)
+
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *Webhook) deliver(e *Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		w.Log(logger.Error, "%v", err)
+		return
+	}
+
+	pause := webhookRetryPause
+
+	for attempt := 0; ; attempt++ {
+		err = w.send(e, body)
+		if err == nil {
+			return
+		}
+
+		if w.ctx.Err() != nil {
+			return
+		}
+
+		if attempt >= w.MaxRetries {
+			w.Log(logger.Error, "unable to deliver %s event: %v", e.Type, err)
+			return
+		}
+
+		w.Log(logger.Warn, "unable to deliver %s event: %v, retrying in %v", e.Type, err, pause)
+
+		select {
+		case <-time.After(pause):
+		case <-w.ctx.Done():
+			return
+		}
+
+		pause *= 2
+		if pause > webhookMaxRetryPause {
+			pause = webhookMaxRetryPause
+		}
+	}
+}
+
+func (w *Webhook) send(e *Event, body []byte) error {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-MediaMTX-Event", string(e.Type))
+
+	if w.Secret != "" {
+		req.Header.Set("X-MediaMTX-Signature", "sha256="+Sign(w.Secret, body))
+	}
+
+	res, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign returns the hex-encoded HMAC-SHA256 signature of a body.
+func Sign(secret string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
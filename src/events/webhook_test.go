@@ -0,0 +1,90 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/test"
+)
+
+func TestWebhook(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:9060")
+	require.NoError(t, err)
+
+	received := make(chan *Event)
+	count := 0
+
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodPost, r.Method)
+			require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+			body, err2 := io.ReadAll(r.Body)
+			require.NoError(t, err2)
+
+			require.Equal(t, "sha256="+Sign("mysecret", body), r.Header.Get("X-MediaMTX-Signature"))
+
+			// first attempt fails, in order to test retries.
+			count++
+			if count == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			var e Event
+			err2 = json.Unmarshal(body, &e)
+			require.NoError(t, err2)
+
+			require.Equal(t, string(e.Type), r.Header.Get("X-MediaMTX-Event"))
+
+			received <- &e
+		}),
+	}
+	go s.Serve(ln)
+	defer s.Shutdown(context.Background())
+
+	w := &Webhook{
+		URL:         "http://127.0.0.1:9060/events",
+		Secret:      "mysecret",
+		QueueSize:   16,
+		MaxRetries:  3,
+		ReadTimeout: conf.Duration(10 * time.Second),
+		Parent:      test.NilLogger,
+	}
+	w.Initialize()
+	defer w.Close()
+
+	b := &Bus{}
+	b.AddSink(w)
+
+	b.Publish(TypeSegmentComplete, "mypath", map[string]interface{}{
+		"segmentPath": "/recordings/mypath/1.mp4",
+	})
+	b.Publish(TypeSourceNotReady, "mypath", nil)
+
+	e := <-received
+	require.Equal(t, TypeSegmentComplete, e.Type)
+	require.Equal(t, "mypath", e.Path)
+	require.Equal(t, map[string]interface{}{
+		"segmentPath": "/recordings/mypath/1.mp4",
+	}, e.Data)
+
+	e = <-received
+	require.Equal(t, TypeSourceNotReady, e.Type)
+	require.Equal(t, 3, count)
+
+	b.RemoveSink(w)
+}
+
+func TestBusNil(_ *testing.T) {
+	var b *Bus
+	b.Publish(TypeSourceReady, "mypath", nil)
+}
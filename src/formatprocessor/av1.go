@@ -1,12 +1,14 @@
 package formatprocessor //nolint:dupl
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/bluenviron/gortsplib/v4/pkg/format"
 	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpav1"
+	mcav1 "github.com/bluenviron/mediacommon/v2/pkg/codecs/av1"
 	"github.com/pion/rtp"
 
 	"github.com/flynnletford/mediamtx/src/logger"
@@ -20,6 +22,76 @@ var (
 	}
 )
 
+// OBU types.
+// Specification: https://aomediacodec.github.io/av1-spec/#obu-header-semantics
+const (
+	av1OBUTypeSequenceHeader    = 1
+	av1OBUTypeTemporalDelimiter = 2
+	av1OBUTypeFrameHeader       = 3
+	av1OBUTypeFrame             = 6
+)
+
+// av1OBUParse returns the type and the payload of a OBU.
+func av1OBUParse(obu []byte) (byte, []byte, error) {
+	if len(obu) < 1 {
+		return 0, nil, fmt.Errorf("not enough bytes")
+	}
+
+	typ := (obu[0] >> 3) & 0b1111
+	hasExtension := ((obu[0] >> 2) & 0b1) != 0
+	hasSize := ((obu[0] >> 1) & 0b1) != 0
+
+	pos := 1
+
+	if hasExtension {
+		pos++
+	}
+
+	if len(obu) < pos {
+		return 0, nil, fmt.Errorf("not enough bytes")
+	}
+
+	if hasSize {
+		var size mcav1.LEB128
+		n, err := size.Unmarshal(obu[pos:])
+		if err != nil {
+			return 0, nil, err
+		}
+		pos += n
+
+		if len(obu[pos:]) < int(size) {
+			return 0, nil, fmt.Errorf("not enough bytes")
+		}
+
+		return typ, obu[pos : pos+int(size)], nil
+	}
+
+	return typ, obu[pos:], nil
+}
+
+// av1FrameIsKey checks whether the payload of a frame or frame header OBU
+// contains a key frame that is shown.
+// Specification: https://aomediacodec.github.io/av1-spec/#uncompressed-header-syntax
+func av1FrameIsKey(payload []byte, reducedStillPictureHeader bool) bool {
+	if reducedStillPictureHeader {
+		return true
+	}
+
+	if len(payload) < 1 {
+		return false
+	}
+
+	showExistingFrame := (payload[0] >> 7) != 0
+	if showExistingFrame {
+		return false
+	}
+
+	frameType := (payload[0] >> 5) & 0b11
+	showFrame := ((payload[0] >> 4) & 0b1) != 0
+
+	return frameType == 0 && showFrame
+}
+
 type av1 struct {
 	UDPMaxPayloadSize  int
 	Format             *format.AV1
@@ -29,11 +101,15 @@ type av1 struct {
 	encoder     *rtpav1.Encoder
 	decoder     *rtpav1.Decoder
 	randomStart uint32
+
+	// last received sequence header
+	sequenceHeader            []byte
+	reducedStillPictureHeader bool
 }
 
 func (t *av1) initialize() error {
 	if t.GenerateRTPPackets {
-		err := t.createEncoder()
+		err := t.createEncoder(nil, nil)
 		if err != nil {
 			return err
 		}
@@ -47,17 +123,90 @@ func (t *av1) initialize() error {
 	return nil
 }
 
-func (t *av1) createEncoder() error {
+func (t *av1) createEncoder(
+	ssrc *uint32,
+	initialSequenceNumber *uint16,
+) error {
 	t.encoder = &rtpav1.Encoder{
-		PayloadMaxSize: t.UDPMaxPayloadSize - 12,
-		PayloadType:    t.Format.PayloadTyp,
+		PayloadMaxSize:        t.UDPMaxPayloadSize - 12,
+		PayloadType:           t.Format.PayloadTyp,
+		SSRC:                  ssrc,
+		InitialSequenceNumber: initialSequenceNumber,
 	}
 	return t.encoder.Init()
 }
 
+func (t *av1) updateSequenceHeader(obu []byte) {
+	if bytes.Equal(obu, t.sequenceHeader) {
+		return
+	}
+
+	var h mcav1.SequenceHeader
+	err := h.Unmarshal(obu)
+	if err != nil {
+		return
+	}
+
+	t.sequenceHeader = obu
+	t.reducedStillPictureHeader = h.ReducedStillPictureHeader
+}
+
+// remuxTemporalUnit captures the sequence header, removes temporal delimiters
+// and makes sure that the sequence header is present in key frames only,
+// in order to allow muxers to be initialized from any key frame.
+func (t *av1) remuxTemporalUnit(tu [][]byte) [][]byte {
+	isKeyFrame := false
+	frameFound := false
+
+	for _, obu := range tu {
+		typ, payload, err := av1OBUParse(obu)
+		if err != nil {
+			// temporal unit can't be parsed: route it as is
+			return tu
+		}
+
+		switch typ {
+		case av1OBUTypeSequenceHeader:
+			t.updateSequenceHeader(obu)
+
+		case av1OBUTypeFrameHeader, av1OBUTypeFrame:
+			if !frameFound {
+				frameFound = true
+				isKeyFrame = av1FrameIsKey(payload, t.reducedStillPictureHeader)
+			}
+		}
+	}
+
+	// temporal unit doesn't contain frames: route it as is
+	if !frameFound {
+		return tu
+	}
+
+	filteredOBUs := make([][]byte, 0, len(tu)+1)
+
+	if isKeyFrame && t.sequenceHeader != nil {
+		filteredOBUs = append(filteredOBUs, t.sequenceHeader)
+	}
+
+	for _, obu := range tu {
+		typ, _, _ := av1OBUParse(obu)
+
+		switch typ {
+		case av1OBUTypeSequenceHeader, av1OBUTypeTemporalDelimiter:
+			continue
+		}
+
+		filteredOBUs = append(filteredOBUs, obu)
+	}
+
+	return filteredOBUs
+}
+
 func (t *av1) ProcessUnit(uu unit.Unit) error { //nolint:dupl
 	u := uu.(*unit.AV1)
 
+	u.TU = t.remuxTemporalUnit(u.TU)
+
 	pkts, err := t.encoder.Encode(u.TU)
 	if err != nil {
 		return err
@@ -85,17 +234,26 @@ func (t *av1) ProcessRTPPacket( //nolint:dupl
 		},
 	}
 
-	// remove padding
-	pkt.Header.Padding = false
-	pkt.PaddingSize = 0
+	if t.encoder == nil {
+		// remove padding
+		pkt.Header.Padding = false
+		pkt.PaddingSize = 0
+
+		// RTP packets exceed maximum size: start re-encoding them
+		if pkt.MarshalSize() > t.UDPMaxPayloadSize {
+			t.Parent.Log(logger.Info, "RTP packets are too big, remuxing them into smaller ones")
 
-	if pkt.MarshalSize() > t.UDPMaxPayloadSize {
-		return nil, fmt.Errorf("payload size (%d) is greater than maximum allowed (%d)",
-			pkt.MarshalSize(), t.UDPMaxPayloadSize)
+			v1 := pkt.SSRC
+			v2 := pkt.SequenceNumber
+			err := t.createEncoder(&v1, &v2)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	// decode from RTP
-	if hasNonRTSPReaders || t.decoder != nil {
+	if hasNonRTSPReaders || t.decoder != nil || t.encoder != nil {
 		if t.decoder == nil {
 			var err error
 			t.decoder, err = t.Format.CreateDecoder()
@@ -105,6 +263,11 @@ func (t *av1) ProcessRTPPacket( //nolint:dupl
 		}
 
 		tu, err := t.decoder.Decode(pkt)
+
+		if t.encoder != nil {
+			u.RTPPackets = nil
+		}
+
 		if err != nil {
 			if errors.Is(err, rtpav1.ErrNonStartingPacketAndNoPrevious) ||
 				errors.Is(err, rtpav1.ErrMorePacketsNeeded) {
@@ -113,9 +276,26 @@ func (t *av1) ProcessRTPPacket( //nolint:dupl
 			return nil, err
 		}
 
-		u.TU = tu
+		u.TU = t.remuxTemporalUnit(tu)
 	}
 
 	// route packet as is
+	if t.encoder == nil {
+		return u, nil
+	}
+
+	// encode into RTP
+	if len(u.TU) != 0 {
+		pkts, err := t.encoder.Encode(u.TU)
+		if err != nil {
+			return nil, err
+		}
+		u.RTPPackets = pkts
+
+		for _, newPKT := range u.RTPPackets {
+			newPKT.Timestamp = pkt.Timestamp
+		}
+	}
+
 	return u, nil
 }
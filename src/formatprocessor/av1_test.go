@@ -0,0 +1,105 @@
+package formatprocessor
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpav1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+func TestAV1RemuxTemporalUnit(t *testing.T) {
+	forma := &format.AV1{
+		PayloadTyp: 96,
+	}
+
+	p, err := New(1472, forma, false, nil)
+	require.NoError(t, err)
+
+	enc, err := forma.CreateEncoder()
+	require.NoError(t, err)
+
+	keyFrame := []byte{byte(av1OBUTypeFrame << 3), 0b00010000, 1, 2}
+	interFrame := []byte{byte(av1OBUTypeFrame << 3), 0b00110000, 3, 4}
+
+	for _, ca := range []struct {
+		name string
+		in   [][]byte
+		out  [][]byte
+	}{
+		{
+			"key frame with sequence header",
+			[][]byte{AV1DefaultSequenceHeader, keyFrame},
+			[][]byte{AV1DefaultSequenceHeader, keyFrame},
+		},
+		{
+			"key frame without sequence header",
+			[][]byte{keyFrame},
+			[][]byte{AV1DefaultSequenceHeader, keyFrame},
+		},
+		{
+			"non-key frame with sequence header",
+			[][]byte{AV1DefaultSequenceHeader, interFrame},
+			[][]byte{interFrame},
+		},
+		{
+			"non-key frame",
+			[][]byte{interFrame},
+			[][]byte{interFrame},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			pkts, err := enc.Encode(ca.in)
+			require.NoError(t, err)
+
+			data, err := p.ProcessRTPPacket(pkts[0], time.Time{}, 0, true)
+			require.NoError(t, err)
+
+			require.Equal(t, ca.out, data.(*unit.AV1).TU)
+		})
+	}
+}
+
+func TestAV1OversizedPackets(t *testing.T) {
+	forma := &format.AV1{
+		PayloadTyp: 96,
+	}
+
+	logged := false
+
+	p, err := New(1472, forma, false,
+		Logger(func(_ logger.Level, s string, i ...interface{}) {
+			require.Equal(t, "RTP packets are too big, remuxing them into smaller ones", fmt.Sprintf(s, i...))
+			logged = true
+		}))
+	require.NoError(t, err)
+
+	enc := &rtpav1.Encoder{
+		PayloadMaxSize: 2000,
+		PayloadType:    96,
+	}
+	err = enc.Init()
+	require.NoError(t, err)
+
+	frame := append([]byte{byte(av1OBUTypeFrame << 3), 0b00110000}, bytes.Repeat([]byte{1}, 1500)...)
+
+	pkts, err := enc.Encode([][]byte{frame})
+	require.NoError(t, err)
+	require.Len(t, pkts, 1)
+
+	data, err := p.ProcessRTPPacket(pkts[0], time.Time{}, 0, false)
+	require.NoError(t, err)
+
+	require.True(t, logged)
+	require.Greater(t, len(data.GetRTPPackets()), 1)
+
+	for _, pkt := range data.GetRTPPackets() {
+		require.LessOrEqual(t, pkt.MarshalSize(), 1472)
+	}
+}
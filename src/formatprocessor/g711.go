@@ -6,6 +6,7 @@ import (
 
 	"github.com/bluenviron/gortsplib/v4/pkg/format"
 	"github.com/bluenviron/gortsplib/v4/pkg/format/rtplpcm"
+	mcg711 "github.com/bluenviron/mediacommon/v2/pkg/codecs/g711"
 	"github.com/pion/rtp"
 
 	"github.com/flynnletford/mediamtx/src/logger"
@@ -16,6 +17,7 @@ type g711 struct {
 	UDPMaxPayloadSize  int
 	Format             *format.G711
 	GenerateRTPPackets bool
+	DecodeToLPCM       bool
 	Parent             logger.Writer
 
 	encoder     *rtplpcm.Encoder
@@ -49,6 +51,67 @@ func (t *g711) createEncoder() error {
 	return t.encoder.Init()
 }
 
+// decode tables of G711, that map every possible sample to a 16-bit big-endian LPCM sample.
+var (
+	g711MulawTable = g711DecodeTable(func(samples []byte) []byte {
+		var mu mcg711.Mulaw
+		mu.Unmarshal(samples)
+		return mu
+	})
+	g711AlawTable = g711DecodeTable(func(samples []byte) []byte {
+		var al mcg711.Alaw
+		al.Unmarshal(samples)
+		return al
+	})
+)
+
+func g711DecodeTable(decode func([]byte) []byte) *[256][2]byte {
+	samples := make([]byte, 256)
+	for i := range samples {
+		samples[i] = byte(i)
+	}
+
+	lpcm := decode(samples)
+
+	var table [256][2]byte
+	for i := range table {
+		table[i] = [2]byte{lpcm[i*2], lpcm[i*2+1]}
+	}
+	return &table
+}
+
+func decodeG711To(out []byte, forma *format.G711, samples []byte) {
+	table := g711AlawTable
+	if forma.MULaw {
+		table = g711MulawTable
+	}
+
+	for i, sample := range samples {
+		out[i*2] = table[sample][0]
+		out[i*2+1] = table[sample][1]
+	}
+}
+
+// DecodeG711ToLPCM decodes G711 samples into 16-bit big-endian LPCM.
+func DecodeG711ToLPCM(forma *format.G711, samples []byte) []byte {
+	out := make([]byte, len(samples)*2)
+	decodeG711To(out, forma, samples)
+	return out
+}
+
+// decodeLPCM decodes samples into LPCM.
+// When the unit has no payload, LPCM is stored into a pooled payload that is assigned to the unit.
+func (t *g711) decodeLPCM(u *unit.Base, samples []byte) []byte {
+	if u.Payload != nil {
+		return DecodeG711ToLPCM(t.Format, samples)
+	}
+
+	u.Payload = unit.NewPayload(len(samples) * 2)
+	lpcm := u.Payload.Bytes()
+	decodeG711To(lpcm, t.Format, samples)
+	return lpcm
+}
+
 func (t *g711) ProcessUnit(uu unit.Unit) error { //nolint:dupl
 	u := uu.(*unit.G711)
 
@@ -58,6 +121,10 @@ func (t *g711) ProcessUnit(uu unit.Unit) error { //nolint:dupl
 	}
 	u.RTPPackets = pkts
 
+	if t.DecodeToLPCM {
+		u.LPCM = t.decodeLPCM(&u.Base, u.Samples)
+	}
+
 	for _, pkt := range u.RTPPackets {
 		pkt.Timestamp += t.randomStart + uint32(u.PTS)
 	}
@@ -104,6 +171,10 @@ func (t *g711) ProcessRTPPacket( //nolint:dupl
 		}
 
 		u.Samples = samples
+
+		if t.DecodeToLPCM {
+			u.LPCM = t.decodeLPCM(&u.Base, samples)
+		}
 	}
 
 	// route packet as is
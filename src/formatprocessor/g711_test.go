@@ -68,3 +68,36 @@ func TestG711Encode(t *testing.T) {
 		}}, unit.RTPPackets)
 	})
 }
+
+func TestG711DecodeToLPCM(t *testing.T) {
+	for _, ca := range []struct {
+		name  string
+		mulaw bool
+		lpcm  []byte
+	}{
+		{"alaw", false, []byte{0xea, 0x80, 0x15, 0x80}},
+		{"mulaw", true, []byte{0x82, 0x84, 0x7d, 0x7c}},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			forma := &format.G711{
+				PayloadTyp:   8,
+				MULaw:        ca.mulaw,
+				SampleRate:   8000,
+				ChannelCount: 1,
+			}
+
+			p, err := NewWithOptions(1472, forma, true, Options{DecodeToLPCM: true}, nil)
+			require.NoError(t, err)
+
+			unit := &unit.G711{
+				Samples: []byte{0x00, 0x80},
+			}
+
+			err = p.ProcessUnit(unit)
+			require.NoError(t, err)
+			require.Equal(t, ca.lpcm, unit.LPCM)
+			require.NotNil(t, unit.Payload)
+			require.Equal(t, ca.lpcm, unit.Payload.Bytes())
+		})
+	}
+}
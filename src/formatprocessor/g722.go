@@ -0,0 +1,135 @@
+package formatprocessor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpsimpleaudio"
+	"github.com/pion/rtp"
+
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+type g722 struct {
+	UDPMaxPayloadSize  int
+	Format             *format.G722
+	GenerateRTPPackets bool
+	DecodeToLPCM       bool
+	Parent             logger.Writer
+
+	encoder     *rtpsimpleaudio.Encoder
+	decoder     *rtpsimpleaudio.Decoder
+	lpcmDecoder *G722Decoder
+	randomStart uint32
+}
+
+func (t *g722) initialize() error {
+	if t.GenerateRTPPackets {
+		err := t.createEncoder()
+		if err != nil {
+			return err
+		}
+
+		t.randomStart, err = randUint32()
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.DecodeToLPCM {
+		t.lpcmDecoder = &G722Decoder{}
+		t.lpcmDecoder.Initialize()
+	}
+
+	return nil
+}
+
+func (t *g722) createEncoder() error {
+	t.encoder = &rtpsimpleaudio.Encoder{
+		PayloadMaxSize: t.UDPMaxPayloadSize - 12,
+		PayloadType:    t.Format.PayloadType(),
+	}
+	return t.encoder.Init()
+}
+
+func (t *g722) ProcessUnit(uu unit.Unit) error { //nolint:dupl
+	u := uu.(*unit.G722)
+
+	pkt, err := t.encoder.Encode(u.Frame)
+	if err != nil {
+		return err
+	}
+
+	pkt.Timestamp += t.randomStart + uint32(u.PTS)
+	u.RTPPackets = []*rtp.Packet{pkt}
+
+	if t.lpcmDecoder != nil {
+		u.LPCM = t.decodeLPCM(&u.Base, u.Frame)
+	}
+
+	return nil
+}
+
+func (t *g722) ProcessRTPPacket( //nolint:dupl
+	pkt *rtp.Packet,
+	ntp time.Time,
+	pts int64,
+	hasNonRTSPReaders bool,
+) (unit.Unit, error) {
+	u := &unit.G722{
+		Base: unit.Base{
+			RTPPackets: []*rtp.Packet{pkt},
+			NTP:        ntp,
+			PTS:        pts,
+		},
+	}
+
+	// remove padding
+	pkt.Header.Padding = false
+	pkt.PaddingSize = 0
+
+	if pkt.MarshalSize() > t.UDPMaxPayloadSize {
+		return nil, fmt.Errorf("payload size (%d) is greater than maximum allowed (%d)",
+			pkt.MarshalSize(), t.UDPMaxPayloadSize)
+	}
+
+	// decode from RTP
+	if hasNonRTSPReaders || t.decoder != nil {
+		if t.decoder == nil {
+			var err error
+			t.decoder, err = t.Format.CreateDecoder()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		frame, err := t.decoder.Decode(pkt)
+		if err != nil {
+			return nil, err
+		}
+
+		u.Frame = frame
+
+		if t.lpcmDecoder != nil {
+			u.LPCM = t.decodeLPCM(&u.Base, frame)
+		}
+	}
+
+	// route packet as is
+	return u, nil
+}
+
+// decodeLPCM decodes a frame into LPCM.
+// When the unit has no payload, LPCM is stored into a pooled payload that is assigned to the unit.
+func (t *g722) decodeLPCM(u *unit.Base, frame []byte) []byte {
+	if u.Payload != nil {
+		return t.lpcmDecoder.Decode(frame)
+	}
+
+	u.Payload = unit.NewPayload(len(frame) * 4)
+	lpcm := u.Payload.Bytes()
+	t.lpcmDecoder.decodeTo(lpcm, frame)
+	return lpcm
+}
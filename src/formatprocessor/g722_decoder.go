@@ -0,0 +1,254 @@
+package formatprocessor
+
+// G722 decoder, operating at 64 kbit/s.
+// Specification: ITU-T G.722
+
+var (
+	g722WL   = [8]int{-60, -30, 58, 172, 334, 538, 1198, 3042}
+	g722RL42 = [16]int{0, 7, 6, 5, 4, 3, 2, 1, 7, 6, 5, 4, 3, 2, 1, 0}
+	g722ILB  = [32]int{
+		2048, 2093, 2139, 2186, 2233, 2282, 2332, 2383,
+		2435, 2489, 2543, 2599, 2656, 2714, 2774, 2834,
+		2896, 2960, 3025, 3091, 3158, 3228, 3298, 3371,
+		3444, 3520, 3597, 3676, 3756, 3838, 3922, 4008,
+	}
+	g722WH  = [3]int{0, -214, 798}
+	g722RH2 = [4]int{2, 1, 2, 1}
+	g722QM2 = [4]int{-7408, -1616, 7408, 1616}
+	g722QM4 = [16]int{
+		0, -20456, -12896, -8968, -6288, -4240, -2584, -1200,
+		20456, 12896, 8968, 6288, 4240, 2584, 1200, 0,
+	}
+	g722QM6 = [64]int{
+		-136, -136, -136, -136, -24808, -21904, -19008, -16704,
+		-14984, -13512, -12280, -11192, -10232, -9360, -8576, -7856,
+		-7192, -6576, -6000, -5456, -4944, -4464, -4008, -3576,
+		-3168, -2776, -2400, -2032, -1688, -1360, -1040, -728,
+		24808, 21904, 19008, 16704, 14984, 13512, 12280, 11192,
+		10232, 9360, 8576, 7856, 7192, 6576, 6000, 5456,
+		4944, 4464, 4008, 3576, 3168, 2776, 2400, 2032,
+		1688, 1360, 1040, 728, 432, 136, -432, -136,
+	}
+	g722QMFCoeffs = [12]int{3, -11, 12, 32, -210, 951, 3876, -805, 362, -156, 53, -11}
+)
+
+func g722Saturate(v int) int {
+	if v > 32767 {
+		return 32767
+	}
+	if v < -32768 {
+		return -32768
+	}
+	return v
+}
+
+func g722Clamp(v int, lo int, hi int) int {
+	if v > hi {
+		return hi
+	}
+	if v < lo {
+		return lo
+	}
+	return v
+}
+
+// g722Band is the state of the adaptive predictor of a sub-band.
+type g722Band struct {
+	s   int
+	sp  int
+	sz  int
+	r   [3]int
+	a   [3]int
+	ap  [3]int
+	p   [3]int
+	d   [7]int
+	b   [7]int
+	bp  [7]int
+	sg  [7]int
+	nb  int
+	det int
+}
+
+// update updates the predictor with a quantized difference signal.
+func (b *g722Band) update(d int) {
+	// RECONS
+	b.d[0] = d
+	b.r[0] = g722Saturate(b.s + d)
+
+	// PARREC
+	b.p[0] = g722Saturate(b.sz + d)
+
+	// UPPOL2
+	for i := 0; i < 3; i++ {
+		b.sg[i] = b.p[i] >> 15
+	}
+
+	wd1 := g722Saturate(b.a[1] << 2)
+
+	wd2 := wd1
+	if b.sg[0] == b.sg[1] {
+		wd2 = -wd1
+	}
+	if wd2 > 32767 {
+		wd2 = 32767
+	}
+
+	wd3 := wd2 >> 7
+	if b.sg[0] == b.sg[2] {
+		wd3 += 128
+	} else {
+		wd3 -= 128
+	}
+	wd3 += (b.a[2] * 32512) >> 15
+	b.ap[2] = g722Clamp(wd3, -12288, 12288)
+
+	// UPPOL1
+	b.sg[0] = b.p[0] >> 15
+	b.sg[1] = b.p[1] >> 15
+
+	wd1 = -192
+	if b.sg[0] == b.sg[1] {
+		wd1 = 192
+	}
+	wd2 = (b.a[1] * 32640) >> 15
+	b.ap[1] = g722Saturate(wd1 + wd2)
+
+	wd3 = g722Saturate(15360 - b.ap[2])
+	b.ap[1] = g722Clamp(b.ap[1], -wd3, wd3)
+
+	// UPZERO
+	wd1 = 0
+	if d != 0 {
+		wd1 = 128
+	}
+
+	b.sg[0] = d >> 15
+
+	for i := 1; i < 7; i++ {
+		b.sg[i] = b.d[i] >> 15
+
+		wd2 = -wd1
+		if b.sg[i] == b.sg[0] {
+			wd2 = wd1
+		}
+
+		wd3 = (b.b[i] * 32640) >> 15
+		b.bp[i] = g722Saturate(wd2 + wd3)
+	}
+
+	// DELAYA
+	for i := 6; i > 0; i-- {
+		b.d[i] = b.d[i-1]
+		b.b[i] = b.bp[i]
+	}
+
+	for i := 2; i > 0; i-- {
+		b.r[i] = b.r[i-1]
+		b.p[i] = b.p[i-1]
+		b.a[i] = b.ap[i]
+	}
+
+	// FILTEP
+	wd1 = g722Saturate(b.r[1] + b.r[1])
+	wd1 = (b.a[1] * wd1) >> 15
+	wd2 = g722Saturate(b.r[2] + b.r[2])
+	wd2 = (b.a[2] * wd2) >> 15
+	b.sp = g722Saturate(wd1 + wd2)
+
+	// FILTEZ
+	b.sz = 0
+	for i := 6; i > 0; i-- {
+		wd1 = g722Saturate(b.d[i] + b.d[i])
+		b.sz += (b.b[i] * wd1) >> 15
+	}
+	b.sz = g722Saturate(b.sz)
+
+	// PREDIC
+	b.s = g722Saturate(b.sp + b.sz)
+}
+
+// updateScale updates the scale factor of a sub-band.
+func (b *g722Band) updateScale(wl int, max int, shift int) {
+	// LOGSCL, LOGSCH
+	b.nb = g722Clamp(((b.nb*127)>>7)+wl, 0, max)
+
+	// SCALEL, SCALEH
+	wd1 := (b.nb >> 6) & 31
+	wd2 := shift - (b.nb >> 11)
+
+	var wd3 int
+	if wd2 < 0 {
+		wd3 = g722ILB[wd1] << -wd2
+	} else {
+		wd3 = g722ILB[wd1] >> wd2
+	}
+
+	b.det = wd3 << 2
+}
+
+// G722Decoder decodes G722 into 16-bit LPCM with a sample rate of 16khz.
+// It keeps state between frames, therefore frames must be decoded in order.
+type G722Decoder struct {
+	band [2]g722Band
+	x    [24]int
+}
+
+// Initialize initializes G722Decoder.
+func (d *G722Decoder) Initialize() {
+	d.band[0].det = 32
+	d.band[1].det = 8
+}
+
+// Decode decodes a frame into big-endian samples.
+// Every byte of the frame is decoded into two samples.
+func (d *G722Decoder) Decode(frame []byte) []byte {
+	out := make([]byte, len(frame)*4)
+	d.decodeTo(out, frame)
+	return out
+}
+
+// decodeTo decodes a frame into out, that must be 4 times the size of the frame.
+func (d *G722Decoder) decodeTo(out []byte, frame []byte) {
+	for j, code := range frame {
+		ilow := int(code & 0x3F)
+		ihigh := int(code>>6) & 0x03
+
+		// low band: INVQBL
+		rlow := g722Clamp(d.band[0].s+((d.band[0].det*g722QM6[ilow])>>15), -16384, 16383)
+
+		// low band: INVQAL
+		ilow >>= 2
+		dlow := (d.band[0].det * g722QM4[ilow]) >> 15
+
+		d.band[0].updateScale(g722WL[g722RL42[ilow]], 18432, 8)
+		d.band[0].update(dlow)
+
+		// high band: INVQAH
+		dhigh := (d.band[1].det * g722QM2[ihigh]) >> 15
+		rhigh := g722Clamp(dhigh+d.band[1].s, -16384, 16383)
+
+		d.band[1].updateScale(g722WH[g722RH2[ihigh]], 22528, 10)
+		d.band[1].update(dhigh)
+
+		// receive QMF
+		copy(d.x[:], d.x[2:])
+		d.x[22] = rlow + rhigh
+		d.x[23] = rlow - rhigh
+
+		xout1 := 0
+		xout2 := 0
+
+		for i := 0; i < 12; i++ {
+			xout2 += d.x[2*i] * g722QMFCoeffs[i]
+			xout1 += d.x[2*i+1] * g722QMFCoeffs[11-i]
+		}
+
+		s1 := uint16(g722Saturate(xout1 >> 11))
+		s2 := uint16(g722Saturate(xout2 >> 11))
+
+		out[j*4] = byte(s1 >> 8)
+		out[j*4+1] = byte(s1)
+		out[j*4+2] = byte(s2 >> 8)
+		out[j*4+3] = byte(s2)
+	}
+}
@@ -0,0 +1,56 @@
+package formatprocessor
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+// 10ms of a 1khz sine wave with an amplitude of 10000, encoded with G722 at 64 kbit/s.
+var g722SineFrame = []byte{
+	0xfa, 0x92, 0x23, 0x8d, 0x23, 0x93, 0xa0, 0xa0, 0x20, 0xb0, 0xca, 0xc8, 0xce, 0xdc, 0xed, 0x69,
+	0xeb, 0xfa, 0xd1, 0xce, 0xd1, 0xdf, 0xee, 0xea, 0xee, 0xfc, 0xd3, 0x8f, 0xd2, 0xdf, 0xf0, 0x6b,
+	0xf0, 0xbc, 0xd4, 0x90, 0xd3, 0xfe, 0xb0, 0xec, 0x70, 0xfc, 0x95, 0xd0, 0xd4, 0xbe, 0xf1, 0xed,
+	0xf1, 0x7d, 0xd5, 0x92, 0xd6, 0xff, 0xb2, 0xef, 0xf3, 0xff, 0x56, 0x94, 0xd6, 0xfd, 0xb2, 0xef,
+	0xf3, 0xfe, 0xd7, 0xd5, 0xd7, 0xfc, 0xf2, 0xf0, 0xb2, 0xfe, 0xd6, 0x94, 0xd6, 0xfc, 0xb2, 0xf0,
+}
+
+func TestG722DecodeToLPCM(t *testing.T) {
+	forma := &format.G722{}
+
+	p, err := NewWithOptions(1472, forma, true, Options{DecodeToLPCM: true}, nil)
+	require.NoError(t, err)
+
+	u := &unit.G722{
+		Frame: g722SineFrame,
+	}
+
+	err = p.ProcessUnit(u)
+	require.NoError(t, err)
+	require.Equal(t, g722SineFrame, u.RTPPackets[0].Payload)
+
+	// every byte is decoded into two 16-bit samples
+	require.Len(t, u.LPCM, len(g722SineFrame)*4)
+
+	// the decoded signal follows the original one, with the delay of the codec.
+	for i := 100; i < len(u.LPCM)/2; i++ {
+		v := int(int16(binary.BigEndian.Uint16(u.LPCM[i*2:])))
+		expected := int(10000 * math.Sin(2*math.Pi*1000*float64(i-54)/16000))
+		require.InDelta(t, expected, v, 500)
+	}
+
+	// decoding from RTP
+	p, err = NewWithOptions(1472, forma, false, Options{DecodeToLPCM: true}, nil)
+	require.NoError(t, err)
+
+	uu, err := p.ProcessRTPPacket(u.RTPPackets[0], time.Time{}, 0, true)
+	require.NoError(t, err)
+	require.Equal(t, g722SineFrame, uu.(*unit.G722).Frame)
+	require.Equal(t, u.LPCM, uu.(*unit.G722).LPCM)
+}
@@ -3,6 +3,7 @@ package formatprocessor
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/bluenviron/gortsplib/v4/pkg/format"
@@ -83,17 +84,44 @@ func rtpH264ExtractParams(payload []byte) ([]byte, []byte) {
 }
 
 type h264 struct {
-	UDPMaxPayloadSize  int
-	Format             *format.H264
-	GenerateRTPPackets bool
-	Parent             logger.Writer
+	UDPMaxPayloadSize   int
+	Format              *format.H264
+	GenerateRTPPackets  bool
+	InjectParameterSets bool
+	OnParametersChange  func()
+	DropMalformed       bool
+	OnMalformed         func(error)
+	Parent              logger.Writer
 
 	encoder     *rtph264.Encoder
 	decoder     *rtph264.Decoder
 	randomStart uint32
+	malformed   *malformedFilter
+	sps         []byte
+	spsParsed   *mch264.SPS
 }
 
 func (t *h264) initialize() error {
+	if t.DropMalformed {
+		t.malformed = &malformedFilter{
+			isMalformedNALU: func(nalu []byte) bool {
+				// forbidden_zero_bit must be zero
+				return len(nalu) == 0 || (nalu[0]&0x80) != 0
+			},
+			isRandomAccess: mch264.IsRandomAccess,
+			newDTSExtractor: func() dtsExtractor {
+				d := &mch264.DTSExtractor{}
+				d.Initialize()
+				return d
+			},
+			onMalformed: func(err error) {
+				if t.OnMalformed != nil {
+					t.OnMalformed(err)
+				}
+			},
+		}
+	}
+
 	if t.GenerateRTPPackets {
 		err := t.createEncoder(nil, nil)
 		if err != nil {
@@ -135,6 +163,10 @@ func (t *h264) updateTrackParametersFromRTPPacket(payload []byte) {
 			pps = t.Format.PPS
 		}
 		t.Format.SafeSetParams(sps, pps)
+
+		if t.OnParametersChange != nil {
+			t.OnParametersChange()
+		}
 	}
 }
 
@@ -163,6 +195,10 @@ func (t *h264) updateTrackParametersFromAU(au [][]byte) {
 
 	if update {
 		t.Format.SafeSetParams(sps, pps)
+
+		if t.OnParametersChange != nil {
+			t.OnParametersChange()
+		}
 	}
 }
 
@@ -224,12 +260,46 @@ func (t *h264) remuxAccessUnit(au [][]byte) [][]byte {
 	return filteredNALUs
 }
 
+// parseSEI extracts metadata from SEI NALUs.
+// Malformed SEI messages are not fatal, therefore they are discarded.
+func (t *h264) parseSEI(au [][]byte) *unit.SEI {
+	// picture timing messages depend on the SPS
+	if !bytes.Equal(t.sps, t.Format.SPS) {
+		t.sps = t.Format.SPS
+		t.spsParsed = nil
+
+		if t.sps != nil {
+			var sps mch264.SPS
+			if err := sps.Unmarshal(t.sps); err == nil {
+				t.spsParsed = &sps
+			}
+		}
+	}
+
+	sei, err := h264ParseSEI(au, t.spsParsed)
+	if err != nil {
+		return nil
+	}
+
+	return sei
+}
+
 func (t *h264) ProcessUnit(uu unit.Unit) error {
 	u := uu.(*unit.H264)
 
+	if t.malformed != nil {
+		u.AU = t.malformed.filterNALUs(u.AU)
+	}
+
 	t.updateTrackParametersFromAU(u.AU)
 	u.AU = t.remuxAccessUnit(u.AU)
 
+	if t.malformed != nil {
+		u.AU = t.malformed.filterAccessUnit(u.AU, u.PTS)
+	}
+
+	u.SEI = t.parseSEI(u.AU)
+
 	if u.AU != nil {
 		pkts, err := t.encoder.Encode(u.AU)
 		if err != nil {
@@ -266,10 +336,21 @@ func (t *h264) ProcessRTPPacket( //nolint:dupl
 		pkt.Header.Padding = false
 		pkt.PaddingSize = 0
 
+		createEncoder := false
+
+		switch {
+		// parameters are prepended to key frames by remuxAccessUnit(): re-encode packets in order to send them
+		case t.InjectParameterSets:
+			t.Parent.Log(logger.Info, "injecting parameter sets into key frames, remuxing RTP packets")
+			createEncoder = true
+
 		// RTP packets exceed maximum size: start re-encoding them
-		if pkt.MarshalSize() > t.UDPMaxPayloadSize {
+		case pkt.MarshalSize() > t.UDPMaxPayloadSize:
 			t.Parent.Log(logger.Info, "RTP packets are too big, remuxing them into smaller ones")
+			createEncoder = true
+		}
 
+		if createEncoder {
 			v1 := pkt.SSRC
 			v2 := pkt.SequenceNumber
 			err := t.createEncoder(&v1, &v2)
@@ -300,10 +381,26 @@ func (t *h264) ProcessRTPPacket( //nolint:dupl
 				errors.Is(err, rtph264.ErrMorePacketsNeeded) {
 				return u, nil
 			}
+
+			if t.malformed != nil {
+				t.malformed.reset(fmt.Errorf("dropped malformed access unit: %w", err))
+				return u, nil
+			}
+
 			return nil, err
 		}
 
+		if t.malformed != nil {
+			au = t.malformed.filterNALUs(au)
+		}
+
 		u.AU = t.remuxAccessUnit(au)
+
+		if t.malformed != nil {
+			u.AU = t.malformed.filterAccessUnit(u.AU, pts)
+		}
+
+		u.SEI = t.parseSEI(u.AU)
 	}
 
 	// route packet as is
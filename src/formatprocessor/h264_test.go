@@ -201,6 +201,118 @@ func TestH264OversizedPackets(t *testing.T) {
 	require.True(t, logged)
 }
 
+func TestH264InjectParameterSets(t *testing.T) {
+	forma := &format.H264{
+		PayloadTyp:        96,
+		SPS:               []byte{0x07, 0x01, 0x02, 0x03},
+		PPS:               []byte{0x08, 0x01, 0x02},
+		PacketizationMode: 1,
+	}
+
+	p, err := NewWithOptions(1472, forma, false, Options{InjectParameterSets: true},
+		Logger(func(_ logger.Level, s string, i ...interface{}) {
+			require.Equal(t, "injecting parameter sets into key frames, remuxing RTP packets", fmt.Sprintf(s, i...))
+		}))
+	require.NoError(t, err)
+
+	data, err := p.ProcessRTPPacket(&rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         true,
+			PayloadType:    96,
+			SequenceNumber: 123,
+			Timestamp:      45343,
+			SSRC:           563423,
+		},
+		Payload: []byte{0x05, 0x01, 0x02},
+	}, time.Time{}, 0, false)
+	require.NoError(t, err)
+
+	require.Equal(t, []*rtp.Packet{{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         true,
+			PayloadType:    96,
+			SequenceNumber: 123,
+			Timestamp:      45343,
+			SSRC:           563423,
+		},
+		Payload: []byte{
+			0x18, 0x00, 0x04, 0x07, 0x01, 0x02, 0x03, 0x00,
+			0x03, 0x08, 0x01, 0x02, 0x00, 0x03, 0x05, 0x01,
+			0x02,
+		},
+	}}, data.GetRTPPackets())
+}
+
+func TestH264DropMalformed(t *testing.T) {
+	forma := &format.H264{
+		PayloadTyp:        96,
+		SPS:               H264DefaultSPS,
+		PPS:               H264DefaultPPS,
+		PacketizationMode: 1,
+	}
+
+	var errs []string
+
+	p, err := NewWithOptions(1472, forma, true, Options{
+		DropMalformed: true,
+		OnMalformed: func(err error) {
+			errs = append(errs, err.Error())
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	for _, ca := range []struct {
+		au  [][]byte
+		pts int64
+		out [][]byte
+	}{
+		{
+			[][]byte{{}, {0x85, 0x01}, {0x65, 0x01}},
+			0,
+			[][]byte{H264DefaultSPS, H264DefaultPPS, {0x65, 0x01}},
+		},
+		{
+			[][]byte{{0x41, 0x01}},
+			3000,
+			[][]byte{{0x41, 0x01}},
+		},
+		{
+			[][]byte{{0x41, 0x02}},
+			1000,
+			nil,
+		},
+		{
+			[][]byte{{0x41, 0x03}},
+			6000,
+			nil,
+		},
+		{
+			[][]byte{{0x65, 0x02}},
+			9000,
+			[][]byte{H264DefaultSPS, H264DefaultPPS, {0x65, 0x02}},
+		},
+	} {
+		u := &unit.H264{
+			Base: unit.Base{
+				PTS: ca.pts,
+			},
+			AU: ca.au,
+		}
+
+		err = p.ProcessUnit(u)
+		require.NoError(t, err)
+		require.Equal(t, ca.out, u.AU)
+	}
+
+	require.Equal(t, []string{
+		"dropped 2 malformed NAL units",
+		"dropped malformed access unit: DTS is not monotonically increasing, was 3000, now is 1000",
+		"access unit depends on a dropped access unit",
+	}, errs)
+}
+
 func TestH264EmptyPacket(t *testing.T) {
 	forma := &format.H264{
 		PayloadTyp:        96,
@@ -3,6 +3,7 @@ package formatprocessor
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/bluenviron/gortsplib/v4/pkg/format"
@@ -103,17 +104,42 @@ func rtpH265ExtractParams(payload []byte) ([]byte, []byte, []byte) {
 }
 
 type h265 struct {
-	UDPMaxPayloadSize  int
-	Format             *format.H265
-	GenerateRTPPackets bool
-	Parent             logger.Writer
+	UDPMaxPayloadSize   int
+	Format              *format.H265
+	GenerateRTPPackets  bool
+	InjectParameterSets bool
+	OnParametersChange  func()
+	DropMalformed       bool
+	OnMalformed         func(error)
+	Parent              logger.Writer
 
 	encoder     *rtph265.Encoder
 	decoder     *rtph265.Decoder
 	randomStart uint32
+	malformed   *malformedFilter
 }
 
 func (t *h265) initialize() error {
+	if t.DropMalformed {
+		t.malformed = &malformedFilter{
+			isMalformedNALU: func(nalu []byte) bool {
+				// forbidden_zero_bit must be zero
+				return len(nalu) < 2 || (nalu[0]&0x80) != 0
+			},
+			isRandomAccess: mch265.IsRandomAccess,
+			newDTSExtractor: func() dtsExtractor {
+				d := &mch265.DTSExtractor{}
+				d.Initialize()
+				return d
+			},
+			onMalformed: func(err error) {
+				if t.OnMalformed != nil {
+					t.OnMalformed(err)
+				}
+			},
+		}
+	}
+
 	if t.GenerateRTPPackets {
 		err := t.createEncoder(nil, nil)
 		if err != nil {
@@ -159,6 +185,10 @@ func (t *h265) updateTrackParametersFromRTPPacket(payload []byte) {
 			pps = t.Format.PPS
 		}
 		t.Format.SafeSetParams(vps, sps, pps)
+
+		if t.OnParametersChange != nil {
+			t.OnParametersChange()
+		}
 	}
 }
 
@@ -194,6 +224,10 @@ func (t *h265) updateTrackParametersFromAU(au [][]byte) {
 
 	if update {
 		t.Format.SafeSetParams(vps, sps, pps)
+
+		if t.OnParametersChange != nil {
+			t.OnParametersChange()
+		}
 	}
 }
 
@@ -256,12 +290,33 @@ func (t *h265) remuxAccessUnit(au [][]byte) [][]byte {
 	return filteredNALUs
 }
 
+// parseSEI extracts metadata from SEI NALUs.
+// Malformed SEI messages are not fatal, therefore they are discarded.
+func (t *h265) parseSEI(au [][]byte) *unit.SEI {
+	sei, err := h265ParseSEI(au)
+	if err != nil {
+		return nil
+	}
+
+	return sei
+}
+
 func (t *h265) ProcessUnit(uu unit.Unit) error { //nolint:dupl
 	u := uu.(*unit.H265)
 
+	if t.malformed != nil {
+		u.AU = t.malformed.filterNALUs(u.AU)
+	}
+
 	t.updateTrackParametersFromAU(u.AU)
 	u.AU = t.remuxAccessUnit(u.AU)
 
+	if t.malformed != nil {
+		u.AU = t.malformed.filterAccessUnit(u.AU, u.PTS)
+	}
+
+	u.SEI = t.parseSEI(u.AU)
+
 	if u.AU != nil {
 		pkts, err := t.encoder.Encode(u.AU)
 		if err != nil {
@@ -298,10 +353,21 @@ func (t *h265) ProcessRTPPacket( //nolint:dupl
 		pkt.Header.Padding = false
 		pkt.PaddingSize = 0
 
+		createEncoder := false
+
+		switch {
+		// parameters are prepended to key frames by remuxAccessUnit(): re-encode packets in order to send them
+		case t.InjectParameterSets:
+			t.Parent.Log(logger.Info, "injecting parameter sets into key frames, remuxing RTP packets")
+			createEncoder = true
+
 		// RTP packets exceed maximum size: start re-encoding them
-		if pkt.MarshalSize() > t.UDPMaxPayloadSize {
+		case pkt.MarshalSize() > t.UDPMaxPayloadSize:
 			t.Parent.Log(logger.Info, "RTP packets are too big, remuxing them into smaller ones")
+			createEncoder = true
+		}
 
+		if createEncoder {
 			v1 := pkt.SSRC
 			v2 := pkt.SequenceNumber
 			err := t.createEncoder(&v1, &v2)
@@ -332,10 +398,26 @@ func (t *h265) ProcessRTPPacket( //nolint:dupl
 				errors.Is(err, rtph265.ErrMorePacketsNeeded) {
 				return u, nil
 			}
+
+			if t.malformed != nil {
+				t.malformed.reset(fmt.Errorf("dropped malformed access unit: %w", err))
+				return u, nil
+			}
+
 			return nil, err
 		}
 
+		if t.malformed != nil {
+			au = t.malformed.filterNALUs(au)
+		}
+
 		u.AU = t.remuxAccessUnit(au)
+
+		if t.malformed != nil {
+			u.AU = t.malformed.filterAccessUnit(u.AU, pts)
+		}
+
+		u.SEI = t.parseSEI(u.AU)
 	}
 
 	// route packet as is
@@ -0,0 +1,318 @@
+package formatprocessor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtp"
+
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+// H266 NAL unit types.
+// Specification: ITU-T H.266, table 5
+const (
+	h266NALUTypeIDRWRADL = 7
+	h266NALUTypeIDRNLP   = 8
+	h266NALUTypeCRA      = 9
+	h266NALUTypeGDR      = 10
+	h266NALUTypeVPS      = 14
+	h266NALUTypeSPS      = 15
+	h266NALUTypePPS      = 16
+	h266NALUTypeAUD      = 20
+
+	// RFC 9328
+	h266NALUTypeAggregationUnit   = 28
+	h266NALUTypeFragmentationUnit = 29
+)
+
+// maximum size of a H266 access unit.
+const h266MaxAccessUnitSize = 8 * 1024 * 1024
+
+var (
+	errH266MorePacketsNeeded              = errors.New("need more packets")
+	errH266NonStartingPacketAndNoPrevious = errors.New(
+		"received a non-starting fragment without any previous starting fragment")
+)
+
+func h266NALUType(nalu []byte) uint8 {
+	return nalu[1] >> 3
+}
+
+// H266IsRandomAccess checks whether a H266 access unit can be randomly accessed.
+func H266IsRandomAccess(au [][]byte) bool {
+	for _, nalu := range au {
+		switch h266NALUType(nalu) {
+		case h266NALUTypeIDRWRADL, h266NALUTypeIDRNLP, h266NALUTypeCRA, h266NALUTypeGDR:
+			return true
+		}
+	}
+	return false
+}
+
+// IsH266 checks whether a format contains H266, as described in RFC 9328.
+func IsH266(forma format.Format) bool {
+	g, ok := forma.(*format.Generic)
+	if !ok {
+		return false
+	}
+
+	codec, _, _ := strings.Cut(g.RTPMa, "/")
+	return strings.ToLower(codec) == "h266"
+}
+
+// h266Decoder is a RTP/H266 decoder.
+// Specification: RFC 9328
+type h266Decoder struct {
+	fragments     [][]byte
+	fragmentsSize int
+	frameBuffer   [][]byte
+	frameSize     int
+}
+
+func (d *h266Decoder) decodeNALUs(pkt *rtp.Packet) ([][]byte, error) {
+	if len(pkt.Payload) < 3 {
+		d.fragments = d.fragments[:0]
+		return nil, fmt.Errorf("payload is too short")
+	}
+
+	typ := h266NALUType(pkt.Payload)
+
+	switch typ {
+	case h266NALUTypeAggregationUnit:
+		d.fragments = d.fragments[:0]
+
+		payload := pkt.Payload[2:]
+		var nalus [][]byte
+
+		for len(payload) > 0 {
+			if len(payload) < 2 {
+				return nil, fmt.Errorf("invalid aggregation unit (invalid size)")
+			}
+
+			size := int(uint16(payload[0])<<8 | uint16(payload[1]))
+			payload = payload[2:]
+
+			if size < 2 || size > len(payload) {
+				return nil, fmt.Errorf("invalid aggregation unit (invalid size)")
+			}
+
+			nalus = append(nalus, payload[:size])
+			payload = payload[size:]
+		}
+
+		if nalus == nil {
+			return nil, fmt.Errorf("aggregation unit doesn't contain any NALU")
+		}
+
+		return nalus, nil
+
+	case h266NALUTypeFragmentationUnit:
+		start := pkt.Payload[2] >> 7
+		end := (pkt.Payload[2] >> 6) & 0x01
+
+		if start == 1 {
+			d.fragments = d.fragments[:0]
+
+			if end != 0 {
+				return nil, fmt.Errorf("invalid fragmentation unit (can't contain both a start and end bit)")
+			}
+
+			fuType := pkt.Payload[2] & 0b11111
+			head := []byte{pkt.Payload[0], (fuType << 3) | (pkt.Payload[1] & 0b111)}
+			d.fragments = append(d.fragments, head, pkt.Payload[3:])
+			d.fragmentsSize = len(head) + len(pkt.Payload[3:])
+			return nil, errH266MorePacketsNeeded
+		}
+
+		if len(d.fragments) == 0 {
+			return nil, errH266NonStartingPacketAndNoPrevious
+		}
+
+		d.fragmentsSize += len(pkt.Payload[3:])
+		if d.fragmentsSize > h266MaxAccessUnitSize {
+			d.fragments = d.fragments[:0]
+			return nil, fmt.Errorf("NALU size (%d) is too big, maximum is %d", d.fragmentsSize, h266MaxAccessUnitSize)
+		}
+
+		d.fragments = append(d.fragments, pkt.Payload[3:])
+
+		if end != 1 {
+			return nil, errH266MorePacketsNeeded
+		}
+
+		nalu := bytes.Join(d.fragments, nil)
+		d.fragments = d.fragments[:0]
+		return [][]byte{nalu}, nil
+
+	default:
+		d.fragments = d.fragments[:0]
+		return [][]byte{pkt.Payload}, nil
+	}
+}
+
+// decode decodes an access unit from RTP packets.
+// Access units end with packets that have the marker bit set.
+func (d *h266Decoder) decode(pkt *rtp.Packet) ([][]byte, error) {
+	nalus, err := d.decodeNALUs(pkt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, nalu := range nalus {
+		d.frameSize += len(nalu)
+	}
+
+	if d.frameSize > h266MaxAccessUnitSize {
+		size := d.frameSize
+		d.frameBuffer = nil
+		d.frameSize = 0
+		return nil, fmt.Errorf("access unit size (%d) is too big, maximum is %d", size, h266MaxAccessUnitSize)
+	}
+
+	d.frameBuffer = append(d.frameBuffer, nalus...)
+
+	if !pkt.Marker {
+		return nil, errH266MorePacketsNeeded
+	}
+
+	au := d.frameBuffer
+	d.frameBuffer = nil
+	d.frameSize = 0
+
+	return au, nil
+}
+
+// h266 is a processor of H266 streams, that are described by generic formats
+// since they are not supported by the RTSP library.
+type h266 struct {
+	UDPMaxPayloadSize  int
+	Format             *format.Generic
+	GenerateRTPPackets bool
+	Parent             logger.Writer
+
+	decoder *h266Decoder
+	vps     []byte
+	sps     []byte
+	pps     []byte
+}
+
+func (t *h266) initialize() error {
+	if t.GenerateRTPPackets {
+		return fmt.Errorf("we don't know how to generate RTP packets of format %T", t.Format)
+	}
+
+	// parameters can be provided out of band
+	for key, dest := range map[string]*[]byte{
+		"sprop-vps": &t.vps,
+		"sprop-sps": &t.sps,
+		"sprop-pps": &t.pps,
+	} {
+		if val, ok := t.Format.FMT[key]; ok {
+			byts, err := base64.StdEncoding.DecodeString(val)
+			if err == nil && len(byts) >= 2 {
+				*dest = byts
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t *h266) updateParameters(au [][]byte) {
+	for _, nalu := range au {
+		switch h266NALUType(nalu) {
+		case h266NALUTypeVPS:
+			t.vps = nalu
+
+		case h266NALUTypeSPS:
+			t.sps = nalu
+
+		case h266NALUTypePPS:
+			t.pps = nalu
+		}
+	}
+}
+
+// remuxAccessUnit removes parameters and delimiters, and prepends parameters
+// to random access units.
+func (t *h266) remuxAccessUnit(au [][]byte) [][]byte {
+	filteredNALUs := make([][]byte, 0, len(au)+3)
+
+	if H266IsRandomAccess(au) && t.sps != nil && t.pps != nil {
+		if t.vps != nil {
+			filteredNALUs = append(filteredNALUs, t.vps)
+		}
+		filteredNALUs = append(filteredNALUs, t.sps, t.pps)
+	}
+
+	for _, nalu := range au {
+		switch h266NALUType(nalu) {
+		case h266NALUTypeVPS, h266NALUTypeSPS, h266NALUTypePPS, h266NALUTypeAUD:
+			continue
+		}
+		filteredNALUs = append(filteredNALUs, nalu)
+	}
+
+	if len(filteredNALUs) == 0 {
+		return nil
+	}
+
+	return filteredNALUs
+}
+
+func (t *h266) ProcessUnit(_ unit.Unit) error {
+	return fmt.Errorf("using a H266 unit without RTP is not supported")
+}
+
+func (t *h266) ProcessRTPPacket(
+	pkt *rtp.Packet,
+	ntp time.Time,
+	pts int64,
+	hasNonRTSPReaders bool,
+) (unit.Unit, error) {
+	u := &unit.H266{
+		Base: unit.Base{
+			RTPPackets: []*rtp.Packet{pkt},
+			NTP:        ntp,
+			PTS:        pts,
+		},
+	}
+
+	// remove padding
+	pkt.Header.Padding = false
+	pkt.PaddingSize = 0
+
+	if pkt.MarshalSize() > t.UDPMaxPayloadSize {
+		return nil, fmt.Errorf("payload size (%d) is greater than maximum allowed (%d)",
+			pkt.MarshalSize(), t.UDPMaxPayloadSize)
+	}
+
+	// decode from RTP
+	if hasNonRTSPReaders || t.decoder != nil {
+		if t.decoder == nil {
+			t.decoder = &h266Decoder{}
+		}
+
+		au, err := t.decoder.decode(pkt)
+		if err != nil {
+			if errors.Is(err, errH266NonStartingPacketAndNoPrevious) ||
+				errors.Is(err, errH266MorePacketsNeeded) {
+				return u, nil
+			}
+			return nil, err
+		}
+
+		t.updateParameters(au)
+		u.AU = t.remuxAccessUnit(au)
+	}
+
+	// route packet as is
+	return u, nil
+}
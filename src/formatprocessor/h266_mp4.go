@@ -0,0 +1,274 @@
+package formatprocessor
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/bits"
+	mch264 "github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+)
+
+// H266Params returns the parameters contained in a H266 access unit.
+func H266Params(au [][]byte) (vps []byte, sps []byte, pps []byte) {
+	for _, nalu := range au {
+		switch h266NALUType(nalu) {
+		case h266NALUTypeVPS:
+			vps = nalu
+
+		case h266NALUTypeSPS:
+			sps = nalu
+
+		case h266NALUTypePPS:
+			pps = nalu
+		}
+	}
+	return
+}
+
+// h266SkipProfileTierLevel skips a profile_tier_level() structure with profileTierPresentFlag = 1.
+// Specification: ITU-T H.266, section 7.3.3.1
+func h266SkipProfileTierLevel(buf []byte, pos *int, maxSubLayersMinus1 int) error {
+	// general_profile_idc, general_tier_flag, general_level_idc,
+	// ptl_frame_only_constraint_flag, ptl_multilayer_enabled_flag
+	_, err := bits.ReadBits(buf, pos, 7+1+8+1+1)
+	if err != nil {
+		return err
+	}
+
+	// general_constraints_info()
+	gciPresent, err := bits.ReadFlag(buf, pos)
+	if err != nil {
+		return err
+	}
+
+	if gciPresent {
+		// constraint flags
+		_, err = bits.ReadBits(buf, pos, 71)
+		if err != nil {
+			return err
+		}
+
+		var numAdditionalBits uint64
+		numAdditionalBits, err = bits.ReadBits(buf, pos, 8)
+		if err != nil {
+			return err
+		}
+
+		err = bits.HasSpace(buf, *pos, int(numAdditionalBits))
+		if err != nil {
+			return err
+		}
+		*pos += int(numAdditionalBits)
+	}
+
+	// gci_alignment_zero_bit
+	*pos = (*pos + 7) / 8 * 8
+
+	sublayerLevelPresent := make([]bool, maxSubLayersMinus1)
+	for i := maxSubLayersMinus1 - 1; i >= 0; i-- {
+		sublayerLevelPresent[i], err = bits.ReadFlag(buf, pos)
+		if err != nil {
+			return err
+		}
+	}
+
+	// ptl_reserved_zero_bit
+	*pos = (*pos + 7) / 8 * 8
+
+	for i := maxSubLayersMinus1 - 1; i >= 0; i-- {
+		if sublayerLevelPresent[i] {
+			// sublayer_level_idc
+			_, err = bits.ReadBits(buf, pos, 8)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	numSubProfiles, err := bits.ReadBits(buf, pos, 8)
+	if err != nil {
+		return err
+	}
+
+	// general_sub_profile_idc
+	err = bits.HasSpace(buf, *pos, int(numSubProfiles)*32)
+	if err != nil {
+		return err
+	}
+	*pos += int(numSubProfiles) * 32
+
+	return nil
+}
+
+// H266SPSResolution returns the resolution of the pictures described by a H266 SPS,
+// taking into account the conformance window.
+// Specification: ITU-T H.266, section 7.3.2.4
+func H266SPSResolution(sps []byte) (int, int, error) {
+	if len(sps) < 2 {
+		return 0, 0, fmt.Errorf("SPS is too short")
+	}
+
+	buf := mch264.EmulationPreventionRemove(sps)
+	pos := 2 * 8 // NAL unit header
+
+	// sps_seq_parameter_set_id, sps_video_parameter_set_id
+	_, err := bits.ReadBits(buf, &pos, 4+4)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	maxSubLayersMinus1, err := bits.ReadBits(buf, &pos, 3)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	chromaFormatIdc, err := bits.ReadBits(buf, &pos, 2)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// sps_log2_ctu_size_minus5
+	_, err = bits.ReadBits(buf, &pos, 2)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ptlDpbHrdParamsPresent, err := bits.ReadFlag(buf, &pos)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if ptlDpbHrdParamsPresent {
+		err = h266SkipProfileTierLevel(buf, &pos, int(maxSubLayersMinus1))
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// sps_gdr_enabled_flag
+	_, err = bits.ReadFlag(buf, &pos)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	refPicResamplingEnabled, err := bits.ReadFlag(buf, &pos)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if refPicResamplingEnabled {
+		// sps_res_change_in_clvs_allowed_flag
+		_, err = bits.ReadFlag(buf, &pos)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	width, err := bits.ReadGolombUnsigned(buf, &pos)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	height, err := bits.ReadGolombUnsigned(buf, &pos)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	conformanceWindow, err := bits.ReadFlag(buf, &pos)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if conformanceWindow {
+		var offsets [4]uint32
+		for i := range offsets {
+			offsets[i], err = bits.ReadGolombUnsigned(buf, &pos)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+
+		subWidthC, subHeightC := uint32(1), uint32(1)
+		switch chromaFormatIdc {
+		case 1:
+			subWidthC, subHeightC = 2, 2
+
+		case 2:
+			subWidthC = 2
+		}
+
+		cropWidth := subWidthC * (offsets[0] + offsets[1])
+		cropHeight := subHeightC * (offsets[2] + offsets[3])
+
+		if cropWidth >= width || cropHeight >= height {
+			return 0, 0, fmt.Errorf("invalid conformance window")
+		}
+
+		width -= cropWidth
+		height -= cropHeight
+	}
+
+	return int(width), int(height), nil
+}
+
+func marshalMP4Box(typ string, payloads ...[]byte) []byte {
+	size := 8
+	for _, p := range payloads {
+		size += len(p)
+	}
+
+	buf := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(size))
+	copy(buf[4:8], typ)
+
+	for _, p := range payloads {
+		buf = append(buf, p...)
+	}
+
+	return buf
+}
+
+// H266MP4SampleEntry returns the MP4 sample entry (vvc1) of a H266 track,
+// that contains a VvcConfigurationBox (vvcC) with the given parameters.
+// The profile, tier and level are not included in the configuration, since they are optional.
+// Specification: ISO 14496-15, section 11.2.4.2
+func H266MP4SampleEntry(vps []byte, sps []byte, pps []byte) ([]byte, error) {
+	width, height, err := H266SPSResolution(sps)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse SPS: %w", err)
+	}
+
+	// reserved = '11111'b, LengthSizeMinusOne = 3, ptl_present_flag = 0
+	vvcC := []byte{
+		0, 0, 0, 0, // version and flags
+		0b11111110,
+		0, // num_of_arrays
+	}
+
+	for _, nalu := range [][]byte{vps, sps, pps} {
+		if nalu == nil {
+			continue
+		}
+
+		vvcC[5]++
+		// array_completeness = 1, NAL_unit_type
+		vvcC = append(vvcC, 0x80|h266NALUType(nalu))
+		vvcC = binary.BigEndian.AppendUint16(vvcC, 1) // num_nalus
+		vvcC = binary.BigEndian.AppendUint16(vvcC, uint16(len(nalu)))
+		vvcC = append(vvcC, nalu...)
+	}
+
+	// VisualSampleEntry
+	// Specification: ISO 14496-12, section 12.1.3
+	fields := make([]byte, 78)
+	binary.BigEndian.PutUint16(fields[6:8], 1) // data_reference_index
+	binary.BigEndian.PutUint16(fields[24:26], uint16(width))
+	binary.BigEndian.PutUint16(fields[26:28], uint16(height))
+	binary.BigEndian.PutUint32(fields[28:32], 0x00480000) // horizresolution
+	binary.BigEndian.PutUint32(fields[32:36], 0x00480000) // vertresolution
+	binary.BigEndian.PutUint16(fields[40:42], 1)          // frame_count
+	binary.BigEndian.PutUint16(fields[74:76], 0x0018)     // depth
+	binary.BigEndian.PutUint16(fields[76:78], 0xFFFF)     // pre_defined
+
+	return marshalMP4Box("vvc1", fields, marshalMP4Box("vvcC", vvcC)), nil
+}
@@ -0,0 +1,179 @@
+package formatprocessor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+func TestH266ProcessRTPPacket(t *testing.T) {
+	forma := &format.Generic{
+		PayloadTyp: 96,
+		RTPMa:      "H266/90000",
+	}
+	err := forma.Init()
+	require.NoError(t, err)
+
+	p, err := New(1472, forma, false, nil)
+	require.NoError(t, err)
+
+	sps := []byte{0, h266NALUTypeSPS<<3 | 1, 1, 2}
+	pps := []byte{0, h266NALUTypePPS<<3 | 1, 3, 4}
+	idr := []byte{0, h266NALUTypeIDRNLP<<3 | 1, 5, 6, 7, 8}
+	trail := []byte{0, 0<<3 | 1, 9, 10}
+
+	for _, ca := range []struct {
+		name     string
+		payloads [][]byte
+		au       [][]byte
+	}{
+		{
+			"parameters and fragmented key frame",
+			[][]byte{
+				sps,
+				pps,
+				{0, h266NALUTypeFragmentationUnit<<3 | 1, 0x80 | h266NALUTypeIDRNLP, 5, 6},
+				{0, h266NALUTypeFragmentationUnit<<3 | 1, 0x40 | h266NALUTypeIDRNLP, 7, 8},
+			},
+			[][]byte{sps, pps, idr},
+		},
+		{
+			"non-key frame",
+			[][]byte{trail},
+			[][]byte{trail},
+		},
+		{
+			"key frame without parameters",
+			[][]byte{idr},
+			[][]byte{sps, pps, idr},
+		},
+		{
+			"aggregation unit",
+			[][]byte{
+				append(append(append([]byte{0, h266NALUTypeAggregationUnit<<3 | 1},
+					0, 4), sps...),
+					append([]byte{0, 6}, idr...)...),
+			},
+			[][]byte{sps, pps, idr},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var u unit.Unit
+
+			for i, payload := range ca.payloads {
+				u, err = p.ProcessRTPPacket(&rtp.Packet{
+					Header: rtp.Header{
+						Version:        2,
+						Marker:         i == len(ca.payloads)-1,
+						PayloadType:    96,
+						SequenceNumber: uint16(i),
+						SSRC:           1234,
+					},
+					Payload: payload,
+				}, time.Time{}, 0, true)
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, ca.au, u.(*unit.H266).AU)
+		})
+	}
+}
+
+func TestH266ParametersFromFMTP(t *testing.T) {
+	forma := &format.Generic{
+		PayloadTyp: 96,
+		RTPMa:      "H266/90000",
+		FMT: map[string]string{
+			"sprop-sps": "AHkBAg==",
+			"sprop-pps": "AIEDBA==",
+		},
+	}
+	err := forma.Init()
+	require.NoError(t, err)
+
+	p, err := New(1472, forma, false, nil)
+	require.NoError(t, err)
+
+	idr := []byte{0, h266NALUTypeIDRNLP<<3 | 1, 5, 6}
+
+	u, err := p.ProcessRTPPacket(&rtp.Packet{
+		Header: rtp.Header{
+			Version:     2,
+			Marker:      true,
+			PayloadType: 96,
+			SSRC:        1234,
+		},
+		Payload: idr,
+	}, time.Time{}, 0, true)
+	require.NoError(t, err)
+
+	require.Equal(t, [][]byte{
+		{0, h266NALUTypeSPS<<3 | 1, 1, 2},
+		{0, h266NALUTypePPS<<3 | 1, 3, 4},
+		idr,
+	}, u.(*unit.H266).AU)
+}
+
+func TestH266SPSResolution(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		sps    []byte
+		width  int
+		height int
+	}{
+		{
+			"1920x1080",
+			[]byte{
+				0x00, 0x79, 0x00, 0x0d, 0x02, 0x53, 0x80, 0x00,
+				0x00, 0x0f, 0x02, 0x00, 0x43, 0x94,
+			},
+			1920,
+			1080,
+		},
+		{
+			"conformance window",
+			[]byte{
+				0x00, 0x79, 0x00, 0x0d, 0x02, 0x53, 0x80, 0x00,
+				0x00, 0x0f, 0x02, 0x00, 0x44, 0x1f, 0x2c,
+			},
+			1920,
+			1080,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			width, height, err := H266SPSResolution(ca.sps)
+			require.NoError(t, err)
+			require.Equal(t, ca.width, width)
+			require.Equal(t, ca.height, height)
+		})
+	}
+}
+
+func TestH266MP4SampleEntry(t *testing.T) {
+	sps := []byte{
+		0x00, 0x79, 0x00, 0x0d, 0x02, 0x53, 0x80, 0x00,
+		0x00, 0x0f, 0x02, 0x00, 0x43, 0x94,
+	}
+	pps := []byte{0x00, 0x81, 0x03, 0x04}
+
+	entry, err := H266MP4SampleEntry(nil, sps, pps)
+	require.NoError(t, err)
+
+	require.Equal(t, []byte{0, 0, 0, 0x80, 'v', 'v', 'c', '1'}, entry[:8])
+	require.Equal(t, []byte{0x07, 0x80, 0x04, 0x38}, entry[8+24:8+28])
+
+	require.Equal(t, append([]byte{
+		0, 0, 0, 0x2a, 'v', 'v', 'c', 'C',
+		0, 0, 0, 0,
+		0xfe,
+		2,
+		0x80 | h266NALUTypeSPS, 0, 1, 0, byte(len(sps)),
+	}, append(append(sps,
+		0x80|h266NALUTypePPS, 0, 1, 0, byte(len(pps))),
+		pps...)...), entry[8+78:])
+}
@@ -0,0 +1,219 @@
+package formatprocessor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtp"
+
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+// maximum size of a KLV unit.
+const klvMaxUnitSize = 1 * 1024 * 1024
+
+var errKLVMorePacketsNeeded = errors.New("need more packets")
+
+// IsKLV checks whether a format contains KLV metadata, as described in RFC 6597.
+func IsKLV(forma format.Format) bool {
+	g, ok := forma.(*format.Generic)
+	if !ok {
+		return false
+	}
+
+	codec, _, _ := strings.Cut(g.RTPMa, "/")
+	return strings.ToLower(codec) == "smpte336m"
+}
+
+// klvDecoder is a RTP/KLV decoder.
+// Specification: RFC 6597
+type klvDecoder struct {
+	fragments          [][]byte
+	fragmentsSize      int
+	fragmentsTimestamp uint32
+	fragmentNextSeqNum uint16
+}
+
+func (d *klvDecoder) resetFragments() {
+	d.fragments = d.fragments[:0]
+	d.fragmentsSize = 0
+}
+
+// decode decodes a KLV unit from RTP packets.
+// KLV units end with packets that have the marker bit set.
+func (d *klvDecoder) decode(pkt *rtp.Packet) ([]byte, error) {
+	if len(d.fragments) != 0 &&
+		(pkt.SequenceNumber != d.fragmentNextSeqNum || pkt.Timestamp != d.fragmentsTimestamp) {
+		d.resetFragments()
+		return nil, fmt.Errorf("discarding KLV unit since a RTP packet is missing")
+	}
+
+	d.fragmentsSize += len(pkt.Payload)
+	if d.fragmentsSize > klvMaxUnitSize {
+		size := d.fragmentsSize
+		d.resetFragments()
+		return nil, fmt.Errorf("KLV unit size (%d) is too big, maximum is %d", size, klvMaxUnitSize)
+	}
+
+	d.fragments = append(d.fragments, pkt.Payload)
+
+	if !pkt.Marker {
+		d.fragmentsTimestamp = pkt.Timestamp
+		d.fragmentNextSeqNum = pkt.SequenceNumber + 1
+		return nil, errKLVMorePacketsNeeded
+	}
+
+	u := bytes.Join(d.fragments, nil)
+	d.resetFragments()
+
+	return u, nil
+}
+
+// klvEncoder is a RTP/KLV encoder.
+// Specification: RFC 6597
+type klvEncoder struct {
+	payloadType    uint8
+	payloadMaxSize int
+	ssrc           uint32
+	sequenceNumber uint16
+}
+
+func (e *klvEncoder) initialize() error {
+	var err error
+	e.ssrc, err = randUint32()
+	if err != nil {
+		return err
+	}
+
+	v, err := randUint32()
+	if err != nil {
+		return err
+	}
+	e.sequenceNumber = uint16(v)
+
+	return nil
+}
+
+func (e *klvEncoder) encode(u []byte) []*rtp.Packet {
+	var pkts []*rtp.Packet
+
+	for {
+		le := min(len(u), e.payloadMaxSize)
+
+		pkts = append(pkts, &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    e.payloadType,
+				SequenceNumber: e.sequenceNumber,
+				SSRC:           e.ssrc,
+				Marker:         le == len(u),
+			},
+			Payload: u[:le],
+		})
+
+		e.sequenceNumber++
+		u = u[le:]
+
+		if len(u) == 0 {
+			return pkts
+		}
+	}
+}
+
+// klv is a processor of KLV metadata streams, that are described by generic formats
+// since they are not supported by the RTSP library.
+type klv struct {
+	UDPMaxPayloadSize  int
+	Format             *format.Generic
+	GenerateRTPPackets bool
+	Parent             logger.Writer
+
+	encoder     *klvEncoder
+	decoder     *klvDecoder
+	randomStart uint32
+}
+
+func (t *klv) initialize() error {
+	if t.GenerateRTPPackets {
+		t.encoder = &klvEncoder{
+			payloadType:    t.Format.PayloadTyp,
+			payloadMaxSize: t.UDPMaxPayloadSize - 12,
+		}
+		err := t.encoder.initialize()
+		if err != nil {
+			return err
+		}
+
+		t.randomStart, err = randUint32()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *klv) ProcessUnit(uu unit.Unit) error {
+	u := uu.(*unit.KLV)
+
+	if u.Unit == nil {
+		return nil
+	}
+
+	u.RTPPackets = t.encoder.encode(u.Unit)
+
+	for _, pkt := range u.RTPPackets {
+		pkt.Timestamp += t.randomStart + uint32(u.PTS)
+	}
+
+	return nil
+}
+
+func (t *klv) ProcessRTPPacket(
+	pkt *rtp.Packet,
+	ntp time.Time,
+	pts int64,
+	hasNonRTSPReaders bool,
+) (unit.Unit, error) {
+	u := &unit.KLV{
+		Base: unit.Base{
+			RTPPackets: []*rtp.Packet{pkt},
+			NTP:        ntp,
+			PTS:        pts,
+		},
+	}
+
+	// remove padding
+	pkt.Header.Padding = false
+	pkt.PaddingSize = 0
+
+	if pkt.MarshalSize() > t.UDPMaxPayloadSize {
+		return nil, fmt.Errorf("payload size (%d) is greater than maximum allowed (%d)",
+			pkt.MarshalSize(), t.UDPMaxPayloadSize)
+	}
+
+	// decode from RTP
+	if hasNonRTSPReaders || t.decoder != nil {
+		if t.decoder == nil {
+			t.decoder = &klvDecoder{}
+		}
+
+		ku, err := t.decoder.decode(pkt)
+		if err != nil {
+			if errors.Is(err, errKLVMorePacketsNeeded) {
+				return u, nil
+			}
+			return nil, err
+		}
+
+		u.Unit = ku
+	}
+
+	// route packet as is
+	return u, nil
+}
@@ -0,0 +1,91 @@
+package formatprocessor
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/flynnletford/mediamtx/src/unit"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKLVRoundTrip(t *testing.T) {
+	forma := &format.Generic{
+		PayloadTyp: 96,
+		RTPMa:      "smpte336m/90000",
+	}
+	err := forma.Init()
+	require.NoError(t, err)
+
+	p, err := New(1472, forma, true, nil)
+	require.NoError(t, err)
+
+	klvUnit := append([]byte{0x06, 0x0e, 0x2b, 0x34}, bytes.Repeat([]byte{1, 2, 3, 4}, 1000)...)
+
+	u := &unit.KLV{
+		Base: unit.Base{
+			PTS: 30000,
+		},
+		Unit: klvUnit,
+	}
+
+	err = p.ProcessUnit(u)
+	require.NoError(t, err)
+	require.Len(t, u.RTPPackets, 3)
+
+	for i, pkt := range u.RTPPackets {
+		require.Equal(t, i == 2, pkt.Marker)
+		require.Equal(t, u.RTPPackets[0].Timestamp, pkt.Timestamp)
+		require.LessOrEqual(t, pkt.MarshalSize(), 1472)
+	}
+
+	p2, err := New(1472, forma, false, nil)
+	require.NoError(t, err)
+
+	var out unit.Unit
+
+	for _, pkt := range u.RTPPackets {
+		out, err = p2.ProcessRTPPacket(pkt, time.Time{}, 0, true)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, klvUnit, out.(*unit.KLV).Unit)
+}
+
+func TestKLVMissingPacket(t *testing.T) {
+	forma := &format.Generic{
+		PayloadTyp: 96,
+		RTPMa:      "smpte336m/90000",
+	}
+	err := forma.Init()
+	require.NoError(t, err)
+
+	p, err := New(1472, forma, false, nil)
+	require.NoError(t, err)
+
+	u, err := p.ProcessRTPPacket(&rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    96,
+			SequenceNumber: 100,
+			Timestamp:      1000,
+		},
+		Payload: []byte{0x06, 0x0e},
+	}, time.Time{}, 0, true)
+	require.NoError(t, err)
+	require.Nil(t, u.(*unit.KLV).Unit)
+
+	_, err = p.ProcessRTPPacket(&rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    96,
+			SequenceNumber: 102,
+			Timestamp:      1000,
+			Marker:         true,
+		},
+		Payload: []byte{0x2b, 0x34},
+	}, time.Time{}, 0, true)
+	require.EqualError(t, err, "discarding KLV unit since a RTP packet is missing")
+}
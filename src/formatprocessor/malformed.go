@@ -0,0 +1,96 @@
+package formatprocessor
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errDependsOnMalformed = errors.New("access unit depends on a dropped access unit")
+
+type dtsExtractor interface {
+	Extract(au [][]byte, pts int64) (int64, error)
+}
+
+// malformedFilter drops malformed NALUs and access units of H264 and H265 streams,
+// in order to prevent them from reaching readers.
+// Access units are considered malformed when their DTS can't be extracted, since this
+// is what readers do. After a malformed access unit, following access units are dropped
+// until the next random access point, since they may depend on the dropped one.
+type malformedFilter struct {
+	isMalformedNALU func(nalu []byte) bool
+	isRandomAccess  func(au [][]byte) bool
+	newDTSExtractor func() dtsExtractor
+	onMalformed     func(err error)
+
+	dtsExtractor    dtsExtractor
+	waitingKeyFrame bool
+}
+
+// reset drops access units until the next random access point.
+func (f *malformedFilter) reset(err error) {
+	f.dtsExtractor = nil
+	f.waitingKeyFrame = true
+	f.onMalformed(err)
+}
+
+// filterNALUs removes malformed NALUs from an access unit.
+func (f *malformedFilter) filterNALUs(au [][]byte) [][]byte {
+	n := 0
+	for _, nalu := range au {
+		if f.isMalformedNALU(nalu) {
+			n++
+		}
+	}
+
+	if n == 0 {
+		return au
+	}
+
+	f.onMalformed(fmt.Errorf("dropped %d malformed NAL %s", n, func() string {
+		if n == 1 {
+			return "unit"
+		}
+		return "units"
+	}()))
+
+	if n == len(au) {
+		return nil
+	}
+
+	filtered := make([][]byte, 0, len(au)-n)
+	for _, nalu := range au {
+		if !f.isMalformedNALU(nalu) {
+			filtered = append(filtered, nalu)
+		}
+	}
+
+	return filtered
+}
+
+// filterAccessUnit returns nil when an access unit must be dropped.
+func (f *malformedFilter) filterAccessUnit(au [][]byte, pts int64) [][]byte {
+	if au == nil {
+		return nil
+	}
+
+	if f.dtsExtractor == nil {
+		if !f.isRandomAccess(au) {
+			if f.waitingKeyFrame {
+				f.onMalformed(errDependsOnMalformed)
+				return nil
+			}
+			return au
+		}
+
+		f.dtsExtractor = f.newDTSExtractor()
+		f.waitingKeyFrame = false
+	}
+
+	_, err := f.dtsExtractor.Extract(au, pts)
+	if err != nil {
+		f.reset(fmt.Errorf("dropped malformed access unit: %w", err))
+		return nil
+	}
+
+	return au
+}
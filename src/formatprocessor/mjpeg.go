@@ -7,12 +7,69 @@ import (
 
 	"github.com/bluenviron/gortsplib/v4/pkg/format"
 	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpmjpeg"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/jpeg"
 	"github.com/pion/rtp"
 
 	"github.com/flynnletford/mediamtx/src/logger"
 	"github.com/flynnletford/mediamtx/src/unit"
 )
 
+// jpegExtractSize extracts the size of a JPEG image from its SOF segment.
+func jpegExtractSize(image []byte) (int, int, error) {
+	l := len(image)
+	if l < 2 || image[0] != 0xFF || image[1] != jpeg.MarkerStartOfImage {
+		return 0, 0, fmt.Errorf("invalid header")
+	}
+
+	image = image[2:]
+
+	for {
+		if len(image) < 2 {
+			return 0, 0, fmt.Errorf("not enough bits")
+		}
+
+		h0, h1 := image[0], image[1]
+		image = image[2:]
+
+		if h0 != 0xFF {
+			return 0, 0, fmt.Errorf("invalid image")
+		}
+
+		switch h1 {
+		case 0xE0, 0xE1, 0xE2, // JFIF
+			jpeg.MarkerDefineHuffmanTable,
+			jpeg.MarkerComment,
+			jpeg.MarkerDefineQuantizationTable,
+			jpeg.MarkerDefineRestartInterval:
+			mlen := int(image[0])<<8 | int(image[1])
+			if len(image) < mlen {
+				return 0, 0, fmt.Errorf("not enough bits")
+			}
+			image = image[mlen:]
+
+		case jpeg.MarkerStartOfFrame1:
+			mlen := int(image[0])<<8 | int(image[1])
+			if len(image) < mlen {
+				return 0, 0, fmt.Errorf("not enough bits")
+			}
+
+			var sof jpeg.StartOfFrame1
+			err := sof.Unmarshal(image[2:mlen])
+			if err != nil {
+				return 0, 0, err
+			}
+
+			return sof.Width, sof.Height, nil
+
+		case jpeg.MarkerStartOfScan:
+			return 0, 0, fmt.Errorf("SOF not found")
+
+		default:
+			return 0, 0, fmt.Errorf("unknown marker: 0x%.2x", h1)
+		}
+	}
+}
+
 type mjpeg struct {
 	UDPMaxPayloadSize  int
 	Format             *format.MJPEG
@@ -47,9 +104,25 @@ func (t *mjpeg) createEncoder() error {
 	return t.encoder.Init()
 }
 
+// fillSize fills the size of the frame.
+// Frames whose size can't be extracted are routed anyway.
+func (t *mjpeg) fillSize(u *unit.MJPEG) {
+	width, height, err := jpegExtractSize(u.Frame)
+	if err != nil {
+		u.Width = 0
+		u.Height = 0
+		return
+	}
+
+	u.Width = width
+	u.Height = height
+}
+
 func (t *mjpeg) ProcessUnit(uu unit.Unit) error { //nolint:dupl
 	u := uu.(*unit.MJPEG)
 
+	t.fillSize(u)
+
 	// encode into RTP
 	pkts, err := t.encoder.Encode(u.Frame)
 	if err != nil {
@@ -107,6 +180,7 @@ func (t *mjpeg) ProcessRTPPacket( //nolint:dupl
 		}
 
 		u.Frame = frame
+		t.fillSize(u)
 	}
 
 	// route packet as is
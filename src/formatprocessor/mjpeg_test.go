@@ -0,0 +1,64 @@
+package formatprocessor
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+func TestMJPEGSize(t *testing.T) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 640, 480)), nil)
+	require.NoError(t, err)
+
+	forma := &format.MJPEG{}
+
+	p, err := New(1472, forma, true, nil)
+	require.NoError(t, err)
+
+	u := &unit.MJPEG{
+		Frame: buf.Bytes(),
+	}
+
+	err = p.ProcessUnit(u)
+	require.NoError(t, err)
+	require.Equal(t, 640, u.Width)
+	require.Equal(t, 480, u.Height)
+	require.Greater(t, len(u.RTPPackets), 1)
+
+	// frames are reassembled from fragments
+	p, err = New(1472, forma, false, nil)
+	require.NoError(t, err)
+
+	var out *unit.MJPEG
+
+	for _, pkt := range u.RTPPackets {
+		var uu unit.Unit
+		uu, err = p.ProcessRTPPacket(pkt, time.Time{}, 0, true)
+		require.NoError(t, err)
+		out = uu.(*unit.MJPEG)
+	}
+
+	require.NotNil(t, out.Frame)
+	require.Equal(t, 640, out.Width)
+	require.Equal(t, 480, out.Height)
+
+	// size of invalid frames is zero
+	u = &unit.MJPEG{
+		Frame: []byte{0xFF, 0xD8, 0x01},
+	}
+
+	p, err = New(1472, forma, true, nil)
+	require.NoError(t, err)
+
+	err = p.ProcessUnit(u)
+	require.Error(t, err)
+	require.Equal(t, 0, u.Width)
+}
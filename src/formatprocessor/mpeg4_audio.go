@@ -19,9 +19,10 @@ type mpeg4Audio struct {
 	GenerateRTPPackets bool
 	Parent             logger.Writer
 
-	encoder     *rtpmpeg4audio.Encoder
-	decoder     *rtpmpeg4audio.Decoder
-	randomStart uint32
+	encoder       *rtpmpeg4audio.Encoder
+	decoder       *rtpmpeg4audio.Decoder
+	inBandDecoder *latmInBandDecoder
+	randomStart   uint32
 }
 
 func (t *mpeg4Audio) initialize() error {
@@ -44,6 +45,7 @@ func (t *mpeg4Audio) createEncoder() error {
 	t.encoder = &rtpmpeg4audio.Encoder{
 		PayloadMaxSize:   t.UDPMaxPayloadSize - 12,
 		PayloadType:      t.Format.PayloadTyp,
+		LATM:             t.Format.LATM,
 		SizeLength:       t.Format.SizeLength,
 		IndexLength:      t.Format.IndexLength,
 		IndexDeltaLength: t.Format.IndexDeltaLength,
@@ -90,6 +92,28 @@ func (t *mpeg4Audio) ProcessRTPPacket( //nolint:dupl
 			pkt.MarshalSize(), t.UDPMaxPayloadSize)
 	}
 
+	// LATM streams with in-band configuration
+	if t.Format.LATM && t.Format.CPresent {
+		if hasNonRTSPReaders || t.inBandDecoder != nil {
+			if t.inBandDecoder == nil {
+				t.inBandDecoder = &latmInBandDecoder{}
+			}
+
+			aus, err := t.inBandDecoder.decode(pkt)
+			if err != nil {
+				return nil, err
+			}
+
+			if aus != nil {
+				u.AUs = aus
+				u.Config = t.inBandDecoder.config.Programs[0].Layers[0].AudioSpecificConfig
+			}
+		}
+
+		// route packet as is
+		return u, nil
+	}
+
 	// decode from RTP
 	if hasNonRTSPReaders || t.decoder != nil {
 		if t.decoder == nil {
@@ -0,0 +1,276 @@
+package formatprocessor
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/bits"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+	"github.com/pion/rtp"
+)
+
+// MPEG-4 audio related parameters
+var (
+	// MPEG4AudioLATMDefaultConfig is the configuration of LATM streams
+	// whose configuration is transmitted in-band, before it is received.
+	MPEG4AudioLATMDefaultConfig = mpeg4audio.Config{
+		Type:         mpeg4audio.ObjectTypeAACLC,
+		SampleRate:   16000,
+		ChannelCount: 1,
+	}
+)
+
+var errLATMConfigMissing = errors.New("LATM payload received before StreamMuxConfig")
+
+const (
+	loasSyncWord   = 0x2B7
+	loasHeaderSize = 3
+)
+
+func isLOAS(buf []byte) bool {
+	return len(buf) >= loasHeaderSize && (uint16(buf[0])<<3|uint16(buf[1])>>5) == loasSyncWord
+}
+
+// unmarshalLATMStreamMuxConfig decodes a StreamMuxConfig that starts at an arbitrary bit position.
+// Only configurations with a single program and a single layer are supported.
+// Specification: ISO 14496-3, Table 1.42
+func unmarshalLATMStreamMuxConfig(buf []byte, pos *int) (*mpeg4audio.StreamMuxConfig, error) {
+	err := bits.HasSpace(buf, *pos, 15)
+	if err != nil {
+		return nil, err
+	}
+
+	audioMuxVersion := bits.ReadFlagUnsafe(buf, pos)
+	if audioMuxVersion {
+		return nil, fmt.Errorf("audioMuxVersion = 1 is not supported")
+	}
+
+	allStreamsSameTimeFraming := bits.ReadFlagUnsafe(buf, pos)
+	if !allStreamsSameTimeFraming {
+		return nil, fmt.Errorf("allStreamsSameTimeFraming = 0 is not supported")
+	}
+
+	c := &mpeg4audio.StreamMuxConfig{}
+	c.NumSubFrames = uint(bits.ReadBitsUnsafe(buf, pos, 6))
+
+	numProgram := bits.ReadBitsUnsafe(buf, pos, 4)
+	numLayer := bits.ReadBitsUnsafe(buf, pos, 3)
+	if numProgram != 0 || numLayer != 0 {
+		return nil, fmt.Errorf("multiple programs or layers are not supported")
+	}
+
+	l := &mpeg4audio.StreamMuxConfigLayer{
+		AudioSpecificConfig: &mpeg4audio.AudioSpecificConfig{},
+	}
+	c.Programs = []*mpeg4audio.StreamMuxConfigProgram{{
+		Layers: []*mpeg4audio.StreamMuxConfigLayer{l},
+	}}
+
+	err = l.AudioSpecificConfig.UnmarshalFromPos(buf, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := bits.ReadBits(buf, pos, 3)
+	if err != nil {
+		return nil, err
+	}
+	l.FrameLengthType = uint(tmp)
+
+	if l.FrameLengthType != 0 {
+		return nil, fmt.Errorf("frameLengthType = %d is not supported", l.FrameLengthType)
+	}
+
+	tmp, err = bits.ReadBits(buf, pos, 8)
+	if err != nil {
+		return nil, err
+	}
+	l.LatmBufferFullness = uint(tmp)
+
+	c.OtherDataPresent, err = bits.ReadFlag(buf, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.OtherDataPresent {
+		for {
+			c.OtherDataLenBits *= 256
+
+			err = bits.HasSpace(buf, *pos, 9)
+			if err != nil {
+				return nil, err
+			}
+
+			otherDataLenEsc := bits.ReadFlagUnsafe(buf, pos)
+			c.OtherDataLenBits += uint32(bits.ReadBitsUnsafe(buf, pos, 8))
+
+			if !otherDataLenEsc {
+				break
+			}
+		}
+	}
+
+	c.CRCCheckPresent, err = bits.ReadFlag(buf, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.CRCCheckPresent {
+		tmp, err = bits.ReadBits(buf, pos, 8)
+		if err != nil {
+			return nil, err
+		}
+		c.CRCCheckSum = uint8(tmp)
+	}
+
+	return c, nil
+}
+
+// latmInBandDecoder decodes LATM streams that transmit their StreamMuxConfig in-band (cpresent=1),
+// optionally wrapped into LOAS (AudioSyncStream), into raw AUs.
+// Specification: https://datatracker.ietf.org/doc/html/rfc6416#section-7.3
+// Specification: ISO 14496-3, section 1.7.3
+type latmInBandDecoder struct {
+	// current configuration
+	config *mpeg4audio.StreamMuxConfig
+
+	fragments          [][]byte
+	fragmentsSize      int
+	fragmentNextSeqNum uint16
+}
+
+func (d *latmInBandDecoder) resetFragments() {
+	d.fragments = d.fragments[:0]
+	d.fragmentsSize = 0
+}
+
+// decode decodes AUs from a RTP packet.
+// AudioMuxElements can be split into multiple RTP packets, the last of which has the marker flag.
+func (d *latmInBandDecoder) decode(pkt *rtp.Packet) ([][]byte, error) {
+	if d.fragmentsSize != 0 && pkt.SequenceNumber != d.fragmentNextSeqNum {
+		d.resetFragments()
+		return nil, fmt.Errorf("discarding frame since a RTP packet is missing")
+	}
+
+	if !pkt.Marker {
+		d.fragmentsSize += len(pkt.Payload)
+		if d.fragmentsSize > mpeg4audio.MaxAccessUnitSize {
+			errSize := d.fragmentsSize
+			d.resetFragments()
+			return nil, fmt.Errorf("AudioMuxElement size (%d) is too big, maximum is %d",
+				errSize, mpeg4audio.MaxAccessUnitSize)
+		}
+
+		d.fragments = append(d.fragments, pkt.Payload)
+		d.fragmentNextSeqNum = pkt.SequenceNumber + 1
+		return nil, nil
+	}
+
+	buf := pkt.Payload
+
+	if d.fragmentsSize != 0 {
+		buf = make([]byte, 0, d.fragmentsSize+len(pkt.Payload))
+		for _, frag := range d.fragments {
+			buf = append(buf, frag...)
+		}
+		buf = append(buf, pkt.Payload...)
+		d.resetFragments()
+	}
+
+	if !isLOAS(buf) {
+		return d.decodeAudioMuxElement(buf)
+	}
+
+	var aus [][]byte
+
+	for len(buf) != 0 {
+		if !isLOAS(buf) {
+			return nil, fmt.Errorf("invalid LOAS sync word")
+		}
+
+		le := int(buf[1]&0x1F)<<8 | int(buf[2])
+		buf = buf[loasHeaderSize:]
+
+		if le > len(buf) {
+			return nil, fmt.Errorf("invalid LOAS frame length")
+		}
+
+		elementAUs, err := d.decodeAudioMuxElement(buf[:le])
+		if err != nil {
+			return nil, err
+		}
+
+		aus = append(aus, elementAUs...)
+		buf = buf[le:]
+	}
+
+	return aus, nil
+}
+
+// decodeAudioMuxElement decodes an AudioMuxElement with muxConfigPresent = 1.
+// Specification: ISO 14496-3, Table 1.41
+func (d *latmInBandDecoder) decodeAudioMuxElement(buf []byte) ([][]byte, error) {
+	pos := 0
+
+	useSameStreamMux, err := bits.ReadFlag(buf, &pos)
+	if err != nil {
+		return nil, err
+	}
+
+	if !useSameStreamMux {
+		d.config, err = unmarshalLATMStreamMuxConfig(buf, &pos)
+		if err != nil {
+			return nil, fmt.Errorf("invalid StreamMuxConfig: %w", err)
+		}
+	}
+
+	if d.config == nil {
+		return nil, errLATMConfigMissing
+	}
+
+	aus := make([][]byte, d.config.NumSubFrames+1)
+
+	for i := range aus {
+		le := 0
+
+		for {
+			var tmp uint64
+			tmp, err = bits.ReadBits(buf, &pos, 8)
+			if err != nil {
+				return nil, err
+			}
+			le += int(tmp)
+
+			if tmp != 255 {
+				break
+			}
+		}
+
+		if le > mpeg4audio.MaxAccessUnitSize {
+			return nil, fmt.Errorf("access unit size (%d) is too big, maximum is %d",
+				le, mpeg4audio.MaxAccessUnitSize)
+		}
+
+		err = bits.HasSpace(buf, pos, le*8)
+		if err != nil {
+			return nil, err
+		}
+
+		au := make([]byte, le)
+
+		if (pos % 8) == 0 {
+			copy(au, buf[pos/8:])
+			pos += le * 8
+		} else {
+			for j := range au {
+				au[j] = byte(bits.ReadBitsUnsafe(buf, &pos, 8))
+			}
+		}
+
+		aus[i] = au
+	}
+
+	// otherData and byte alignment are ignored.
+
+	return aus, nil
+}
@@ -0,0 +1,214 @@
+package formatprocessor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/v2/pkg/bits"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+	"github.com/flynnletford/mediamtx/src/unit"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+// marshalAudioMuxElement encodes an AudioMuxElement that contains an AAC-LC 44100Hz stereo
+// StreamMuxConfig (when withConfig is true) followed by the given AUs.
+func marshalAudioMuxElement(withConfig bool, aus [][]byte) []byte {
+	buf := make([]byte, 64+len(aus)*2+len(aus[0])*len(aus))
+	pos := 0
+
+	bits.WriteBitsUnsafe(buf, &pos, boolToUint64(!withConfig), 1) // useSameStreamMux
+
+	if withConfig {
+		bits.WriteBitsUnsafe(buf, &pos, 0, 1)                  // audioMuxVersion
+		bits.WriteBitsUnsafe(buf, &pos, 1, 1)                  // allStreamsSameTimeFraming
+		bits.WriteBitsUnsafe(buf, &pos, uint64(len(aus)-1), 6) // numSubFrames
+		bits.WriteBitsUnsafe(buf, &pos, 0, 4)                  // numProgram
+		bits.WriteBitsUnsafe(buf, &pos, 0, 3)                  // numLayer
+		bits.WriteBitsUnsafe(buf, &pos, 2, 5)                  // audioObjectType
+		bits.WriteBitsUnsafe(buf, &pos, 4, 4)                  // samplingFrequencyIndex
+		bits.WriteBitsUnsafe(buf, &pos, 2, 4)                  // channelConfiguration
+		bits.WriteBitsUnsafe(buf, &pos, 0, 3)                  // GASpecificConfig
+		bits.WriteBitsUnsafe(buf, &pos, 0, 3)                  // frameLengthType
+		bits.WriteBitsUnsafe(buf, &pos, 0xFF, 8)               // latmBufferFullness
+		bits.WriteBitsUnsafe(buf, &pos, 0, 1)                  // otherDataPresent
+		bits.WriteBitsUnsafe(buf, &pos, 0, 1)                  // crcCheckPresent
+	}
+
+	for _, au := range aus {
+		bits.WriteBitsUnsafe(buf, &pos, uint64(len(au)), 8)
+
+		for _, b := range au {
+			bits.WriteBitsUnsafe(buf, &pos, uint64(b), 8)
+		}
+	}
+
+	n := pos / 8
+	if (pos % 8) != 0 {
+		n++
+	}
+
+	return buf[:n]
+}
+
+func boolToUint64(v bool) uint64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func marshalLOAS(element []byte) []byte {
+	return append([]byte{
+		0x56,
+		0xE0 | byte(len(element)>>8),
+		byte(len(element)),
+	}, element...)
+}
+
+func TestMPEG4AudioLATMEncode(t *testing.T) {
+	forma := &format.MPEG4Audio{
+		PayloadTyp:     96,
+		LATM:           true,
+		ProfileLevelID: 30,
+		StreamMuxConfig: &mpeg4audio.StreamMuxConfig{
+			Programs: []*mpeg4audio.StreamMuxConfigProgram{{
+				Layers: []*mpeg4audio.StreamMuxConfigLayer{{
+					AudioSpecificConfig: &mpeg4audio.AudioSpecificConfig{
+						Type:         mpeg4audio.ObjectTypeAACLC,
+						SampleRate:   44100,
+						ChannelCount: 2,
+					},
+					LatmBufferFullness: 255,
+				}},
+			}},
+		},
+	}
+
+	p, err := New(1472, forma, true, nil)
+	require.NoError(t, err)
+
+	u := &unit.MPEG4Audio{
+		AUs: [][]byte{{1, 2, 3, 4}},
+	}
+
+	err = p.ProcessUnit(u)
+	require.NoError(t, err)
+	require.Equal(t, []*rtp.Packet{{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    96,
+			SequenceNumber: u.RTPPackets[0].SequenceNumber,
+			Timestamp:      u.RTPPackets[0].Timestamp,
+			SSRC:           u.RTPPackets[0].SSRC,
+			Marker:         true,
+		},
+		Payload: []byte{4, 1, 2, 3, 4},
+	}}, u.RTPPackets)
+}
+
+func TestMPEG4AudioLATMInBandConfig(t *testing.T) {
+	aus := [][]byte{{1, 2, 3, 4}, {5, 6, 7, 8}}
+
+	for _, ca := range []struct {
+		name     string
+		payloads [][]byte
+	}{
+		{
+			"latm",
+			[][]byte{
+				marshalAudioMuxElement(true, aus),
+				marshalAudioMuxElement(false, aus),
+			},
+		},
+		{
+			"loas",
+			[][]byte{
+				append(
+					marshalLOAS(marshalAudioMuxElement(true, aus)),
+					marshalLOAS(marshalAudioMuxElement(false, aus))...),
+			},
+		},
+		{
+			"fragmented",
+			func() [][]byte {
+				buf := marshalAudioMuxElement(true, aus)
+				return [][]byte{buf[:4], buf[4:]}
+			}(),
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			forma := &format.MPEG4Audio{
+				PayloadTyp:     96,
+				LATM:           true,
+				ProfileLevelID: 30,
+				CPresent:       true,
+			}
+
+			p, err := New(1472, forma, false, nil)
+			require.NoError(t, err)
+
+			var decoded [][]byte
+
+			for i, payload := range ca.payloads {
+				var u unit.Unit
+				u, err = p.ProcessRTPPacket(&rtp.Packet{
+					Header: rtp.Header{
+						Version:        2,
+						PayloadType:    96,
+						SequenceNumber: 123 + uint16(i),
+						Timestamp:      45343,
+						SSRC:           563423,
+						Marker:         (i == len(ca.payloads)-1) || ca.name != "fragmented",
+					},
+					Payload: payload,
+				}, time.Time{}, 0, true)
+				require.NoError(t, err)
+
+				tunit := u.(*unit.MPEG4Audio)
+				if tunit.AUs != nil {
+					require.Equal(t, &mpeg4audio.Config{
+						Type:         mpeg4audio.ObjectTypeAACLC,
+						SampleRate:   44100,
+						ChannelCount: 2,
+					}, tunit.Config)
+				}
+
+				decoded = append(decoded, tunit.AUs...)
+			}
+
+			expected := aus
+			if ca.name != "fragmented" {
+				expected = append(append([][]byte(nil), aus...), aus...)
+			}
+
+			require.Equal(t, expected, decoded)
+		})
+	}
+}
+
+func TestMPEG4AudioLATMInBandConfigMissing(t *testing.T) {
+	forma := &format.MPEG4Audio{
+		PayloadTyp:     96,
+		LATM:           true,
+		ProfileLevelID: 30,
+		CPresent:       true,
+	}
+
+	p, err := New(1472, forma, false, nil)
+	require.NoError(t, err)
+
+	_, err = p.ProcessRTPPacket(&rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    96,
+			SequenceNumber: 123,
+			Timestamp:      45343,
+			SSRC:           563423,
+			Marker:         true,
+		},
+		Payload: marshalAudioMuxElement(false, [][]byte{{1, 2, 3, 4}}),
+	}, time.Time{}, 0, true)
+	require.ErrorIs(t, err, errLATMConfigMissing)
+}
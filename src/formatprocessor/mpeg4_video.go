@@ -31,6 +31,7 @@ type mpeg4Video struct {
 	UDPMaxPayloadSize  int
 	Format             *format.MPEG4Video
 	GenerateRTPPackets bool
+	OnParametersChange func()
 	Parent             logger.Writer
 
 	encoder     *rtpmpeg4video.Encoder
@@ -72,6 +73,10 @@ func (t *mpeg4Video) updateTrackParameters(frame []byte) {
 
 		if !bytes.Equal(conf, t.Format.Config) {
 			t.Format.SafeSetParams(conf)
+
+			if t.OnParametersChange != nil {
+				t.OnParametersChange()
+			}
 		}
 	}
 }
@@ -37,6 +37,27 @@ type Processor interface {
 	initialize() error
 }
 
+// Options are options of a Processor.
+type Options struct {
+	// prepend parameters to every key frame of H264 and H265 streams,
+	// re-encoding RTP packets.
+	InjectParameterSets bool
+
+	// called when parameters of the format (H264 and H265 parameter sets, MPEG-4 Video configuration)
+	// change, before the unit that contains them is returned.
+	OnParametersChange func()
+
+	// decode G711 and G722 into LPCM, filling the LPCM field of units.
+	DecodeToLPCM bool
+
+	// drop malformed H264 and H265 NAL units and access units instead of returning errors.
+	// Access units that follow a dropped one are dropped too, until the next key frame.
+	DropMalformed bool
+
+	// called when DropMalformed is true and a NAL unit or access unit is dropped.
+	OnMalformed func(err error)
+}
+
 // New allocates a Processor.
 func New(
 	udpMaxPayloadSize int,
@@ -44,6 +65,32 @@ func New(
 	generateRTPPackets bool,
 	parent logger.Writer,
 ) (Processor, error) {
+	return NewWithOptions(udpMaxPayloadSize, forma, generateRTPPackets, Options{}, parent)
+}
+
+// NewWithOptions allocates a Processor with options.
+func NewWithOptions(
+	udpMaxPayloadSize int,
+	forma format.Format,
+	generateRTPPackets bool,
+	opts Options,
+	parent logger.Writer,
+) (Processor, error) {
+	if reg := Lookup(forma); reg != nil {
+		cp, err := reg.New(Params{
+			UDPMaxPayloadSize:  udpMaxPayloadSize,
+			Format:             forma,
+			GenerateRTPPackets: generateRTPPackets,
+			Options:            opts,
+			Parent:             parent,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return &custom{cp}, nil
+	}
+
 	var proc Processor
 
 	switch forma := forma.(type) {
@@ -73,18 +120,26 @@ func New(
 
 	case *format.H265:
 		proc = &h265{
-			UDPMaxPayloadSize:  udpMaxPayloadSize,
-			Format:             forma,
-			GenerateRTPPackets: generateRTPPackets,
-			Parent:             parent,
+			UDPMaxPayloadSize:   udpMaxPayloadSize,
+			Format:              forma,
+			GenerateRTPPackets:  generateRTPPackets,
+			InjectParameterSets: opts.InjectParameterSets,
+			OnParametersChange:  opts.OnParametersChange,
+			DropMalformed:       opts.DropMalformed,
+			OnMalformed:         opts.OnMalformed,
+			Parent:              parent,
 		}
 
 	case *format.H264:
 		proc = &h264{
-			UDPMaxPayloadSize:  udpMaxPayloadSize,
-			Format:             forma,
-			GenerateRTPPackets: generateRTPPackets,
-			Parent:             parent,
+			UDPMaxPayloadSize:   udpMaxPayloadSize,
+			Format:              forma,
+			GenerateRTPPackets:  generateRTPPackets,
+			InjectParameterSets: opts.InjectParameterSets,
+			OnParametersChange:  opts.OnParametersChange,
+			DropMalformed:       opts.DropMalformed,
+			OnMalformed:         opts.OnMalformed,
+			Parent:              parent,
 		}
 
 	case *format.MPEG4Video:
@@ -92,6 +147,7 @@ func New(
 			UDPMaxPayloadSize:  udpMaxPayloadSize,
 			Format:             forma,
 			GenerateRTPPackets: generateRTPPackets,
+			OnParametersChange: opts.OnParametersChange,
 			Parent:             parent,
 		}
 
@@ -143,11 +199,21 @@ func New(
 			Parent:             parent,
 		}
 
+	case *format.G722:
+		proc = &g722{
+			UDPMaxPayloadSize:  udpMaxPayloadSize,
+			Format:             forma,
+			GenerateRTPPackets: generateRTPPackets,
+			DecodeToLPCM:       opts.DecodeToLPCM,
+			Parent:             parent,
+		}
+
 	case *format.G711:
 		proc = &g711{
 			UDPMaxPayloadSize:  udpMaxPayloadSize,
 			Format:             forma,
 			GenerateRTPPackets: generateRTPPackets,
+			DecodeToLPCM:       opts.DecodeToLPCM,
 			Parent:             parent,
 		}
 
@@ -159,6 +225,33 @@ func New(
 			Parent:             parent,
 		}
 
+	case *format.Generic:
+		switch {
+		case IsKLV(forma):
+			proc = &klv{
+				UDPMaxPayloadSize:  udpMaxPayloadSize,
+				Format:             forma,
+				GenerateRTPPackets: generateRTPPackets,
+				Parent:             parent,
+			}
+
+		case IsH266(forma):
+			proc = &h266{
+				UDPMaxPayloadSize:  udpMaxPayloadSize,
+				Format:             forma,
+				GenerateRTPPackets: generateRTPPackets,
+				Parent:             parent,
+			}
+
+		default:
+			proc = &generic{
+				UDPMaxPayloadSize:  udpMaxPayloadSize,
+				Format:             forma,
+				GenerateRTPPackets: generateRTPPackets,
+				Parent:             parent,
+			}
+		}
+
 	default:
 		proc = &generic{
 			UDPMaxPayloadSize:  udpMaxPayloadSize,
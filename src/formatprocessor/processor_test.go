@@ -83,6 +83,11 @@ func TestNew(t *testing.T) {
 			&format.LPCM{},
 			&lpcm{},
 		},
+		{
+			"klv",
+			&format.Generic{RTPMa: "SMPTE336M/90000"},
+			&klv{},
+		},
 		{
 			"generic",
 			&format.Generic{},
@@ -0,0 +1,126 @@
+package formatprocessor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/pion/rtp"
+
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+// Params are the parameters passed to custom processors.
+type Params struct {
+	UDPMaxPayloadSize  int
+	Format             format.Format
+	GenerateRTPPackets bool
+	Options            Options
+	Parent             logger.Writer
+}
+
+// CustomProcessor is a processor provided by an external package.
+// It has the same methods of Processor, except for initialization, that is performed by Registration.New.
+type CustomProcessor interface {
+	// process a Unit.
+	ProcessUnit(unit.Unit) error
+
+	// process a RTP packet and convert it into a unit.
+	ProcessRTPPacket(
+		pkt *rtp.Packet,
+		ntp time.Time,
+		pts int64,
+		hasNonRTSPReaders bool,
+	) (unit.Unit, error)
+}
+
+// FMP4Sample is a fMP4 sample generated from a unit of a custom format.
+type FMP4Sample struct {
+	*fmp4.PartSample
+
+	// decoding timestamp, expressed in clock rate units of the format.
+	DTS int64
+}
+
+// Registration is the registration of a custom processor.
+type Registration struct {
+	// checks whether a format is handled by the processor.
+	Match func(forma format.Format) bool
+
+	// allocates the processor.
+	New func(params Params) (CustomProcessor, error)
+
+	// (optional) returns the fMP4 codec of a format, allowing the recorder to store it.
+	FMP4Codec func(forma format.Format) fmp4.Codec
+
+	// (optional) converts a unit into fMP4 samples. It is mandatory when FMP4Codec is set.
+	FMP4Samples func(u unit.Unit) ([]*FMP4Sample, error)
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      []*Registration
+)
+
+// Register registers a custom processor.
+// Registrations take precedence over built-in processors and are checked in registration order.
+func Register(r *Registration) error {
+	if r.Match == nil || r.New == nil {
+		return fmt.Errorf("registration must have Match and New")
+	}
+
+	if (r.FMP4Codec == nil) != (r.FMP4Samples == nil) {
+		return fmt.Errorf("registration must have both FMP4Codec and FMP4Samples, or none of them")
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	for _, existing := range registry {
+		if existing == r {
+			return fmt.Errorf("processor is already registered")
+		}
+	}
+
+	registry = append(registry, r)
+	return nil
+}
+
+// Unregister removes a custom processor from the registry.
+// Processors that have already been allocated are not affected.
+func Unregister(r *Registration) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	for i, existing := range registry {
+		if existing == r {
+			registry = append(registry[:i:i], registry[i+1:]...)
+			return
+		}
+	}
+}
+
+// Lookup returns the registration that handles a format, or nil.
+func Lookup(forma format.Format) *Registration {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	for _, r := range registry {
+		if r.Match(forma) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+type custom struct {
+	CustomProcessor
+}
+
+func (t *custom) initialize() error {
+	return nil
+}
@@ -0,0 +1,84 @@
+package formatprocessor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/flynnletford/mediamtx/src/unit"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+type testCustomProcessor struct {
+	params Params
+}
+
+func (t *testCustomProcessor) ProcessUnit(unit.Unit) error {
+	return nil
+}
+
+func (t *testCustomProcessor) ProcessRTPPacket(
+	pkt *rtp.Packet,
+	ntp time.Time,
+	pts int64,
+	_ bool,
+) (unit.Unit, error) {
+	return &unit.Generic{
+		Base: unit.Base{
+			RTPPackets: []*rtp.Packet{pkt},
+			NTP:        ntp,
+			PTS:        pts,
+		},
+	}, nil
+}
+
+func TestRegister(t *testing.T) {
+	reg := &Registration{
+		Match: func(forma format.Format) bool {
+			g, ok := forma.(*format.Generic)
+			return ok && g.RTPMa == "x-custom/90000"
+		},
+		New: func(params Params) (CustomProcessor, error) {
+			return &testCustomProcessor{params: params}, nil
+		},
+	}
+
+	err := Register(reg)
+	require.NoError(t, err)
+	defer Unregister(reg)
+
+	err = Register(reg)
+	require.EqualError(t, err, "processor is already registered")
+
+	err = Register(&Registration{Match: reg.Match})
+	require.EqualError(t, err, "registration must have Match and New")
+
+	forma := &format.Generic{
+		PayloadTyp: 96,
+		RTPMa:      "x-custom/90000",
+	}
+	err = forma.Init()
+	require.NoError(t, err)
+
+	require.Equal(t, reg, Lookup(forma))
+
+	p, err := NewWithOptions(1472, forma, true, Options{DecodeToLPCM: true}, nil)
+	require.NoError(t, err)
+
+	cp, ok := p.(*custom).CustomProcessor.(*testCustomProcessor)
+	require.True(t, ok)
+	require.Equal(t, Params{
+		UDPMaxPayloadSize:  1472,
+		Format:             forma,
+		GenerateRTPPackets: true,
+		Options:            Options{DecodeToLPCM: true},
+	}, cp.params)
+
+	Unregister(reg)
+	require.Nil(t, Lookup(forma))
+
+	p, err = New(1472, forma, false, nil)
+	require.NoError(t, err)
+	require.IsType(t, &generic{}, p)
+}
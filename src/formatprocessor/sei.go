@@ -0,0 +1,346 @@
+package formatprocessor
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/bits"
+	mch264 "github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	mch265 "github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
+
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+// SEI payload types.
+// Specification: ITU-T H.264, annex D and ITU-T H.265, annex D
+const (
+	seiTypePicTiming            = 1
+	seiTypeUserDataRegistered   = 4
+	seiTypeUserDataUnregistered = 5
+	seiTypeTimeCode             = 136
+	seiTypeMasteringDisplay     = 137
+	seiTypeContentLightLevel    = 144
+)
+
+// number of clock timestamps of a H264 picture timing message, indexed by pic_struct.
+var h264PicStructNumClockTS = []int{1, 1, 1, 2, 2, 3, 3, 2, 3}
+
+// seiReadMessages reads the messages contained in the RBSP of a SEI NALU.
+func seiReadMessages(rbsp []byte, cb func(typ int, payload []byte) error) error {
+	pos := 0
+
+	readValue := func() (int, error) {
+		v := 0
+		for {
+			if pos >= len(rbsp) {
+				return 0, fmt.Errorf("not enough bits")
+			}
+			b := rbsp[pos]
+			pos++
+			v += int(b)
+			if b != 0xFF {
+				return v, nil
+			}
+		}
+	}
+
+	// stop at rbsp_trailing_bits
+	for pos < len(rbsp) && rbsp[pos] != 0x80 {
+		typ, err := readValue()
+		if err != nil {
+			return err
+		}
+
+		size, err := readValue()
+		if err != nil {
+			return err
+		}
+
+		if (len(rbsp) - pos) < size {
+			return fmt.Errorf("invalid payload size")
+		}
+
+		err = cb(typ, rbsp[pos:pos+size])
+		if err != nil {
+			return err
+		}
+
+		pos += size
+	}
+
+	return nil
+}
+
+func seiParseMasteringDisplay(payload []byte) (*unit.MasteringDisplay, error) {
+	if len(payload) < 24 {
+		return nil, fmt.Errorf("invalid mastering display size")
+	}
+
+	md := &unit.MasteringDisplay{}
+
+	for i := 0; i < 3; i++ {
+		md.DisplayPrimariesX[i] = binary.BigEndian.Uint16(payload[i*4:])
+		md.DisplayPrimariesY[i] = binary.BigEndian.Uint16(payload[i*4+2:])
+	}
+
+	md.WhitePointX = binary.BigEndian.Uint16(payload[12:])
+	md.WhitePointY = binary.BigEndian.Uint16(payload[14:])
+	md.MaxLuminance = binary.BigEndian.Uint32(payload[16:])
+	md.MinLuminance = binary.BigEndian.Uint32(payload[20:])
+
+	return md, nil
+}
+
+func seiParseContentLightLevel(payload []byte) (*unit.ContentLightLevel, error) {
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("invalid content light level size")
+	}
+
+	return &unit.ContentLightLevel{
+		MaxContentLightLevel:    binary.BigEndian.Uint16(payload[0:]),
+		MaxPicAverageLightLevel: binary.BigEndian.Uint16(payload[2:]),
+	}, nil
+}
+
+// seiReadClockTimestamp reads a clock timestamp, that has the same layout in H264 and H265,
+// except for the size of n_frames.
+func seiReadClockTimestamp(buf []byte, pos *int, nFramesBits int) (*unit.Timecode, error) {
+	err := bits.HasSpace(buf, *pos, 1+5+1+1+1+nFramesBits)
+	if err != nil {
+		return nil, err
+	}
+
+	bits.ReadBitsUnsafe(buf, pos, 1) // nuit_field_based_flag
+	countingType := bits.ReadBitsUnsafe(buf, pos, 5)
+	fullTimestampFlag := bits.ReadFlagUnsafe(buf, pos)
+	bits.ReadFlagUnsafe(buf, pos) // discontinuity_flag
+	cntDroppedFlag := bits.ReadFlagUnsafe(buf, pos)
+
+	tc := &unit.Timecode{
+		Frames:    uint16(bits.ReadBitsUnsafe(buf, pos, nFramesBits)),
+		DropFrame: cntDroppedFlag && (countingType == 4),
+	}
+
+	if fullTimestampFlag {
+		err = bits.HasSpace(buf, *pos, 6+6+5)
+		if err != nil {
+			return nil, err
+		}
+
+		tc.Seconds = uint8(bits.ReadBitsUnsafe(buf, pos, 6))
+		tc.Minutes = uint8(bits.ReadBitsUnsafe(buf, pos, 6))
+		tc.Hours = uint8(bits.ReadBitsUnsafe(buf, pos, 5))
+		return tc, nil
+	}
+
+	for _, field := range []struct {
+		dest *uint8
+		bits int
+	}{
+		{&tc.Seconds, 6},
+		{&tc.Minutes, 6},
+		{&tc.Hours, 5},
+	} {
+		var present bool
+		present, err = bits.ReadFlag(buf, pos)
+		if err != nil {
+			return nil, err
+		}
+
+		if !present {
+			break
+		}
+
+		var v uint64
+		v, err = bits.ReadBits(buf, pos, field.bits)
+		if err != nil {
+			return nil, err
+		}
+		*field.dest = uint8(v)
+	}
+
+	return tc, nil
+}
+
+// h264ParsePicTiming extracts the first clock timestamp of a picture timing message.
+// The layout of the message depends on the active SPS.
+func h264ParsePicTiming(payload []byte, sps *mch264.SPS) (*unit.Timecode, error) {
+	if sps == nil || sps.VUI == nil || !sps.VUI.PicStructPresentFlag {
+		return nil, nil
+	}
+
+	pos := 0
+
+	hrd := sps.VUI.NalHRD
+	if hrd == nil {
+		hrd = sps.VUI.VclHRD
+	}
+
+	if hrd != nil {
+		// cpb_removal_delay, dpb_output_delay
+		_, err := bits.ReadBits(payload, &pos,
+			int(hrd.CpbRemovalDelayLengthMinus1)+1+int(hrd.DpbOutputDelayLengthMinus1)+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	picStruct, err := bits.ReadBits(payload, &pos, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(picStruct) >= len(h264PicStructNumClockTS) {
+		return nil, fmt.Errorf("invalid pic_struct")
+	}
+
+	for i := 0; i < h264PicStructNumClockTS[picStruct]; i++ {
+		var clockTimestampFlag bool
+		clockTimestampFlag, err = bits.ReadFlag(payload, &pos)
+		if err != nil {
+			return nil, err
+		}
+
+		if clockTimestampFlag {
+			_, err = bits.ReadBits(payload, &pos, 2) // ct_type
+			if err != nil {
+				return nil, err
+			}
+
+			return seiReadClockTimestamp(payload, &pos, 8)
+		}
+	}
+
+	return nil, nil
+}
+
+// h265ParseTimeCode extracts the first clock timestamp of a time code message.
+func h265ParseTimeCode(payload []byte) (*unit.Timecode, error) {
+	pos := 0
+
+	numClockTS, err := bits.ReadBits(payload, &pos, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint64(0); i < numClockTS; i++ {
+		var clockTimestampFlag bool
+		clockTimestampFlag, err = bits.ReadFlag(payload, &pos)
+		if err != nil {
+			return nil, err
+		}
+
+		if clockTimestampFlag {
+			return seiReadClockTimestamp(payload, &pos, 9)
+		}
+	}
+
+	return nil, nil
+}
+
+// seiParseMessage fills a SEI with a message that is shared by H264 and H265.
+func seiParseMessage(sei *unit.SEI, typ int, payload []byte) error {
+	switch typ {
+	case seiTypeUserDataRegistered:
+		sei.UserDataRegistered = append(sei.UserDataRegistered, payload)
+
+	case seiTypeUserDataUnregistered:
+		if len(payload) < 16 {
+			return fmt.Errorf("invalid user data unregistered size")
+		}
+		sei.UserDataUnregistered = append(sei.UserDataUnregistered, payload)
+
+	case seiTypeMasteringDisplay:
+		var err error
+		sei.MasteringDisplay, err = seiParseMasteringDisplay(payload)
+		if err != nil {
+			return err
+		}
+
+	case seiTypeContentLightLevel:
+		var err error
+		sei.ContentLightLevel, err = seiParseContentLightLevel(payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// h264ParseSEI extracts metadata from the SEI NALUs of an access unit.
+// It returns nil if there are no SEI NALUs.
+func h264ParseSEI(au [][]byte, sps *mch264.SPS) (*unit.SEI, error) {
+	var sei *unit.SEI
+
+	for _, nalu := range au {
+		if mch264.NALUType(nalu[0]&0x1F) != mch264.NALUTypeSEI {
+			continue
+		}
+
+		if sei == nil {
+			sei = &unit.SEI{}
+		}
+
+		err := seiReadMessages(mch264.EmulationPreventionRemove(nalu[1:]), func(typ int, payload []byte) error {
+			if typ == seiTypePicTiming {
+				tc, err := h264ParsePicTiming(payload, sps)
+				if err != nil {
+					return err
+				}
+				if tc != nil {
+					sei.Timecode = tc
+				}
+				return nil
+			}
+
+			return seiParseMessage(sei, typ, payload)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sei, nil
+}
+
+// h265ParseSEI extracts metadata from the SEI NALUs of an access unit.
+// It returns nil if there are no SEI NALUs.
+func h265ParseSEI(au [][]byte) (*unit.SEI, error) {
+	var sei *unit.SEI
+
+	for _, nalu := range au {
+		if len(nalu) < 2 {
+			continue
+		}
+
+		typ := mch265.NALUType((nalu[0] >> 1) & 0b111111)
+		if typ != mch265.NALUType_PREFIX_SEI_NUT && typ != mch265.NALUType_SUFFIX_SEI_NUT {
+			continue
+		}
+
+		if sei == nil {
+			sei = &unit.SEI{}
+		}
+
+		err := seiReadMessages(mch264.EmulationPreventionRemove(nalu[2:]), func(typ int, payload []byte) error {
+			if typ == seiTypeTimeCode {
+				tc, err := h265ParseTimeCode(payload)
+				if err != nil {
+					return err
+				}
+				if tc != nil {
+					sei.Timecode = tc
+				}
+				return nil
+			}
+
+			return seiParseMessage(sei, typ, payload)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sei, nil
+}
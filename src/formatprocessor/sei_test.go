@@ -0,0 +1,113 @@
+package formatprocessor
+
+import (
+	"testing"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	mch264 "github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+func TestH264ParseSEI(t *testing.T) {
+	sps := &mch264.SPS{
+		VUI: &mch264.SPS_VUI{
+			PicStructPresentFlag: true,
+		},
+	}
+
+	sei, err := h264ParseSEI([][]byte{
+		{
+			byte(mch264.NALUTypeSEI),
+			1, 6, // picture timing
+			0x08, 0x25, 0x17, 0x7a, 0xd6, 0x00,
+			137, 24, // mastering display, with an emulation prevention byte
+			0x21, 0x34, 0x9b, 0xaa, 0x19, 0x96, 0x08, 0xfc,
+			0x8a, 0x48, 0x39, 0x08, 0x3d, 0x13, 0x40, 0x42,
+			0x00, 0x98, 0x96, 0x80, 0x00, 0x00, 0x03, 0x00, 0x32,
+			144, 4, // content light level
+			0x03, 0xe8, 0x01, 0x90,
+			5, 17, // user data unregistered
+			0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+			0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10,
+			0x11,
+			0x80,
+		},
+		{byte(mch264.NALUTypeIDR)},
+	}, sps)
+	require.NoError(t, err)
+
+	require.Equal(t, &unit.SEI{
+		Timecode: &unit.Timecode{
+			Hours:     12,
+			Minutes:   45,
+			Seconds:   30,
+			Frames:    23,
+			DropFrame: true,
+		},
+		MasteringDisplay: &unit.MasteringDisplay{
+			DisplayPrimariesX: [3]uint16{8500, 6550, 35400},
+			DisplayPrimariesY: [3]uint16{39850, 2300, 14600},
+			WhitePointX:       15635,
+			WhitePointY:       16450,
+			MaxLuminance:      10000000,
+			MinLuminance:      50,
+		},
+		ContentLightLevel: &unit.ContentLightLevel{
+			MaxContentLightLevel:    1000,
+			MaxPicAverageLightLevel: 400,
+		},
+		UserDataUnregistered: [][]byte{{
+			0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+			0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10,
+			0x11,
+		}},
+	}, sei)
+
+	// picture timing can't be parsed without the SPS
+	sei, err = h264ParseSEI([][]byte{{byte(mch264.NALUTypeSEI), 1, 6, 0x08, 0x25, 0x17, 0x7a, 0xd6, 0x00, 0x80}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, &unit.SEI{}, sei)
+
+	sei, err = h264ParseSEI([][]byte{{byte(mch264.NALUTypeIDR)}}, sps)
+	require.NoError(t, err)
+	require.Nil(t, sei)
+
+	_, err = h264ParseSEI([][]byte{{byte(mch264.NALUTypeSEI), 137, 24, 0x01, 0x02}}, sps)
+	require.EqualError(t, err, "invalid payload size")
+}
+
+func TestH265ProcessSEI(t *testing.T) {
+	forma := &format.H265{
+		PayloadTyp: 96,
+	}
+
+	p, err := New(1472, forma, true, nil)
+	require.NoError(t, err)
+
+	u := &unit.H265{
+		AU: [][]byte{
+			{
+				0x4e, 0x01, // prefix SEI
+				136, 4, // time code
+				0x60, 0x00, 0x54, 0x50,
+				4, 3, // user data registered
+				0xb5, 0x00, 0x31,
+				0x80,
+			},
+			{0x26, 0x01, 0x01}, // IDR
+		},
+	}
+
+	err = p.ProcessUnit(u)
+	require.NoError(t, err)
+
+	require.Equal(t, &unit.SEI{
+		Timecode: &unit.Timecode{
+			Seconds: 5,
+			Frames:  10,
+		},
+		UserDataRegistered: [][]byte{{0xb5, 0x00, 0x31}},
+	}, u.SEI)
+}
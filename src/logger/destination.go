@@ -19,6 +19,6 @@ const (
 )
 
 type destination interface {
-	log(time.Time, Level, string, ...interface{})
+	log(time.Time, Format, Level, string, ...interface{})
 	close()
 }
@@ -22,11 +22,9 @@ func newDestinationFile(filePath string) (destination, error) {
 	}, nil
 }
 
-func (d *destinationFile) log(t time.Time, level Level, format string, args ...interface{}) {
+func (d *destinationFile) log(t time.Time, f Format, level Level, format string, args ...interface{}) {
 	d.buf.Reset()
-	writeTime(&d.buf, t, false)
-	writeLevel(&d.buf, level, false)
-	writeContent(&d.buf, format, args)
+	writeEntry(&d.buf, t, f, level, format, args, false)
 	d.file.Write(d.buf.Bytes()) //nolint:errcheck
 }
 
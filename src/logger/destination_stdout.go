@@ -20,11 +20,9 @@ func newDestionationStdout() destination {
 	}
 }
 
-func (d *destinationStdout) log(t time.Time, level Level, format string, args ...interface{}) {
+func (d *destinationStdout) log(t time.Time, f Format, level Level, format string, args ...interface{}) {
 	d.buf.Reset()
-	writeTime(&d.buf, t, d.useColor)
-	writeLevel(&d.buf, level, d.useColor)
-	writeContent(&d.buf, format, args)
+	writeEntry(&d.buf, t, f, level, format, args, d.useColor)
 	os.Stdout.Write(d.buf.Bytes()) //nolint:errcheck
 }
 
@@ -22,11 +22,9 @@ func newDestinationSyslog(prefix string) (destination, error) {
 	}, nil
 }
 
-func (d *destinationSysLog) log(t time.Time, level Level, format string, args ...interface{}) {
+func (d *destinationSysLog) log(t time.Time, f Format, level Level, format string, args ...interface{}) {
 	d.buf.Reset()
-	writeTime(&d.buf, t, false)
-	writeLevel(&d.buf, level, false)
-	writeContent(&d.buf, format, args)
+	writeEntry(&d.buf, t, f, level, format, args, false)
 	d.syslog.Write(d.buf.Bytes())
 }
 
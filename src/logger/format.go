@@ -0,0 +1,14 @@
+package logger
+
+// Format is a log format.
+type Format int
+
+// Log formats.
+const (
+	// FormatConsole writes entries as human-readable lines.
+	FormatConsole Format = iota
+
+	// FormatJSON writes entries as JSON objects, one per line,
+	// with context tags of messages converted into fields.
+	FormatJSON
+)
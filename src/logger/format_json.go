@@ -0,0 +1,91 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// tags that are converted into fields of JSON entries,
+// like "[path mypath]" into "path": "mypath".
+// The other tags, like "[RTSP]" or "[WebRTC source]", are put into the "module" field.
+var contextTags = []string{
+	"path",
+	"recorder",
+	"track",
+	"segment",
+	"conn",
+	"session",
+	"muxer",
+}
+
+func contextTag(tag string) (string, string, bool) {
+	key, value, ok := strings.Cut(tag, " ")
+	if !ok {
+		return "", "", false
+	}
+
+	for _, t := range contextTags {
+		if key == t {
+			return key, value, true
+		}
+	}
+
+	return "", "", false
+}
+
+func levelName(level Level) string {
+	switch level {
+	case Debug:
+		return "debug"
+
+	case Info:
+		return "info"
+
+	case Warn:
+		return "warn"
+
+	default:
+		return "error"
+	}
+}
+
+func writeJSONField(buf *bytes.Buffer, key string, value string) {
+	buf.WriteByte(',')
+	k, _ := json.Marshal(key)
+	buf.Write(k)
+	buf.WriteByte(':')
+	v, _ := json.Marshal(value)
+	buf.Write(v)
+}
+
+// writeJSON writes an entry as a JSON object.
+// Fields are written in a fixed order: time, level, context fields in the order
+// they appear in the message, module, message.
+func writeJSON(buf *bytes.Buffer, t time.Time, level Level, msg string) {
+	tags, content := splitTags(msg)
+
+	buf.WriteString(`{"time":"`)
+	buf.WriteString(t.Format(time.RFC3339Nano))
+	buf.WriteString(`","level":"`)
+	buf.WriteString(levelName(level))
+	buf.WriteByte('"')
+
+	var modules []string
+
+	for _, tag := range tags {
+		if key, value, ok := contextTag(tag); ok {
+			writeJSONField(buf, key, value)
+		} else {
+			modules = append(modules, tag)
+		}
+	}
+
+	if len(modules) != 0 {
+		writeJSONField(buf, "module", strings.Join(modules, " "))
+	}
+
+	writeJSONField(buf, "message", content)
+	buf.WriteString("}\n")
+}
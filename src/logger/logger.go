@@ -4,6 +4,7 @@ package logger
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,8 +13,11 @@ import (
 
 // Logger is a log handler.
 type Logger struct {
-	level Level
+	level        Level
+	debugModules []string
+	filterMutex  sync.RWMutex
 
+	format       Format
 	destinations []destination
 	mutex        sync.Mutex
 }
@@ -140,9 +144,91 @@ func writeContent(buf *bytes.Buffer, format string, args []interface{}) {
 	buf.WriteByte('\n')
 }
 
+func writeEntry(buf *bytes.Buffer, t time.Time, f Format, level Level, format string, args []interface{}, useColor bool) {
+	if f == FormatJSON {
+		writeJSON(buf, t, level, fmt.Sprintf(format, args...))
+		return
+	}
+
+	writeTime(buf, t, useColor)
+	writeLevel(buf, level, useColor)
+	writeContent(buf, format, args)
+}
+
+// SetLevel sets the minimum level of entries that are written.
+func (lh *Logger) SetLevel(level Level) {
+	lh.filterMutex.Lock()
+	defer lh.filterMutex.Unlock()
+	lh.level = level
+}
+
+// SetDebugModules sets modules whose entries are written regardless of the level.
+// A module matches entries that start with a tag with the same name,
+// like "recorder" with "[path mypath] [recorder] ...".
+func (lh *Logger) SetDebugModules(modules []string) {
+	lh.filterMutex.Lock()
+	defer lh.filterMutex.Unlock()
+	lh.debugModules = modules
+}
+
+// SetFormat sets the format of entries.
+func (lh *Logger) SetFormat(f Format) {
+	lh.mutex.Lock()
+	defer lh.mutex.Unlock()
+	lh.format = f
+}
+
+// splitTags splits the leading tags of a message from its content.
+func splitTags(msg string) ([]string, string) {
+	var tags []string
+
+	for strings.HasPrefix(msg, "[") {
+		end := strings.IndexByte(msg, ']')
+		if end < 0 {
+			break
+		}
+
+		tags = append(tags, msg[1:end])
+		msg = strings.TrimPrefix(msg[end+1:], " ")
+	}
+
+	return tags, msg
+}
+
+// matchesModules checks whether one of the leading tags of a message matches one of the modules.
+// A tag matches a module when it is equal to it or starts with it, followed by a space,
+// therefore "webrtc" matches "[WebRTC]", "[WebRTC source]" but not "[WebRTCX]".
+func matchesModules(msg string, modules []string) bool {
+	tags, _ := splitTags(msg)
+
+	for _, tag := range tags {
+		tag = strings.ToLower(tag)
+
+		for _, m := range modules {
+			m = strings.ToLower(m)
+			if tag == m || strings.HasPrefix(tag, m+" ") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (lh *Logger) enabled(level Level, format string, args []interface{}) bool {
+	lh.filterMutex.RLock()
+	defer lh.filterMutex.RUnlock()
+
+	if level >= lh.level {
+		return true
+	}
+
+	return len(lh.debugModules) != 0 && matchesModules(fmt.Sprintf(format, args...), lh.debugModules)
+}
+
 // Log writes a log entry.
 func (lh *Logger) Log(level Level, format string, args ...interface{}) {
-	if level < lh.level {
+	if !lh.enabled(level, format, args) {
 		return
 	}
 
@@ -152,6 +238,6 @@ func (lh *Logger) Log(level Level, format string, args ...interface{}) {
 	t := time.Now()
 
 	for _, dest := range lh.destinations {
-		dest.log(t, level, format, args...)
+		dest.log(t, lh.format, level, format, args...)
 	}
 }
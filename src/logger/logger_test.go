@@ -0,0 +1,66 @@
+package logger
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchesModules(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		msg  string
+		ok   bool
+	}{
+		{"first tag", "[WebRTC] [session 123] created", true},
+		{"nested tag", "[path mypath] [recorder] recording started", true},
+		{"tag with label", "[WebRTC source] started", true},
+		{"different tag", "[WebRTCX] started", false},
+		{"content", "[RTSP] recorder not found", false},
+		{"no tags", "MediaMTX v1.0.0", false},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			require.Equal(t, ca.ok, matchesModules(ca.msg, []string{"recorder", "webrtc"}))
+		})
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		msg  string
+		out  string
+	}{
+		{
+			"context tags",
+			"[path mypath] [recorder 123] [segment /rec/1.mp4] creating segment",
+			`{"time":"2026-10-16T10:20:30.000000001Z","level":"info","path":"mypath","recorder":"123",` +
+				`"segment":"/rec/1.mp4","message":"creating segment"}` + "\n",
+		},
+		{
+			"modules",
+			"[RTSP] [conn 1.2.3.4:5] [session abc] is \"reading\"",
+			`{"time":"2026-10-16T10:20:30.000000001Z","level":"info","conn":"1.2.3.4:5","session":"abc",` +
+				`"module":"RTSP","message":"is \"reading\""}` + "\n",
+		},
+		{
+			"tag without value",
+			"[path mypath] [recorder] [track 2] skipping (VP8)",
+			`{"time":"2026-10-16T10:20:30.000000001Z","level":"info","path":"mypath","track":"2",` +
+				`"module":"recorder","message":"skipping (VP8)"}` + "\n",
+		},
+		{
+			"no tags",
+			"MediaMTX v1.0.0",
+			`{"time":"2026-10-16T10:20:30.000000001Z","level":"info","message":"MediaMTX v1.0.0"}` + "\n",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeEntry(&buf, time.Date(2026, 10, 16, 10, 20, 30, 1, time.UTC), FormatJSON, Info, "%s", []interface{}{ca.msg}, true)
+			require.Equal(t, ca.out, buf.String())
+		})
+	}
+}
@@ -62,6 +62,7 @@ type Metrics struct {
 	srtServer    api.SRTServer
 	hlsManager   api.HLSServer
 	webRTCServer api.WebRTCServer
+	uploader     api.Uploader
 }
 
 // Initialize initializes metrics.
@@ -161,11 +162,51 @@ func (m *Metrics) onMetrics(ctx *gin.Context) {
 			out += metric("paths", tags, 1)
 			out += metric("paths_bytes_received", tags, int64(i.BytesReceived))
 			out += metric("paths_bytes_sent", tags, int64(i.BytesSent))
+			out += metric("paths_duplicated_timestamps", tags, int64(i.DuplicatedTimestamps))
+			out += metric("paths_non_monotonic_timestamps", tags, int64(i.NonMonotonicTimestamps))
+			out += metric("paths_missing_reference_frames", tags, int64(i.MissingReferenceFrames))
+
+			for j, tr := range i.TrackStats {
+				trackTags := "{name=\"" + i.Name + "\",state=\"" + state + "\"," +
+					"track=\"" + strconv.FormatInt(int64(j), 10) + "\",codec=\"" + tr.Codec + "\"}"
+				out += metric("paths_tracks_packets_lost", trackTags, int64(tr.PacketsLost))
+			}
 		}
 	} else {
 		out += metric("paths", "", 0)
 	}
 
+	if err == nil {
+		n := 0
+
+		for _, i := range data.Items {
+			for _, r := range i.Recorders {
+				id := ""
+				if r.ID != nil {
+					id = r.ID.String()
+				}
+
+				tags := "{path=\"" + i.Name + "\",id=\"" + id + "\"}"
+				out += metric("recorders", tags, 1)
+				out += metric("recorders_segments_written", tags, int64(r.SegmentsWritten))
+				out += metric("recorders_bytes_written", tags, int64(r.BytesWritten))
+				out += metric("recorders_restarts", tags, int64(r.Restarts))
+				out += metric("recorders_queued_units", tags, int64(r.QueuedUnits))
+				out += metric("recorders_queue_size", tags, int64(r.QueueSize))
+				n++
+			}
+		}
+
+		if n == 0 {
+			out += metric("recorders", "", 0)
+			out += metric("recorders_segments_written", "", 0)
+			out += metric("recorders_bytes_written", "", 0)
+			out += metric("recorders_restarts", "", 0)
+			out += metric("recorders_queued_units", "", 0)
+			out += metric("recorders_queue_size", "", 0)
+		}
+	}
+
 	if !interfaceIsEmpty(m.hlsManager) {
 		data, err := m.hlsManager.APIMuxersList()
 		if err == nil && len(data.Items) != 0 {
@@ -442,6 +483,10 @@ func (m *Metrics) onMetrics(ctx *gin.Context) {
 		}
 	}
 
+	if !interfaceIsEmpty(m.uploader) {
+		out += metric("uploader_queue_size", "", int64(m.uploader.QueueLen()))
+	}
+
 	ctx.Writer.WriteHeader(http.StatusOK)
 	io.WriteString(ctx.Writer, out) //nolint:errcheck
 }
@@ -501,3 +546,10 @@ func (m *Metrics) SetWebRTCServer(s api.WebRTCServer) {
 	defer m.mutex.Unlock()
 	m.webRTCServer = s
 }
+
+// SetUploader is called by core.
+func (m *Metrics) SetUploader(s api.Uploader) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.uploader = s
+}
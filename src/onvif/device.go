@@ -0,0 +1,219 @@
+package onvif
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const envelopeTemplate = `<?xml version="1.0" encoding="UTF-8"?>` +
+	`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"` +
+	` xmlns:tds="http://www.onvif.org/ver10/device/wsdl"` +
+	` xmlns:trt="http://www.onvif.org/ver10/media/wsdl"` +
+	` xmlns:tt="http://www.onvif.org/ver10/schema">` +
+	`%s<s:Body>%s</s:Body></s:Envelope>`
+
+const securityTemplate = `<s:Header>` +
+	`<Security s:mustUnderstand="1"` +
+	` xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">` +
+	`<UsernameToken>` +
+	`<Username>%s</Username>` +
+	`<Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">` +
+	`%s</Password>` +
+	`<Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">` +
+	`%s</Nonce>` +
+	`<Created xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">` +
+	`%s</Created>` +
+	`</UsernameToken>` +
+	`</Security>` +
+	`</s:Header>`
+
+const getCapabilitiesRequest = `<tds:GetCapabilities><tds:Category>Media</tds:Category></tds:GetCapabilities>`
+
+const getProfilesRequest = `<trt:GetProfiles/>`
+
+const getStreamURIRequest = `<trt:GetStreamUri>` +
+	`<trt:StreamSetup>` +
+	`<tt:Stream>RTP-Unicast</tt:Stream>` +
+	`<tt:Transport><tt:Protocol>RTSP</tt:Protocol></tt:Transport>` +
+	`</trt:StreamSetup>` +
+	`<trt:ProfileToken>%s</trt:ProfileToken>` +
+	`</trt:GetStreamUri>`
+
+type getCapabilitiesResponse struct {
+	Body struct {
+		GetCapabilitiesResponse struct {
+			Capabilities struct {
+				Media struct {
+					XAddr string `xml:"XAddr"`
+				} `xml:"Media"`
+			} `xml:"Capabilities"`
+		} `xml:"GetCapabilitiesResponse"`
+	} `xml:"Body"`
+}
+
+type getProfilesResponse struct {
+	Body struct {
+		GetProfilesResponse struct {
+			Profiles []struct {
+				Token string `xml:"token,attr"`
+			} `xml:"Profiles"`
+		} `xml:"GetProfilesResponse"`
+	} `xml:"Body"`
+}
+
+type getStreamURIResponse struct {
+	Body struct {
+		GetStreamURIResponse struct {
+			MediaURI struct {
+				URI string `xml:"Uri"`
+			} `xml:"MediaUri"`
+		} `xml:"GetStreamUriResponse"`
+	} `xml:"Body"`
+}
+
+type fault struct {
+	Body struct {
+		Fault struct {
+			Reason struct {
+				Text string `xml:"Text"`
+			} `xml:"Reason"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s)) //nolint:errcheck
+	return buf.String()
+}
+
+// Device is an ONVIF device.
+type Device struct {
+	// URL of the device service.
+	XAddr string
+
+	// credentials. If empty, requests are not authenticated.
+	User string
+	Pass string
+
+	HTTPClient *http.Client
+}
+
+func (d *Device) securityHeader() (string, error) {
+	if d.User == "" {
+		return "", nil
+	}
+
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	created := time.Now().UTC().Format(time.RFC3339)
+
+	h := sha1.New() //nolint:gosec
+	h.Write(nonce)
+	h.Write([]byte(created))
+	h.Write([]byte(d.Pass))
+
+	return fmt.Sprintf(securityTemplate,
+		xmlEscape(d.User),
+		base64.StdEncoding.EncodeToString(h.Sum(nil)),
+		base64.StdEncoding.EncodeToString(nonce),
+		created), nil
+}
+
+func (d *Device) call(ctx context.Context, ur string, body string, res interface{}) error {
+	header, err := d.securityHeader()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ur,
+		bytes.NewReader([]byte(fmt.Sprintf(envelopeTemplate, header, body))))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+
+	hres, err := d.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer hres.Body.Close()
+
+	buf, err := io.ReadAll(io.LimitReader(hres.Body, maxMessageSize*16))
+	if err != nil {
+		return err
+	}
+
+	if hres.StatusCode != http.StatusOK {
+		var f fault
+		err = xml.Unmarshal(buf, &f)
+		if err == nil && f.Body.Fault.Reason.Text != "" {
+			return fmt.Errorf("bad status code: %d (%s)", hres.StatusCode, f.Body.Fault.Reason.Text)
+		}
+		return fmt.Errorf("bad status code: %d", hres.StatusCode)
+	}
+
+	return xml.Unmarshal(buf, res)
+}
+
+// StreamURI returns the RTSP URL of the main stream of the device.
+// If credentials are set, they are included into the URL.
+func (d *Device) StreamURI(ctx context.Context) (string, error) {
+	var capRes getCapabilitiesResponse
+	err := d.call(ctx, d.XAddr, getCapabilitiesRequest, &capRes)
+	if err != nil {
+		return "", fmt.Errorf("GetCapabilities failed: %w", err)
+	}
+
+	mediaXAddr := capRes.Body.GetCapabilitiesResponse.Capabilities.Media.XAddr
+	if mediaXAddr == "" {
+		return "", fmt.Errorf("device doesn't provide a media service")
+	}
+
+	var profRes getProfilesResponse
+	err = d.call(ctx, mediaXAddr, getProfilesRequest, &profRes)
+	if err != nil {
+		return "", fmt.Errorf("GetProfiles failed: %w", err)
+	}
+
+	profiles := profRes.Body.GetProfilesResponse.Profiles
+	if len(profiles) == 0 {
+		return "", fmt.Errorf("device doesn't provide any media profile")
+	}
+
+	// the first profile is the one with the highest quality.
+	var uriRes getStreamURIResponse
+	err = d.call(ctx, mediaXAddr, fmt.Sprintf(getStreamURIRequest, xmlEscape(profiles[0].Token)), &uriRes)
+	if err != nil {
+		return "", fmt.Errorf("GetStreamUri failed: %w", err)
+	}
+
+	u, err := url.Parse(uriRes.Body.GetStreamURIResponse.MediaURI.URI)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme != "rtsp" && u.Scheme != "rtsps" {
+		return "", fmt.Errorf("unsupported stream URI: '%s'", u)
+	}
+
+	if d.User != "" && u.User == nil {
+		u.User = url.UserPassword(d.User, d.Pass)
+	}
+
+	return u.String(), nil
+}
@@ -0,0 +1,139 @@
+// Package onvif contains an ONVIF camera discoverer.
+package onvif
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/logger"
+)
+
+const (
+	probeTimeout = 3 * time.Second
+)
+
+// Camera is a discovered camera.
+type Camera struct {
+	// IP or host name of the camera.
+	Host string
+
+	// RTSP URL of the main stream of the camera.
+	StreamURL string
+}
+
+type discovererParent interface {
+	logger.Writer
+	ONVIFCamerasFound(ctx context.Context, cameras []*Camera)
+}
+
+// Discoverer periodically discovers ONVIF cameras in the local network
+// and fetches the URLs of their streams.
+type Discoverer struct {
+	Interval    conf.Duration
+	ReadTimeout conf.Duration
+	User        string
+	Pass        string
+	Parent      discovererParent
+
+	ctx       context.Context
+	ctxCancel func()
+
+	done chan struct{}
+}
+
+// Initialize initializes Discoverer.
+func (d *Discoverer) Initialize() {
+	d.ctx, d.ctxCancel = context.WithCancel(context.Background())
+	d.done = make(chan struct{})
+
+	d.Log(logger.Info, "discovery started")
+
+	go d.run()
+}
+
+// Close closes Discoverer.
+func (d *Discoverer) Close() {
+	d.Log(logger.Info, "discovery stopped")
+	d.ctxCancel()
+	<-d.done
+}
+
+// Log implements logger.Writer.
+func (d *Discoverer) Log(level logger.Level, format string, args ...interface{}) {
+	d.Parent.Log(level, "[ONVIF] "+format, args...)
+}
+
+func (d *Discoverer) run() {
+	defer close(d.done)
+
+	for {
+		d.discover()
+
+		select {
+		case <-time.After(time.Duration(d.Interval)):
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *Discoverer) discover() {
+	xaddrs, err := Probe(d.ctx, probeTimeout)
+	if err != nil {
+		if d.ctx.Err() == nil {
+			d.Log(logger.Warn, "probe failed: %v", err)
+		}
+		return
+	}
+
+	d.Log(logger.Debug, "%d %s replied to probe",
+		len(xaddrs),
+		func() string {
+			if len(xaddrs) == 1 {
+				return "device"
+			}
+			return "devices"
+		}())
+
+	hc := &http.Client{
+		Timeout: time.Duration(d.ReadTimeout),
+	}
+	defer hc.CloseIdleConnections()
+
+	var cameras []*Camera //nolint:prealloc
+
+	for _, xaddr := range xaddrs {
+		u, err := url.Parse(xaddr)
+		if err != nil {
+			continue
+		}
+
+		dev := &Device{
+			XAddr:      xaddr,
+			User:       d.User,
+			Pass:       d.Pass,
+			HTTPClient: hc,
+		}
+
+		streamURL, err := dev.StreamURI(d.ctx)
+		if err != nil {
+			if d.ctx.Err() != nil {
+				return
+			}
+			d.Log(logger.Warn, "unable to get the stream of %s: %v", u.Hostname(), err)
+			continue
+		}
+
+		cameras = append(cameras, &Camera{
+			Host:      u.Hostname(),
+			StreamURL: streamURL,
+		})
+	}
+
+	if cameras != nil {
+		d.Parent.ONVIFCamerasFound(d.ctx, cameras)
+	}
+}
@@ -0,0 +1,131 @@
+package onvif
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	multicastAddress = "239.255.255.250:3702"
+	maxMessageSize   = 65536
+)
+
+const probeTemplate = `<?xml version="1.0" encoding="UTF-8"?>` +
+	`<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope"` +
+	` xmlns:w="http://schemas.xmlsoap.org/ws/2004/08/addressing"` +
+	` xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery"` +
+	` xmlns:dn="http://www.onvif.org/ver10/network/wsdl">` +
+	`<e:Header>` +
+	`<w:MessageID>uuid:%s</w:MessageID>` +
+	`<w:To e:mustUnderstand="true">urn:schemas-xmlsoap-org:ws:2005:04:discovery</w:To>` +
+	`<w:Action e:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</w:Action>` +
+	`</e:Header>` +
+	`<e:Body><d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></e:Body>` +
+	`</e:Envelope>`
+
+type probeMatches struct {
+	Body struct {
+		ProbeMatches struct {
+			ProbeMatch []struct {
+				XAddrs string `xml:"XAddrs"`
+			} `xml:"ProbeMatch"`
+		} `xml:"ProbeMatches"`
+	} `xml:"Body"`
+}
+
+func unmarshalProbeMatches(buf []byte) ([]string, error) {
+	var m probeMatches
+	err := xml.Unmarshal(buf, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []string
+
+	for _, match := range m.Body.ProbeMatches.ProbeMatch {
+		// XAddrs is a space-separated list of URLs of the device service.
+		// use the first one.
+		fields := strings.Fields(match.XAddrs)
+		if len(fields) != 0 {
+			ret = append(ret, fields[0])
+		}
+	}
+
+	return ret, nil
+}
+
+// Probe sends a WS-Discovery probe and returns the URLs of the device service
+// of every ONVIF device that replies within the timeout.
+func Probe(ctx context.Context, timeout time.Duration) ([]string, error) {
+	dest, err := net.ResolveUDPAddr("udp4", multicastAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+
+	_, err = pc.WriteTo([]byte(fmt.Sprintf(probeTemplate, uuid.New())), dest)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	pc.SetReadDeadline(deadline) //nolint:errcheck
+
+	readDone := make(chan struct{})
+	defer close(readDone)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			pc.SetReadDeadline(time.Now()) //nolint:errcheck
+		case <-readDone:
+		}
+	}()
+
+	found := make(map[string]struct{})
+	var ret []string
+	buf := make([]byte, maxMessageSize)
+
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("terminated")
+			}
+
+			var terr net.Error
+			if errors.As(err, &terr) && terr.Timeout() {
+				return ret, nil
+			}
+
+			return nil, err
+		}
+
+		xaddrs, err := unmarshalProbeMatches(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		for _, xaddr := range xaddrs {
+			if _, ok := found[xaddr]; !ok {
+				found[xaddr] = struct{}{}
+				ret = append(ret, xaddr)
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package onvif
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshalProbeMatches(t *testing.T) {
+	xaddrs, err := unmarshalProbeMatches([]byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://www.w3.org/2003/05/soap-envelope"` +
+		` xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing"` +
+		` xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery"` +
+		` xmlns:dn="http://www.onvif.org/ver10/network/wsdl">` +
+		`<SOAP-ENV:Header>` +
+		`<wsa:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/ProbeMatches</wsa:Action>` +
+		`</SOAP-ENV:Header>` +
+		`<SOAP-ENV:Body><d:ProbeMatches>` +
+		`<d:ProbeMatch>` +
+		`<wsa:EndpointReference><wsa:Address>urn:uuid:1234</wsa:Address></wsa:EndpointReference>` +
+		`<d:Types>dn:NetworkVideoTransmitter</d:Types>` +
+		`<d:XAddrs>http://192.168.1.10/onvif/device_service http://[fe80::1]/onvif/device_service</d:XAddrs>` +
+		`</d:ProbeMatch>` +
+		`<d:ProbeMatch>` +
+		`<d:XAddrs>http://192.168.1.11:8080/onvif/device_service</d:XAddrs>` +
+		`</d:ProbeMatch>` +
+		`</d:ProbeMatches></SOAP-ENV:Body>` +
+		`</SOAP-ENV:Envelope>`))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"http://192.168.1.10/onvif/device_service",
+		"http://192.168.1.11:8080/onvif/device_service",
+	}, xaddrs)
+}
@@ -0,0 +1,139 @@
+package playback
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// byteRange is a range of bytes, with end excluded.
+type byteRange struct {
+	start uint64
+	end   uint64
+}
+
+func parseRangeValue(raw string) (uint64, bool) {
+	v, err := strconv.ParseUint(raw, 10, 64)
+	return v, err == nil
+}
+
+// parseByteRange parses the Range header of a request.
+// Only single ranges are supported; when the header is empty, invalid or contains multiple ranges,
+// nil is returned and the entire content must be sent.
+func parseByteRange(header string, size uint64) (*byteRange, error) {
+	if header == "" {
+		return nil, nil
+	}
+
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, nil
+	}
+
+	if strings.Contains(spec, ",") {
+		return nil, nil
+	}
+
+	rawStart, rawEnd, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+
+	// suffix range, that contains the last bytes
+	if rawStart == "" {
+		n, ok := parseRangeValue(rawEnd)
+		if !ok {
+			return nil, nil
+		}
+
+		if n == 0 {
+			return nil, errRangeNotSatisfiable
+		}
+
+		if n > size {
+			n = size
+		}
+
+		return &byteRange{start: size - n, end: size}, nil
+	}
+
+	start, ok := parseRangeValue(rawStart)
+	if !ok {
+		return nil, nil
+	}
+
+	if start >= size {
+		return nil, errRangeNotSatisfiable
+	}
+
+	end := size
+
+	if rawEnd != "" {
+		last, ok := parseRangeValue(rawEnd)
+		if !ok || last < start {
+			return nil, nil
+		}
+
+		if last < size {
+			end = last + 1
+		}
+	}
+
+	return &byteRange{start: start, end: end}, nil
+}
+
+// rangeWriter is a io.Writer that writes a byte range of its input only.
+// The size of the output is communicated through onSize before the first Write(),
+// that must contain the header of the file.
+type rangeWriter struct {
+	w        io.Writer
+	bodySize uint64
+	onSize   func(size uint64) (*byteRange, error)
+
+	r   *byteRange
+	pos uint64
+}
+
+func (w *rangeWriter) Write(p []byte) (int, error) {
+	if w.r == nil {
+		var err error
+		w.r, err = w.onSize(uint64(len(p)) + w.bodySize)
+		if err != nil {
+			return 0, err
+		}
+
+		if w.r == nil {
+			w.r = &byteRange{start: 0, end: uint64(len(p)) + w.bodySize}
+		}
+	}
+
+	base := w.pos
+	w.pos += uint64(len(p))
+
+	start := base
+	if start < w.r.start {
+		start = w.r.start
+	}
+
+	end := w.pos
+	if end > w.r.end {
+		end = w.r.end
+	}
+
+	if end > start {
+		_, err := w.w.Write(p[start-base : end-base])
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// isOutside checks whether the next size bytes are outside the range.
+func (w *rangeWriter) isOutside(size uint32) bool {
+	return w.r != nil && ((w.pos+uint64(size)) <= w.r.start || w.pos >= w.r.end)
+}
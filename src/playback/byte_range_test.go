@@ -0,0 +1,33 @@
+package playback
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseByteRange(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		header string
+		r      *byteRange
+		err    error
+	}{
+		{"none", "", nil, nil},
+		{"start and end", "bytes=10-19", &byteRange{10, 20}, nil},
+		{"start", "bytes=10-", &byteRange{10, 100}, nil},
+		{"end after size", "bytes=10-200", &byteRange{10, 100}, nil},
+		{"suffix", "bytes=-30", &byteRange{70, 100}, nil},
+		{"suffix after size", "bytes=-300", &byteRange{0, 100}, nil},
+		{"multiple ranges", "bytes=0-1,5-6", nil, nil},
+		{"invalid", "bytes=a-b", nil, nil},
+		{"invalid unit", "items=0-1", nil, nil},
+		{"not satisfiable", "bytes=100-", nil, errRangeNotSatisfiable},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			r, err := parseByteRange(ca.header, 100)
+			require.Equal(t, ca.err, err)
+			require.Equal(t, ca.r, r)
+		})
+	}
+}
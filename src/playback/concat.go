@@ -0,0 +1,150 @@
+package playback
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+)
+
+// upper limit of the duration of concatenated segments.
+const concatMaxDuration = 100000 * time.Hour
+
+// initsAreCompatible checks whether two segments contain the same tracks,
+// with the same codecs. Timescales are allowed to differ.
+func initsAreCompatible(a *fmp4.Init, b *fmp4.Init) bool {
+	if len(a.Tracks) != len(b.Tracks) {
+		return false
+	}
+
+	for i, track := range a.Tracks {
+		if track.ID != b.Tracks[i].ID || !reflect.DeepEqual(track.Codec, b.Tracks[i].Codec) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// muxerRescaler is a muxer that converts timestamps of a segment
+// into the timescales of another segment.
+type muxerRescaler struct {
+	m   muxer
+	src *fmp4.Init
+	dst *fmp4.Init
+
+	srcTimeScale uint32
+	dstTimeScale uint32
+}
+
+func (w *muxerRescaler) writeInit(init *fmp4.Init) {
+	w.m.writeInit(init)
+}
+
+func (w *muxerRescaler) setTrack(trackID int) {
+	w.srcTimeScale = findInitTrack(w.src.Tracks, trackID).TimeScale
+	w.dstTimeScale = findInitTrack(w.dst.Tracks, trackID).TimeScale
+	w.m.setTrack(trackID)
+}
+
+func (w *muxerRescaler) convert(v int64) int64 {
+	return durationGoToMp4(durationMp4ToGo(v, w.srcTimeScale), w.dstTimeScale)
+}
+
+func (w *muxerRescaler) writeSample(
+	dts int64,
+	ptsOffset int32,
+	isNonSyncSample bool,
+	payloadSize uint32,
+	getPayload func() ([]byte, error),
+) error {
+	return w.m.writeSample(
+		w.convert(dts),
+		int32(w.convert(int64(ptsOffset))),
+		isNonSyncSample,
+		payloadSize,
+		getPayload)
+}
+
+func (w *muxerRescaler) writeFinalDTS(dts int64) {
+	w.m.writeFinalDTS(w.convert(dts))
+}
+
+func (w *muxerRescaler) dropSample() {
+	w.m.dropSample()
+}
+
+func (w *muxerRescaler) flush() error {
+	return w.m.flush()
+}
+
+type nilLogger struct{}
+
+func (nilLogger) Log(logger.Level, string, ...interface{}) {
+}
+
+// ConcatSegments writes segments into w, one after the other, in the MP4 format.
+// Timestamps of each segment continue from the end of the previous one,
+// regardless of the time elapsed between segments.
+// Segments must contain the same tracks; timestamps are converted
+// into the timescales of the first segment.
+func ConcatSegments(paths []string, w io.Writer) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("no segments provided")
+	}
+
+	m := &muxerMP4{
+		w:   w,
+		log: nilLogger{},
+	}
+
+	var firstInit *fmp4.Init
+	var offset time.Duration
+
+	for _, fpath := range paths {
+		err := func() error {
+			f, err := os.Open(fpath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			init, _, err := recordstore.FMP4ReadHeader(f)
+			if err != nil {
+				return fmt.Errorf("unable to read segment %s: %w", fpath, err)
+			}
+
+			var sm muxer = m
+
+			if firstInit == nil {
+				firstInit = init
+				m.writeInit(init)
+			} else {
+				if !initsAreCompatible(firstInit, init) {
+					return fmt.Errorf("segment %s is not compatible with previous segments", fpath)
+				}
+
+				if !reflect.DeepEqual(firstInit, init) {
+					sm = &muxerRescaler{
+						m:   m,
+						src: init,
+						dst: firstInit,
+					}
+				}
+			}
+
+			offset, err = segmentFMP4MuxParts(f, offset, concatMaxDuration, init, sm)
+			return err
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
+	return m.flush()
+}
@@ -0,0 +1,108 @@
+package playback
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/abema/go-mp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4/seekablebuffer"
+	"github.com/flynnletford/mediamtx/src/test"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSegmentTimeScale1000(t *testing.T, fpath string) {
+	init := fmp4.Init{
+		Tracks: []*fmp4.InitTrack{{
+			ID:        1,
+			TimeScale: 1000,
+			Codec: &fmp4.CodecH264{
+				SPS: test.FormatH264.SPS,
+				PPS: test.FormatH264.PPS,
+			},
+		}},
+	}
+
+	var buf seekablebuffer.Buffer
+	err := init.Marshal(&buf)
+	require.NoError(t, err)
+
+	parts := fmp4.Parts{{
+		SequenceNumber: 1,
+		Tracks: []*fmp4.PartTrack{{
+			ID:       1,
+			BaseTime: 0,
+			Samples: []*fmp4.PartSample{
+				{
+					Duration: 500,
+					Payload:  []byte{1},
+				},
+				{
+					Duration:        500,
+					IsNonSyncSample: true,
+					Payload:         []byte{2},
+				},
+			},
+		}},
+	}}
+	err = parts.Marshal(&buf)
+	require.NoError(t, err)
+
+	err = os.WriteFile(fpath, buf.Bytes(), 0o644)
+	require.NoError(t, err)
+}
+
+func TestConcatSegments(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-playback")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeSegment3(t, filepath.Join(dir, "seg1.mp4"))
+	writeSegmentTimeScale1000(t, filepath.Join(dir, "seg2.mp4"))
+	writeSegment3(t, filepath.Join(dir, "seg3.mp4"))
+
+	var buf bytes.Buffer
+	err = ConcatSegments([]string{
+		filepath.Join(dir, "seg1.mp4"),
+		filepath.Join(dir, "seg2.mp4"),
+		filepath.Join(dir, "seg3.mp4"),
+	}, &buf)
+	require.NoError(t, err)
+
+	boxes, err := mp4.ExtractBoxWithPayload(bytes.NewReader(buf.Bytes()), nil,
+		mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMinf(),
+			mp4.BoxTypeStbl(), mp4.BoxTypeStts()})
+	require.NoError(t, err)
+	require.Len(t, boxes, 1)
+
+	// timestamps of the second segment are converted into the timescale of the first one
+	require.Equal(t, []mp4.SttsEntry{{
+		SampleCount: 1,
+		SampleDelta: 90000,
+	}, {
+		SampleCount: 2,
+		SampleDelta: 45000,
+	}, {
+		SampleCount: 1,
+		SampleDelta: 90000,
+	}}, boxes[0].Payload.(*mp4.Stts).Entries)
+}
+
+func TestConcatSegmentsIncompatible(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-playback")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeSegment1(t, filepath.Join(dir, "seg1.mp4"))
+	writeSegment3(t, filepath.Join(dir, "seg2.mp4"))
+
+	var buf bytes.Buffer
+	err = ConcatSegments([]string{
+		filepath.Join(dir, "seg1.mp4"),
+		filepath.Join(dir, "seg2.mp4"),
+	}, &buf)
+	require.EqualError(t, err, "segment "+filepath.Join(dir, "seg2.mp4")+
+		" is not compatible with previous segments")
+}
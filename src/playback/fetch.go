@@ -0,0 +1,60 @@
+package playback
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+	"github.com/flynnletford/mediamtx/src/uploader"
+)
+
+// fetchSegments restores segments whose local copy has been removed, by downloading them
+// from the upload storage of the path.
+func fetchSegments(
+	ctx context.Context,
+	pathConf *conf.Path,
+	segments []*recordstore.Segment,
+	log logger.Writer,
+) error {
+	for _, seg := range segments {
+		if !seg.Remote {
+			continue
+		}
+
+		log.Log(logger.Debug, "fetching %s from the upload storage", seg.Fpath)
+
+		err := uploader.FetchSegment(ctx, pathConf, seg.Fpath)
+		if err != nil {
+			return fmt.Errorf("unable to fetch %s: %w", seg.Fpath, err)
+		}
+
+		seg.Remote = false
+	}
+
+	return nil
+}
+
+// findSegments returns segments of a path, fetching the ones that are available
+// in the upload storage only.
+func (s *Server) findSegments(
+	ctx context.Context,
+	pathConf *conf.Path,
+	pathName string,
+	start *time.Time,
+	end *time.Time,
+) ([]*recordstore.Segment, error) {
+	segments, err := recordstore.FindSegments(pathConf, pathName, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fetchSegments(ctx, pathConf, segments, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return segments, nil
+}
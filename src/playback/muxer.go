@@ -13,5 +13,6 @@ type muxer interface {
 		getPayload func() ([]byte, error),
 	) error
 	writeFinalDTS(dts int64)
+	dropSample()
 	flush() error
 }
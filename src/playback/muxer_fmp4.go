@@ -129,6 +129,10 @@ func (w *muxerFMP4) writeFinalDTS(dts int64) {
 	}
 }
 
+func (w *muxerFMP4) dropSample() {
+	// the gap is covered by the duration of the previous sample
+}
+
 func (w *muxerFMP4) innerFlush(final bool) error {
 	var part fmp4.Part
 
@@ -1,15 +1,36 @@
 package playback
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
 	"io"
+	"time"
 
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/pmp4"
+	"github.com/flynnletford/mediamtx/src/logger"
 )
 
+// timescale of the movie header and of edit lists, set by pmp4.
+const movieTimeScale = 1000
+
 type muxerMP4Track struct {
 	pmp4.Track
-	lastDTS int64
+	lastDTS         int64
+	maxPTS          int64
+	sourceTimeScale uint32
+
+	// media time of the first presented sample,
+	// that is written into the edit list.
+	editMediaTime int32
+}
+
+func (t *muxerMP4Track) convertTimestamp(v int64) int64 {
+	if t.TimeScale == t.sourceTimeScale {
+		return v
+	}
+	return durationGoToMp4(durationMp4ToGo(v, t.sourceTimeScale), t.TimeScale)
 }
 
 func findTrackMP4(tracks []*muxerMP4Track, id int) *muxerMP4Track {
@@ -22,22 +43,45 @@ func findTrackMP4(tracks []*muxerMP4Track, id int) *muxerMP4Track {
 }
 
 type muxerMP4 struct {
-	w io.Writer
+	w   io.Writer
+	log logger.Writer
+
+	// timescale of all tracks.
+	// if zero, the timescale of the recording is used.
+	timeScale uint32
+
+	// maximum duration of the presentation, enforced through edit lists.
+	// if zero, the presentation ends with the last sample.
+	maxDuration time.Duration
+
+	// optional; called with the size of the file before writing it.
+	// It returns the byte range to write, or nil to write the entire file.
+	onSize func(size uint64) (*byteRange, error)
+	rw     *rangeWriter
 
 	tracks   []*muxerMP4Track
 	curTrack *muxerMP4Track
+
+	droppedCount    int
+	unreadableCount int
 }
 
 func (w *muxerMP4) writeInit(init *fmp4.Init) {
 	w.tracks = make([]*muxerMP4Track, len(init.Tracks))
 
 	for i, track := range init.Tracks {
+		timeScale := track.TimeScale
+		if w.timeScale != 0 {
+			timeScale = w.timeScale
+		}
+
 		w.tracks[i] = &muxerMP4Track{
 			Track: pmp4.Track{
 				ID:        track.ID,
-				TimeScale: track.TimeScale,
+				TimeScale: timeScale,
 				Codec:     track.Codec,
 			},
+			sourceTimeScale: track.TimeScale,
 		}
 	}
 }
@@ -53,14 +97,32 @@ func (w *muxerMP4) writeSample(
 	payloadSize uint32,
 	getPayload func() ([]byte, error),
 ) error {
-	// remove GOPs before the GOP of the first frame
-	if (dts < 0 || (dts >= 0 && w.curTrack.lastDTS < 0)) && !isNonSyncSample {
+	// track is not in the init
+	if w.curTrack == nil {
+		w.droppedCount++
+		return nil
+	}
+
+	dts = w.curTrack.convertTimestamp(dts)
+	ptsOffset = int32(w.curTrack.convertTimestamp(int64(ptsOffset)))
+
+	pts := dts + int64(ptsOffset)
+
+	// remove GOPs before the GOP of the first frame.
+	// The decision is based on PTS instead of DTS, since frames of previous GOPs
+	// may be presented after the start when B-frames are present.
+	if !isNonSyncSample && w.curTrack.Samples != nil && w.curTrack.maxPTS < 0 {
 		w.curTrack.Samples = nil
 	}
 
 	if w.curTrack.Samples == nil {
-		w.curTrack.TimeOffset = int32(dts)
+		w.curTrack.setTimeOffset(dts, ptsOffset)
+		w.curTrack.maxPTS = pts
 	} else {
+		if pts > w.curTrack.maxPTS {
+			w.curTrack.maxPTS = pts
+		}
+
 		diff := dts - w.curTrack.lastDTS
 		if diff < 0 {
 			diff = 0
@@ -68,23 +130,62 @@ func (w *muxerMP4) writeSample(
 		w.curTrack.Samples[len(w.curTrack.Samples)-1].Duration = uint32(diff)
 	}
 
-	// prevent warning "edit list: 1 Missing key frame while searching for timestamp: 0"
-	if !isNonSyncSample {
-		ptsOffset = 0
-	}
-
 	w.curTrack.Samples = append(w.curTrack.Samples, &pmp4.Sample{
 		PTSOffset:       ptsOffset,
 		IsNonSyncSample: isNonSyncSample,
 		PayloadSize:     payloadSize,
-		GetPayload:      getPayload,
+		GetPayload: func() ([]byte, error) {
+			// payload is not going to be written
+			if w.rw != nil && w.rw.isOutside(payloadSize) {
+				return make([]byte, payloadSize), nil
+			}
+
+			// sample tables have already been written when payloads are read,
+			// therefore unreadable samples are replaced with zeros.
+			payload, err := getPayload()
+			if err != nil || len(payload) != int(payloadSize) {
+				w.unreadableCount++
+				return make([]byte, payloadSize), nil //nolint:nilerr
+			}
+			return payload, nil
+		},
 	})
 	w.curTrack.lastDTS = dts
 
 	return nil
 }
 
+// setTimeOffset fills the edit list in order to present the first sample at its PTS.
+// The edit list starts from the PTS of the first sample, instead of its DTS,
+// in order to prevent warning "edit list: 1 Missing key frame while searching for timestamp: 0"
+// when the first sample has a PTS offset (B-frames).
+func (t *muxerMP4Track) setTimeOffset(dts int64, ptsOffset int32) {
+	presentationStart := dts + int64(ptsOffset)
+	if presentationStart < 0 {
+		presentationStart = 0
+	}
+
+	mediaTime := int32(presentationStart - dts)
+
+	// pmp4 supports either an initial pause followed by the media from its beginning,
+	// or the media from a given time. In the first case, the media time is set by patchEditLists.
+	if presentationStart > 0 {
+		t.TimeOffset = int32(presentationStart)
+		t.editMediaTime = mediaTime
+	} else {
+		t.TimeOffset = -mediaTime
+		t.editMediaTime = 0
+	}
+}
+
 func (w *muxerMP4) writeFinalDTS(dts int64) {
+	// track did not receive any sample
+	if w.curTrack == nil || len(w.curTrack.Samples) == 0 {
+		return
+	}
+
+	dts = w.curTrack.convertTimestamp(dts)
+
 	diff := dts - w.curTrack.lastDTS
 	if diff < 0 {
 		diff = 0
@@ -92,14 +193,205 @@ func (w *muxerMP4) writeFinalDTS(dts int64) {
 	w.curTrack.Samples[len(w.curTrack.Samples)-1].Duration = uint32(diff)
 }
 
+// dropSample marks a sample as damaged.
+// The gap is covered by the duration of the previous sample.
+func (w *muxerMP4) dropSample() {
+	w.droppedCount++
+}
+
 func (w *muxerMP4) flush() error {
+	err := w.innerFlush()
+
+	if w.droppedCount != 0 {
+		w.log.Log(logger.Warn, "%d damaged samples have been dropped", w.droppedCount)
+	}
+	if w.unreadableCount != 0 {
+		w.log.Log(logger.Warn, "%d unreadable samples have been replaced with zeros", w.unreadableCount)
+	}
+
+	return err
+}
+
+func (w *muxerMP4) innerFlush() error {
 	h := pmp4.Presentation{
 		Tracks: make([]*pmp4.Track, len(w.tracks)),
 	}
 
+	patch := w.maxDuration != 0
+	var bodySize uint64
+
 	for i, track := range w.tracks {
 		h.Tracks[i] = &track.Track
+
+		if track.editMediaTime != 0 {
+			patch = true
+		}
+
+		for _, sa := range track.Samples {
+			bodySize += uint64(sa.PayloadSize)
+		}
+	}
+
+	out := w.w
+
+	if w.onSize != nil {
+		w.rw = &rangeWriter{
+			w:        w.w,
+			bodySize: 8 + bodySize, // mdat header and payloads
+			onSize:   w.onSize,
+		}
+		out = w.rw
+	}
+
+	if !patch {
+		return h.Marshal(out)
+	}
+
+	pw := &editListPatcher{
+		w:           out,
+		tracks:      w.tracks,
+		maxDuration: durationGoToMp4(w.maxDuration, movieTimeScale),
+	}
+
+	return h.Marshal(pw)
+}
+
+// editListPatcher is a io.Writer that sets the media time of edit lists
+// and limits their duration.
+// Presentation.Marshal() writes ftyp and moov with a single Write(),
+// therefore boxes are patched in the first Write().
+type editListPatcher struct {
+	w           io.Writer
+	tracks      []*muxerMP4Track
+	maxDuration int64
+
+	done bool
+}
+
+func (p *editListPatcher) Write(buf []byte) (int, error) {
+	if !p.done {
+		p.done = true
+
+		buf = append([]byte(nil), buf...)
+
+		err := p.patchBoxes(buf, "")
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return p.w.Write(buf)
+}
+
+func (p *editListPatcher) patchBoxes(buf []byte, parent string) error {
+	var track *muxerMP4Track
+
+	for len(buf) != 0 {
+		if len(buf) < 8 {
+			return fmt.Errorf("invalid box")
+		}
+
+		size := int(binary.BigEndian.Uint32(buf[:4]))
+		typ := string(buf[4:8])
+
+		if size < 8 || size > len(buf) {
+			return fmt.Errorf("invalid size of box '%s'", typ)
+		}
+
+		content := buf[8:size]
+
+		switch {
+		case typ == "moov" && parent == "":
+			return p.patchBoxes(content, typ)
+
+		case typ == "trak" && parent == "moov":
+			err := p.patchBoxes(content, typ)
+			if err != nil {
+				return err
+			}
+
+		case typ == "tkhd" && parent == "trak":
+			if len(content) < 16 || content[0] != 0 {
+				return fmt.Errorf("unsupported tkhd box")
+			}
+			track = findTrackMP4(p.tracks, int(binary.BigEndian.Uint32(content[12:16])))
+
+		case typ == "edts" && parent == "trak":
+			if track != nil {
+				err := track.patchEditList(content, p.maxDuration)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		buf = buf[size:]
+	}
+
+	return nil
+}
+
+func (t *muxerMP4Track) patchEditList(edts []byte, maxDuration int64) error {
+	if len(edts) < 8 || !bytes.Equal(edts[4:8], []byte("elst")) {
+		return fmt.Errorf("elst box not found")
+	}
+
+	elst := edts[8:]
+
+	// version 0, one or two entries of 12 bytes
+	if len(elst) < 8 || elst[0] != 0 {
+		return fmt.Errorf("unsupported elst box")
+	}
+	count := int(binary.BigEndian.Uint32(elst[4:8]))
+	if (count != 1 && count != 2) || len(elst) < 8+count*12 {
+		return fmt.Errorf("unsupported elst box")
+	}
+
+	entry := elst[8+(count-1)*12:]
+
+	if t.editMediaTime != 0 {
+		if count != 2 {
+			return fmt.Errorf("unsupported elst box")
+		}
+		t.patchEditMediaTime(entry)
+	}
+
+	if maxDuration != 0 {
+		pause := int64(0)
+		if count == 2 {
+			pause = int64(binary.BigEndian.Uint32(elst[8:12]))
+		}
+
+		segmentDuration := int64(binary.BigEndian.Uint32(entry[0:4]))
+		if (pause + segmentDuration) > maxDuration {
+			segmentDuration = maxDuration - pause
+			if segmentDuration < 0 {
+				segmentDuration = 0
+			}
+			binary.BigEndian.PutUint32(entry[0:4], uint32(segmentDuration))
+		}
+	}
+
+	return nil
+}
+
+func (t *muxerMP4Track) patchEditMediaTime(entry []byte) {
+	// the presentation ends with the sample with the greatest PTS
+	dts := int64(0)
+	end := int64(0)
+	for _, sa := range t.Samples {
+		sampleEnd := dts + int64(sa.PTSOffset) + int64(sa.Duration)
+		if sampleEnd > end {
+			end = sampleEnd
+		}
+		dts += int64(sa.Duration)
+	}
+
+	segmentDuration := (end - int64(t.editMediaTime)) * movieTimeScale / int64(t.TimeScale)
+	if segmentDuration < 0 {
+		segmentDuration = 0
 	}
 
-	return h.Marshal(w.w)
+	binary.BigEndian.PutUint32(entry[0:4], uint32(segmentDuration))
+	binary.BigEndian.PutUint32(entry[4:8], uint32(t.editMediaTime))
 }
@@ -0,0 +1,327 @@
+package playback
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/abema/go-mp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMuxerMP4EditList(t *testing.T) {
+	for _, ca := range []struct {
+		name      string
+		dts       int64
+		timeScale uint32
+		entries   []mp4.ElstEntry
+		ctts      []mp4.CttsEntry
+	}{
+		{
+			"negative dts",
+			-3000,
+			0,
+			[]mp4.ElstEntry{
+				{
+					SegmentDurationV0: 33,
+					MediaTimeV0:       -1,
+					MediaRateInteger:  1,
+				},
+				{
+					SegmentDurationV0: 66,
+					MediaTimeV0:       6000,
+					MediaRateInteger:  1,
+				},
+			},
+			[]mp4.CttsEntry{
+				{SampleCount: 1, SampleOffsetV0: 6000},
+				{SampleCount: 1, SampleOffsetV0: 0},
+				{SampleCount: 1, SampleOffsetV0: 3000},
+			},
+		},
+		{
+			"positive dts",
+			9000,
+			0,
+			[]mp4.ElstEntry{
+				{
+					SegmentDurationV0: 166,
+					MediaTimeV0:       -1,
+					MediaRateInteger:  1,
+				},
+				{
+					SegmentDurationV0: 66,
+					MediaTimeV0:       6000,
+					MediaRateInteger:  1,
+				},
+			},
+			[]mp4.CttsEntry{
+				{SampleCount: 1, SampleOffsetV0: 6000},
+				{SampleCount: 1, SampleOffsetV0: 0},
+				{SampleCount: 1, SampleOffsetV0: 3000},
+			},
+		},
+		{
+			"custom timescale",
+			9000,
+			1000,
+			[]mp4.ElstEntry{
+				{
+					SegmentDurationV0: 166,
+					MediaTimeV0:       -1,
+					MediaRateInteger:  1,
+				},
+				{
+					SegmentDurationV0: 67,
+					MediaTimeV0:       66,
+					MediaRateInteger:  1,
+				},
+			},
+			[]mp4.CttsEntry{
+				{SampleCount: 1, SampleOffsetV0: 66},
+				{SampleCount: 1, SampleOffsetV0: 0},
+				{SampleCount: 1, SampleOffsetV0: 33},
+			},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			m := &muxerMP4{
+				w:         &buf,
+				timeScale: ca.timeScale,
+			}
+
+			m.writeInit(&fmp4.Init{
+				Tracks: []*fmp4.InitTrack{{
+					ID:        1,
+					TimeScale: 90000,
+					Codec: &fmp4.CodecH264{
+						SPS: test.FormatH264.SPS,
+						PPS: test.FormatH264.PPS,
+					},
+				}},
+			})
+			m.setTrack(1)
+
+			// I-frame followed by a B-frame and a P-frame
+			for i, ptsOffset := range []int32{6000, 0, 3000} {
+				err := m.writeSample(ca.dts+int64(i)*3000, ptsOffset, i != 0, 2,
+					func() ([]byte, error) { return []byte{1, 2}, nil })
+				require.NoError(t, err)
+			}
+
+			m.writeFinalDTS(ca.dts + 3*3000)
+
+			err := m.flush()
+			require.NoError(t, err)
+
+			boxes, err := mp4.ExtractBoxWithPayload(bytes.NewReader(buf.Bytes()), nil,
+				mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeEdts(), mp4.BoxTypeElst()})
+			require.NoError(t, err)
+			require.Equal(t, ca.entries, boxes[0].Payload.(*mp4.Elst).Entries)
+
+			boxes, err = mp4.ExtractBoxWithPayload(bytes.NewReader(buf.Bytes()), nil, mp4.BoxPath{
+				mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(),
+				mp4.BoxTypeMinf(), mp4.BoxTypeStbl(), mp4.BoxTypeCtts(),
+			})
+			require.NoError(t, err)
+			require.Equal(t, ca.ctts, boxes[0].Payload.(*mp4.Ctts).Entries)
+		})
+	}
+}
+
+func TestMuxerMP4PreviousGOP(t *testing.T) {
+	for _, ca := range []struct {
+		name       string
+		ptsOffsets []int32
+		samples    int
+	}{
+		{
+			"previous gop presented before start",
+			[]int32{0, 0, 0},
+			1,
+		},
+		{
+			// the P-frame is presented after the start
+			"previous gop presented after start",
+			[]int32{3000, 9000, 0},
+			4,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			m := &muxerMP4{
+				w: &buf,
+			}
+
+			m.writeInit(&fmp4.Init{
+				Tracks: []*fmp4.InitTrack{{
+					ID:        1,
+					TimeScale: 90000,
+					Codec: &fmp4.CodecH264{
+						SPS: test.FormatH264.SPS,
+						PPS: test.FormatH264.PPS,
+					},
+				}},
+			})
+			m.setTrack(1)
+
+			// previous GOP
+			for i, ptsOffset := range ca.ptsOffsets {
+				err := m.writeSample(-9000+int64(i)*3000, ptsOffset, i != 0, 2,
+					func() ([]byte, error) { return []byte{1, 2}, nil })
+				require.NoError(t, err)
+			}
+
+			// next GOP, that starts from the start
+			err := m.writeSample(0, 3000, false, 2,
+				func() ([]byte, error) { return []byte{1, 2}, nil })
+			require.NoError(t, err)
+
+			m.writeFinalDTS(3000)
+
+			err = m.flush()
+			require.NoError(t, err)
+
+			boxes, err := mp4.ExtractBoxWithPayload(bytes.NewReader(buf.Bytes()), nil, mp4.BoxPath{
+				mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(),
+				mp4.BoxTypeMinf(), mp4.BoxTypeStbl(), mp4.BoxTypeStsz(),
+			})
+			require.NoError(t, err)
+			require.Equal(t, uint32(ca.samples), boxes[0].Payload.(*mp4.Stsz).SampleCount)
+		})
+	}
+}
+
+func TestMuxerMP4DamagedSamples(t *testing.T) {
+	var buf bytes.Buffer
+	var logs []string
+
+	m := &muxerMP4{
+		w: &buf,
+		log: test.Logger(func(_ logger.Level, format string, args ...interface{}) {
+			logs = append(logs, fmt.Sprintf(format, args...))
+		}),
+	}
+
+	m.writeInit(&fmp4.Init{
+		Tracks: []*fmp4.InitTrack{{
+			ID:        1,
+			TimeScale: 90000,
+			Codec: &fmp4.CodecH264{
+				SPS: test.FormatH264.SPS,
+				PPS: test.FormatH264.PPS,
+			},
+		}},
+	})
+
+	m.setTrack(1)
+
+	err := m.writeSample(0, 0, false, 2, func() ([]byte, error) {
+		return []byte{1, 2}, nil
+	})
+	require.NoError(t, err)
+
+	m.dropSample()
+
+	err = m.writeSample(6000, 0, true, 2, func() ([]byte, error) {
+		return nil, fmt.Errorf("partial read")
+	})
+	require.NoError(t, err)
+
+	m.writeFinalDTS(9000)
+
+	m.setTrack(2)
+
+	err = m.writeSample(0, 0, false, 2, func() ([]byte, error) {
+		return []byte{1, 2}, nil
+	})
+	require.NoError(t, err)
+
+	m.writeFinalDTS(3000)
+
+	err = m.flush()
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		"2 damaged samples have been dropped",
+		"1 unreadable samples have been replaced with zeros",
+	}, logs)
+
+	boxes, err := mp4.ExtractBoxWithPayload(bytes.NewReader(buf.Bytes()), nil, mp4.BoxPath{
+		mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(),
+		mp4.BoxTypeMinf(), mp4.BoxTypeStbl(), mp4.BoxTypeStts(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []mp4.SttsEntry{
+		{SampleCount: 1, SampleDelta: 6000},
+		{SampleCount: 1, SampleDelta: 3000},
+	}, boxes[0].Payload.(*mp4.Stts).Entries)
+
+	require.Equal(t, []byte{1, 2, 0, 0}, buf.Bytes()[buf.Len()-4:])
+}
+
+func TestMuxerMP4NoSamples(t *testing.T) {
+	m := &muxerMP4{w: &bytes.Buffer{}}
+
+	m.writeInit(&fmp4.Init{
+		Tracks: []*fmp4.InitTrack{{
+			ID:        1,
+			TimeScale: 90000,
+			Codec: &fmp4.CodecH264{
+				SPS: test.FormatH264.SPS,
+				PPS: test.FormatH264.PPS,
+			},
+		}},
+	})
+	m.setTrack(1)
+
+	m.writeFinalDTS(3000)
+}
+
+func TestMuxerMP4MaxDuration(t *testing.T) {
+	var buf bytes.Buffer
+
+	m := &muxerMP4{
+		w:           &buf,
+		maxDuration: 50 * time.Millisecond,
+	}
+
+	m.writeInit(&fmp4.Init{
+		Tracks: []*fmp4.InitTrack{{
+			ID:        1,
+			TimeScale: 90000,
+			Codec: &fmp4.CodecH264{
+				SPS: test.FormatH264.SPS,
+				PPS: test.FormatH264.PPS,
+			},
+		}},
+	})
+	m.setTrack(1)
+
+	for i := 0; i < 3; i++ {
+		err := m.writeSample(-3000+int64(i)*3000, 0, i != 0, 2,
+			func() ([]byte, error) { return []byte{1, 2}, nil })
+		require.NoError(t, err)
+	}
+
+	m.writeFinalDTS(-3000 + 3*3000)
+
+	err := m.flush()
+	require.NoError(t, err)
+
+	boxes, err := mp4.ExtractBoxWithPayload(bytes.NewReader(buf.Bytes()), nil,
+		mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeEdts(), mp4.BoxTypeElst()})
+	require.NoError(t, err)
+	require.Equal(t, []mp4.ElstEntry{{
+		SegmentDurationV0: 50,
+		MediaTimeV0:       3000,
+		MediaRateInteger:  1,
+	}}, boxes[0].Payload.(*mp4.Elst).Entries)
+}
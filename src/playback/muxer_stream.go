@@ -0,0 +1,180 @@
+package playback
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/flynnletford/mediamtx/src/stream"
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+type muxerStreamSample struct {
+	track   *recordingStreamTrack
+	dts     time.Duration
+	pts     time.Duration
+	payload []byte
+}
+
+type muxerStreamTrack struct {
+	*recordingStreamTrack
+
+	// samples that precede the requested start, starting from the last sync sample.
+	preStart []*muxerStreamSample
+}
+
+// muxerStream is a muxer that writes samples into a stream, in real time.
+// Samples of different tracks are interleaved by decoding timestamp within each part.
+// Samples that precede the requested start are written starting from the last sync sample,
+// in order to allow readers to decode the first frame.
+type muxerStream struct {
+	ctx    context.Context
+	start  time.Time
+	tracks []*recordingStreamTrack
+	stream *stream.Stream
+
+	muxerTracks   map[int]*muxerStreamTrack
+	curTrack      *muxerStreamTrack
+	partTracks    map[int]struct{}
+	samples       []*muxerStreamSample
+	err           error
+	startWritten  bool
+	firstDTS      time.Duration
+	firstWallTime time.Time
+}
+
+func (w *muxerStream) writeInit(_ *fmp4.Init) {
+	// tracks have already been created from the initialization section of the first segment.
+	if w.muxerTracks == nil {
+		w.muxerTracks = make(map[int]*muxerStreamTrack)
+		for _, track := range w.tracks {
+			w.muxerTracks[track.id] = &muxerStreamTrack{recordingStreamTrack: track}
+		}
+		w.partTracks = make(map[int]struct{})
+	}
+}
+
+func (w *muxerStream) setTrack(trackID int) {
+	// a track that has already been seen means that a new part has started.
+	if _, ok := w.partTracks[trackID]; ok {
+		w.partTracks = make(map[int]struct{})
+		w.curTrack = nil
+
+		// errors are returned by the next call to writeSample or flush.
+		w.err = w.innerFlush(false)
+	}
+
+	w.partTracks[trackID] = struct{}{}
+	w.curTrack = w.muxerTracks[trackID]
+}
+
+func (w *muxerStream) writeSample(
+	dts int64,
+	ptsOffset int32,
+	isNonSyncSample bool,
+	_ uint32,
+	getPayload func() ([]byte, error),
+) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	// track is not supported
+	if w.curTrack == nil {
+		return nil
+	}
+
+	pl, err := getPayload()
+	if err != nil {
+		return err
+	}
+
+	sample := &muxerStreamSample{
+		track:   w.curTrack.recordingStreamTrack,
+		dts:     durationMp4ToGo(dts, w.curTrack.timeScale),
+		pts:     durationMp4ToGo(dts+int64(ptsOffset), w.curTrack.timeScale),
+		payload: pl,
+	}
+
+	if dts < 0 {
+		if !isNonSyncSample {
+			w.curTrack.preStart = []*muxerStreamSample{sample}
+		} else if w.curTrack.preStart != nil {
+			w.curTrack.preStart = append(w.curTrack.preStart, sample)
+		}
+		return nil
+	}
+
+	w.samples = append(w.samples, sample)
+	return nil
+}
+
+func (w *muxerStream) writeFinalDTS(_ int64) {
+}
+
+func (w *muxerStream) dropSample() {
+}
+
+func (w *muxerStream) innerFlush(final bool) error {
+	if !w.startWritten {
+		// wait until samples that follow the start are available,
+		// since a following sync sample may still replace the pre-start ones.
+		if len(w.samples) == 0 && !final {
+			return nil
+		}
+
+		for _, track := range w.muxerTracks {
+			w.samples = append(w.samples, track.preStart...)
+			track.preStart = nil
+		}
+		w.startWritten = true
+	}
+
+	if len(w.samples) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(w.samples, func(i, j int) bool {
+		return w.samples[i].dts < w.samples[j].dts
+	})
+
+	if w.firstWallTime.IsZero() {
+		w.firstDTS = w.samples[0].dts
+		w.firstWallTime = time.Now()
+	}
+
+	for _, sample := range w.samples {
+		wait := time.Until(w.firstWallTime.Add(sample.dts - w.firstDTS))
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-w.ctx.Done():
+				return fmt.Errorf("terminated")
+			}
+		}
+
+		u, err := sample.track.newUnit(unit.Base{
+			NTP: w.start.Add(sample.pts),
+			PTS: durationGoToMp4(sample.pts, uint32(sample.track.format.ClockRate())),
+		}, sample.payload)
+		if err != nil {
+			return err
+		}
+
+		w.stream.WriteUnit(sample.track.media, sample.track.format, u)
+	}
+
+	w.samples = w.samples[:0]
+
+	return nil
+}
+
+func (w *muxerStream) flush() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	return w.innerFlush(true)
+}
@@ -0,0 +1,90 @@
+package playback
+
+import (
+	"fmt"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+)
+
+// muxerTrickPlay is a muxer that writes key frames of video tracks only,
+// with timestamps divided by a speed factor, in order to allow fast-forward scrubbing.
+// Durations of key frames are extended up to the next key frame.
+type muxerTrickPlay struct {
+	m     muxer
+	speed float64
+
+	tracks     map[int]struct{}
+	skipTrack  bool
+	trackCount int
+}
+
+func (w *muxerTrickPlay) writeInit(init *fmp4.Init) {
+	w.tracks = make(map[int]struct{})
+
+	var tracks []*fmp4.InitTrack
+
+	for _, track := range init.Tracks {
+		if track.Codec.IsVideo() {
+			w.tracks[track.ID] = struct{}{}
+			tracks = append(tracks, track)
+		}
+	}
+
+	w.trackCount = len(tracks)
+
+	w.m.writeInit(&fmp4.Init{
+		Tracks: tracks,
+	})
+}
+
+func (w *muxerTrickPlay) setTrack(trackID int) {
+	_, ok := w.tracks[trackID]
+	w.skipTrack = !ok
+
+	if !w.skipTrack {
+		w.m.setTrack(trackID)
+	}
+}
+
+func (w *muxerTrickPlay) scale(v int64) int64 {
+	return int64(float64(v) / w.speed)
+}
+
+func (w *muxerTrickPlay) writeSample(
+	dts int64,
+	ptsOffset int32,
+	isNonSyncSample bool,
+	payloadSize uint32,
+	getPayload func() ([]byte, error),
+) error {
+	if w.skipTrack || isNonSyncSample {
+		return nil
+	}
+
+	return w.m.writeSample(
+		w.scale(dts),
+		int32(w.scale(int64(ptsOffset))),
+		false,
+		payloadSize,
+		getPayload)
+}
+
+func (w *muxerTrickPlay) writeFinalDTS(dts int64) {
+	if !w.skipTrack {
+		w.m.writeFinalDTS(w.scale(dts))
+	}
+}
+
+func (w *muxerTrickPlay) dropSample() {
+	if !w.skipTrack {
+		w.m.dropSample()
+	}
+}
+
+func (w *muxerTrickPlay) flush() error {
+	if w.trackCount == 0 {
+		return fmt.Errorf("recording doesn't contain any video track")
+	}
+
+	return w.m.flush()
+}
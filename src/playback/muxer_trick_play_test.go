@@ -0,0 +1,139 @@
+package playback
+
+import (
+	"testing"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/flynnletford/mediamtx/src/test"
+	"github.com/stretchr/testify/require"
+)
+
+type testMuxerSample struct {
+	trackID   int
+	dts       int64
+	ptsOffset int32
+}
+
+type testMuxer struct {
+	init     *fmp4.Init
+	curTrack int
+	samples  []testMuxerSample
+	finalDTS []int64
+}
+
+func (m *testMuxer) writeInit(init *fmp4.Init) {
+	m.init = init
+}
+
+func (m *testMuxer) setTrack(trackID int) {
+	m.curTrack = trackID
+}
+
+func (m *testMuxer) writeSample(
+	dts int64,
+	ptsOffset int32,
+	_ bool,
+	_ uint32,
+	_ func() ([]byte, error),
+) error {
+	m.samples = append(m.samples, testMuxerSample{
+		trackID:   m.curTrack,
+		dts:       dts,
+		ptsOffset: ptsOffset,
+	})
+	return nil
+}
+
+func (m *testMuxer) writeFinalDTS(dts int64) {
+	m.finalDTS = append(m.finalDTS, dts)
+}
+
+func (m *testMuxer) dropSample() {
+}
+
+func (m *testMuxer) flush() error {
+	return nil
+}
+
+func TestMuxerTrickPlay(t *testing.T) {
+	tm := &testMuxer{}
+	m := &muxerTrickPlay{
+		m:     tm,
+		speed: 4,
+	}
+
+	m.writeInit(&fmp4.Init{
+		Tracks: []*fmp4.InitTrack{
+			{
+				ID:        1,
+				TimeScale: 90000,
+				Codec: &fmp4.CodecH264{
+					SPS: test.FormatH264.SPS,
+					PPS: test.FormatH264.PPS,
+				},
+			},
+			{
+				ID:        2,
+				TimeScale: 48000,
+				Codec: &fmp4.CodecMPEG4Audio{
+					Config: mpeg4audio.Config{
+						Type:         mpeg4audio.ObjectTypeAACLC,
+						SampleRate:   48000,
+						ChannelCount: 2,
+					},
+				},
+			},
+		},
+	})
+
+	require.Len(t, tm.init.Tracks, 1)
+	require.Equal(t, 1, tm.init.Tracks[0].ID)
+
+	m.setTrack(1)
+
+	for i, nonSync := range []bool{false, true, true, false, true} {
+		err := m.writeSample(int64(i)*90000, 9000, nonSync, 1, nil)
+		require.NoError(t, err)
+	}
+	m.writeFinalDTS(5 * 90000)
+
+	m.setTrack(2)
+
+	err := m.writeSample(0, 0, false, 1, nil)
+	require.NoError(t, err)
+	m.writeFinalDTS(1024)
+
+	require.Equal(t, []testMuxerSample{
+		{trackID: 1, dts: 0, ptsOffset: 2250},
+		{trackID: 1, dts: 67500, ptsOffset: 2250},
+	}, tm.samples)
+	require.Equal(t, []int64{112500}, tm.finalDTS)
+
+	err = m.flush()
+	require.NoError(t, err)
+}
+
+func TestMuxerTrickPlayNoVideo(t *testing.T) {
+	m := &muxerTrickPlay{
+		m:     &testMuxer{},
+		speed: 4,
+	}
+
+	m.writeInit(&fmp4.Init{
+		Tracks: []*fmp4.InitTrack{{
+			ID:        1,
+			TimeScale: 48000,
+			Codec: &fmp4.CodecMPEG4Audio{
+				Config: mpeg4audio.Config{
+					Type:         mpeg4audio.ObjectTypeAACLC,
+					SampleRate:   48000,
+					ChannelCount: 2,
+				},
+			},
+		}},
+	})
+
+	err := m.flush()
+	require.EqualError(t, err, "recording doesn't contain any video track")
+}
@@ -0,0 +1,364 @@
+package playback
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+	"github.com/gin-gonic/gin"
+)
+
+const bundleManifestName = "manifest.json"
+
+// bundleFile is a recording segment contained in a bundle.
+type bundleFile struct {
+	Name     string             `json:"name"`
+	Path     string             `json:"path"`
+	Start    time.Time          `json:"start"`
+	Duration *listEntryDuration `json:"duration,omitempty"`
+	Size     int64              `json:"size"`
+	SHA256   string             `json:"sha256"`
+
+	fpath string
+}
+
+// bundleManifest describes the content of a bundle.
+type bundleManifest struct {
+	Created time.Time     `json:"created"`
+	Start   time.Time     `json:"start"`
+	End     time.Time     `json:"end"`
+	Paths   []string      `json:"paths"`
+	Files   []*bundleFile `json:"files"`
+}
+
+// bundleArchive is an archive format.
+type bundleArchive interface {
+	create(name string, size int64, modTime time.Time) (io.Writer, error)
+	Close() error
+}
+
+type bundleArchiveZIP struct {
+	*zip.Writer
+}
+
+func (a *bundleArchiveZIP) create(name string, _ int64, modTime time.Time) (io.Writer, error) {
+	// segments are already compressed, therefore they are stored as they are.
+	return a.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: modTime,
+	})
+}
+
+type bundleArchiveTAR struct {
+	*tar.Writer
+}
+
+func (a *bundleArchiveTAR) create(name string, size int64, modTime time.Time) (io.Writer, error) {
+	err := a.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a.Writer, nil
+}
+
+func newBundleArchive(format string, w io.Writer) (bundleArchive, error) {
+	switch format {
+	case "", "zip":
+		return &bundleArchiveZIP{zip.NewWriter(w)}, nil
+
+	case "tar":
+		return &bundleArchiveTAR{tar.NewWriter(w)}, nil
+
+	default:
+		return nil, fmt.Errorf("invalid format: %s", format)
+	}
+}
+
+func bundleContentType(format string) string {
+	if format == "tar" {
+		return "application/x-tar"
+	}
+	return "application/zip"
+}
+
+func bundleFileName(start time.Time, format string) string {
+	if format == "" {
+		format = "zip"
+	}
+	return "bundle_" + start.Format("2006-01-02_15-04-05") + "." + format
+}
+
+// Bundle writes all the recording segments of the given paths that overlap
+// the given time range into w, in a ZIP or TAR archive, together with a manifest
+// that contains the SHA-256 checksum of each segment.
+// Segments are copied without modifications, therefore they may start before start
+// and end after end.
+func (s *Server) Bundle(pathNames []string, start time.Time, end time.Time, format string, w io.Writer) error {
+	// the server may not be initialized, therefore a dedicated index is used.
+	index := &segmentIndex{}
+	index.initialize()
+
+	return s.bundle(context.Background(), index, pathNames, start, end, format, w)
+}
+
+// bundleSegments returns the segments of a path that overlap the time range.
+func (s *Server) bundleSegments(
+	ctx context.Context,
+	index *segmentIndex,
+	pathConf *conf.Path,
+	pathName string,
+	start time.Time,
+	end time.Time,
+) ([]*bundleFile, error) {
+	segments, err := s.findSegments(ctx, pathConf, pathName, &start, &end)
+	if err != nil {
+		// paths that have never been recorded are included into the manifest, without files.
+		if errors.Is(err, recordstore.ErrNoSegmentsFound) || errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []*bundleFile
+
+	// the duration of MPEG-TS segments is unknown.
+	if pathConf.RecordFormat != conf.RecordFormatFMP4 {
+		for _, seg := range segments {
+			files = append(files, &bundleFile{
+				Name:  pathName + "/" + filepath.Base(seg.Fpath),
+				Path:  pathName,
+				Start: seg.Start,
+				fpath: seg.Fpath,
+			})
+		}
+		return files, nil
+	}
+
+	for _, seg := range index.parseSegments(segments, s) {
+		if !seg.start.Add(seg.duration).After(start) {
+			continue
+		}
+
+		d := listEntryDuration(seg.duration)
+		files = append(files, &bundleFile{
+			Name:     pathName + "/" + filepath.Base(seg.fpath),
+			Path:     pathName,
+			Start:    seg.start,
+			Duration: &d,
+			fpath:    seg.fpath,
+		})
+	}
+
+	return files, nil
+}
+
+func writeBundleFile(a bundleArchive, file *bundleFile) error {
+	f, err := os.Open(file.fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	w, err := a.create(file.Name, stat.Size(), stat.ModTime())
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+
+	// the size is fixed in TAR headers, therefore segments that are
+	// still being written are copied up to the size read before.
+	n, err := io.Copy(io.MultiWriter(w, h), io.LimitReader(f, stat.Size()))
+	if err != nil {
+		return err
+	}
+	if n != stat.Size() {
+		return fmt.Errorf("%s has been truncated", file.fpath)
+	}
+
+	file.Size = n
+	file.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	return nil
+}
+
+func (s *Server) bundle(
+	ctx context.Context,
+	index *segmentIndex,
+	pathNames []string,
+	start time.Time,
+	end time.Time,
+	format string,
+	w io.Writer,
+) error {
+	if len(pathNames) == 0 {
+		return fmt.Errorf("at least one path is required")
+	}
+
+	if !end.After(start) {
+		return fmt.Errorf("end must be after start")
+	}
+
+	manifest := &bundleManifest{
+		Created: time.Now(),
+		Start:   start,
+		End:     end,
+		Paths:   []string{},
+		Files:   []*bundleFile{},
+	}
+
+	seen := make(map[string]struct{})
+
+	// segments are found before writing anything, in order to be able to return errors.
+	for _, pathName := range pathNames {
+		if _, ok := seen[pathName]; ok {
+			continue
+		}
+		seen[pathName] = struct{}{}
+
+		pathConf, err := s.safeFindPathConf(pathName)
+		if err != nil {
+			return err
+		}
+
+		files, err := s.bundleSegments(ctx, index, pathConf, pathName, start, end)
+		if err != nil {
+			return err
+		}
+
+		manifest.Paths = append(manifest.Paths, pathName)
+		manifest.Files = append(manifest.Files, files...)
+	}
+
+	if len(manifest.Files) == 0 {
+		return recordstore.ErrNoSegmentsFound
+	}
+
+	a, err := newBundleArchive(format, w)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range manifest.Files {
+		err = writeBundleFile(a, file)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the manifest is written last, since it contains checksums of segments.
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	mw, err := a.create(bundleManifestName, int64(len(buf)), manifest.Created)
+	if err != nil {
+		return err
+	}
+
+	_, err = mw.Write(buf)
+	if err != nil {
+		return err
+	}
+
+	return a.Close()
+}
+
+type bundleWriter struct {
+	ctx         *gin.Context
+	contentType string
+	written     bool
+}
+
+func (w *bundleWriter) Write(p []byte) (int, error) {
+	if !w.written {
+		w.written = true
+		w.ctx.Header("Content-Type", w.contentType)
+	}
+	return w.ctx.Writer.Write(p)
+}
+
+func (s *Server) onBundle(ctx *gin.Context) {
+	pathNames := ctx.QueryArray("path")
+
+	for _, pathName := range pathNames {
+		if !s.doAuth(ctx, pathName) {
+			return
+		}
+	}
+
+	start, err := time.Parse(time.RFC3339, ctx.Query("start"))
+	if err != nil {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid start: %w", err))
+		return
+	}
+
+	end, err := time.Parse(time.RFC3339, ctx.Query("end"))
+	if err != nil {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid end: %w", err))
+		return
+	}
+
+	format := ctx.Query("format")
+	if format != "" && format != "zip" && format != "tar" {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid format: %s", format))
+		return
+	}
+
+	ww := &bundleWriter{
+		ctx:         ctx,
+		contentType: bundleContentType(format),
+	}
+
+	ctx.Header("Content-Disposition", `attachment; filename="`+bundleFileName(start, format)+`"`)
+
+	err = s.bundle(ctx.Request.Context(), s.index, pathNames, start, end, format, ww)
+	if err != nil {
+		// user aborted the download
+		var neterr *net.OpError
+		if errors.As(err, &neterr) {
+			return
+		}
+
+		// nothing has been written yet; send back the error
+		if !ww.written {
+			ctx.Writer.Header().Del("Content-Disposition")
+
+			s.writeError(ctx, muxErrorStatus(err), err)
+			return
+		}
+
+		// something has already been written: abort and write logs only
+		s.Log(logger.Error, err.Error())
+		return
+	}
+}
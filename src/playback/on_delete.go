@@ -0,0 +1,225 @@
+package playback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+	"github.com/gin-gonic/gin"
+)
+
+// OnSegmentDeleteFunc is the prototype of the function passed as OnSegmentDelete.
+// trimmed is true when only a portion of the segment has been deleted.
+type OnSegmentDeleteFunc = func(pathName string, segmentPath string, trimmed bool)
+
+func writeSegmentParts(
+	fpath string,
+	r *os.File,
+	init *fmp4.Init,
+	headerSize uint64,
+	parts []*recordstore.FMP4Part,
+	offset time.Duration,
+) error {
+	err := os.MkdirAll(filepath.Dir(fpath), 0o755)
+	if err != nil {
+		return err
+	}
+
+	// write into a temporary file, then replace the destination,
+	// in order to prevent readers from accessing incomplete segments.
+	tmpPath := fpath + ".tmp"
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	err = segmentFMP4WriteParts(f, r, init, headerSize, parts, offset)
+	if err == nil {
+		err = segmentFMP4WriteDuration(f, parts[len(parts)-1].End-offset)
+	}
+
+	f.Close()
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, fpath)
+}
+
+func (s *Server) deleteSegmentRange(
+	pathName string,
+	pathFormat string,
+	seg *recordstore.Segment,
+	start time.Time,
+	end time.Time,
+) error {
+	// segment starts when the deleted range ends
+	if !seg.Start.Before(end) {
+		return nil
+	}
+
+	f, err := os.Open(seg.Fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	init, duration, err := recordstore.FMP4ReadHeader(f)
+	if err != nil || duration == 0 {
+		return fmt.Errorf("segment %s is still being written", seg.Fpath)
+	}
+
+	segEnd := seg.Start.Add(duration)
+
+	// segment ends before the deleted range
+	if !segEnd.After(start) {
+		return nil
+	}
+
+	headerSize, parts, err := recordstore.FMP4ReadParts(f, init)
+	if err != nil {
+		return err
+	}
+
+	// keep parts that end before the deleted range
+	var head []*recordstore.FMP4Part
+	if seg.Start.Before(start) {
+		for _, part := range parts {
+			if seg.Start.Add(part.End).After(start) {
+				break
+			}
+			head = append(head, part)
+		}
+	}
+
+	// keep parts that start after the deleted range, starting from a key frame
+	var tail []*recordstore.FMP4Part
+	if segEnd.After(end) {
+		for i, part := range parts {
+			if part.Independent && !seg.Start.Add(part.Start).Before(end) {
+				tail = parts[i:]
+				break
+			}
+		}
+	}
+
+	// the tail is moved into a new segment, whose name contains its start time
+	if tail != nil {
+		offset := tail[0].Start
+
+		tailPath := recordstore.Path{
+			Start: seg.Start.Add(offset),
+			Path:  pathName,
+		}.Encode(pathFormat)
+
+		err = writeSegmentParts(tailPath, f, init, headerSize, tail, offset)
+		if err != nil {
+			return err
+		}
+
+		s.index.invalidate(tailPath)
+	}
+
+	if head != nil {
+		err = writeSegmentParts(seg.Fpath, f, init, headerSize, head, 0)
+		if err == nil {
+			// the uploaded copy doesn't match the segment anymore,
+			// therefore it must not replace the local copy.
+			os.Remove(recordstore.UploadStatusPath(seg.Fpath)) //nolint:errcheck
+		}
+	} else {
+		err = recordstore.RemoveSegment(seg.Fpath)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.index.invalidate(seg.Fpath)
+
+	if s.OnSegmentDelete != nil {
+		s.OnSegmentDelete(pathName, seg.Fpath, head != nil || tail != nil)
+	}
+
+	return nil
+}
+
+// DeleteRecording deletes the recordings of a path between start and end.
+// Segments that are entirely covered are removed, while segments that are partially
+// covered are trimmed. Trimming takes place at part boundaries and
+// the footage after the deleted range starts from a key frame,
+// therefore slightly more footage than requested may be deleted.
+func (s *Server) DeleteRecording(pathName string, start time.Time, end time.Time) error {
+	if !end.After(start) {
+		return fmt.Errorf("end must be after start")
+	}
+
+	pathConf, err := s.safeFindPathConf(pathName)
+	if err != nil {
+		return err
+	}
+
+	if pathConf.RecordFormat != conf.RecordFormatFMP4 {
+		return fmt.Errorf("MPEG-TS format is not supported yet")
+	}
+
+	segments, err := s.findSegments(context.Background(), pathConf, pathName, &start, &end)
+	if err != nil {
+		return err
+	}
+
+	pathFormat := recordstore.PathAddExtension(pathConf.RecordPath, pathConf.RecordFormat)
+
+	// segment paths are absolute, and so are paths of new segments
+	pathFormat, _ = filepath.Abs(pathFormat)
+
+	for _, seg := range segments {
+		err = s.deleteSegmentRange(pathName, pathFormat, seg, start, end)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) onDelete(ctx *gin.Context) {
+	pathName := ctx.Query("path")
+
+	if !s.doAuthAction(ctx, pathName, conf.AuthActionAPI) {
+		return
+	}
+
+	start, err := time.Parse(time.RFC3339, ctx.Query("start"))
+	if err != nil {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid start: %w", err))
+		return
+	}
+
+	end, err := time.Parse(time.RFC3339, ctx.Query("end"))
+	if err != nil {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid end: %w", err))
+		return
+	}
+
+	err = s.DeleteRecording(pathName, start, end)
+	if err != nil {
+		if errors.Is(err, recordstore.ErrNoSegmentsFound) {
+			s.writeError(ctx, http.StatusNotFound, err)
+		} else {
+			s.writeError(ctx, http.StatusBadRequest, err)
+		}
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
@@ -0,0 +1,134 @@
+package playback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// Export writes a clip of the recordings of a path into w, in the MP4 format.
+// The clip is trimmed to the requested range through edit lists,
+// therefore it starts and ends exactly at the given times,
+// even when they are not aligned with key frames.
+func (s *Server) Export(pathName string, start time.Time, end time.Time, w io.Writer) error {
+	return s.export(context.Background(), pathName, start, end, 0, w, nil)
+}
+
+// ExportTrickPlay writes a fast-forward clip of the recordings of a path into w, in the MP4 format.
+// The clip contains key frames of video tracks only, played speed times faster.
+func (s *Server) ExportTrickPlay(pathName string, start time.Time, end time.Time, speed float64, w io.Writer) error {
+	err := checkSpeed(speed)
+	if err != nil {
+		return err
+	}
+
+	return s.export(context.Background(), pathName, start, end, speed, w, nil)
+}
+
+func (s *Server) export(
+	ctx context.Context,
+	pathName string,
+	start time.Time,
+	end time.Time,
+	speed float64,
+	w io.Writer,
+	onSize func(size uint64) (*byteRange, error),
+) error {
+	if !end.After(start) {
+		return fmt.Errorf("end must be after start")
+	}
+
+	pathConf, err := s.safeFindPathConf(pathName)
+	if err != nil {
+		return err
+	}
+
+	segments, err := s.findSegments(ctx, pathConf, pathName, &start, &end)
+	if err != nil {
+		return err
+	}
+
+	duration := end.Sub(start)
+
+	if speed == 0 {
+		return seekAndMux(pathConf.RecordFormat, segments, start, duration, &muxerMP4{
+			w:           w,
+			log:         s,
+			maxDuration: duration,
+			onSize:      onSize,
+		})
+	}
+
+	return seekAndMux(pathConf.RecordFormat, segments, start, duration, &muxerTrickPlay{
+		m: &muxerMP4{
+			w:           w,
+			log:         s,
+			maxDuration: time.Duration(float64(duration) / speed),
+			onSize:      onSize,
+		},
+		speed: speed,
+	})
+}
+
+func (s *Server) onExport(ctx *gin.Context) {
+	pathName := ctx.Query("path")
+
+	if !s.doAuth(ctx, pathName) {
+		return
+	}
+
+	start, err := time.Parse(time.RFC3339, ctx.Query("start"))
+	if err != nil {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid start: %w", err))
+		return
+	}
+
+	end, err := time.Parse(time.RFC3339, ctx.Query("end"))
+	if err != nil {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid end: %w", err))
+		return
+	}
+
+	var speed float64
+	if rawSpeed := ctx.Query("speed"); rawSpeed != "" {
+		speed, err = parseSpeed(rawSpeed)
+		if err != nil {
+			s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid speed: %w", err))
+			return
+		}
+	}
+
+	ww := &writerWrapper{ctx: ctx}
+
+	fileName := strings.ReplaceAll(pathName, "/", "_") + "_" + start.Format("2006-01-02_15-04-05") + ".mp4"
+	ctx.Header("Content-Disposition", `attachment; filename="`+fileName+`"`)
+
+	err = s.export(ctx.Request.Context(), pathName, start, end, speed, ww, ww.onSize)
+	if err != nil {
+		// user aborted the download
+		var neterr *net.OpError
+		if errors.As(err, &neterr) {
+			return
+		}
+
+		// nothing has been written yet; send back the error
+		if !ww.written {
+			ctx.Writer.Header().Del("Content-Disposition")
+
+			s.writeError(ctx, muxErrorStatus(err), err)
+			return
+		}
+
+		// something has already been written: abort and write logs only
+		s.Log(logger.Error, err.Error())
+		return
+	}
+}
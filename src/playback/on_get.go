@@ -3,6 +3,7 @@ package playback
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net"
 	"net/http"
 	"os"
@@ -24,12 +25,51 @@ type writerWrapper struct {
 func (w *writerWrapper) Write(p []byte) (int, error) {
 	if !w.written {
 		w.written = true
-		w.ctx.Header("Accept-Ranges", "none")
+		if w.ctx.Writer.Header().Get("Accept-Ranges") == "" {
+			w.ctx.Header("Accept-Ranges", "none")
+		}
 		w.ctx.Header("Content-Type", "video/mp4")
 	}
 	return w.ctx.Writer.Write(p)
 }
 
+// onSize is called once the size of a MP4 file is known.
+// It fills response headers and returns the requested byte range.
+func (w *writerWrapper) onSize(size uint64) (*byteRange, error) {
+	w.ctx.Header("Accept-Ranges", "bytes")
+
+	r, err := parseByteRange(w.ctx.GetHeader("Range"), size)
+	if err != nil {
+		if errors.Is(err, errRangeNotSatisfiable) {
+			w.ctx.Header("Content-Range", "bytes */"+strconv.FormatUint(size, 10))
+		}
+		return nil, err
+	}
+
+	if r == nil {
+		w.ctx.Header("Content-Length", strconv.FormatUint(size, 10))
+		return nil, nil
+	}
+
+	w.ctx.Header("Content-Range", "bytes "+strconv.FormatUint(r.start, 10)+"-"+
+		strconv.FormatUint(r.end-1, 10)+"/"+strconv.FormatUint(size, 10))
+	w.ctx.Header("Content-Length", strconv.FormatUint(r.end-r.start, 10))
+	w.ctx.Status(http.StatusPartialContent)
+
+	return r, nil
+}
+
+func muxErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, recordstore.ErrNoSegmentsFound):
+		return http.StatusNotFound
+	case errors.Is(err, errRangeNotSatisfiable):
+		return http.StatusRequestedRangeNotSatisfiable
+	default:
+		return http.StatusBadRequest
+	}
+}
+
 func parseDuration(raw string) (time.Duration, error) {
 	// seconds
 	if secs, err := strconv.ParseFloat(raw, 64); err == nil {
@@ -40,6 +80,27 @@ func parseDuration(raw string) (time.Duration, error) {
 	return time.ParseDuration(raw)
 }
 
+func checkSpeed(speed float64) error {
+	if !(speed >= 1) || math.IsInf(speed, 0) {
+		return fmt.Errorf("speed must be greater than or equal to 1")
+	}
+	return nil
+}
+
+func parseSpeed(raw string) (float64, error) {
+	speed, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkSpeed(speed)
+	if err != nil {
+		return 0, err
+	}
+
+	return speed, nil
+}
+
 func seekAndMux(
 	recordFormat conf.RecordFormat,
 	segments []*recordstore.Segment,
@@ -57,7 +118,7 @@ func seekAndMux(
 		}
 		defer f.Close()
 
-		firstInit, _, err = segmentFMP4ReadHeader(f)
+		firstInit, _, err = recordstore.FMP4ReadHeader(f)
 		if err != nil {
 			return err
 		}
@@ -80,10 +141,11 @@ func seekAndMux(
 			}
 			defer f.Close()
 
+			// the segment may be still being written and may not contain a header yet.
 			var init *fmp4.Init
-			init, _, err = segmentFMP4ReadHeader(f)
+			init, _, err = recordstore.FMP4ReadHeader(f)
 			if err != nil {
-				return err
+				break
 			}
 
 			if !segmentFMP4CanBeConcatenated(firstInit, segmentEnd, init, seg.Start) {
@@ -140,13 +202,41 @@ func (s *Server) onGet(ctx *gin.Context) {
 		m = &muxerFMP4{w: ww}
 
 	case "mp4":
-		m = &muxerMP4{w: ww}
+		var timeScale uint64
+		if ts := ctx.Query("timescale"); ts != "" {
+			timeScale, err = strconv.ParseUint(ts, 10, 32)
+			if err != nil || timeScale == 0 {
+				s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid timescale: %s", ts))
+				return
+			}
+		}
+
+		m = &muxerMP4{
+			w:         ww,
+			log:       s,
+			timeScale: uint32(timeScale),
+			onSize:    ww.onSize,
+		}
 
 	default:
 		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid format: %s", format))
 		return
 	}
 
+	if rawSpeed := ctx.Query("speed"); rawSpeed != "" {
+		var speed float64
+		speed, err = parseSpeed(rawSpeed)
+		if err != nil {
+			s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid speed: %w", err))
+			return
+		}
+
+		m = &muxerTrickPlay{
+			m:     m,
+			speed: speed,
+		}
+	}
+
 	pathConf, err := s.safeFindPathConf(pathName)
 	if err != nil {
 		s.writeError(ctx, http.StatusBadRequest, err)
@@ -154,7 +244,7 @@ func (s *Server) onGet(ctx *gin.Context) {
 	}
 
 	end := start.Add(duration)
-	segments, err := recordstore.FindSegments(pathConf, pathName, &start, &end)
+	segments, err := s.findSegments(ctx.Request.Context(), pathConf, pathName, &start, &end)
 	if err != nil {
 		if errors.Is(err, recordstore.ErrNoSegmentsFound) {
 			s.writeError(ctx, http.StatusNotFound, err)
@@ -174,11 +264,7 @@ func (s *Server) onGet(ctx *gin.Context) {
 
 		// nothing has been written yet; send back JSON
 		if !ww.written {
-			if errors.Is(err, recordstore.ErrNoSegmentsFound) {
-				s.writeError(ctx, http.StatusNotFound, err)
-			} else {
-				s.writeError(ctx, http.StatusBadRequest, err)
-			}
+			s.writeError(ctx, muxErrorStatus(err), err)
 			return
 		}
 
@@ -0,0 +1,280 @@
+package playback
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+	"github.com/gin-gonic/gin"
+)
+
+// hlsSegment is a HLS segment, made of consecutive parts of a recorded segment.
+// It starts with a part that can be decoded independently.
+type hlsSegment struct {
+	offset uint64
+	size   uint64
+	start  time.Duration
+	end    time.Duration
+}
+
+func groupParts(parts []*recordstore.FMP4Part) []*hlsSegment {
+	var out []*hlsSegment
+
+	for _, part := range parts {
+		if len(out) == 0 || part.Independent {
+			out = append(out, &hlsSegment{
+				offset: part.Offset,
+				start:  part.Start,
+			})
+		}
+
+		cur := out[len(out)-1]
+		cur.size = part.Offset + part.Size - cur.offset
+		if part.End > cur.end {
+			cur.end = part.End
+		}
+	}
+
+	return out
+}
+
+type hlsPlaylistFile struct {
+	url        string
+	start      time.Time
+	headerSize uint64
+	segments   []*hlsSegment
+}
+
+func marshalHLSPlaylist(files []*hlsPlaylistFile) []byte {
+	targetDuration := 1
+	for _, f := range files {
+		for _, seg := range f.segments {
+			d := int(math.Ceil((seg.end - seg.start).Seconds()))
+			if d > targetDuration {
+				targetDuration = d
+			}
+		}
+	}
+
+	var b strings.Builder
+
+	b.WriteString("#EXTM3U\n" +
+		"#EXT-X-VERSION:7\n" +
+		"#EXT-X-TARGETDURATION:" + strconv.FormatInt(int64(targetDuration), 10) + "\n" +
+		"#EXT-X-MEDIA-SEQUENCE:0\n" +
+		"#EXT-X-PLAYLIST-TYPE:VOD\n")
+
+	for i, f := range files {
+		// each recorded segment has its own header and timestamps
+		if i != 0 {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+
+		b.WriteString("#EXT-X-MAP:URI=\"" + f.url + "\",BYTERANGE=\"" +
+			strconv.FormatUint(f.headerSize, 10) + "@0\"\n")
+
+		b.WriteString("#EXT-X-PROGRAM-DATE-TIME:" +
+			f.start.Add(f.segments[0].start).Format("2006-01-02T15:04:05.000Z07:00") + "\n")
+
+		for _, seg := range f.segments {
+			b.WriteString("#EXTINF:" + strconv.FormatFloat((seg.end-seg.start).Seconds(), 'f', 5, 64) + ",\n" +
+				"#EXT-X-BYTERANGE:" + strconv.FormatUint(seg.size, 10) + "@" + strconv.FormatUint(seg.offset, 10) + "\n" +
+				f.url + "\n")
+		}
+	}
+
+	b.WriteString("#EXT-X-ENDLIST\n")
+
+	return []byte(b.String())
+}
+
+func readHLSPlaylistFile(
+	pathName string,
+	parsed *parsedSegment,
+	start *time.Time,
+	end *time.Time,
+) (*hlsPlaylistFile, error) {
+	f, err := os.Open(parsed.fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	headerSize, parts, err := recordstore.FMP4ReadParts(f, parsed.init)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []*hlsSegment
+
+	for _, seg := range groupParts(parts) {
+		if start != nil && !parsed.start.Add(seg.end).After(*start) {
+			continue
+		}
+		if end != nil && !parsed.start.Add(seg.start).Before(*end) {
+			continue
+		}
+		segments = append(segments, seg)
+	}
+
+	if segments == nil {
+		return nil, nil
+	}
+
+	v := url.Values{}
+	v.Add("path", pathName)
+	v.Add("start", parsed.start.Format(time.RFC3339Nano))
+
+	return &hlsPlaylistFile{
+		// relative to the playlist URL
+		url:        "segment.mp4?" + v.Encode(),
+		start:      parsed.start,
+		headerSize: headerSize,
+		segments:   segments,
+	}, nil
+}
+
+func (s *Server) onHLSPlaylist(ctx *gin.Context) {
+	pathName := ctx.Query("path")
+
+	if !s.doAuth(ctx, pathName) {
+		return
+	}
+
+	pathConf, err := s.safeFindPathConf(pathName)
+	if err != nil {
+		s.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if pathConf.RecordFormat != conf.RecordFormatFMP4 {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("MPEG-TS format is not supported yet"))
+		return
+	}
+
+	var start *time.Time
+	rawStart := ctx.Query("start")
+	if rawStart != "" {
+		var tmp time.Time
+		tmp, err = time.Parse(time.RFC3339, rawStart)
+		if err != nil {
+			s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid start: %w", err))
+			return
+		}
+		start = &tmp
+	}
+
+	var end *time.Time
+	rawEnd := ctx.Query("end")
+	if rawEnd != "" {
+		var tmp time.Time
+		tmp, err = time.Parse(time.RFC3339, rawEnd)
+		if err != nil {
+			s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid end: %w", err))
+			return
+		}
+		end = &tmp
+	}
+
+	segments, err := s.findSegments(ctx.Request.Context(), pathConf, pathName, start, end)
+	if err != nil {
+		if errors.Is(err, recordstore.ErrNoSegmentsFound) {
+			s.writeError(ctx, http.StatusNotFound, err)
+		} else {
+			s.writeError(ctx, http.StatusBadRequest, err)
+		}
+		return
+	}
+
+	var files []*hlsPlaylistFile
+
+	for _, parsed := range s.index.parseSegments(segments, s) {
+		var file *hlsPlaylistFile
+		file, err = readHLSPlaylistFile(pathName, parsed, start, end)
+		if err != nil {
+			s.Log(logger.Warn, "skipping segment %s: %v", parsed.fpath, err)
+			continue
+		}
+
+		if file != nil {
+			files = append(files, file)
+		}
+	}
+
+	if files == nil {
+		s.writeError(ctx, http.StatusNotFound, recordstore.ErrNoSegmentsFound)
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Data(http.StatusOK, "application/vnd.apple.mpegurl", marshalHLSPlaylist(files))
+}
+
+func (s *Server) onHLSSegment(ctx *gin.Context) {
+	pathName := ctx.Query("path")
+
+	if !s.doAuth(ctx, pathName) {
+		return
+	}
+
+	pathConf, err := s.safeFindPathConf(pathName)
+	if err != nil {
+		s.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	start, err := time.Parse(time.RFC3339, ctx.Query("start"))
+	if err != nil {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid start: %w", err))
+		return
+	}
+
+	segments, err := s.findSegments(ctx.Request.Context(), pathConf, pathName, &start, &start)
+	if err != nil {
+		if errors.Is(err, recordstore.ErrNoSegmentsFound) {
+			s.writeError(ctx, http.StatusNotFound, err)
+		} else {
+			s.writeError(ctx, http.StatusBadRequest, err)
+		}
+		return
+	}
+
+	var fpath string
+	for _, seg := range segments {
+		if seg.Start.Equal(start) {
+			fpath = seg.Fpath
+			break
+		}
+	}
+
+	if fpath == "" {
+		s.writeError(ctx, http.StatusNotFound, recordstore.ErrNoSegmentsFound)
+		return
+	}
+
+	f, err := os.Open(fpath)
+	if err != nil {
+		s.writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		s.writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	// byte ranges are handled by ServeContent
+	ctx.Header("Content-Type", "video/mp4")
+	http.ServeContent(ctx.Writer, ctx.Request, "", stat.ModTime(), f)
+}
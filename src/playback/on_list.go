@@ -12,6 +12,7 @@ import (
 
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
 	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/logger"
 	"github.com/flynnletford/mediamtx/src/recordstore"
 	"github.com/gin-gonic/gin"
 )
@@ -23,19 +24,24 @@ func (d listEntryDuration) MarshalJSON() ([]byte, error) {
 }
 
 type parsedSegment struct {
+	fpath    string
 	start    time.Time
 	init     *fmp4.Init
 	duration time.Duration
 }
 
 func parseSegment(seg *recordstore.Segment) (*parsedSegment, error) {
+	if seg.Remote {
+		return parseRemoteSegment(seg)
+	}
+
 	f, err := os.Open(seg.Fpath)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	init, duration, err := segmentFMP4ReadHeader(f)
+	init, duration, err := recordstore.FMP4ReadHeader(f)
 	if err != nil {
 		return nil, err
 	}
@@ -50,15 +56,38 @@ func parseSegment(seg *recordstore.Segment) (*parsedSegment, error) {
 	}
 
 	return &parsedSegment{
+		fpath:    seg.Fpath,
 		start:    seg.Start,
 		init:     init,
 		duration: duration,
 	}, nil
 }
 
-func parseSegments(segments []*recordstore.Segment) ([]*parsedSegment, error) {
+// parseRemoteSegment parses a segment whose local copy has been removed,
+// by using the duration saved into the upload status.
+func parseRemoteSegment(seg *recordstore.Segment) (*parsedSegment, error) {
+	st, err := recordstore.ReadUploadStatus(seg.Fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	if st.Duration == 0 {
+		return nil, fmt.Errorf("duration is unknown")
+	}
+
+	return &parsedSegment{
+		fpath:    seg.Fpath,
+		start:    seg.Start,
+		duration: time.Duration(st.Duration * float64(time.Second)),
+	}, nil
+}
+
+// parseSegments parses segments. Segments that can't be parsed,
+// like segments that are still being written and don't contain a header yet,
+// are returned as nil.
+func parseSegments(segments []*recordstore.Segment, log logger.Writer) []*parsedSegment {
 	parsed := make([]*parsedSegment, len(segments))
-	ch := make(chan error)
+	ch := make(chan struct{})
 
 	// process segments in parallel.
 	// parallel random access should improve performance in most cases.
@@ -67,20 +96,18 @@ func parseSegments(segments []*recordstore.Segment) ([]*parsedSegment, error) {
 		go func(i int, seg *recordstore.Segment) {
 			var err error
 			parsed[i], err = parseSegment(seg)
-			ch <- err
+			if err != nil {
+				log.Log(logger.Warn, "skipping segment %s: %v", seg.Fpath, err)
+			}
+			ch <- struct{}{}
 		}(i, seg)
 	}
 
-	var err error
-
 	for range segments {
-		err2 := <-ch
-		if err2 != nil {
-			err = err2
-		}
+		<-ch
 	}
 
-	return parsed, err
+	return parsed
 }
 
 type listEntry struct {
@@ -118,11 +145,13 @@ func concatenateSegments(parsed []*parsedSegment) []listEntry {
 func parseAndConcatenate(
 	recordFormat conf.RecordFormat,
 	segments []*recordstore.Segment,
+	index *segmentIndex,
+	log logger.Writer,
 ) ([]listEntry, error) {
 	if recordFormat == conf.RecordFormatFMP4 {
-		parsed, err := parseSegments(segments)
-		if err != nil {
-			return nil, err
+		parsed := index.parseSegments(segments, log)
+		if len(parsed) == 0 {
+			return nil, recordstore.ErrNoSegmentsFound
 		}
 
 		out := concatenateSegments(parsed)
@@ -179,9 +208,13 @@ func (s *Server) onList(ctx *gin.Context) {
 		return
 	}
 
-	entries, err := parseAndConcatenate(pathConf.RecordFormat, segments)
+	entries, err := parseAndConcatenate(pathConf.RecordFormat, segments, s.index, s)
 	if err != nil {
-		s.writeError(ctx, http.StatusInternalServerError, err)
+		if errors.Is(err, recordstore.ErrNoSegmentsFound) {
+			s.writeError(ctx, http.StatusNotFound, err)
+		} else {
+			s.writeError(ctx, http.StatusInternalServerError, err)
+		}
 		return
 	}
 
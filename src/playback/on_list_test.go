@@ -27,6 +27,7 @@ func TestOnList(t *testing.T) {
 		"different init",
 		"start after duration",
 		"start before first",
+		"segment being written",
 	} {
 		t.Run(ca, func(t *testing.T) {
 			dir, err := os.MkdirTemp("", "mediamtx-playback")
@@ -52,6 +53,11 @@ func TestOnList(t *testing.T) {
 
 			case "start after duration":
 				writeSegment1(t, filepath.Join(dir, "mypath", "2008-11-07_11-22-00-500000.mp4"))
+
+			case "segment being written":
+				writeSegment1(t, filepath.Join(dir, "mypath", "2008-11-07_11-22-00-500000.mp4"))
+				err = os.WriteFile(filepath.Join(dir, "mypath", "2008-11-07_11-23-02-500000.mp4"), []byte{}, 0o644)
+				require.NoError(t, err)
 			}
 
 			s := &Server{
@@ -155,6 +161,16 @@ func TestOnList(t *testing.T) {
 					},
 				}, out)
 
+			case "segment being written":
+				require.Equal(t, []interface{}{
+					map[string]interface{}{
+						"duration": float64(62),
+						"start":    time.Date(2008, 11, 0o7, 11, 22, 0, 500000000, time.Local).Format(time.RFC3339Nano),
+						"url": "http://localhost:9996/get?duration=62&path=mypath&start=" +
+							url.QueryEscape(time.Date(2008, 11, 0o7, 11, 22, 0, 500000000, time.Local).Format(time.RFC3339Nano)),
+					},
+				}, out)
+
 			case "different init":
 				require.Equal(t, []interface{}{
 					map[string]interface{}{
@@ -0,0 +1,153 @@
+package playback
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+	"github.com/flynnletford/mediamtx/src/uploader"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultSignedURLExpiration = 1 * time.Hour
+
+type signedURLEntry struct {
+	Start    time.Time         `json:"start"`
+	Duration listEntryDuration `json:"duration"`
+	URL      string            `json:"url"`
+	Signed   bool              `json:"signed"`
+}
+
+// signSegment returns a pre-signed URL of the remote copy of a segment.
+// It returns an empty string when the segment has not been uploaded or
+// when the storage does not support signed URLs.
+func signSegment(pathConf *conf.Path, segmentPath string, expiration time.Duration) (string, error) {
+	st, err := recordstore.ReadUploadStatus(segmentPath)
+	if err != nil || st.State != recordstore.UploadStateUploaded {
+		return "", nil
+	}
+
+	u, err := uploader.SignURL(pathConf, st.Key, expiration)
+	if err != nil {
+		if errors.Is(err, uploader.ErrSignedURLsNotSupported) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return u, nil
+}
+
+func (s *Server) onSignedURLs(ctx *gin.Context) {
+	pathName := ctx.Query("path")
+
+	if !s.doAuth(ctx, pathName) {
+		return
+	}
+
+	start, err := time.Parse(time.RFC3339, ctx.Query("start"))
+	if err != nil {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid start: %w", err))
+		return
+	}
+
+	end, err := time.Parse(time.RFC3339, ctx.Query("end"))
+	if err != nil {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid end: %w", err))
+		return
+	}
+
+	if !end.After(start) {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("end must be after start"))
+		return
+	}
+
+	expiration := defaultSignedURLExpiration
+	if rawExpiration := ctx.Query("expiration"); rawExpiration != "" {
+		var tmp float64
+		tmp, err = strconv.ParseFloat(rawExpiration, 64)
+		if err != nil {
+			s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid expiration: %w", err))
+			return
+		}
+		expiration = time.Duration(tmp * float64(time.Second))
+	}
+
+	pathConf, err := s.safeFindPathConf(pathName)
+	if err != nil {
+		s.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if pathConf.RecordFormat != conf.RecordFormatFMP4 {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("MPEG-TS format is not supported yet"))
+		return
+	}
+
+	segments, err := recordstore.FindSegments(pathConf, pathName, &start, &end)
+	if err != nil {
+		if errors.Is(err, recordstore.ErrNoSegmentsFound) {
+			s.writeError(ctx, http.StatusNotFound, err)
+		} else {
+			s.writeError(ctx, http.StatusBadRequest, err)
+		}
+		return
+	}
+
+	var scheme string
+	if s.Encryption {
+		scheme = "https"
+	} else {
+		scheme = "http"
+	}
+
+	entries := []signedURLEntry{}
+
+	for _, seg := range s.index.parseSegments(segments, s) {
+		if !seg.start.Add(seg.duration).After(start) {
+			continue
+		}
+
+		entry := signedURLEntry{
+			Start:    seg.start,
+			Duration: listEntryDuration(seg.duration),
+		}
+
+		entry.URL, err = signSegment(pathConf, seg.fpath, expiration)
+		if err != nil {
+			s.writeError(ctx, http.StatusBadRequest, err)
+			return
+		}
+
+		// segments that can't be downloaded from the storage are proxied by the server.
+		if entry.URL != "" {
+			entry.Signed = true
+		} else {
+			v := url.Values{}
+			v.Add("path", pathName)
+			v.Add("start", seg.start.Format(time.RFC3339Nano))
+			v.Add("duration", strconv.FormatFloat(seg.duration.Seconds(), 'f', -1, 64))
+			u := &url.URL{
+				Scheme:   scheme,
+				Host:     ctx.Request.Host,
+				Path:     "/get",
+				RawQuery: v.Encode(),
+			}
+			entry.URL = u.String()
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if len(entries) == 0 {
+		s.writeError(ctx, http.StatusNotFound, recordstore.ErrNoSegmentsFound)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, entries)
+}
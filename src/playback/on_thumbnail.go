@@ -0,0 +1,174 @@
+package playback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+	"github.com/gin-gonic/gin"
+)
+
+// Keyframe is a key frame extracted from a recording.
+type Keyframe struct {
+	// time of the key frame.
+	Time time.Time
+
+	// codec of the key frame, either *fmp4.CodecH264 or *fmp4.CodecH265.
+	Codec fmp4.Codec
+
+	// access unit, preceded by parameter sets.
+	AU [][]byte
+}
+
+// ThumbnailDecoder converts a key frame into a JPEG image.
+type ThumbnailDecoder func(kf *Keyframe) ([]byte, error)
+
+func findKeyframeTrack(init *fmp4.Init) *fmp4.InitTrack {
+	for _, track := range init.Tracks {
+		switch track.Codec.(type) {
+		case *fmp4.CodecH264, *fmp4.CodecH265:
+			return track
+		}
+	}
+	return nil
+}
+
+func readKeyframe(seg *recordstore.Segment, t time.Time) (*Keyframe, error) {
+	f, err := os.Open(seg.Fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	init, _, err := recordstore.FMP4ReadHeader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	track := findKeyframeTrack(init)
+	if track == nil {
+		return nil, fmt.Errorf("recording doesn't contain any H264 or H265 track")
+	}
+
+	sample, err := segmentFMP4FindKeyframe(f, init, track.ID, t.Sub(seg.Start))
+	if err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, sample.size)
+	_, err = f.ReadAt(payload, int64(sample.offset))
+	if err != nil {
+		return nil, err
+	}
+
+	var avcc h264.AVCC
+	err = avcc.Unmarshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var au [][]byte
+
+	switch codec := track.Codec.(type) {
+	case *fmp4.CodecH264:
+		au = append(au, codec.SPS, codec.PPS)
+
+	case *fmp4.CodecH265:
+		au = append(au, codec.VPS, codec.SPS, codec.PPS)
+	}
+
+	au = append(au, avcc...)
+
+	return &Keyframe{
+		Time:  seg.Start.Add(sample.dts),
+		Codec: track.Codec,
+		AU:    au,
+	}, nil
+}
+
+// Keyframe returns the key frame of a path that is the nearest to the given time.
+func (s *Server) Keyframe(pathName string, t time.Time) (*Keyframe, error) {
+	pathConf, err := s.safeFindPathConf(pathName)
+	if err != nil {
+		return nil, err
+	}
+
+	if pathConf.RecordFormat != conf.RecordFormatFMP4 {
+		return nil, fmt.Errorf("MPEG-TS format is not supported yet")
+	}
+
+	segments, err := s.findSegments(context.Background(), pathConf, pathName, &t, &t)
+	if err != nil {
+		return nil, err
+	}
+
+	return readKeyframe(segments[0], t)
+}
+
+func (s *Server) onThumbnail(ctx *gin.Context) {
+	pathName := ctx.Query("path")
+
+	if !s.doAuth(ctx, pathName) {
+		return
+	}
+
+	t, err := time.Parse(time.RFC3339, ctx.Query("time"))
+	if err != nil {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid time: %w", err))
+		return
+	}
+
+	format := ctx.Query("format")
+	if format != "" && format != "raw" && format != "jpeg" {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid format: %s", format))
+		return
+	}
+
+	if format == "jpeg" && s.ThumbnailDecoder == nil {
+		s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("JPEG thumbnails are not available"))
+		return
+	}
+
+	kf, err := s.Keyframe(pathName, t)
+	if err != nil {
+		if errors.Is(err, recordstore.ErrNoSegmentsFound) {
+			s.writeError(ctx, http.StatusNotFound, err)
+		} else {
+			s.writeError(ctx, http.StatusBadRequest, err)
+		}
+		return
+	}
+
+	ctx.Header("X-Keyframe-Time", kf.Time.Format(time.RFC3339Nano))
+
+	if format == "jpeg" {
+		var byts []byte
+		byts, err = s.ThumbnailDecoder(kf)
+		if err != nil {
+			s.writeError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+
+		ctx.Data(http.StatusOK, "image/jpeg", byts)
+		return
+	}
+
+	byts, err := h264.AnnexB(kf.AU).Marshal()
+	if err != nil {
+		s.writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+
+	if _, ok := kf.Codec.(*fmp4.CodecH265); ok {
+		ctx.Data(http.StatusOK, "video/H265", byts)
+	} else {
+		ctx.Data(http.StatusOK, "video/H264", byts)
+	}
+}
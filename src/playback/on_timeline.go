@@ -0,0 +1,222 @@
+package playback
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+	"github.com/gin-gonic/gin"
+)
+
+// TimelineRange is a time range.
+type TimelineRange struct {
+	Start    time.Time
+	Duration time.Duration
+}
+
+// TimelineAnnotation is a label attached to a time range.
+type TimelineAnnotation struct {
+	Start time.Time
+	End   time.Time
+	Label string
+	Tags  map[string]string
+}
+
+// AnnotationsFunc is the prototype of the function passed as Annotations.
+type AnnotationsFunc = func(pathName string, start *time.Time, end *time.Time) ([]TimelineAnnotation, error)
+
+// Timeline describes the footage that is available in a time window.
+type Timeline struct {
+	// ranges that contain footage
+	Ranges []TimelineRange
+
+	// ranges that don't contain footage
+	Gaps []TimelineRange
+
+	// annotations that overlap the time window
+	Annotations []TimelineAnnotation
+}
+
+type timelineEntry struct {
+	Start    time.Time         `json:"start"`
+	Duration listEntryDuration `json:"duration"`
+}
+
+type timelineAnnotationEntry struct {
+	Start time.Time         `json:"start"`
+	End   time.Time         `json:"end"`
+	Label string            `json:"label"`
+	Tags  map[string]string `json:"tags"`
+}
+
+type timelineResponse struct {
+	Ranges      []timelineEntry           `json:"ranges"`
+	Gaps        []timelineEntry           `json:"gaps"`
+	Annotations []timelineAnnotationEntry `json:"annotations,omitempty"`
+}
+
+func timelineEntries(ranges []TimelineRange) []timelineEntry {
+	out := make([]timelineEntry, len(ranges))
+	for i, r := range ranges {
+		out[i] = timelineEntry{
+			Start:    r.Start,
+			Duration: listEntryDuration(r.Duration),
+		}
+	}
+	return out
+}
+
+func timelineAnnotationEntries(annotations []TimelineAnnotation) []timelineAnnotationEntry {
+	out := make([]timelineAnnotationEntry, len(annotations))
+	for i, a := range annotations {
+		out[i] = timelineAnnotationEntry{
+			Start: a.Start,
+			End:   a.End,
+			Label: a.Label,
+			Tags:  a.Tags,
+		}
+	}
+	return out
+}
+
+// computeTimeline merges segments into ranges, without taking into account
+// whether segments can be concatenated, and computes gaps between them.
+func computeTimeline(parsed []*parsedSegment, start *time.Time, end *time.Time) *Timeline {
+	tl := &Timeline{
+		Ranges: []TimelineRange{},
+		Gaps:   []TimelineRange{},
+	}
+
+	for _, seg := range parsed {
+		segStart := seg.start
+		segEnd := seg.start.Add(seg.duration)
+
+		if start != nil && segStart.Before(*start) {
+			segStart = *start
+		}
+		if end != nil && segEnd.After(*end) {
+			segEnd = *end
+		}
+		if !segEnd.After(segStart) {
+			continue
+		}
+
+		if len(tl.Ranges) != 0 {
+			prev := &tl.Ranges[len(tl.Ranges)-1]
+			prevEnd := prev.Start.Add(prev.Duration)
+
+			if !segStart.After(prevEnd.Add(concatenationTolerance)) {
+				if segEnd.After(prevEnd) {
+					prev.Duration = segEnd.Sub(prev.Start)
+				}
+				continue
+			}
+		}
+
+		tl.Ranges = append(tl.Ranges, TimelineRange{
+			Start:    segStart,
+			Duration: segEnd.Sub(segStart),
+		})
+	}
+
+	cur := start
+	for _, r := range tl.Ranges {
+		if cur != nil && r.Start.After(*cur) {
+			tl.Gaps = append(tl.Gaps, TimelineRange{
+				Start:    *cur,
+				Duration: r.Start.Sub(*cur),
+			})
+		}
+		tmp := r.Start.Add(r.Duration)
+		cur = &tmp
+	}
+
+	if cur != nil && end != nil && end.After(*cur) {
+		tl.Gaps = append(tl.Gaps, TimelineRange{
+			Start:    *cur,
+			Duration: end.Sub(*cur),
+		})
+	}
+
+	return tl
+}
+
+// Timeline returns the footage of a path that is available between start and end.
+// start and end are optional.
+func (s *Server) Timeline(pathName string, start *time.Time, end *time.Time) (*Timeline, error) {
+	pathConf, err := s.safeFindPathConf(pathName)
+	if err != nil {
+		return nil, err
+	}
+
+	if pathConf.RecordFormat != conf.RecordFormatFMP4 {
+		return nil, fmt.Errorf("MPEG-TS format is not supported yet")
+	}
+
+	var tl *Timeline
+
+	segments, err := recordstore.FindSegments(pathConf, pathName, start, end)
+	if err != nil {
+		if !errors.Is(err, recordstore.ErrNoSegmentsFound) {
+			return nil, err
+		}
+		tl = computeTimeline(nil, start, end)
+	} else {
+		parsed := s.index.parseSegments(segments, s)
+		tl = computeTimeline(parsed, start, end)
+	}
+
+	if s.Annotations != nil {
+		tl.Annotations, err = s.Annotations(pathName, start, end)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tl, nil
+}
+
+func (s *Server) onTimeline(ctx *gin.Context) {
+	pathName := ctx.Query("path")
+
+	if !s.doAuth(ctx, pathName) {
+		return
+	}
+
+	var start *time.Time
+	rawStart := ctx.Query("start")
+	if rawStart != "" {
+		tmp, err := time.Parse(time.RFC3339, rawStart)
+		if err != nil {
+			s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid start: %w", err))
+			return
+		}
+		start = &tmp
+	}
+
+	var end *time.Time
+	rawEnd := ctx.Query("end")
+	if rawEnd != "" {
+		tmp, err := time.Parse(time.RFC3339, rawEnd)
+		if err != nil {
+			s.writeError(ctx, http.StatusBadRequest, fmt.Errorf("invalid end: %w", err))
+			return
+		}
+		end = &tmp
+	}
+
+	tl, err := s.Timeline(pathName, start, end)
+	if err != nil {
+		s.writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, timelineResponse{
+		Ranges:      timelineEntries(tl.Ranges),
+		Gaps:        timelineEntries(tl.Gaps),
+		Annotations: timelineAnnotationEntries(tl.Annotations),
+	})
+}
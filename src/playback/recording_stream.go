@@ -0,0 +1,312 @@
+package playback
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/av1"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+	"github.com/flynnletford/mediamtx/src/stream"
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+type recordingStreamTrack struct {
+	id        int
+	timeScale uint32
+	media     *description.Media
+	format    format.Format
+	newUnit   func(base unit.Base, payload []byte) (unit.Unit, error)
+}
+
+func newRecordingStreamTrack(track *fmp4.InitTrack) *recordingStreamTrack {
+	t := &recordingStreamTrack{
+		id:        track.ID,
+		timeScale: track.TimeScale,
+	}
+
+	switch codec := track.Codec.(type) {
+	case *fmp4.CodecAV1:
+		t.format = &format.AV1{
+			PayloadTyp: 96,
+		}
+
+		t.newUnit = func(base unit.Base, payload []byte) (unit.Unit, error) {
+			var tu av1.Bitstream
+			err := tu.Unmarshal(payload)
+			if err != nil {
+				return nil, err
+			}
+
+			return &unit.AV1{
+				Base: base,
+				TU:   tu,
+			}, nil
+		}
+
+	case *fmp4.CodecVP9:
+		t.format = &format.VP9{
+			PayloadTyp: 96,
+		}
+
+		t.newUnit = func(base unit.Base, payload []byte) (unit.Unit, error) {
+			return &unit.VP9{
+				Base:  base,
+				Frame: payload,
+			}, nil
+		}
+
+	case *fmp4.CodecH265:
+		t.format = &format.H265{
+			PayloadTyp: 96,
+			VPS:        codec.VPS,
+			SPS:        codec.SPS,
+			PPS:        codec.PPS,
+		}
+
+		t.newUnit = func(base unit.Base, payload []byte) (unit.Unit, error) {
+			var au h264.AVCC
+			err := au.Unmarshal(payload)
+			if err != nil {
+				return nil, err
+			}
+
+			return &unit.H265{
+				Base: base,
+				AU:   au,
+			}, nil
+		}
+
+	case *fmp4.CodecH264:
+		t.format = &format.H264{
+			PayloadTyp:        96,
+			PacketizationMode: 1,
+			SPS:               codec.SPS,
+			PPS:               codec.PPS,
+		}
+
+		t.newUnit = func(base unit.Base, payload []byte) (unit.Unit, error) {
+			var au h264.AVCC
+			err := au.Unmarshal(payload)
+			if err != nil {
+				return nil, err
+			}
+
+			return &unit.H264{
+				Base: base,
+				AU:   au,
+			}, nil
+		}
+
+	case *fmp4.CodecOpus:
+		t.format = &format.Opus{
+			PayloadTyp:   96,
+			ChannelCount: codec.ChannelCount,
+		}
+
+		t.newUnit = func(base unit.Base, payload []byte) (unit.Unit, error) {
+			return &unit.Opus{
+				Base:    base,
+				Packets: [][]byte{payload},
+			}, nil
+		}
+
+	case *fmp4.CodecMPEG4Audio:
+		t.format = &format.MPEG4Audio{
+			PayloadTyp:       96,
+			SizeLength:       13,
+			IndexLength:      3,
+			IndexDeltaLength: 3,
+			Config:           &codec.Config,
+		}
+
+		t.newUnit = func(base unit.Base, payload []byte) (unit.Unit, error) {
+			return &unit.MPEG4Audio{
+				Base: base,
+				AUs:  [][]byte{payload},
+			}, nil
+		}
+
+	case *fmp4.CodecLPCM:
+		// LPCM in RTP is big endian
+		if codec.LittleEndian {
+			return nil
+		}
+
+		t.format = &format.LPCM{
+			PayloadTyp:   96,
+			BitDepth:     codec.BitDepth,
+			SampleRate:   codec.SampleRate,
+			ChannelCount: codec.ChannelCount,
+		}
+
+		t.newUnit = func(base unit.Base, payload []byte) (unit.Unit, error) {
+			return &unit.LPCM{
+				Base:    base,
+				Samples: payload,
+			}, nil
+		}
+
+	default:
+		return nil
+	}
+
+	t.media = &description.Media{
+		Type: func() description.MediaType {
+			if track.Codec.IsVideo() {
+				return description.MediaTypeVideo
+			}
+			return description.MediaTypeAudio
+		}(),
+		Formats: []format.Format{t.format},
+	}
+
+	return t
+}
+
+// RecordingStream reads a recorded time range of a path and writes it into a stream, in real time.
+// This allows to serve recordings with protocols that are meant for live streams.
+type RecordingStream struct {
+	PathConf          *conf.Path
+	PathName          string
+	Start             time.Time
+	Duration          time.Duration
+	WriteQueueSize    int
+	UDPMaxPayloadSize int
+	Parent            logger.Writer
+
+	// filled by Initialize.
+	Stream *stream.Stream
+
+	ctx       context.Context
+	ctxCancel func()
+	segments  []*recordstore.Segment
+	tracks    []*recordingStreamTrack
+	started   bool
+	err       error
+
+	done chan struct{}
+}
+
+// Initialize initializes RecordingStream.
+func (s *RecordingStream) Initialize() error {
+	if s.WriteQueueSize == 0 {
+		s.WriteQueueSize = 512
+	}
+	if s.UDPMaxPayloadSize == 0 {
+		s.UDPMaxPayloadSize = 1472
+	}
+
+	if s.PathConf.RecordFormat != conf.RecordFormatFMP4 {
+		return fmt.Errorf("MPEG-TS format is not supported yet")
+	}
+
+	end := s.Start.Add(s.Duration)
+	segments, err := recordstore.FindSegments(s.PathConf, s.PathName, &s.Start, &end)
+	if err != nil {
+		return err
+	}
+
+	err = fetchSegments(context.Background(), s.PathConf, segments, s)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(segments[0].Fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	init, _, err := recordstore.FMP4ReadHeader(f)
+	if err != nil {
+		return err
+	}
+
+	var medias []*description.Media //nolint:prealloc
+
+	for _, track := range init.Tracks {
+		t := newRecordingStreamTrack(track)
+		if t == nil {
+			continue
+		}
+
+		s.tracks = append(s.tracks, t)
+		medias = append(medias, t.media)
+	}
+
+	if medias == nil {
+		return fmt.Errorf("recording doesn't contain any supported track")
+	}
+
+	s.Stream = &stream.Stream{
+		WriteQueueSize:     s.WriteQueueSize,
+		UDPMaxPayloadSize:  s.UDPMaxPayloadSize,
+		Desc:               &description.Session{Medias: medias},
+		GenerateRTPPackets: true,
+		Parent:             s,
+	}
+	err = s.Stream.Initialize()
+	if err != nil {
+		return err
+	}
+
+	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
+	s.segments = segments
+	s.done = make(chan struct{})
+
+	return nil
+}
+
+// Log implements logger.Writer.
+func (s *RecordingStream) Log(level logger.Level, format string, args ...interface{}) {
+	s.Parent.Log(level, "[recording] "+format, args...)
+}
+
+// Play starts writing the recording into the stream.
+// It must be called after readers have been added to the stream.
+func (s *RecordingStream) Play() {
+	s.started = true
+	go s.run()
+}
+
+// Close closes RecordingStream.
+func (s *RecordingStream) Close() {
+	s.ctxCancel()
+
+	if s.started {
+		<-s.done
+	}
+
+	s.Stream.Close()
+}
+
+// Done returns a channel that is closed when the whole time range has been written or an error occurred.
+func (s *RecordingStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error that caused the termination, if any.
+// It must be called after Done() has been closed.
+func (s *RecordingStream) Err() error {
+	return s.err
+}
+
+func (s *RecordingStream) run() {
+	defer close(s.done)
+
+	s.err = seekAndMux(conf.RecordFormatFMP4, s.segments, s.Start, s.Duration, &muxerStream{
+		ctx:    s.ctx,
+		start:  s.Start,
+		tracks: s.tracks,
+		stream: s.Stream,
+	})
+}
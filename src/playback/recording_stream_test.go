@@ -0,0 +1,179 @@
+package playback
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4/seekablebuffer"
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/test"
+	"github.com/flynnletford/mediamtx/src/unit"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSegmentH264MPEG4Audio(t *testing.T, fpath string) {
+	init := fmp4.Init{
+		Tracks: []*fmp4.InitTrack{
+			{
+				ID:        1,
+				TimeScale: 90000,
+				Codec: &fmp4.CodecH264{
+					SPS: test.FormatH264.SPS,
+					PPS: test.FormatH264.PPS,
+				},
+			},
+			{
+				ID:        2,
+				TimeScale: 48000,
+				Codec: &fmp4.CodecMPEG4Audio{
+					Config: mpeg4audio.Config{
+						Type:         mpeg4audio.ObjectTypeAACLC,
+						SampleRate:   48000,
+						ChannelCount: 2,
+					},
+				},
+			},
+		},
+	}
+
+	var buf1 seekablebuffer.Buffer
+	err := init.Marshal(&buf1)
+	require.NoError(t, err)
+
+	var parts fmp4.Parts
+
+	for i := 0; i < 2; i++ {
+		parts = append(parts, &fmp4.Part{
+			SequenceNumber: uint32(i),
+			Tracks: []*fmp4.PartTrack{
+				{
+					ID:       1,
+					BaseTime: uint64(i) * 18000,
+					Samples: []*fmp4.PartSample{
+						{
+							Duration: 9000,
+							Payload:  []byte{0, 0, 0, 1, 5},
+						},
+						{
+							Duration:        9000,
+							IsNonSyncSample: true,
+							Payload:         []byte{0, 0, 0, 1, 1},
+						},
+					},
+				},
+				{
+					ID:       2,
+					BaseTime: uint64(i) * 9600,
+					Samples: []*fmp4.PartSample{
+						{
+							Duration: 4800,
+							Payload:  []byte{byte(i), 1},
+						},
+						{
+							Duration: 4800,
+							Payload:  []byte{byte(i), 2},
+						},
+					},
+				},
+			},
+		})
+	}
+
+	var buf2 seekablebuffer.Buffer
+	err = parts.Marshal(&buf2)
+	require.NoError(t, err)
+
+	err = os.WriteFile(fpath, append(buf1.Bytes(), buf2.Bytes()...), 0o644)
+	require.NoError(t, err)
+}
+
+func TestRecordingStream(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-playback")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "mypath"), 0o755)
+	require.NoError(t, err)
+
+	writeSegmentH264MPEG4Audio(t, filepath.Join(dir, "mypath", "2008-11-07_11-22-00-000000.mp4"))
+
+	start := time.Date(2008, 11, 7, 11, 22, 0, 120000000, time.Local)
+
+	s := &RecordingStream{
+		PathConf: &conf.Path{
+			Name:       "mypath",
+			RecordPath: filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f"),
+		},
+		PathName: "mypath",
+		Start:    start,
+		Duration: 10 * time.Second,
+		Parent:   test.NilLogger,
+	}
+	err = s.Initialize()
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.Len(t, s.Stream.Desc.Medias, 2)
+
+	var mutex sync.Mutex
+	var received []unit.Unit
+
+	for _, medi := range s.Stream.Desc.Medias {
+		s.Stream.AddReader(test.NilLogger, medi, medi.Formats[0], func(u unit.Unit) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			received = append(received, u)
+			return nil
+		})
+	}
+
+	s.Stream.StartReader(test.NilLogger)
+	defer s.Stream.RemoveReader(test.NilLogger)
+
+	s.Play()
+
+	<-s.Done()
+	require.NoError(t, s.Err())
+
+	// wait for the reader to process all units
+	time.Sleep(100 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	type entry struct {
+		pts     int64
+		ntp     time.Time
+		payload [][]byte
+	}
+
+	var entries []entry
+
+	for _, u := range received {
+		switch tu := u.(type) {
+		case *unit.H264:
+			entries = append(entries, entry{tu.PTS, tu.NTP, tu.AU})
+		case *unit.MPEG4Audio:
+			entries = append(entries, entry{tu.PTS, tu.NTP, tu.AUs})
+		}
+	}
+
+	// parameter sets are prepended to IDRs by the stream
+	idr := [][]byte{test.FormatH264.SPS, test.FormatH264.PPS, {5}}
+
+	// units of different tracks with the same NTP timestamp can be received in any order.
+	require.ElementsMatch(t, []entry{
+		{-10800, start.Add(-120 * time.Millisecond), idr},
+		{-1800, start.Add(-20 * time.Millisecond), [][]byte{{1}}},
+		{-960, start.Add(-20 * time.Millisecond), [][]byte{{0, 2}}},
+		{7200, start.Add(80 * time.Millisecond), idr},
+		{3840, start.Add(80 * time.Millisecond), [][]byte{{1, 1}}},
+		{16200, start.Add(180 * time.Millisecond), [][]byte{{1}}},
+		{8640, start.Add(180 * time.Millisecond), [][]byte{{1, 2}}},
+	}, entries)
+}
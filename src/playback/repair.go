@@ -0,0 +1,45 @@
+package playback
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/flynnletford/mediamtx/src/recordstore"
+)
+
+// RepairSegment finalizes a fMP4 segment that has not been closed properly,
+// as in segments left behind by a crash or a power loss.
+// Incomplete parts are discarded and the duration is written into the header.
+// It returns false when the segment is already finalized.
+func RepairSegment(fpath string) (bool, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	init, duration, err := recordstore.FMP4ReadHeader(f)
+	if err != nil {
+		return false, fmt.Errorf("unable to read segment header: %w", err)
+	}
+
+	if duration != 0 {
+		return false, nil
+	}
+
+	headerSize, parts, err := recordstore.FMP4ReadParts(f, init)
+	if err != nil {
+		return false, err
+	}
+
+	if len(parts) == 0 {
+		return false, fmt.Errorf("segment does not contain any complete part")
+	}
+
+	err = writeSegmentParts(fpath, f, init, headerSize, parts, 0)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
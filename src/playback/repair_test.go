@@ -0,0 +1,57 @@
+package playback
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/recordstore"
+)
+
+func TestRepairSegment(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-playback")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "2008-11-07_11-22-00-000000.mp4")
+	writeSegment4Parts(t, fpath)
+
+	// simulate a crash: the duration is missing and the last part is truncated.
+	func() {
+		var f *os.File
+		f, err = os.OpenFile(fpath, os.O_RDWR, 0o644)
+		require.NoError(t, err)
+		defer f.Close()
+
+		err = segmentFMP4WriteDuration(f, 0)
+		require.NoError(t, err)
+
+		var fi os.FileInfo
+		fi, err = f.Stat()
+		require.NoError(t, err)
+
+		err = f.Truncate(fi.Size() - 1)
+		require.NoError(t, err)
+	}()
+
+	info, err := recordstore.InspectSegment(fpath)
+	require.NoError(t, err)
+	require.False(t, info.Finalized())
+	require.Equal(t, 3, info.PartCount)
+
+	repaired, err := RepairSegment(fpath)
+	require.NoError(t, err)
+	require.True(t, repaired)
+
+	info, err = recordstore.InspectSegment(fpath)
+	require.NoError(t, err)
+	require.True(t, info.Finalized())
+	require.Equal(t, 3*time.Second, info.Duration)
+
+	repaired, err = RepairSegment(fpath)
+	require.NoError(t, err)
+	require.False(t, repaired)
+}
@@ -2,6 +2,7 @@ package playback
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -60,77 +61,6 @@ func segmentFMP4CanBeConcatenated(
 		!curStart.After(prevEnd.Add(concatenationTolerance))
 }
 
-func segmentFMP4ReadHeader(r io.ReadSeeker) (*fmp4.Init, time.Duration, error) {
-	// check and skip ftyp
-
-	buf := make([]byte, 8)
-	_, err := io.ReadFull(r, buf)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	if !bytes.Equal(buf[4:], []byte{'f', 't', 'y', 'p'}) {
-		return nil, 0, fmt.Errorf("ftyp box not found")
-	}
-
-	ftypSize := uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
-
-	_, err = r.Seek(int64(ftypSize), io.SeekStart)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	// check moov
-
-	_, err = io.ReadFull(r, buf)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	if !bytes.Equal(buf[4:], []byte{'m', 'o', 'o', 'v'}) {
-		return nil, 0, fmt.Errorf("moov box not found")
-	}
-
-	moovSize := uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
-
-	// skip moov header
-
-	_, err = r.Seek(8, io.SeekCurrent)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	// read mvhd
-
-	var mvhd mp4.Mvhd
-	mvhdSize, err := mp4.Unmarshal(r, uint64(moovSize-8), &mvhd, mp4.Context{})
-	if err != nil {
-		return nil, 0, err
-	}
-
-	mvhdSize += 8                    // add mvhd header
-	moovSize -= 8 + uint32(mvhdSize) // remove moov header and mvhd
-
-	d := time.Duration(mvhd.DurationV0) * time.Second / time.Duration(mvhd.Timescale)
-
-	// read tracks
-
-	buf = make([]byte, uint64(moovSize))
-
-	_, err = io.ReadFull(r, buf)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	var init fmp4.Init
-	err = init.Unmarshal(bytes.NewReader(buf))
-	if err != nil {
-		return nil, 0, err
-	}
-
-	return &init, d, nil
-}
-
 func segmentFMP4ReadDurationFromParts(
 	r io.ReadSeeker,
 	init *fmp4.Init,
@@ -193,6 +123,17 @@ func segmentFMP4ReadDurationFromParts(
 			break
 		}
 
+		// skip segment index and reserved space
+		if bytes.Equal(buf[4:], []byte{'s', 'i', 'd', 'x'}) || bytes.Equal(buf[4:], []byte{'f', 'r', 'e', 'e'}) {
+			boxSize := uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
+
+			_, err = r.Seek(int64(boxSize)-8, io.SeekCurrent)
+			if err != nil {
+				break
+			}
+			continue
+		}
+
 		if !bytes.Equal(buf[4:], []byte{'m', 'o', 'o', 'f'}) {
 			return 0, fmt.Errorf("moof box not found")
 		}
@@ -368,6 +309,20 @@ outer:
 	return maxElapsed, nil
 }
 
+func segmentFMP4FileSize(r io.Seeker) (uint64, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(size), nil
+}
+
 func segmentFMP4SeekAndMuxParts(
 	r readSeekerAt,
 	segmentStartOffset time.Duration,
@@ -385,7 +340,12 @@ func segmentFMP4SeekAndMuxParts(
 	var maxMuxerDTS time.Duration
 	breakAtNextMdat := false
 
-	_, err := mp4.ReadBoxStructure(r, func(h *mp4.ReadHandle) (interface{}, error) {
+	fileSize, err := segmentFMP4FileSize(r)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = mp4.ReadBoxStructure(r, func(h *mp4.ReadHandle) (interface{}, error) {
 		switch h.BoxInfo.Type.String() {
 		case "moof":
 			moofOffset = h.BoxInfo.Offset
@@ -442,6 +402,14 @@ func segmentFMP4SeekAndMuxParts(
 				sampleOffset := dataOffset
 				sampleSize := e.SampleSize
 
+				// sample is partially or totally missing, as in segments that are still being written
+				if (sampleOffset + uint64(sampleSize)) > fileSize {
+					m.dropSample()
+					dataOffset += uint64(e.SampleSize)
+					muxerDTS += int64(e.SampleDuration)
+					continue
+				}
+
 				err = m.writeSample(
 					muxerDTS,
 					e.SampleCompositionTimeOffsetV1,
@@ -513,7 +481,12 @@ func segmentFMP4MuxParts(
 	var maxMuxerDTS time.Duration
 	breakAtNextMdat := false
 
-	_, err := mp4.ReadBoxStructure(r, func(h *mp4.ReadHandle) (interface{}, error) {
+	fileSize, err := segmentFMP4FileSize(r)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = mp4.ReadBoxStructure(r, func(h *mp4.ReadHandle) (interface{}, error) {
 		switch h.BoxInfo.Type.String() {
 		case "moof":
 			moofOffset = h.BoxInfo.Offset
@@ -566,6 +539,14 @@ func segmentFMP4MuxParts(
 				sampleOffset := dataOffset
 				sampleSize := e.SampleSize
 
+				// sample is partially or totally missing, as in segments that are still being written
+				if (sampleOffset + uint64(sampleSize)) > fileSize {
+					m.dropSample()
+					dataOffset += uint64(e.SampleSize)
+					muxerDTS += int64(e.SampleDuration)
+					continue
+				}
+
 				err = m.writeSample(
 					muxerDTS,
 					e.SampleCompositionTimeOffsetV1,
@@ -616,3 +597,306 @@ func segmentFMP4MuxParts(
 
 	return maxMuxerDTS, nil
 }
+
+type segmentFMP4Sample struct {
+	offset uint64
+	size   uint32
+
+	// decode time, relative to the start of the segment
+	dts time.Duration
+}
+
+// segmentFMP4FindKeyframe returns the complete sync sample of a track
+// whose decode time is the nearest to the given offset.
+func segmentFMP4FindKeyframe(
+	r io.ReadSeeker,
+	init *fmp4.Init,
+	trackID int,
+	offset time.Duration,
+) (*segmentFMP4Sample, error) {
+	track := findInitTrack(init.Tracks, trackID)
+	if track == nil {
+		return nil, fmt.Errorf("invalid track ID: %v", trackID)
+	}
+
+	fileSize, err := segmentFMP4FileSize(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *segmentFMP4Sample
+	var bestDistance time.Duration
+	moofOffset := uint64(0)
+	var tfhd *mp4.Tfhd
+	var tfdt *mp4.Tfdt
+
+	_, err = mp4.ReadBoxStructure(r, func(h *mp4.ReadHandle) (interface{}, error) {
+		switch h.BoxInfo.Type.String() {
+		case "moof":
+			moofOffset = h.BoxInfo.Offset
+			return h.Expand()
+
+		case "traf":
+			return h.Expand()
+
+		case "tfhd":
+			box, _, err := h.ReadPayload()
+			if err != nil {
+				return nil, err
+			}
+			tfhd = box.(*mp4.Tfhd)
+
+		case "tfdt":
+			box, _, err := h.ReadPayload()
+			if err != nil {
+				return nil, err
+			}
+			tfdt = box.(*mp4.Tfdt)
+
+		case "trun":
+			if int(tfhd.TrackID) != trackID {
+				return nil, nil
+			}
+
+			box, _, err := h.ReadPayload()
+			if err != nil {
+				return nil, err
+			}
+			trun := box.(*mp4.Trun)
+
+			dataOffset := moofOffset + uint64(trun.DataOffset)
+			dts := int64(tfdt.BaseMediaDecodeTimeV1)
+
+			for i, e := range trun.Entries {
+				flags := e.SampleFlags
+				if i == 0 && trun.CheckFlag(0x000004) {
+					flags = trun.FirstSampleFlags
+				}
+
+				// sample is partially or totally missing, as in segments that are still being written
+				if (dataOffset + uint64(e.SampleSize)) > fileSize {
+					return nil, errTerminated
+				}
+
+				if (flags & sampleFlagIsNonSyncSample) == 0 {
+					dtsGo := durationMp4ToGo(dts, track.TimeScale)
+
+					distance := dtsGo - offset
+					if distance < 0 {
+						distance = -distance
+					}
+
+					// following key frames are farther
+					if best != nil && distance > bestDistance {
+						return nil, errTerminated
+					}
+
+					best = &segmentFMP4Sample{
+						offset: dataOffset,
+						size:   e.SampleSize,
+						dts:    dtsGo,
+					}
+					bestDistance = distance
+				}
+
+				dataOffset += uint64(e.SampleSize)
+				dts += int64(e.SampleDuration)
+			}
+		}
+		return nil, nil
+	})
+	if err != nil && !errors.Is(err, errTerminated) && best == nil {
+		return nil, err
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("no key frames found")
+	}
+
+	return best, nil
+}
+
+func segmentFMP4ForEachBox(buf []byte, cb func(typ string, content []byte) error) error {
+	for len(buf) != 0 {
+		if len(buf) < 8 {
+			return fmt.Errorf("invalid box")
+		}
+
+		size := int(binary.BigEndian.Uint32(buf[:4]))
+		typ := string(buf[4:8])
+
+		if size < 8 || size > len(buf) {
+			return fmt.Errorf("invalid size of box '%s'", typ)
+		}
+
+		err := cb(typ, buf[8:size])
+		if err != nil {
+			return err
+		}
+
+		buf = buf[size:]
+	}
+
+	return nil
+}
+
+// segmentFMP4ShiftPart subtracts offset from the base decode time of every track of a part.
+func segmentFMP4ShiftPart(part []byte, init *fmp4.Init, offset time.Duration) error {
+	return segmentFMP4ForEachBox(part, func(typ string, content []byte) error {
+		if typ != "moof" {
+			return nil
+		}
+
+		return segmentFMP4ForEachBox(content, func(typ string, content []byte) error {
+			if typ != "traf" {
+				return nil
+			}
+
+			var track *fmp4.InitTrack
+
+			return segmentFMP4ForEachBox(content, func(typ string, content []byte) error {
+				switch typ {
+				case "tfhd":
+					if len(content) < 8 {
+						return fmt.Errorf("invalid tfhd box")
+					}
+
+					trackID := binary.BigEndian.Uint32(content[4:8])
+					track = findInitTrack(init.Tracks, int(trackID))
+					if track == nil {
+						return fmt.Errorf("invalid track ID: %v", trackID)
+					}
+
+				case "tfdt":
+					if track == nil {
+						return fmt.Errorf("tfhd box not found")
+					}
+
+					offsetMP4 := durationGoToMp4(offset, track.TimeScale)
+
+					switch {
+					case len(content) >= 12 && content[0] == 1:
+						v := int64(binary.BigEndian.Uint64(content[4:12])) - offsetMP4
+						if v < 0 {
+							v = 0
+						}
+						binary.BigEndian.PutUint64(content[4:12], uint64(v))
+
+					case len(content) >= 8 && content[0] == 0:
+						v := int64(binary.BigEndian.Uint32(content[4:8])) - offsetMP4
+						if v < 0 {
+							v = 0
+						}
+						binary.BigEndian.PutUint32(content[4:8], uint32(v))
+
+					default:
+						return fmt.Errorf("invalid tfdt box")
+					}
+				}
+
+				return nil
+			})
+		})
+	})
+}
+
+// segmentFMP4WriteParts writes a segment made of the header of the source segment
+// and of the given parts, shifted back by offset.
+func segmentFMP4WriteParts(
+	w io.Writer,
+	r io.ReaderAt,
+	init *fmp4.Init,
+	headerSize uint64,
+	parts []*recordstore.FMP4Part,
+	offset time.Duration,
+) error {
+	_, err := io.Copy(w, io.NewSectionReader(r, 0, int64(headerSize)))
+	if err != nil {
+		return err
+	}
+
+	for _, part := range parts {
+		buf := make([]byte, part.Size)
+		_, err = r.ReadAt(buf, int64(part.Offset))
+		if err != nil {
+			return err
+		}
+
+		if offset != 0 {
+			err = segmentFMP4ShiftPart(buf, init, offset)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = w.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// segmentFMP4WriteDuration writes the duration into the movie header.
+func segmentFMP4WriteDuration(f io.ReadWriteSeeker, d time.Duration) error {
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	// check and skip ftyp header and content
+
+	buf := make([]byte, 8)
+	_, err = io.ReadFull(f, buf)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(buf[4:], []byte{'f', 't', 'y', 'p'}) {
+		return fmt.Errorf("ftyp box not found")
+	}
+
+	ftypSize := uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
+
+	_, err = f.Seek(int64(ftypSize), io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	// check and skip moov header
+
+	_, err = io.ReadFull(f, buf)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(buf[4:], []byte{'m', 'o', 'o', 'v'}) {
+		return fmt.Errorf("moov box not found")
+	}
+
+	moovSize := uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
+
+	// skip mvhd header
+
+	moovPos, err := f.Seek(8, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	var mvhd mp4.Mvhd
+	_, err = mp4.Unmarshal(f, uint64(moovSize-8), &mvhd, mp4.Context{})
+	if err != nil {
+		return err
+	}
+
+	mvhd.DurationV0 = uint32(d * time.Duration(mvhd.Timescale) / time.Second)
+
+	_, err = f.Seek(moovPos, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	_, err = mp4.Marshal(f, &mvhd, mp4.Context{})
+	return err
+}
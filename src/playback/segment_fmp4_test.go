@@ -7,6 +7,7 @@ import (
 
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/flynnletford/mediamtx/src/recordstore"
 	"github.com/flynnletford/mediamtx/src/test"
 )
 
@@ -66,7 +67,7 @@ func BenchmarkFMP4ReadHeader(b *testing.B) {
 			}
 			defer f.Close()
 
-			_, _, err = segmentFMP4ReadHeader(f)
+			_, _, err = recordstore.FMP4ReadHeader(f)
 			if err != nil {
 				panic(err)
 			}
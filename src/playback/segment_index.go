@@ -0,0 +1,120 @@
+package playback
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+)
+
+const (
+	segmentIndexMaxEntries = 100000
+)
+
+type segmentIndexEntry struct {
+	size    int64
+	modTime time.Time
+	parsed  *parsedSegment
+}
+
+// segmentIndex caches parsed segments, in order to avoid parsing them
+// every time they are requested.
+// Entries are invalidated when the size or the modification time of segments change,
+// therefore segments that are being written are parsed again.
+type segmentIndex struct {
+	mutex   sync.Mutex
+	entries map[string]*segmentIndexEntry
+}
+
+func (i *segmentIndex) initialize() {
+	i.entries = make(map[string]*segmentIndexEntry)
+}
+
+// parseSegments parses segments, using cached results when possible.
+// Segments that can't be parsed are discarded.
+func (i *segmentIndex) parseSegments(segments []*recordstore.Segment, log logger.Writer) []*parsedSegment {
+	parsed := make([]*parsedSegment, len(segments))
+	stats := make([]os.FileInfo, len(segments))
+	var missing []*recordstore.Segment
+	var missingPos []int
+
+	i.mutex.Lock()
+
+	for j, seg := range segments {
+		// segments whose local copy has been removed are parsed every time,
+		// since parsing them doesn't require reading the segment.
+		if seg.Remote {
+			missing = append(missing, seg)
+			missingPos = append(missingPos, j)
+			continue
+		}
+
+		stat, err := os.Stat(seg.Fpath)
+		if err != nil {
+			delete(i.entries, seg.Fpath)
+			continue
+		}
+		stats[j] = stat
+
+		entry, ok := i.entries[seg.Fpath]
+		if ok && entry.size == stat.Size() && entry.modTime.Equal(stat.ModTime()) {
+			parsed[j] = entry.parsed
+			continue
+		}
+
+		missing = append(missing, seg)
+		missingPos = append(missingPos, j)
+	}
+
+	i.mutex.Unlock()
+
+	if len(missing) != 0 {
+		missingParsed := parseSegments(missing, log)
+
+		i.mutex.Lock()
+
+		// prevent the cache from growing indefinitely
+		if len(i.entries)+len(missing) > segmentIndexMaxEntries {
+			i.entries = make(map[string]*segmentIndexEntry)
+		}
+
+		for k, p := range missingParsed {
+			if p == nil {
+				continue
+			}
+
+			j := missingPos[k]
+			parsed[j] = p
+
+			if stats[j] == nil {
+				continue
+			}
+
+			i.entries[missing[k].Fpath] = &segmentIndexEntry{
+				size:    stats[j].Size(),
+				modTime: stats[j].ModTime(),
+				parsed:  p,
+			}
+		}
+
+		i.mutex.Unlock()
+	}
+
+	out := parsed[:0]
+	for _, p := range parsed {
+		if p != nil {
+			out = append(out, p)
+		}
+	}
+
+	return out
+}
+
+// invalidate removes the cached result of a segment that has been modified or deleted.
+func (i *segmentIndex) invalidate(fpath string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	delete(i.entries, fpath)
+}
@@ -32,12 +32,25 @@ type Server struct {
 	AuthManager    serverAuthManager
 	Parent         logger.Writer
 
+	// optional; converts key frames into JPEG thumbnails.
+	ThumbnailDecoder ThumbnailDecoder
+
+	// optional; called when a segment is deleted or trimmed.
+	OnSegmentDelete OnSegmentDeleteFunc
+
+	// optional; returns annotations that are included into timelines.
+	Annotations AnnotationsFunc
+
 	httpServer *httpp.Server
+	index      *segmentIndex
 	mutex      sync.RWMutex
 }
 
 // Initialize initializes Server.
 func (s *Server) Initialize() error {
+	s.index = &segmentIndex{}
+	s.index.initialize()
+
 	router := gin.New()
 	router.SetTrustedProxies(s.TrustedProxies.ToTrustedProxies()) //nolint:errcheck
 
@@ -45,6 +58,14 @@ func (s *Server) Initialize() error {
 
 	router.GET("/list", s.onList)
 	router.GET("/get", s.onGet)
+	router.GET("/export", s.onExport)
+	router.GET("/timeline", s.onTimeline)
+	router.GET("/signedurls", s.onSignedURLs)
+	router.GET("/bundle", s.onBundle)
+	router.GET("/hls/index.m3u8", s.onHLSPlaylist)
+	router.GET("/hls/segment.mp4", s.onHLSSegment)
+	router.GET("/thumbnail", s.onThumbnail)
+	router.DELETE("/delete", s.onDelete)
 
 	network, address := restrictnetwork.Restrict("tcp", s.Address)
 
@@ -109,7 +130,7 @@ func (s *Server) middlewareOrigin(ctx *gin.Context) {
 	// preflight requests
 	if ctx.Request.Method == http.MethodOptions &&
 		ctx.Request.Header.Get("Access-Control-Request-Method") != "" {
-		ctx.Header("Access-Control-Allow-Methods", "OPTIONS, GET")
+		ctx.Header("Access-Control-Allow-Methods", "OPTIONS, GET, DELETE")
 		ctx.Header("Access-Control-Allow-Headers", "Authorization")
 		ctx.AbortWithStatus(http.StatusNoContent)
 		return
@@ -117,9 +138,13 @@ func (s *Server) middlewareOrigin(ctx *gin.Context) {
 }
 
 func (s *Server) doAuth(ctx *gin.Context, pathName string) bool {
+	return s.doAuthAction(ctx, pathName, conf.AuthActionPlayback)
+}
+
+func (s *Server) doAuthAction(ctx *gin.Context, pathName string, action conf.AuthAction) bool {
 	req := &auth.Request{
 		IP:     net.ParseIP(ctx.ClientIP()),
-		Action: conf.AuthActionPlayback,
+		Action: action,
 		Path:   pathName,
 	}
 	req.FillFromHTTPRequest(ctx.Request)
@@ -42,7 +42,7 @@ func TestPreflightRequest(t *testing.T) {
 
 	require.Equal(t, "*", res.Header.Get("Access-Control-Allow-Origin"))
 	require.Equal(t, "true", res.Header.Get("Access-Control-Allow-Credentials"))
-	require.Equal(t, "OPTIONS, GET", res.Header.Get("Access-Control-Allow-Methods"))
+	require.Equal(t, "OPTIONS, GET, DELETE", res.Header.Get("Access-Control-Allow-Methods"))
 	require.Equal(t, "Authorization", res.Header.Get("Access-Control-Allow-Headers"))
 	require.Equal(t, byts, []byte{})
 }
@@ -0,0 +1,245 @@
+// Package mqtt contains a minimal MQTT 3.1.1 client.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	packetTypeConnect    = 1
+	packetTypeConnack    = 2
+	packetTypePublish    = 3
+	packetTypePuback     = 4
+	packetTypePingreq    = 12
+	packetTypePingresp   = 13
+	packetTypeDisconnect = 14
+
+	protocolLevel     = 4
+	maxPacketSize     = 256 * 1024 * 1024
+	maxRemainingBytes = 4
+)
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+func appendRemainingLength(buf []byte, l int) []byte {
+	for {
+		b := byte(l % 128)
+		l /= 128
+		if l > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if l == 0 {
+			return buf
+		}
+	}
+}
+
+// Conn is a MQTT client connection.
+// It does not support subscriptions and QoS 2.
+type Conn struct {
+	RW io.ReadWriter
+
+	br           *bufio.Reader
+	nextPacketID uint16
+}
+
+// Initialize initializes Conn.
+func (c *Conn) Initialize() {
+	c.br = bufio.NewReader(c.RW)
+	c.nextPacketID = 1
+}
+
+func (c *Conn) writePacket(typ byte, flags byte, body []byte) error {
+	buf := []byte{typ<<4 | flags}
+	buf = appendRemainingLength(buf, len(body))
+	buf = append(buf, body...)
+	_, err := c.RW.Write(buf)
+	return err
+}
+
+func (c *Conn) readPacket() (byte, byte, []byte, error) {
+	header, err := c.br.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	l := 0
+	mult := 1
+
+	for i := 0; ; i++ {
+		if i >= maxRemainingBytes {
+			return 0, 0, nil, fmt.Errorf("invalid remaining length")
+		}
+
+		var b byte
+		b, err = c.br.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+
+		l += int(b&0x7F) * mult
+		mult *= 128
+
+		if (b & 0x80) == 0 {
+			break
+		}
+	}
+
+	if l > maxPacketSize {
+		return 0, 0, nil, fmt.Errorf("packet size (%d) is too big", l)
+	}
+
+	body := make([]byte, l)
+	_, err = io.ReadFull(c.br, body)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	return header >> 4, header & 0x0F, body, nil
+}
+
+// waitPacket reads packets until a packet of the given type is received.
+func (c *Conn) waitPacket(typ byte) ([]byte, error) {
+	for {
+		t, _, body, err := c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t {
+		case typ:
+			return body, nil
+
+		case packetTypePingresp, packetTypePuback:
+			// ignore responses to previous requests
+
+		default:
+			return nil, fmt.Errorf("unexpected packet type %d", t)
+		}
+	}
+}
+
+// Connect sends a CONNECT packet and waits for the CONNACK packet.
+// keepAlive is expressed in seconds.
+func (c *Conn) Connect(clientID string, user string, pass string, keepAlive uint16) error {
+	flags := byte(0x02) // clean session
+
+	if user != "" {
+		flags |= 0x80
+		if pass != "" {
+			flags |= 0x40
+		}
+	}
+
+	body := appendString(nil, "MQTT")
+	body = append(body, protocolLevel, flags)
+	body = binary.BigEndian.AppendUint16(body, keepAlive)
+	body = appendString(body, clientID)
+
+	if user != "" {
+		body = appendString(body, user)
+		if pass != "" {
+			body = appendString(body, pass)
+		}
+	}
+
+	err := c.writePacket(packetTypeConnect, 0, body)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.waitPacket(packetTypeConnack)
+	if err != nil {
+		return err
+	}
+
+	if len(res) != 2 {
+		return fmt.Errorf("invalid CONNACK packet")
+	}
+
+	if res[1] != 0 {
+		return fmt.Errorf("connection refused with code %d", res[1])
+	}
+
+	return nil
+}
+
+// Publish publishes a message.
+// With QoS 1, it waits for the PUBACK packet.
+func (c *Conn) Publish(topic string, payload []byte, qos byte, retain bool) error {
+	if qos > 1 {
+		return fmt.Errorf("unsupported QoS: %d", qos)
+	}
+
+	flags := qos << 1
+	if retain {
+		flags |= 0x01
+	}
+
+	body := appendString(nil, topic)
+
+	var packetID uint16
+
+	if qos != 0 {
+		packetID = c.nextPacketID
+		c.nextPacketID++
+		if c.nextPacketID == 0 {
+			c.nextPacketID = 1
+		}
+
+		body = binary.BigEndian.AppendUint16(body, packetID)
+	}
+
+	body = append(body, payload...)
+
+	err := c.writePacket(packetTypePublish, flags, body)
+	if err != nil {
+		return err
+	}
+
+	if qos == 0 {
+		return nil
+	}
+
+	for {
+		t, _, res, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+
+		if t == packetTypePuback {
+			if len(res) != 2 {
+				return fmt.Errorf("invalid PUBACK packet")
+			}
+
+			if binary.BigEndian.Uint16(res) == packetID {
+				return nil
+			}
+		} else if t != packetTypePingresp {
+			return fmt.Errorf("unexpected packet type %d", t)
+		}
+	}
+}
+
+// Ping sends a PINGREQ packet and waits for the PINGRESP packet.
+func (c *Conn) Ping() error {
+	err := c.writePacket(packetTypePingreq, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.waitPacket(packetTypePingresp)
+	return err
+}
+
+// Disconnect sends a DISCONNECT packet.
+func (c *Conn) Disconnect() error {
+	return c.writePacket(packetTypeDisconnect, 0, nil)
+}
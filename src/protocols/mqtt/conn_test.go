@@ -0,0 +1,122 @@
+package mqtt
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendRemainingLength(t *testing.T) {
+	for _, ca := range []struct {
+		l   int
+		enc []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7F}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xFF, 0x7F}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+	} {
+		require.Equal(t, ca.enc, appendRemainingLength(nil, ca.l))
+	}
+}
+
+func TestConn(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer serverSide.Close()
+
+		buf := make([]byte, 38)
+		_, err := io.ReadFull(serverSide, buf)
+		require.NoError(t, err)
+		require.Equal(t, []byte{
+			0x10, 36,
+			0x00, 0x04, 'M', 'Q', 'T', 'T',
+			0x04, 0xC2, 0x00, 0x3C,
+			0x00, 0x08, 'm', 'e', 'd', 'i', 'a', 'm', 't', 'x',
+			0x00, 0x06, 'm', 'y', 'u', 's', 'e', 'r',
+			0x00, 0x06, 'm', 'y', 'p', 'a', 's', 's',
+		}, buf)
+
+		_, err = serverSide.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		require.NoError(t, err)
+
+		buf = make([]byte, 21)
+		_, err = io.ReadFull(serverSide, buf)
+		require.NoError(t, err)
+		require.Equal(t, []byte{
+			0x33, 19,
+			0x00, 0x0C, 'm', 'y', 't', 'o', 'p', 'i', 'c', '/', 'p', 'a', 't', 'h',
+			0x00, 0x01,
+			'a', 'b', 'c',
+		}, buf)
+
+		// a PINGRESP before the PUBACK must be ignored
+		_, err = serverSide.Write([]byte{0xD0, 0x00, 0x40, 0x02, 0x00, 0x01})
+		require.NoError(t, err)
+
+		buf = make([]byte, 19)
+		_, err = io.ReadFull(serverSide, buf)
+		require.NoError(t, err)
+		require.Equal(t, []byte{
+			0x30, 17,
+			0x00, 0x0C, 'm', 'y', 't', 'o', 'p', 'i', 'c', '/', 'p', 'a', 't', 'h',
+			'd', 'e', 'f',
+		}, buf)
+
+		buf = make([]byte, 2)
+		_, err = io.ReadFull(serverSide, buf)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0xC0, 0x00}, buf)
+
+		_, err = serverSide.Write([]byte{0xD0, 0x00})
+		require.NoError(t, err)
+
+		buf = make([]byte, 2)
+		_, err = io.ReadFull(serverSide, buf)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0xE0, 0x00}, buf)
+	}()
+
+	c := &Conn{RW: clientSide}
+	c.Initialize()
+
+	err := c.Connect("mediamtx", "myuser", "mypass", 60)
+	require.NoError(t, err)
+
+	err = c.Publish("mytopic/path", []byte("abc"), 1, true)
+	require.NoError(t, err)
+
+	err = c.Publish("mytopic/path", []byte("def"), 0, false)
+	require.NoError(t, err)
+
+	err = c.Ping()
+	require.NoError(t, err)
+
+	err = c.Disconnect()
+	require.NoError(t, err)
+
+	<-done
+}
+
+func TestConnConnectRefused(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write([]byte{0x20, 0x02, 0x00, 0x05})
+
+	c := &Conn{RW: &struct {
+		io.Reader
+		io.Writer
+	}{&buf, io.Discard}}
+	c.Initialize()
+
+	err := c.Connect("mediamtx", "", "", 60)
+	require.EqualError(t, err, "connection refused with code 5")
+}
@@ -0,0 +1,486 @@
+// Package postgres contains a minimal PostgreSQL client.
+package postgres
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	protocolVersion = 196608
+	sslRequestCode  = 80877103
+	maxMessageSize  = 64 * 1024 * 1024
+)
+
+// authentication methods.
+const (
+	authOK           = 0
+	authCleartext    = 3
+	authMD5          = 5
+	authSASL         = 10
+	authSASLContinue = 11
+	authSASLFinal    = 12
+)
+
+// Error is an error returned by the server.
+// It doesn't affect the connection, that can be reused.
+type Error struct {
+	Severity string
+	Code     string
+	Message  string
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s (SQLSTATE %s)", e.Severity, e.Message, e.Code)
+}
+
+func parseError(body []byte) *Error {
+	e := &Error{}
+
+	for len(body) > 1 {
+		typ := body[0]
+		i := strings.IndexByte(string(body[1:]), 0)
+		if i < 0 {
+			break
+		}
+		v := string(body[1 : 1+i])
+		body = body[2+i:]
+
+		switch typ {
+		case 'S':
+			e.Severity = v
+		case 'C':
+			e.Code = v
+		case 'M':
+			e.Message = v
+		}
+	}
+
+	return e
+}
+
+// Result is the result of a query.
+type Result struct {
+	Columns []string
+
+	// values are in text format. NULL values are nil.
+	Rows [][]*string
+
+	// number of rows inserted, updated, deleted or returned.
+	RowsAffected int64
+}
+
+func appendCString(buf []byte, s string) []byte {
+	buf = append(buf, s...)
+	return append(buf, 0)
+}
+
+func readCString(b []byte) (string, []byte, error) {
+	i := strings.IndexByte(string(b), 0)
+	if i < 0 {
+		return "", nil, fmt.Errorf("invalid string")
+	}
+	return string(b[:i]), b[i+1:], nil
+}
+
+// encodeValue converts a parameter into its text format.
+func encodeValue(v interface{}) (*string, error) {
+	var s string
+
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		s = v
+	case int:
+		s = strconv.FormatInt(int64(v), 10)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case uint64:
+		s = strconv.FormatUint(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(v)
+	case time.Time:
+		s = v.Format(time.RFC3339Nano)
+	default:
+		return nil, fmt.Errorf("unsupported parameter type: %T", v)
+	}
+
+	return &s, nil
+}
+
+// RequestTLS asks the server to switch to TLS.
+// It must be called before Startup, on the raw connection.
+// It returns false if the server doesn't support TLS.
+func RequestTLS(rw io.ReadWriter) (bool, error) {
+	buf := binary.BigEndian.AppendUint32(nil, 8)
+	buf = binary.BigEndian.AppendUint32(buf, sslRequestCode)
+
+	_, err := rw.Write(buf)
+	if err != nil {
+		return false, err
+	}
+
+	var res [1]byte
+	_, err = io.ReadFull(rw, res[:])
+	if err != nil {
+		return false, err
+	}
+
+	switch res[0] {
+	case 'S':
+		return true, nil
+	case 'N':
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid response to TLS request")
+	}
+}
+
+// Conn is a PostgreSQL client connection.
+// Queries are performed one at a time with the extended query protocol,
+// parameters and results are exchanged in text format.
+type Conn struct {
+	RW io.ReadWriter
+
+	br *bufio.Reader
+}
+
+// Initialize initializes Conn.
+func (c *Conn) Initialize() {
+	c.br = bufio.NewReader(c.RW)
+}
+
+func (c *Conn) writeMessage(typ byte, body []byte) error {
+	buf := make([]byte, 0, 5+len(body))
+	buf = append(buf, typ)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(4+len(body)))
+	buf = append(buf, body...)
+	_, err := c.RW.Write(buf)
+	return err
+}
+
+func (c *Conn) readMessage() (byte, []byte, error) {
+	var header [5]byte
+	_, err := io.ReadFull(c.br, header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	l := binary.BigEndian.Uint32(header[1:])
+	if l < 4 || l > maxMessageSize {
+		return 0, nil, fmt.Errorf("invalid message length: %d", l)
+	}
+
+	body := make([]byte, l-4)
+	_, err = io.ReadFull(c.br, body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], body, nil
+}
+
+// Startup sends the startup message, performs authentication
+// and waits until the server is ready to receive queries.
+// Supported authentication methods are cleartext, MD5 and SCRAM-SHA-256.
+func (c *Conn) Startup(user string, pass string, database string) error {
+	body := binary.BigEndian.AppendUint32(nil, protocolVersion)
+	body = appendCString(body, "user")
+	body = appendCString(body, user)
+	if database != "" {
+		body = appendCString(body, "database")
+		body = appendCString(body, database)
+	}
+	body = appendCString(body, "application_name")
+	body = appendCString(body, "mediamtx")
+	body = append(body, 0)
+
+	buf := binary.BigEndian.AppendUint32(nil, uint32(4+len(body)))
+	buf = append(buf, body...)
+
+	_, err := c.RW.Write(buf)
+	if err != nil {
+		return err
+	}
+
+	var sc *scramClient
+
+	for {
+		typ, res, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+
+		switch typ {
+		case 'R':
+			if len(res) < 4 {
+				return fmt.Errorf("invalid authentication message")
+			}
+			method := binary.BigEndian.Uint32(res)
+			res = res[4:]
+
+			switch method {
+			case authOK:
+
+			case authCleartext:
+				err = c.writeMessage('p', appendCString(nil, pass))
+				if err != nil {
+					return err
+				}
+
+			case authMD5:
+				if len(res) != 4 {
+					return fmt.Errorf("invalid authentication message")
+				}
+				err = c.writeMessage('p', appendCString(nil, md5Password(user, pass, res)))
+				if err != nil {
+					return err
+				}
+
+			case authSASL:
+				if !strings.Contains(string(res), scramMechanism+"\x00") {
+					return fmt.Errorf("server doesn't support %s", scramMechanism)
+				}
+
+				sc, err = newSCRAMClient("", pass)
+				if err != nil {
+					return err
+				}
+
+				first := sc.clientFirst()
+				msg := appendCString(nil, scramMechanism)
+				msg = binary.BigEndian.AppendUint32(msg, uint32(len(first)))
+				msg = append(msg, first...)
+
+				err = c.writeMessage('p', msg)
+				if err != nil {
+					return err
+				}
+
+			case authSASLContinue:
+				if sc == nil {
+					return fmt.Errorf("unexpected SASL message")
+				}
+
+				var final string
+				final, err = sc.clientFinal(string(res))
+				if err != nil {
+					return err
+				}
+
+				err = c.writeMessage('p', []byte(final))
+				if err != nil {
+					return err
+				}
+
+			case authSASLFinal:
+				if sc == nil {
+					return fmt.Errorf("unexpected SASL message")
+				}
+
+				err = sc.verifyServerFinal(string(res))
+				if err != nil {
+					return err
+				}
+
+			default:
+				return fmt.Errorf("unsupported authentication method: %d", method)
+			}
+
+		case 'E':
+			return parseError(res)
+
+		case 'Z':
+			return nil
+
+		case 'S', 'K', 'N':
+			// parameter statuses, key data and notices are ignored
+		default:
+			return fmt.Errorf("unexpected message type: %c", typ)
+		}
+	}
+}
+
+func md5Password(user string, pass string, salt []byte) string {
+	h := md5.Sum([]byte(pass + user))
+	h = md5.Sum(append([]byte(hex.EncodeToString(h[:])), salt...))
+	return "md5" + hex.EncodeToString(h[:])
+}
+
+// Query performs a query.
+// Parameters are referenced in the query with $1, $2, etc.
+// Supported parameter types are nil, string, int, int64, uint64, float64, bool and time.Time.
+func (c *Conn) Query(query string, args ...interface{}) (*Result, error) {
+	parse := appendCString(nil, "")
+	parse = appendCString(parse, query)
+	parse = binary.BigEndian.AppendUint16(parse, 0)
+
+	bind := appendCString(nil, "")
+	bind = appendCString(bind, "")
+	bind = binary.BigEndian.AppendUint16(bind, 0)
+	bind = binary.BigEndian.AppendUint16(bind, uint16(len(args)))
+
+	for _, arg := range args {
+		v, err := encodeValue(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if v == nil {
+			bind = binary.BigEndian.AppendUint32(bind, 0xFFFFFFFF)
+		} else {
+			bind = binary.BigEndian.AppendUint32(bind, uint32(len(*v)))
+			bind = append(bind, *v...)
+		}
+	}
+
+	bind = binary.BigEndian.AppendUint16(bind, 0)
+
+	execute := appendCString(nil, "")
+	execute = binary.BigEndian.AppendUint32(execute, 0)
+
+	var buf []byte
+	for _, msg := range []struct {
+		typ  byte
+		body []byte
+	}{
+		{'P', parse},
+		{'B', bind},
+		{'D', appendCString([]byte{'P'}, "")},
+		{'E', execute},
+		{'S', nil},
+	} {
+		buf = append(buf, msg.typ)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(4+len(msg.body)))
+		buf = append(buf, msg.body...)
+	}
+
+	_, err := c.RW.Write(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{}
+	var queryErr error
+
+	for {
+		typ, body, err := c.readMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		switch typ {
+		case 'T':
+			res.Columns, err = parseRowDescription(body)
+			if err != nil {
+				return nil, err
+			}
+
+		case 'D':
+			var row []*string
+			row, err = parseDataRow(body)
+			if err != nil {
+				return nil, err
+			}
+			res.Rows = append(res.Rows, row)
+
+		case 'C':
+			tag, _, err := readCString(body)
+			if err != nil {
+				return nil, err
+			}
+			fields := strings.Fields(tag)
+			if len(fields) > 1 {
+				res.RowsAffected, _ = strconv.ParseInt(fields[len(fields)-1], 10, 64)
+			}
+
+		case 'E':
+			// the server discards messages until Sync, then sends ReadyForQuery.
+			queryErr = parseError(body)
+
+		case 'Z':
+			if queryErr != nil {
+				return nil, queryErr
+			}
+			return res, nil
+
+		case '1', '2', 'n', 'I', 'S', 'N':
+			// parse complete, bind complete, no data, empty query, parameter statuses and notices are ignored
+		default:
+			return nil, fmt.Errorf("unexpected message type: %c", typ)
+		}
+	}
+}
+
+func parseRowDescription(body []byte) ([]string, error) {
+	if len(body) < 2 {
+		return nil, fmt.Errorf("invalid row description")
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+
+	columns := make([]string, n)
+
+	for i := range columns {
+		var err error
+		columns[i], body, err = readCString(body)
+		if err != nil {
+			return nil, err
+		}
+
+		// table OID, column number, type OID, type size, type modifier, format
+		if len(body) < 18 {
+			return nil, fmt.Errorf("invalid row description")
+		}
+		body = body[18:]
+	}
+
+	return columns, nil
+}
+
+func parseDataRow(body []byte) ([]*string, error) {
+	if len(body) < 2 {
+		return nil, fmt.Errorf("invalid data row")
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+
+	row := make([]*string, n)
+
+	for i := range row {
+		if len(body) < 4 {
+			return nil, fmt.Errorf("invalid data row")
+		}
+		l := int32(binary.BigEndian.Uint32(body))
+		body = body[4:]
+
+		if l < 0 {
+			continue
+		}
+
+		if len(body) < int(l) {
+			return nil, fmt.Errorf("invalid data row")
+		}
+		v := string(body[:l])
+		row[i] = &v
+		body = body[l:]
+	}
+
+	return row, nil
+}
+
+// Terminate sends a Terminate message.
+func (c *Conn) Terminate() error {
+	return c.writeMessage('X', nil)
+}
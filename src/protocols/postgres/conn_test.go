@@ -0,0 +1,166 @@
+package postgres
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSCRAM(t *testing.T) {
+	// example of RFC 7677
+	c := &scramClient{
+		user:        "user",
+		pass:        "pencil",
+		clientNonce: "rOprNGfwEbeRWgbNEkqO",
+	}
+
+	require.Equal(t, "n,,n=user,r=rOprNGfwEbeRWgbNEkqO", c.clientFirst())
+
+	final, err := c.clientFinal("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0," +
+		"s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	require.NoError(t, err)
+	require.Equal(t, "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,"+
+		"p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=", final)
+
+	err = c.verifyServerFinal("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")
+	require.NoError(t, err)
+
+	err = c.verifyServerFinal("v=AAAATRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")
+	require.Error(t, err)
+}
+
+func writeTestMessage(t *testing.T, w io.Writer, typ byte, body []byte) {
+	buf := []byte{typ}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(4+len(body)))
+	buf = append(buf, body...)
+	_, err := w.Write(buf)
+	require.NoError(t, err)
+}
+
+func readTestMessage(t *testing.T, r io.Reader) (byte, []byte) {
+	var header [5]byte
+	_, err := io.ReadFull(r, header[:])
+	require.NoError(t, err)
+
+	body := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+	_, err = io.ReadFull(r, body)
+	require.NoError(t, err)
+
+	return header[0], body
+}
+
+func TestConn(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer serverSide.Close()
+
+		br := bufio.NewReader(serverSide)
+
+		var l [4]byte
+		_, err := io.ReadFull(br, l[:])
+		require.NoError(t, err)
+
+		body := make([]byte, binary.BigEndian.Uint32(l[:])-4)
+		_, err = io.ReadFull(br, body)
+		require.NoError(t, err)
+		require.Equal(t, append(binary.BigEndian.AppendUint32(nil, protocolVersion),
+			"user\x00myuser\x00database\x00mydb\x00application_name\x00mediamtx\x00\x00"...), body)
+
+		writeTestMessage(t, serverSide, 'R', []byte{0, 0, 0, authMD5, 1, 2, 3, 4})
+
+		typ, body := readTestMessage(t, br)
+		require.Equal(t, byte('p'), typ)
+		require.Equal(t, appendCString(nil, md5Password("myuser", "mypass", []byte{1, 2, 3, 4})), body)
+
+		writeTestMessage(t, serverSide, 'R', []byte{0, 0, 0, authOK})
+		writeTestMessage(t, serverSide, 'S', []byte("TimeZone\x00UTC\x00"))
+		writeTestMessage(t, serverSide, 'Z', []byte{'I'})
+
+		typ, body = readTestMessage(t, br)
+		require.Equal(t, byte('P'), typ)
+		require.Equal(t, []byte("\x00SELECT a, b FROM t WHERE c = $1 AND d = $2\x00\x00\x00"), body)
+
+		typ, body = readTestMessage(t, br)
+		require.Equal(t, byte('B'), typ)
+		require.Equal(t, []byte{
+			0, 0, 0, 0, 0, 2,
+			0, 0, 0, 20,
+			'2', '0', '0', '8', '-', '0', '5', '-', '2', '0',
+			'T', '2', '2', ':', '1', '5', ':', '2', '5', 'Z',
+			0xFF, 0xFF, 0xFF, 0xFF,
+			0, 0,
+		}, body)
+
+		typ, _ = readTestMessage(t, br)
+		require.Equal(t, byte('D'), typ)
+		typ, _ = readTestMessage(t, br)
+		require.Equal(t, byte('E'), typ)
+		typ, _ = readTestMessage(t, br)
+		require.Equal(t, byte('S'), typ)
+
+		writeTestMessage(t, serverSide, '1', nil)
+		writeTestMessage(t, serverSide, '2', nil)
+
+		desc := binary.BigEndian.AppendUint16(nil, 2)
+		desc = append(appendCString(desc, "a"), make([]byte, 18)...)
+		desc = append(appendCString(desc, "b"), make([]byte, 18)...)
+		writeTestMessage(t, serverSide, 'T', desc)
+
+		row := binary.BigEndian.AppendUint16(nil, 2)
+		row = append(binary.BigEndian.AppendUint32(row, 3), "abc"...)
+		row = binary.BigEndian.AppendUint32(row, 0xFFFFFFFF)
+		writeTestMessage(t, serverSide, 'D', row)
+
+		writeTestMessage(t, serverSide, 'C', []byte("SELECT 1\x00"))
+		writeTestMessage(t, serverSide, 'Z', []byte{'I'})
+
+		for i := 0; i < 5; i++ {
+			readTestMessage(t, br)
+		}
+
+		writeTestMessage(t, serverSide, 'E', []byte("SERROR\x00C42P01\x00Mrelation does not exist\x00\x00"))
+		writeTestMessage(t, serverSide, 'Z', []byte{'I'})
+
+		typ, _ = readTestMessage(t, br)
+		require.Equal(t, byte('X'), typ)
+	}()
+
+	c := &Conn{RW: clientSide}
+	c.Initialize()
+
+	err := c.Startup("myuser", "mypass", "mydb")
+	require.NoError(t, err)
+
+	res, err := c.Query("SELECT a, b FROM t WHERE c = $1 AND d = $2",
+		time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC), nil)
+	require.NoError(t, err)
+
+	v := "abc"
+	require.Equal(t, &Result{
+		Columns:      []string{"a", "b"},
+		Rows:         [][]*string{{&v, nil}},
+		RowsAffected: 1,
+	}, res)
+
+	_, err = c.Query("SELECT * FROM missing")
+	require.Equal(t, &Error{
+		Severity: "ERROR",
+		Code:     "42P01",
+		Message:  "relation does not exist",
+	}, err)
+
+	err = c.Terminate()
+	require.NoError(t, err)
+
+	<-done
+}
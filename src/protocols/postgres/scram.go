@@ -0,0 +1,116 @@
+package postgres
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const scramMechanism = "SCRAM-SHA-256"
+
+func hmacSHA256(key []byte, msg string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
+
+// scramClient performs a SCRAM-SHA-256 authentication (RFC 7677).
+// The user name is sent empty by PostgreSQL, since it is already part of the startup message.
+type scramClient struct {
+	user        string
+	pass        string
+	clientNonce string
+
+	authMessage string
+	saltedPass  []byte
+}
+
+func newSCRAMClient(user string, pass string) (*scramClient, error) {
+	nonce := make([]byte, 18)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return &scramClient{
+		user:        user,
+		pass:        pass,
+		clientNonce: base64.StdEncoding.EncodeToString(nonce),
+	}, nil
+}
+
+func (c *scramClient) clientFirstBare() string {
+	return "n=" + c.user + ",r=" + c.clientNonce
+}
+
+func (c *scramClient) clientFirst() string {
+	return "n,," + c.clientFirstBare()
+}
+
+func (c *scramClient) clientFinal(serverFirst string) (string, error) {
+	var nonce string
+	var salt []byte
+	var iterations int
+
+	for _, attr := range strings.Split(serverFirst, ",") {
+		if len(attr) < 2 || attr[1] != '=' {
+			return "", fmt.Errorf("invalid SCRAM message")
+		}
+
+		var err error
+
+		switch attr[0] {
+		case 'r':
+			nonce = attr[2:]
+		case 's':
+			salt, err = base64.StdEncoding.DecodeString(attr[2:])
+		case 'i':
+			iterations, err = strconv.Atoi(attr[2:])
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("invalid SCRAM message: %w", err)
+		}
+	}
+
+	if !strings.HasPrefix(nonce, c.clientNonce) || salt == nil || iterations <= 0 {
+		return "", fmt.Errorf("invalid SCRAM message")
+	}
+
+	c.saltedPass = pbkdf2.Key([]byte(c.pass), salt, iterations, sha256.Size, sha256.New)
+
+	withoutProof := "c=biws,r=" + nonce
+	c.authMessage = c.clientFirstBare() + "," + serverFirst + "," + withoutProof
+
+	clientKey := hmacSHA256(c.saltedPass, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	clientSignature := hmacSHA256(storedKey[:], c.authMessage)
+
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (c *scramClient) verifyServerFinal(serverFinal string) error {
+	if strings.HasPrefix(serverFinal, "e=") {
+		return fmt.Errorf("authentication failed: %s", serverFinal[2:])
+	}
+
+	serverKey := hmacSHA256(c.saltedPass, "Server Key")
+	serverSignature := hmacSHA256(serverKey, c.authMessage)
+
+	if serverFinal != "v="+base64.StdEncoding.EncodeToString(serverSignature) {
+		return fmt.Errorf("invalid server signature")
+	}
+
+	return nil
+}
@@ -2,7 +2,6 @@
 package rtsp
 
 import (
-	"fmt"
 	"time"
 
 	"github.com/bluenviron/gortsplib/v4"
@@ -83,8 +82,6 @@ func ToStream(
 					return
 				}
 
-				fmt.Println("TOStream")
-
 				stream.WriteRTPPacket(cmedi, cforma, pkt, ntp, pts)
 			})
 		}
@@ -4,12 +4,10 @@ import (
 	"time"
 
 	"github.com/bluenviron/gortsplib/v4/pkg/rtcpreceiver"
-	"github.com/bluenviron/gortsplib/v4/pkg/rtpreorderer"
 	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
 
-	"github.com/flynnletford/mediamtx/src/counterdumper"
 	"github.com/flynnletford/mediamtx/src/logger"
 )
 
@@ -237,7 +235,8 @@ var incomingAudioCodecs = []webrtc.RTPCodecParameters{
 
 // IncomingTrack is an incoming track.
 type IncomingTrack struct {
-	OnPacketRTP func(*rtp.Packet, time.Time)
+	OnPacketRTP    func(*rtp.Packet, time.Time)
+	OnSenderReport func(*rtcp.SenderReport)
 
 	useAbsoluteTimestamp bool
 	track                *webrtc.TrackRemote
@@ -245,39 +244,15 @@ type IncomingTrack struct {
 	writeRTCP            func([]rtcp.Packet) error
 	log                  logger.Writer
 
-	packetsLost  *counterdumper.CounterDumper
 	rtcpReceiver *rtcpreceiver.RTCPReceiver
 }
 
 func (t *IncomingTrack) initialize() {
 	t.OnPacketRTP = func(*rtp.Packet, time.Time) {}
-}
-
-// ClockRate returns the clock rate. Needed by rtptime.GlobalDecoder
-func (t *IncomingTrack) ClockRate() int {
-	return int(t.track.Codec().ClockRate)
-}
-
-// PTSEqualsDTS returns whether PTS equals DTS. Needed by rtptime.GlobalDecoder
-func (*IncomingTrack) PTSEqualsDTS(*rtp.Packet) bool {
-	return true
+	t.OnSenderReport = func(*rtcp.SenderReport) {}
 }
 
 func (t *IncomingTrack) start() {
-	t.packetsLost = &counterdumper.CounterDumper{
-		OnReport: func(val uint64) {
-			t.log.Log(logger.Warn, "%d RTP %s lost",
-				val,
-				func() string {
-					if val == 1 {
-						return "packet"
-					}
-					return "packets"
-				}())
-		},
-	}
-	t.packetsLost.Start()
-
 	t.rtcpReceiver = &rtcpreceiver.RTCPReceiver{
 		ClockRate: int(t.track.SSRC()),
 		Period:    1 * time.Second,
@@ -307,6 +282,7 @@ func (t *IncomingTrack) start() {
 			for _, pkt := range pkts {
 				if sr, ok := pkt.(*rtcp.SenderReport); ok {
 					t.rtcpReceiver.ProcessSenderReport(sr, time.Now())
+					t.OnSenderReport(sr)
 				}
 			}
 		}
@@ -331,23 +307,15 @@ func (t *IncomingTrack) start() {
 		}()
 	}
 
-	// read incoming RTP packets
+	// read incoming RTP packets.
+	// packets are reordered by the stream, that also counts lost ones.
 	go func() {
-		reorderer := &rtpreorderer.Reorderer{}
-		reorderer.Initialize()
-
 		for {
 			pkt, _, err := t.track.ReadRTP()
 			if err != nil {
 				return
 			}
 
-			packets, lost := reorderer.Process(pkt)
-			if lost != 0 {
-				t.packetsLost.Add(uint64(lost))
-				// do not return
-			}
-
 			err = t.rtcpReceiver.ProcessPacket(pkt, time.Now(), true)
 			if err != nil {
 				t.log.Log(logger.Warn, err.Error())
@@ -366,22 +334,17 @@ func (t *IncomingTrack) start() {
 				ntp = time.Now()
 			}
 
-			for _, pkt := range packets {
-				// sometimes Chrome sends empty RTP packets. ignore them.
-				if len(pkt.Payload) == 0 {
-					continue
-				}
-
-				t.OnPacketRTP(pkt, ntp)
+			// sometimes Chrome sends empty RTP packets. ignore them.
+			if len(pkt.Payload) == 0 {
+				continue
 			}
+
+			t.OnPacketRTP(pkt, ntp)
 		}
 	}()
 }
 
 func (t *IncomingTrack) close() {
-	if t.packetsLost != nil {
-		t.packetsLost.Stop()
-	}
 	if t.rtcpReceiver != nil {
 		t.rtcpReceiver.Close()
 	}
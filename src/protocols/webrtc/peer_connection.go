@@ -11,6 +11,7 @@ import (
 
 	"github.com/pion/ice/v4"
 	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
 	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v4"
 
@@ -533,6 +534,11 @@ func (co *PeerConnection) IncomingTracks() []*IncomingTrack {
 	return co.incomingTracks
 }
 
+// WriteRTCP writes RTCP packets.
+func (co *PeerConnection) WriteRTCP(pkts []rtcp.Packet) error {
+	return co.wr.WriteRTCP(pkts)
+}
+
 // StartReading starts reading all incoming tracks.
 func (co *PeerConnection) StartReading() {
 	for _, track := range co.incomingTracks {
@@ -8,8 +8,8 @@ import (
 
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
 	"github.com/bluenviron/gortsplib/v4/pkg/format"
-	"github.com/bluenviron/gortsplib/v4/pkg/rtptime"
 	"github.com/flynnletford/mediamtx/src/stream"
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
 )
@@ -24,8 +24,6 @@ func ToStream(
 	stream **stream.Stream,
 ) ([]*description.Media, error) {
 	var medias []*description.Media //nolint:prealloc
-	timeDecoder := &rtptime.GlobalDecoder2{}
-	timeDecoder.Initialize()
 
 	for _, track := range pc.incomingTracks {
 		var typ description.MediaType
@@ -142,13 +140,17 @@ func ToStream(
 			Formats: []format.Format{forma},
 		}
 
-		track.OnPacketRTP = func(pkt *rtp.Packet, ntp time.Time) {
-			pts, ok := timeDecoder.Decode(track, pkt)
-			if !ok {
-				return
-			}
+		// timestamps are computed by the stream.
+		// sender reports are used only when absolute timestamps are enabled,
+		// otherwise NTP timestamps are the time of arrival of packets.
+		track.OnPacketRTP = func(pkt *rtp.Packet, _ time.Time) {
+			(*stream).WriteRTPPacketRaw(medi, forma, pkt)
+		}
 
-			(*stream).WriteRTPPacket(medi, forma, pkt, ntp, pts)
+		if track.useAbsoluteTimestamp {
+			track.OnSenderReport = func(sr *rtcp.SenderReport) {
+				(*stream).WriteRTCPSenderReport(medi, sr)
+			}
 		}
 
 		medias = append(medias, medi)
@@ -10,15 +10,23 @@ import (
 	"time"
 
 	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/events"
 	"github.com/flynnletford/mediamtx/src/logger"
 	"github.com/flynnletford/mediamtx/src/recordstore"
 )
 
 var timeNow = time.Now
 
+// quotas of tenants are checked with this period.
+const quotaInterval = 60 * time.Second
+
 // Cleaner removes expired recording segments from disk.
+// It also removes local copies of segments that have been uploaded,
+// once uploadLocalRetention has passed.
+// Removals are published to EventBus.
 type Cleaner struct {
 	PathConfs map[string]*conf.Path
+	EventBus  *events.Bus
 	Parent    logger.Writer
 
 	ctx       context.Context
@@ -83,6 +91,15 @@ func (c *Cleaner) cleanInterval() time.Duration {
 			interval > (time.Duration(e.RecordDeleteAfter)/2) {
 			interval = time.Duration(e.RecordDeleteAfter) / 2
 		}
+
+		if e.UploadLocalRetention != 0 &&
+			interval > (time.Duration(e.UploadLocalRetention)/2) {
+			interval = time.Duration(e.UploadLocalRetention) / 2
+		}
+
+		if hasQuota(e.Tenant) && interval > quotaInterval {
+			interval = quotaInterval
+		}
 	}
 
 	return interval
@@ -96,6 +113,8 @@ func (c *Cleaner) doRun() {
 	for _, pathName := range pathNames {
 		c.processPath(now, pathName) //nolint:errcheck
 	}
+
+	c.enforceQuotas(pathNames)
 }
 
 func (c *Cleaner) processPath(now time.Time, pathName string) error {
@@ -104,6 +123,10 @@ func (c *Cleaner) processPath(now time.Time, pathName string) error {
 		return err
 	}
 
+	if pathConf.UploadLocalRetention != 0 {
+		c.removeLocalCopies(now, pathName, pathConf)
+	}
+
 	if pathConf.RecordDeleteAfter == 0 {
 		return nil
 	}
@@ -127,12 +150,87 @@ func (c *Cleaner) deleteExpiredSegments(now time.Time, pathName string, pathConf
 
 	for _, seg := range segments {
 		c.Log(logger.Debug, "removing %s", seg.Fpath)
-		os.Remove(seg.Fpath)
+		c.removeSegment(pathName, seg.Fpath)
 	}
 
 	return nil
 }
 
+// removeLocalCopies removes local copies of uploaded segments that have not been
+// modified for uploadLocalRetention. Upload statuses are kept, in order to list segments
+// and to fetch them from the storage when they are requested.
+func (c *Cleaner) removeLocalCopies(now time.Time, pathName string, pathConf *conf.Path) {
+	end := now.Add(-time.Duration(pathConf.UploadLocalRetention))
+	segments, err := recordstore.FindSegments(pathConf, pathName, nil, &end)
+	if err != nil {
+		return
+	}
+
+	for _, seg := range segments {
+		if seg.Remote {
+			continue
+		}
+
+		// segments that have been fetched from the storage are kept for uploadLocalRetention too.
+		fi, err := os.Stat(seg.Fpath)
+		if err != nil || fi.ModTime().After(end) {
+			continue
+		}
+
+		st, err := recordstore.ReadUploadStatus(seg.Fpath)
+		if err != nil || st.State != recordstore.UploadStateUploaded {
+			continue
+		}
+
+		// the duration is needed to list segments without fetching them.
+		if st.Duration == 0 && pathConf.RecordFormat == conf.RecordFormatFMP4 {
+			err = c.saveDuration(seg.Fpath, st)
+			if err != nil {
+				c.Log(logger.Warn, "unable to remove local copy of %s: %v", seg.Fpath, err)
+				continue
+			}
+		}
+
+		c.Log(logger.Debug, "removing local copy of %s", seg.Fpath)
+		err = os.Remove(seg.Fpath)
+		if err != nil {
+			continue
+		}
+
+		c.EventBus.Publish(events.TypeSegmentDelete, pathName, map[string]interface{}{
+			"segmentPath": seg.Fpath,
+			"remote":      true,
+		})
+	}
+}
+
+func (c *Cleaner) removeSegment(pathName string, segmentPath string) {
+	err := recordstore.RemoveSegment(segmentPath)
+	if err != nil {
+		return
+	}
+
+	c.EventBus.Publish(events.TypeSegmentDelete, pathName, map[string]interface{}{
+		"segmentPath": segmentPath,
+	})
+}
+
+func (c *Cleaner) saveDuration(segmentPath string, st *recordstore.UploadStatus) error {
+	info, err := recordstore.InspectSegment(segmentPath)
+	if err != nil {
+		return err
+	}
+
+	duration := info.Duration
+	if duration == 0 {
+		duration = info.PartsDuration
+	}
+
+	st.Duration = duration.Seconds()
+
+	return recordstore.WriteUploadStatus(segmentPath, st)
+}
+
 func (c *Cleaner) deleteEmptyDirs(pathConf *conf.Path) {
 	recordPath := strings.ReplaceAll(pathConf.RecordPath, "%path", pathConf.Name)
 	commonPath := recordstore.CommonPath(recordPath)
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/recordstore"
 	"github.com/flynnletford/mediamtx/src/test"
 	"github.com/stretchr/testify/require"
 )
@@ -108,3 +109,130 @@ func TestCleanerMultipleEntriesSamePath(t *testing.T) {
 	_, err = os.Stat(filepath.Join(dir, "path2", "2009-05-19_22-15-25-000427.mp4"))
 	require.NoError(t, err)
 }
+
+func TestCleanerLocalRetention(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(2009, 5, 20, 22, 15, 25, 427000, time.Local)
+	}
+
+	dir, err := os.MkdirTemp("", "mediamtx-cleaner")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "mypath"), 0o755)
+	require.NoError(t, err)
+
+	for _, name := range []string{
+		"2009-05-20_20-15-25-000427.mp4", // old, uploaded
+		"2009-05-20_21-15-25-000427.mp4", // old, not uploaded
+		"2009-05-20_22-15-20-000427.mp4", // recent, uploaded
+	} {
+		fpath := filepath.Join(dir, "mypath", name)
+
+		err = os.WriteFile(fpath, []byte{1}, 0o644)
+		require.NoError(t, err)
+
+		var pa recordstore.Path
+		ok := pa.Decode(filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f.mp4"), fpath)
+		require.True(t, ok)
+
+		err = os.Chtimes(fpath, pa.Start.Add(time.Minute), pa.Start.Add(time.Minute))
+		require.NoError(t, err)
+
+		if name != "2009-05-20_21-15-25-000427.mp4" {
+			err = recordstore.WriteUploadStatus(fpath, &recordstore.UploadStatus{
+				State:    recordstore.UploadStateUploaded,
+				Duration: 60,
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	c := &Cleaner{
+		PathConfs: map[string]*conf.Path{
+			"mypath": {
+				Name:                 "mypath",
+				RecordPath:           filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f"),
+				RecordFormat:         conf.RecordFormatFMP4,
+				UploadLocalRetention: conf.Duration(30 * time.Minute),
+			},
+		},
+		Parent: test.NilLogger,
+	}
+	c.Initialize()
+	defer c.Close()
+
+	time.Sleep(500 * time.Millisecond)
+
+	_, err = os.Stat(filepath.Join(dir, "mypath", "2009-05-20_20-15-25-000427.mp4"))
+	require.True(t, os.IsNotExist(err))
+
+	_, err = os.Stat(filepath.Join(dir, "mypath", "2009-05-20_21-15-25-000427.mp4"))
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, "mypath", "2009-05-20_22-15-20-000427.mp4"))
+	require.NoError(t, err)
+
+	segments, err := recordstore.FindSegments(c.PathConfs["mypath"], "mypath", nil, nil)
+	require.NoError(t, err)
+	require.Len(t, segments, 3)
+	require.True(t, segments[0].Remote)
+}
+
+func TestCleanerTenantQuota(t *testing.T) {
+	timeNow = func() time.Time {
+		return time.Date(2009, 5, 20, 22, 15, 25, 427000, time.Local)
+	}
+
+	dir, err := os.MkdirTemp("", "mediamtx-cleaner")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, fpath := range []string{
+		"cam1/2009-05-19_10-00-00-000000.mp4",
+		"cam1/2009-05-19_11-00-00-000000.mp4",
+		"cam2/2009-05-19_10-30-00-000000.mp4",
+		"cam2/2009-05-19_11-30-00-000000.mp4",
+	} {
+		err = os.MkdirAll(filepath.Join(dir, "acme", filepath.Dir(fpath)), 0o755)
+		require.NoError(t, err)
+
+		err = os.WriteFile(filepath.Join(dir, "acme", fpath), make([]byte, 1000), 0o644)
+		require.NoError(t, err)
+	}
+
+	tenant := &conf.Tenant{
+		Name:         "acme",
+		MaxDiskBytes: 3000,
+	}
+
+	c := &Cleaner{
+		PathConfs: map[string]*conf.Path{
+			"acme/*": {
+				Name:         "acme/*",
+				Regexp:       regexp.MustCompile("^acme/([^/]+)$"),
+				Tenant:       tenant,
+				RecordPath:   filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f"),
+				RecordFormat: conf.RecordFormatFMP4,
+			},
+		},
+		Parent: test.NilLogger,
+	}
+	c.Initialize()
+	defer c.Close()
+
+	time.Sleep(500 * time.Millisecond)
+
+	// the oldest segment is removed
+	_, err = os.Stat(filepath.Join(dir, "acme", "cam1", "2009-05-19_10-00-00-000000.mp4"))
+	require.Error(t, err)
+
+	for _, fpath := range []string{
+		"cam1/2009-05-19_11-00-00-000000.mp4",
+		"cam2/2009-05-19_10-30-00-000000.mp4",
+		"cam2/2009-05-19_11-30-00-000000.mp4",
+	} {
+		_, err = os.Stat(filepath.Join(dir, "acme", fpath))
+		require.NoError(t, err)
+	}
+}
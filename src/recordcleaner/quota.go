@@ -0,0 +1,124 @@
+package recordcleaner
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+)
+
+type quotaSegment struct {
+	pathName string
+	fpath    string
+	start    time.Time
+	size     uint64
+	duration time.Duration
+	last     bool
+}
+
+func hasQuota(t *conf.Tenant) bool {
+	return t != nil && (t.MaxDiskBytes != 0 || t.MaxRecordingDuration != 0)
+}
+
+func gatherQuotaSegments(pathConf *conf.Path, pathName string) []*quotaSegment {
+	segments, err := recordstore.FindSegments(pathConf, pathName, nil, nil)
+	if err != nil {
+		return nil
+	}
+
+	out := make([]*quotaSegment, 0, len(segments))
+
+	for i, seg := range segments {
+		fi, err := os.Stat(seg.Fpath)
+		if err != nil {
+			continue
+		}
+
+		qs := &quotaSegment{
+			pathName: pathName,
+			fpath:    seg.Fpath,
+			start:    seg.Start,
+			size:     uint64(fi.Size()),
+			last:     i == (len(segments) - 1),
+		}
+
+		// the duration of a segment is estimated from the start of the next one,
+		// or from the last modification time for the last one.
+		if !qs.last {
+			qs.duration = segments[i+1].Start.Sub(seg.Start)
+		} else {
+			qs.duration = fi.ModTime().Sub(seg.Start)
+		}
+		if qs.duration < 0 {
+			qs.duration = 0
+		}
+
+		out = append(out, qs)
+	}
+
+	return out
+}
+
+// enforceQuotas deletes the oldest segments of tenants that exceed their quotas.
+func (c *Cleaner) enforceQuotas(pathNames []string) {
+	tenants := make(map[string]*conf.Tenant)
+	segments := make(map[string][]*quotaSegment)
+
+	for _, pathName := range pathNames {
+		pathConf, _, err := conf.FindPathConf(c.PathConfs, pathName)
+		if err != nil || !hasQuota(pathConf.Tenant) {
+			continue
+		}
+
+		tenants[pathConf.Tenant.Name] = pathConf.Tenant
+		segments[pathConf.Tenant.Name] = append(segments[pathConf.Tenant.Name],
+			gatherQuotaSegments(pathConf, pathName)...)
+	}
+
+	for name, tenant := range tenants {
+		c.enforceTenantQuota(tenant, segments[name])
+	}
+}
+
+func (c *Cleaner) enforceTenantQuota(tenant *conf.Tenant, segments []*quotaSegment) {
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].start.Before(segments[j].start)
+	})
+
+	var size uint64
+	var duration time.Duration
+
+	for _, seg := range segments {
+		size += seg.size
+		duration += seg.duration
+	}
+
+	exceeded := func() bool {
+		return (tenant.MaxDiskBytes != 0 && size > uint64(tenant.MaxDiskBytes)) ||
+			(tenant.MaxRecordingDuration != 0 && duration > time.Duration(tenant.MaxRecordingDuration))
+	}
+
+	for _, seg := range segments {
+		if !exceeded() {
+			return
+		}
+
+		// last segments may still be in use by recorders
+		if seg.last {
+			continue
+		}
+
+		c.Log(logger.Debug, "removing %s (quota of tenant '%s' exceeded)", seg.fpath, tenant.Name)
+		c.removeSegment(seg.pathName, seg.fpath)
+
+		size -= seg.size
+		duration -= seg.duration
+	}
+
+	if exceeded() {
+		c.Log(logger.Warn, "quota of tenant '%s' is exceeded by segments that are being recorded", tenant.Name)
+	}
+}
@@ -0,0 +1,54 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// EmergencyReason is the reason of an emergency.
+type EmergencyReason string
+
+// emergency reasons.
+const (
+	EmergencyReasonDiskFull   EmergencyReason = "diskFull"
+	EmergencyReasonSlowWrite  EmergencyReason = "slowWrite"
+	EmergencyReasonWriteError EmergencyReason = "writeError"
+)
+
+// OnEmergencyFunc is the prototype of the function passed as OnEmergency.
+type OnEmergencyFunc = func(reason EmergencyReason, err error)
+
+// OnRecoveryFunc is the prototype of the function passed as OnRecovery.
+type OnRecoveryFunc = func()
+
+type slowWriteError struct {
+	latency time.Duration
+}
+
+// Error implements the error interface.
+func (e *slowWriteError) Error() string {
+	return fmt.Sprintf("write took %v", e.latency)
+}
+
+// emergencyReason returns the emergency reason of an error,
+// or false if the error is not related to the storage.
+func emergencyReason(err error) (EmergencyReason, bool) {
+	if errors.Is(err, syscall.ENOSPC) {
+		return EmergencyReasonDiskFull, true
+	}
+
+	var swe *slowWriteError
+	if errors.As(err, &swe) {
+		return EmergencyReasonSlowWrite, true
+	}
+
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return EmergencyReasonWriteError, true
+	}
+
+	return "", false
+}
@@ -5,13 +5,12 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
 	rtspformat "github.com/bluenviron/gortsplib/v4/pkg/format"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/ac3"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/av1"
-	"github.com/bluenviron/mediacommon/v2/pkg/codecs/g711"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
-	"github.com/bluenviron/mediacommon/v2/pkg/codecs/jpeg"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg1audio"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4video"
@@ -37,61 +36,6 @@ func mpeg1audioChannelCount(cm mpeg1audio.ChannelMode) int {
 	}
 }
 
-func jpegExtractSize(image []byte) (int, int, error) {
-	l := len(image)
-	if l < 2 || image[0] != 0xFF || image[1] != jpeg.MarkerStartOfImage {
-		return 0, 0, fmt.Errorf("invalid header")
-	}
-
-	image = image[2:]
-
-	for {
-		if len(image) < 2 {
-			return 0, 0, fmt.Errorf("not enough bits")
-		}
-
-		h0, h1 := image[0], image[1]
-		image = image[2:]
-
-		if h0 != 0xFF {
-			return 0, 0, fmt.Errorf("invalid image")
-		}
-
-		switch h1 {
-		case 0xE0, 0xE1, 0xE2, // JFIF
-			jpeg.MarkerDefineHuffmanTable,
-			jpeg.MarkerComment,
-			jpeg.MarkerDefineQuantizationTable,
-			jpeg.MarkerDefineRestartInterval:
-			mlen := int(image[0])<<8 | int(image[1])
-			if len(image) < mlen {
-				return 0, 0, fmt.Errorf("not enough bits")
-			}
-			image = image[mlen:]
-
-		case jpeg.MarkerStartOfFrame1:
-			mlen := int(image[0])<<8 | int(image[1])
-			if len(image) < mlen {
-				return 0, 0, fmt.Errorf("not enough bits")
-			}
-
-			var sof jpeg.StartOfFrame1
-			err := sof.Unmarshal(image[2:mlen])
-			if err != nil {
-				return 0, 0, err
-			}
-
-			return sof.Width, sof.Height, nil
-
-		case jpeg.MarkerStartOfScan:
-			return 0, 0, fmt.Errorf("SOF not found")
-
-		default:
-			return 0, 0, fmt.Errorf("unknown marker: 0x%.2x", h1)
-		}
-	}
-}
-
 type formatFMP4 struct {
 	ri *recorderInstance
 
@@ -105,6 +49,7 @@ func (f *formatFMP4) initialize() bool {
 	nextID := 1
 	var setuppedFormats []rtspformat.Format
 	setuppedFormatsMap := make(map[rtspformat.Format]struct{})
+	paramsUpdaters := make(map[rtspformat.Format]func())
 
 	addTrack := func(format rtspformat.Format, codec fmp4.Codec) *formatFMP4Track {
 		initTrack := &fmp4.InitTrack{
@@ -139,6 +84,37 @@ func (f *formatFMP4) initialize() bool {
 		for _, forma := range media.Formats {
 			clockRate := forma.ClockRate()
 
+			if reg := formatprocessor.Lookup(forma); reg != nil {
+				if reg.FMP4Codec != nil {
+					track := addTrack(forma, reg.FMP4Codec(forma))
+
+					f.ri.rec.Stream.AddReader(
+						f.ri,
+						media,
+						forma,
+						func(u unit.Unit) error {
+							samples, err := reg.FMP4Samples(u)
+							if err != nil {
+								return err
+							}
+
+							for _, s := range samples {
+								err = track.write(&sample{
+									PartSample: s.PartSample,
+									dts:        s.DTS,
+									ntp:        u.GetNTP().Add(timestampToDuration(s.DTS-u.GetPTS(), clockRate)),
+								})
+								if err != nil {
+									return err
+								}
+							}
+
+							return nil
+						})
+				}
+				continue
+			}
+
 			switch forma := forma.(type) {
 			case *rtspformat.AV1:
 				codec := &fmp4.CodecAV1{
@@ -292,6 +268,15 @@ func (f *formatFMP4) initialize() bool {
 				}
 				track := addTrack(forma, codec)
 
+				paramsUpdaters[forma] = func() {
+					vps, sps, pps := forma.SafeParams()
+					if vps != nil && sps != nil && pps != nil &&
+						(!bytes.Equal(codec.VPS, vps) || !bytes.Equal(codec.SPS, sps) || !bytes.Equal(codec.PPS, pps)) {
+						codec.VPS, codec.SPS, codec.PPS = vps, sps, pps
+						updateCodecs()
+					}
+				}
+
 				var dtsExtractor *h265.DTSExtractor
 
 				f.ri.rec.Stream.AddReader(
@@ -304,6 +289,10 @@ func (f *formatFMP4) initialize() bool {
 							return nil
 						}
 
+						if tunit.SEI != nil && track.hdr.update(tunit.SEI) {
+							updateCodecs()
+						}
+
 						randomAccess := false
 
 						for _, nalu := range tunit.AU {
@@ -375,6 +364,15 @@ func (f *formatFMP4) initialize() bool {
 				}
 				track := addTrack(forma, codec)
 
+				paramsUpdaters[forma] = func() {
+					sps, pps := forma.SafeParams()
+					if sps != nil && pps != nil &&
+						(!bytes.Equal(codec.SPS, sps) || !bytes.Equal(codec.PPS, pps)) {
+						codec.SPS, codec.PPS = sps, pps
+						updateCodecs()
+					}
+				}
+
 				var dtsExtractor *h264.DTSExtractor
 
 				f.ri.rec.Stream.AddReader(
@@ -387,6 +385,10 @@ func (f *formatFMP4) initialize() bool {
 							return nil
 						}
 
+						if tunit.SEI != nil && track.hdr.update(tunit.SEI) {
+							updateCodecs()
+						}
+
 						randomAccess := false
 
 						for _, nalu := range tunit.AU {
@@ -449,6 +451,14 @@ func (f *formatFMP4) initialize() bool {
 				}
 				track := addTrack(forma, codec)
 
+				paramsUpdaters[forma] = func() {
+					config := forma.SafeParams()
+					if config != nil && !bytes.Equal(codec.Config, config) {
+						codec.Config = config
+						updateCodecs()
+					}
+				}
+
 				firstReceived := false
 				var lastPTS int64
 
@@ -568,14 +578,14 @@ func (f *formatFMP4) initialize() bool {
 							return nil
 						}
 
-						if !parsed {
-							parsed = true
-							width, height, err := jpegExtractSize(tunit.Frame)
-							if err != nil {
-								return err
+						if tunit.Width == 0 || tunit.Height == 0 {
+							if !parsed {
+								return fmt.Errorf("unable to extract MJPEG frame size")
 							}
-							codec.Width = width
-							codec.Height = height
+						} else if !parsed || codec.Width != tunit.Width || codec.Height != tunit.Height {
+							parsed = true
+							codec.Width = tunit.Width
+							codec.Height = tunit.Height
 							updateCodecs()
 						}
 
@@ -626,6 +636,12 @@ func (f *formatFMP4) initialize() bool {
 
 			case *rtspformat.MPEG4Audio:
 				co := forma.GetConfig()
+
+				// LATM streams can transmit their configuration in-band.
+				if co == nil && forma.LATM {
+					co = &formatprocessor.MPEG4AudioLATMDefaultConfig
+				}
+
 				if co != nil {
 					codec := &fmp4.CodecMPEG4Audio{
 						Config: *co,
@@ -642,8 +658,14 @@ func (f *formatFMP4) initialize() bool {
 								return nil
 							}
 
+							if tunit.Config != nil && *tunit.Config != codec.Config {
+								codec.Config = *tunit.Config
+								updateCodecs()
+							}
+
 							for i, au := range tunit.AUs {
-								pts := tunit.PTS + int64(i)*mpeg4audio.SamplesPerAccessUnit
+								pts := tunit.PTS + int64(i)*mpeg4audio.SamplesPerAccessUnit*
+									int64(clockRate)/int64(codec.Config.SampleRate)
 
 								err := track.write(&sample{
 									PartSample: &fmp4.PartSample{
@@ -783,7 +805,55 @@ func (f *formatFMP4) initialize() bool {
 					})
 
 			case *rtspformat.G722:
-				// TODO
+				// G722 has no MP4 mapping, therefore it is stored as LPCM.
+				// The sample rate is 16khz, while the clock rate is 8khz.
+				codec := &fmp4.CodecLPCM{
+					LittleEndian: false,
+					BitDepth:     16,
+					SampleRate:   16000,
+					ChannelCount: 1,
+				}
+				track := addTrack(forma, codec)
+
+				// frames are decoded by the stream when DecodeToLPCM is enabled,
+				// otherwise they are decoded here.
+				var lpcmDecoder *formatprocessor.G722Decoder
+
+				f.ri.rec.Stream.AddReader(
+					f.ri,
+					media,
+					forma,
+					func(u unit.Unit) error {
+						tunit := u.(*unit.G722)
+
+						if tunit.Frame == nil {
+							return nil
+						}
+
+						if tunit.LPCM == nil {
+							if lpcmDecoder == nil {
+								lpcmDecoder = &formatprocessor.G722Decoder{}
+								lpcmDecoder.Initialize()
+							}
+
+							return track.write(&sample{
+								PartSample: &fmp4.PartSample{
+									Payload: lpcmDecoder.Decode(tunit.Frame),
+								},
+								dts: tunit.PTS,
+								ntp: tunit.NTP,
+							})
+						}
+
+						// LPCM is stored in the payload of the unit, that is retained until the part is written.
+						return track.write((&sample{
+							PartSample: &fmp4.PartSample{
+								Payload: tunit.LPCM,
+							},
+							dts: tunit.PTS,
+							ntp: tunit.NTP,
+						}).retainPayload(tunit))
+					})
 
 			case *rtspformat.G711:
 				codec := &fmp4.CodecLPCM{
@@ -805,26 +875,115 @@ func (f *formatFMP4) initialize() bool {
 							return nil
 						}
 
-						var lpcm []byte
-						if forma.MULaw {
-							var mu g711.Mulaw
-							mu.Unmarshal(tunit.Samples)
-							lpcm = mu
-						} else {
-							var al g711.Alaw
-							al.Unmarshal(tunit.Samples)
-							lpcm = al
+						if tunit.LPCM == nil {
+							return track.write(&sample{
+								PartSample: &fmp4.PartSample{
+									Payload: formatprocessor.DecodeG711ToLPCM(forma, tunit.Samples),
+								},
+								dts: tunit.PTS,
+								ntp: tunit.NTP,
+							})
 						}
 
-						return track.write(&sample{
+						// LPCM is stored in the payload of the unit, that is retained until the part is written.
+						return track.write((&sample{
 							PartSample: &fmp4.PartSample{
-								Payload: lpcm,
+								Payload: tunit.LPCM,
 							},
 							dts: tunit.PTS,
 							ntp: tunit.NTP,
-						})
+						}).retainPayload(tunit))
 					})
 
+			case *rtspformat.Generic:
+				if formatprocessor.IsKLV(forma) {
+					track := addTrack(forma, klvPlaceholderCodec)
+					track.klv = true
+
+					f.ri.rec.Stream.AddReader(
+						f.ri,
+						media,
+						forma,
+						func(u unit.Unit) error {
+							tunit := u.(*unit.KLV)
+							if tunit.Unit == nil {
+								return nil
+							}
+
+							return track.write(&sample{
+								PartSample: &fmp4.PartSample{
+									Payload: tunit.Unit,
+								},
+								dts: tunit.PTS,
+								ntp: tunit.NTP,
+							})
+						})
+				} else if formatprocessor.IsH266(forma) {
+					codec := newVVCPlaceholderCodec()
+					track := addTrack(forma, codec)
+					track.vvc = true
+
+					var vps, sps, pps []byte
+					started := false
+
+					f.ri.rec.Stream.AddReader(
+						f.ri,
+						media,
+						forma,
+						func(u unit.Unit) error {
+							tunit := u.(*unit.H266)
+							if tunit.AU == nil {
+								return nil
+							}
+
+							vps2, sps2, pps2 := formatprocessor.H266Params(tunit.AU)
+							if sps2 != nil && pps2 != nil &&
+								(!bytes.Equal(vps, vps2) || !bytes.Equal(sps, sps2) || !bytes.Equal(pps, pps2)) {
+								width, height, err := formatprocessor.H266SPSResolution(sps2)
+								if err != nil {
+									return err
+								}
+
+								entry, err := formatprocessor.H266MP4SampleEntry(vps2, sps2, pps2)
+								if err != nil {
+									return err
+								}
+
+								vps, sps, pps = vps2, sps2, pps2
+								codec.Width, codec.Height = width, height
+								track.vvcSampleEntry = entry
+								updateCodecs()
+							}
+
+							randomAccess := formatprocessor.H266IsRandomAccess(tunit.AU)
+
+							if !started {
+								// wait for parameters and for a random access point
+								if track.vvcSampleEntry == nil || !randomAccess {
+									return nil
+								}
+								started = true
+							}
+
+							avcc, err := h264.AVCC(tunit.AU).Marshal()
+							if err != nil {
+								return err
+							}
+
+							// the DTS can't be extracted without parsing slice headers,
+							// therefore it is assumed to be equal to the PTS and
+							// streams with B-frames are not supported.
+							return track.write(&sample{
+								PartSample: &fmp4.PartSample{
+									Payload:         avcc,
+									IsNonSyncSample: !randomAccess,
+								},
+								dts: tunit.PTS,
+								ntp: tunit.NTP,
+							})
+						})
+				}
+
 			case *rtspformat.LPCM:
 				codec := &fmp4.CodecLPCM{
 					LittleEndian: false,
@@ -861,11 +1020,38 @@ func (f *formatFMP4) initialize() bool {
 		return false
 	}
 
+	if f.ri.rec.Encryption != nil {
+		for i, track := range f.tracks {
+			if !track.klv && !track.vvc {
+				var err error
+				track.cenc, err = newFormatFMP4CENC(f.ri.rec.Encryption, track.initTrack.Codec)
+				if err != nil {
+					f.ri.Log(logger.Error, err.Error())
+					return false
+				}
+			}
+
+			if track.cenc == nil {
+				f.ri.Log(logger.Warn, "[track %d] %s can't be encrypted, it will be recorded in clear",
+					i+1, setuppedFormats[i].Codec())
+			}
+		}
+	}
+
+	// when parameters change, rotate the segment in order to write a new init
+	// before the unit that contains them.
+	f.ri.rec.Stream.SetReaderOnParametersChange(f.ri, func(_ *description.Media, forma rtspformat.Format) error {
+		if update, ok := paramsUpdaters[forma]; ok {
+			update()
+		}
+		return nil
+	})
+
 	n := 1
 	for _, medi := range f.ri.rec.Stream.Desc.Medias {
 		for _, forma := range medi.Formats {
 			if _, ok := setuppedFormatsMap[forma]; !ok {
-				f.ri.Log(logger.Warn, "skipping track %d (%s)", n, forma.Codec())
+				f.ri.Log(logger.Warn, "[track %d] skipping (%s)", n, forma.Codec())
 			}
 			n++
 		}
@@ -877,6 +1063,17 @@ func (f *formatFMP4) initialize() bool {
 	return true
 }
 
+// indexTrack returns the track that is used as reference by segment indexes,
+// that is the first video track or, if there's none, the first track.
+func (f *formatFMP4) indexTrack() *formatFMP4Track {
+	for _, track := range f.tracks {
+		if track.initTrack.Codec.IsVideo() {
+			return track
+		}
+	}
+	return f.tracks[0]
+}
+
 func (f *formatFMP4) close() {
 	if f.currentSegment != nil {
 		for _, track := range f.tracks {
@@ -888,4 +1085,10 @@ func (f *formatFMP4) close() {
 
 		f.currentSegment.close() //nolint:errcheck
 	}
+
+	for _, track := range f.tracks {
+		if track.nextSample != nil {
+			track.nextSample.releasePayload()
+		}
+	}
 }
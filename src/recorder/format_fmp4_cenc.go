@@ -0,0 +1,459 @@
+package recorder
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+)
+
+// Common Encryption of fMP4 segments.
+// Specification: ISO 23001-7
+
+// Encryption contains parameters of the Common Encryption of fMP4 segments.
+type Encryption struct {
+	Scheme conf.RecordEncryption
+	KeyID  [16]byte
+	Key    [16]byte
+
+	// pssh boxes of DRM systems, in binary format.
+	// They are written after the W3C Common PSSH box.
+	PSSH [][]byte
+}
+
+// system ID of the W3C Common PSSH box.
+var cencCommonSystemID = []byte{
+	0x10, 0x77, 0xef, 0xec, 0xc0, 0xb2, 0x4d, 0x02,
+	0xac, 0xe3, 0x3c, 0x1e, 0x52, 0xe2, 0xfb, 0x4b,
+}
+
+// size of per-sample IVs of the cenc scheme.
+// The cbcs scheme uses a constant IV.
+const cencPerSampleIVSize = 8
+
+func marshalBox(typ string, payloads ...[]byte) []byte {
+	size := 8
+	for _, p := range payloads {
+		size += len(p)
+	}
+
+	buf := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(size))
+	copy(buf[4:8], typ)
+
+	for _, p := range payloads {
+		buf = append(buf, p...)
+	}
+
+	return buf
+}
+
+type cencSubsample struct {
+	clear     uint16
+	protected uint32
+}
+
+// appendSubsample appends clear and protected bytes to a subsample list,
+// merging them with the previous subsample when it doesn't contain protected bytes.
+func appendSubsample(subsamples []cencSubsample, clear int, protected int) []cencSubsample {
+	if n := len(subsamples); n > 0 && subsamples[n-1].protected == 0 &&
+		(int(subsamples[n-1].clear)+clear) <= 0xFFFF {
+		subsamples[n-1].clear += uint16(clear)
+		subsamples[n-1].protected = uint32(protected)
+		return subsamples
+	}
+
+	for clear > 0xFFFF {
+		subsamples = append(subsamples, cencSubsample{clear: 0xFFFF})
+		clear -= 0xFFFF
+	}
+
+	return append(subsamples, cencSubsample{clear: uint16(clear), protected: uint32(protected)})
+}
+
+// cencSampleInfo is the auxiliary information of an encrypted sample.
+type cencSampleInfo struct {
+	iv         []byte
+	subsamples []cencSubsample
+}
+
+func (i *cencSampleInfo) size() int {
+	n := len(i.iv)
+	if i.subsamples != nil {
+		n += 2 + len(i.subsamples)*6
+	}
+	return n
+}
+
+// cbcsEncrypt encrypts a protected range with AES-CBC, following a pattern of
+// encrypted and skipped blocks. A pattern of 0:0 means that all blocks are encrypted.
+// The trailing partial block is left in clear.
+func cbcsEncrypt(block cipher.Block, iv []byte, buf []byte, cryptBlocks int, skipBlocks int) {
+	enc := cipher.NewCBCEncrypter(block, iv)
+	n := len(buf) / aes.BlockSize
+
+	if cryptBlocks == 0 {
+		enc.CryptBlocks(buf[:n*aes.BlockSize], buf[:n*aes.BlockSize])
+		return
+	}
+
+	for i := 0; i < n; i += cryptBlocks + skipBlocks {
+		chunk := buf[i*aes.BlockSize : min(i+cryptBlocks, n)*aes.BlockSize]
+		enc.CryptBlocks(chunk, chunk)
+	}
+}
+
+func h264IsVCL(nalu []byte) bool {
+	typ := nalu[0] & 0x1F
+	return typ >= 1 && typ <= 5
+}
+
+func h265IsVCL(nalu []byte) bool {
+	return ((nalu[0] >> 1) & 0b111111) < 32
+}
+
+// formatFMP4CENC encrypts the samples of a track.
+type formatFMP4CENC struct {
+	enc   *Encryption
+	codec fmp4.Codec
+
+	block cipher.Block
+	// constant IV in case of cbcs, first IV in case of cenc.
+	iv     [16]byte
+	nextIV uint64
+
+	// in case of video tracks, NAL units are encrypted with subsamples,
+	// leaving NAL unit headers and non-VCL NAL units in clear.
+	// Audio samples are entirely encrypted.
+	naluHeaderSize int
+	isVCL          func(nalu []byte) bool
+}
+
+// newFormatFMP4CENC allocates a formatFMP4CENC.
+// It returns nil if the codec can't be encrypted.
+func newFormatFMP4CENC(enc *Encryption, codec fmp4.Codec) (*formatFMP4CENC, error) {
+	c := &formatFMP4CENC{
+		enc:   enc,
+		codec: codec,
+	}
+
+	switch codec.(type) {
+	case *fmp4.CodecH264:
+		c.naluHeaderSize = 1
+		c.isVCL = h264IsVCL
+
+	case *fmp4.CodecH265:
+		c.naluHeaderSize = 2
+		c.isVCL = h265IsVCL
+
+	case *fmp4.CodecMPEG4Audio, *fmp4.CodecOpus, *fmp4.CodecAC3:
+
+	default:
+		return nil, nil
+	}
+
+	var err error
+	c.block, err = aes.NewCipher(enc.Key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = rand.Read(c.iv[:])
+	if err != nil {
+		return nil, err
+	}
+
+	c.nextIV = binary.BigEndian.Uint64(c.iv[:8])
+
+	return c, nil
+}
+
+// pattern returns the number of encrypted and skipped blocks of the cbcs scheme.
+func (c *formatFMP4CENC) pattern() (int, int) {
+	if c.isVCL != nil {
+		return 1, 9
+	}
+	return 0, 0
+}
+
+func (c *formatFMP4CENC) subsamples(payload []byte) ([]cencSubsample, error) {
+	subsamples := []cencSubsample{}
+
+	for pos := 0; pos < len(payload); {
+		if (len(payload) - pos) < 4 {
+			return nil, fmt.Errorf("invalid NAL unit size")
+		}
+
+		size := int(binary.BigEndian.Uint32(payload[pos:]))
+		if size == 0 || (len(payload)-pos-4) < size {
+			return nil, fmt.Errorf("invalid NAL unit size")
+		}
+
+		nalu := payload[pos+4 : pos+4+size]
+
+		if size > c.naluHeaderSize && c.isVCL(nalu) {
+			subsamples = appendSubsample(subsamples, 4+c.naluHeaderSize, size-c.naluHeaderSize)
+		} else {
+			subsamples = appendSubsample(subsamples, 4+size, 0)
+		}
+
+		pos += 4 + size
+	}
+
+	return subsamples, nil
+}
+
+// encrypt encrypts the payload of a sample, replacing it with an encrypted copy.
+func (c *formatFMP4CENC) encrypt(sample *fmp4.PartSample) (*cencSampleInfo, error) {
+	payload := make([]byte, len(sample.Payload))
+	copy(payload, sample.Payload)
+
+	info := &cencSampleInfo{}
+
+	var ranges [][]byte
+
+	if c.isVCL != nil {
+		var err error
+		info.subsamples, err = c.subsamples(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		pos := 0
+		for _, s := range info.subsamples {
+			pos += int(s.clear)
+			ranges = append(ranges, payload[pos:pos+int(s.protected)])
+			pos += int(s.protected)
+		}
+	} else {
+		ranges = [][]byte{payload}
+	}
+
+	switch c.enc.Scheme {
+	case conf.RecordEncryptionCBCS:
+		cryptBlocks, skipBlocks := c.pattern()
+
+		// the constant IV is applied at the start of every subsample
+		for _, r := range ranges {
+			cbcsEncrypt(c.block, c.iv[:], r, cryptBlocks, skipBlocks)
+		}
+
+	default:
+		info.iv = make([]byte, cencPerSampleIVSize)
+		binary.BigEndian.PutUint64(info.iv, c.nextIV)
+		c.nextIV++
+
+		// the counter continues across subsamples
+		var counter [16]byte
+		copy(counter[:], info.iv)
+		stream := cipher.NewCTR(c.block, counter[:])
+
+		for _, r := range ranges {
+			stream.XORKeyStream(r, r)
+		}
+	}
+
+	sample.Payload = payload
+
+	return info, nil
+}
+
+// marshalSinf returns a sinf box, that describes the protection of a sample entry
+// with the given original format.
+func (c *formatFMP4CENC) marshalSinf(originalFormat []byte) []byte {
+	frma := marshalBox("frma", originalFormat)
+
+	schm := make([]byte, 12)
+	switch c.enc.Scheme {
+	case conf.RecordEncryptionCBCS:
+		copy(schm[4:8], "cbcs")
+	default:
+		copy(schm[4:8], "cenc")
+	}
+	binary.BigEndian.PutUint32(schm[8:12], 0x00010000) // scheme_version
+
+	var tenc []byte
+
+	switch c.enc.Scheme {
+	case conf.RecordEncryptionCBCS:
+		cryptBlocks, skipBlocks := c.pattern()
+		tenc = make([]byte, 4+4+16+1+16)
+		tenc[0] = 1 // version
+		tenc[5] = byte(cryptBlocks<<4 | skipBlocks)
+		tenc[6] = 1 // default_isProtected
+		tenc[7] = 0 // default_Per_Sample_IV_Size
+		copy(tenc[8:24], c.enc.KeyID[:])
+		tenc[24] = 16 // default_constant_IV_size
+		copy(tenc[25:41], c.iv[:])
+
+	default:
+		tenc = make([]byte, 4+4+16)
+		tenc[6] = 1 // default_isProtected
+		tenc[7] = cencPerSampleIVSize
+		copy(tenc[8:24], c.enc.KeyID[:])
+	}
+
+	return marshalBox("sinf",
+		frma,
+		marshalBox("schm", schm),
+		marshalBox("schi", marshalBox("tenc", tenc)))
+}
+
+// marshalPSSH returns the W3C Common PSSH box, followed by pssh boxes of DRM systems.
+func marshalPSSH(enc *Encryption) []byte {
+	payload := make([]byte, 4+16+4+16+4)
+	payload[0] = 1 // version
+	copy(payload[4:20], cencCommonSystemID)
+	binary.BigEndian.PutUint32(payload[20:24], 1) // KID_count
+	copy(payload[24:40], enc.KeyID[:])
+
+	buf := marshalBox("pssh", payload)
+
+	for _, pssh := range enc.PSSH {
+		buf = append(buf, pssh...)
+	}
+
+	return buf
+}
+
+// protectSampleEntry replaces the sample entry of a track with a encv / enca sample entry,
+// containing a sinf box.
+func protectSampleEntry(init []byte, trackIndex int, c *formatFMP4CENC) ([]byte, error) {
+	entry, err := findSampleEntry(init, trackIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	originalFormat := make([]byte, 4)
+	copy(originalFormat, init[entry.start+4:entry.start+8])
+
+	if c.codec.IsVideo() {
+		copy(init[entry.start+4:entry.start+8], "encv")
+	} else {
+		copy(init[entry.start+4:entry.start+8], "enca")
+	}
+
+	return insertBoxes(init, entry.parents, entry.end, c.marshalSinf(originalFormat)), nil
+}
+
+// marshalSampleEncryption returns the senc, saiz and saio boxes of a track fragment.
+// sencOffset is the offset of the senc box from the start of the moof box.
+func marshalSampleEncryption(infos []*cencSampleInfo, sencOffset int) []byte {
+	var flags byte
+	if infos[0].subsamples != nil {
+		flags = 0x02 // UseSubSampleEncryption
+	}
+
+	senc := []byte{0, 0, 0, flags, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(senc[4:8], uint32(len(infos)))
+
+	defaultSize := infos[0].size()
+
+	for _, info := range infos {
+		senc = append(senc, info.iv...)
+
+		if info.subsamples != nil {
+			senc = binary.BigEndian.AppendUint16(senc, uint16(len(info.subsamples)))
+
+			for _, s := range info.subsamples {
+				senc = binary.BigEndian.AppendUint16(senc, s.clear)
+				senc = binary.BigEndian.AppendUint32(senc, s.protected)
+			}
+		}
+
+		if info.size() != defaultSize {
+			defaultSize = 0
+		}
+	}
+
+	saiz := []byte{0, 0, 0, 0, byte(defaultSize), 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(saiz[5:9], uint32(len(infos)))
+
+	if defaultSize == 0 {
+		for _, info := range infos {
+			saiz = append(saiz, byte(info.size()))
+		}
+	}
+
+	// auxiliary information starts after the header, version, flags and sample_count of senc
+	saio := []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(saio[8:12], uint32(sencOffset+8+4+4))
+
+	buf := marshalBox("senc", senc)
+	buf = append(buf, marshalBox("saiz", saiz)...)
+	buf = append(buf, marshalBox("saio", saio)...)
+
+	return buf
+}
+
+// insertSampleEncryption inserts senc, saiz and saio boxes into the track fragments of a part,
+// updating box sizes and data offsets.
+func insertSampleEncryption(part []byte, infos map[int][]*cencSampleInfo) ([]byte, error) {
+	moofStart, moofEnd, err := findBox(part, 0, len(part), "moof", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(part)+1024)
+	out = append(out, part[:moofStart+8]...)
+
+	var truns []int
+	inserted := 0
+
+	for pos := moofStart + 8; pos < moofEnd; {
+		if (moofEnd - pos) < 8 {
+			return nil, fmt.Errorf("invalid box")
+		}
+
+		var boxStart, boxEnd int
+		boxStart, boxEnd, err = findBox(part, pos, moofEnd, string(part[pos+4:pos+8]), 0)
+		if err != nil {
+			return nil, err
+		}
+
+		outStart := len(out)
+		out = append(out, part[boxStart:boxEnd]...)
+
+		if string(part[boxStart+4:boxStart+8]) == "traf" {
+			var trunStart int
+			trunStart, _, err = findBox(out, outStart+8, len(out), "trun", 0)
+			if err != nil {
+				return nil, err
+			}
+			truns = append(truns, trunStart)
+
+			var tfhdStart int
+			tfhdStart, _, err = findBox(out, outStart+8, len(out), "tfhd", 0)
+			if err != nil {
+				return nil, err
+			}
+			trackID := int(binary.BigEndian.Uint32(out[tfhdStart+12:]))
+
+			if trackInfos, ok := infos[trackID]; ok {
+				boxes := marshalSampleEncryption(trackInfos, len(out)-moofStart)
+				out = append(out, boxes...)
+				binary.BigEndian.PutUint32(out[outStart:], uint32(len(out)-outStart))
+				inserted += len(boxes)
+			}
+		}
+
+		pos = boxEnd
+	}
+
+	binary.BigEndian.PutUint32(out[moofStart:], uint32(len(out)-moofStart))
+
+	// samples have been moved forward
+	for _, trunStart := range truns {
+		dataOffset := binary.BigEndian.Uint32(out[trunStart+16:])
+		binary.BigEndian.PutUint32(out[trunStart+16:], dataOffset+uint32(inserted))
+	}
+
+	out = append(out, part[moofEnd:]...)
+
+	return out, nil
+}
@@ -0,0 +1,178 @@
+package recorder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+// size of the fields of a VisualSampleEntry that precede child boxes.
+// Specification: ISO 14496-12, section 12.1.3
+const visualSampleEntryFieldsSize = 78
+
+type formatFMP4HDR struct {
+	masteringDisplay  *unit.MasteringDisplay
+	contentLightLevel *unit.ContentLightLevel
+}
+
+// update updates HDR metadata with the content of a SEI.
+// It returns true if metadata has changed.
+func (h *formatFMP4HDR) update(sei *unit.SEI) bool {
+	changed := false
+
+	if sei.MasteringDisplay != nil && !reflect.DeepEqual(sei.MasteringDisplay, h.masteringDisplay) {
+		h.masteringDisplay = sei.MasteringDisplay
+		changed = true
+	}
+
+	if sei.ContentLightLevel != nil && !reflect.DeepEqual(sei.ContentLightLevel, h.contentLightLevel) {
+		h.contentLightLevel = sei.ContentLightLevel
+		changed = true
+	}
+
+	return changed
+}
+
+// marshal returns mdcv and clli boxes, that are appended to the sample entry.
+func (h *formatFMP4HDR) marshal() []byte {
+	var buf []byte
+
+	if md := h.masteringDisplay; md != nil {
+		b := make([]byte, 8+24)
+		binary.BigEndian.PutUint32(b[0:4], uint32(len(b)))
+		copy(b[4:8], "mdcv")
+
+		for i := 0; i < 3; i++ {
+			binary.BigEndian.PutUint16(b[8+i*4:], md.DisplayPrimariesX[i])
+			binary.BigEndian.PutUint16(b[8+i*4+2:], md.DisplayPrimariesY[i])
+		}
+
+		binary.BigEndian.PutUint16(b[20:], md.WhitePointX)
+		binary.BigEndian.PutUint16(b[22:], md.WhitePointY)
+		binary.BigEndian.PutUint32(b[24:], md.MaxLuminance)
+		binary.BigEndian.PutUint32(b[28:], md.MinLuminance)
+		buf = append(buf, b...)
+	}
+
+	if cll := h.contentLightLevel; cll != nil {
+		b := make([]byte, 8+4)
+		binary.BigEndian.PutUint32(b[0:4], uint32(len(b)))
+		copy(b[4:8], "clli")
+		binary.BigEndian.PutUint16(b[8:], cll.MaxContentLightLevel)
+		binary.BigEndian.PutUint16(b[10:], cll.MaxPicAverageLightLevel)
+		buf = append(buf, b...)
+	}
+
+	return buf
+}
+
+// findBox returns the position of the n-th child box with the given type,
+// searching between start and end.
+func findBox(buf []byte, start int, end int, typ string, n int) (int, int, error) {
+	pos := start
+
+	for pos < end {
+		if (end - pos) < 8 {
+			return 0, 0, fmt.Errorf("invalid box")
+		}
+
+		size := int(binary.BigEndian.Uint32(buf[pos:]))
+		if size < 8 || (end-pos) < size {
+			return 0, 0, fmt.Errorf("invalid box size")
+		}
+
+		if string(buf[pos+4:pos+8]) == typ {
+			if n == 0 {
+				return pos, pos + size, nil
+			}
+			n--
+		}
+
+		pos += size
+	}
+
+	return 0, 0, fmt.Errorf("box '%s' not found", typ)
+}
+
+// sampleEntry is the position of the sample entry of a track of an init segment.
+type sampleEntry struct {
+	// positions of moov, trak, mdia, minf, stbl, stsd and of the sample entry itself.
+	parents []int
+	start   int
+	end     int
+}
+
+// findSampleEntry finds the sample entry of a track of an init segment.
+func findSampleEntry(init []byte, trackIndex int) (*sampleEntry, error) {
+	var parents []int
+
+	start, end, err := findBox(init, 0, len(init), "moov", 0)
+	if err != nil {
+		return nil, err
+	}
+	parents = append(parents, start)
+
+	start, end, err = findBox(init, start+8, end, "trak", trackIndex)
+	if err != nil {
+		return nil, err
+	}
+	parents = append(parents, start)
+
+	for _, typ := range []string{"mdia", "minf", "stbl", "stsd"} {
+		start, end, err = findBox(init, start+8, end, typ, 0)
+		if err != nil {
+			return nil, err
+		}
+		parents = append(parents, start)
+	}
+
+	// skip version, flags and entry_count of stsd
+	entryStart := start + 8 + 4 + 4
+	if (end - entryStart) < 8 {
+		return nil, fmt.Errorf("invalid sample entry")
+	}
+
+	entryEnd := entryStart + int(binary.BigEndian.Uint32(init[entryStart:]))
+	if entryEnd > end {
+		return nil, fmt.Errorf("invalid sample entry size")
+	}
+	parents = append(parents, entryStart)
+
+	return &sampleEntry{
+		parents: parents,
+		start:   entryStart,
+		end:     entryEnd,
+	}, nil
+}
+
+// insertIntoSampleEntry inserts boxes at the end of the sample entry of a track of an init segment,
+// updating the size of all parent boxes.
+func insertIntoSampleEntry(init []byte, trackIndex int, boxes []byte) ([]byte, error) {
+	entry, err := findSampleEntry(init, trackIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	if (entry.end - entry.start) < (8 + visualSampleEntryFieldsSize) {
+		return nil, fmt.Errorf("invalid sample entry")
+	}
+
+	return insertBoxes(init, entry.parents, entry.end, boxes), nil
+}
+
+// insertBoxes inserts boxes at the given position, updating the size of parent boxes.
+func insertBoxes(buf []byte, parents []int, pos int, boxes []byte) []byte {
+	for _, parent := range parents {
+		size := binary.BigEndian.Uint32(buf[parent:])
+		binary.BigEndian.PutUint32(buf[parent:], size+uint32(len(boxes)))
+	}
+
+	out := make([]byte, 0, len(buf)+len(boxes))
+	out = append(out, buf[:pos]...)
+	out = append(out, boxes...)
+	out = append(out, buf[pos:]...)
+
+	return out
+}
@@ -0,0 +1,93 @@
+package recorder
+
+import (
+	"encoding/binary"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+)
+
+// KLV metadata tracks of fMP4 segments.
+// Specification: MISB ST 1910
+
+// URI of the URIMetaSampleEntry of KLV tracks.
+const klvURI = "urn:misb:KLV:bin:1910.1"
+
+// codec used to generate the init segment of KLV tracks,
+// whose trak box is then replaced by marshalKLVTrak().
+var klvPlaceholderCodec = &fmp4.CodecOpus{
+	ChannelCount: 1,
+}
+
+func marshalFullBox(typ string, version uint8, flags uint32, payloads ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return marshalBox(typ, append([][]byte{header}, payloads...)...)
+}
+
+// marshalKLVTrak returns the trak box of a KLV track.
+func marshalKLVTrak(id int, timeScale uint32) []byte {
+	tkhd := make([]byte, 80)
+	binary.BigEndian.PutUint32(tkhd[8:12], uint32(id))
+	// unity matrix
+	binary.BigEndian.PutUint32(tkhd[40:44], 0x00010000)
+	binary.BigEndian.PutUint32(tkhd[56:60], 0x00010000)
+	binary.BigEndian.PutUint32(tkhd[72:76], 0x40000000)
+
+	mdhd := make([]byte, 20)
+	binary.BigEndian.PutUint32(mdhd[8:12], timeScale)
+	binary.BigEndian.PutUint16(mdhd[16:18], 0x55C4) // und
+
+	hdlr := make([]byte, 20, 20+len("KLVHandler")+1)
+	copy(hdlr[4:8], "meta")
+	hdlr = append(hdlr, "KLVHandler\x00"...)
+
+	urim := make([]byte, 8)
+	binary.BigEndian.PutUint16(urim[6:8], 1) // data_reference_index
+
+	stsd := make([]byte, 4)
+	binary.BigEndian.PutUint32(stsd, 1) // entry_count
+
+	dref := make([]byte, 4)
+	binary.BigEndian.PutUint32(dref, 1) // entry_count
+
+	return marshalBox("trak",
+		marshalFullBox("tkhd", 0, 3, tkhd),
+		marshalBox("mdia",
+			marshalFullBox("mdhd", 0, 0, mdhd),
+			marshalFullBox("hdlr", 0, 0, hdlr),
+			marshalBox("minf",
+				marshalFullBox("nmhd", 0, 0),
+				marshalBox("dinf",
+					marshalFullBox("dref", 0, 0, dref,
+						marshalFullBox("url ", 0, 1))),
+				marshalBox("stbl",
+					marshalFullBox("stsd", 0, 0, stsd,
+						marshalBox("urim", urim,
+							marshalFullBox("uri ", 0, 0, []byte(klvURI+"\x00")))),
+					marshalFullBox("stts", 0, 0, make([]byte, 4)),
+					marshalFullBox("stsc", 0, 0, make([]byte, 4)),
+					marshalFullBox("stsz", 0, 0, make([]byte, 8)),
+					marshalFullBox("stco", 0, 0, make([]byte, 4))))))
+}
+
+// replaceTrak replaces the trak box of a track of an init segment, updating the size of the moov box.
+func replaceTrak(init []byte, trackIndex int, trak []byte) ([]byte, error) {
+	moovStart, moovEnd, err := findBox(init, 0, len(init), "moov", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, err := findBox(init, moovStart+8, moovEnd, "trak", trackIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	moovSize := binary.BigEndian.Uint32(init[moovStart:])
+	binary.BigEndian.PutUint32(init[moovStart:], moovSize-uint32(end-start)+uint32(len(trak)))
+
+	out := make([]byte, 0, len(init)-(end-start)+len(trak))
+	out = append(out, init[:start]...)
+	out = append(out, trak...)
+	out = append(out, init[end:]...)
+
+	return out, nil
+}
@@ -17,7 +17,8 @@ func writePart(
 	f io.Writer,
 	sequenceNumber uint32,
 	partTracks map[*formatFMP4Track]*fmp4.PartTrack,
-) error {
+	sampleInfos map[int][]*cencSampleInfo,
+) (uint64, error) {
 	fmp4PartTracks := make([]*fmp4.PartTrack, len(partTracks))
 	i := 0
 	for _, partTrack := range partTracks {
@@ -33,11 +34,20 @@ func writePart(
 	var buf seekablebuffer.Buffer
 	err := part.Marshal(&buf)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	_, err = f.Write(buf.Bytes())
-	return err
+	byts := buf.Bytes()
+
+	if len(sampleInfos) != 0 {
+		byts, err = insertSampleEncryption(byts, sampleInfos)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = f.Write(byts)
+	return uint64(len(byts)), err
 }
 
 type formatFMP4Part struct {
@@ -45,18 +55,46 @@ type formatFMP4Part struct {
 	sequenceNumber uint32
 	startDTS       time.Duration
 
-	partTracks map[*formatFMP4Track]*fmp4.PartTrack
-	endDTS     time.Duration
+	partTracks  map[*formatFMP4Track]*fmp4.PartTrack
+	sampleInfos map[int][]*cencSampleInfo
+	endDTS      time.Duration
+	independent bool
+
+	// samples whose payload must be released after the part has been written.
+	retained []*sample
 }
 
 func (p *formatFMP4Part) initialize() {
 	p.partTracks = make(map[*formatFMP4Track]*fmp4.PartTrack)
+	p.sampleInfos = make(map[int][]*cencSampleInfo)
 }
 
 func (p *formatFMP4Part) close() error {
+	if p.s.fi == nil && p.s.span == nil {
+		p.s.path = recordstore.Path{Start: p.s.startNTP}.Encode(p.s.pathFormat)
+		p.s.span = p.s.f.ri.rec.startSegmentSpan(p.s.path)
+	}
+
+	span := p.s.span.StartChild("record.part")
+	defer span.Finish()
+
+	err := p.flush()
+	span.RecordError(err)
+
+	for _, sample := range p.retained {
+		sample.releasePayload()
+	}
+	p.retained = nil
+
+	return err
+}
+
+func (p *formatFMP4Part) flush() error {
+	start := time.Now()
+
 	if p.s.fi == nil {
-		p.s.path = recordstore.Path{Start: p.s.startNTP}.Encode(p.s.f.ri.pathFormat)
-		p.s.f.ri.Log(logger.Debug, "creating segment %s", p.s.path)
+		p.s.path = recordstore.Path{Start: p.s.startNTP}.Encode(p.s.pathFormat)
+		p.s.f.ri.Log(logger.Debug, "[segment %s] creating segment", p.s.path)
 
 		err := os.MkdirAll(filepath.Dir(p.s.path), 0o755)
 		if err != nil {
@@ -69,17 +107,48 @@ func (p *formatFMP4Part) close() error {
 		}
 
 		p.s.f.ri.rec.OnSegmentCreate(p.s.path)
+		p.s.stream = p.s.f.ri.rec.OnSegmentStream(p.s.path)
 
-		err = writeInit(fi, p.s.f.tracks)
+		err = writeInit(p.s.writer(fi), p.s.f.tracks)
 		if err != nil {
 			fi.Close()
 			return err
 		}
 
+		if p.s.f.ri.rec.SegmentIndex {
+			err = p.s.reserveIndex(fi)
+			if err != nil {
+				fi.Close()
+				return err
+			}
+		}
+
+		headerSize, err := fi.Seek(0, io.SeekCurrent)
+		if err != nil {
+			fi.Close()
+			return err
+		}
+		p.s.f.ri.rec.addBytesWritten(uint64(headerSize))
+
 		p.s.fi = fi
 	}
 
-	return writePart(p.s.fi, p.sequenceNumber, p.partTracks)
+	size, err := writePart(p.s.writer(p.s.fi), p.sequenceNumber, p.partTracks, p.sampleInfos)
+	if err != nil {
+		return err
+	}
+
+	p.s.f.ri.rec.addBytesWritten(size)
+
+	if p.s.f.ri.rec.SegmentIndex {
+		p.s.indexEntries = append(p.s.indexEntries, &segmentIndexEntry{
+			size:        size,
+			startDTS:    p.startDTS,
+			independent: p.independent,
+		})
+	}
+
+	return p.s.f.ri.rec.checkWriteLatency(start)
 }
 
 func (p *formatFMP4Part) write(track *formatFMP4Track, sample *sample, dtsDuration time.Duration) error {
@@ -91,11 +160,27 @@ func (p *formatFMP4Part) write(track *formatFMP4Track, sample *sample, dtsDurati
 				int64(track.initTrack.TimeScale), int64(time.Second))),
 		}
 		p.partTracks[track] = partTrack
+
+		if track == p.s.f.indexTrack() {
+			p.independent = !sample.IsNonSyncSample
+		}
+	}
+
+	if track.cenc != nil {
+		info, err := track.cenc.encrypt(sample.PartSample)
+		if err != nil {
+			return err
+		}
+		p.sampleInfos[track.initTrack.ID] = append(p.sampleInfos[track.initTrack.ID], info)
 	}
 
 	partTrack.Samples = append(partTrack.Samples, sample.PartSample)
 	p.endDTS = dtsDuration
 
+	if sample.payload != nil {
+		p.retained = append(p.retained, sample)
+	}
+
 	return nil
 }
 
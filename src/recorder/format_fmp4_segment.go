@@ -2,6 +2,7 @@ package recorder
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
@@ -12,6 +13,7 @@ import (
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4/seekablebuffer"
 
 	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/tracing"
 )
 
 func writeInit(f io.Writer, tracks []*formatFMP4Track) error {
@@ -30,7 +32,52 @@ func writeInit(f io.Writer, tracks []*formatFMP4Track) error {
 		return err
 	}
 
-	_, err = f.Write(buf.Bytes())
+	init2 := buf.Bytes()
+
+	for i, track := range tracks {
+		if track.klv {
+			init2, err = replaceTrak(init2, i, marshalKLVTrak(track.initTrack.ID, track.initTrack.TimeScale))
+			if err != nil {
+				return err
+			}
+		} else if track.vvcSampleEntry != nil {
+			init2, err = replaceSampleEntry(init2, i, track.vvcSampleEntry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, track := range tracks {
+		if boxes := track.hdr.marshal(); boxes != nil {
+			init2, err = insertIntoSampleEntry(init2, i, boxes)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var enc *Encryption
+
+	for i, track := range tracks {
+		if track.cenc != nil {
+			init2, err = protectSampleEntry(init2, i, track.cenc)
+			if err != nil {
+				return err
+			}
+			enc = track.cenc.enc
+		}
+	}
+
+	if enc != nil {
+		moovStart, moovEnd, err := findBox(init2, 0, len(init2), "moov", 0)
+		if err != nil {
+			return err
+		}
+		init2 = insertBoxes(init2, []int{moovStart}, moovEnd, marshalPSSH(enc))
+	}
+
+	_, err = f.Write(init2)
 	return err
 }
 
@@ -98,19 +145,151 @@ func writeDuration(f io.ReadWriteSeeker, d time.Duration) error {
 	return nil
 }
 
+const (
+	sidxHeaderSize    = 40
+	sidxReferenceSize = 12
+)
+
+type segmentIndexEntry struct {
+	size        uint64
+	startDTS    time.Duration
+	independent bool
+}
+
+// segmentIndexMaxReferences returns the maximum number of references of the segment index.
+// Since segments can last longer than the segment duration, space is reserved for
+// twice the expected number of parts. When parts exceed this amount, they are grouped.
+func segmentIndexMaxReferences(segmentDuration time.Duration, partDuration time.Duration) int {
+	n := 16
+	if partDuration > 0 {
+		n += 2 * int(segmentDuration/partDuration)
+	}
+	if n > 0xFFFF {
+		n = 0xFFFF
+	}
+	return n
+}
+
+func marshalFree(size int) []byte {
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(size))
+	copy(buf[4:8], "free")
+	return buf
+}
+
+// marshalSegmentIndex returns a sidx box, followed by a free box that fills the reserved size.
+// Specification: ISO 14496-12, section 8.16.3
+func marshalSegmentIndex(
+	entries []*segmentIndexEntry,
+	track *fmp4.InitTrack,
+	startDTS time.Duration,
+	endDTS time.Duration,
+	reservedSize int,
+) []byte {
+	maxReferences := (reservedSize - sidxHeaderSize) / sidxReferenceSize
+	groupSize := (len(entries) + maxReferences - 1) / maxReferences
+	referenceCount := (len(entries) + groupSize - 1) / groupSize
+
+	toTimeScale := func(d time.Duration) uint64 {
+		return uint64(multiplyAndDivide(int64(d-startDTS), int64(track.TimeScale), int64(time.Second)))
+	}
+
+	sidxSize := sidxHeaderSize + referenceCount*sidxReferenceSize
+	buf := make([]byte, reservedSize)
+
+	binary.BigEndian.PutUint32(buf[0:4], uint32(sidxSize))
+	copy(buf[4:8], "sidx")
+	buf[8] = 1 // version
+	binary.BigEndian.PutUint32(buf[12:16], uint32(track.ID))
+	binary.BigEndian.PutUint32(buf[16:20], track.TimeScale)
+	binary.BigEndian.PutUint64(buf[20:28], toTimeScale(entries[0].startDTS))
+
+	// first_offset is the distance between the end of the sidx box and the first moof box
+	binary.BigEndian.PutUint64(buf[28:36], uint64(reservedSize-sidxSize))
+	binary.BigEndian.PutUint16(buf[38:40], uint16(referenceCount))
+
+	n := sidxHeaderSize
+
+	for i := 0; i < len(entries); i += groupSize {
+		group := entries[i:min(i+groupSize, len(entries))]
+
+		var size uint64
+		for _, e := range group {
+			size += e.size
+		}
+
+		end := endDTS
+		if (i + groupSize) < len(entries) {
+			end = entries[i+groupSize].startDTS
+		}
+
+		binary.BigEndian.PutUint32(buf[n:n+4], uint32(size)&0x7FFFFFFF)
+		binary.BigEndian.PutUint32(buf[n+4:n+8], uint32(toTimeScale(end)-toTimeScale(group[0].startDTS)))
+
+		if group[0].independent {
+			binary.BigEndian.PutUint32(buf[n+8:n+12], 1<<31|1<<28) // starts_with_SAP, SAP_type 1
+		}
+
+		n += sidxReferenceSize
+	}
+
+	if reservedSize > sidxSize {
+		copy(buf[sidxSize:], marshalFree(reservedSize-sidxSize))
+	}
+
+	return buf
+}
+
 type formatFMP4Segment struct {
 	f        *formatFMP4
 	startDTS time.Duration
 	startNTP time.Time
 
-	path    string
-	fi      *os.File
-	curPart *formatFMP4Part
-	lastDTS time.Duration
+	pathFormat   string
+	path         string
+	span         *tracing.Span
+	fi           *os.File
+	stream       io.WriteCloser
+	curPart      *formatFMP4Part
+	lastDTS      time.Duration
+	indexOffset  int64
+	indexSize    int
+	indexEntries []*segmentIndexEntry
 }
 
 func (s *formatFMP4Segment) initialize() {
 	s.lastDTS = s.startDTS
+	s.pathFormat = s.f.ri.segmentPathFormat()
+}
+
+// writer returns the destination of the content of the segment.
+// When the segment is streamed, the content is written both to disk and to the stream.
+func (s *formatFMP4Segment) writer(fi *os.File) io.Writer {
+	if s.stream != nil {
+		return io.MultiWriter(fi, s.stream)
+	}
+	return fi
+}
+
+// reserveIndex reserves space for the segment index, that is written when the segment is closed.
+func (s *formatFMP4Segment) reserveIndex(fi *os.File) error {
+	var err error
+	s.indexOffset, err = fi.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	s.indexSize = sidxHeaderSize + sidxReferenceSize*segmentIndexMaxReferences(
+		s.f.ri.rec.SegmentDuration, s.f.ri.rec.PartDuration)
+
+	_, err = s.writer(fi).Write(marshalFree(s.indexSize))
+	return err
+}
+
+func (s *formatFMP4Segment) writeIndex() error {
+	buf := marshalSegmentIndex(s.indexEntries, s.f.indexTrack().initTrack, s.startDTS, s.lastDTS, s.indexSize)
+	_, err := s.fi.WriteAt(buf, s.indexOffset)
+	return err
 }
 
 func (s *formatFMP4Segment) close() error {
@@ -121,7 +300,14 @@ func (s *formatFMP4Segment) close() error {
 	}
 
 	if s.fi != nil {
-		s.f.ri.Log(logger.Debug, "closing segment %s", s.path)
+		s.f.ri.Log(logger.Debug, "[segment %s] closing segment", s.path)
+
+		if s.indexSize != 0 && len(s.indexEntries) != 0 {
+			err2 := s.writeIndex()
+			if err == nil {
+				err = err2
+			}
+		}
 
 		// write overall duration in the header in order to speed up the playback server
 		duration := s.lastDTS - s.startDTS
@@ -135,11 +321,20 @@ func (s *formatFMP4Segment) close() error {
 			err = err2
 		}
 
+		if s.stream != nil {
+			s.stream.Close()
+		}
+
 		if err2 == nil {
-			s.f.ri.rec.OnSegmentComplete(s.path, duration)
+			s.f.ri.rec.segmentCompleted(s.path, duration)
 		}
+
+		s.span.SetAttribute("duration_s", duration.Seconds())
 	}
 
+	s.span.RecordError(err)
+	s.span.Finish()
+
 	return err
 }
 
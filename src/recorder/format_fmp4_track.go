@@ -7,6 +7,13 @@ import (
 type formatFMP4Track struct {
 	f         *formatFMP4
 	initTrack *fmp4.InitTrack
+	hdr       formatFMP4HDR
+	cenc      *formatFMP4CENC
+	klv       bool
+	vvc       bool
+
+	// sample entry of H266 tracks, that replaces the one of the placeholder codec.
+	vvcSampleEntry []byte
 
 	nextSample *sample
 }
@@ -17,6 +24,13 @@ func (t *formatFMP4Track) write(sample *sample) error {
 		t.f.hasVideo = true
 	}
 
+	// during an emergency, only key frames of video tracks are recorded
+	if t.f.ri.rec.keyframesOnly() && t.f.hasVideo &&
+		(!t.initTrack.Codec.IsVideo() || sample.IsNonSyncSample) {
+		sample.releasePayload()
+		return nil
+	}
+
 	sample, t.nextSample = t.nextSample, sample
 	if sample == nil {
 		return nil
@@ -34,6 +48,7 @@ func (t *formatFMP4Track) write(sample *sample) error {
 		t.f.currentSegment.initialize()
 		// BaseTime is negative, this is not supported by fMP4. Reject the sample silently.
 	} else if (dtsDuration - t.f.currentSegment.startDTS) < 0 {
+		sample.releasePayload()
 		return nil
 	}
 
@@ -46,7 +61,8 @@ func (t *formatFMP4Track) write(sample *sample) error {
 
 	if (!t.f.hasVideo || t.initTrack.Codec.IsVideo()) &&
 		!t.nextSample.IsNonSyncSample &&
-		(nextDTSDuration-t.f.currentSegment.startDTS) >= t.f.ri.rec.SegmentDuration {
+		((nextDTSDuration-t.f.currentSegment.startDTS) >= t.f.ri.rec.SegmentDuration ||
+			t.f.currentSegment.pathFormat != t.f.ri.segmentPathFormat()) {
 		t.f.currentSegment.lastDTS = nextDTSDuration
 		err := t.f.currentSegment.close()
 		if err != nil {
@@ -0,0 +1,47 @@
+package recorder
+
+import (
+	"encoding/binary"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+)
+
+// H266 tracks of fMP4 segments.
+// Specification: ISO 14496-15, section 11
+
+// newVVCPlaceholderCodec returns the codec used to generate the init segment of H266 tracks,
+// whose sample entry is then replaced by the one generated by formatprocessor.H266MP4SampleEntry().
+// Width and height are the ones written into the tkhd box.
+func newVVCPlaceholderCodec() *fmp4.CodecVP9 {
+	return &fmp4.CodecVP9{
+		Width:             1920,
+		Height:            1080,
+		Profile:           0,
+		BitDepth:          8,
+		ChromaSubsampling: 1,
+	}
+}
+
+// replaceSampleEntry replaces the sample entry of a track of an init segment,
+// updating the size of all parent boxes.
+func replaceSampleEntry(init []byte, trackIndex int, newEntry []byte) ([]byte, error) {
+	entry, err := findSampleEntry(init, trackIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := len(newEntry) - (entry.end - entry.start)
+
+	// the last parent is the sample entry itself
+	for _, parent := range entry.parents[:len(entry.parents)-1] {
+		size := binary.BigEndian.Uint32(init[parent:])
+		binary.BigEndian.PutUint32(init[parent:], uint32(int(size)+diff))
+	}
+
+	out := make([]byte, 0, len(init)+diff)
+	out = append(out, init[:entry.start]...)
+	out = append(out, newEntry...)
+	out = append(out, init[entry.end:]...)
+
+	return out, nil
+}
@@ -388,7 +388,7 @@ func (f *formatMPEGTS) initialize() bool {
 	for _, medi := range f.ri.rec.Stream.Desc.Medias {
 		for _, forma := range medi.Formats {
 			if _, ok := setuppedFormatsMap[forma]; !ok {
-				f.ri.Log(logger.Warn, "skipping track %d (%s)", n, forma.Codec())
+				f.ri.Log(logger.Warn, "[track %d] skipping (%s)", n, forma.Codec())
 			}
 			n++
 		}
@@ -426,6 +426,11 @@ func (f *formatMPEGTS) write(
 		f.hasVideo = true
 	}
 
+	// during an emergency, only key frames of video tracks are recorded
+	if f.ri.rec.keyframesOnly() && f.hasVideo && (!isVideo || !randomAccess) {
+		return nil
+	}
+
 	switch {
 	case f.currentSegment == nil:
 		f.currentSegment = &formatMPEGTSSegment{
@@ -436,7 +441,8 @@ func (f *formatMPEGTS) write(
 		f.currentSegment.initialize()
 	case (!f.hasVideo || isVideo) &&
 		randomAccess &&
-		(dtsDuration-f.currentSegment.startDTS) >= f.ri.rec.SegmentDuration:
+		((dtsDuration-f.currentSegment.startDTS) >= f.ri.rec.SegmentDuration ||
+			f.currentSegment.pathFormat != f.ri.segmentPathFormat()):
 		f.currentSegment.lastDTS = dtsDuration
 		err := f.currentSegment.close()
 		if err != nil {
@@ -451,7 +457,7 @@ func (f *formatMPEGTS) write(
 		f.currentSegment.initialize()
 
 	case (dtsDuration - f.currentSegment.lastFlush) >= f.ri.rec.PartDuration:
-		err := f.bw.Flush()
+		err := f.currentSegment.flush()
 		if err != nil {
 			return err
 		}
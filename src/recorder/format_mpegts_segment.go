@@ -7,6 +7,7 @@ import (
 
 	"github.com/flynnletford/mediamtx/src/logger"
 	"github.com/flynnletford/mediamtx/src/recordstore"
+	"github.com/flynnletford/mediamtx/src/tracing"
 )
 
 type formatMPEGTSSegment struct {
@@ -14,23 +15,26 @@ type formatMPEGTSSegment struct {
 	startDTS time.Duration
 	startNTP time.Time
 
-	path      string
-	fi        *os.File
-	lastFlush time.Duration
-	lastDTS   time.Duration
+	pathFormat string
+	path       string
+	span       *tracing.Span
+	fi         *os.File
+	lastFlush  time.Duration
+	lastDTS    time.Duration
 }
 
 func (s *formatMPEGTSSegment) initialize() {
 	s.lastFlush = s.startDTS
 	s.lastDTS = s.startDTS
+	s.pathFormat = s.f.ri.segmentPathFormat()
 	s.f.dw.setTarget(s)
 }
 
 func (s *formatMPEGTSSegment) close() error {
-	err := s.f.bw.Flush()
+	err := s.flush()
 
 	if s.fi != nil {
-		s.f.ri.Log(logger.Debug, "closing segment %s", s.path)
+		s.f.ri.Log(logger.Debug, "[segment %s] closing segment", s.path)
 		err2 := s.fi.Close()
 		if err == nil {
 			err = err2
@@ -38,17 +42,35 @@ func (s *formatMPEGTSSegment) close() error {
 
 		if err2 == nil {
 			duration := s.lastDTS - s.startDTS
-			s.f.ri.rec.OnSegmentComplete(s.path, duration)
+			s.f.ri.rec.segmentCompleted(s.path, duration)
+			s.span.SetAttribute("duration_s", duration.Seconds())
 		}
 	}
 
+	s.span.RecordError(err)
+	s.span.Finish()
+
+	return err
+}
+
+// flush writes buffered data to the segment.
+func (s *formatMPEGTSSegment) flush() error {
+	span := s.span.StartChild("record.flush")
+	defer span.Finish()
+
+	err := s.f.bw.Flush()
+	span.RecordError(err)
 	return err
 }
 
 func (s *formatMPEGTSSegment) Write(p []byte) (int, error) {
 	if s.fi == nil {
-		s.path = recordstore.Path{Start: s.startNTP}.Encode(s.f.ri.pathFormat)
-		s.f.ri.Log(logger.Debug, "creating segment %s", s.path)
+		s.path = recordstore.Path{Start: s.startNTP}.Encode(s.pathFormat)
+		s.f.ri.Log(logger.Debug, "[segment %s] creating segment", s.path)
+
+		if s.span == nil {
+			s.span = s.f.ri.rec.startSegmentSpan(s.path)
+		}
 
 		err := os.MkdirAll(filepath.Dir(s.path), 0o755)
 		if err != nil {
@@ -65,5 +87,13 @@ func (s *formatMPEGTSSegment) Write(p []byte) (int, error) {
 		s.fi = fi
 	}
 
-	return s.fi.Write(p)
+	start := time.Now()
+
+	n, err := s.fi.Write(p)
+	s.f.ri.rec.addBytesWritten(uint64(n))
+	if err != nil {
+		return n, err
+	}
+
+	return n, s.f.ri.rec.checkWriteLatency(start)
 }
@@ -2,11 +2,15 @@
 package recorder
 
 import (
+	"io"
+	"sync/atomic"
 	"time"
 
 	"github.com/flynnletford/mediamtx/src/conf"
 	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/recordstore"
 	"github.com/flynnletford/mediamtx/src/stream"
+	"github.com/flynnletford/mediamtx/src/tracing"
 )
 
 // OnSegmentCreateFunc is the prototype of the function passed as OnSegmentCreate
@@ -15,24 +19,85 @@ type OnSegmentCreateFunc = func(path string)
 // OnSegmentCompleteFunc is the prototype of the function passed as OnSegmentComplete
 type OnSegmentCompleteFunc = func(path string, duration time.Duration)
 
+// OnSegmentStreamFunc is the prototype of the function passed as OnSegmentStream.
+// It returns a writer that receives the content of a segment while it is written,
+// or nil. The writer is closed after the segment has been finalized on disk.
+type OnSegmentStreamFunc = func(path string) io.WriteCloser
+
+// maximum number of segments waiting for their checksum.
+const checksumQueueSize = 64
+
+type completedSegment struct {
+	path     string
+	duration time.Duration
+}
+
+// Stats are statistics of a Recorder.
+type Stats struct {
+	// segments that have been written and closed.
+	SegmentsWritten uint64
+
+	// bytes written to disk.
+	BytesWritten uint64
+
+	// time of the last write to disk, zero if nothing has been written yet.
+	LastWrite time.Time
+
+	// times the recorder has been restarted after an error.
+	Restarts uint64
+
+	// units waiting to be written.
+	QueuedUnits int
+
+	// maximum number of units that can wait to be written.
+	QueueSize int
+
+	// whether the emergency policy is being applied.
+	Emergency bool
+}
+
 // Recorder writes recordings to disk.
+// When Checksum is true, the SHA-256 checksum of every completed segment is computed
+// in background and stored next to the segment, before OnSegmentComplete is called.
+// When the disk is full, when writes fail or when they take more than MaxWriteLatency,
+// EmergencyPolicy is applied until a segment has been written without further issues.
+// Log entries are tagged with ID, when set, and with the track or segment they refer to.
 type Recorder struct {
-	PathFormat        string
-	Format            conf.RecordFormat
-	PartDuration      time.Duration
-	SegmentDuration   time.Duration
-	PathName          string
-	Stream            *stream.Stream
-	OnSegmentCreate   OnSegmentCreateFunc
-	OnSegmentComplete OnSegmentCompleteFunc
-	Parent            logger.Writer
-
-	restartPause time.Duration
+	ID                 string
+	PathFormat         string
+	Format             conf.RecordFormat
+	PartDuration       time.Duration
+	SegmentDuration    time.Duration
+	SegmentIndex       bool
+	RestartPause       time.Duration
+	Encryption         *Encryption
+	Checksum           bool
+	MaxWriteLatency    time.Duration
+	EmergencyPolicy    conf.RecordEmergencyPolicy
+	OverflowPathFormat string
+	PathName           string
+	Stream             *stream.Stream
+	OnSegmentCreate    OnSegmentCreateFunc
+	OnSegmentComplete  OnSegmentCompleteFunc
+	OnSegmentStream    OnSegmentStreamFunc
+	OnEmergency        OnEmergencyFunc
+	OnRecovery         OnRecoveryFunc
+	Tracer             *tracing.Tracer
+	Parent             logger.Writer
 
 	currentInstance *recorderInstance
+	segmentsWritten *uint64
+	bytesWritten    *uint64
+	lastWrite       *int64
+	restarts        *uint64
+	emergencySince  *int64
+
+	// in
+	chChecksum chan completedSegment
 
-	terminate chan struct{}
-	done      chan struct{}
+	terminate    chan struct{}
+	done         chan struct{}
+	checksumDone chan struct{}
 }
 
 // Initialize initializes Recorder.
@@ -45,13 +110,38 @@ func (r *Recorder) Initialize() {
 		r.OnSegmentComplete = func(string, time.Duration) {
 		}
 	}
-	if r.restartPause == 0 {
-		r.restartPause = 2 * time.Second
+	if r.OnSegmentStream == nil {
+		r.OnSegmentStream = func(string) io.WriteCloser {
+			return nil
+		}
+	}
+	if r.OnEmergency == nil {
+		r.OnEmergency = func(EmergencyReason, error) {
+		}
+	}
+	if r.OnRecovery == nil {
+		r.OnRecovery = func() {
+		}
+	}
+	if r.RestartPause == 0 {
+		r.RestartPause = 2 * time.Second
 	}
 
+	r.segmentsWritten = new(uint64)
+	r.bytesWritten = new(uint64)
+	r.lastWrite = new(int64)
+	r.restarts = new(uint64)
+	r.emergencySince = new(int64)
+
 	r.terminate = make(chan struct{})
 	r.done = make(chan struct{})
 
+	if r.Checksum {
+		r.chChecksum = make(chan completedSegment, checksumQueueSize)
+		r.checksumDone = make(chan struct{})
+		go r.runChecksum()
+	}
+
 	r.currentInstance = &recorderInstance{
 		rec: r,
 	}
@@ -62,6 +152,10 @@ func (r *Recorder) Initialize() {
 
 // Log implements logger.Writer.
 func (r *Recorder) Log(level logger.Level, format string, args ...interface{}) {
+	if r.ID != "" {
+		r.Parent.Log(level, "[recorder %s] "+format, append([]interface{}{r.ID}, args...)...)
+		return
+	}
 	r.Parent.Log(level, "[recorder] "+format, args...)
 }
 
@@ -70,6 +164,11 @@ func (r *Recorder) Close() {
 	r.Log(logger.Info, "recording stopped")
 	close(r.terminate)
 	<-r.done
+
+	if r.Checksum {
+		close(r.chChecksum)
+		<-r.checksumDone
+	}
 }
 
 func (r *Recorder) run() {
@@ -84,15 +183,145 @@ func (r *Recorder) run() {
 			return
 		}
 
+		// recording is stopped until the recorder is closed.
+		if r.EmergencyPolicy == conf.RecordEmergencyPolicyStop && r.inEmergency() {
+			r.Log(logger.Error, "recording stopped because of an emergency")
+			<-r.terminate
+			return
+		}
+
 		select {
-		case <-time.After(r.restartPause):
+		case <-time.After(r.RestartPause):
 		case <-r.terminate:
 			return
 		}
 
+		atomic.AddUint64(r.restarts, 1)
+
 		r.currentInstance = &recorderInstance{
 			rec: r,
 		}
 		r.currentInstance.initialize()
 	}
 }
+
+func (r *Recorder) runChecksum() {
+	defer close(r.checksumDone)
+
+	for seg := range r.chChecksum {
+		checksum, err := recordstore.ComputeSegmentChecksum(seg.path)
+		if err == nil {
+			err = recordstore.WriteChecksum(seg.path, checksum)
+		}
+		if err != nil {
+			r.Log(logger.Warn, "[segment %s] unable to save checksum: %v", seg.path, err)
+		}
+
+		r.OnSegmentComplete(seg.path, seg.duration)
+	}
+}
+
+func (r *Recorder) segmentCompleted(path string, duration time.Duration) {
+	atomic.AddUint64(r.segmentsWritten, 1)
+
+	// the emergency is over when a segment has been completed,
+	// and no issues have been detected for a segment duration.
+	if since := atomic.LoadInt64(r.emergencySince); since != 0 &&
+		time.Since(time.Unix(0, since)) >= r.SegmentDuration &&
+		atomic.CompareAndSwapInt64(r.emergencySince, since, 0) {
+		r.Log(logger.Info, "emergency is over")
+		r.OnRecovery()
+	}
+
+	if r.Checksum {
+		r.chChecksum <- completedSegment{path: path, duration: duration}
+		return
+	}
+
+	r.OnSegmentComplete(path, duration)
+}
+
+func (r *Recorder) addBytesWritten(n uint64) {
+	atomic.AddUint64(r.bytesWritten, n)
+	atomic.StoreInt64(r.lastWrite, time.Now().UnixNano())
+}
+
+// startSegmentSpan starts the span that covers the writing of a segment.
+func (r *Recorder) startSegmentSpan(segmentPath string) *tracing.Span {
+	span := r.Tracer.StartSegment(segmentPath, "record.segment")
+	span.SetAttribute("path", r.PathName)
+	span.SetAttribute("segment", segmentPath)
+	if r.Format == conf.RecordFormatMPEGTS {
+		span.SetAttribute("format", "mpegts")
+	} else {
+		span.SetAttribute("format", "fmp4")
+	}
+	return span
+}
+
+// emergency applies the emergency policy.
+func (r *Recorder) emergency(reason EmergencyReason, err error) {
+	now := time.Now().UnixNano()
+
+	// when the emergency is already in progress, its start is moved forward.
+	if atomic.SwapInt64(r.emergencySince, now) != 0 {
+		return
+	}
+
+	r.Log(logger.Error, "%v, applying emergency policy '%s'", err, r.EmergencyPolicy)
+	r.OnEmergency(reason, err)
+}
+
+func (r *Recorder) inEmergency() bool {
+	return atomic.LoadInt64(r.emergencySince) != 0
+}
+
+// keyframesOnly returns whether only key frames of video tracks must be recorded.
+func (r *Recorder) keyframesOnly() bool {
+	return r.EmergencyPolicy == conf.RecordEmergencyPolicyKeyframes && r.inEmergency()
+}
+
+// checkWriteLatency triggers an emergency when a write took more than MaxWriteLatency.
+// An error is returned when recording must be stopped.
+func (r *Recorder) checkWriteLatency(start time.Time) error {
+	if r.MaxWriteLatency == 0 {
+		return nil
+	}
+
+	latency := time.Since(start)
+	if latency <= r.MaxWriteLatency {
+		return nil
+	}
+
+	err := &slowWriteError{latency: latency}
+	r.emergency(EmergencyReasonSlowWrite, err)
+
+	if r.EmergencyPolicy == conf.RecordEmergencyPolicyStop {
+		return err
+	}
+	return nil
+}
+
+// Stats returns statistics of the recorder.
+func (r *Recorder) Stats() *Stats {
+	stats := &Stats{
+		SegmentsWritten: atomic.LoadUint64(r.segmentsWritten),
+		BytesWritten:    atomic.LoadUint64(r.bytesWritten),
+		Restarts:        atomic.LoadUint64(r.restarts),
+		Emergency:       r.inEmergency(),
+	}
+
+	if v := atomic.LoadInt64(r.lastWrite); v != 0 {
+		stats.LastWrite = time.Unix(0, v)
+	}
+
+	for _, rs := range r.Stream.Stats().Readers {
+		if ri, ok := rs.Reader.(*recorderInstance); ok && ri.rec == r {
+			stats.QueuedUnits = rs.QueuedUnits
+			stats.QueueSize = rs.QueueSize
+			break
+		}
+	}
+
+	return stats
+}
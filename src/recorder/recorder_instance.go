@@ -9,20 +9,42 @@ import (
 	"github.com/flynnletford/mediamtx/src/conf"
 	"github.com/flynnletford/mediamtx/src/logger"
 	"github.com/flynnletford/mediamtx/src/recordstore"
+	"github.com/flynnletford/mediamtx/src/unit"
 )
 
 type sample struct {
 	*fmp4.PartSample
 	dts int64
 	ntp time.Time
+
+	// payload that contains the data of the sample, if retained.
+	// It is released once the sample has been written to disk or discarded.
+	payload *unit.Payload
+}
+
+// retainPayload retains the payload of a unit, in order to use its data after the read callback returns.
+func (s *sample) retainPayload(u unit.Unit) *sample {
+	s.payload = unit.GetPayload(u)
+	if s.payload != nil {
+		s.payload.Retain()
+	}
+	return s
+}
+
+func (s *sample) releasePayload() {
+	if s.payload != nil {
+		s.payload.Release()
+		s.payload = nil
+	}
 }
 
 type recorderInstance struct {
 	rec *Recorder
 
-	pathFormat string
-	format     format
-	skip       bool
+	pathFormat         string
+	overflowPathFormat string
+	format             format
+	skip               bool
 
 	terminate chan struct{}
 	done      chan struct{}
@@ -34,13 +56,18 @@ func (ri *recorderInstance) Log(level logger.Level, format string, args ...inter
 }
 
 func (ri *recorderInstance) initialize() {
-	ri.pathFormat = ri.rec.PathFormat
-
 	ri.pathFormat = recordstore.PathAddExtension(
-		strings.ReplaceAll(ri.pathFormat, "%path", ri.rec.PathName),
+		strings.ReplaceAll(ri.rec.PathFormat, "%path", ri.rec.PathName),
 		ri.rec.Format,
 	)
 
+	if ri.rec.OverflowPathFormat != "" {
+		ri.overflowPathFormat = recordstore.PathAddExtension(
+			strings.ReplaceAll(ri.rec.OverflowPathFormat, "%path", ri.rec.PathName),
+			ri.rec.Format,
+		)
+	}
+
 	ri.terminate = make(chan struct{})
 	ri.done = make(chan struct{})
 
@@ -67,6 +94,16 @@ func (ri *recorderInstance) initialize() {
 	go ri.run()
 }
 
+// segmentPathFormat returns the path format of new segments.
+func (ri *recorderInstance) segmentPathFormat() string {
+	if ri.rec.EmergencyPolicy == conf.RecordEmergencyPolicyOverflow &&
+		ri.overflowPathFormat != "" &&
+		ri.rec.inEmergency() {
+		return ri.overflowPathFormat
+	}
+	return ri.pathFormat
+}
+
 func (ri *recorderInstance) close() {
 	close(ri.terminate)
 	<-ri.done
@@ -80,6 +117,10 @@ func (ri *recorderInstance) run() {
 		case err := <-ri.rec.Stream.ReaderError(ri):
 			ri.Log(logger.Error, err.Error())
 
+			if reason, ok := emergencyReason(err); ok {
+				ri.rec.emergency(reason, err)
+			}
+
 		case <-ri.terminate:
 		}
 
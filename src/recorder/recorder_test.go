@@ -1,21 +1,29 @@
 package recorder
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"fmt"
 	"os"
 	"path/filepath"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/abema/go-mp4"
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
 	rtspformat "github.com/bluenviron/gortsplib/v4/pkg/format"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/pion/rtp"
 	"github.com/stretchr/testify/require"
 
 	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/formatprocessor"
 	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/recordstore"
 	"github.com/flynnletford/mediamtx/src/stream"
 	"github.com/flynnletford/mediamtx/src/test"
 	"github.com/flynnletford/mediamtx/src/unit"
@@ -129,6 +137,7 @@ func TestRecorder(t *testing.T) {
 				UDPMaxPayloadSize:  1472,
 				Desc:               desc,
 				GenerateRTPPackets: true,
+				DecodeToLPCM:       true,
 				Parent:             test.NilLogger,
 			}
 			err := strm.Initialize()
@@ -194,7 +203,7 @@ func TestRecorder(t *testing.T) {
 					segDone <- struct{}{}
 				},
 				Parent:       test.NilLogger,
-				restartPause: 1 * time.Millisecond,
+				RestartPause: 1 * time.Millisecond,
 			}
 			w.Initialize()
 
@@ -309,6 +318,26 @@ func TestRecorder(t *testing.T) {
 
 			_, err = os.Stat(filepath.Join(dir, "mypath", "2010-05-20_22-15-25-000000."+ext))
 			require.NoError(t, err)
+
+			entries, err := os.ReadDir(filepath.Join(dir, "mypath"))
+			require.NoError(t, err)
+
+			var size uint64
+			for _, e := range entries {
+				fi, err2 := e.Info()
+				require.NoError(t, err2)
+				size += uint64(fi.Size())
+			}
+
+			stats := w.Stats()
+			require.WithinDuration(t, time.Now(), stats.LastWrite, 2*time.Second)
+			stats.LastWrite = time.Time{}
+
+			require.Equal(t, &Stats{
+				SegmentsWritten: 3,
+				BytesWritten:    size,
+				Restarts:        1,
+			}, stats)
 		})
 	}
 }
@@ -412,25 +441,40 @@ func TestRecorderFMP4NegativeDTS(t *testing.T) {
 	require.Equal(t, true, found)
 }
 
-func TestRecorderSkipTracksPartial(t *testing.T) {
-	for _, ca := range []string{"fmp4", "mpegts"} {
+func TestRecorderFMP4DecodeToLPCM(t *testing.T) {
+	for _, ca := range []string{"recorder", "stream"} {
 		t.Run(ca, func(t *testing.T) {
 			desc := &description.Session{Medias: []*description.Media{
 				{
-					Type:    description.MediaTypeVideo,
-					Formats: []rtspformat.Format{&rtspformat.H264{}},
+					Type: description.MediaTypeVideo,
+					Formats: []rtspformat.Format{&rtspformat.H264{
+						PayloadTyp:        96,
+						PacketizationMode: 1,
+					}},
 				},
 				{
-					Type:    description.MediaTypeVideo,
-					Formats: []rtspformat.Format{&rtspformat.VP8{}},
+					Type: description.MediaTypeAudio,
+					Formats: []rtspformat.Format{&rtspformat.G711{
+						PayloadTyp:   8,
+						MULaw:        false,
+						SampleRate:   8000,
+						ChannelCount: 1,
+					}},
+				},
+				{
+					Type:    description.MediaTypeAudio,
+					Formats: []rtspformat.Format{&rtspformat.G722{}},
 				},
 			}}
 
+			// when samples are decoded by the stream, LPCM is stored into pooled payloads
+			// that are retained by the recorder until they are written.
 			strm := &stream.Stream{
 				WriteQueueSize:     512,
 				UDPMaxPayloadSize:  1472,
 				Desc:               desc,
 				GenerateRTPPackets: true,
+				DecodeToLPCM:       ca == "stream",
 				Parent:             test.NilLogger,
 			}
 			err := strm.Initialize()
@@ -443,97 +487,1185 @@ func TestRecorderSkipTracksPartial(t *testing.T) {
 
 			recordPath := filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f")
 
-			n := 0
-
-			l := test.Logger(func(l logger.Level, format string, args ...interface{}) {
-				if n == 0 {
-					require.Equal(t, logger.Warn, l)
-					require.Equal(t, "[recorder] skipping track 2 (VP8)", fmt.Sprintf(format, args...))
-				}
-				n++
-			})
-
-			var fo conf.RecordFormat
-			if ca == "fmp4" {
-				fo = conf.RecordFormatFMP4
-			} else {
-				fo = conf.RecordFormatMPEGTS
-			}
-
 			w := &Recorder{
 				PathFormat:      recordPath,
-				Format:          fo,
+				Format:          conf.RecordFormatFMP4,
 				PartDuration:    100 * time.Millisecond,
 				SegmentDuration: 1 * time.Second,
 				PathName:        "mypath",
 				Stream:          strm,
-				Parent:          l,
+				Parent:          test.NilLogger,
 			}
 			w.Initialize()
-			defer w.Close()
 
-			require.Equal(t, 2, n)
-		})
-	}
-}
+			for i := 0; i < 3; i++ {
+				strm.WriteUnit(desc.Medias[0], desc.Medias[0].Formats[0], &unit.H264{
+					Base: unit.Base{
+						PTS: int64(i) * 200 * 90000 / 1000,
+						NTP: time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC),
+					},
+					AU: [][]byte{
+						test.FormatH264.SPS,
+						test.FormatH264.PPS,
+						{5}, // IDR
+					},
+				})
 
-func TestRecorderSkipTracksFull(t *testing.T) {
-	for _, ca := range []string{"fmp4", "mpegts"} {
-		t.Run(ca, func(t *testing.T) {
-			desc := &description.Session{Medias: []*description.Media{
-				{
-					Type:    description.MediaTypeVideo,
-					Formats: []rtspformat.Format{&rtspformat.VP8{}},
-				},
-			}}
+				strm.WriteUnit(desc.Medias[1], desc.Medias[1].Formats[0], &unit.G711{
+					Base: unit.Base{
+						PTS: int64(i) * 200 * 8000 / 1000,
+					},
+					Samples: []byte{byte(i + 1), 2, 3, 4},
+				})
 
-			strm := &stream.Stream{
-				WriteQueueSize:     512,
-				UDPMaxPayloadSize:  1472,
-				Desc:               desc,
-				GenerateRTPPackets: true,
-				Parent:             test.NilLogger,
+				strm.WriteUnit(desc.Medias[2], desc.Medias[2].Formats[0], &unit.G722{
+					Base: unit.Base{
+						PTS: int64(i) * 200 * 8000 / 1000,
+					},
+					Frame: []byte{1, 2, 3, 4},
+				})
 			}
-			err := strm.Initialize()
+
+			time.Sleep(50 * time.Millisecond)
+
+			w.Close()
+
+			byts, err := os.ReadFile(filepath.Join(dir, "mypath", "2008-05-20_22-15-25-000000.mp4"))
 			require.NoError(t, err)
-			defer strm.Close()
 
-			dir, err := os.MkdirTemp("", "mediamtx-agent")
+			var parts fmp4.Parts
+			err = parts.Unmarshal(byts)
 			require.NoError(t, err)
-			defer os.RemoveAll(dir)
 
-			recordPath := filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f")
+			var g711Samples, g722Samples int
+
+			for _, part := range parts {
+				for _, track := range part.Tracks {
+					for _, sample := range track.Samples {
+						switch track.ID {
+						case 2:
+							require.Equal(t, formatprocessor.DecodeG711ToLPCM(
+								desc.Medias[1].Formats[0].(*rtspformat.G711),
+								[]byte{byte(g711Samples + 1), 2, 3, 4}), sample.Payload)
+							g711Samples++
+
+						case 3:
+							require.Len(t, sample.Payload, 4*4)
+							g722Samples++
+						}
+					}
+				}
+			}
 
-			n := 0
+			require.NotZero(t, g711Samples)
+			require.NotZero(t, g722Samples)
+		})
+	}
+}
 
-			l := test.Logger(func(l logger.Level, format string, args ...interface{}) {
-				if n == 0 {
-					require.Equal(t, logger.Warn, l)
-					require.Equal(t, "[recorder] no supported tracks found, skipping recording", fmt.Sprintf(format, args...))
-				}
-				n++
-			})
+type testCustomUnit struct {
+	unit.Base
+	Frame []byte
+}
 
-			var fo conf.RecordFormat
-			if ca == "fmp4" {
-				fo = conf.RecordFormatFMP4
-			} else {
-				fo = conf.RecordFormatMPEGTS
+type testCustomProcessor struct{}
+
+func (testCustomProcessor) ProcessUnit(unit.Unit) error {
+	return nil
+}
+
+func (testCustomProcessor) ProcessRTPPacket(*rtp.Packet, time.Time, int64, bool) (unit.Unit, error) {
+	return nil, fmt.Errorf("unused")
+}
+
+func TestRecorderCustomProcessor(t *testing.T) {
+	reg := &formatprocessor.Registration{
+		Match: func(forma rtspformat.Format) bool {
+			g, ok := forma.(*rtspformat.Generic)
+			return ok && g.RTPMa == "x-custom/90000"
+		},
+		New: func(formatprocessor.Params) (formatprocessor.CustomProcessor, error) {
+			return testCustomProcessor{}, nil
+		},
+		FMP4Codec: func(rtspformat.Format) fmp4.Codec {
+			return &fmp4.CodecMJPEG{
+				Width:  640,
+				Height: 480,
 			}
+		},
+		FMP4Samples: func(u unit.Unit) ([]*formatprocessor.FMP4Sample, error) {
+			return []*formatprocessor.FMP4Sample{{
+				PartSample: &fmp4.PartSample{
+					Payload: u.(*testCustomUnit).Frame,
+				},
+				DTS: u.GetPTS(),
+			}}, nil
+		},
+	}
 
-			w := &Recorder{
-				PathFormat:      recordPath,
-				Format:          fo,
-				PartDuration:    100 * time.Millisecond,
-				SegmentDuration: 1 * time.Second,
-				PathName:        "mypath",
-				Stream:          strm,
-				Parent:          l,
+	err := formatprocessor.Register(reg)
+	require.NoError(t, err)
+	defer formatprocessor.Unregister(reg)
+
+	forma := &rtspformat.Generic{
+		PayloadTyp: 96,
+		RTPMa:      "x-custom/90000",
+	}
+	err = forma.Init()
+	require.NoError(t, err)
+
+	desc := &description.Session{Medias: []*description.Media{{
+		Type:    description.MediaTypeVideo,
+		Formats: []rtspformat.Format{forma},
+	}}}
+
+	strm := &stream.Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+		Parent:             test.NilLogger,
+	}
+	err = strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	dir, err := os.MkdirTemp("", "mediamtx-agent")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	w := &Recorder{
+		PathFormat:      filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f"),
+		Format:          conf.RecordFormatFMP4,
+		PartDuration:    100 * time.Millisecond,
+		SegmentDuration: 1 * time.Second,
+		PathName:        "mypath",
+		Stream:          strm,
+		Parent:          test.NilLogger,
+	}
+	w.Initialize()
+
+	for i := 0; i < 3; i++ {
+		strm.WriteUnit(desc.Medias[0], forma, &testCustomUnit{
+			Base: unit.Base{
+				PTS: int64(i) * 90000 / 10,
+				NTP: time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC),
+			},
+			Frame: []byte{byte(i + 1), 2, 3, 4},
+		})
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	w.Close()
+
+	byts, err := os.ReadFile(filepath.Join(dir, "mypath", "2008-05-20_22-15-25-000000.mp4"))
+	require.NoError(t, err)
+
+	var init fmp4.Init
+	err = init.Unmarshal(bytes.NewReader(byts))
+	require.NoError(t, err)
+	require.Equal(t, &fmp4.CodecMJPEG{
+		Width:  640,
+		Height: 480,
+	}, init.Tracks[0].Codec)
+
+	var parts fmp4.Parts
+	err = parts.Unmarshal(byts)
+	require.NoError(t, err)
+
+	var payloads [][]byte
+	for _, part := range parts {
+		for _, sample := range part.Tracks[0].Samples {
+			payloads = append(payloads, sample.Payload)
+		}
+	}
+
+	// the last sample is not written since its duration is unknown
+	require.Equal(t, [][]byte{{1, 2, 3, 4}, {2, 2, 3, 4}}, payloads)
+}
+
+func TestRecorderKLV(t *testing.T) {
+	klvFormat := &rtspformat.Generic{
+		PayloadTyp: 97,
+		RTPMa:      "smpte336m/90000",
+	}
+	err := klvFormat.Init()
+	require.NoError(t, err)
+
+	desc := &description.Session{Medias: []*description.Media{
+		{
+			Type:    description.MediaTypeVideo,
+			Formats: []rtspformat.Format{test.FormatH264},
+		},
+		{
+			Type:    description.MediaTypeApplication,
+			Formats: []rtspformat.Format{klvFormat},
+		},
+	}}
+
+	strm := &stream.Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+		Parent:             test.NilLogger,
+	}
+	err = strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	dir, err := os.MkdirTemp("", "mediamtx-agent")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	w := &Recorder{
+		PathFormat:      filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f"),
+		Format:          conf.RecordFormatFMP4,
+		PartDuration:    100 * time.Millisecond,
+		SegmentDuration: 1 * time.Second,
+		PathName:        "mypath",
+		Stream:          strm,
+		Parent:          test.NilLogger,
+	}
+	w.Initialize()
+
+	for i := 0; i < 3; i++ {
+		strm.WriteUnit(desc.Medias[0], desc.Medias[0].Formats[0], &unit.H264{
+			Base: unit.Base{
+				PTS: int64(i) * 90000 / 10,
+				NTP: time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC),
+			},
+			AU: [][]byte{
+				test.FormatH264.SPS,
+				test.FormatH264.PPS,
+				{5}, // IDR
+			},
+		})
+
+		strm.WriteUnit(desc.Medias[1], klvFormat, &unit.KLV{
+			Base: unit.Base{
+				PTS: int64(i) * 90000 / 10,
+				NTP: time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC),
+			},
+			Unit: []byte{0x06, 0x0e, 0x2b, 0x34, byte(i)},
+		})
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	w.Close()
+
+	byts, err := os.ReadFile(filepath.Join(dir, "mypath", "2008-05-20_22-15-25-000000.mp4"))
+	require.NoError(t, err)
+
+	// the KLV track is skipped by readers that don't support it
+	var init fmp4.Init
+	err = init.Unmarshal(bytes.NewReader(byts))
+	require.NoError(t, err)
+	require.Len(t, init.Tracks, 1)
+
+	infos, err := mp4.ExtractBox(bytes.NewReader(byts), nil, mp4.BoxPath{
+		mp4.BoxTypeMoov(),
+		mp4.BoxTypeTrak(),
+		mp4.BoxTypeMdia(),
+		mp4.BoxTypeHdlr(),
+	})
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	require.Equal(t, "meta", string(byts[infos[1].Offset+16:infos[1].Offset+20]))
+
+	infos, err = mp4.ExtractBox(bytes.NewReader(byts), nil, mp4.BoxPath{
+		mp4.BoxTypeMoov(),
+		mp4.BoxTypeTrak(),
+		mp4.BoxTypeMdia(),
+		mp4.BoxTypeMinf(),
+		mp4.BoxTypeStbl(),
+		mp4.BoxTypeStsd(),
+		mp4.StrToBoxType("urim"),
+	})
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.Contains(t, string(byts[infos[0].Offset:infos[0].Offset+infos[0].Size]), klvURI)
+
+	var parts fmp4.Parts
+	err = parts.Unmarshal(byts)
+	require.NoError(t, err)
+
+	var payloads [][]byte
+	for _, part := range parts {
+		for _, track := range part.Tracks {
+			if track.ID == 2 {
+				for _, sample := range track.Samples {
+					payloads = append(payloads, sample.Payload)
+				}
 			}
-			w.Initialize()
-			defer w.Close()
+		}
+	}
 
-			require.Equal(t, 1, n)
+	require.Equal(t, [][]byte{
+		{0x06, 0x0e, 0x2b, 0x34, 0},
+		{0x06, 0x0e, 0x2b, 0x34, 1},
+	}, payloads)
+}
+
+func TestRecorderH266(t *testing.T) {
+	h266Format := &rtspformat.Generic{
+		PayloadTyp: 96,
+		RTPMa:      "H266/90000",
+	}
+	err := h266Format.Init()
+	require.NoError(t, err)
+
+	desc := &description.Session{Medias: []*description.Media{{
+		Type:    description.MediaTypeVideo,
+		Formats: []rtspformat.Format{h266Format},
+	}}}
+
+	strm := &stream.Stream{
+		WriteQueueSize:    512,
+		UDPMaxPayloadSize: 1472,
+		Desc:              desc,
+		Parent:            test.NilLogger,
+	}
+	err = strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	dir, err := os.MkdirTemp("", "mediamtx-agent")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	w := &Recorder{
+		PathFormat:      filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f"),
+		Format:          conf.RecordFormatFMP4,
+		PartDuration:    100 * time.Millisecond,
+		SegmentDuration: 1 * time.Second,
+		PathName:        "mypath",
+		Stream:          strm,
+		Parent:          test.NilLogger,
+	}
+	w.Initialize()
+
+	sps := []byte{
+		0x00, 0x79, 0x00, 0x0d, 0x02, 0x53, 0x80, 0x00,
+		0x00, 0x0f, 0x02, 0x00, 0x43, 0x94,
+	}
+	pps := []byte{0x00, 0x81, 0x03, 0x04}
+	idr := []byte{0x00, 0x41, 0x05, 0x06} // IDR_N_LP
+	trail := []byte{0x00, 0x01, 0x07, 0x08}
+
+	seqNum := uint16(0)
+
+	for i, au := range [][][]byte{
+		{sps, pps, idr},
+		{trail},
+		{idr},
+	} {
+		for j, nalu := range au {
+			strm.WriteRTPPacket(desc.Medias[0], h266Format, &rtp.Packet{
+				Header: rtp.Header{
+					Version:        2,
+					Marker:         j == len(au)-1,
+					PayloadType:    96,
+					SequenceNumber: seqNum,
+					Timestamp:      uint32(i) * 90000 / 10,
+					SSRC:           1234,
+				},
+				Payload: nalu,
+			}, time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC), int64(i)*90000/10)
+			seqNum++
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	w.Close()
+
+	byts, err := os.ReadFile(filepath.Join(dir, "mypath", "2008-05-20_22-15-25-000000.mp4"))
+	require.NoError(t, err)
+
+	infos, err := mp4.ExtractBox(bytes.NewReader(byts), nil, mp4.BoxPath{
+		mp4.BoxTypeMoov(),
+		mp4.BoxTypeTrak(),
+		mp4.BoxTypeMdia(),
+		mp4.BoxTypeMinf(),
+		mp4.BoxTypeStbl(),
+		mp4.BoxTypeStsd(),
+		mp4.StrToBoxType("vvc1"),
+	})
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+
+	entry, err := formatprocessor.H266MP4SampleEntry(nil, sps, pps)
+	require.NoError(t, err)
+	require.Equal(t, entry, byts[infos[0].Offset:infos[0].Offset+infos[0].Size])
+
+	var parts fmp4.Parts
+	err = parts.Unmarshal(byts)
+	require.NoError(t, err)
+
+	var samples []*fmp4.PartSample
+	for _, part := range parts {
+		for _, track := range part.Tracks {
+			samples = append(samples, track.Samples...)
+		}
+	}
+
+	require.Equal(t, []*fmp4.PartSample{
+		{
+			Duration: 9000,
+			Payload: []byte{
+				0, 0, 0, 14, 0x00, 0x79, 0x00, 0x0d, 0x02, 0x53, 0x80, 0x00,
+				0x00, 0x0f, 0x02, 0x00, 0x43, 0x94,
+				0, 0, 0, 4, 0x00, 0x81, 0x03, 0x04,
+				0, 0, 0, 4, 0x00, 0x41, 0x05, 0x06,
+			},
+		},
+		{
+			Duration:        9000,
+			IsNonSyncSample: true,
+			Payload:         []byte{0, 0, 0, 4, 0x00, 0x01, 0x07, 0x08},
+		},
+	}, samples)
+}
+
+func TestRecorderSkipTracksPartial(t *testing.T) {
+	for _, ca := range []string{"fmp4", "mpegts"} {
+		t.Run(ca, func(t *testing.T) {
+			desc := &description.Session{Medias: []*description.Media{
+				{
+					Type:    description.MediaTypeVideo,
+					Formats: []rtspformat.Format{&rtspformat.H264{}},
+				},
+				{
+					Type:    description.MediaTypeVideo,
+					Formats: []rtspformat.Format{&rtspformat.VP8{}},
+				},
+			}}
+
+			strm := &stream.Stream{
+				WriteQueueSize:     512,
+				UDPMaxPayloadSize:  1472,
+				Desc:               desc,
+				GenerateRTPPackets: true,
+				DecodeToLPCM:       true,
+				Parent:             test.NilLogger,
+			}
+			err := strm.Initialize()
+			require.NoError(t, err)
+			defer strm.Close()
+
+			dir, err := os.MkdirTemp("", "mediamtx-agent")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			recordPath := filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f")
+
+			n := 0
+
+			l := test.Logger(func(l logger.Level, format string, args ...interface{}) {
+				if n == 0 {
+					require.Equal(t, logger.Warn, l)
+					require.Equal(t, "[recorder] [track 2] skipping (VP8)", fmt.Sprintf(format, args...))
+				}
+				n++
+			})
+
+			var fo conf.RecordFormat
+			if ca == "fmp4" {
+				fo = conf.RecordFormatFMP4
+			} else {
+				fo = conf.RecordFormatMPEGTS
+			}
+
+			w := &Recorder{
+				PathFormat:      recordPath,
+				Format:          fo,
+				PartDuration:    100 * time.Millisecond,
+				SegmentDuration: 1 * time.Second,
+				PathName:        "mypath",
+				Stream:          strm,
+				Parent:          l,
+			}
+			w.Initialize()
+			defer w.Close()
+
+			require.Equal(t, 2, n)
+		})
+	}
+}
+
+func TestRecorderSkipTracksFull(t *testing.T) {
+	for _, ca := range []string{"fmp4", "mpegts"} {
+		t.Run(ca, func(t *testing.T) {
+			desc := &description.Session{Medias: []*description.Media{
+				{
+					Type:    description.MediaTypeVideo,
+					Formats: []rtspformat.Format{&rtspformat.VP8{}},
+				},
+			}}
+
+			strm := &stream.Stream{
+				WriteQueueSize:     512,
+				UDPMaxPayloadSize:  1472,
+				Desc:               desc,
+				GenerateRTPPackets: true,
+				DecodeToLPCM:       true,
+				Parent:             test.NilLogger,
+			}
+			err := strm.Initialize()
+			require.NoError(t, err)
+			defer strm.Close()
+
+			dir, err := os.MkdirTemp("", "mediamtx-agent")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			recordPath := filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f")
+
+			n := 0
+
+			l := test.Logger(func(l logger.Level, format string, args ...interface{}) {
+				if n == 0 {
+					require.Equal(t, logger.Warn, l)
+					require.Equal(t, "[recorder] no supported tracks found, skipping recording", fmt.Sprintf(format, args...))
+				}
+				n++
+			})
+
+			var fo conf.RecordFormat
+			if ca == "fmp4" {
+				fo = conf.RecordFormatFMP4
+			} else {
+				fo = conf.RecordFormatMPEGTS
+			}
+
+			w := &Recorder{
+				PathFormat:      recordPath,
+				Format:          fo,
+				PartDuration:    100 * time.Millisecond,
+				SegmentDuration: 1 * time.Second,
+				PathName:        "mypath",
+				Stream:          strm,
+				Parent:          l,
+			}
+			w.Initialize()
+			defer w.Close()
+
+			require.Equal(t, 1, n)
+		})
+	}
+}
+
+func TestRecorderFMP4SegmentIndex(t *testing.T) {
+	desc := &description.Session{Medias: []*description.Media{
+		{
+			Type: description.MediaTypeVideo,
+			Formats: []rtspformat.Format{&rtspformat.H264{
+				PayloadTyp:        96,
+				PacketizationMode: 1,
+			}},
+		},
+	}}
+
+	strm := &stream.Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+		Parent:             test.NilLogger,
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	dir, err := os.MkdirTemp("", "mediamtx-agent")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	recordPath := filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f")
+
+	w := &Recorder{
+		PathFormat:      recordPath,
+		Format:          conf.RecordFormatFMP4,
+		PartDuration:    100 * time.Millisecond,
+		SegmentDuration: 1 * time.Second,
+		SegmentIndex:    true,
+		PathName:        "mypath",
+		Stream:          strm,
+		Parent:          test.NilLogger,
+	}
+	w.Initialize()
+
+	for i := 0; i < 8; i++ {
+		au := [][]byte{{1}} // non-IDR
+		if i%4 == 0 {
+			au = [][]byte{
+				test.FormatH264.SPS,
+				test.FormatH264.PPS,
+				{5}, // IDR
+			}
+		}
+
+		strm.WriteUnit(desc.Medias[0], desc.Medias[0].Formats[0], &unit.H264{
+			Base: unit.Base{
+				PTS: int64(i) * 100 * 90000 / 1000,
+				NTP: time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC),
+			},
+			AU: au,
+		})
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	w.Close()
+
+	fpath := filepath.Join(dir, "mypath", "2008-05-20_22-15-25-000000.mp4")
+
+	f, err := os.Open(fpath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	boxes, err := mp4.ExtractBoxWithPayload(f, nil, mp4.BoxPath{mp4.BoxTypeSidx()})
+	require.NoError(t, err)
+	require.Len(t, boxes, 1)
+
+	sidx := boxes[0].Payload.(*mp4.Sidx)
+	require.Equal(t, uint32(1), sidx.ReferenceID)
+	require.Equal(t, uint32(90000), sidx.Timescale)
+	require.Equal(t, uint16(4), sidx.ReferenceCount)
+
+	byts, err := os.ReadFile(fpath)
+	require.NoError(t, err)
+
+	offset := boxes[0].Info.Offset + boxes[0].Info.Size + sidx.FirstOffsetV1
+	var duration uint32
+
+	for i, ref := range sidx.References {
+		require.Equal(t, "moof", string(byts[offset+4:offset+8]))
+		require.Equal(t, i == 0 || i == 2, ref.StartsWithSAP)
+		offset += uint64(ref.ReferencedSize)
+		duration += ref.SubsegmentDuration
+	}
+
+	require.Equal(t, uint64(len(byts)), offset)
+	require.Equal(t, uint32(700*90000/1000), duration)
+}
+
+func TestMarshalSegmentIndexGroups(t *testing.T) {
+	entries := make([]*segmentIndexEntry, 5)
+	for i := range entries {
+		entries[i] = &segmentIndexEntry{
+			size:        100,
+			startDTS:    time.Duration(i) * time.Second,
+			independent: i != 1,
+		}
+	}
+
+	byts := marshalSegmentIndex(entries, &fmp4.InitTrack{ID: 1, TimeScale: 90000},
+		0, 5*time.Second, sidxHeaderSize+2*sidxReferenceSize+8)
+
+	boxes, err := mp4.ExtractBoxWithPayload(bytes.NewReader(byts), nil, mp4.BoxPath{mp4.BoxTypeSidx()})
+	require.NoError(t, err)
+	require.Len(t, boxes, 1)
+
+	sidx := boxes[0].Payload.(*mp4.Sidx)
+	require.Equal(t, uint64(2*sidxReferenceSize+8-2*sidxReferenceSize), sidx.FirstOffsetV1)
+	require.Equal(t, []mp4.SidxReference{
+		{
+			ReferencedSize:     300,
+			SubsegmentDuration: 3 * 90000,
+			StartsWithSAP:      true,
+			SAPType:            1,
+		},
+		{
+			ReferencedSize:     200,
+			SubsegmentDuration: 2 * 90000,
+			StartsWithSAP:      true,
+			SAPType:            1,
+		},
+	}, sidx.References)
+
+	require.Equal(t, "free", string(byts[len(byts)-8+4:]))
+}
+
+func TestWriteInitHDR(t *testing.T) {
+	tracks := []*formatFMP4Track{
+		{
+			initTrack: &fmp4.InitTrack{
+				ID:        1,
+				TimeScale: 48000,
+				Codec: &fmp4.CodecMPEG4Audio{
+					Config: mpeg4audio.AudioSpecificConfig{
+						Type:         2,
+						SampleRate:   48000,
+						ChannelCount: 2,
+					},
+				},
+			},
+		},
+		{
+			initTrack: &fmp4.InitTrack{
+				ID:        2,
+				TimeScale: 90000,
+				Codec: &fmp4.CodecH265{
+					VPS: formatprocessor.H265DefaultVPS,
+					SPS: formatprocessor.H265DefaultSPS,
+					PPS: formatprocessor.H265DefaultPPS,
+				},
+			},
+			hdr: formatFMP4HDR{
+				masteringDisplay: &unit.MasteringDisplay{
+					DisplayPrimariesX: [3]uint16{8500, 6550, 35400},
+					DisplayPrimariesY: [3]uint16{39850, 2300, 14600},
+					WhitePointX:       15635,
+					WhitePointY:       16450,
+					MaxLuminance:      10000000,
+					MinLuminance:      50,
+				},
+				contentLightLevel: &unit.ContentLightLevel{
+					MaxContentLightLevel:    1000,
+					MaxPicAverageLightLevel: 400,
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	err := writeInit(&buf, tracks)
+	require.NoError(t, err)
+
+	// init must still be readable
+	var init fmp4.Init
+	err = init.Unmarshal(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, init.Tracks, 2)
+
+	sampleEntry := mp4.BoxPath{
+		mp4.BoxTypeMoov(),
+		mp4.BoxTypeTrak(),
+		mp4.BoxTypeMdia(),
+		mp4.BoxTypeMinf(),
+		mp4.BoxTypeStbl(),
+		mp4.BoxTypeStsd(),
+		mp4.BoxTypeHev1(),
+	}
+
+	for _, ca := range []struct {
+		typ     string
+		payload []byte
+	}{
+		{
+			"mdcv",
+			[]byte{
+				0x21, 0x34, 0x9b, 0xaa, 0x19, 0x96, 0x08, 0xfc,
+				0x8a, 0x48, 0x39, 0x08, 0x3d, 0x13, 0x40, 0x42,
+				0x00, 0x98, 0x96, 0x80, 0x00, 0x00, 0x00, 0x32,
+			},
+		},
+		{
+			"clli",
+			[]byte{0x03, 0xe8, 0x01, 0x90},
+		},
+	} {
+		infos, err := mp4.ExtractBox(bytes.NewReader(buf.Bytes()), nil,
+			append(append(mp4.BoxPath{}, sampleEntry...), mp4.StrToBoxType(ca.typ)))
+		require.NoError(t, err)
+		require.Len(t, infos, 1)
+		require.Equal(t, ca.payload,
+			buf.Bytes()[infos[0].Offset+infos[0].HeaderSize:infos[0].Offset+infos[0].Size])
+	}
+}
+
+func decryptSample(
+	t *testing.T,
+	track *formatFMP4Track,
+	info *cencSampleInfo,
+	payload []byte,
+) []byte {
+	block, err := aes.NewCipher(track.cenc.enc.Key[:])
+	require.NoError(t, err)
+
+	out := append([]byte(nil), payload...)
+
+	ranges := [][]byte{out}
+	if info.subsamples != nil {
+		ranges = nil
+		pos := 0
+		for _, s := range info.subsamples {
+			pos += int(s.clear)
+			ranges = append(ranges, out[pos:pos+int(s.protected)])
+			pos += int(s.protected)
+		}
+	}
+
+	if track.cenc.enc.Scheme == conf.RecordEncryptionCBCS {
+		cryptBlocks, skipBlocks := track.cenc.pattern()
+
+		for _, r := range ranges {
+			dec := cipher.NewCBCDecrypter(block, track.cenc.iv[:])
+			n := len(r) / aes.BlockSize
+			step := cryptBlocks + skipBlocks
+			if cryptBlocks == 0 {
+				cryptBlocks, step = n, max(n, 1)
+			}
+
+			for i := 0; i < n; i += step {
+				chunk := r[i*aes.BlockSize : min(i+cryptBlocks, n)*aes.BlockSize]
+				dec.CryptBlocks(chunk, chunk)
+			}
+		}
+	} else {
+		var counter [16]byte
+		copy(counter[:], info.iv)
+		stream := cipher.NewCTR(block, counter[:])
+
+		for _, r := range ranges {
+			stream.XORKeyStream(r, r)
+		}
+	}
+
+	return out
+}
+
+func TestWriteEncrypted(t *testing.T) {
+	for _, ca := range []struct {
+		scheme conf.RecordEncryption
+		name   string
+	}{
+		{conf.RecordEncryptionCENC, "cenc"},
+		{conf.RecordEncryptionCBCS, "cbcs"},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			enc := &Encryption{
+				Scheme: ca.scheme,
+				KeyID:  [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+				Key:    [16]byte{16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1},
+			}
+
+			tracks := []*formatFMP4Track{
+				{
+					initTrack: &fmp4.InitTrack{
+						ID:        1,
+						TimeScale: 90000,
+						Codec: &fmp4.CodecH264{
+							SPS: formatprocessor.H264DefaultSPS,
+							PPS: formatprocessor.H264DefaultPPS,
+						},
+					},
+				},
+				{
+					initTrack: &fmp4.InitTrack{
+						ID:        2,
+						TimeScale: 48000,
+						Codec: &fmp4.CodecMPEG4Audio{
+							Config: mpeg4audio.AudioSpecificConfig{
+								Type:         2,
+								SampleRate:   48000,
+								ChannelCount: 2,
+							},
+						},
+					},
+				},
+			}
+
+			for _, track := range tracks {
+				var err error
+				track.cenc, err = newFormatFMP4CENC(enc, track.initTrack.Codec)
+				require.NoError(t, err)
+			}
+
+			var buf bytes.Buffer
+			err := writeInit(&buf, tracks)
+			require.NoError(t, err)
+
+			stsd := mp4.BoxPath{
+				mp4.BoxTypeMoov(),
+				mp4.BoxTypeTrak(),
+				mp4.BoxTypeMdia(),
+				mp4.BoxTypeMinf(),
+				mp4.BoxTypeStbl(),
+				mp4.BoxTypeStsd(),
+			}
+
+			for _, ca2 := range []struct {
+				entry          mp4.BoxType
+				originalFormat string
+			}{
+				{mp4.BoxTypeEncv(), "avc1"},
+				{mp4.BoxTypeEnca(), "mp4a"},
+			} {
+				sinf := append(append(mp4.BoxPath{}, stsd...), ca2.entry, mp4.BoxTypeSinf())
+
+				boxes, err2 := mp4.ExtractBoxWithPayload(bytes.NewReader(buf.Bytes()), nil,
+					append(append(mp4.BoxPath{}, sinf...), mp4.BoxTypeFrma()))
+				require.NoError(t, err2)
+				require.Len(t, boxes, 1)
+				require.Equal(t, ca2.originalFormat, string(boxes[0].Payload.(*mp4.Frma).DataFormat[:]))
+
+				boxes, err2 = mp4.ExtractBoxWithPayload(bytes.NewReader(buf.Bytes()), nil,
+					append(append(mp4.BoxPath{}, sinf...), mp4.BoxTypeSchm()))
+				require.NoError(t, err2)
+				require.Len(t, boxes, 1)
+				require.Equal(t, ca.name, string(boxes[0].Payload.(*mp4.Schm).SchemeType[:]))
+
+				boxes, err2 = mp4.ExtractBoxWithPayload(bytes.NewReader(buf.Bytes()), nil,
+					append(append(mp4.BoxPath{}, sinf...), mp4.BoxTypeSchi(), mp4.BoxTypeTenc()))
+				require.NoError(t, err2)
+				require.Len(t, boxes, 1)
+				require.Equal(t, enc.KeyID, boxes[0].Payload.(*mp4.Tenc).DefaultKID)
+			}
+
+			boxes, err := mp4.ExtractBoxWithPayload(bytes.NewReader(buf.Bytes()), nil,
+				mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypePssh()})
+			require.NoError(t, err)
+			require.Len(t, boxes, 1)
+			require.Equal(t, []mp4.PsshKID{{KID: enc.KeyID}}, boxes[0].Payload.(*mp4.Pssh).KIDs)
+
+			originals := [][]byte{
+				append([]byte{
+					0, 0, 0, 4, 0x06, 0x05, 0x01, 0x80, // SEI
+					0, 0, 0, 50, 0x65, // IDR
+				}, bytes.Repeat([]byte{1, 2, 3, 4, 5}, 49/5+1)[:49]...),
+				bytes.Repeat([]byte{7, 8, 9}, 20),
+			}
+
+			partTracks := make(map[*formatFMP4Track]*fmp4.PartTrack)
+			sampleInfos := make(map[int][]*cencSampleInfo)
+
+			for i, track := range tracks {
+				sampl := &fmp4.PartSample{
+					Duration: 100,
+					Payload:  append([]byte(nil), originals[i]...),
+				}
+
+				info, err2 := track.cenc.encrypt(sampl)
+				require.NoError(t, err2)
+				require.NotEqual(t, originals[i], sampl.Payload)
+
+				partTracks[track] = &fmp4.PartTrack{
+					ID:      track.initTrack.ID,
+					Samples: []*fmp4.PartSample{sampl},
+				}
+				sampleInfos[track.initTrack.ID] = []*cencSampleInfo{info}
+			}
+
+			require.Equal(t, []cencSubsample{{clear: 13, protected: 49}},
+				sampleInfos[1][0].subsamples)
+
+			buf.Reset()
+			_, err = writePart(&buf, 1, partTracks, sampleInfos)
+			require.NoError(t, err)
+
+			infos, err := mp4.ExtractBox(bytes.NewReader(buf.Bytes()), nil,
+				mp4.BoxPath{mp4.BoxTypeMoof(), mp4.BoxTypeTraf(), mp4.StrToBoxType("senc")})
+			require.NoError(t, err)
+			require.Len(t, infos, 2)
+
+			// data offsets must point to samples
+			var parts fmp4.Parts
+			err = parts.Unmarshal(buf.Bytes())
+			require.NoError(t, err)
+
+			for _, partTrack := range parts[0].Tracks {
+				track := tracks[partTrack.ID-1]
+				require.Equal(t, originals[partTrack.ID-1], decryptSample(t, track,
+					sampleInfos[partTrack.ID][0], partTrack.Samples[0].Payload))
+			}
+		})
+	}
+}
+
+func TestRecorderChecksum(t *testing.T) {
+	desc := &description.Session{Medias: []*description.Media{
+		{
+			Type: description.MediaTypeVideo,
+			Formats: []rtspformat.Format{&rtspformat.H264{
+				PayloadTyp:        96,
+				PacketizationMode: 1,
+			}},
+		},
+	}}
+
+	strm := &stream.Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+		Parent:             test.NilLogger,
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	dir, err := os.MkdirTemp("", "mediamtx-agent")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	recordPath := filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f")
+
+	segCompleted := make(chan string, 4)
+
+	w := &Recorder{
+		PathFormat:      recordPath,
+		Format:          conf.RecordFormatFMP4,
+		PartDuration:    100 * time.Millisecond,
+		SegmentDuration: 1 * time.Second,
+		Checksum:        true,
+		PathName:        "mypath",
+		Stream:          strm,
+		OnSegmentComplete: func(segPath string, _ time.Duration) {
+			segCompleted <- segPath
+		},
+		Parent: test.NilLogger,
+	}
+	w.Initialize()
+
+	for i := 0; i < 2; i++ {
+		strm.WriteUnit(desc.Medias[0], desc.Medias[0].Formats[0], &unit.H264{
+			Base: unit.Base{
+				PTS: int64(i) * 100 * 90000 / 1000,
+				NTP: time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC),
+			},
+			AU: [][]byte{
+				test.FormatH264.SPS,
+				test.FormatH264.PPS,
+				{5}, // IDR
+			},
+		})
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	w.Close()
+
+	fpath := filepath.Join(dir, "mypath", "2008-05-20_22-15-25-000000.mp4")
+	require.Equal(t, fpath, <-segCompleted)
+
+	// the checksum is available when the segment is reported as complete.
+	checksum, err := recordstore.ReadChecksum(fpath)
+	require.NoError(t, err)
+
+	checksum2, err := recordstore.ComputeSegmentChecksum(fpath)
+	require.NoError(t, err)
+	require.Equal(t, checksum2, checksum)
+}
+
+func TestRecorderEmergencyOverflow(t *testing.T) {
+	desc := &description.Session{Medias: []*description.Media{
+		{
+			Type: description.MediaTypeVideo,
+			Formats: []rtspformat.Format{&rtspformat.H264{
+				PayloadTyp:        96,
+				PacketizationMode: 1,
+			}},
+		},
+	}}
+
+	strm := &stream.Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+		Parent:             test.NilLogger,
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	dir, err := os.MkdirTemp("", "mediamtx-agent")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	emergency := make(chan EmergencyReason, 1)
+
+	w := &Recorder{
+		PathFormat:         filepath.Join(dir, "main", "%path/%Y-%m-%d_%H-%M-%S-%f"),
+		OverflowPathFormat: filepath.Join(dir, "overflow", "%path/%Y-%m-%d_%H-%M-%S-%f"),
+		EmergencyPolicy:    conf.RecordEmergencyPolicyOverflow,
+		Format:             conf.RecordFormatFMP4,
+		PartDuration:       100 * time.Millisecond,
+		SegmentDuration:    1 * time.Second,
+		PathName:           "mypath",
+		Stream:             strm,
+		OnEmergency: func(reason EmergencyReason, _ error) {
+			emergency <- reason
+		},
+		Parent: test.NilLogger,
+	}
+	w.Initialize()
+
+	w.emergency(EmergencyReasonDiskFull, syscall.ENOSPC)
+	require.Equal(t, EmergencyReasonDiskFull, <-emergency)
+	require.True(t, w.Stats().Emergency)
+
+	// further issues do not trigger additional notifications.
+	w.emergency(EmergencyReasonSlowWrite, &slowWriteError{latency: time.Second})
+
+	for i := 0; i < 2; i++ {
+		strm.WriteUnit(desc.Medias[0], desc.Medias[0].Formats[0], &unit.H264{
+			Base: unit.Base{
+				PTS: int64(i) * 100 * 90000 / 1000,
+				NTP: time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC),
+			},
+			AU: [][]byte{
+				test.FormatH264.SPS,
+				test.FormatH264.PPS,
+				{5}, // IDR
+			},
+		})
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	w.Close()
+
+	require.Len(t, emergency, 0)
+
+	_, err = os.Stat(filepath.Join(dir, "overflow", "mypath", "2008-05-20_22-15-25-000000.mp4"))
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, "main"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestEmergencyReason(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		err    error
+		reason EmergencyReason
+		ok     bool
+	}{
+		{
+			"disk full",
+			&os.PathError{Op: "write", Path: "/tmp/seg.mp4", Err: syscall.ENOSPC},
+			EmergencyReasonDiskFull,
+			true,
+		},
+		{
+			"slow write",
+			fmt.Errorf("wrapped: %w", &slowWriteError{latency: time.Second}),
+			EmergencyReasonSlowWrite,
+			true,
+		},
+		{
+			"write error",
+			&os.PathError{Op: "open", Path: "/tmp/seg.mp4", Err: syscall.EACCES},
+			EmergencyReasonWriteError,
+			true,
+		},
+		{
+			"other",
+			fmt.Errorf("unsupported codec"),
+			"",
+			false,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			reason, ok := emergencyReason(ca.err)
+			require.Equal(t, ca.ok, ok)
+			require.Equal(t, ca.reason, reason)
 		})
 	}
 }
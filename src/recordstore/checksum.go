@@ -0,0 +1,80 @@
+package recordstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ChecksumPath returns the path of the file that contains the checksum of a segment.
+// The file uses the format of sha256sum, therefore it can be checked with sha256sum -c.
+func ChecksumPath(segmentPath string) string {
+	return strings.TrimSuffix(segmentPath, filepath.Ext(segmentPath)) + ".sha256"
+}
+
+// ComputeChecksum computes the SHA-256 checksum of the content of a reader.
+func ComputeChecksum(r io.Reader) (string, error) {
+	h := sha256.New()
+
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ComputeSegmentChecksum computes the SHA-256 checksum of a segment.
+func ComputeSegmentChecksum(segmentPath string) (string, error) {
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return ComputeChecksum(f)
+}
+
+// WriteChecksum writes the checksum of a segment.
+func WriteChecksum(segmentPath string, checksum string) error {
+	return os.WriteFile(ChecksumPath(segmentPath),
+		[]byte(checksum+"  "+filepath.Base(segmentPath)+"\n"), 0o644)
+}
+
+// ReadChecksum reads the checksum of a segment.
+func ReadChecksum(segmentPath string) (string, error) {
+	byts, err := os.ReadFile(ChecksumPath(segmentPath))
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(string(byts))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid checksum file")
+	}
+
+	return fields[0], nil
+}
+
+// VerifySegment compares the checksum of a segment with the stored one.
+// It returns the stored checksum and whether the segment matches it.
+func VerifySegment(segmentPath string) (string, bool, error) {
+	expected, err := ReadChecksum(segmentPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, fmt.Errorf("checksum not found")
+		}
+		return "", false, err
+	}
+
+	actual, err := ComputeSegmentChecksum(segmentPath)
+	if err != nil {
+		return "", false, err
+	}
+
+	return expected, actual == expected, nil
+}
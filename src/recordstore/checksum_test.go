@@ -0,0 +1,54 @@
+package recordstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksum(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-checksum")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	segmentPath := filepath.Join(dir, "2008-05-20_22-15-25-000125.mp4")
+	require.Equal(t, filepath.Join(dir, "2008-05-20_22-15-25-000125.sha256"), ChecksumPath(segmentPath))
+
+	err = os.WriteFile(segmentPath, []byte("abc"), 0o644)
+	require.NoError(t, err)
+
+	checksum, err := ComputeSegmentChecksum(segmentPath)
+	require.NoError(t, err)
+	require.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", checksum)
+
+	err = WriteChecksum(segmentPath, checksum)
+	require.NoError(t, err)
+
+	byts, err := os.ReadFile(ChecksumPath(segmentPath))
+	require.NoError(t, err)
+	require.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"+
+		"  2008-05-20_22-15-25-000125.mp4\n", string(byts))
+
+	checksum2, err := ReadChecksum(segmentPath)
+	require.NoError(t, err)
+	require.Equal(t, checksum, checksum2)
+
+	_, ok, err := VerifySegment(segmentPath)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	err = os.WriteFile(segmentPath, []byte("abd"), 0o644)
+	require.NoError(t, err)
+
+	_, ok, err = VerifySegment(segmentPath)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	err = RemoveSegment(segmentPath)
+	require.NoError(t, err)
+
+	_, err = os.Stat(ChecksumPath(segmentPath))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,108 @@
+package recordstore
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+)
+
+// SegmentTrack contains metadata of a track of a segment.
+type SegmentTrack struct {
+	ID        int
+	Codec     string
+	TimeScale uint32
+}
+
+// SegmentInfo contains metadata of a fMP4 segment.
+type SegmentInfo struct {
+	Size   uint64
+	Tracks []SegmentTrack
+
+	// duration written into the header, zero when the segment is not finalized.
+	Duration time.Duration
+
+	// number and total duration of complete parts.
+	PartCount     int
+	PartsDuration time.Duration
+}
+
+// Finalized returns whether the segment has been closed properly.
+func (i *SegmentInfo) Finalized() bool {
+	return i.Duration != 0
+}
+
+func codecName(c fmp4.Codec) string {
+	switch c.(type) {
+	case *fmp4.CodecAV1:
+		return "AV1"
+	case *fmp4.CodecVP9:
+		return "VP9"
+	case *fmp4.CodecH265:
+		return "H265"
+	case *fmp4.CodecH264:
+		return "H264"
+	case *fmp4.CodecMPEG4Video:
+		return "MPEG-4 Video"
+	case *fmp4.CodecMPEG1Video:
+		return "MPEG-1/2 Video"
+	case *fmp4.CodecMJPEG:
+		return "M-JPEG"
+	case *fmp4.CodecOpus:
+		return "Opus"
+	case *fmp4.CodecMPEG4Audio:
+		return "MPEG-4 Audio"
+	case *fmp4.CodecMPEG1Audio:
+		return "MPEG-1/2 Audio"
+	case *fmp4.CodecAC3:
+		return "AC-3"
+	case *fmp4.CodecLPCM:
+		return "LPCM"
+	}
+	return "unknown"
+}
+
+// InspectSegment returns metadata of a fMP4 segment.
+func InspectSegment(fpath string) (*SegmentInfo, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	init, duration, err := FMP4ReadHeader(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read segment header: %w", err)
+	}
+
+	size, err := fileSize(f)
+	if err != nil {
+		return nil, err
+	}
+
+	_, parts, err := FMP4ReadParts(f, init)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &SegmentInfo{
+		Size:      size,
+		Duration:  duration,
+		PartCount: len(parts),
+	}
+
+	if len(parts) != 0 {
+		info.PartsDuration = parts[len(parts)-1].End
+	}
+
+	for _, track := range init.Tracks {
+		info.Tracks = append(info.Tracks, SegmentTrack{
+			ID:        track.ID,
+			Codec:     codecName(track.Codec),
+			TimeScale: track.TimeScale,
+		})
+	}
+
+	return info, nil
+}
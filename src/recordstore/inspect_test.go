@@ -0,0 +1,74 @@
+package recordstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4/seekablebuffer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectSegment(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-recordstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	init := fmp4.Init{
+		Tracks: []*fmp4.InitTrack{{
+			ID:        1,
+			TimeScale: 90000,
+			Codec: &fmp4.CodecH264{
+				SPS: []byte{
+					0x67, 0x42, 0xc0, 0x28, 0xd9, 0x00, 0x78, 0x02,
+					0x27, 0xe5, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04,
+					0x00, 0x00, 0x03, 0x00, 0xf0, 0x3c, 0x60, 0xc9, 0x20,
+				},
+				PPS: []byte{0x08, 0x06, 0x07, 0x08},
+			},
+		}},
+	}
+
+	var buf seekablebuffer.Buffer
+	err = init.Marshal(&buf)
+	require.NoError(t, err)
+
+	parts := make(fmp4.Parts, 4)
+	for i := range parts {
+		parts[i] = &fmp4.Part{
+			SequenceNumber: uint32(i),
+			Tracks: []*fmp4.PartTrack{{
+				ID:       1,
+				BaseTime: uint64(i) * 90000,
+				Samples: []*fmp4.PartSample{{
+					Duration:        90000,
+					IsNonSyncSample: i == 2,
+					Payload:         []byte{byte(i)},
+				}},
+			}},
+		}
+	}
+
+	err = parts.Marshal(&buf)
+	require.NoError(t, err)
+
+	fpath := filepath.Join(dir, "2008-11-07_11-22-00-000000.mp4")
+	err = os.WriteFile(fpath, buf.Bytes(), 0o644)
+	require.NoError(t, err)
+
+	info, err := InspectSegment(fpath)
+	require.NoError(t, err)
+	require.False(t, info.Finalized())
+	require.Equal(t, &SegmentInfo{
+		Size: uint64(len(buf.Bytes())),
+		Tracks: []SegmentTrack{{
+			ID:        1,
+			Codec:     "H264",
+			TimeScale: 90000,
+		}},
+		PartCount:     4,
+		PartsDuration: 4 * time.Second,
+	}, info)
+}
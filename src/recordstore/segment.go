@@ -3,6 +3,7 @@ package recordstore
 import (
 	"errors"
 	"io/fs"
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -20,6 +21,30 @@ var errFound = errors.New("found")
 type Segment struct {
 	Fpath string
 	Start time.Time
+
+	// the local copy has been removed and the segment is available in the upload storage only.
+	Remote bool
+}
+
+// decodeSegmentPath decodes the path of a segment.
+// Upload statuses of segments whose local copy has been removed are decoded too,
+// and the path of the segment is returned in their place.
+func decodeSegmentPath(recordPath string, fpath string) (string, *Path, bool, bool) {
+	var pa Path
+	if pa.Decode(recordPath, fpath) {
+		return fpath, &pa, false, true
+	}
+
+	if strings.HasSuffix(fpath, uploadStatusExt) {
+		segmentPath := strings.TrimSuffix(fpath, uploadStatusExt) + filepath.Ext(recordPath)
+		if pa.Decode(recordPath, segmentPath) {
+			if _, err := os.Stat(segmentPath); os.IsNotExist(err) {
+				return segmentPath, &pa, true, true
+			}
+		}
+	}
+
+	return "", nil, false, false
 }
 
 func fixedPathHasSegments(pathConf *conf.Path) bool {
@@ -40,8 +65,7 @@ func fixedPathHasSegments(pathConf *conf.Path) bool {
 		}
 
 		if !info.IsDir() {
-			var pa Path
-			ok := pa.Decode(recordPath, fpath)
+			_, _, _, ok := decodeSegmentPath(recordPath, fpath)
 			if ok {
 				return errFound
 			}
@@ -76,8 +100,7 @@ func regexpPathFindPathsWithSegments(pathConf *conf.Path) map[string]struct{} {
 		}
 
 		if !info.IsDir() {
-			var pa Path
-			if ok := pa.Decode(recordPath, fpath); ok {
+			if _, pa, _, ok := decodeSegmentPath(recordPath, fpath); ok {
 				if err := conf.IsValidPathName(pa.Path); err == nil {
 					if pathConf.Regexp.FindStringSubmatch(pa.Path) != nil {
 						ret[pa.Path] = struct{}{}
@@ -119,17 +142,15 @@ func FindAllPathsWithSegments(pathConfs map[string]*conf.Path) []string {
 	return out
 }
 
-// FindSegments returns all segments of a path.
-// Segments can be filtered by start date and end date.
-func FindSegments(
-	pathConf *conf.Path,
+func findSegmentsInRecordPath(
+	recordPath string,
 	pathName string,
-	start *time.Time,
+	recordFormat conf.RecordFormat,
 	end *time.Time,
 ) ([]*Segment, error) {
-	recordPath := PathAddExtension(
-		strings.ReplaceAll(pathConf.RecordPath, "%path", pathName),
-		pathConf.RecordFormat,
+	recordPath = PathAddExtension(
+		strings.ReplaceAll(recordPath, "%path", pathName),
+		recordFormat,
 	)
 
 	// we have to convert to absolute paths
@@ -145,24 +166,48 @@ func FindSegments(
 		}
 
 		if !info.IsDir() {
-			var pa Path
-			ok := pa.Decode(recordPath, fpath)
+			segmentPath, pa, remote, ok := decodeSegmentPath(recordPath, fpath)
 
 			// gather all segments that starts before the end of the playback
 			if ok && (end == nil || !end.Before(pa.Start)) {
 				segments = append(segments, &Segment{
-					Fpath: fpath,
-					Start: pa.Start,
+					Fpath:  segmentPath,
+					Start:  pa.Start,
+					Remote: remote,
 				})
 			}
 		}
 
 		return nil
 	})
-	if err != nil {
+
+	return segments, err
+}
+
+// FindSegments returns all segments of a path.
+// Segments written into the overflow directory are included.
+// Segments can be filtered by start date and end date.
+func FindSegments(
+	pathConf *conf.Path,
+	pathName string,
+	start *time.Time,
+	end *time.Time,
+) ([]*Segment, error) {
+	segments, err := findSegmentsInRecordPath(pathConf.RecordPath, pathName, pathConf.RecordFormat, end)
+	if err != nil && (pathConf.RecordOverflowPath == "" || !errors.Is(err, fs.ErrNotExist)) {
 		return nil, err
 	}
 
+	if pathConf.RecordOverflowPath != "" {
+		var overflowSegments []*Segment
+		overflowSegments, err = findSegmentsInRecordPath(pathConf.RecordOverflowPath, pathName,
+			pathConf.RecordFormat, end)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		segments = append(segments, overflowSegments...)
+	}
+
 	if segments == nil {
 		return nil, ErrNoSegmentsFound
 	}
@@ -0,0 +1,250 @@
+package recordstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/abema/go-mp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+)
+
+const sampleFlagIsNonSyncSample = 1 << 16
+
+var errTerminated = errors.New("terminated")
+
+func durationMp4ToGo(v int64, timeScale uint32) time.Duration {
+	timeScale64 := int64(timeScale)
+	secs := v / timeScale64
+	dec := v % timeScale64
+	return time.Duration(secs)*time.Second + time.Duration(dec)*time.Second/time.Duration(timeScale64)
+}
+
+func findInitTrack(tracks []*fmp4.InitTrack, id int) *fmp4.InitTrack {
+	for _, track := range tracks {
+		if track.ID == id {
+			return track
+		}
+	}
+	return nil
+}
+
+func fileSize(r io.Seeker) (uint64, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(size), nil
+}
+
+// FMP4ReadHeader reads the initialization section of a fMP4 segment (ftyp and moov),
+// and returns it together with the duration written into the mvhd box.
+func FMP4ReadHeader(r io.ReadSeeker) (*fmp4.Init, time.Duration, error) {
+	// check and skip ftyp
+
+	buf := make([]byte, 8)
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !bytes.Equal(buf[4:], []byte{'f', 't', 'y', 'p'}) {
+		return nil, 0, fmt.Errorf("ftyp box not found")
+	}
+
+	ftypSize := uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
+
+	_, err = r.Seek(int64(ftypSize), io.SeekStart)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// check moov
+
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !bytes.Equal(buf[4:], []byte{'m', 'o', 'o', 'v'}) {
+		return nil, 0, fmt.Errorf("moov box not found")
+	}
+
+	moovSize := uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
+
+	// skip moov header
+
+	_, err = r.Seek(8, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// read mvhd
+
+	var mvhd mp4.Mvhd
+	mvhdSize, err := mp4.Unmarshal(r, uint64(moovSize-8), &mvhd, mp4.Context{})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	mvhdSize += 8                    // add mvhd header
+	moovSize -= 8 + uint32(mvhdSize) // remove moov header and mvhd
+
+	d := time.Duration(mvhd.DurationV0) * time.Second / time.Duration(mvhd.Timescale)
+
+	// read tracks
+
+	buf = make([]byte, uint64(moovSize))
+
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var init fmp4.Init
+	err = init.Unmarshal(bytes.NewReader(buf))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &init, d, nil
+}
+
+// FMP4Part is a part (moof and mdat) of a fMP4 segment.
+type FMP4Part struct {
+	Offset uint64
+	Size   uint64
+
+	// time range of the part, relative to the start of the segment
+	Start time.Duration
+	End   time.Duration
+
+	// whether the part starts with a sync sample in every track
+	Independent bool
+}
+
+// FMP4ReadParts returns the size of the header (ftyp and moov)
+// and the position and time range of each complete part (moof and mdat).
+// Parts without samples and incomplete parts, as in segments that are still being written, are discarded.
+func FMP4ReadParts(
+	r io.ReadSeeker,
+	init *fmp4.Init,
+) (uint64, []*FMP4Part, error) {
+	size, err := fileSize(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var headerSize uint64
+	var parts []*FMP4Part
+	var curPart *FMP4Part
+	var tfhd *mp4.Tfhd
+	var tfdt *mp4.Tfdt
+
+	_, err = mp4.ReadBoxStructure(r, func(h *mp4.ReadHandle) (interface{}, error) {
+		switch h.BoxInfo.Type.String() {
+		case "ftyp", "moov":
+			headerSize = h.BoxInfo.Offset + h.BoxInfo.Size
+
+		case "moof":
+			curPart = &FMP4Part{
+				Offset:      h.BoxInfo.Offset,
+				Start:       -1,
+				Independent: true,
+			}
+			return h.Expand()
+
+		case "traf":
+			return h.Expand()
+
+		case "tfhd":
+			box, _, err := h.ReadPayload()
+			if err != nil {
+				return nil, err
+			}
+			tfhd = box.(*mp4.Tfhd)
+
+		case "tfdt":
+			box, _, err := h.ReadPayload()
+			if err != nil {
+				return nil, err
+			}
+			tfdt = box.(*mp4.Tfdt)
+
+		case "trun":
+			box, _, err := h.ReadPayload()
+			if err != nil {
+				return nil, err
+			}
+			trun := box.(*mp4.Trun)
+
+			track := findInitTrack(init.Tracks, int(tfhd.TrackID))
+			if track == nil {
+				return nil, fmt.Errorf("invalid track ID: %v", tfhd.TrackID)
+			}
+
+			dts := int64(tfdt.BaseMediaDecodeTimeV1)
+
+			start := durationMp4ToGo(dts, track.TimeScale)
+			if curPart.Start < 0 || start < curPart.Start {
+				curPart.Start = start
+			}
+
+			for i, e := range trun.Entries {
+				flags := e.SampleFlags
+				if i == 0 && trun.CheckFlag(0x000004) {
+					flags = trun.FirstSampleFlags
+				}
+
+				if i == 0 && (flags&sampleFlagIsNonSyncSample) != 0 {
+					curPart.Independent = false
+				}
+
+				dts += int64(e.SampleDuration)
+			}
+
+			end := durationMp4ToGo(dts, track.TimeScale)
+			if end > curPart.End {
+				curPart.End = end
+			}
+
+		case "mdat":
+			if curPart == nil {
+				return nil, fmt.Errorf("moof box not found")
+			}
+
+			curPart.Size = h.BoxInfo.Offset + h.BoxInfo.Size - curPart.Offset
+
+			if (curPart.Offset + curPart.Size) > size {
+				return nil, errTerminated
+			}
+
+			// skip parts without samples
+			if curPart.Start >= 0 && curPart.End > curPart.Start {
+				parts = append(parts, curPart)
+			}
+			curPart = nil
+		}
+		return nil, nil
+	})
+	if err != nil && !errors.Is(err, errTerminated) {
+		// the last part may be truncated
+		if len(parts) == 0 {
+			return 0, nil, err
+		}
+	}
+
+	if headerSize == 0 {
+		return 0, nil, fmt.Errorf("moov box not found")
+	}
+
+	return headerSize, parts, nil
+}
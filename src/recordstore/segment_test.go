@@ -140,3 +140,54 @@ func TestFindSegments(t *testing.T) {
 		})
 	}
 }
+
+func TestFindSegmentsRemote(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-recordstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "path1"), 0o755)
+	require.NoError(t, err)
+
+	// uploaded segment whose local copy has been removed
+	err = WriteUploadStatus(filepath.Join(dir, "path1", "2015-05-19_22-15-25-000427.mp4"), &UploadStatus{
+		State: UploadStateUploaded,
+	})
+	require.NoError(t, err)
+
+	// uploaded segment that is still available locally
+	err = os.WriteFile(filepath.Join(dir, "path1", "2016-05-19_22-15-25-000427.mp4"), []byte{1}, 0o644)
+	require.NoError(t, err)
+
+	err = WriteUploadStatus(filepath.Join(dir, "path1", "2016-05-19_22-15-25-000427.mp4"), &UploadStatus{
+		State: UploadStateUploaded,
+	})
+	require.NoError(t, err)
+
+	pathConf := &conf.Path{
+		Name:         "path1",
+		RecordPath:   filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f"),
+		RecordFormat: conf.RecordFormatFMP4,
+	}
+
+	segments, err := FindSegments(pathConf, "path1", nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []*Segment{
+		{
+			Fpath:  filepath.Join(dir, "path1", "2015-05-19_22-15-25-000427.mp4"),
+			Start:  time.Date(2015, 5, 19, 22, 15, 25, 427000, time.Local),
+			Remote: true,
+		},
+		{
+			Fpath: filepath.Join(dir, "path1", "2016-05-19_22-15-25-000427.mp4"),
+			Start: time.Date(2016, 5, 19, 22, 15, 25, 427000, time.Local),
+		},
+	}, segments)
+
+	err = RemoveSegment(segments[0].Fpath)
+	require.NoError(t, err)
+
+	segments, err = FindSegments(pathConf, "path1", nil, nil)
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+}
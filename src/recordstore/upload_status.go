@@ -0,0 +1,83 @@
+package recordstore
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const uploadStatusExt = ".upload.json"
+
+// UploadState is the state of the upload of a segment.
+type UploadState string
+
+// upload states.
+const (
+	UploadStateUploaded UploadState = "uploaded"
+	UploadStateFailed   UploadState = "failed"
+)
+
+// UploadStatus is the status of the upload of a segment.
+// It is stored into a file next to the segment.
+type UploadStatus struct {
+	State    UploadState `json:"state"`
+	Storage  string      `json:"storage"`
+	Bucket   string      `json:"bucket"`
+	Key      string      `json:"key"`
+	Time     time.Time   `json:"time"`
+	Attempts int         `json:"attempts"`
+	Checksum string      `json:"checksum,omitempty"`
+	Error    string      `json:"error,omitempty"`
+
+	// duration of the segment in seconds.
+	// It is filled when the local copy of the segment is removed.
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// UploadStatusPath returns the path of the file that contains the upload status of a segment.
+// The extension of the segment is replaced, therefore the file is never mistaken for a segment.
+func UploadStatusPath(segmentPath string) string {
+	return strings.TrimSuffix(segmentPath, filepath.Ext(segmentPath)) + uploadStatusExt
+}
+
+// WriteUploadStatus writes the upload status of a segment.
+func WriteUploadStatus(segmentPath string, s *UploadStatus) error {
+	byts, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(UploadStatusPath(segmentPath), byts, 0o644)
+}
+
+// ReadUploadStatus reads the upload status of a segment.
+func ReadUploadStatus(segmentPath string) (*UploadStatus, error) {
+	byts, err := os.ReadFile(UploadStatusPath(segmentPath))
+	if err != nil {
+		return nil, err
+	}
+
+	var s UploadStatus
+	err = json.Unmarshal(byts, &s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// RemoveSegment removes a segment, its upload status and its checksum.
+func RemoveSegment(segmentPath string) error {
+	err := os.Remove(segmentPath)
+	statusErr := os.Remove(UploadStatusPath(segmentPath))
+	os.Remove(ChecksumPath(segmentPath)) //nolint:errcheck
+
+	// segments whose local copy has been removed are made of the upload status only.
+	if os.IsNotExist(err) && statusErr == nil {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,44 @@
+package recordstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadStatus(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-upload-status")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	segmentPath := filepath.Join(dir, "2008-05-20_22-15-25-000125.mp4")
+	require.Equal(t, filepath.Join(dir, "2008-05-20_22-15-25-000125.upload.json"), UploadStatusPath(segmentPath))
+
+	err = os.WriteFile(segmentPath, []byte{1, 2, 3}, 0o644)
+	require.NoError(t, err)
+
+	s := &UploadStatus{
+		State:    UploadStateFailed,
+		Bucket:   "mybucket",
+		Key:      "mypath/2008-05-20_22-15-25-000125.mp4",
+		Time:     time.Date(2008, 5, 20, 22, 16, 0, 0, time.UTC),
+		Attempts: 3,
+		Error:    "bad status code: 500",
+	}
+
+	err = WriteUploadStatus(segmentPath, s)
+	require.NoError(t, err)
+
+	s2, err := ReadUploadStatus(segmentPath)
+	require.NoError(t, err)
+	require.Equal(t, s, s2)
+
+	err = RemoveSegment(segmentPath)
+	require.NoError(t, err)
+
+	_, err = os.Stat(UploadStatusPath(segmentPath))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,304 @@
+package rtptomp4
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// capturePacket is a RTP or RTCP packet read from a capture.
+type capturePacket struct {
+	time    time.Time
+	payload []byte
+	isRTCP  bool
+}
+
+type captureReader interface {
+	readPacket() (*capturePacket, error)
+}
+
+func newCaptureReader(r io.Reader) (captureReader, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read capture: %w", err)
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte("#!rt")):
+		cr := &captureRtpdump{r: br}
+		err = cr.initialize()
+		return cr, err
+
+	case bytes.Equal(magic, []byte{0x0a, 0x0d, 0x0d, 0x0a}):
+		return nil, fmt.Errorf("pcapng captures are not supported, convert them into pcap first")
+
+	default:
+		cr := &capturePcap{r: br}
+		err = cr.initialize()
+		return cr, err
+	}
+}
+
+// isRTCP tells whether an UDP payload is a RTCP packet, by using the
+// payload type range reserved to RTCP (RFC5761).
+func isRTCP(payload []byte) bool {
+	return len(payload) >= 2 && payload[1] >= 192 && payload[1] <= 223
+}
+
+// captureRtpdump reads captures in the rtpdump format, produced by rtptools and Wireshark.
+type captureRtpdump struct {
+	r *bufio.Reader
+
+	startTime time.Time
+}
+
+func (c *captureRtpdump) initialize() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("invalid rtpdump header: %w", err)
+	}
+
+	if len(line) < 12 || line[:12] != "#!rtpplay1.0" {
+		return fmt.Errorf("unsupported rtpdump version")
+	}
+
+	var header [16]byte
+	_, err = io.ReadFull(c.r, header[:])
+	if err != nil {
+		return fmt.Errorf("invalid rtpdump header: %w", err)
+	}
+
+	c.startTime = time.Unix(
+		int64(binary.BigEndian.Uint32(header[0:4])),
+		int64(binary.BigEndian.Uint32(header[4:8]))*int64(time.Microsecond))
+
+	return nil
+}
+
+func (c *captureRtpdump) readPacket() (*capturePacket, error) {
+	var header [8]byte
+	_, err := io.ReadFull(c.r, header[:])
+	if err != nil {
+		return nil, err
+	}
+
+	length := int(binary.BigEndian.Uint16(header[0:2]))
+	plen := binary.BigEndian.Uint16(header[2:4])
+	offset := binary.BigEndian.Uint32(header[4:8])
+
+	if length < 8 {
+		return nil, fmt.Errorf("invalid rtpdump packet")
+	}
+
+	payload := make([]byte, length-8)
+	_, err = io.ReadFull(c.r, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if plen != 0 && int(plen) < len(payload) {
+		payload = payload[:plen]
+	}
+
+	return &capturePacket{
+		time:    c.startTime.Add(time.Duration(offset) * time.Millisecond),
+		payload: payload,
+		isRTCP:  plen == 0 || isRTCP(payload),
+	}, nil
+}
+
+const (
+	pcapLinkTypeNull     = 0
+	pcapLinkTypeEthernet = 1
+	pcapLinkTypeRaw      = 101
+	pcapLinkTypeLinuxSLL = 113
+	pcapLinkTypeIPv4     = 228
+	pcapLinkTypeIPv6     = 229
+)
+
+// capturePcap reads captures in the pcap format, produced by tcpdump and Wireshark.
+// UDP packets are extracted from IPv4 and IPv6 frames.
+type capturePcap struct {
+	r io.Reader
+
+	order    binary.ByteOrder
+	nano     bool
+	linkType uint32
+}
+
+func (c *capturePcap) initialize() error {
+	var header [24]byte
+	_, err := io.ReadFull(c.r, header[:])
+	if err != nil {
+		return fmt.Errorf("invalid pcap header: %w", err)
+	}
+
+	switch binary.LittleEndian.Uint32(header[0:4]) {
+	case 0xa1b2c3d4:
+		c.order = binary.LittleEndian
+
+	case 0xa1b23c4d:
+		c.order = binary.LittleEndian
+		c.nano = true
+
+	case 0xd4c3b2a1:
+		c.order = binary.BigEndian
+
+	case 0x4d3cb2a1:
+		c.order = binary.BigEndian
+		c.nano = true
+
+	default:
+		return fmt.Errorf("unsupported capture format")
+	}
+
+	c.linkType = c.order.Uint32(header[20:24])
+
+	switch c.linkType {
+	case pcapLinkTypeNull, pcapLinkTypeEthernet, pcapLinkTypeRaw,
+		pcapLinkTypeLinuxSLL, pcapLinkTypeIPv4, pcapLinkTypeIPv6:
+
+	default:
+		return fmt.Errorf("unsupported link type: %d", c.linkType)
+	}
+
+	return nil
+}
+
+func (c *capturePcap) readPacket() (*capturePacket, error) {
+	for {
+		var header [16]byte
+		_, err := io.ReadFull(c.r, header[:])
+		if err != nil {
+			return nil, err
+		}
+
+		sec := int64(c.order.Uint32(header[0:4]))
+		frac := int64(c.order.Uint32(header[4:8]))
+		length := c.order.Uint32(header[8:12])
+
+		if length > 256*1024 {
+			return nil, fmt.Errorf("invalid pcap packet")
+		}
+
+		frame := make([]byte, length)
+		_, err = io.ReadFull(c.r, frame)
+		if err != nil {
+			return nil, err
+		}
+
+		payload := c.udpPayload(frame)
+		if payload == nil {
+			continue
+		}
+
+		if !c.nano {
+			frac *= int64(time.Microsecond)
+		}
+
+		return &capturePacket{
+			time:    time.Unix(sec, frac),
+			payload: payload,
+			isRTCP:  isRTCP(payload),
+		}, nil
+	}
+}
+
+// udpPayload returns the UDP payload of a frame, or nil if the frame doesn't contain an UDP packet.
+func (c *capturePcap) udpPayload(frame []byte) []byte {
+	var ip []byte
+
+	switch c.linkType {
+	case pcapLinkTypeNull:
+		if len(frame) < 4 {
+			return nil
+		}
+		ip = frame[4:]
+
+	case pcapLinkTypeEthernet:
+		if len(frame) < 14 {
+			return nil
+		}
+		etherType := binary.BigEndian.Uint16(frame[12:14])
+		frame = frame[14:]
+
+		// VLAN tags
+		for etherType == 0x8100 || etherType == 0x88a8 {
+			if len(frame) < 4 {
+				return nil
+			}
+			etherType = binary.BigEndian.Uint16(frame[2:4])
+			frame = frame[4:]
+		}
+
+		if etherType != 0x0800 && etherType != 0x86dd {
+			return nil
+		}
+		ip = frame
+
+	case pcapLinkTypeLinuxSLL:
+		if len(frame) < 16 {
+			return nil
+		}
+		ip = frame[16:]
+
+	default:
+		ip = frame
+	}
+
+	if len(ip) < 1 {
+		return nil
+	}
+
+	var udp []byte
+
+	switch ip[0] >> 4 {
+	case 4:
+		if len(ip) < 20 {
+			return nil
+		}
+
+		headerLen := int(ip[0]&0x0f) * 4
+		totalLen := int(binary.BigEndian.Uint16(ip[2:4]))
+		fragment := binary.BigEndian.Uint16(ip[6:8])
+
+		// fragmented packets are not supported
+		if ip[9] != 17 || (fragment&0x3fff) != 0 ||
+			headerLen < 20 || totalLen < headerLen || totalLen > len(ip) {
+			return nil
+		}
+		udp = ip[headerLen:totalLen]
+
+	case 6:
+		if len(ip) < 40 {
+			return nil
+		}
+
+		payloadLen := int(binary.BigEndian.Uint16(ip[4:6]))
+
+		// extension headers are not supported
+		if ip[6] != 17 || 40+payloadLen > len(ip) {
+			return nil
+		}
+		udp = ip[40 : 40+payloadLen]
+
+	default:
+		return nil
+	}
+
+	if len(udp) < 8 {
+		return nil
+	}
+
+	udpLen := int(binary.BigEndian.Uint16(udp[4:6]))
+	if udpLen < 8 || udpLen > len(udp) {
+		return nil
+	}
+
+	return udp[8:udpLen]
+}
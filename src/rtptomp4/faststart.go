@@ -0,0 +1,225 @@
+package rtptomp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type faststartBox struct {
+	typ    string
+	offset int64
+	size   int64
+}
+
+func faststartReadBoxes(r io.ReadSeeker) ([]faststartBox, error) {
+	var boxes []faststartBox
+	offset := int64(0)
+	buf := make([]byte, 16)
+
+	for {
+		_, err := r.Seek(offset, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = io.ReadFull(r, buf[:8])
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return boxes, nil
+			}
+			return nil, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(buf[:4]))
+		typ := string(buf[4:8])
+
+		switch size {
+		case 0: // box extends to the end of the file
+			var end int64
+			end, err = r.Seek(0, io.SeekEnd)
+			if err != nil {
+				return nil, err
+			}
+			size = end - offset
+
+		case 1: // 64-bit size
+			_, err = io.ReadFull(r, buf[8:16])
+			if err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(buf[8:16]))
+		}
+
+		if size < 8 {
+			return nil, fmt.Errorf("invalid size of box '%s'", typ)
+		}
+
+		boxes = append(boxes, faststartBox{
+			typ:    typ,
+			offset: offset,
+			size:   size,
+		})
+		offset += size
+	}
+}
+
+// faststartShiftOffsets adds delta to the chunk offsets of a moov box
+// that point to the range [start, end).
+func faststartShiftOffsets(buf []byte, start int64, end int64, delta int64) error {
+	for len(buf) != 0 {
+		if len(buf) < 8 {
+			return fmt.Errorf("invalid box")
+		}
+
+		size := int(binary.BigEndian.Uint32(buf[:4]))
+		typ := string(buf[4:8])
+
+		if size < 8 || size > len(buf) {
+			return fmt.Errorf("invalid size of box '%s'", typ)
+		}
+
+		content := buf[8:size]
+
+		switch typ {
+		case "moov", "trak", "mdia", "minf", "stbl":
+			err := faststartShiftOffsets(content, start, end, delta)
+			if err != nil {
+				return err
+			}
+
+		case "stco":
+			if len(content) < 8 {
+				return fmt.Errorf("invalid stco box")
+			}
+			count := int(binary.BigEndian.Uint32(content[4:8]))
+			if len(content) < 8+count*4 {
+				return fmt.Errorf("invalid stco box")
+			}
+
+			for i := 0; i < count; i++ {
+				entry := content[8+i*4:]
+				v := int64(binary.BigEndian.Uint32(entry))
+				if v < start || v >= end {
+					continue
+				}
+				v += delta
+				if v < 0 || v > 0xFFFFFFFF {
+					return fmt.Errorf("chunk offset overflows stco box")
+				}
+				binary.BigEndian.PutUint32(entry, uint32(v))
+			}
+
+		case "co64":
+			if len(content) < 8 {
+				return fmt.Errorf("invalid co64 box")
+			}
+			count := int(binary.BigEndian.Uint32(content[4:8]))
+			if len(content) < 8+count*8 {
+				return fmt.Errorf("invalid co64 box")
+			}
+
+			for i := 0; i < count; i++ {
+				entry := content[8+i*8:]
+				v := int64(binary.BigEndian.Uint64(entry))
+				if v < start || v >= end {
+					continue
+				}
+				binary.BigEndian.PutUint64(entry, uint64(v+delta))
+			}
+		}
+
+		buf = buf[size:]
+	}
+
+	return nil
+}
+
+// FastStart copies a MP4 file from r to w, moving the moov box before the mdat box.
+// This allows players to start the playback before the whole file has been downloaded.
+// Files that already have the moov box before the mdat box are copied as they are.
+// Files produced by MP4Writer are always in this form.
+func FastStart(r io.ReadSeeker, w io.Writer) error {
+	boxes, err := faststartReadBoxes(r)
+	if err != nil {
+		return err
+	}
+
+	moovPos := -1
+	mdatPos := -1
+
+	for i, box := range boxes {
+		switch box.typ {
+		case "moov":
+			if moovPos < 0 {
+				moovPos = i
+			}
+
+		case "mdat":
+			if mdatPos < 0 {
+				mdatPos = i
+			}
+		}
+	}
+
+	if moovPos < 0 {
+		return fmt.Errorf("moov box not found")
+	}
+
+	moov := make([]byte, boxes[moovPos].size)
+	_, err = r.Seek(boxes[moovPos].offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(r, moov)
+	if err != nil {
+		return err
+	}
+
+	order := make([]int, 0, len(boxes))
+
+	if mdatPos >= 0 && mdatPos < moovPos {
+		// boxes between the mdat box and the moov box are moved forward
+		err = faststartShiftOffsets(moov, boxes[mdatPos].offset, boxes[moovPos].offset, int64(len(moov)))
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < mdatPos; i++ {
+			order = append(order, i)
+		}
+		order = append(order, moovPos)
+		for i := mdatPos; i < len(boxes); i++ {
+			if i != moovPos {
+				order = append(order, i)
+			}
+		}
+	} else {
+		for i := range boxes {
+			order = append(order, i)
+		}
+	}
+
+	for _, i := range order {
+		if i == moovPos {
+			_, err = w.Write(moov)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, err = r.Seek(boxes[i].offset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.CopyN(w, r, boxes[i].size)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
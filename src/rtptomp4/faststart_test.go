@@ -0,0 +1,81 @@
+package rtptomp4
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtspformat "github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/test"
+)
+
+func TestFastStart(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.mp4")
+
+	forma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	w, err := NewMP4Writer(fpath, forma)
+	require.NoError(t, err)
+
+	enc, err := forma.CreateEncoder()
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		pkts, err2 := enc.Encode([][]byte{
+			test.FormatH264.SPS,
+			test.FormatH264.PPS,
+			{5, 1}, // IDR
+		})
+		require.NoError(t, err2)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(i * 3000)
+			err2 = w.WriteRTP(forma, pkt)
+			require.NoError(t, err2)
+		}
+	}
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	faststart, err := os.ReadFile(fpath)
+	require.NoError(t, err)
+
+	// build a file with the moov box after the mdat box
+
+	boxes, err := faststartReadBoxes(bytes.NewReader(faststart))
+	require.NoError(t, err)
+	require.Equal(t, "ftyp", boxes[0].typ)
+	require.Equal(t, "moov", boxes[1].typ)
+	require.Equal(t, "mdat", boxes[2].typ)
+
+	moov := append([]byte(nil), faststart[boxes[1].offset:boxes[1].offset+boxes[1].size]...)
+	err = faststartShiftOffsets(moov, boxes[2].offset, boxes[2].offset+boxes[2].size, -boxes[1].size)
+	require.NoError(t, err)
+
+	var slowstart []byte
+	slowstart = append(slowstart, faststart[:boxes[1].offset]...)
+	slowstart = append(slowstart, faststart[boxes[2].offset:]...)
+	slowstart = append(slowstart, moov...)
+
+	var buf bytes.Buffer
+	err = FastStart(bytes.NewReader(slowstart), &buf)
+	require.NoError(t, err)
+	require.Equal(t, faststart, buf.Bytes())
+
+	// files that are already in the right order are left untouched
+	buf.Reset()
+	err = FastStart(bytes.NewReader(faststart), &buf)
+	require.NoError(t, err)
+	require.Equal(t, faststart, buf.Bytes())
+}
@@ -0,0 +1,551 @@
+package rtptomp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	// seconds between 1904-01-01 (MP4 epoch) and 1970-01-01 (Unix epoch)
+	mp4EpochOffset = 2082844800
+
+	// seconds between 1900-01-01 (NTP epoch) and 1970-01-01 (Unix epoch)
+	ntpEpochOffset = 2208988800
+)
+
+func timeToMP4(t time.Time) uint64 {
+	return uint64(t.Unix() + mp4EpochOffset)
+}
+
+func timeToNTP(t time.Time) uint64 {
+	s := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) * (1 << 32) / uint64(time.Second)
+	return s<<32 | frac
+}
+
+func ntpToTime(v uint64) time.Time {
+	s := int64(v>>32) - ntpEpochOffset
+	nano := int64((v & 0xFFFFFFFF) * uint64(time.Second) >> 32)
+	return time.Unix(s, nano)
+}
+
+// metadataSetTimes fills creation and modification times of mvhd, tkhd and mdhd boxes.
+func metadataSetTimes(buf []byte, t uint64) error {
+	for len(buf) != 0 {
+		if len(buf) < 8 {
+			return fmt.Errorf("invalid box")
+		}
+
+		size := int(binary.BigEndian.Uint32(buf[:4]))
+		typ := string(buf[4:8])
+
+		if size < 8 || size > len(buf) {
+			return fmt.Errorf("invalid size of box '%s'", typ)
+		}
+
+		content := buf[8:size]
+
+		switch typ {
+		case "moov", "trak", "mdia":
+			err := metadataSetTimes(content, t)
+			if err != nil {
+				return err
+			}
+
+		case "mvhd", "tkhd", "mdhd":
+			if len(content) < 20 {
+				return fmt.Errorf("invalid %s box", typ)
+			}
+
+			if content[0] == 1 {
+				binary.BigEndian.PutUint64(content[4:12], t)
+				binary.BigEndian.PutUint64(content[12:20], t)
+			} else {
+				if t > math.MaxUint32 {
+					return fmt.Errorf("time can't be represented by %s box", typ)
+				}
+				binary.BigEndian.PutUint32(content[4:8], uint32(t))
+				binary.BigEndian.PutUint32(content[8:12], uint32(t))
+			}
+		}
+
+		buf = buf[size:]
+	}
+
+	return nil
+}
+
+// chapter is a named marker.
+type chapter struct {
+	title string
+	pts   time.Duration
+}
+
+// metadataMarshalChpl returns a chpl box (Nero chapters), that is supported
+// by FFmpeg, VLC, MPV and most players.
+func metadataMarshalChpl(chapters []chapter) []byte {
+	size := 8 + 4 + 4 + 1
+	for _, ch := range chapters {
+		size += 8 + 1 + len(ch.title)
+	}
+
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(size))
+	copy(buf[4:8], "chpl")
+	buf[8] = 1 // version
+	buf[16] = byte(len(chapters))
+	n := 17
+
+	for _, ch := range chapters {
+		// start time is expressed in units of 100ns
+		binary.BigEndian.PutUint64(buf[n:], uint64(ch.pts/100))
+		buf[n+8] = byte(len(ch.title))
+		copy(buf[n+9:], ch.title)
+		n += 8 + 1 + len(ch.title)
+	}
+
+	return buf
+}
+
+// metadataMarshalUdta returns an udta box that contains the recording date,
+// in the same form used by QuickTime and FFmpeg, and chapters.
+func metadataMarshalUdta(t time.Time, chapters []chapter) []byte {
+	date := []byte(t.UTC().Format("2006-01-02T15:04:05.000000Z"))
+
+	day := make([]byte, 8+4+len(date))
+	binary.BigEndian.PutUint32(day[0:4], uint32(len(day)))
+	copy(day[4:8], "\xa9day")
+	binary.BigEndian.PutUint16(day[8:10], uint16(len(date)))
+	binary.BigEndian.PutUint16(day[10:12], 0x55c4) // language: und
+	copy(day[12:], date)
+
+	content := day
+	if len(chapters) != 0 {
+		content = append(content, metadataMarshalChpl(chapters)...)
+	}
+
+	udta := make([]byte, 8+len(content))
+	binary.BigEndian.PutUint32(udta[0:4], uint32(len(udta)))
+	copy(udta[4:8], "udta")
+	copy(udta[8:], content)
+
+	return udta
+}
+
+// trackMetadata contains optional properties of a track.
+type trackMetadata struct {
+	// ISO 639-2/T language code.
+	language string
+
+	// name of the handler.
+	name string
+
+	// clockwise rotation, in degrees.
+	rotation int
+
+	// sample entry that replaces the one generated by the MP4 library,
+	// for codecs that are not supported by the library.
+	sampleEntry []byte
+}
+
+func (m *trackMetadata) isEmpty() bool {
+	return m.language == "" && m.name == "" && m.rotation == 0 && m.sampleEntry == nil
+}
+
+func metadataMarshalBox(typ string, content []byte) []byte {
+	buf := make([]byte, 8+len(content))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(buf)))
+	copy(buf[4:8], typ)
+	copy(buf[8:], content)
+	return buf
+}
+
+// metadataMapBoxes calls cb for each box in buf and
+// returns the concatenation of the boxes returned by cb.
+func metadataMapBoxes(buf []byte, cb func(typ string, box []byte) ([]byte, error)) ([]byte, error) {
+	ret := make([]byte, 0, len(buf))
+
+	for len(buf) != 0 {
+		if len(buf) < 8 {
+			return nil, fmt.Errorf("invalid box")
+		}
+
+		size := int(binary.BigEndian.Uint32(buf[:4]))
+		typ := string(buf[4:8])
+
+		if size < 8 || size > len(buf) {
+			return nil, fmt.Errorf("invalid size of box '%s'", typ)
+		}
+
+		box, err := cb(typ, buf[:size])
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, box...)
+		buf = buf[size:]
+	}
+
+	return ret, nil
+}
+
+// metadataTrakID returns the ID of a track from the content of a trak box.
+func metadataTrakID(trak []byte) (int, error) {
+	id := -1
+
+	_, err := metadataMapBoxes(trak, func(typ string, box []byte) ([]byte, error) {
+		if typ == "tkhd" {
+			content := box[8:]
+
+			if len(content) < 16 || (content[0] == 1 && len(content) < 24) {
+				return nil, fmt.Errorf("invalid tkhd box")
+			}
+
+			if content[0] == 1 {
+				id = int(binary.BigEndian.Uint32(content[20:24]))
+			} else {
+				id = int(binary.BigEndian.Uint32(content[12:16]))
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if id < 0 {
+		return 0, fmt.Errorf("tkhd box not found")
+	}
+
+	return id, nil
+}
+
+// metadataSetMatrix sets the transformation matrix of a tkhd box,
+// in the same form used by FFmpeg.
+// Specification: ISO 14496-12, section 8.3.2
+func metadataSetMatrix(content []byte, rotation int) error {
+	matrixOffset := 40
+	if len(content) != 0 && content[0] == 1 {
+		matrixOffset = 52
+	}
+
+	if len(content) < matrixOffset+36+8 {
+		return fmt.Errorf("invalid tkhd box")
+	}
+
+	// width and height are in 16.16 fixed-point format, like translations
+	width := binary.BigEndian.Uint32(content[matrixOffset+36:])
+	height := binary.BigEndian.Uint32(content[matrixOffset+40:])
+
+	const one = 0x10000
+
+	var a, b, c, d int32
+	var tx, ty uint32
+
+	switch rotation {
+	case 90:
+		b, c = one, -one
+		tx = height
+
+	case 180:
+		a, d = -one, -one
+		tx, ty = width, height
+
+	case 270:
+		b, c = -one, one
+		ty = width
+
+	default:
+		a, d = one, one
+	}
+
+	matrix := content[matrixOffset : matrixOffset+36]
+	binary.BigEndian.PutUint32(matrix[0:4], uint32(a))
+	binary.BigEndian.PutUint32(matrix[4:8], uint32(b))
+	binary.BigEndian.PutUint32(matrix[8:12], 0)
+	binary.BigEndian.PutUint32(matrix[12:16], uint32(c))
+	binary.BigEndian.PutUint32(matrix[16:20], uint32(d))
+	binary.BigEndian.PutUint32(matrix[20:24], 0)
+	binary.BigEndian.PutUint32(matrix[24:28], tx)
+	binary.BigEndian.PutUint32(matrix[28:32], ty)
+	binary.BigEndian.PutUint32(matrix[32:36], 0x40000000)
+
+	return nil
+}
+
+// metadataSetLanguage sets the language of a mdhd box.
+func metadataSetLanguage(content []byte, language string) error {
+	offset := 20
+	if len(content) != 0 && content[0] == 1 {
+		offset = 32
+	}
+
+	if len(content) < offset+2 {
+		return fmt.Errorf("invalid mdhd box")
+	}
+
+	// each character is stored in 5 bits, as difference with 0x60
+	v := uint16(language[0]-0x60)<<10 | uint16(language[1]-0x60)<<5 | uint16(language[2]-0x60)
+	binary.BigEndian.PutUint16(content[offset:offset+2], v)
+
+	return nil
+}
+
+// metadataSetHandlerName returns a copy of a hdlr box with the given name.
+func metadataSetHandlerName(box []byte, name string) ([]byte, error) {
+	content := box[8:]
+
+	if len(content) < 24 {
+		return nil, fmt.Errorf("invalid hdlr box")
+	}
+
+	newContent := make([]byte, 24+len(name)+1)
+	copy(newContent, content[:24])
+	copy(newContent[24:], name)
+
+	return metadataMarshalBox("hdlr", newContent), nil
+}
+
+// metadataReplaceSampleEntry returns a copy of a minf box in which
+// the first sample entry is replaced by the given one.
+func metadataReplaceSampleEntry(minf []byte, entry []byte) ([]byte, error) {
+	content, err := metadataMapBoxes(minf[8:], func(typ string, box []byte) ([]byte, error) {
+		if typ != "stbl" {
+			return box, nil
+		}
+
+		content, err := metadataMapBoxes(box[8:], func(typ string, box []byte) ([]byte, error) {
+			if typ != "stsd" {
+				return box, nil
+			}
+
+			// version, flags and entry_count
+			if len(box) < 8+4+4+8 {
+				return nil, fmt.Errorf("invalid stsd box")
+			}
+
+			entries := box[8+4+4:]
+			size := int(binary.BigEndian.Uint32(entries[:4]))
+			if size < 8 || size > len(entries) {
+				return nil, fmt.Errorf("invalid sample entry")
+			}
+
+			newContent := make([]byte, 0, len(box)-8-size+len(entry))
+			newContent = append(newContent, box[8:8+4+4]...)
+			newContent = append(newContent, entry...)
+			newContent = append(newContent, entries[size:]...)
+
+			return metadataMarshalBox("stsd", newContent), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return metadataMarshalBox("stbl", content), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return metadataMarshalBox("minf", content), nil
+}
+
+// metadataPatchTrak returns a copy of a trak box that contains the given metadata.
+func metadataPatchTrak(trak []byte, meta *trackMetadata) ([]byte, error) {
+	content, err := metadataMapBoxes(trak[8:], func(typ string, box []byte) ([]byte, error) {
+		switch typ {
+		case "tkhd":
+			if meta.rotation == 0 {
+				return box, nil
+			}
+
+			box = append([]byte(nil), box...)
+			err := metadataSetMatrix(box[8:], meta.rotation)
+			return box, err
+
+		case "mdia":
+			content, err := metadataMapBoxes(box[8:], func(typ string, box []byte) ([]byte, error) {
+				switch {
+				case typ == "mdhd" && meta.language != "":
+					box = append([]byte(nil), box...)
+					err := metadataSetLanguage(box[8:], meta.language)
+					return box, err
+
+				case typ == "hdlr" && meta.name != "":
+					return metadataSetHandlerName(box, meta.name)
+
+				case typ == "minf" && meta.sampleEntry != nil:
+					return metadataReplaceSampleEntry(box, meta.sampleEntry)
+				}
+				return box, nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			return metadataMarshalBox("mdia", content), nil
+		}
+		return box, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return metadataMarshalBox("trak", content), nil
+}
+
+// metadataPatchTracks returns a copy of a moov box in which tracks contain the given metadata.
+func metadataPatchTracks(moov []byte, tracks map[int]*trackMetadata) ([]byte, error) {
+	content, err := metadataMapBoxes(moov[8:], func(typ string, box []byte) ([]byte, error) {
+		if typ != "trak" {
+			return box, nil
+		}
+
+		id, err := metadataTrakID(box[8:])
+		if err != nil {
+			return nil, err
+		}
+
+		meta, ok := tracks[id]
+		if !ok || meta.isEmpty() {
+			return box, nil
+		}
+
+		return metadataPatchTrak(box, meta)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return metadataMarshalBox("moov", content), nil
+}
+
+// metadataFillMoov returns a copy of a moov box that contains the start time, chapters
+// and metadata of tracks.
+// When the start time is zero, the start time and chapters are not written.
+func metadataFillMoov(
+	moov []byte,
+	startTime time.Time,
+	chapters []chapter,
+	tracks map[int]*trackMetadata,
+) ([]byte, error) {
+	if len(tracks) != 0 {
+		var err error
+		moov, err = metadataPatchTracks(moov, tracks)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if startTime.IsZero() {
+		return moov, nil
+	}
+
+	udta := metadataMarshalUdta(startTime, chapters)
+
+	ret := make([]byte, len(moov)+len(udta))
+	copy(ret, moov)
+	copy(ret[len(moov):], udta)
+	binary.BigEndian.PutUint32(ret[0:4], uint32(len(ret)))
+
+	err := metadataSetTimes(ret, timeToMP4(startTime))
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// metadataWriter is a io.Writer that fills the moov box written through it
+// with the start time, chapters and metadata of tracks.
+// The moov box must be in front of the mdat box. Chunk offsets are
+// updated to take into account the changed size of the moov box.
+type metadataWriter struct {
+	w         io.Writer
+	startTime time.Time
+	chapters  []chapter
+	tracks    map[int]*trackMetadata
+
+	buf  []byte
+	done bool
+}
+
+func (w *metadataWriter) Write(p []byte) (int, error) {
+	if w.done {
+		return w.w.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+
+	boxes, err := faststartReadBoxes(bytes.NewReader(w.buf))
+	if err != nil {
+		// boxes are not complete yet
+		return len(p), nil //nolint:nilerr
+	}
+
+	for _, box := range boxes {
+		if box.typ == "moov" && box.offset+box.size <= int64(len(w.buf)) {
+			err = w.fill(box)
+			if err != nil {
+				return 0, err
+			}
+			break
+		}
+	}
+
+	return len(p), nil
+}
+
+func (w *metadataWriter) fill(moovBox faststartBox) error {
+	moovEnd := moovBox.offset + moovBox.size
+
+	moov, err := metadataFillMoov(w.buf[moovBox.offset:moovEnd], w.startTime, w.chapters, w.tracks)
+	if err != nil {
+		return err
+	}
+
+	err = faststartShiftOffsets(moov, moovEnd, math.MaxInt64, int64(len(moov))-moovBox.size)
+	if err != nil {
+		return err
+	}
+
+	w.done = true
+
+	for _, byts := range [][]byte{w.buf[:moovBox.offset], moov, w.buf[moovEnd:]} {
+		_, err = w.w.Write(byts)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.buf = nil
+	return nil
+}
+
+// flush writes buffered data when no moov box has been found.
+func (w *metadataWriter) flush() error {
+	if w.done {
+		return nil
+	}
+
+	w.done = true
+
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// metadataMarshalPrft returns a prft box, that associates the media time
+// of a track with an absolute time.
+// Specification: ISO 14496-12, section 8.16.5
+func metadataMarshalPrft(trackID int, ntp time.Time, mediaTime uint64) []byte {
+	buf := make([]byte, 32)
+	binary.BigEndian.PutUint32(buf[0:4], 32)
+	copy(buf[4:8], "prft")
+	buf[8] = 1 // version
+	binary.BigEndian.PutUint32(buf[12:16], uint32(trackID))
+	binary.BigEndian.PutUint64(buf[16:24], timeToNTP(ntp))
+	binary.BigEndian.PutUint64(buf[24:32], mediaTime)
+	return buf
+}
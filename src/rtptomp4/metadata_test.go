@@ -0,0 +1,416 @@
+package rtptomp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abema/go-mp4"
+	rtspformat "github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/test"
+)
+
+func writeTestH264(t *testing.T, w *MP4Writer, forma *rtspformat.H264, count int) {
+	enc, err := forma.CreateEncoder()
+	require.NoError(t, err)
+
+	for i := 0; i < count; i++ {
+		pkts, err := enc.Encode([][]byte{
+			test.FormatH264.SPS,
+			test.FormatH264.PPS,
+			{5, 1}, // IDR
+		})
+		require.NoError(t, err)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(i * 90000)
+			err = w.WriteRTP(forma, pkt)
+			require.NoError(t, err)
+		}
+	}
+}
+
+func TestMP4WriterStartTime(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.mp4")
+
+	forma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	w, err := NewMP4Writer(fpath, forma)
+	require.NoError(t, err)
+
+	startTime := time.Date(2010, 11, 12, 13, 14, 15, 0, time.UTC)
+	w.SetStartTime(startTime)
+
+	writeTestH264(t, w, forma, 3)
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	f, err := os.Open(fpath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	boxes, err := mp4.ExtractBoxWithPayload(f, nil, mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeMvhd()})
+	require.NoError(t, err)
+	require.Equal(t, uint32(startTime.Unix()+mp4EpochOffset), boxes[0].Payload.(*mp4.Mvhd).CreationTimeV0)
+
+	boxes, err = mp4.ExtractBoxWithPayload(f, nil,
+		mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMdhd()})
+	require.NoError(t, err)
+	require.Equal(t, uint32(startTime.Unix()+mp4EpochOffset), boxes[0].Payload.(*mp4.Mdhd).CreationTimeV0)
+
+	// chunk offsets must point to the samples, after the enlarged moov box
+	boxes, err = mp4.ExtractBoxWithPayload(f, nil, mp4.BoxPath{
+		mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(),
+		mp4.BoxTypeMinf(), mp4.BoxTypeStbl(), mp4.BoxTypeStco(),
+	})
+	require.NoError(t, err)
+
+	byts, err := os.ReadFile(fpath)
+	require.NoError(t, err)
+	require.True(t, bytes.Contains(byts, []byte("\xa9day\x00\x1b\x55\xc42010-11-12T13:14:15.000000Z")))
+
+	offset := boxes[0].Payload.(*mp4.Stco).ChunkOffset[0]
+	require.Equal(t, []byte{0, 0, 0, 0x19, 0x67}, byts[offset:offset+5]) // SPS
+}
+
+func TestMP4WriterFragmentedStartTime(t *testing.T) {
+	var buf bytes.Buffer
+
+	forma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	w, err := NewFragmentedMP4Writer(&buf, time.Second, forma)
+	require.NoError(t, err)
+
+	startTime := time.Date(2010, 11, 12, 13, 14, 15, 0, time.UTC)
+	w.SetStartTime(startTime)
+
+	writeTestH264(t, w, forma, 3)
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	boxes, err := mp4.ExtractBoxWithPayload(bytes.NewReader(buf.Bytes()), nil,
+		mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeMvhd()})
+	require.NoError(t, err)
+	require.Equal(t, uint32(startTime.Unix()+mp4EpochOffset), boxes[0].Payload.(*mp4.Mvhd).CreationTimeV0)
+
+	topBoxes, err := faststartReadBoxes(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	i := 0
+	for _, box := range topBoxes {
+		if box.typ != "prft" {
+			continue
+		}
+
+		prft := buf.Bytes()[box.offset : box.offset+box.size]
+		require.Equal(t, uint64(i*90000), binary.BigEndian.Uint64(prft[24:32]))
+		require.Equal(t, timeToNTP(startTime.Add(time.Duration(i)*time.Second)), binary.BigEndian.Uint64(prft[16:24]))
+		i++
+	}
+	require.Equal(t, 3, i)
+}
+
+func TestMP4WriterChapters(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.mp4")
+
+	forma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	w, err := NewMP4Writer(fpath, forma)
+	require.NoError(t, err)
+
+	err = w.AddChapter("second", 2*time.Second)
+	require.NoError(t, err)
+
+	err = w.AddChapter("first", 0)
+	require.NoError(t, err)
+
+	writeTestH264(t, w, forma, 3)
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	byts, err := os.ReadFile(fpath)
+	require.NoError(t, err)
+
+	i := bytes.Index(byts, []byte("chpl"))
+	require.NotEqual(t, -1, i)
+
+	require.Equal(t, []byte{
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x02,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x05, 'f', 'i', 'r', 's', 't',
+		0x00, 0x00, 0x00, 0x00, 0x01, 0x31, 0x2d, 0x00,
+		0x06, 's', 'e', 'c', 'o', 'n', 'd',
+	}, byts[i+4:i+4+38])
+
+	var buf bytes.Buffer
+	w, err = NewFragmentedMP4Writer(&buf, time.Second, forma)
+	require.NoError(t, err)
+	defer w.Close()
+
+	err = w.AddChapter("first", 0)
+	require.EqualError(t, err, "chapters are supported by finalized MP4 files only")
+}
+
+func checkTrackMetadata(t *testing.T, r io.ReadSeeker) {
+	boxes, err := mp4.ExtractBoxWithPayload(r, nil,
+		mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeTkhd()})
+	require.NoError(t, err)
+	require.Len(t, boxes, 2)
+
+	tkhd := boxes[0].Payload.(*mp4.Tkhd)
+	require.Equal(t, [9]int32{
+		0, 0x10000, 0,
+		-0x10000, 0, 0,
+		int32(tkhd.Height), 0, 0x40000000,
+	}, tkhd.Matrix)
+
+	boxes, err = mp4.ExtractBoxWithPayload(r, nil,
+		mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMdhd()})
+	require.NoError(t, err)
+	require.Len(t, boxes, 2)
+	require.Equal(t, [3]byte{'i' - 0x60, 't' - 0x60, 'a' - 0x60}, boxes[1].Payload.(*mp4.Mdhd).Language)
+
+	boxes, err = mp4.ExtractBoxWithPayload(r, nil,
+		mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeHdlr()})
+	require.NoError(t, err)
+	require.Len(t, boxes, 2)
+	require.Equal(t, "Commentary", boxes[1].Payload.(*mp4.Hdlr).Name)
+}
+
+func TestMP4WriterTrackMetadata(t *testing.T) {
+	videoForma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	audioForma := &rtspformat.G711{
+		PayloadTyp:   8,
+		MULaw:        false,
+		SampleRate:   8000,
+		ChannelCount: 1,
+	}
+
+	setMetadata := func(w *MP4Writer) {
+		err := w.SetTrackRotation(videoForma, 90)
+		require.NoError(t, err)
+
+		err = w.SetTrackLanguage(audioForma, "ita")
+		require.NoError(t, err)
+
+		err = w.SetTrackName(audioForma, "Commentary")
+		require.NoError(t, err)
+	}
+
+	writeAudio := func(w *MP4Writer) {
+		for i := 0; i < 3; i++ {
+			err := w.WriteRTP(audioForma, &rtp.Packet{
+				Header: rtp.Header{
+					Version:        2,
+					PayloadType:    8,
+					SequenceNumber: uint16(i),
+					Timestamp:      uint32(i * 8000),
+					SSRC:           1234,
+				},
+				Payload: []byte{1, 2, 3, 4},
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	t.Run("mp4", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		fpath := filepath.Join(dir, "out.mp4")
+
+		w, err := NewMP4Writer(fpath, videoForma, audioForma)
+		require.NoError(t, err)
+
+		setMetadata(w)
+		writeTestH264(t, w, videoForma, 3)
+		writeAudio(w)
+
+		err = w.Close()
+		require.NoError(t, err)
+
+		f, err := os.Open(fpath)
+		require.NoError(t, err)
+		defer f.Close()
+
+		checkTrackMetadata(t, f)
+
+		// chunk offsets must point to the samples, after the enlarged moov box
+		boxes, err := mp4.ExtractBoxWithPayload(f, nil, mp4.BoxPath{
+			mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(),
+			mp4.BoxTypeMinf(), mp4.BoxTypeStbl(), mp4.BoxTypeStco(),
+		})
+		require.NoError(t, err)
+
+		byts, err := os.ReadFile(fpath)
+		require.NoError(t, err)
+
+		offset := boxes[0].Payload.(*mp4.Stco).ChunkOffset[0]
+		require.Equal(t, []byte{0, 0, 0, 0x19, 0x67}, byts[offset:offset+5]) // SPS
+	})
+
+	t.Run("fmp4", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		w, err := NewFragmentedMP4Writer(&buf, 10*time.Second, videoForma, audioForma)
+		require.NoError(t, err)
+
+		setMetadata(w)
+		writeTestH264(t, w, videoForma, 3)
+		writeAudio(w)
+
+		err = w.Close()
+		require.NoError(t, err)
+
+		err = w.SetTrackName(audioForma, "Other")
+		require.EqualError(t, err, "metadata can't be changed after the init segment has been written")
+
+		checkTrackMetadata(t, bytes.NewReader(buf.Bytes()))
+	})
+}
+
+func TestMP4WriterTrackMetadataErrors(t *testing.T) {
+	videoForma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	audioForma := &rtspformat.Opus{
+		PayloadTyp:   97,
+		ChannelCount: 2,
+	}
+
+	var buf bytes.Buffer
+	w, err := NewFragmentedMP4Writer(&buf, time.Second, videoForma, audioForma)
+	require.NoError(t, err)
+	defer w.Close()
+
+	err = w.SetTrackLanguage(videoForma, "english")
+	require.EqualError(t, err, "invalid language code: english")
+
+	err = w.SetTrackLanguage(videoForma, "EN1")
+	require.EqualError(t, err, "invalid language code: EN1")
+
+	err = w.SetTrackName(videoForma, "a\x00b")
+	require.EqualError(t, err, "track name can't contain null characters")
+
+	err = w.SetTrackRotation(videoForma, 45)
+	require.EqualError(t, err, "unsupported rotation: 45")
+
+	err = w.SetTrackRotation(audioForma, 90)
+	require.EqualError(t, err, "rotation can be applied to video tracks only")
+
+	err = w.SetTrackRotation(&rtspformat.VP8{PayloadTyp: 98}, 90)
+	require.EqualError(t, err, "track not found")
+}
+
+func TestMP4WriterSenderReports(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.mp4")
+
+	videoForma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	audioForma := &rtspformat.Opus{
+		PayloadTyp:   97,
+		ChannelCount: 2,
+	}
+
+	w, err := NewMP4Writer(fpath, videoForma, audioForma)
+	require.NoError(t, err)
+
+	ntp := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	err = w.WriteRTCP(videoForma, &rtcp.SenderReport{
+		NTPTime: timeToNTP(ntp),
+		RTPTime: 0,
+	})
+	require.NoError(t, err)
+
+	// audio starts 1 second after video
+	err = w.WriteRTCP(audioForma, &rtcp.SenderReport{
+		NTPTime: timeToNTP(ntp.Add(1 * time.Second)),
+		RTPTime: 0,
+	})
+	require.NoError(t, err)
+
+	writeTestH264(t, w, videoForma, 3)
+
+	enc, err := audioForma.CreateEncoder()
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		pkt, err2 := enc.Encode([]byte{0xfc, 1, 2, 3}) // 20ms, stereo
+		require.NoError(t, err2)
+
+		pkt.Timestamp = uint32(i * 960)
+		err2 = w.WriteRTP(audioForma, pkt)
+		require.NoError(t, err2)
+	}
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	f, err := os.Open(fpath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	boxes, err := mp4.ExtractBoxWithPayload(f, nil, mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeMvhd()})
+	require.NoError(t, err)
+	require.Equal(t, uint32(timeToMP4(ntp)), boxes[0].Payload.(*mp4.Mvhd).CreationTimeV0)
+
+	boxes, err = mp4.ExtractBoxWithPayload(f, nil, mp4.BoxPath{
+		mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeEdts(), mp4.BoxTypeElst(),
+	})
+	require.NoError(t, err)
+	require.Len(t, boxes, 2)
+
+	elst := boxes[1].Payload.(*mp4.Elst)
+	require.Len(t, elst.Entries, 2)
+	require.Equal(t, int32(-1), elst.Entries[0].MediaTimeV0)
+	require.Equal(t, uint32(1000), elst.Entries[0].SegmentDurationV0)
+}
+
+func TestNTPToTime(t *testing.T) {
+	v := time.Date(2010, 1, 1, 0, 0, 1, 500000000, time.UTC)
+	require.True(t, v.Equal(ntpToTime(timeToNTP(v))))
+}
@@ -0,0 +1,14 @@
+package rtptomp4
+
+import (
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+)
+
+// output is the container-dependent part of MP4Writer.
+type output interface {
+	addTrack(track *writerTrack) error
+	writeSample(track *writerTrack, dts int64, sampl *fmp4.PartSample) error
+	close(startTime time.Time) error
+}
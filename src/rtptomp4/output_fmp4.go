@@ -0,0 +1,265 @@
+package rtptomp4
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4/seekablebuffer"
+)
+
+func multiplyAndDivide2(v, m, d time.Duration) time.Duration {
+	secs := v / d
+	dec := v % d
+	return (secs*m + dec*m/d)
+}
+
+func timestampToDuration(t int64, clockRate int) time.Duration {
+	return multiplyAndDivide2(time.Duration(t), time.Second, time.Duration(clockRate))
+}
+
+func durationToTimestamp(d time.Duration, clockRate int) int64 {
+	return int64(multiplyAndDivide2(d, time.Duration(clockRate), time.Second))
+}
+
+type outputFMP4Track struct {
+	initTrack *fmp4.InitTrack
+	metadata  *trackMetadata
+
+	started      bool
+	inInit       bool
+	firstDTS     int64
+	nextSample   *fmp4.PartSample
+	nextDTS      int64
+	lastDuration uint32
+	partTrack    *fmp4.PartTrack
+	partDTS      int64
+}
+
+// outputFMP4 writes a fragmented MP4 progressively.
+// The init segment is written together with the first fragment and contains
+// the tracks that received at least one sample until then.
+// Samples of tracks that are not in the init segment are discarded.
+// BaseTime can't be negative, therefore timestamps of all tracks are shifted by
+// the same origin, that is the earliest first timestamp of tracks in the init segment,
+// in order to preserve the offset between tracks.
+type outputFMP4 struct {
+	w                io.Writer
+	fragmentDuration time.Duration
+
+	tracks             []*outputFMP4Track
+	byTrack            map[*writerTrack]*outputFMP4Track
+	initWritten        bool
+	fragmentStarted    bool
+	fragmentStart      time.Duration
+	nextSequenceNumber uint32
+	startTime          time.Time
+	origin             time.Duration
+}
+
+func (o *outputFMP4) initialize() {
+	o.byTrack = make(map[*writerTrack]*outputFMP4Track)
+	o.nextSequenceNumber = 1
+}
+
+func (o *outputFMP4) addTrack(track *writerTrack) error {
+	if o.initWritten {
+		return fmt.Errorf("tracks can't be added after the init segment has been written")
+	}
+
+	ot := &outputFMP4Track{
+		initTrack: &fmp4.InitTrack{
+			ID:        track.id,
+			TimeScale: uint32(track.format.ClockRate()),
+			Codec:     track.codec,
+		},
+		metadata: &track.metadata,
+	}
+	o.tracks = append(o.tracks, ot)
+	o.byTrack[track] = ot
+	return nil
+}
+
+func (o *outputFMP4) writeSample(track *writerTrack, dts int64, sampl *fmp4.PartSample) error {
+	ot := o.byTrack[track]
+	o.startTime = track.w.startTime
+
+	if o.initWritten && !ot.inInit {
+		return nil
+	}
+
+	if !ot.started {
+		ot.started = true
+		ot.firstDTS = dts
+	}
+
+	// the duration of a sample is known when the next one is received.
+	if ot.nextSample != nil {
+		diff := dts - ot.nextDTS
+		if diff < 0 {
+			diff = 0
+		}
+		ot.nextSample.Duration = uint32(diff)
+
+		err := o.appendSample(ot, ot.nextSample, ot.nextDTS)
+		if err != nil {
+			return err
+		}
+	}
+
+	ot.nextSample = sampl
+	ot.nextDTS = dts
+
+	return nil
+}
+
+func (o *outputFMP4) appendSample(ot *outputFMP4Track, sampl *fmp4.PartSample, dts int64) error {
+	if ot.partTrack == nil {
+		ot.partTrack = &fmp4.PartTrack{
+			ID: ot.initTrack.ID,
+		}
+		ot.partDTS = dts
+	}
+
+	ot.partTrack.Samples = append(ot.partTrack.Samples, sampl)
+	ot.lastDuration = sampl.Duration
+
+	clockRate := int(ot.initTrack.TimeScale)
+
+	if !o.fragmentStarted {
+		o.fragmentStarted = true
+		o.fragmentStart = timestampToDuration(dts, clockRate)
+	}
+
+	if (timestampToDuration(dts+int64(sampl.Duration), clockRate) - o.fragmentStart) >= o.fragmentDuration {
+		return o.flushFragment()
+	}
+
+	return nil
+}
+
+func (o *outputFMP4) flushFragment() error {
+	if !o.initWritten {
+		err := o.writeInit()
+		if err != nil {
+			return err
+		}
+	}
+
+	part := &fmp4.Part{
+		SequenceNumber: o.nextSequenceNumber,
+	}
+	o.nextSequenceNumber++
+
+	var first *outputFMP4Track
+
+	for _, ot := range o.tracks {
+		if ot.partTrack != nil {
+			baseTime := ot.partDTS - durationToTimestamp(o.origin, int(ot.initTrack.TimeScale))
+			if baseTime < 0 {
+				baseTime = 0
+			}
+			ot.partTrack.BaseTime = uint64(baseTime)
+
+			if first == nil {
+				first = ot
+			}
+
+			part.Tracks = append(part.Tracks, ot.partTrack)
+			ot.partTrack = nil
+		}
+	}
+
+	o.fragmentStarted = false
+
+	var buf seekablebuffer.Buffer
+
+	// associate the media time of the first track with the absolute time
+	if !o.startTime.IsZero() && first != nil {
+		ntp := o.startTime.Add(timestampToDuration(first.partDTS, int(first.initTrack.TimeScale)))
+		buf.Write(metadataMarshalPrft(first.initTrack.ID, ntp, part.Tracks[0].BaseTime))
+	}
+
+	err := part.Marshal(&buf)
+	if err != nil {
+		return err
+	}
+
+	_, err = o.w.Write(buf.Bytes())
+	return err
+}
+
+func (o *outputFMP4) writeInit() error {
+	init := &fmp4.Init{}
+	metadata := make(map[int]*trackMetadata)
+
+	originSet := false
+
+	for _, ot := range o.tracks {
+		if ot.started {
+			ot.inInit = true
+			init.Tracks = append(init.Tracks, ot.initTrack)
+			metadata[ot.initTrack.ID] = ot.metadata
+
+			first := timestampToDuration(ot.firstDTS, int(ot.initTrack.TimeScale))
+			if !originSet || first < o.origin {
+				o.origin = first
+				originSet = true
+			}
+		}
+	}
+
+	var buf seekablebuffer.Buffer
+	err := init.Marshal(&buf)
+	if err != nil {
+		return err
+	}
+
+	mw := &metadataWriter{
+		w:         o.w,
+		startTime: o.startTime,
+		tracks:    metadata,
+	}
+	_, err = mw.Write(buf.Bytes())
+	if err == nil {
+		err = mw.flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	o.initWritten = true
+	return nil
+}
+
+func (o *outputFMP4) close(_ time.Time) error {
+	pending := false
+
+	for _, ot := range o.tracks {
+		if ot.nextSample != nil {
+			// the duration of the last sample is unknown,
+			// use the duration of the previous one.
+			ot.nextSample.Duration = ot.lastDuration
+
+			if ot.partTrack == nil {
+				ot.partTrack = &fmp4.PartTrack{
+					ID: ot.initTrack.ID,
+				}
+				ot.partDTS = ot.nextDTS
+			}
+			ot.partTrack.Samples = append(ot.partTrack.Samples, ot.nextSample)
+			ot.nextSample = nil
+		}
+
+		if ot.partTrack != nil {
+			pending = true
+		}
+	}
+
+	if !pending {
+		return nil
+	}
+
+	return o.flushFragment()
+}
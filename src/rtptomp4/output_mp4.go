@@ -0,0 +1,162 @@
+package rtptomp4
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/pmp4"
+)
+
+type outputMP4Track struct {
+	pmp4.Track
+	metadata *trackMetadata
+	lastDTS  int64
+}
+
+// outputMP4 keeps samples in memory and writes a finalized MP4 on close.
+// The start time is stored into creation times and into a udta box,
+// while metadata of tracks is stored into tkhd, mdhd and hdlr boxes.
+// When the size of payloads exceeds memoryLimit, they are moved into a temporary file.
+type outputMP4 struct {
+	w           io.Writer
+	memoryLimit int64
+	chapters    []chapter
+
+	tracks     []*outputMP4Track
+	byTrack    map[*writerTrack]*outputMP4Track
+	memorySize int64
+	spillFile  *os.File
+	spillSize  int64
+}
+
+func (o *outputMP4) initialize() {
+	o.byTrack = make(map[*writerTrack]*outputMP4Track)
+}
+
+func (o *outputMP4) addTrack(track *writerTrack) error {
+	ot := &outputMP4Track{
+		Track: pmp4.Track{
+			ID:        track.id,
+			TimeScale: uint32(track.format.ClockRate()),
+			Codec:     track.codec,
+		},
+		metadata: &track.metadata,
+	}
+	o.tracks = append(o.tracks, ot)
+	o.byTrack[track] = ot
+	return nil
+}
+
+func (o *outputMP4) writeSample(track *writerTrack, dts int64, sampl *fmp4.PartSample) error {
+	ot := o.byTrack[track]
+
+	if ot.Samples == nil {
+		ot.TimeOffset = int32(dts)
+	} else {
+		diff := dts - ot.lastDTS
+		if diff < 0 {
+			diff = 0
+		}
+		ot.Samples[len(ot.Samples)-1].Duration = uint32(diff)
+	}
+
+	getPayload, err := o.storePayload(sampl.Payload)
+	if err != nil {
+		return err
+	}
+
+	ot.Samples = append(ot.Samples, &pmp4.Sample{
+		PTSOffset:       sampl.PTSOffset,
+		IsNonSyncSample: sampl.IsNonSyncSample,
+		PayloadSize:     uint32(len(sampl.Payload)),
+		GetPayload:      getPayload,
+	})
+	ot.lastDTS = dts
+
+	return nil
+}
+
+func (o *outputMP4) storePayload(payload []byte) (func() ([]byte, error), error) {
+	if o.memoryLimit <= 0 || (o.memorySize+int64(len(payload))) <= o.memoryLimit {
+		o.memorySize += int64(len(payload))
+
+		return func() ([]byte, error) {
+			return payload, nil
+		}, nil
+	}
+
+	if o.spillFile == nil {
+		var err error
+		o.spillFile, err = os.CreateTemp("", "mediamtx-rtptomp4")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary file: %w", err)
+		}
+	}
+
+	offset := o.spillSize
+
+	_, err := o.spillFile.WriteAt(payload, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	o.spillSize += int64(len(payload))
+	size := len(payload)
+
+	return func() ([]byte, error) {
+		buf := make([]byte, size)
+		_, err2 := o.spillFile.ReadAt(buf, offset)
+		if err2 != nil {
+			return nil, err2
+		}
+		return buf, nil
+	}, nil
+}
+
+func (o *outputMP4) removeSpillFile() {
+	if o.spillFile != nil {
+		o.spillFile.Close()
+		os.Remove(o.spillFile.Name())
+		o.spillFile = nil
+	}
+}
+
+func (o *outputMP4) close(startTime time.Time) error {
+	defer o.removeSpillFile()
+
+	presentation := pmp4.Presentation{}
+	metadata := make(map[int]*trackMetadata)
+
+	for _, ot := range o.tracks {
+		// tracks without samples produce invalid sample tables
+		if len(ot.Samples) == 0 {
+			continue
+		}
+
+		// the duration of the last sample is unknown,
+		// use the duration of the previous one.
+		if n := len(ot.Samples); n >= 2 {
+			ot.Samples[n-1].Duration = ot.Samples[n-2].Duration
+		}
+
+		presentation.Tracks = append(presentation.Tracks, &ot.Track)
+		metadata[ot.ID] = ot.metadata
+	}
+
+	mw := &metadataWriter{
+		w:         o.w,
+		startTime: startTime,
+		chapters:  o.chapters,
+		tracks:    metadata,
+	}
+
+	err := presentation.Marshal(mw)
+	if err != nil {
+		return err
+	}
+
+	return mw.flush()
+}
@@ -0,0 +1,124 @@
+package rtptomp4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// Remux reads RTP and RTCP packets from a pcap or rtpdump capture
+// and writes them into a finalized MP4 file.
+// Packets are associated with formats of the session description through
+// their payload type, while packets with unknown payload types are skipped,
+// therefore captures that contain multiple sessions must be filtered first.
+// Packets that can't be decoded are skipped too.
+// When ctx is canceled, the conversion is stopped and the MP4 file contains
+// the packets read until then. onProgress is optional.
+func Remux(
+	ctx context.Context,
+	capture io.Reader,
+	desc *description.Session,
+	outputPath string,
+	onProgress func(Progress),
+) error {
+	cr, err := newCaptureReader(capture)
+	if err != nil {
+		return err
+	}
+
+	w, err := NewMP4WriterFromSession(outputPath, desc)
+	if err != nil {
+		return err
+	}
+
+	w.SetContext(ctx)
+	w.SetOnProgress(onProgress)
+
+	err = remuxPackets(cr, w)
+	if err != nil {
+		w.Close() //nolint:errcheck
+		return err
+	}
+
+	return w.Close()
+}
+
+func remuxPackets(cr captureReader, w *MP4Writer) error {
+	for {
+		cpkt, err := cr.readPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read capture: %w", err)
+		}
+
+		if cpkt.isRTCP {
+			var pkts []rtcp.Packet
+			pkts, err = rtcp.Unmarshal(cpkt.payload)
+			if err != nil {
+				continue
+			}
+
+			for _, pkt := range pkts {
+				w.WriteRTCPPacket(pkt)
+			}
+			continue
+		}
+
+		var pkt rtp.Packet
+		err = pkt.Unmarshal(cpkt.payload)
+		if err != nil || pkt.Version != 2 {
+			continue
+		}
+
+		track, ok := w.payloadTypes[pkt.PayloadType]
+		if !ok {
+			continue
+		}
+
+		err = track.writeRTP(&pkt, cpkt.time)
+		if err != nil {
+			var perr *packetError
+			if errors.As(err, &perr) {
+				continue
+			}
+			return err
+		}
+	}
+}
+
+// RemuxFile reads RTP and RTCP packets from a pcap or rtpdump file,
+// decodes them with a SDP file and writes them into a finalized MP4 file.
+// See Remux() for details.
+func RemuxFile(
+	ctx context.Context,
+	capturePath string,
+	sdpPath string,
+	outputPath string,
+	onProgress func(Progress),
+) error {
+	byts, err := os.ReadFile(sdpPath)
+	if err != nil {
+		return fmt.Errorf("failed to read SDP: %w", err)
+	}
+
+	desc, err := unmarshalSDP(byts)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(capturePath)
+	if err != nil {
+		return fmt.Errorf("failed to open capture: %w", err)
+	}
+	defer f.Close()
+
+	return Remux(ctx, f, desc, outputPath, onProgress)
+}
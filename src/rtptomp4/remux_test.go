@@ -0,0 +1,199 @@
+package rtptomp4
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abema/go-mp4"
+	rtspformat "github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/test"
+)
+
+var testSDP = []byte("v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=fmtp:96 packetization-mode=1\r\n")
+
+func testCapturePayloads(t *testing.T) [][]byte {
+	forma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	enc, err := forma.CreateEncoder()
+	require.NoError(t, err)
+
+	sr, err := (&rtcp.SenderReport{SSRC: 1234}).Marshal()
+	require.NoError(t, err)
+
+	// packet with an unknown payload type
+	unknown, err := (&rtp.Packet{
+		Header: rtp.Header{
+			Version:     2,
+			PayloadType: 97,
+		},
+		Payload: []byte{1, 2, 3},
+	}).Marshal()
+	require.NoError(t, err)
+
+	payloads := [][]byte{sr, unknown}
+
+	for i := 0; i < 3; i++ {
+		pkts, err2 := enc.Encode([][]byte{
+			test.FormatH264.SPS,
+			test.FormatH264.PPS,
+			{5, 1}, // IDR
+		})
+		require.NoError(t, err2)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(i * 90000)
+			byts, err2 := pkt.Marshal()
+			require.NoError(t, err2)
+			payloads = append(payloads, byts)
+		}
+	}
+
+	return payloads
+}
+
+func marshalPcap(payloads [][]byte) []byte {
+	var buf bytes.Buffer
+
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], 65535)
+	binary.LittleEndian.PutUint32(header[20:24], pcapLinkTypeEthernet)
+	buf.Write(header)
+
+	writeFrame := func(frame []byte) {
+		rec := make([]byte, 16)
+		binary.LittleEndian.PutUint32(rec[0:4], 1262304000)
+		binary.LittleEndian.PutUint32(rec[8:12], uint32(len(frame)))
+		binary.LittleEndian.PutUint32(rec[12:16], uint32(len(frame)))
+		buf.Write(rec)
+		buf.Write(frame)
+	}
+
+	// ARP frame, that must be skipped
+	arp := make([]byte, 42)
+	binary.BigEndian.PutUint16(arp[12:14], 0x0806)
+	writeFrame(arp)
+
+	for _, payload := range payloads {
+		frame := make([]byte, 14+20+8+len(payload))
+		binary.BigEndian.PutUint16(frame[12:14], 0x0800)
+
+		ip := frame[14:]
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:4], uint16(20+8+len(payload)))
+		ip[8] = 64
+		ip[9] = 17
+
+		udp := ip[20:]
+		binary.BigEndian.PutUint16(udp[0:2], 5000)
+		binary.BigEndian.PutUint16(udp[2:4], 5000)
+		binary.BigEndian.PutUint16(udp[4:6], uint16(8+len(payload)))
+		copy(udp[8:], payload)
+
+		writeFrame(frame)
+	}
+
+	return buf.Bytes()
+}
+
+func marshalRtpdump(payloads [][]byte) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("#!rtpplay1.0 127.0.0.1/5000\n")
+
+	header := make([]byte, 16)
+	binary.BigEndian.PutUint32(header[0:4], 1262304000)
+	buf.Write(header)
+
+	for i, payload := range payloads {
+		plen := len(payload)
+		if isRTCP(payload) {
+			plen = 0
+		}
+
+		pkt := make([]byte, 8+len(payload))
+		binary.BigEndian.PutUint16(pkt[0:2], uint16(len(pkt)))
+		binary.BigEndian.PutUint16(pkt[2:4], uint16(plen))
+		binary.BigEndian.PutUint32(pkt[4:8], uint32(i*10))
+		copy(pkt[8:], payload)
+		buf.Write(pkt)
+	}
+
+	return buf.Bytes()
+}
+
+func TestRemuxFile(t *testing.T) {
+	for _, ca := range []string{"pcap", "rtpdump"} {
+		t.Run(ca, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			payloads := testCapturePayloads(t)
+
+			var capture []byte
+			if ca == "pcap" {
+				capture = marshalPcap(payloads)
+			} else {
+				capture = marshalRtpdump(payloads)
+			}
+
+			capturePath := filepath.Join(dir, "capture")
+			err = os.WriteFile(capturePath, capture, 0o644)
+			require.NoError(t, err)
+
+			sdpPath := filepath.Join(dir, "session.sdp")
+			err = os.WriteFile(sdpPath, testSDP, 0o644)
+			require.NoError(t, err)
+
+			outputPath := filepath.Join(dir, "out.mp4")
+
+			var progress Progress
+
+			err = RemuxFile(context.Background(), capturePath, sdpPath, outputPath, func(p Progress) {
+				progress = p
+			})
+			require.NoError(t, err)
+			require.Equal(t, uint64(3), progress.Samples)
+			require.Equal(t, 2*time.Second, progress.PTS)
+
+			f, err := os.Open(outputPath)
+			require.NoError(t, err)
+			defer f.Close()
+
+			boxes, err := mp4.ExtractBoxWithPayload(f, nil, mp4.BoxPath{
+				mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMinf(),
+				mp4.BoxTypeStbl(), mp4.BoxTypeStts(),
+			})
+			require.NoError(t, err)
+			require.Len(t, boxes, 1)
+			require.Equal(t, []mp4.SttsEntry{{SampleCount: 3, SampleDelta: 90000}},
+				boxes[0].Payload.(*mp4.Stts).Entries)
+		})
+	}
+}
+
+func TestRemuxUnsupportedCapture(t *testing.T) {
+	err := Remux(context.Background(), bytes.NewReader([]byte{0x0a, 0x0d, 0x0d, 0x0a, 0, 0, 0, 0}), nil, "", nil)
+	require.EqualError(t, err, "pcapng captures are not supported, convert them into pcap first")
+}
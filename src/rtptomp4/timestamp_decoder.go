@@ -0,0 +1,24 @@
+package rtptomp4
+
+// timestampDecoder converts 32-bit RTP timestamps, that wrap around
+// every 13 hours with a 90kHz clock, into monotonic 64-bit timestamps,
+// relative to the first received one.
+type timestampDecoder struct {
+	initialized bool
+	overall     int64
+	prev        uint32
+}
+
+func (d *timestampDecoder) decode(ts uint32) int64 {
+	if !d.initialized {
+		d.initialized = true
+		d.prev = ts
+		return 0
+	}
+
+	// the difference is interpreted as a signed value in order to
+	// support both wraparounds and out-of-order packets.
+	d.overall += int64(int32(ts - d.prev))
+	d.prev = ts
+	return d.overall
+}
@@ -0,0 +1,306 @@
+package rtptomp4
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtp"
+
+	"github.com/flynnletford/mediamtx/src/formatprocessor"
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+// Matroska element IDs.
+// Specification: RFC 9559
+const (
+	ebmlIDHeader             = 0x1A45DFA3
+	ebmlIDVersion            = 0x4286
+	ebmlIDReadVersion        = 0x42F7
+	ebmlIDMaxIDLength        = 0x42F2
+	ebmlIDMaxSizeLength      = 0x42F3
+	ebmlIDDocType            = 0x4282
+	ebmlIDDocTypeVersion     = 0x4287
+	ebmlIDDocTypeReadVersion = 0x4285
+	ebmlIDSegment            = 0x18538067
+	ebmlIDInfo               = 0x1549A966
+	ebmlIDTimestampScale     = 0x2AD7B1
+	ebmlIDMuxingApp          = 0x4D80
+	ebmlIDWritingApp         = 0x5741
+	ebmlIDTracks             = 0x1654AE6B
+	ebmlIDTrackEntry         = 0xAE
+	ebmlIDTrackNumber        = 0xD7
+	ebmlIDTrackUID           = 0x73C5
+	ebmlIDTrackType          = 0x83
+	ebmlIDCodecID            = 0x86
+	ebmlIDVideo              = 0xE0
+	ebmlIDPixelWidth         = 0xB0
+	ebmlIDPixelHeight        = 0xBA
+	ebmlIDCluster            = 0x1F43B675
+	ebmlIDTimestamp          = 0xE7
+	ebmlIDSimpleBlock        = 0xA3
+)
+
+const (
+	// size of elements whose size is unknown when they are written.
+	ebmlUnknownSize = 0x01FFFFFFFFFFFFFF
+
+	// SimpleBlock timestamps are relative to the cluster and are 16-bit signed integers.
+	webmMaxClusterDuration = 32767 * time.Millisecond
+)
+
+func ebmlWriteID(buf *bytes.Buffer, id uint32) {
+	switch {
+	case id > 0xFFFFFF:
+		buf.Write([]byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)})
+	case id > 0xFFFF:
+		buf.Write([]byte{byte(id >> 16), byte(id >> 8), byte(id)})
+	case id > 0xFF:
+		buf.Write([]byte{byte(id >> 8), byte(id)})
+	default:
+		buf.WriteByte(byte(id))
+	}
+}
+
+// sizes are always written with 8 bytes, in order to keep things simple.
+func ebmlWriteSize(buf *bytes.Buffer, size uint64) {
+	size |= 0x01 << 56
+	buf.Write([]byte{
+		byte(size >> 56), byte(size >> 48), byte(size >> 40), byte(size >> 32),
+		byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size),
+	})
+}
+
+func ebmlWriteBytes(buf *bytes.Buffer, id uint32, v []byte) {
+	ebmlWriteID(buf, id)
+	ebmlWriteSize(buf, uint64(len(v)))
+	buf.Write(v)
+}
+
+func ebmlWriteUint(buf *bytes.Buffer, id uint32, v uint64) {
+	n := 1
+	for n < 8 && (v>>(8*n)) != 0 {
+		n++
+	}
+
+	enc := make([]byte, n)
+	for i := 0; i < n; i++ {
+		enc[n-1-i] = byte(v >> (8 * i))
+	}
+
+	ebmlWriteBytes(buf, id, enc)
+}
+
+func ebmlWriteMaster(buf *bytes.Buffer, id uint32, cb func(buf *bytes.Buffer)) {
+	var content bytes.Buffer
+	cb(&content)
+	ebmlWriteBytes(buf, id, content.Bytes())
+}
+
+// vp8ExtractSize extracts the frame size from the header of a VP8 key frame.
+// Specification: RFC 6386, section 9.1
+func vp8ExtractSize(frame []byte) (int, int, error) {
+	if len(frame) < 10 {
+		return 0, 0, fmt.Errorf("not enough bits")
+	}
+
+	if frame[3] != 0x9d || frame[4] != 0x01 || frame[5] != 0x2a {
+		return 0, 0, fmt.Errorf("invalid start code")
+	}
+
+	width := int(frame[6]) | int(frame[7]&0x3F)<<8
+	height := int(frame[8]) | int(frame[9]&0x3F)<<8
+
+	return width, height, nil
+}
+
+// WebMWriter writes VP8 RTP packets to a WebM file.
+// VP8 has no MP4 mapping, therefore it is written into WebM instead of MP4.
+type WebMWriter struct {
+	outputPath string
+	format     *format.VP8
+	file       *os.File
+	log        *logger.Logger
+	processor  formatprocessor.Processor
+
+	timestampDecoder timestampDecoder
+	headerWritten    bool
+	cluster          *bytes.Buffer
+	clusterStart     time.Duration
+}
+
+// NewWebMWriter creates a new WebMWriter.
+func NewWebMWriter(outputPath string, forma *format.VP8) (*WebMWriter, error) {
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	log, err := logger.New(logger.Info, nil, "", "")
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to create logger: %w", err)
+	}
+
+	processor, err := formatprocessor.New(udpMaxPayloadSize, forma, false, log)
+	if err != nil {
+		log.Close()
+		file.Close()
+		return nil, fmt.Errorf("failed to create format processor: %w", err)
+	}
+
+	return &WebMWriter{
+		outputPath: outputPath,
+		format:     forma,
+		file:       file,
+		log:        log,
+		processor:  processor,
+	}, nil
+}
+
+// WriteRTP writes an RTP packet to the WebM file.
+func (w *WebMWriter) WriteRTP(pkt *rtp.Packet) error {
+	pts := w.timestampDecoder.decode(pkt.Timestamp)
+
+	u, err := w.processor.ProcessRTPPacket(pkt, time.Now(), pts, true)
+	if err != nil {
+		return fmt.Errorf("failed to process RTP packet: %w", err)
+	}
+
+	tunit := u.(*unit.VP8)
+	if tunit.Frame == nil {
+		return nil
+	}
+
+	// key frames have the first bit of the frame tag set to zero
+	randomAccess := (tunit.Frame[0] & 0x01) == 0
+
+	if !w.headerWritten {
+		if !randomAccess {
+			return nil
+		}
+
+		width, height, err2 := vp8ExtractSize(tunit.Frame)
+		if err2 != nil {
+			return err2
+		}
+
+		err2 = w.writeHeader(width, height)
+		if err2 != nil {
+			return err2
+		}
+	}
+
+	return w.writeFrame(tunit.Frame, randomAccess,
+		time.Duration(tunit.PTS)*time.Second/time.Duration(w.format.ClockRate()))
+}
+
+func (w *WebMWriter) writeHeader(width int, height int) error {
+	var buf bytes.Buffer
+
+	ebmlWriteMaster(&buf, ebmlIDHeader, func(buf *bytes.Buffer) {
+		ebmlWriteUint(buf, ebmlIDVersion, 1)
+		ebmlWriteUint(buf, ebmlIDReadVersion, 1)
+		ebmlWriteUint(buf, ebmlIDMaxIDLength, 4)
+		ebmlWriteUint(buf, ebmlIDMaxSizeLength, 8)
+		ebmlWriteBytes(buf, ebmlIDDocType, []byte("webm"))
+		ebmlWriteUint(buf, ebmlIDDocTypeVersion, 2)
+		ebmlWriteUint(buf, ebmlIDDocTypeReadVersion, 2)
+	})
+
+	// the segment size is unknown, this allows to write the file progressively.
+	ebmlWriteID(&buf, ebmlIDSegment)
+	ebmlWriteSize(&buf, ebmlUnknownSize)
+
+	ebmlWriteMaster(&buf, ebmlIDInfo, func(buf *bytes.Buffer) {
+		ebmlWriteUint(buf, ebmlIDTimestampScale, uint64(time.Millisecond))
+		ebmlWriteBytes(buf, ebmlIDMuxingApp, []byte("mediamtx"))
+		ebmlWriteBytes(buf, ebmlIDWritingApp, []byte("mediamtx"))
+	})
+
+	ebmlWriteMaster(&buf, ebmlIDTracks, func(buf *bytes.Buffer) {
+		ebmlWriteMaster(buf, ebmlIDTrackEntry, func(buf *bytes.Buffer) {
+			ebmlWriteUint(buf, ebmlIDTrackNumber, 1)
+			ebmlWriteUint(buf, ebmlIDTrackUID, 1)
+			ebmlWriteUint(buf, ebmlIDTrackType, 1) // video
+			ebmlWriteBytes(buf, ebmlIDCodecID, []byte("V_VP8"))
+			ebmlWriteMaster(buf, ebmlIDVideo, func(buf *bytes.Buffer) {
+				ebmlWriteUint(buf, ebmlIDPixelWidth, uint64(width))
+				ebmlWriteUint(buf, ebmlIDPixelHeight, uint64(height))
+			})
+		})
+	})
+
+	_, err := w.file.Write(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+
+	w.headerWritten = true
+	return nil
+}
+
+func (w *WebMWriter) writeFrame(frame []byte, randomAccess bool, pts time.Duration) error {
+	// start a new cluster on every key frame, in order to allow seeking.
+	if w.cluster != nil && (randomAccess || (pts-w.clusterStart) > webmMaxClusterDuration) {
+		err := w.flushCluster()
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.cluster == nil {
+		w.cluster = &bytes.Buffer{}
+		w.clusterStart = pts
+		ebmlWriteUint(w.cluster, ebmlIDTimestamp, uint64(pts/time.Millisecond))
+	}
+
+	relTimestamp := int16((pts - w.clusterStart) / time.Millisecond)
+
+	flags := byte(0)
+	if randomAccess {
+		flags |= 0x80
+	}
+
+	block := make([]byte, 4+len(frame))
+	block[0] = 0x81 // track number 1, encoded as a variable-size integer
+	block[1] = byte(relTimestamp >> 8)
+	block[2] = byte(relTimestamp)
+	block[3] = flags
+	copy(block[4:], frame)
+
+	ebmlWriteBytes(w.cluster, ebmlIDSimpleBlock, block)
+
+	return nil
+}
+
+func (w *WebMWriter) flushCluster() error {
+	var buf bytes.Buffer
+	ebmlWriteBytes(&buf, ebmlIDCluster, w.cluster.Bytes())
+	w.cluster = nil
+
+	_, err := w.file.Write(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to write cluster: %w", err)
+	}
+
+	return nil
+}
+
+// Close writes pending data and closes the WebMWriter.
+func (w *WebMWriter) Close() error {
+	defer w.log.Close()
+
+	if w.cluster != nil {
+		err := w.flushCluster()
+		if err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+
+	return w.file.Close()
+}
@@ -0,0 +1,55 @@
+package rtptomp4
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtspformat "github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebMWriterVP8(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.webm")
+
+	forma := &rtspformat.VP8{
+		PayloadTyp: 96,
+	}
+
+	_, err = NewMP4Writer(filepath.Join(dir, "out.mp4"), forma)
+	require.EqualError(t, err, "VP8 can't be stored into MP4, use WebMWriter instead")
+
+	w, err := NewWebMWriter(fpath, forma)
+	require.NoError(t, err)
+
+	enc, err := forma.CreateEncoder()
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		pkts, err2 := enc.Encode([]byte{
+			0x50, 0x42, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02,
+			0xe0, 0x01, 0x01, 0x02,
+		})
+		require.NoError(t, err2)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(i * 3000)
+			err2 = w.WriteRTP(pkt)
+			require.NoError(t, err2)
+		}
+	}
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	buf, err := os.ReadFile(fpath)
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(buf, []byte{0x1A, 0x45, 0xDF, 0xA3}))
+	require.True(t, bytes.Contains(buf, []byte("V_VP8")))
+	require.Equal(t, 3, bytes.Count(buf, []byte{0x1F, 0x43, 0xB6, 0x75}))
+}
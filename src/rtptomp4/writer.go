@@ -1,143 +1,500 @@
+// Package rtptomp4 contains a RTP to MP4 remuxer.
 package rtptomp4
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
 	"github.com/bluenviron/gortsplib/v4/pkg/format"
-	rtspformat "github.com/bluenviron/gortsplib/v4/pkg/format"
-	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
-	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4/seekablebuffer"
+	"github.com/bluenviron/gortsplib/v4/pkg/sdp"
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 
-	"github.com/flynnletford/mediamtx/src/formatprocessor"
 	"github.com/flynnletford/mediamtx/src/logger"
-	"github.com/flynnletford/mediamtx/src/unit"
 )
 
-type track struct {
-	initTrack *fmp4.InitTrack
-	nextID    int
+const (
+	udpMaxPayloadSize = 1500
+)
+
+// Progress contains the progress of a MP4Writer.
+type Progress struct {
+	// number of written samples
+	Samples uint64
+
+	// size of written samples
+	Bytes uint64
+
+	// presentation timestamp of the last written sample, relative to the start time
+	PTS time.Duration
 }
 
-// MP4Writer writes RTP packets to an MP4 file.
+// MP4Writer writes RTP packets to a MP4 file.
+// Each format is written into a dedicated track.
 type MP4Writer struct {
-	outputPath string
-	format     format.Format
-	processor  formatprocessor.Processor
-	file       *os.File
-	track      *track
-	mdat       []byte
+	file   *os.File
+	output output
+	log    *logger.Logger
+	tracks []*writerTrack
+
+	startTime    time.Time
+	firstNTP     time.Time
+	payloadTypes map[uint8]*writerTrack
+	ctx          context.Context
+	onProgress   func(Progress)
+	progress     Progress
 }
 
-// NewMP4Writer creates a new MP4Writer.
-func NewMP4Writer(outputPath string, format format.Format) (*MP4Writer, error) {
-	// Create the output file
+// NewMP4Writer creates a MP4Writer that writes a finalized MP4 file.
+// Samples are kept in memory and the file is written by Close(), with the
+// moov box before the mdat box, in order to allow progressive playback.
+// A track is allocated for each of the provided formats.
+func NewMP4Writer(outputPath string, formats ...format.Format) (*MP4Writer, error) {
 	file, err := os.Create(outputPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create output file: %w", err)
 	}
 
-	// Initialize the format processor
-	log, err := logger.New(logger.Info, nil, "", "")
+	o := &outputMP4{
+		w: file,
+	}
+	o.initialize()
+
+	w, err := newMP4Writer(o, formats)
 	if err != nil {
 		file.Close()
-		return nil, fmt.Errorf("failed to create logger: %w", err)
+		return nil, err
 	}
-	processor, err := formatprocessor.New(1500, format, false, log)
+
+	w.file = file
+	return w, nil
+}
+
+// NewMP4WriterFromSession creates a MP4Writer that writes a finalized MP4 file
+// with a track for each format of a session description.
+// Packets can then be written with WriteRTPPacket(), therefore a RTSP session
+// can be remuxed without mapping formats to tracks manually.
+func NewMP4WriterFromSession(outputPath string, desc *description.Session) (*MP4Writer, error) {
+	w, err := NewMP4Writer(outputPath)
 	if err != nil {
-		file.Close()
-		return nil, fmt.Errorf("failed to create format processor: %w", err)
+		return nil, err
 	}
 
-	// Create track
-	track := &track{
-		initTrack: &fmp4.InitTrack{
-			TimeScale: uint32(format.ClockRate()),
-			ID:        1,
-		},
+	err = w.RegisterSession(desc)
+	if err != nil {
+		w.log.Close()
+		w.file.Close()
+		os.Remove(outputPath)
+		return nil, err
 	}
 
-	// Initialize track codec based on format type
-	switch format := format.(type) {
-	case *rtspformat.H264:
-		track.initTrack.Codec = &fmp4.CodecH264{
-			SPS: format.SPS,
-			PPS: format.PPS,
-		}
-	// Add other format types as needed
-	default:
-		file.Close()
-		return nil, fmt.Errorf("unsupported format type: %T", format)
+	return w, nil
+}
+
+// NewMP4WriterFromSDP creates a MP4Writer that writes a finalized MP4 file
+// with a track for each format of a SDP.
+func NewMP4WriterFromSDP(outputPath string, byts []byte) (*MP4Writer, error) {
+	desc, err := unmarshalSDP(byts)
+	if err != nil {
+		return nil, err
 	}
 
-	return &MP4Writer{
-		outputPath: outputPath,
-		format:     format,
-		processor:  processor,
-		file:       file,
-		track:      track,
-		mdat:       make([]byte, 0),
-	}, nil
+	return NewMP4WriterFromSession(outputPath, desc)
 }
 
-// WriteRTP writes an RTP packet to the MP4 file.
-func (w *MP4Writer) WriteRTP(pkt *rtp.Packet) error {
-	// Process the RTP packet into a unit
-	u, err := w.processor.ProcessRTPPacket(pkt, time.Now(), 0, false)
+func unmarshalSDP(byts []byte) (*description.Session, error) {
+	var sd sdp.SessionDescription
+	err := sd.Unmarshal(byts)
 	if err != nil {
-		return fmt.Errorf("failed to process RTP packet: %w", err)
+		return nil, fmt.Errorf("invalid SDP: %w", err)
 	}
 
-	if u == nil {
-		return nil // Skip empty units
+	var desc description.Session
+	err = desc.Unmarshal(&sd)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SDP: %w", err)
 	}
 
-	// Convert the unit into an fMP4 sample based on format type
-	var sampl fmp4.PartSample
+	return &desc, nil
+}
 
-	switch u := u.(type) {
-	case *unit.H264:
-		err = sampl.FillH264(0, u.AU) // Use 0 as duration, it will be updated later
-	// Add other unit types as needed
-	default:
-		return fmt.Errorf("unsupported unit type: %T", u)
+// NewFragmentedMP4Writer creates a MP4Writer that writes a fragmented MP4 into w
+// progressively, while packets are received. This allows to pipe the output
+// to a HTTP response or to any other destination.
+// The init segment is written together with the first fragment, therefore tracks
+// that don't receive samples before the first fragment is complete are discarded.
+// w is not closed by Close().
+func NewFragmentedMP4Writer(
+	w io.Writer,
+	fragmentDuration time.Duration,
+	formats ...format.Format,
+) (*MP4Writer, error) {
+	o := &outputFMP4{
+		w:                w,
+		fragmentDuration: fragmentDuration,
 	}
+	o.initialize()
+
+	return newMP4Writer(o, formats)
+}
 
+func newMP4Writer(o output, formats []format.Format) (*MP4Writer, error) {
+	log, err := logger.New(logger.Info, nil, "", "")
 	if err != nil {
-		return fmt.Errorf("failed to fill fMP4 sample: %w", err)
+		return nil, fmt.Errorf("failed to create logger: %w", err)
 	}
 
-	// Append the sample to the mdat box
-	w.mdat = append(w.mdat, sampl.Payload...)
+	w := &MP4Writer{
+		output:       o,
+		log:          log,
+		payloadTypes: make(map[uint8]*writerTrack),
+		ctx:          context.Background(),
+	}
+
+	for _, forma := range formats {
+		err = w.AddTrack(forma)
+		if err != nil {
+			w.log.Close()
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+// AddTrack adds a track.
+// With finalized MP4 files, tracks can be added at any time before Close().
+func (w *MP4Writer) AddTrack(forma format.Format) error {
+	if w.findTrack(forma) != nil {
+		return fmt.Errorf("a track for this format already exists")
+	}
+
+	_, err := w.addTrack(forma)
+	return err
+}
+
+func (w *MP4Writer) addTrack(forma format.Format) (*writerTrack, error) {
+	track := &writerTrack{
+		w:      w,
+		format: forma,
+		id:     len(w.tracks) + 1,
+	}
+	err := track.initialize(w.log)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.output.addTrack(track)
+	if err != nil {
+		return nil, err
+	}
+
+	w.tracks = append(w.tracks, track)
+	return track, nil
+}
+
+// RegisterSession associates the payload types of a session description with tracks,
+// in order to allow writing packets with WriteRTPPacket().
+// A track is added for every format that doesn't have one yet, therefore
+// clock rates and codec parameters are always the ones negotiated through SDP.
+func (w *MP4Writer) RegisterSession(desc *description.Session) error {
+	// validate the session before editing tracks
+	payloadTypes := make(map[uint8]format.Format)
+
+	for _, medi := range desc.Medias {
+		for _, forma := range medi.Formats {
+			pt := forma.PayloadType()
+
+			if existing, ok := payloadTypes[pt]; ok && existing != forma {
+				return fmt.Errorf("payload type %d is used by multiple formats", pt)
+			}
+			if existing, ok := w.payloadTypes[pt]; ok && existing.format != forma {
+				return fmt.Errorf("payload type %d is already registered", pt)
+			}
+
+			payloadTypes[pt] = forma
+		}
+	}
+
+	for _, medi := range desc.Medias {
+		for _, forma := range medi.Formats {
+			track := w.findTrack(forma)
+			if track == nil {
+				var err error
+				track, err = w.addTrack(forma)
+				if err != nil {
+					return err
+				}
+			}
+
+			w.payloadTypes[forma.PayloadType()] = track
+		}
+	}
 
 	return nil
 }
 
-// Close closes the MP4Writer and finalizes the MP4 file.
-func (w *MP4Writer) Close() error {
-	// Write the init segment
-	init := &fmp4.Init{
-		Tracks: []*fmp4.InitTrack{w.track.initTrack},
+func (w *MP4Writer) findTrack(forma format.Format) *writerTrack {
+	for _, track := range w.tracks {
+		if track.format == forma {
+			return track
+		}
 	}
+	return nil
+}
+
+// WriteRTP writes an RTP packet into the track of the given format.
+func (w *MP4Writer) WriteRTP(forma format.Format, pkt *rtp.Packet) error {
+	track := w.findTrack(forma)
+	if track == nil {
+		return fmt.Errorf("no track found for format %s", forma.Codec())
+	}
+
+	return track.writeRTP(pkt, time.Now())
+}
+
+// WriteRTPPacket writes an RTP packet into the track associated with its payload type.
+// Payload types must be registered first with RegisterSession().
+func (w *MP4Writer) WriteRTPPacket(pkt *rtp.Packet) error {
+	track, ok := w.payloadTypes[pkt.PayloadType]
+	if !ok {
+		return fmt.Errorf("payload type %d is not registered", pkt.PayloadType)
+	}
+
+	return track.writeRTP(pkt, time.Now())
+}
 
-	var buf seekablebuffer.Buffer
-	err := init.Marshal(&buf)
+// WriteRTPPacketAt writes an RTP packet into the track associated with its payload type,
+// with a given absolute time and presentation timestamp, expressed in the clock rate of
+// the format. This allows callers that already know the timing of packets to bypass
+// timestamp decoding and RTCP sender reports.
+// It must not be mixed with other write methods on the same track.
+// Payload types must be registered first with RegisterSession().
+func (w *MP4Writer) WriteRTPPacketAt(pkt *rtp.Packet, ntp time.Time, pts int64) error {
+	track, ok := w.payloadTypes[pkt.PayloadType]
+	if !ok {
+		return fmt.Errorf("payload type %d is not registered", pkt.PayloadType)
+	}
+
+	return track.writeRTPAt(pkt, ntp, pts)
+}
+
+// WriteRTPPackets writes multiple RTP packets, in the same way as WriteRTPPacket().
+// All packets are considered received at the same time.
+func (w *MP4Writer) WriteRTPPackets(pkts []*rtp.Packet) error {
+	now := time.Now()
+
+	for _, pkt := range pkts {
+		track, ok := w.payloadTypes[pkt.PayloadType]
+		if !ok {
+			return fmt.Errorf("payload type %d is not registered", pkt.PayloadType)
+		}
+
+		err := track.writeRTP(pkt, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteRTCP writes a RTCP packet related to the track of the given format.
+// Sender reports are used to compute the absolute time of RTP packets
+// and to synchronize tracks. Other packets are ignored.
+func (w *MP4Writer) WriteRTCP(forma format.Format, pkt rtcp.Packet) error {
+	track := w.findTrack(forma)
+	if track == nil {
+		return fmt.Errorf("no track found for format %s", forma.Codec())
+	}
+
+	if sr, ok := pkt.(*rtcp.SenderReport); ok {
+		track.writeSenderReport(sr)
+	}
+
+	return nil
+}
+
+// WriteRTCPPacket writes a RTCP packet.
+// Sender reports are associated with tracks through the SSRC of RTP packets,
+// therefore sender reports received before any RTP packet of the track are ignored.
+func (w *MP4Writer) WriteRTCPPacket(pkt rtcp.Packet) {
+	if sr, ok := pkt.(*rtcp.SenderReport); ok {
+		for _, track := range w.tracks {
+			if track.firstReceived && track.ssrc == sr.SSRC {
+				track.writeSenderReport(sr)
+			}
+		}
+	}
+}
+
+// SetStartTime sets the absolute time of the first RTP packet.
+// It is used to fill creation times and to associate timestamps with absolute times.
+// By default, it is the time of arrival of the first RTP packet.
+func (w *MP4Writer) SetStartTime(t time.Time) {
+	w.startTime = t
+}
+
+// SetContext sets a context that allows to cancel the conversion.
+// Once the context is canceled, writes return the context error.
+// Close() must be called anyway and writes the samples received until then.
+func (w *MP4Writer) SetContext(ctx context.Context) {
+	w.ctx = ctx
+}
+
+// SetOnProgress sets a callback that is called synchronously every time a sample is written.
+func (w *MP4Writer) SetOnProgress(cb func(Progress)) {
+	w.onProgress = cb
+}
+
+// Progress returns the current progress.
+func (w *MP4Writer) Progress() Progress {
+	return w.progress
+}
+
+// SetMemoryLimit sets the maximum size of payloads that are kept in memory.
+// Payloads that exceed the limit are stored into a temporary file,
+// that is read back by Close(). Zero means no limit.
+// It has effect on finalized MP4 files only, since fragmented MP4 files
+// are written progressively.
+func (w *MP4Writer) SetMemoryLimit(limit int64) {
+	if o, ok := w.output.(*outputMP4); ok {
+		o.memoryLimit = limit
+	}
+}
+
+// AddChapter adds a named chapter that starts at the given time,
+// relative to the start time.
+// Chapters are supported by finalized MP4 files only.
+func (w *MP4Writer) AddChapter(title string, pts time.Duration) error {
+	o, ok := w.output.(*outputMP4)
+	if !ok {
+		return fmt.Errorf("chapters are supported by finalized MP4 files only")
+	}
+
+	if len(title) > 255 {
+		return fmt.Errorf("chapter title is too long")
+	}
+
+	if len(o.chapters) >= 255 {
+		return fmt.Errorf("too many chapters")
+	}
+
+	if pts < 0 {
+		return fmt.Errorf("chapter time can't be negative")
+	}
+
+	o.chapters = append(o.chapters, chapter{
+		title: title,
+		pts:   pts,
+	})
+
+	sort.SliceStable(o.chapters, func(i, j int) bool {
+		return o.chapters[i].pts < o.chapters[j].pts
+	})
+
+	return nil
+}
+
+func (w *MP4Writer) findTrackForMetadata(forma format.Format) (*writerTrack, error) {
+	track := w.findTrack(forma)
+	if track == nil {
+		return nil, fmt.Errorf("track not found")
+	}
+
+	if o, ok := w.output.(*outputFMP4); ok && o.initWritten {
+		return nil, fmt.Errorf("metadata can't be changed after the init segment has been written")
+	}
+
+	return track, nil
+}
+
+// SetTrackLanguage sets the language of the track of the given format,
+// as a ISO 639-2/T code (for instance, "eng").
+// With fragmented MP4 files, it must be called before the first fragment is written.
+func (w *MP4Writer) SetTrackLanguage(forma format.Format, language string) error {
+	track, err := w.findTrackForMetadata(forma)
 	if err != nil {
-		return fmt.Errorf("failed to write init segment: %w", err)
+		return err
+	}
+
+	if len(language) != 3 {
+		return fmt.Errorf("invalid language code: %s", language)
+	}
+
+	for _, c := range []byte(language) {
+		if c < 'a' || c > 'z' {
+			return fmt.Errorf("invalid language code: %s", language)
+		}
+	}
+
+	track.metadata.language = language
+	return nil
+}
+
+// SetTrackName sets the name of the track of the given format,
+// that is stored as name of the handler.
+// With fragmented MP4 files, it must be called before the first fragment is written.
+func (w *MP4Writer) SetTrackName(forma format.Format, name string) error {
+	track, err := w.findTrackForMetadata(forma)
+	if err != nil {
+		return err
+	}
+
+	if strings.ContainsRune(name, 0) {
+		return fmt.Errorf("track name can't contain null characters")
 	}
 
-	_, err = w.file.Write(buf.Bytes())
+	track.metadata.name = name
+	return nil
+}
+
+// SetTrackRotation sets the clockwise rotation, in degrees, that players must apply
+// to the track of the given format. It is stored into the display matrix.
+// Allowed values are 0, 90, 180 and 270.
+// With fragmented MP4 files, it must be called before the first fragment is written.
+func (w *MP4Writer) SetTrackRotation(forma format.Format, degrees int) error {
+	track, err := w.findTrackForMetadata(forma)
 	if err != nil {
-		return fmt.Errorf("failed to write init segment: %w", err)
+		return err
+	}
+
+	switch degrees {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("unsupported rotation: %d", degrees)
+	}
+
+	if !track.codec.IsVideo() {
+		return fmt.Errorf("rotation can be applied to video tracks only")
+	}
+
+	track.metadata.rotation = degrees
+	return nil
+}
+
+// Close writes pending data and closes the MP4Writer.
+func (w *MP4Writer) Close() error {
+	defer w.log.Close()
+
+	err := w.output.close(w.startTime)
+
+	if w.file != nil {
+		err2 := w.file.Close()
+		if err == nil {
+			err = err2
+		}
 	}
 
-	// Write the mdat box
-	_, err = w.file.Write(w.mdat)
 	if err != nil {
-		return fmt.Errorf("failed to write mdat box: %w", err)
+		return fmt.Errorf("failed to write MP4: %w", err)
 	}
 
-	return w.file.Close()
+	return nil
 }
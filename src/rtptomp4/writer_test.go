@@ -0,0 +1,1057 @@
+package rtptomp4
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abema/go-mp4"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	rtspformat "github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph264"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/formatprocessor"
+	"github.com/flynnletford/mediamtx/src/test"
+)
+
+func readTrackIDs(t *testing.T, fpath string) []uint32 {
+	f, err := os.Open(fpath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	boxes, err := mp4.ExtractBoxWithPayload(f, nil,
+		mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeTkhd()})
+	require.NoError(t, err)
+
+	ids := make([]uint32, len(boxes))
+	for i, box := range boxes {
+		ids[i] = box.Payload.(*mp4.Tkhd).TrackID
+	}
+	return ids
+}
+
+func TestMP4WriterMultiTrack(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.mp4")
+
+	forma1 := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+		SPS:               test.FormatH264.SPS,
+		PPS:               test.FormatH264.PPS,
+	}
+	forma2 := &rtspformat.H264{
+		PayloadTyp:        97,
+		PacketizationMode: 1,
+		SPS:               test.FormatH264.SPS,
+		PPS:               test.FormatH264.PPS,
+	}
+
+	w, err := NewMP4Writer(fpath, forma1)
+	require.NoError(t, err)
+
+	err = w.AddTrack(forma2)
+	require.NoError(t, err)
+
+	err = w.AddTrack(forma2)
+	require.Error(t, err)
+
+	for _, forma := range []*rtspformat.H264{forma1, forma2} {
+		enc, err2 := forma.CreateEncoder()
+		require.NoError(t, err2)
+
+		for i := 0; i < 3; i++ {
+			pkts, err2 := enc.Encode([][]byte{
+				test.FormatH264.SPS,
+				test.FormatH264.PPS,
+				{5, 1}, // IDR
+			})
+			require.NoError(t, err2)
+
+			for _, pkt := range pkts {
+				pkt.Timestamp = uint32(i * 3000)
+				err2 = w.WriteRTP(forma, pkt)
+				require.NoError(t, err2)
+			}
+		}
+	}
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	require.Equal(t, []uint32{1, 2}, readTrackIDs(t, fpath))
+}
+
+func TestMP4WriterRegisterSession(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.mp4")
+
+	desc := &description.Session{Medias: []*description.Media{
+		{
+			Type: description.MediaTypeVideo,
+			Formats: []rtspformat.Format{&rtspformat.H264{
+				PayloadTyp:        96,
+				PacketizationMode: 1,
+				SPS:               test.FormatH264.SPS,
+				PPS:               test.FormatH264.PPS,
+			}},
+		},
+		{
+			Type: description.MediaTypeVideo,
+			Formats: []rtspformat.Format{&rtspformat.H264{
+				PayloadTyp:        100,
+				PacketizationMode: 1,
+				SPS:               test.FormatH264.SPS,
+				PPS:               test.FormatH264.PPS,
+			}},
+		},
+	}}
+
+	w, err := NewMP4Writer(fpath)
+	require.NoError(t, err)
+
+	err = w.RegisterSession(desc)
+	require.NoError(t, err)
+
+	err = w.RegisterSession(&description.Session{Medias: []*description.Media{{
+		Type:    description.MediaTypeVideo,
+		Formats: []rtspformat.Format{&rtspformat.H264{PayloadTyp: 96}},
+	}}})
+	require.EqualError(t, err, "payload type 96 is already registered")
+
+	err = w.WriteRTPPacket(&rtp.Packet{Header: rtp.Header{PayloadType: 97}})
+	require.EqualError(t, err, "payload type 97 is not registered")
+
+	for _, medi := range desc.Medias {
+		enc, err2 := medi.Formats[0].(*rtspformat.H264).CreateEncoder()
+		require.NoError(t, err2)
+
+		for i := 0; i < 3; i++ {
+			pkts, err2 := enc.Encode([][]byte{
+				test.FormatH264.SPS,
+				test.FormatH264.PPS,
+				{5, 1}, // IDR
+			})
+			require.NoError(t, err2)
+
+			for _, pkt := range pkts {
+				pkt.Timestamp = uint32(i * 3000)
+				err2 = w.WriteRTPPacket(pkt)
+				require.NoError(t, err2)
+			}
+		}
+	}
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	require.Equal(t, []uint32{1, 2}, readTrackIDs(t, fpath))
+}
+
+func TestMP4WriterFromSession(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.mp4")
+
+	videoForma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	audioForma := &rtspformat.MPEG4Audio{
+		PayloadTyp: 97,
+		Config: &mpeg4audio.Config{
+			Type:         2,
+			SampleRate:   44100,
+			ChannelCount: 2,
+		},
+		SizeLength:       13,
+		IndexLength:      3,
+		IndexDeltaLength: 3,
+	}
+
+	_, err = NewMP4WriterFromSession(fpath, &description.Session{Medias: []*description.Media{{
+		Type:    description.MediaTypeVideo,
+		Formats: []rtspformat.Format{&rtspformat.VP8{PayloadTyp: 96}},
+	}}})
+	require.EqualError(t, err, "VP8 can't be stored into MP4, use WebMWriter instead")
+
+	_, err = os.Stat(fpath)
+	require.True(t, os.IsNotExist(err))
+
+	w, err := NewMP4WriterFromSession(fpath, &description.Session{Medias: []*description.Media{
+		{
+			Type:    description.MediaTypeVideo,
+			Formats: []rtspformat.Format{videoForma},
+		},
+		{
+			Type:    description.MediaTypeAudio,
+			Formats: []rtspformat.Format{audioForma},
+		},
+	}})
+	require.NoError(t, err)
+
+	videoEnc, err := videoForma.CreateEncoder()
+	require.NoError(t, err)
+
+	audioEnc, err := audioForma.CreateEncoder()
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		pkts, err2 := videoEnc.Encode([][]byte{
+			test.FormatH264.SPS,
+			test.FormatH264.PPS,
+			{5, 1}, // IDR
+		})
+		require.NoError(t, err2)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(i * 3000)
+			err2 = w.WriteRTPPacket(pkt)
+			require.NoError(t, err2)
+		}
+
+		pkts, err2 = audioEnc.Encode([][]byte{{1, 2, 3, 4}})
+		require.NoError(t, err2)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(i * 1024)
+			err2 = w.WriteRTPPacket(pkt)
+			require.NoError(t, err2)
+		}
+	}
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	require.Equal(t, []uint32{1, 2}, readTrackIDs(t, fpath))
+}
+
+func TestMP4WriterInBandParams(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.mp4")
+
+	forma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	w, err := NewMP4Writer(fpath, forma)
+	require.NoError(t, err)
+
+	enc, err := forma.CreateEncoder()
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		pkts, err2 := enc.Encode([][]byte{
+			test.FormatH264.SPS,
+			test.FormatH264.PPS,
+			{5, 1}, // IDR
+		})
+		require.NoError(t, err2)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(i * 3000)
+			err2 = w.WriteRTP(forma, pkt)
+			require.NoError(t, err2)
+		}
+	}
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	f, err := os.Open(fpath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	boxes, err := mp4.ExtractBoxWithPayload(f, nil, mp4.BoxPath{
+		mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMinf(),
+		mp4.BoxTypeStbl(), mp4.BoxTypeStsd(), mp4.BoxTypeAvc1(), mp4.BoxTypeAvcC(),
+	})
+	require.NoError(t, err)
+	require.Len(t, boxes, 1)
+
+	avcc := boxes[0].Payload.(*mp4.AVCDecoderConfiguration)
+	require.Equal(t, test.FormatH264.SPS, avcc.SequenceParameterSets[0].NALUnit)
+	require.Equal(t, test.FormatH264.PPS, avcc.PictureParameterSets[0].NALUnit)
+}
+
+func TestMP4WriterVP9(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.mp4")
+
+	forma := &rtspformat.VP9{
+		PayloadTyp: 96,
+	}
+
+	w, err := NewMP4Writer(fpath, forma)
+	require.NoError(t, err)
+
+	enc, err := forma.CreateEncoder()
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		pkts, err2 := enc.Encode([]byte{
+			0x82, 0x49, 0x83, 0x42, 0x00, 0x77, 0xf0, 0x32,
+			0x34, 0x30, 0x38, 0x24, 0x1c, 0x19, 0x40, 0x18,
+			0x03, 0x40, 0x5f, 0xb4,
+		})
+		require.NoError(t, err2)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(i * 3000)
+			err2 = w.WriteRTP(forma, pkt)
+			require.NoError(t, err2)
+		}
+	}
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	f, err := os.Open(fpath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	boxes, err := mp4.ExtractBoxWithPayload(f, nil, mp4.BoxPath{
+		mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMinf(),
+		mp4.BoxTypeStbl(), mp4.BoxTypeStsd(), mp4.BoxTypeVp09(),
+	})
+	require.NoError(t, err)
+	require.Len(t, boxes, 1)
+
+	vp09 := boxes[0].Payload.(*mp4.VisualSampleEntry)
+	require.Equal(t, uint16(1920), vp09.Width)
+	require.Equal(t, uint16(804), vp09.Height)
+}
+
+func TestMP4WriterH266(t *testing.T) {
+	sps := []byte{
+		0x00, 0x79, 0x00, 0x0d, 0x02, 0x53, 0x80, 0x00,
+		0x00, 0x0f, 0x02, 0x00, 0x43, 0x94,
+	}
+	pps := []byte{0x00, 0x81, 0x03, 0x04}
+	idr := []byte{0x00, 0x41, 0x05, 0x06} // IDR_N_LP
+	trail := []byte{0x00, 0x01, 0x07, 0x08}
+
+	entry, err := formatprocessor.H266MP4SampleEntry(nil, sps, pps)
+	require.NoError(t, err)
+
+	for _, ca := range []string{"mp4", "fmp4"} {
+		t.Run(ca, func(t *testing.T) {
+			forma := &rtspformat.Generic{
+				PayloadTyp: 96,
+				RTPMa:      "H266/90000",
+			}
+			err2 := forma.Init()
+			require.NoError(t, err2)
+
+			dir, err2 := os.MkdirTemp("", "mediamtx-rtptomp4")
+			require.NoError(t, err2)
+			defer os.RemoveAll(dir)
+
+			fpath := filepath.Join(dir, "out.mp4")
+
+			var w *MP4Writer
+			var buf bytes.Buffer
+
+			if ca == "mp4" {
+				w, err2 = NewMP4Writer(fpath, forma)
+			} else {
+				w, err2 = NewFragmentedMP4Writer(&buf, 200*time.Millisecond, forma)
+			}
+			require.NoError(t, err2)
+
+			seqNum := uint16(0)
+
+			for i := 0; i < 4; i++ {
+				au := [][]byte{trail}
+				if i%2 == 0 {
+					au = [][]byte{sps, pps, idr}
+				}
+
+				for j, nalu := range au {
+					err2 = w.WriteRTP(forma, &rtp.Packet{
+						Header: rtp.Header{
+							Version:        2,
+							Marker:         j == len(au)-1,
+							PayloadType:    96,
+							SequenceNumber: seqNum,
+							Timestamp:      uint32(i * 9000),
+							SSRC:           1234,
+						},
+						Payload: nalu,
+					})
+					require.NoError(t, err2)
+					seqNum++
+				}
+			}
+
+			err2 = w.Close()
+			require.NoError(t, err2)
+
+			var byts []byte
+			if ca == "mp4" {
+				byts, err2 = os.ReadFile(fpath)
+				require.NoError(t, err2)
+			} else {
+				byts = buf.Bytes()
+			}
+
+			infos, err2 := mp4.ExtractBox(bytes.NewReader(byts), nil, mp4.BoxPath{
+				mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMinf(),
+				mp4.BoxTypeStbl(), mp4.BoxTypeStsd(), mp4.StrToBoxType("vvc1"),
+			})
+			require.NoError(t, err2)
+			require.Len(t, infos, 1)
+			require.Equal(t, entry, byts[infos[0].Offset:infos[0].Offset+infos[0].Size])
+
+			var sampleCount int
+
+			if ca == "mp4" {
+				var boxes []*mp4.BoxInfoWithPayload
+				boxes, err2 = mp4.ExtractBoxWithPayload(bytes.NewReader(byts), nil, mp4.BoxPath{
+					mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMinf(),
+					mp4.BoxTypeStbl(), mp4.BoxTypeStsz(),
+				})
+				require.NoError(t, err2)
+				require.Len(t, boxes, 1)
+				sampleCount = int(boxes[0].Payload.(*mp4.Stsz).SampleCount)
+			} else {
+				var parts fmp4.Parts
+				err2 = parts.Unmarshal(byts)
+				require.NoError(t, err2)
+
+				for _, part := range parts {
+					for _, track := range part.Tracks {
+						sampleCount += len(track.Samples)
+					}
+				}
+			}
+
+			require.Equal(t, 4, sampleCount)
+		})
+	}
+}
+
+func TestMP4WriterFragmentedKeyFrames(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.mp4")
+
+	forma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	w, err := NewMP4Writer(fpath, forma)
+	require.NoError(t, err)
+
+	// split NALUs into FU-A packets
+	enc := &rtph264.Encoder{
+		PayloadType:       96,
+		PayloadMaxSize:    100,
+		PacketizationMode: 1,
+	}
+	err = enc.Init()
+	require.NoError(t, err)
+
+	idr := append([]byte{5}, bytes.Repeat([]byte{1, 2, 3, 4}, 250)...)
+	nonIDR := append([]byte{1}, bytes.Repeat([]byte{5, 6, 7, 8}, 100)...)
+
+	for i, nalu := range [][]byte{idr, nonIDR, idr, nonIDR} {
+		au := [][]byte{nalu}
+		if nalu[0] == 5 {
+			au = [][]byte{test.FormatH264.SPS, test.FormatH264.PPS, nalu}
+		}
+
+		pkts, err2 := enc.Encode(au)
+		require.NoError(t, err2)
+		require.Greater(t, len(pkts), 1)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(i * 3000)
+			err2 = w.WriteRTP(forma, pkt)
+			require.NoError(t, err2)
+		}
+	}
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	f, err := os.Open(fpath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	boxes, err := mp4.ExtractBoxWithPayload(f, nil, mp4.BoxPath{
+		mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMinf(),
+		mp4.BoxTypeStbl(), mp4.BoxTypeStsz(),
+	})
+	require.NoError(t, err)
+	require.Len(t, boxes, 1)
+
+	idrSize := uint32(4 + len(test.FormatH264.SPS) + 4 + len(test.FormatH264.PPS) + 4 + len(idr))
+	nonIDRSize := uint32(4 + len(nonIDR))
+
+	require.Equal(t, []uint32{idrSize, nonIDRSize, idrSize, nonIDRSize},
+		boxes[0].Payload.(*mp4.Stsz).EntrySize)
+}
+
+func TestMP4WriterAudio(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.mp4")
+
+	aacForma := &rtspformat.MPEG4Audio{
+		PayloadTyp: 96,
+		Config: &mpeg4audio.Config{
+			Type:         2,
+			SampleRate:   44100,
+			ChannelCount: 2,
+		},
+		SizeLength:       13,
+		IndexLength:      3,
+		IndexDeltaLength: 3,
+	}
+
+	opusForma := &rtspformat.Opus{
+		PayloadTyp:   97,
+		ChannelCount: 2,
+	}
+
+	w, err := NewMP4Writer(fpath, aacForma, opusForma)
+	require.NoError(t, err)
+
+	aacEnc, err := aacForma.CreateEncoder()
+	require.NoError(t, err)
+
+	opusEnc, err := opusForma.CreateEncoder()
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		pkts, err2 := aacEnc.Encode([][]byte{{1, 2, 3, 4}, {5, 6, 7, 8}})
+		require.NoError(t, err2)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(i * 2 * mpeg4audio.SamplesPerAccessUnit)
+			err2 = w.WriteRTP(aacForma, pkt)
+			require.NoError(t, err2)
+		}
+
+		pkt, err2 := opusEnc.Encode([]byte{0xfc, 1, 2, 3}) // 20ms, stereo
+		require.NoError(t, err2)
+
+		pkt.Timestamp = uint32(i * 960)
+		err2 = w.WriteRTP(opusForma, pkt)
+		require.NoError(t, err2)
+	}
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	f, err := os.Open(fpath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	stbl := mp4.BoxPath{
+		mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMinf(),
+		mp4.BoxTypeStbl(),
+	}
+
+	boxes, err := mp4.ExtractBoxWithPayload(f, nil, append(stbl, mp4.BoxTypeStsd(), mp4.BoxTypeMp4a(),
+		mp4.BoxTypeEsds()))
+	require.NoError(t, err)
+	require.Len(t, boxes, 1)
+
+	boxes, err = mp4.ExtractBoxWithPayload(f, nil, append(stbl, mp4.BoxTypeStsd(), mp4.BoxTypeOpus(),
+		mp4.BoxTypeDOps()))
+	require.NoError(t, err)
+	require.Len(t, boxes, 1)
+	require.Equal(t, uint8(2), boxes[0].Payload.(*mp4.DOps).OutputChannelCount)
+
+	boxes, err = mp4.ExtractBoxWithPayload(f, nil, append(stbl, mp4.BoxTypeStsz()))
+	require.NoError(t, err)
+	require.Len(t, boxes, 2)
+	require.Equal(t, uint32(6), boxes[0].Payload.(*mp4.Stsz).SampleCount)
+	require.Equal(t, uint32(3), boxes[1].Payload.(*mp4.Stsz).SampleCount)
+}
+
+func TestMP4WriterFragmented(t *testing.T) {
+	var buf bytes.Buffer
+
+	videoForma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	audioForma := &rtspformat.MPEG4Audio{
+		PayloadTyp: 97,
+		Config: &mpeg4audio.Config{
+			Type:         2,
+			SampleRate:   44100,
+			ChannelCount: 2,
+		},
+		SizeLength:       13,
+		IndexLength:      3,
+		IndexDeltaLength: 3,
+	}
+
+	w, err := NewFragmentedMP4Writer(&buf, 200*time.Millisecond, videoForma, audioForma)
+	require.NoError(t, err)
+
+	videoEnc, err := videoForma.CreateEncoder()
+	require.NoError(t, err)
+
+	audioEnc, err := audioForma.CreateEncoder()
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		pkts, err2 := videoEnc.Encode([][]byte{
+			test.FormatH264.SPS,
+			test.FormatH264.PPS,
+			{5, 1}, // IDR
+		})
+		require.NoError(t, err2)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(i * 9000)
+			err2 = w.WriteRTP(videoForma, pkt)
+			require.NoError(t, err2)
+		}
+
+		pkts, err2 = audioEnc.Encode([][]byte{{1, 2, 3, 4}, {5, 6, 7, 8}})
+		require.NoError(t, err2)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(i * 4410)
+			err2 = w.WriteRTP(audioForma, pkt)
+			require.NoError(t, err2)
+		}
+	}
+
+	// output is produced before Close()
+	require.NotZero(t, buf.Len())
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	var init fmp4.Init
+	err = init.Unmarshal(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, init.Tracks, 2)
+	require.Equal(t, test.FormatH264.SPS, init.Tracks[0].Codec.(*fmp4.CodecH264).SPS)
+
+	var parts fmp4.Parts
+	err = parts.Unmarshal(buf.Bytes())
+	require.NoError(t, err)
+	require.Greater(t, len(parts), 1)
+
+	sampleCount := make(map[int]int)
+	for _, part := range parts {
+		for _, track := range part.Tracks {
+			sampleCount[track.ID] += len(track.Samples)
+		}
+	}
+	require.Equal(t, map[int]int{1: 10, 2: 20}, sampleCount)
+}
+
+func TestMP4WriterFragmentedTrackOffset(t *testing.T) {
+	var buf bytes.Buffer
+
+	videoForma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	audioForma := &rtspformat.G711{
+		PayloadTyp:   8,
+		MULaw:        false,
+		SampleRate:   8000,
+		ChannelCount: 1,
+	}
+
+	w, err := NewFragmentedMP4Writer(&buf, 200*time.Millisecond)
+	require.NoError(t, err)
+
+	err = w.RegisterSession(&description.Session{Medias: []*description.Media{
+		{
+			Type:    description.MediaTypeVideo,
+			Formats: []rtspformat.Format{videoForma},
+		},
+		{
+			Type:    description.MediaTypeAudio,
+			Formats: []rtspformat.Format{audioForma},
+		},
+	}})
+	require.NoError(t, err)
+
+	videoEnc, err := videoForma.CreateEncoder()
+	require.NoError(t, err)
+
+	audioEnc, err := audioForma.CreateEncoder()
+	require.NoError(t, err)
+
+	ntp := time.Date(2010, 11, 12, 13, 14, 15, 0, time.UTC)
+
+	// audio starts 500ms after video
+	for i := 0; i < 10; i++ {
+		pkts, err2 := videoEnc.Encode([][]byte{
+			test.FormatH264.SPS,
+			test.FormatH264.PPS,
+			{5, 1}, // IDR
+		})
+		require.NoError(t, err2)
+
+		for _, pkt := range pkts {
+			err2 = w.WriteRTPPacketAt(pkt, ntp.Add(time.Duration(i)*100*time.Millisecond), int64(i*9000))
+			require.NoError(t, err2)
+		}
+
+		pkts, err2 = audioEnc.Encode(make([]byte, 800))
+		require.NoError(t, err2)
+
+		for _, pkt := range pkts {
+			err2 = w.WriteRTPPacketAt(pkt, ntp.Add(500*time.Millisecond+time.Duration(i)*100*time.Millisecond),
+				int64(4000+i*800))
+			require.NoError(t, err2)
+		}
+	}
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	var parts fmp4.Parts
+	err = parts.Unmarshal(buf.Bytes())
+	require.NoError(t, err)
+
+	firstBaseTime := make(map[int]uint64)
+	for _, part := range parts {
+		for _, track := range part.Tracks {
+			if _, ok := firstBaseTime[track.ID]; !ok {
+				firstBaseTime[track.ID] = track.BaseTime
+			}
+		}
+	}
+	require.Equal(t, map[int]uint64{1: 0, 2: 4000}, firstBaseTime)
+}
+
+func TestMP4WriterTimestampWraparound(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.mp4")
+
+	forma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	w, err := NewMP4Writer(fpath, forma)
+	require.NoError(t, err)
+
+	enc, err := forma.CreateEncoder()
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		pkts, err2 := enc.Encode([][]byte{
+			test.FormatH264.SPS,
+			test.FormatH264.PPS,
+			{5, 1}, // IDR
+		})
+		require.NoError(t, err2)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = 0xFFFFFFFF - 4000 + uint32(i*3000)
+			err2 = w.WriteRTP(forma, pkt)
+			require.NoError(t, err2)
+		}
+	}
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	f, err := os.Open(fpath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	boxes, err := mp4.ExtractBoxWithPayload(f, nil, mp4.BoxPath{
+		mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(),
+		mp4.BoxTypeMinf(), mp4.BoxTypeStbl(), mp4.BoxTypeStts(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []mp4.SttsEntry{{SampleCount: 4, SampleDelta: 3000}}, boxes[0].Payload.(*mp4.Stts).Entries)
+}
+
+func TestMP4WriterMemoryLimit(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var outputs [][]byte
+
+	for _, limit := range []int64{0, 100} {
+		fpath := filepath.Join(dir, "out.mp4")
+
+		forma := &rtspformat.H264{
+			PayloadTyp:        96,
+			PacketizationMode: 1,
+		}
+
+		w, err2 := NewMP4Writer(fpath, forma)
+		require.NoError(t, err2)
+
+		w.SetStartTime(time.Date(2010, 11, 12, 13, 14, 15, 0, time.UTC))
+		w.SetMemoryLimit(limit)
+
+		writeTestH264(t, w, forma, 10)
+
+		err2 = w.Close()
+		require.NoError(t, err2)
+
+		byts, err2 := os.ReadFile(fpath)
+		require.NoError(t, err2)
+		outputs = append(outputs, byts)
+	}
+
+	require.Equal(t, outputs[0], outputs[1])
+}
+
+func TestMP4WriterG711(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.mp4")
+
+	forma := &rtspformat.G711{
+		PayloadTyp:   0,
+		MULaw:        true,
+		SampleRate:   8000,
+		ChannelCount: 1,
+	}
+
+	w, err := NewMP4Writer(fpath, forma)
+	require.NoError(t, err)
+
+	enc, err := forma.CreateEncoder()
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		pkts, err2 := enc.Encode(bytes.Repeat([]byte{1, 2, 3, 4}, 40))
+		require.NoError(t, err2)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(i * 160)
+			err2 = w.WriteRTP(forma, pkt)
+			require.NoError(t, err2)
+		}
+	}
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	f, err := os.Open(fpath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// samples are decoded into 16-bit LPCM
+	boxes, err := mp4.ExtractBoxWithPayload(f, nil, mp4.BoxPath{
+		mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(),
+		mp4.BoxTypeMinf(), mp4.BoxTypeStbl(), mp4.BoxTypeStsz(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint32{320, 320, 320}, boxes[0].Payload.(*mp4.Stsz).EntrySize)
+}
+
+func TestMP4WriterContext(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	forma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	w, err := NewMP4Writer(filepath.Join(dir, "out.mp4"), forma)
+	require.NoError(t, err)
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	w.SetContext(ctx)
+
+	var progress []Progress
+	w.SetOnProgress(func(p Progress) {
+		progress = append(progress, p)
+	})
+
+	writeTestH264(t, w, forma, 2)
+
+	require.Equal(t, []Progress{
+		{Samples: 1, Bytes: 43, PTS: 0},
+		{Samples: 2, Bytes: 86, PTS: 1 * time.Second},
+	}, progress)
+	require.Equal(t, progress[1], w.Progress())
+
+	ctxCancel()
+
+	err = w.WriteRTP(forma, &rtp.Packet{
+		Header: rtp.Header{
+			Version:     2,
+			PayloadType: 96,
+		},
+		Payload: []byte{5, 1},
+	})
+	require.ErrorIs(t, err, context.Canceled)
+
+	err = w.Close()
+	require.NoError(t, err)
+}
+
+func TestMP4WriterFromSDP(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.mp4")
+
+	_, err = NewMP4WriterFromSDP(fpath, []byte("invalid"))
+	require.Error(t, err)
+
+	// parameter sets are not in the SDP
+	w, err := NewMP4WriterFromSDP(fpath, []byte("v=0\r\n"+
+		"o=- 0 0 IN IP4 127.0.0.1\r\n"+
+		"s=-\r\n"+
+		"t=0 0\r\n"+
+		"m=video 0 RTP/AVP 96\r\n"+
+		"a=rtpmap:96 H265/90000\r\n"))
+	require.NoError(t, err)
+
+	forma := &rtspformat.H265{PayloadTyp: 96}
+
+	enc, err := forma.CreateEncoder()
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		pkts, err2 := enc.Encode([][]byte{
+			test.FormatH265.VPS,
+			test.FormatH265.SPS,
+			test.FormatH265.PPS,
+			{0x26, 0x01, 1}, // IDR
+		})
+		require.NoError(t, err2)
+
+		for _, pkt := range pkts {
+			pkt.Timestamp = uint32(i * 3000)
+			err2 = w.WriteRTPPacket(pkt)
+			require.NoError(t, err2)
+		}
+	}
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	f, err := os.Open(fpath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	boxes, err := mp4.ExtractBoxWithPayload(f, nil, mp4.BoxPath{
+		mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMinf(),
+		mp4.BoxTypeStbl(), mp4.BoxTypeStsd(), mp4.BoxTypeHev1(), mp4.BoxTypeHvcC(),
+	})
+	require.NoError(t, err)
+	require.Len(t, boxes, 1)
+
+	var sps []byte
+	for _, arr := range boxes[0].Payload.(*mp4.HvcC).NaluArrays {
+		if arr.NaluType == 33 {
+			sps = arr.Nalus[0].NALUnit
+		}
+	}
+	require.Equal(t, test.FormatH265.SPS, sps)
+}
+
+func TestMP4WriterWriteRTPPacketAt(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-rtptomp4")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "out.mp4")
+
+	forma := &rtspformat.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	w, err := NewMP4WriterFromSession(fpath, &description.Session{Medias: []*description.Media{{
+		Type:    description.MediaTypeVideo,
+		Formats: []rtspformat.Format{forma},
+	}}})
+	require.NoError(t, err)
+
+	enc, err := forma.CreateEncoder()
+	require.NoError(t, err)
+
+	ntp := time.Date(2010, 11, 12, 13, 14, 15, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		pkts, err2 := enc.Encode([][]byte{
+			test.FormatH264.SPS,
+			test.FormatH264.PPS,
+			{5, 1}, // IDR
+		})
+		require.NoError(t, err2)
+
+		// RTP timestamps are ignored
+		for _, pkt := range pkts {
+			pkt.Timestamp = 0
+			err2 = w.WriteRTPPacketAt(pkt, ntp.Add(time.Duration(i)*2*time.Second), int64(i*180000))
+			require.NoError(t, err2)
+		}
+	}
+
+	err = w.WriteRTPPackets([]*rtp.Packet{{Header: rtp.Header{PayloadType: 97}}})
+	require.EqualError(t, err, "payload type 97 is not registered")
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	f, err := os.Open(fpath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	boxes, err := mp4.ExtractBoxWithPayload(f, nil, mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeMvhd()})
+	require.NoError(t, err)
+	require.Equal(t, uint32(timeToMP4(ntp)), boxes[0].Payload.(*mp4.Mvhd).CreationTimeV0)
+
+	boxes, err = mp4.ExtractBoxWithPayload(f, nil, mp4.BoxPath{
+		mp4.BoxTypeMoov(), mp4.BoxTypeTrak(), mp4.BoxTypeMdia(), mp4.BoxTypeMinf(),
+		mp4.BoxTypeStbl(), mp4.BoxTypeStts(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []mp4.SttsEntry{{SampleCount: 3, SampleDelta: 180000}}, boxes[0].Payload.(*mp4.Stts).Entries)
+}
@@ -0,0 +1,794 @@
+package rtptomp4
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	rtspformat "github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/ac3"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/av1"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg1audio"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4video"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/opus"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/vp9"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+
+	"github.com/flynnletford/mediamtx/src/formatprocessor"
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+func mpeg1audioChannelCount(cm mpeg1audio.ChannelMode) int {
+	switch cm {
+	case mpeg1audio.ChannelModeStereo,
+		mpeg1audio.ChannelModeJointStereo,
+		mpeg1audio.ChannelModeDualChannel:
+		return 2
+
+	default:
+		return 1
+	}
+}
+
+type writerTrack struct {
+	w        *MP4Writer
+	format   rtspformat.Format
+	id       int
+	metadata trackMetadata
+
+	// the processor owns the RTP decoder of the track, which must survive
+	// across packets in order to reassemble fragmented units.
+	processor        formatprocessor.Processor
+	codec            fmp4.Codec
+	writeUnit        func(unit.Unit) error
+	timestampDecoder timestampDecoder
+
+	firstReceived bool
+	ssrc          uint32
+	ptsOffset     int64
+
+	// data from RTCP sender reports
+	srReceived bool
+	srNTP      time.Time
+	srRTP      uint32
+}
+
+func (t *writerTrack) initialize(parent logger.Writer) error {
+	switch forma := t.format.(type) {
+	case *rtspformat.AV1:
+		codec := &fmp4.CodecAV1{}
+		t.codec = codec
+
+		firstReceived := false
+
+		t.writeUnit = func(u unit.Unit) error {
+			tunit := u.(*unit.AV1)
+			if tunit.TU == nil {
+				return nil
+			}
+
+			for _, obu := range tunit.TU {
+				var h av1.OBUHeader
+				err := h.Unmarshal(obu)
+				if err != nil {
+					return err
+				}
+
+				if h.Type == av1.OBUTypeSequenceHeader {
+					codec.SequenceHeader = obu
+				}
+			}
+
+			if !firstReceived {
+				// wait for the sequence header and for a random access point
+				if codec.SequenceHeader == nil || !av1.IsRandomAccess2(tunit.TU) {
+					return nil
+				}
+				firstReceived = true
+			}
+
+			var sampl fmp4.PartSample
+			err := sampl.FillAV1(tunit.TU)
+			if err != nil {
+				return fmt.Errorf("failed to fill MP4 sample: %w", err)
+			}
+
+			return t.writeSample(tunit.PTS, &sampl)
+		}
+
+	case *rtspformat.VP9:
+		codec := &fmp4.CodecVP9{}
+		t.codec = codec
+
+		firstReceived := false
+
+		t.writeUnit = func(u unit.Unit) error {
+			tunit := u.(*unit.VP9)
+			if tunit.Frame == nil {
+				return nil
+			}
+
+			var h vp9.Header
+			err := h.Unmarshal(tunit.Frame)
+			if err != nil {
+				return err
+			}
+
+			randomAccess := !h.NonKeyFrame
+
+			if randomAccess {
+				codec.Width = h.Width()
+				codec.Height = h.Height()
+				codec.Profile = h.Profile
+				codec.BitDepth = h.ColorConfig.BitDepth
+				codec.ChromaSubsampling = h.ChromaSubsampling()
+				codec.ColorRange = h.ColorConfig.ColorRange
+			}
+
+			if !firstReceived {
+				if !randomAccess {
+					return nil
+				}
+				firstReceived = true
+			}
+
+			return t.writeSample(tunit.PTS, &fmp4.PartSample{
+				IsNonSyncSample: !randomAccess,
+				Payload:         tunit.Frame,
+			})
+		}
+
+	case *rtspformat.VP8:
+		return fmt.Errorf("VP8 can't be stored into MP4, use WebMWriter instead")
+
+	case *rtspformat.H265:
+		// parameters are replaced by the ones found in the bitstream,
+		// since the ones in SDP are often missing or outdated.
+		vps, sps, pps := forma.SafeParams()
+
+		codec := &fmp4.CodecH265{
+			VPS: vps,
+			SPS: sps,
+			PPS: pps,
+		}
+		t.codec = codec
+
+		var dtsExtractor *h265.DTSExtractor
+
+		t.writeUnit = func(u unit.Unit) error {
+			tunit := u.(*unit.H265)
+			if tunit.AU == nil {
+				return nil
+			}
+
+			randomAccess := false
+
+			for _, nalu := range tunit.AU {
+				typ := h265.NALUType((nalu[0] >> 1) & 0b111111)
+
+				switch typ {
+				case h265.NALUType_VPS_NUT:
+					codec.VPS = nalu
+
+				case h265.NALUType_SPS_NUT:
+					codec.SPS = nalu
+
+				case h265.NALUType_PPS_NUT:
+					codec.PPS = nalu
+
+				case h265.NALUType_IDR_W_RADL, h265.NALUType_IDR_N_LP, h265.NALUType_CRA_NUT:
+					randomAccess = true
+				}
+			}
+
+			if dtsExtractor == nil {
+				// wait for parameters and for a random access point
+				if codec.VPS == nil || codec.SPS == nil || codec.PPS == nil || !randomAccess {
+					return nil
+				}
+				dtsExtractor = &h265.DTSExtractor{}
+				dtsExtractor.Initialize()
+			}
+
+			dts, err := dtsExtractor.Extract(tunit.AU, tunit.PTS)
+			if err != nil {
+				return err
+			}
+
+			var sampl fmp4.PartSample
+			err = sampl.FillH265(int32(tunit.PTS-dts), tunit.AU)
+			if err != nil {
+				return fmt.Errorf("failed to fill MP4 sample: %w", err)
+			}
+
+			return t.writeSample(dts, &sampl)
+		}
+
+	case *rtspformat.H264:
+		// parameters are replaced by the ones found in the bitstream,
+		// since the ones in SDP are often missing or outdated.
+		sps, pps := forma.SafeParams()
+
+		codec := &fmp4.CodecH264{
+			SPS: sps,
+			PPS: pps,
+		}
+		t.codec = codec
+
+		var dtsExtractor *h264.DTSExtractor
+
+		t.writeUnit = func(u unit.Unit) error {
+			tunit := u.(*unit.H264)
+			if tunit.AU == nil {
+				return nil
+			}
+
+			randomAccess := false
+
+			for _, nalu := range tunit.AU {
+				typ := h264.NALUType(nalu[0] & 0x1F)
+
+				switch typ {
+				case h264.NALUTypeSPS:
+					codec.SPS = nalu
+
+				case h264.NALUTypePPS:
+					codec.PPS = nalu
+
+				case h264.NALUTypeIDR:
+					randomAccess = true
+				}
+			}
+
+			if dtsExtractor == nil {
+				// wait for parameters and for a random access point
+				if codec.SPS == nil || codec.PPS == nil || !randomAccess {
+					return nil
+				}
+				dtsExtractor = &h264.DTSExtractor{}
+				dtsExtractor.Initialize()
+			}
+
+			dts, err := dtsExtractor.Extract(tunit.AU, tunit.PTS)
+			if err != nil {
+				return err
+			}
+
+			var sampl fmp4.PartSample
+			err = sampl.FillH264(int32(tunit.PTS-dts), tunit.AU)
+			if err != nil {
+				return fmt.Errorf("failed to fill MP4 sample: %w", err)
+			}
+
+			return t.writeSample(dts, &sampl)
+		}
+
+	case *rtspformat.MPEG4Video:
+		config := forma.SafeParams()
+
+		if config == nil {
+			config = formatprocessor.MPEG4VideoDefaultConfig
+		}
+
+		codec := &fmp4.CodecMPEG4Video{
+			Config: config,
+		}
+		t.codec = codec
+
+		firstReceived := false
+		var lastPTS int64
+
+		t.writeUnit = func(u unit.Unit) error {
+			tunit := u.(*unit.MPEG4Video)
+			if tunit.Frame == nil {
+				return nil
+			}
+
+			randomAccess := bytes.Contains(tunit.Frame, []byte{0, 0, 1, byte(mpeg4video.GroupOfVOPStartCode)})
+
+			if bytes.HasPrefix(tunit.Frame, []byte{0, 0, 1, byte(mpeg4video.VisualObjectSequenceStartCode)}) {
+				end := bytes.Index(tunit.Frame[4:], []byte{0, 0, 1, byte(mpeg4video.GroupOfVOPStartCode)})
+				if end >= 0 {
+					codec.Config = tunit.Frame[:end+4]
+				}
+			}
+
+			if !firstReceived {
+				if !randomAccess {
+					return nil
+				}
+				firstReceived = true
+			} else if tunit.PTS < lastPTS {
+				return fmt.Errorf("MPEG-4 Video streams with B-frames are not supported (yet)")
+			}
+			lastPTS = tunit.PTS
+
+			return t.writeSample(tunit.PTS, &fmp4.PartSample{
+				Payload:         tunit.Frame,
+				IsNonSyncSample: !randomAccess,
+			})
+		}
+
+	case *rtspformat.MPEG1Video:
+		codec := &fmp4.CodecMPEG1Video{
+			Config: formatprocessor.MPEG1VideoDefaultConfig,
+		}
+		t.codec = codec
+
+		firstReceived := false
+		var lastPTS int64
+
+		t.writeUnit = func(u unit.Unit) error {
+			tunit := u.(*unit.MPEG1Video)
+			if tunit.Frame == nil {
+				return nil
+			}
+
+			randomAccess := bytes.Contains(tunit.Frame, []byte{0, 0, 1, 0xB8})
+
+			if bytes.HasPrefix(tunit.Frame, []byte{0, 0, 1, 0xB3}) {
+				end := bytes.Index(tunit.Frame[4:], []byte{0, 0, 1, 0xB8})
+				if end >= 0 {
+					codec.Config = tunit.Frame[:end+4]
+				}
+			}
+
+			if !firstReceived {
+				if !randomAccess {
+					return nil
+				}
+				firstReceived = true
+			} else if tunit.PTS < lastPTS {
+				return fmt.Errorf("MPEG-1 Video streams with B-frames are not supported (yet)")
+			}
+			lastPTS = tunit.PTS
+
+			return t.writeSample(tunit.PTS, &fmp4.PartSample{
+				Payload:         tunit.Frame,
+				IsNonSyncSample: !randomAccess,
+			})
+		}
+
+	case *rtspformat.MJPEG:
+		codec := &fmp4.CodecMJPEG{
+			Width:  800,
+			Height: 600,
+		}
+		t.codec = codec
+
+		parsed := false
+
+		t.writeUnit = func(u unit.Unit) error {
+			tunit := u.(*unit.MJPEG)
+			if tunit.Frame == nil {
+				return nil
+			}
+
+			if !parsed {
+				if tunit.Width == 0 || tunit.Height == 0 {
+					return fmt.Errorf("unable to extract MJPEG frame size")
+				}
+				parsed = true
+				codec.Width = tunit.Width
+				codec.Height = tunit.Height
+			}
+
+			return t.writeSample(tunit.PTS, &fmp4.PartSample{
+				Payload: tunit.Frame,
+			})
+		}
+
+	case *rtspformat.Opus:
+		t.codec = &fmp4.CodecOpus{
+			ChannelCount: forma.ChannelCount,
+		}
+
+		t.writeUnit = func(u unit.Unit) error {
+			tunit := u.(*unit.Opus)
+			if tunit.Packets == nil {
+				return nil
+			}
+
+			pts := tunit.PTS
+
+			for _, packet := range tunit.Packets {
+				err := t.writeSample(pts, &fmp4.PartSample{
+					Payload: packet,
+				})
+				if err != nil {
+					return err
+				}
+
+				pts += opus.PacketDuration2(packet)
+			}
+
+			return nil
+		}
+
+	case *rtspformat.MPEG4Audio:
+		// the AudioSpecificConfig is taken from the format,
+		// or from the stream in case of LATM with in-band configuration.
+		co := forma.GetConfig()
+		if co == nil {
+			if !forma.LATM {
+				return fmt.Errorf("MPEG-4 Audio tracks without configuration are not supported")
+			}
+			co = &formatprocessor.MPEG4AudioLATMDefaultConfig
+		}
+
+		codec := &fmp4.CodecMPEG4Audio{
+			Config: *co,
+		}
+		t.codec = codec
+		clockRate := int64(forma.ClockRate())
+
+		t.writeUnit = func(u unit.Unit) error {
+			tunit := u.(*unit.MPEG4Audio)
+			if tunit.AUs == nil {
+				return nil
+			}
+
+			if tunit.Config != nil {
+				codec.Config = *tunit.Config
+			}
+
+			for i, au := range tunit.AUs {
+				pts := tunit.PTS + int64(i)*mpeg4audio.SamplesPerAccessUnit*clockRate/int64(codec.Config.SampleRate)
+
+				err := t.writeSample(pts, &fmp4.PartSample{
+					Payload: au,
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+	case *rtspformat.MPEG1Audio:
+		codec := &fmp4.CodecMPEG1Audio{
+			SampleRate:   32000,
+			ChannelCount: 2,
+		}
+		t.codec = codec
+
+		parsed := false
+
+		t.writeUnit = func(u unit.Unit) error {
+			tunit := u.(*unit.MPEG1Audio)
+			if tunit.Frames == nil {
+				return nil
+			}
+
+			pts := tunit.PTS
+
+			for _, frame := range tunit.Frames {
+				var h mpeg1audio.FrameHeader
+				err := h.Unmarshal(frame)
+				if err != nil {
+					return err
+				}
+
+				if !parsed {
+					parsed = true
+					codec.SampleRate = h.SampleRate
+					codec.ChannelCount = mpeg1audioChannelCount(h.ChannelMode)
+				}
+
+				err = t.writeSample(pts, &fmp4.PartSample{
+					Payload: frame,
+				})
+				if err != nil {
+					return err
+				}
+
+				pts += int64(h.SampleCount()) * int64(t.format.ClockRate()) / int64(h.SampleRate)
+			}
+
+			return nil
+		}
+
+	case *rtspformat.AC3:
+		codec := &fmp4.CodecAC3{
+			SampleRate:   forma.SampleRate,
+			ChannelCount: forma.ChannelCount,
+			Fscod:        0,
+			Bsid:         8,
+			Bsmod:        0,
+			Acmod:        7,
+			LfeOn:        true,
+			BitRateCode:  7,
+		}
+		t.codec = codec
+
+		parsed := false
+
+		t.writeUnit = func(u unit.Unit) error {
+			tunit := u.(*unit.AC3)
+			if tunit.Frames == nil {
+				return nil
+			}
+
+			for i, frame := range tunit.Frames {
+				var syncInfo ac3.SyncInfo
+				err := syncInfo.Unmarshal(frame)
+				if err != nil {
+					return fmt.Errorf("invalid AC-3 frame: %w", err)
+				}
+
+				var bsi ac3.BSI
+				err = bsi.Unmarshal(frame[5:])
+				if err != nil {
+					return fmt.Errorf("invalid AC-3 frame: %w", err)
+				}
+
+				if !parsed {
+					parsed = true
+					codec.SampleRate = syncInfo.SampleRate()
+					codec.ChannelCount = bsi.ChannelCount()
+					codec.Fscod = syncInfo.Fscod
+					codec.Bsid = bsi.Bsid
+					codec.Bsmod = bsi.Bsmod
+					codec.Acmod = bsi.Acmod
+					codec.LfeOn = bsi.LfeOn
+					codec.BitRateCode = syncInfo.Frmsizecod >> 1
+				}
+
+				err = t.writeSample(tunit.PTS+int64(i)*ac3.SamplesPerFrame, &fmp4.PartSample{
+					Payload: frame,
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+	case *rtspformat.G711:
+		// G711 has no MP4 mapping, therefore it is decoded into LPCM.
+		t.codec = &fmp4.CodecLPCM{
+			LittleEndian: false,
+			BitDepth:     16,
+			SampleRate:   forma.SampleRate,
+			ChannelCount: forma.ChannelCount,
+		}
+
+		t.writeUnit = func(u unit.Unit) error {
+			tunit := u.(*unit.G711)
+			if tunit.Samples == nil {
+				return nil
+			}
+
+			return t.writeSample(tunit.PTS, &fmp4.PartSample{
+				Payload: tunit.LPCM,
+			})
+		}
+
+	case *rtspformat.G722:
+		// G722 has no MP4 mapping, therefore it is decoded into LPCM.
+		// The sample rate is 16khz, while the clock rate is 8khz.
+		t.codec = &fmp4.CodecLPCM{
+			LittleEndian: false,
+			BitDepth:     16,
+			SampleRate:   16000,
+			ChannelCount: 1,
+		}
+
+		t.writeUnit = func(u unit.Unit) error {
+			tunit := u.(*unit.G722)
+			if tunit.Frame == nil {
+				return nil
+			}
+
+			return t.writeSample(tunit.PTS, &fmp4.PartSample{
+				Payload: tunit.LPCM,
+			})
+		}
+
+	case *rtspformat.LPCM:
+		t.codec = &fmp4.CodecLPCM{
+			LittleEndian: false,
+			BitDepth:     forma.BitDepth,
+			SampleRate:   forma.SampleRate,
+			ChannelCount: forma.ChannelCount,
+		}
+
+		t.writeUnit = func(u unit.Unit) error {
+			tunit := u.(*unit.LPCM)
+			if tunit.Samples == nil {
+				return nil
+			}
+
+			return t.writeSample(tunit.PTS, &fmp4.PartSample{
+				Payload: tunit.Samples,
+			})
+		}
+
+	case *rtspformat.Generic:
+		if !formatprocessor.IsH266(forma) {
+			return fmt.Errorf("unsupported format type: %T", forma)
+		}
+
+		// H266 is not supported by the MP4 library, therefore a placeholder codec is used
+		// and its sample entry is replaced by a vvc1 sample entry.
+		codec := &fmp4.CodecVP9{
+			Width:             1920,
+			Height:            1080,
+			Profile:           0,
+			BitDepth:          8,
+			ChromaSubsampling: 1,
+		}
+		t.codec = codec
+
+		var vps, sps, pps []byte
+		started := false
+
+		t.writeUnit = func(u unit.Unit) error {
+			tunit := u.(*unit.H266)
+			if tunit.AU == nil {
+				return nil
+			}
+
+			vps2, sps2, pps2 := formatprocessor.H266Params(tunit.AU)
+			if sps2 != nil && pps2 != nil &&
+				(!bytes.Equal(vps, vps2) || !bytes.Equal(sps, sps2) || !bytes.Equal(pps, pps2)) {
+				width, height, err := formatprocessor.H266SPSResolution(sps2)
+				if err != nil {
+					return err
+				}
+
+				entry, err := formatprocessor.H266MP4SampleEntry(vps2, sps2, pps2)
+				if err != nil {
+					return err
+				}
+
+				vps, sps, pps = vps2, sps2, pps2
+				codec.Width, codec.Height = width, height
+				t.metadata.sampleEntry = entry
+			}
+
+			randomAccess := formatprocessor.H266IsRandomAccess(tunit.AU)
+
+			if !started {
+				// wait for parameters and for a random access point
+				if t.metadata.sampleEntry == nil || !randomAccess {
+					return nil
+				}
+				started = true
+			}
+
+			avcc, err := h264.AVCC(tunit.AU).Marshal()
+			if err != nil {
+				return fmt.Errorf("failed to fill MP4 sample: %w", err)
+			}
+
+			// the DTS can't be extracted without parsing slice headers,
+			// therefore it is assumed to be equal to the PTS and
+			// streams with B-frames are not supported.
+			return t.writeSample(tunit.PTS, &fmp4.PartSample{
+				Payload:         avcc,
+				IsNonSyncSample: !randomAccess,
+			})
+		}
+
+	default:
+		return fmt.Errorf("unsupported format type: %T", forma)
+	}
+
+	var err error
+	t.processor, err = formatprocessor.NewWithOptions(udpMaxPayloadSize, t.format, false,
+		formatprocessor.Options{
+			DecodeToLPCM: true,
+		},
+		parent)
+	if err != nil {
+		return fmt.Errorf("failed to create format processor: %w", err)
+	}
+
+	return nil
+}
+
+func (t *writerTrack) writeSenderReport(sr *rtcp.SenderReport) {
+	t.srReceived = true
+	t.srNTP = ntpToTime(sr.NTPTime)
+	t.srRTP = sr.RTPTime
+}
+
+// packetNTP returns the absolute time of a RTP packet.
+// It is computed from RTCP sender reports when available,
+// otherwise the time of arrival is used.
+func (t *writerTrack) packetNTP(ts uint32, now time.Time) time.Time {
+	if !t.srReceived {
+		return now
+	}
+
+	diff := int64(int32(ts - t.srRTP))
+	return t.srNTP.Add(timestampToDuration(diff, t.format.ClockRate()))
+}
+
+// packetError is returned when a RTP packet can't be decoded.
+type packetError struct {
+	err error
+}
+
+// Error implements the error interface.
+func (e *packetError) Error() string {
+	return "failed to process RTP packet: " + e.err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *packetError) Unwrap() error {
+	return e.err
+}
+
+func (t *writerTrack) handleFirstPacket(pkt *rtp.Packet, ntp time.Time) {
+	t.firstReceived = true
+	t.ssrc = pkt.SSRC
+
+	if t.w.firstNTP.IsZero() {
+		t.w.firstNTP = ntp
+
+		if t.w.startTime.IsZero() {
+			t.w.startTime = ntp
+		}
+	}
+}
+
+func (t *writerTrack) writeRTP(pkt *rtp.Packet, now time.Time) error {
+	ntp := t.packetNTP(pkt.Timestamp, now)
+
+	if !t.firstReceived {
+		t.handleFirstPacket(pkt, ntp)
+
+		// tracks are synchronized by starting them
+		// at the absolute time of their first packet.
+		t.ptsOffset = durationToTimestamp(ntp.Sub(t.w.firstNTP), t.format.ClockRate())
+	}
+
+	pts := t.timestampDecoder.decode(pkt.Timestamp) + t.ptsOffset
+
+	return t.writeRTPAt(pkt, ntp, pts)
+}
+
+func (t *writerTrack) writeRTPAt(pkt *rtp.Packet, ntp time.Time, pts int64) error {
+	err := t.w.ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	if !t.firstReceived {
+		t.handleFirstPacket(pkt, ntp)
+	}
+
+	u, err := t.processor.ProcessRTPPacket(pkt, ntp, pts, true)
+	if err != nil {
+		return &packetError{err}
+	}
+
+	return t.writeUnit(u)
+}
+
+func (t *writerTrack) writeSample(dts int64, sampl *fmp4.PartSample) error {
+	err := t.w.output.writeSample(t, dts, sampl)
+	if err != nil {
+		return err
+	}
+
+	t.w.progress.Samples++
+	t.w.progress.Bytes += uint64(len(sampl.Payload))
+	t.w.progress.PTS = timestampToDuration(dts+int64(sampl.PTSOffset), t.format.ClockRate())
+
+	if t.w.onProgress != nil {
+		t.w.onProgress(t.w.progress)
+	}
+
+	return nil
+}
@@ -16,11 +16,13 @@ import (
 	"github.com/flynnletford/mediamtx/src/stream"
 	"github.com/flynnletford/mediamtx/src/test"
 	"github.com/flynnletford/mediamtx/src/unit"
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/stretchr/testify/require"
 )
 
 type dummyPath struct {
+	rtcpFeedback  bool
 	stream        *stream.Stream
 	streamCreated chan struct{}
 }
@@ -45,6 +47,12 @@ func (p *dummyPath) StartPublisher(req defs.PathStartPublisherReq) (*stream.Stre
 		GenerateRTPPackets: true,
 		Parent:             test.NilLogger,
 	}
+
+	if p.rtcpFeedback {
+		p.stream.RTCPFeedbackPeriod = 100 * time.Millisecond
+		p.stream.WriteRTCPFeedback = req.Author.(defs.RTCPFeedbackWriter).WriteRTCPFeedback
+	}
+
 	err := p.stream.Initialize()
 	if err != nil {
 		return nil, err
@@ -144,6 +152,71 @@ func TestServerPublish(t *testing.T) {
 	<-recv
 }
 
+func TestServerPublishRTCPFeedback(t *testing.T) {
+	path := &dummyPath{
+		rtcpFeedback:  true,
+		streamCreated: make(chan struct{}),
+	}
+
+	pathManager := &test.PathManager{
+		AddPublisherImpl: func(_ defs.PathAddPublisherReq) (defs.Path, error) {
+			return path, nil
+		},
+	}
+
+	s := &Server{
+		Address:        "127.0.0.1:8557",
+		ReadTimeout:    conf.Duration(10 * time.Second),
+		WriteTimeout:   conf.Duration(10 * time.Second),
+		WriteQueueSize: 512,
+		Transports:     conf.RTSPTransports{gortsplib.TransportTCP: {}},
+		PathManager:    pathManager,
+		Parent:         test.NilLogger,
+	}
+	err := s.Initialize()
+	require.NoError(t, err)
+	defer s.Close()
+
+	source := gortsplib.Client{}
+
+	media0 := test.UniqueMediaH264()
+
+	err = source.StartRecording(
+		"rtsp://127.0.0.1:8557/teststream",
+		&description.Session{Medias: []*description.Media{media0}})
+	require.NoError(t, err)
+	defer source.Close()
+
+	recv := make(chan *rtcp.ReceiverEstimatedMaximumBitrate, 1)
+
+	source.OnPacketRTCPAny(func(_ *description.Media, pkt rtcp.Packet) {
+		if remb, ok := pkt.(*rtcp.ReceiverEstimatedMaximumBitrate); ok {
+			select {
+			case recv <- remb:
+			default:
+			}
+		}
+	})
+
+	<-path.streamCreated
+
+	err = source.WritePacketRTP(media0, &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         true,
+			PayloadType:    96,
+			SequenceNumber: 123,
+			Timestamp:      45343,
+			SSRC:           563423,
+		},
+		Payload: []byte{5, 2, 3, 4},
+	})
+	require.NoError(t, err)
+
+	remb := <-recv
+	require.Equal(t, []uint32{563423}, remb.SSRCs)
+}
+
 func TestServerRead(t *testing.T) {
 	desc := &description.Session{Medias: []*description.Media{test.MediaH264}}
 
@@ -10,7 +10,9 @@ import (
 
 	"github.com/bluenviron/gortsplib/v4"
 	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
 	"github.com/google/uuid"
+	"github.com/pion/rtcp"
 
 	"github.com/flynnletford/mediamtx/src/auth"
 	"github.com/flynnletford/mediamtx/src/conf"
@@ -375,6 +377,13 @@ func (s *session) APISourceDescribe() defs.APIPathSourceOrReader {
 	return s.APIReaderDescribe()
 }
 
+// WriteRTCPFeedback implements defs.RTCPFeedbackWriter.
+func (s *session) WriteRTCPFeedback(medi *description.Media, pkts []rtcp.Packet) {
+	for _, pkt := range pkts {
+		s.rsession.WritePacketRTCP(medi, pkt) //nolint:errcheck
+	}
+}
+
 // onPacketLost is called by rtspServer.
 func (s *session) onPacketsLost(ctx *gortsplib.ServerHandlerOnPacketsLostCtx) {
 	s.packetsLost.Add(ctx.Lost)
@@ -7,12 +7,14 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
 	"github.com/google/uuid"
 	"github.com/pion/ice/v4"
+	"github.com/pion/rtcp"
 	"github.com/pion/sdp/v3"
 	pwebrtc "github.com/pion/webrtc/v4"
 
@@ -22,7 +24,9 @@ import (
 	"github.com/flynnletford/mediamtx/src/externalcmd"
 	"github.com/flynnletford/mediamtx/src/hooks"
 	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/playback"
 	"github.com/flynnletford/mediamtx/src/protocols/webrtc"
+	"github.com/flynnletford/mediamtx/src/recordstore"
 	"github.com/flynnletford/mediamtx/src/stream"
 )
 
@@ -249,6 +253,10 @@ func (s *session) runPublish() (int, error) {
 }
 
 func (s *session) runRead() (int, error) {
+	if s.req.httpRequest.URL.Query().Has("start") {
+		return s.runReadRecording()
+	}
+
 	ip, _, _ := net.SplitHostPort(s.req.remoteAddr)
 
 	req := defs.PathAccessRequest{
@@ -356,6 +364,133 @@ func (s *session) runRead() (int, error) {
 	}
 }
 
+func (s *session) runReadRecording() (int, error) {
+	query := s.req.httpRequest.URL.Query()
+
+	start, err := time.Parse(time.RFC3339, query.Get("start"))
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("invalid 'start' parameter: %w", err)
+	}
+
+	duration, err := strconv.ParseFloat(query.Get("duration"), 64)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("invalid 'duration' parameter: %w", err)
+	}
+
+	ip, _, _ := net.SplitHostPort(s.req.remoteAddr)
+
+	req := defs.PathAccessRequest{
+		Name:     s.req.pathName,
+		IP:       net.ParseIP(ip),
+		Playback: true,
+		Proto:    auth.ProtocolWebRTC,
+		ID:       &s.uuid,
+	}
+	req.FillFromHTTPRequest(s.req.httpRequest)
+
+	pathConf, err := s.pathManager.FindPathConf(defs.PathFindPathConfReq{
+		AccessRequest: req,
+	})
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	rs := &playback.RecordingStream{
+		PathConf: pathConf,
+		PathName: s.req.pathName,
+		Start:    start,
+		Duration: time.Duration(duration * float64(time.Second)),
+		Parent:   s,
+	}
+	err = rs.Initialize()
+	if err != nil {
+		if errors.Is(err, recordstore.ErrNoSegmentsFound) {
+			return http.StatusNotFound, err
+		}
+		return http.StatusBadRequest, err
+	}
+	defer rs.Close()
+
+	iceServers, err := s.parent.generateICEServers(false)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	pc := &webrtc.PeerConnection{
+		ICEUDPMux:             s.iceUDPMux,
+		ICETCPMux:             s.iceTCPMux,
+		ICEServers:            iceServers,
+		IPsFromInterfaces:     s.ipsFromInterfaces,
+		IPsFromInterfacesList: s.ipsFromInterfacesList,
+		AdditionalHosts:       s.additionalHosts,
+		HandshakeTimeout:      s.handshakeTimeout,
+		TrackGatherTimeout:    s.trackGatherTimeout,
+		STUNGatherTimeout:     s.stunGatherTimeout,
+		Publish:               true,
+		UseAbsoluteTimestamp:  pathConf.UseAbsoluteTimestamp,
+		Log:                   s,
+	}
+
+	err = webrtc.FromStream(rs.Stream, s, pc)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	err = pc.Start()
+	if err != nil {
+		rs.Stream.RemoveReader(s)
+		return http.StatusBadRequest, err
+	}
+	defer pc.Close()
+
+	offer := whipOffer(s.req.offer)
+
+	answer, err := pc.CreateFullAnswer(s.ctx, offer)
+	if err != nil {
+		rs.Stream.RemoveReader(s)
+		return http.StatusBadRequest, err
+	}
+
+	s.writeAnswer(answer)
+
+	go s.readRemoteCandidates(pc)
+
+	err = pc.WaitUntilConnected(s.ctx)
+	if err != nil {
+		rs.Stream.RemoveReader(s)
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	s.pc = pc
+	s.mutex.Unlock()
+
+	s.Log(logger.Info, "is reading recording of path '%s' starting from %v, %s",
+		s.req.pathName, start, defs.FormatsInfo(rs.Stream.ReaderFormats(s)))
+
+	rs.Stream.StartReader(s)
+	defer rs.Stream.RemoveReader(s)
+
+	rs.Play()
+
+	select {
+	case <-pc.Failed():
+		return 0, fmt.Errorf("peer connection closed")
+
+	case err := <-rs.Stream.ReaderError(s):
+		return 0, err
+
+	case <-rs.Done():
+		if err := rs.Err(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("end of recording")
+
+	case <-s.ctx.Done():
+		return 0, fmt.Errorf("terminated")
+	}
+}
+
 func (s *session) writeAnswer(answer *pwebrtc.SessionDescription) {
 	s.req.res <- webRTCNewSessionRes{
 		sx:     s,
@@ -418,6 +553,17 @@ func (s *session) APISourceDescribe() defs.APIPathSourceOrReader {
 	return s.APIReaderDescribe()
 }
 
+// WriteRTCPFeedback implements defs.RTCPFeedbackWriter.
+func (s *session) WriteRTCPFeedback(_ *description.Media, pkts []rtcp.Packet) {
+	s.mutex.RLock()
+	pc := s.pc
+	s.mutex.RUnlock()
+
+	if pc != nil {
+		pc.WriteRTCP(pkts) //nolint:errcheck
+	}
+}
+
 func (s *session) apiItem() *defs.APIWebRTCSession {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -0,0 +1,48 @@
+// Package service contains the integration with the service manager of the operating system.
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/logger"
+)
+
+// Service integrates the process with the service manager of the operating system,
+// that is systemd on Linux and the Service Control Manager on Windows.
+// It does nothing when the process has not been started by a service manager.
+type Service struct {
+	// checks whether the process is working.
+	// When it fails or blocks, watchdog notifications are not sent
+	// and the service manager restarts the process.
+	CheckHealth func() error
+
+	// called when the service manager asks the process to stop.
+	OnStop func()
+
+	Parent logger.Writer
+
+	platform
+}
+
+// Log implements logger.Writer.
+func (s *Service) Log(level logger.Level, format string, args ...interface{}) {
+	s.Parent.Log(level, "[service] "+format, args...)
+}
+
+// checkHealth calls CheckHealth, considering it failed when it doesn't return within timeout.
+func (s *Service) checkHealth(timeout time.Duration) error {
+	res := make(chan error, 1)
+
+	go func() {
+		res <- s.CheckHealth()
+	}()
+
+	select {
+	case err := <-res:
+		return err
+
+	case <-time.After(timeout):
+		return fmt.Errorf("health check timed out")
+	}
+}
@@ -0,0 +1,122 @@
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/logger"
+)
+
+// platform implements the sd_notify protocol of systemd.
+type platform struct {
+	conn      *net.UnixConn
+	ctx       context.Context
+	ctxCancel func()
+	done      chan struct{}
+}
+
+func watchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+
+	// the watchdog may be addressed to another process
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+
+	v, err := strconv.ParseUint(usec, 10, 64)
+	if err != nil || v == 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC: '%s'", usec)
+	}
+
+	// notify twice per period, as recommended by systemd.
+	return time.Duration(v) * time.Microsecond / 2, nil
+}
+
+// Initialize initializes Service and notifies the service manager that the process is ready.
+func (s *Service) Initialize() error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+
+	// abstract namespace
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+
+	interval, err := watchdogInterval()
+	if err != nil {
+		return err
+	}
+
+	s.conn, err = net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+
+	err = s.notify("READY=1")
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+
+	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
+	s.done = make(chan struct{})
+
+	if interval != 0 {
+		s.Log(logger.Info, "watchdog enabled, interval %v", interval)
+		go s.runWatchdog(interval)
+	} else {
+		close(s.done)
+	}
+
+	return nil
+}
+
+// Close notifies the service manager that the process is stopping.
+func (s *Service) Close() {
+	if s.conn == nil {
+		return
+	}
+
+	s.ctxCancel()
+	<-s.done
+
+	s.notify("STOPPING=1") //nolint:errcheck
+	s.conn.Close()
+}
+
+func (s *Service) runWatchdog(interval time.Duration) {
+	defer close(s.done)
+
+	for {
+		select {
+		case <-time.After(interval):
+			err := s.checkHealth(interval)
+			if err != nil {
+				s.Log(logger.Error, "health check failed, skipping watchdog notification: %v", err)
+				continue
+			}
+
+			s.notify("WATCHDOG=1") //nolint:errcheck
+
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Service) notify(state string) error {
+	_, err := s.conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,77 @@
+//go:build !windows
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceNotify(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-service")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	addr := filepath.Join(dir, "notify.sock")
+
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	require.NoError(t, err)
+	defer l.Close()
+
+	t.Setenv("NOTIFY_SOCKET", addr)
+	t.Setenv("WATCHDOG_USEC", "200000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	var healthy atomic.Bool
+	healthy.Store(true)
+
+	s := &Service{
+		CheckHealth: func() error {
+			if !healthy.Load() {
+				return fmt.Errorf("stalled")
+			}
+			return nil
+		},
+		Parent: test.NilLogger,
+	}
+	err = s.Initialize()
+	require.NoError(t, err)
+
+	read := func() string {
+		l.SetReadDeadline(time.Now().Add(500 * time.Millisecond)) //nolint:errcheck
+		buf := make([]byte, 64)
+		n, err2 := l.Read(buf)
+		if err2 != nil {
+			return ""
+		}
+		return string(buf[:n])
+	}
+
+	require.Equal(t, "READY=1", read())
+	require.Equal(t, "WATCHDOG=1", read())
+
+	healthy.Store(false)
+	read()
+	require.Equal(t, "", read())
+
+	s.Close()
+
+	require.Equal(t, "STOPPING=1", read())
+}
+
+func TestServiceNoManager(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	s := &Service{Parent: test.NilLogger}
+	err := s.Initialize()
+	require.NoError(t, err)
+	s.Close()
+}
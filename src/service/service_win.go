@@ -0,0 +1,88 @@
+//go:build windows
+
+package service
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/sys/windows/svc"
+
+	"github.com/flynnletford/mediamtx/src/logger"
+)
+
+// platform implements the handler of the Service Control Manager.
+// The Service Control Manager doesn't provide a watchdog, therefore stalls are not detected;
+// crashes and unexpected exits are handled through the recovery actions of the service.
+type platform struct {
+	stopRequested atomic.Bool
+	chClose       chan struct{}
+	done          chan struct{}
+}
+
+// Initialize initializes Service and notifies the service manager that the process is ready.
+func (s *Service) Initialize() error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+
+	if !isService {
+		return nil
+	}
+
+	s.chClose = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go s.run()
+
+	return nil
+}
+
+// Close notifies the service manager that the process is stopping.
+func (s *Service) Close() {
+	if s.done == nil {
+		return
+	}
+
+	close(s.chClose)
+	<-s.done
+}
+
+func (s *Service) run() {
+	defer close(s.done)
+
+	// the name is ignored by services that run in their own process.
+	err := svc.Run("", s)
+	if err != nil {
+		s.Log(logger.Error, "%v", err)
+	}
+}
+
+// Execute implements svc.Handler.
+func (s *Service) Execute(_ []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+
+			case svc.Stop, svc.Shutdown:
+				if !s.stopRequested.Swap(true) {
+					changes <- svc.Status{State: svc.StopPending}
+					s.OnStop()
+				}
+			}
+
+		case <-s.chClose:
+			// exiting without a request is reported as a failure,
+			// in order to trigger recovery actions.
+			if !s.stopRequested.Load() {
+				return true, 1
+			}
+			return false, 0
+		}
+	}
+}
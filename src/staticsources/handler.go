@@ -12,6 +12,7 @@ import (
 	"github.com/flynnletford/mediamtx/src/defs"
 	"github.com/flynnletford/mediamtx/src/logger"
 	sshls "github.com/flynnletford/mediamtx/src/staticsources/hls"
+	ssrist "github.com/flynnletford/mediamtx/src/staticsources/rist"
 	ssrpicamera "github.com/flynnletford/mediamtx/src/staticsources/rpicamera"
 	ssrtmp "github.com/flynnletford/mediamtx/src/staticsources/rtmp"
 	ssrtsp "github.com/flynnletford/mediamtx/src/staticsources/rtsp"
@@ -21,10 +22,6 @@ import (
 	"github.com/flynnletford/mediamtx/src/stream"
 )
 
-const (
-	retryPause = 5 * time.Second
-)
-
 func emptyTimer() *time.Timer {
 	t := time.NewTimer(0)
 	<-t.C
@@ -116,6 +113,12 @@ func (s *Handler) Initialize() {
 			Parent:      s,
 		}
 
+	case strings.HasPrefix(s.Conf.Source, "rist://"):
+		s.instance = &ssrist.Source{
+			ReadTimeout: s.ReadTimeout,
+			Parent:      s,
+		}
+
 	case strings.HasPrefix(s.Conf.Source, "srt://"):
 		s.instance = &sssrt.Source{
 			ReadTimeout: s.ReadTimeout,
@@ -214,6 +217,7 @@ func (s *Handler) run() {
 
 	recreating := false
 	recreateTimer := emptyTimer()
+	retryPause := time.Duration(s.Conf.SourceRetryPause)
 
 	for {
 		select {
@@ -222,8 +226,11 @@ func (s *Handler) run() {
 			s.instance.Log(logger.Error, err.Error())
 			recreating = true
 			recreateTimer = time.NewTimer(retryPause)
+			retryPause = min(retryPause*2, time.Duration(s.Conf.SourceRetryMaxPause))
 
 		case req := <-s.chInstanceSetReady:
+			// the source is working again, therefore the next failure must be retried quickly.
+			retryPause = time.Duration(s.Conf.SourceRetryPause)
 			s.Parent.StaticSourceHandlerSetReady(s.ctx, req)
 
 		case req := <-s.chInstanceSetNotReady:
@@ -0,0 +1,380 @@
+// Package rist contains the RIST static source.
+package rist
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/multicast"
+	"github.com/bluenviron/gortsplib/v4/pkg/rtpreorderer"
+	mcmpegts "github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/counterdumper"
+	"github.com/flynnletford/mediamtx/src/defs"
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/protocols/mpegts"
+	"github.com/flynnletford/mediamtx/src/restrictnetwork"
+	"github.com/flynnletford/mediamtx/src/stream"
+)
+
+const (
+	// same size as GStreamer's rtspsrc
+	udpKernelReadBufferSize = 0x80000
+
+	// same size as the buffer of mpegts.BufferedReader
+	maxPacketSize = 1500
+
+	// number of packets that are kept while waiting for retransmissions.
+	// it must be a power of two.
+	reorderBufferSize = 1024
+
+	// maximum number of packets that are requested again after a single gap.
+	maxNACKedPackets = 256
+
+	// SSRC of RTCP packets sent by the receiver
+	receiverSSRC = 0x5249_5354
+)
+
+type packetConn interface {
+	net.PacketConn
+	SetReadBuffer(int) error
+}
+
+func listenPacket(addr *net.UDPAddr, intfName string) (packetConn, error) {
+	if ip4 := addr.IP.To4(); ip4 != nil && addr.IP.IsMulticast() {
+		if intfName != "" {
+			intf, err := net.InterfaceByName(intfName)
+			if err != nil {
+				return nil, err
+			}
+
+			return multicast.NewSingleConn(intf, addr.String(), net.ListenPacket)
+		}
+
+		return multicast.NewMultiConn(addr.String(), true, net.ListenPacket)
+	}
+
+	tmp, err := net.ListenPacket(restrictnetwork.Restrict("udp", addr.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	return tmp.(*net.UDPConn), nil
+}
+
+// packetReader reads RTP packets that contain MPEG-TS (RFC 2250),
+// puts them in order, requests missing ones to the sender with RTCP NACKs
+// and returns their payloads.
+// Packets are reordered here instead of in the stream since they have to be in order
+// before MPEG-TS is demuxed, and the stream only receives the demuxed units.
+type packetReader struct {
+	rtpConn         net.PacketConn
+	rtcpConn        net.PacketConn
+	readTimeout     conf.Duration
+	senderRTCPAddr  func() net.Addr
+	onPacketsLost   func(uint64)
+	onPacketsNACKed func(uint64)
+	onDecodeError   func()
+
+	reorderer   *rtpreorderer.Reorderer
+	initialized bool
+	highestSeq  uint16
+	queue       []*rtp.Packet
+}
+
+func (r *packetReader) initialize() {
+	r.reorderer = &rtpreorderer.Reorderer{
+		BufferSize: reorderBufferSize,
+	}
+	r.reorderer.Initialize()
+}
+
+// Read implements io.Reader.
+func (r *packetReader) Read(p []byte) (int, error) {
+	for len(r.queue) == 0 {
+		buf := make([]byte, maxPacketSize)
+
+		r.rtpConn.SetReadDeadline(time.Now().Add(time.Duration(r.readTimeout)))
+		n, addr, err := r.rtpConn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+
+		var pkt rtp.Packet
+		err = pkt.Unmarshal(buf[:n])
+		if err != nil {
+			r.onDecodeError()
+			continue
+		}
+
+		r.requestMissing(&pkt, addr)
+
+		var lost uint
+		r.queue, lost = r.reorderer.Process(&pkt)
+		if lost != 0 {
+			r.onPacketsLost(uint64(lost))
+		}
+	}
+
+	pkt := r.queue[0]
+	r.queue = r.queue[1:]
+
+	return copy(p, pkt.Payload), nil
+}
+
+func (r *packetReader) requestMissing(pkt *rtp.Packet, rtpAddr net.Addr) {
+	if !r.initialized {
+		r.initialized = true
+		r.highestSeq = pkt.SequenceNumber
+		return
+	}
+
+	diff := pkt.SequenceNumber - r.highestSeq
+
+	// packet is a duplicate, a retransmission or arrived out of order
+	if diff == 0 || diff >= 0x8000 {
+		return
+	}
+
+	r.highestSeq = pkt.SequenceNumber
+
+	if diff == 1 {
+		return
+	}
+
+	count := min(diff-1, maxNACKedPackets)
+	missing := make([]uint16, count)
+	for i := range missing {
+		missing[i] = pkt.SequenceNumber - count + uint16(i)
+	}
+
+	// retransmitted packets have the least significant bit of the SSRC set to 1.
+	buf, err := (&rtcp.TransportLayerNack{
+		SenderSSRC: receiverSSRC,
+		MediaSSRC:  pkt.SSRC &^ 1,
+		Nacks:      rtcp.NackPairsFromSequenceNumbers(missing),
+	}).Marshal()
+	if err != nil {
+		return
+	}
+
+	dest := r.senderRTCPAddr()
+	if dest == nil {
+		// RTCP packets are sent by the sender from the port that follows the RTP one.
+		udpAddr := rtpAddr.(*net.UDPAddr)
+		dest = &net.UDPAddr{IP: udpAddr.IP, Port: udpAddr.Port + 1}
+	}
+
+	r.rtcpConn.WriteTo(buf, dest) //nolint:errcheck
+	r.onPacketsNACKed(uint64(count))
+}
+
+// Source is a RIST static source.
+// It implements the RIST simple profile (VSF TR-06-1) as a receiver.
+type Source struct {
+	ReadTimeout conf.Duration
+	Parent      defs.StaticSourceParent
+
+	mutex          sync.Mutex
+	senderRTCPAddr net.Addr
+}
+
+// Log implements logger.Writer.
+func (s *Source) Log(level logger.Level, format string, args ...interface{}) {
+	s.Parent.Log(level, "[RIST source] "+format, args...)
+}
+
+// Run implements StaticSource.
+func (s *Source) Run(params defs.StaticSourceRunParams) error {
+	s.Log(logger.Debug, "connecting")
+
+	u, err := url.Parse(params.ResolvedSource)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+
+	addr, err := net.ResolveUDPAddr("udp", u.Host)
+	if err != nil {
+		return err
+	}
+
+	if (addr.Port % 2) != 0 {
+		return fmt.Errorf("RIST port must be even")
+	}
+
+	rtpConn, err := listenPacket(addr, q.Get("interface"))
+	if err != nil {
+		return err
+	}
+	defer rtpConn.Close()
+
+	err = rtpConn.SetReadBuffer(udpKernelReadBufferSize)
+	if err != nil {
+		return err
+	}
+
+	rtcpConn, err := listenPacket(&net.UDPAddr{IP: addr.IP, Port: addr.Port + 1}, q.Get("interface"))
+	if err != nil {
+		return err
+	}
+	defer rtcpConn.Close()
+
+	rtcpDone := make(chan struct{})
+	go func() {
+		defer close(rtcpDone)
+		s.runRTCPReader(rtcpConn)
+	}()
+
+	readerErr := make(chan error)
+	go func() {
+		readerErr <- s.runReader(rtpConn, rtcpConn)
+	}()
+
+	select {
+	case err = <-readerErr:
+		rtcpConn.Close()
+		<-rtcpDone
+		return err
+
+	case <-params.Context.Done():
+		rtpConn.Close()
+		rtcpConn.Close()
+		<-readerErr
+		<-rtcpDone
+		return fmt.Errorf("terminated")
+	}
+}
+
+// runRTCPReader reads RTCP packets sent by the sender,
+// in order to find out where NACKs have to be sent.
+func (s *Source) runRTCPReader(rtcpConn net.PacketConn) {
+	buf := make([]byte, maxPacketSize)
+
+	for {
+		_, addr, err := rtcpConn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		s.senderRTCPAddr = addr
+		s.mutex.Unlock()
+	}
+}
+
+func (s *Source) runReader(rtpConn net.PacketConn, rtcpConn net.PacketConn) error {
+	packetsLost := &counterdumper.CounterDumper{
+		OnReport: func(val uint64) {
+			s.Log(logger.Warn, "%d RTP %s lost",
+				val,
+				func() string {
+					if val == 1 {
+						return "packet"
+					}
+					return "packets"
+				}())
+		},
+	}
+
+	packetsLost.Start()
+	defer packetsLost.Stop()
+
+	packetsNACKed := &counterdumper.CounterDumper{
+		OnReport: func(val uint64) {
+			s.Log(logger.Debug, "%d RTP %s requested again",
+				val,
+				func() string {
+					if val == 1 {
+						return "packet"
+					}
+					return "packets"
+				}())
+		},
+	}
+
+	packetsNACKed.Start()
+	defer packetsNACKed.Stop()
+
+	decodeErrors := &counterdumper.CounterDumper{
+		OnReport: func(val uint64) {
+			s.Log(logger.Warn, "%d decode %s",
+				val,
+				func() string {
+					if val == 1 {
+						return "error"
+					}
+					return "errors"
+				}())
+		},
+	}
+
+	decodeErrors.Start()
+	defer decodeErrors.Stop()
+
+	pr := &packetReader{
+		rtpConn:     rtpConn,
+		rtcpConn:    rtcpConn,
+		readTimeout: s.ReadTimeout,
+		senderRTCPAddr: func() net.Addr {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			return s.senderRTCPAddr
+		},
+		onPacketsLost:   packetsLost.Add,
+		onPacketsNACKed: packetsNACKed.Add,
+		onDecodeError:   decodeErrors.Increase,
+	}
+	pr.initialize()
+
+	r := &mcmpegts.Reader{R: mcmpegts.NewBufferedReader(pr)}
+	err := r.Initialize()
+	if err != nil {
+		return err
+	}
+
+	r.OnDecodeError(func(_ error) {
+		decodeErrors.Increase()
+	})
+
+	var stream *stream.Stream
+
+	medias, err := mpegts.ToStream(r, &stream, s)
+	if err != nil {
+		return err
+	}
+
+	res := s.Parent.SetReady(defs.PathSourceStaticSetReadyReq{
+		Desc:               &description.Session{Medias: medias},
+		GenerateRTPPackets: true,
+	})
+	if res.Err != nil {
+		return res.Err
+	}
+
+	defer s.Parent.SetNotReady(defs.PathSourceStaticSetNotReadyReq{})
+
+	stream = res.Stream
+
+	for {
+		err := r.Read()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// APISourceDescribe implements StaticSource.
+func (*Source) APISourceDescribe() defs.APIPathSourceOrReader {
+	return defs.APIPathSourceOrReader{
+		Type: "ristSource",
+		ID:   "",
+	}
+}
@@ -0,0 +1,118 @@
+package rist
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/defs"
+	"github.com/flynnletford/mediamtx/src/test"
+)
+
+func TestSource(t *testing.T) {
+	te := test.NewSourceTester(
+		func(p defs.StaticSourceParent) defs.StaticSource {
+			return &Source{
+				ReadTimeout: conf.Duration(10 * time.Second),
+				Parent:      p,
+			}
+		},
+		"rist://127.0.0.1:9010",
+		&conf.Path{},
+	)
+	defer te.Close()
+
+	time.Sleep(50 * time.Millisecond)
+
+	// RTCP packets of the receiver are sent to the port that follows the RTP one.
+	rtcpAddr, err := net.ResolveUDPAddr("udp", "127.0.0.1:9021")
+	require.NoError(t, err)
+
+	rtcpConn, err := net.ListenUDP("udp", rtcpAddr)
+	require.NoError(t, err)
+	defer rtcpConn.Close() //nolint:errcheck
+
+	src, err := net.ResolveUDPAddr("udp", "127.0.0.1:9020")
+	require.NoError(t, err)
+
+	dest, err := net.ResolveUDPAddr("udp", "127.0.0.1:9010")
+	require.NoError(t, err)
+
+	conn, err := net.DialUDP("udp", src, dest)
+	require.NoError(t, err)
+	defer conn.Close() //nolint:errcheck
+
+	track := &mpegts.Track{
+		Codec: &mpegts.CodecH264{},
+	}
+
+	var buf bytes.Buffer
+	w := &mpegts.Writer{W: &buf, Tracks: []*mpegts.Track{track}}
+	err = w.Initialize()
+	require.NoError(t, err)
+
+	err = w.WriteH264(track, 0, 0, [][]byte{{ // IDR
+		5, 1,
+	}})
+	require.NoError(t, err)
+
+	err = w.WriteH264(track, 0, 0, [][]byte{{ // non-IDR
+		5, 2,
+	}})
+	require.NoError(t, err)
+
+	var pkts []*rtp.Packet
+
+	for i := 0; buf.Len() != 0; i++ {
+		pkts = append(pkts, &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    33,
+				SequenceNumber: uint16(1000 + i),
+				SSRC:           0x1234,
+			},
+			Payload: buf.Next(188),
+		})
+	}
+
+	writePacket := func(pkt *rtp.Packet) {
+		var byts []byte
+		byts, err = pkt.Marshal()
+		require.NoError(t, err)
+		_, err = conn.Write(byts)
+		require.NoError(t, err)
+	}
+
+	// skip the second packet
+	for i, pkt := range pkts {
+		if i != 1 {
+			writePacket(pkt)
+		}
+	}
+
+	rbuf := make([]byte, 1500)
+	n, _, err := rtcpConn.ReadFrom(rbuf)
+	require.NoError(t, err)
+
+	packets, err := rtcp.Unmarshal(rbuf[:n])
+	require.NoError(t, err)
+
+	require.Equal(t, []rtcp.Packet{&rtcp.TransportLayerNack{
+		SenderSSRC: receiverSSRC,
+		MediaSSRC:  0x1234,
+		Nacks:      []rtcp.NackPair{{PacketID: 1001}},
+	}}, packets)
+
+	// retransmit the missing packet
+	pkts[1].SSRC |= 1
+	writePacket(pkts[1])
+
+	<-te.Unit
+}
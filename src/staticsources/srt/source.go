@@ -2,6 +2,9 @@
 package srt
 
 import (
+	"context"
+	"fmt"
+	"net/url"
 	"time"
 
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
@@ -22,6 +25,26 @@ type Source struct {
 	Parent      defs.StaticSourceParent
 }
 
+func checkPassphrase(req srt.ConnRequest, passphrase string) error {
+	if passphrase == "" {
+		if req.IsEncrypted() {
+			return fmt.Errorf("connection is encrypted, but no passphrase is defined in configuration")
+		}
+		return nil
+	}
+
+	if !req.IsEncrypted() {
+		return fmt.Errorf("connection is not encrypted, but a passphrase is defined in configuration")
+	}
+
+	err := req.SetPassphrase(passphrase)
+	if err != nil {
+		return fmt.Errorf("invalid passphrase")
+	}
+
+	return nil
+}
+
 // Log implements logger.Writer.
 func (s *Source) Log(level logger.Level, format string, args ...interface{}) {
 	s.Parent.Log(level, "[SRT source] "+format, args...)
@@ -42,7 +65,27 @@ func (s *Source) Run(params defs.StaticSourceRunParams) error {
 		return err
 	}
 
-	sconn, err := srt.Dial("srt", address, conf)
+	u, err := url.Parse(params.ResolvedSource)
+	if err != nil {
+		return err
+	}
+
+	var sconn srt.Conn
+
+	if u.Query().Get("mode") == "listener" {
+		var ln srt.Listener
+		ln, err = srt.Listen("srt", address, conf)
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+
+		s.Log(logger.Debug, "waiting for a caller on %s", address)
+
+		sconn, err = s.accept(params.Context, ln, conf.Passphrase)
+	} else {
+		sconn, err = srt.Dial("srt", address, conf)
+	}
 	if err != nil {
 		return err
 	}
@@ -68,6 +111,65 @@ func (s *Source) Run(params defs.StaticSourceRunParams) error {
 	}
 }
 
+// accept waits for a caller, accepts its connection and rejects any further one.
+func (s *Source) accept(ctx context.Context, ln srt.Listener, passphrase string) (srt.Conn, error) {
+	acceptDone := make(chan struct{})
+	var sconn srt.Conn
+	var err error
+
+	go func() {
+		defer close(acceptDone)
+
+		for {
+			var req srt.ConnRequest
+			req, err = ln.Accept2()
+			if err != nil {
+				return
+			}
+
+			err = checkPassphrase(req, passphrase)
+			if err != nil {
+				s.Log(logger.Warn, "rejected connection from %v: %v", req.RemoteAddr(), err)
+				req.Reject(srt.REJ_BADSECRET)
+				continue
+			}
+
+			sconn, err = req.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				for {
+					req, err := ln.Accept2()
+					if err != nil {
+						return
+					}
+					req.Reject(srt.REJ_RESOURCE)
+				}
+			}()
+
+			return
+		}
+	}()
+
+	select {
+	case <-acceptDone:
+		return sconn, err
+
+	case <-ctx.Done():
+		ln.Close()
+		<-acceptDone
+
+		// the connection may have been accepted before the listener was closed
+		if sconn != nil {
+			sconn.Close()
+		}
+
+		return nil, fmt.Errorf("terminated")
+	}
+}
+
 func (s *Source) runReader(sconn srt.Conn) error {
 	sconn.SetReadDeadline(time.Now().Add(time.Duration(s.ReadTimeout)))
 	r := &mcmpegts.Reader{R: mcmpegts.NewBufferedReader(sconn)}
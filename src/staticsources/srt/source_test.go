@@ -66,3 +66,56 @@ func TestSource(t *testing.T) {
 
 	<-te.Unit
 }
+
+func TestSourceListener(t *testing.T) {
+	te := test.NewSourceTester(
+		func(p defs.StaticSourceParent) defs.StaticSource {
+			return &Source{
+				ReadTimeout: conf.Duration(10 * time.Second),
+				Parent:      p,
+			}
+		},
+		"srt://127.0.0.1:9003?mode=listener&passphrase=ttest1234567",
+		&conf.Path{},
+	)
+	defer te.Close()
+
+	cconf := srt.DefaultConfig()
+	address, err := cconf.UnmarshalURL("srt://127.0.0.1:9003?passphrase=ttest1234567")
+	require.NoError(t, err)
+
+	var conn srt.Conn
+
+	// wait for the source to start listening
+	for i := 0; i < 20; i++ {
+		conn, err = srt.Dial("srt", address, cconf)
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NoError(t, err)
+
+	track := &mpegts.Track{
+		Codec: &mpegts.CodecH264{},
+	}
+
+	bw := bufio.NewWriter(conn)
+	w := &mpegts.Writer{W: bw, Tracks: []*mpegts.Track{track}}
+	err = w.Initialize()
+	require.NoError(t, err)
+
+	err = w.WriteH264(track, 0, 0, [][]byte{{ // IDR
+		5, 1,
+	}})
+	require.NoError(t, err)
+
+	err = bw.Flush()
+	require.NoError(t, err)
+
+	// wait for internal SRT queue to be written
+	time.Sleep(500 * time.Millisecond)
+	conn.Close()
+
+	<-te.Unit
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
 	"github.com/bluenviron/gortsplib/v4/pkg/multicast"
 	mcmpegts "github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts"
+	"github.com/pion/rtp"
 
 	"github.com/flynnletford/mediamtx/src/conf"
 	"github.com/flynnletford/mediamtx/src/counterdumper"
@@ -23,8 +24,14 @@ import (
 const (
 	// same size as GStreamer's rtspsrc
 	udpKernelReadBufferSize = 0x80000
+
+	mpegtsSyncByte = 0x47
 )
 
+// packetConnReader reads MPEG-TS packets from a PacketConn.
+// Packets can be sent as they are or encapsulated into RTP (RFC 2250):
+// since MPEG-TS packets start with a sync byte, that is not a valid first byte of RTP packets,
+// RTP is detected and removed automatically.
 type packetConnReader struct {
 	pc       net.PacketConn
 	sourceIP net.IP
@@ -38,7 +45,17 @@ func (r *packetConnReader) Read(p []byte) (int, error) {
 			continue
 		}
 
-		return n, err
+		if err != nil || n == 0 || p[0] == mpegtsSyncByte {
+			return n, err
+		}
+
+		var pkt rtp.Packet
+		err = pkt.Unmarshal(p[:n])
+		if err != nil {
+			return 0, fmt.Errorf("received a packet that is neither MPEG-TS nor RTP: %w", err)
+		}
+
+		return copy(p, pkt.Payload), nil
 	}
 }
 
@@ -2,11 +2,13 @@ package udp
 
 import (
 	"bufio"
+	"io"
 	"net"
 	"testing"
 	"time"
 
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts"
+	"github.com/pion/rtp"
 	"github.com/stretchr/testify/require"
 
 	"github.com/flynnletford/mediamtx/src/conf"
@@ -28,12 +30,43 @@ func multicastCapableInterface(t *testing.T) string {
 	return ""
 }
 
+type rtpWriter struct {
+	w              io.Writer
+	sequenceNumber uint16
+}
+
+func (w *rtpWriter) Write(p []byte) (int, error) {
+	pkt := &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    33,
+			SequenceNumber: w.sequenceNumber,
+			SSRC:           123456,
+		},
+		Payload: p,
+	}
+	w.sequenceNumber++
+
+	buf, err := pkt.Marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = w.w.Write(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
 func TestSource(t *testing.T) {
 	for _, ca := range []string{
 		"unicast",
 		"multicast",
 		"multicast with interface",
 		"unicast with source",
+		"unicast rtp",
 	} {
 		t.Run(ca, func(t *testing.T) {
 			var src string
@@ -50,6 +83,9 @@ func TestSource(t *testing.T) {
 
 			case "unicast with source":
 				src = "udp://127.0.0.1:9001?source=127.0.1.1"
+
+			case "unicast rtp":
+				src = "udp://127.0.0.1:9001"
 			}
 
 			te := test.NewSourceTester(
@@ -78,7 +114,7 @@ func TestSource(t *testing.T) {
 			case "multicast with interface":
 				dest = "238.0.0.1:9001"
 
-			case "unicast with source":
+			case "unicast with source", "unicast rtp":
 				dest = "127.0.0.1:9001"
 			}
 
@@ -99,7 +135,13 @@ func TestSource(t *testing.T) {
 				Codec: &mpegts.CodecH264{},
 			}
 
-			bw := bufio.NewWriter(conn)
+			var bw *bufio.Writer
+			if ca == "unicast rtp" {
+				// 7 MPEG-TS packets per RTP packet
+				bw = bufio.NewWriterSize(&rtpWriter{w: conn}, 1316)
+			} else {
+				bw = bufio.NewWriter(conn)
+			}
 			w := &mpegts.Writer{W: bw, Tracks: []*mpegts.Track{track}}
 			err = w.Initialize()
 			require.NoError(t, err)
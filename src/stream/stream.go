@@ -2,6 +2,7 @@
 package stream
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"sync/atomic"
@@ -10,10 +11,12 @@ import (
 	"github.com/bluenviron/gortsplib/v4"
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
 	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 
 	"github.com/flynnletford/mediamtx/src/counterdumper"
 	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/tracing"
 	"github.com/flynnletford/mediamtx/src/unit"
 )
 
@@ -25,38 +28,125 @@ type Reader interface {
 // ReadFunc is the callback passed to AddReader().
 type ReadFunc func(unit.Unit) error
 
+// UnitHook is a function that inspects or transforms units after they have been
+// processed and before they are delivered to readers.
+// It returns the unit to deliver, or nil in order to drop the unit.
+// Units are shared with other hooks and readers, therefore a hook that changes
+// a unit must return a copy instead of editing the original.
+// RTSP readers receive the RTP packets of the returned unit, that are not regenerated
+// when the content of the unit is changed.
+type UnitHook func(medi *description.Media, forma format.Format, u unit.Unit) unit.Unit
+
+// MediaChangeFunc is the callback passed to SetReaderOnMediaChange().
+// added is true when the media has been added to the stream, false when it has been removed.
+type MediaChangeFunc func(medi *description.Media, added bool) error
+
+// ParametersChangeFunc is the callback passed to SetReaderOnParametersChange().
+type ParametersChangeFunc func(medi *description.Media, forma format.Format) error
+
+// OnParametersChangeFunc is the prototype of the function passed as OnParametersChange.
+// It is called by the routine that writes data, therefore it must not block and must not call methods of Stream.
+type OnParametersChangeFunc = func(medi *description.Media, forma format.Format)
+
+// OnMalformedFunc is the prototype of the function passed as OnMalformed.
+// It is called by the routine that writes data, therefore it must not block and must not call methods of Stream.
+type OnMalformedFunc = func(medi *description.Media, forma format.Format, err error)
+
+// OnReaderOverflowFunc is the prototype of the function passed as OnReaderOverflow.
+// It is called when the queue of a reader is full, by the routine that writes data,
+// therefore it must not block and must not call methods of Stream.
+type OnReaderOverflowFunc = func(reader Reader, policy OverflowPolicy)
+
 // Stream is a media stream.
 // It stores tracks, readers and allows to write data to readers, converting it when needed.
+// When CacheGOP is true, the last GOP of each video format is kept in memory and
+// is sent to readers when they are started, allowing them to start decoding immediately.
+// The GOP is sent only to readers whose queue can contain it entirely, and it is not cached
+// when it is longer than the queues of all readers.
+// When InjectParameterSets is true, H264 and H265 parameter sets are prepended to every key frame
+// sent to readers, including RTP packets, that are re-encoded.
+// When JitterBufferSize is not zero, RTP packets written with WriteRTPPacket() are reordered
+// and deduplicated with a buffer that contains up to JitterBufferSize packets, and lost packets
+// are counted.
+// Medias can be added or removed after Initialize() with AddMedia() and RemoveMedia().
+// When DecodeToLPCM is true, G711 and G722 are decoded into LPCM once, and the LPCM field of units
+// is shared between readers.
+// OnParametersChange is called when parameters of a format change, and readers are notified through
+// the callback passed to SetReaderOnParametersChange().
+// When DropMalformed is true, malformed H264 and H265 NAL units and access units are dropped
+// instead of being delivered to readers, that would stop with an error; they are counted and
+// passed to OnMalformed.
+// When WriteRTCPFeedback is not nil, RTCP receiver reports and REMB packets are generated
+// from RTP packets written to the stream and are passed to WriteRTCPFeedback every
+// RTCPFeedbackPeriod, in order to be sent back to the source.
+// When Tracer is not nil, a sample of written units is traced across ingest, format processing,
+// writing and delivery to readers, and the trace is attached to units.
 type Stream struct {
-	WriteQueueSize     int
-	UDPMaxPayloadSize  int
-	Desc               *description.Session
-	GenerateRTPPackets bool
-	Parent             logger.Writer
+	WriteQueueSize      int
+	UDPMaxPayloadSize   int
+	Desc                *description.Session
+	GenerateRTPPackets  bool
+	InjectParameterSets bool
+	DecodeToLPCM        bool
+	DropMalformed       bool
+	CacheGOP            bool
+	JitterBufferSize    int
+	Clock               ClockSource
+	RTCPFeedbackPeriod  time.Duration
+	WriteRTCPFeedback   WriteRTCPFeedbackFunc
+	OnReaderOverflow    OnReaderOverflowFunc
+	OnParametersChange  OnParametersChangeFunc
+	OnMalformed         OnMalformedFunc
+	Tracer              *tracing.Tracer
+	PathName            string
+	Parent              logger.Writer
 
 	bytesReceived    *uint64
 	bytesSent        *uint64
+	packetsLost      *counterdumper.CounterDumper
+	malformedUnits   *counterdumper.CounterDumper
 	streamMedias     map[*description.Media]*streamMedia
 	mutex            sync.RWMutex
 	rtspStream       *gortsplib.ServerStream
 	rtspsStream      *gortsplib.ServerStream
 	streamReaders    map[Reader]*streamReader
+	unitHooks        []UnitHook
+	timestamps       *timestampNormalizer
 	processingErrors *counterdumper.CounterDumper
+	maxQueueSize     int
 
 	readerRunning chan struct{}
 }
 
 // Initialize initializes a Stream.
 func (s *Stream) Initialize() error {
+	if (s.JitterBufferSize & (s.JitterBufferSize - 1)) != 0 {
+		return fmt.Errorf("jitter buffer size must be a power of two")
+	}
+
+	if s.Clock == nil {
+		s.Clock = systemClock{}
+	}
+
+	if s.RTCPFeedbackPeriod == 0 {
+		s.RTCPFeedbackPeriod = rtcpFeedbackDefaultPeriod
+	}
+
 	s.bytesReceived = new(uint64)
 	s.bytesSent = new(uint64)
 	s.streamMedias = make(map[*description.Media]*streamMedia)
 	s.streamReaders = make(map[Reader]*streamReader)
+	s.maxQueueSize = s.WriteQueueSize
 	s.readerRunning = make(chan struct{})
 
+	s.timestamps = &timestampNormalizer{
+		clock: s.Clock,
+	}
+	s.timestamps.initialize()
+
 	s.processingErrors = &counterdumper.CounterDumper{
 		OnReport: func(val uint64) {
-			s.Parent.Log(logger.Warn, "%d processing %s",
+			s.Log(logger.Warn, "%d processing %s",
 				val,
 				func() string {
 					if val == 1 {
@@ -68,26 +158,101 @@ func (s *Stream) Initialize() error {
 	}
 	s.processingErrors.Start()
 
+	s.packetsLost = &counterdumper.CounterDumper{
+		OnReport: func(val uint64) {
+			s.Log(logger.Warn, "%d RTP %s lost",
+				val,
+				func() string {
+					if val == 1 {
+						return "packet"
+					}
+					return "packets"
+				}())
+		},
+	}
+	s.packetsLost.Start()
+
+	s.malformedUnits = &counterdumper.CounterDumper{
+		OnReport: func(val uint64) {
+			s.Log(logger.Warn, "%d malformed %s dropped",
+				val,
+				func() string {
+					if val == 1 {
+						return "unit"
+					}
+					return "units"
+				}())
+		},
+	}
+	s.malformedUnits.Start()
+
 	for _, media := range s.Desc.Medias {
-		s.streamMedias[media] = &streamMedia{
-			udpMaxPayloadSize:  s.UDPMaxPayloadSize,
-			media:              media,
-			generateRTPPackets: s.GenerateRTPPackets,
-			processingErrors:   s.processingErrors,
-			parent:             s.Parent,
-		}
-		err := s.streamMedias[media].initialize()
+		sm, err := s.newStreamMedia(media)
 		if err != nil {
 			return err
 		}
+		s.streamMedias[media] = sm
 	}
 
 	return nil
 }
 
+func (s *Stream) newStreamMedia(medi *description.Media) (*streamMedia, error) {
+	sm := &streamMedia{
+		udpMaxPayloadSize:   s.UDPMaxPayloadSize,
+		media:               medi,
+		generateRTPPackets:  s.GenerateRTPPackets,
+		injectParameterSets: s.InjectParameterSets,
+		decodeToLPCM:        s.DecodeToLPCM,
+		dropMalformed:       s.DropMalformed,
+		onParametersChange:  s.OnParametersChange,
+		onMalformed:         s.OnMalformed,
+		jitterBufferSize:    s.JitterBufferSize,
+		processingErrors:    s.processingErrors,
+		packetsLost:         s.packetsLost,
+		malformedUnits:      s.malformedUnits,
+		parent:              s,
+	}
+	err := sm.initialize()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.WriteRTCPFeedback != nil {
+		for _, sf := range sm.formats {
+			sf.rtcpFeedback = &rtcpFeedback{
+				clock:     s.Clock,
+				period:    s.RTCPFeedbackPeriod,
+				media:     medi,
+				clockRate: sf.format.ClockRate(),
+				write:     s.WriteRTCPFeedback,
+			}
+			err = sf.rtcpFeedback.initialize()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return sm, nil
+}
+
+// Log implements logger.Writer.
+func (s *Stream) Log(level logger.Level, format string, args ...interface{}) {
+	s.Parent.Log(level, "[stream] "+format, args...)
+}
+
 // Close closes all resources of the stream.
 func (s *Stream) Close() {
 	s.processingErrors.Stop()
+	s.packetsLost.Stop()
+	s.malformedUnits.Stop()
+
+	s.mutex.Lock()
+	for _, sm := range s.streamMedias {
+		sm.close()
+	}
+	s.mutex.Unlock()
 
 	if s.rtspStream != nil {
 		s.rtspStream.Close()
@@ -119,6 +284,219 @@ func (s *Stream) BytesSent() uint64 {
 	return bytesSent
 }
 
+// FormatInfo contains informations about a format of the stream.
+type FormatInfo struct {
+	Media     *description.Media
+	Format    format.Format
+	Codec     string
+	ClockRate int
+
+	// number of readers of the format, excluding RTSP readers.
+	Readers int
+
+	// RTP packets lost, detected by the jitter buffer.
+	PacketsLost uint64
+
+	// NAL units and access units dropped since they are malformed.
+	MalformedUnits uint64
+
+	// frames with the same timestamp of the previous frame.
+	DuplicatedTimestamps uint64
+
+	// frames with a timestamp lower than previous frames.
+	NonMonotonicTimestamps uint64
+
+	// non-key frames received after data loss, before the next key frame.
+	MissingReferenceFrames uint64
+
+	// received bits per second, measured on the last 5 seconds.
+	Bitrate float64
+
+	// received frames per second, measured on the last 5 seconds.
+	FrameRate float64
+
+	// interval between the last two key frames, zero when unknown.
+	KeyframeInterval time.Duration
+}
+
+// Stats are statistics of a stream.
+type Stats struct {
+	// formats, in the same order of the stream description.
+	Formats []FormatInfo
+
+	// readers, excluding RTSP readers.
+	Readers []ReaderStats
+}
+
+// Stats returns statistics of readers and informations about formats.
+func (s *Stream) Stats() *Stats {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	stats := &Stats{
+		Readers: make([]ReaderStats, 0, len(s.streamReaders)),
+	}
+
+	now := s.Clock.Now()
+
+	for _, medi := range s.Desc.Medias {
+		sm := s.streamMedias[medi]
+
+		for _, forma := range medi.Formats {
+			sf := sm.formats[forma]
+
+			bitrate, frameRate, keyframeInterval := sf.meter.measure(now)
+
+			stats.Formats = append(stats.Formats, FormatInfo{
+				Media:                  medi,
+				Format:                 forma,
+				Codec:                  forma.Codec(),
+				ClockRate:              forma.ClockRate(),
+				Readers:                len(sf.pausedReaders) + len(sf.runningReaders),
+				PacketsLost:            atomic.LoadUint64(&sf.packetsLostCount),
+				MalformedUnits:         atomic.LoadUint64(&sf.malformedCount),
+				DuplicatedTimestamps:   atomic.LoadUint64(&sf.discontinuities.duplicatedTimestamps),
+				NonMonotonicTimestamps: atomic.LoadUint64(&sf.discontinuities.nonMonotonicTimestamps),
+				MissingReferenceFrames: atomic.LoadUint64(&sf.discontinuities.missingReferences),
+				Bitrate:                bitrate,
+				FrameRate:              frameRate,
+				KeyframeInterval:       keyframeInterval,
+			})
+		}
+	}
+
+	for reader, sr := range s.streamReaders {
+		stats.Readers = append(stats.Readers, sr.stats(reader))
+	}
+
+	return stats
+}
+
+// AddUnitHook adds a hook that is called for every unit, before units are delivered to readers.
+// Hooks are called in the order in which they have been added,
+// by the routine that writes data, therefore they must not block.
+func (s *Stream) AddUnitHook(hook UnitHook) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.unitHooks = append(s.unitHooks, hook)
+}
+
+// AddMedia adds a media to the stream.
+// Desc is replaced with a new description that contains the media,
+// and readers are notified through the callback passed to SetReaderOnMediaChange().
+// The media is not available to RTSP readers when the RTSP stream has already been created.
+func (s *Stream) AddMedia(medi *description.Media) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.streamMedias[medi]; ok {
+		return fmt.Errorf("media already present")
+	}
+
+	sm, err := s.newStreamMedia(medi)
+	if err != nil {
+		return err
+	}
+
+	if s.rtspStream != nil || s.rtspsStream != nil {
+		s.Log(logger.Warn, "media added after the creation of the RTSP stream,"+
+			" it will not be available to RTSP readers")
+
+		for _, sf := range sm.formats {
+			sf.rtspUnavailable = true
+		}
+	}
+
+	s.streamMedias[medi] = sm
+
+	medias := make([]*description.Media, len(s.Desc.Medias), len(s.Desc.Medias)+1)
+	copy(medias, s.Desc.Medias)
+	s.setMedias(append(medias, medi))
+
+	s.notifyMediaChange(medi, true)
+
+	return nil
+}
+
+// RemoveMedia removes a media from the stream.
+// Desc is replaced with a new description that doesn't contain the media,
+// readers of the media are detached from it and readers are notified through
+// the callback passed to SetReaderOnMediaChange().
+func (s *Stream) RemoveMedia(medi *description.Media) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sm, ok := s.streamMedias[medi]
+	if !ok {
+		return fmt.Errorf("media not found")
+	}
+
+	sm.close()
+
+	for _, sf := range sm.formats {
+		for sr := range sf.pausedReaders {
+			sf.removeReader(sr)
+		}
+		for sr := range sf.runningReaders {
+			sf.removeReader(sr)
+		}
+	}
+
+	delete(s.streamMedias, medi)
+
+	medias := make([]*description.Media, 0, len(s.Desc.Medias)-1)
+	for _, m := range s.Desc.Medias {
+		if m != medi {
+			medias = append(medias, m)
+		}
+	}
+	s.setMedias(medias)
+
+	s.notifyMediaChange(medi, false)
+
+	return nil
+}
+
+// setMedias replaces Desc with a copy that contains the given medias,
+// in order not to edit descriptions that are in use by other entities.
+func (s *Stream) setMedias(medias []*description.Media) {
+	desc := *s.Desc
+	desc.Medias = medias
+	s.Desc = &desc
+}
+
+func (s *Stream) notifyMediaChange(medi *description.Media, added bool) {
+	for _, sr := range s.streamReaders {
+		if sr.onMediaChange != nil {
+			cb := sr.onMediaChange
+			sr.pushControl(func() error {
+				return cb(medi, added)
+			})
+		}
+	}
+}
+
+// RequestKeyframeSample returns the last key frame of a video media, or waits for the next one
+// until the context is canceled. Key frames of H264 and H265 contain parameters.
+// After the first request, RTP packets of the media are decoded even when there are no readers.
+// The returned unit is retained and must be released with unit.Release() when it is not needed anymore.
+func (s *Stream) RequestKeyframeSample(ctx context.Context, medi *description.Media) (unit.Unit, error) {
+	s.mutex.RLock()
+	sm, ok := s.streamMedias[medi]
+	s.mutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("media not found")
+	}
+
+	if sm.keyframes == nil {
+		return nil, fmt.Errorf("media is not a video media")
+	}
+
+	return sm.keyframes.request(ctx)
+}
+
 // RTSPStream returns the RTSP stream.
 func (s *Stream) RTSPStream(server *gortsplib.Server) *gortsplib.ServerStream {
 	s.mutex.Lock()
@@ -161,20 +539,85 @@ func (s *Stream) AddReader(reader Reader, medi *description.Media, forma format.
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	sr := s.findOrCreateReader(reader)
+
+	sm := s.streamMedias[medi]
+	sf := sm.formats[forma]
+	sf.addReader(sr, cb)
+
+	// the reader has already been started, this happens when a media is added
+	if sr.started {
+		sf.startReader(s, sr)
+	}
+}
+
+// SetReaderOptions sets the options of a reader.
+// It must be called before StartReader().
+// Used by all protocols except RTSP.
+func (s *Stream) SetReaderOptions(reader Reader, opts ReaderOptions) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sr := s.findOrCreateReader(reader)
+
+	if opts.QueueSize > 0 {
+		sr.queueSize = opts.QueueSize
+	} else {
+		sr.queueSize = s.WriteQueueSize
+	}
+	sr.overflowPolicy = opts.OverflowPolicy
+
+	if sr.queueSize > s.maxQueueSize {
+		s.maxQueueSize = sr.queueSize
+	}
+}
+
+// SetReaderOnMediaChange sets a callback that is called when a media is added to
+// or removed from the stream.
+// The callback is called by the routine of the reader, in order with units,
+// and is allowed to call AddReader() in order to read added medias.
+// Used by all protocols except RTSP.
+func (s *Stream) SetReaderOnMediaChange(reader Reader, cb MediaChangeFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sr := s.findOrCreateReader(reader)
+	sr.onMediaChange = cb
+}
+
+// SetReaderOnParametersChange sets a callback that is called when parameters of a format
+// (H264 and H265 parameter sets, MPEG-4 Video configuration) change.
+// The callback is called by the routine of the reader, before the unit that contains the new parameters,
+// allowing to update initialization data of the format.
+// Used by all protocols except RTSP.
+func (s *Stream) SetReaderOnParametersChange(reader Reader, cb ParametersChangeFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sr := s.findOrCreateReader(reader)
+	sr.onParametersChange = cb
+}
+
+func (s *Stream) findOrCreateReader(reader Reader) *streamReader {
 	sr, ok := s.streamReaders[reader]
 	if !ok {
 		sr = &streamReader{
 			queueSize: s.WriteQueueSize,
 			parent:    reader,
 		}
+
+		if s.OnReaderOverflow != nil {
+			sr.onOverflow = func() {
+				s.OnReaderOverflow(reader, sr.overflowPolicy)
+			}
+		}
+
 		sr.initialize()
 
 		s.streamReaders[reader] = sr
 	}
 
-	sm := s.streamMedias[medi]
-	sf := sm.formats[forma]
-	sf.addReader(sr, cb)
+	return sr
 }
 
 // RemoveReader removes a reader.
@@ -208,7 +651,7 @@ func (s *Stream) StartReader(reader Reader) {
 
 	for _, sm := range s.streamMedias {
 		for _, sf := range sm.formats {
-			sf.startReader(sr)
+			sf.startReader(s, sr)
 		}
 	}
 
@@ -252,16 +695,39 @@ func (s *Stream) WaitRunningReader() {
 }
 
 // WriteUnit writes a Unit.
+// When the unit contains a unit.Payload, the stream retains it as long as it is needed,
+// and the caller is responsible for releasing its own reference after the call.
 func (s *Stream) WriteUnit(medi *description.Media, forma format.Format, u unit.Unit) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
 	sm := s.streamMedias[medi]
 	sf := sm.formats[forma]
 
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	sf.writeUnit(nil, s, medi, u) //nolint:errcheck,staticcheck
+}
 
-	fmt.Printf("WriteUnit\n")
+// WriteUnitCtx writes a Unit.
+// Unlike WriteUnit(), when the queue of a reader is full, it waits until there's room
+// or the context is canceled, regardless of the overflow policy of the reader.
+func (s *Stream) WriteUnitCtx(ctx context.Context, medi *description.Media, forma format.Format, u unit.Unit) error {
+	var pending []pendingEntry
 
-	sf.writeUnit(s, medi, u)
+	s.mutex.RLock()
+	sm := s.streamMedias[medi]
+	sf := sm.formats[forma]
+	err := sf.writeUnit(&pending, s, medi, u)
+	s.mutex.RUnlock()
+
+	// the mutex is released before waiting, since readers are allowed to call
+	// methods of the stream, like AddReader() inside the callback of SetReaderOnMediaChange().
+	// Entries of units written before an error, like packets released by the jitter buffer,
+	// are pushed anyway.
+	perr := pushPending(ctx, pending)
+	if err != nil {
+		return err
+	}
+	return perr
 }
 
 // WriteRTPPacket writes a RTP packet.
@@ -272,13 +738,81 @@ func (s *Stream) WriteRTPPacket(
 	ntp time.Time,
 	pts int64,
 ) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	sm := s.streamMedias[medi]
+	sf := sm.formats[forma]
+
+	sf.writeRTPPacket(nil, s, medi, pkt, ntp, pts) //nolint:errcheck,staticcheck
+}
+
+// WriteRTPPacketCtx writes a RTP packet.
+// Unlike WriteRTPPacket(), when the queue of a reader is full, it waits until there's room
+// or the context is canceled, regardless of the overflow policy of the reader.
+func (s *Stream) WriteRTPPacketCtx(
+	ctx context.Context,
+	medi *description.Media,
+	forma format.Format,
+	pkt *rtp.Packet,
+	ntp time.Time,
+	pts int64,
+) error {
+	var pending []pendingEntry
+
+	s.mutex.RLock()
+	sm := s.streamMedias[medi]
+	sf := sm.formats[forma]
+	err := sf.writeRTPPacket(&pending, s, medi, pkt, ntp, pts)
+	s.mutex.RUnlock()
+
+	// the mutex is released before waiting, since readers are allowed to call
+	// methods of the stream, like AddReader() inside the callback of SetReaderOnMediaChange().
+	// Entries of units written before an error, like packets released by the jitter buffer,
+	// are pushed anyway.
+	perr := pushPending(ctx, pending)
+	if err != nil {
+		return err
+	}
+	return perr
+}
+
+// WriteRTPPacketRaw writes a RTP packet, computing its PTS and NTP timestamp
+// with a normalizer shared by all formats of the stream.
+// PTS start from the arrival of the first packet of the stream and RTP timestamps
+// are unwrapped. Discontinuities are replaced with the time elapsed between packets
+// and PTS never go back, except with formats that support B-frames (H264 and H265).
+// NTP timestamps are computed from RTCP sender reports when available,
+// otherwise they are the time of arrival of packets, provided by Clock.
+func (s *Stream) WriteRTPPacketRaw(
+	medi *description.Media,
+	forma format.Format,
+	pkt *rtp.Packet,
+) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
 	sm := s.streamMedias[medi]
 	sf := sm.formats[forma]
 
+	pts, ntp := s.timestamps.normalize(sf, pkt)
+
+	sf.writeRTPPacket(nil, s, medi, pkt, ntp, pts) //nolint:errcheck,staticcheck
+}
+
+// WriteRTCPSenderReport writes a RTCP sender report, that is used by WriteRTPPacketRaw()
+// to compute NTP timestamps of packets of the given media, and by RTCP feedback.
+func (s *Stream) WriteRTCPSenderReport(medi *description.Media, sr *rtcp.SenderReport) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	fmt.Printf("WriteRTPPacket\n")
+	sm := s.streamMedias[medi]
+
+	for _, sf := range sm.formats {
+		s.timestamps.processSenderReport(sf, sr)
 
-	sf.writeRTPPacket(s, medi, pkt, ntp, pts)
+		if sf.rtcpFeedback != nil {
+			sf.rtcpFeedback.processSenderReport(sr)
+		}
+	}
 }
@@ -0,0 +1,220 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	psdp "github.com/pion/sdp/v3"
+	"gopkg.in/yaml.v2"
+
+	"github.com/flynnletford/mediamtx/src/conf/yamlwrapper"
+)
+
+// StreamConfigVersion is the current version of the stream configuration schema.
+const StreamConfigVersion = 1
+
+// FormatConfig is the serializable configuration of a format.
+// Codec is the discriminator of the format and must match the codec described by RTPMap and FMTP.
+// Codec parameters are stored with the same syntax used by SDP, therefore
+// parameter sets (SPS, PPS, VPS, decoder configurations) are encoded in base64 or hex,
+// depending on the codec.
+type FormatConfig struct {
+	Codec       string            `json:"codec"`
+	PayloadType uint8             `json:"payloadType"`
+	RTPMap      string            `json:"rtpMap,omitempty"`
+	FMTP        map[string]string `json:"fmtp,omitempty"`
+}
+
+// MediaConfig is the serializable configuration of a media.
+type MediaConfig struct {
+	Type    description.MediaType `json:"type"`
+	Formats []FormatConfig        `json:"formats"`
+}
+
+// StreamConfig is the serializable configuration of a stream.
+type StreamConfig struct {
+	Version int           `json:"version"`
+	Title   string        `json:"title,omitempty"`
+	Medias  []MediaConfig `json:"medias"`
+}
+
+// NewStreamConfig creates a StreamConfig from a stream description.
+func NewStreamConfig(desc *description.Session) *StreamConfig {
+	c := &StreamConfig{
+		Version: StreamConfigVersion,
+		Title:   desc.Title,
+		Medias:  make([]MediaConfig, len(desc.Medias)),
+	}
+
+	for i, medi := range desc.Medias {
+		c.Medias[i] = MediaConfig{
+			Type:    medi.Type,
+			Formats: make([]FormatConfig, len(medi.Formats)),
+		}
+
+		for j, forma := range medi.Formats {
+			c.Medias[i].Formats[j] = FormatConfig{
+				Codec:       forma.Codec(),
+				PayloadType: forma.PayloadType(),
+				RTPMap:      forma.RTPMap(),
+				FMTP:        forma.FMTP(),
+			}
+		}
+	}
+
+	return c
+}
+
+func (fc FormatConfig) toFormat(mediaType description.MediaType) (format.Format, error) {
+	pt := strconv.FormatUint(uint64(fc.PayloadType), 10)
+
+	md := &psdp.MediaDescription{
+		MediaName: psdp.MediaName{
+			Media:   string(mediaType),
+			Formats: []string{pt},
+		},
+	}
+
+	if fc.RTPMap != "" {
+		md.Attributes = append(md.Attributes, psdp.Attribute{
+			Key:   "rtpmap",
+			Value: pt + " " + fc.RTPMap,
+		})
+	}
+
+	if len(fc.FMTP) != 0 {
+		keys := make([]string, 0, len(fc.FMTP))
+		for k := range fc.FMTP {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		params := make([]string, len(keys))
+		for i, k := range keys {
+			params[i] = k + "=" + fc.FMTP[k]
+		}
+
+		md.Attributes = append(md.Attributes, psdp.Attribute{
+			Key:   "fmtp",
+			Value: pt + " " + strings.Join(params, ";"),
+		})
+	}
+
+	forma, err := format.Unmarshal(md, pt)
+	if err != nil {
+		return nil, err
+	}
+
+	if forma.Codec() != fc.Codec {
+		return nil, fmt.Errorf("codec is '%s', but parameters describe '%s'", fc.Codec, forma.Codec())
+	}
+
+	return forma, nil
+}
+
+// Description converts the configuration into a stream description.
+func (c *StreamConfig) Description() (*description.Session, error) {
+	if c.Version != StreamConfigVersion {
+		return nil, fmt.Errorf("unsupported version: %d", c.Version)
+	}
+
+	if len(c.Medias) == 0 {
+		return nil, fmt.Errorf("no medias defined")
+	}
+
+	desc := &description.Session{
+		Title:  c.Title,
+		Medias: make([]*description.Media, len(c.Medias)),
+	}
+
+	for i, mc := range c.Medias {
+		switch mc.Type {
+		case description.MediaTypeVideo, description.MediaTypeAudio, description.MediaTypeApplication:
+
+		default:
+			return nil, fmt.Errorf("media %d: invalid type '%s'", i, mc.Type)
+		}
+
+		if len(mc.Formats) == 0 {
+			return nil, fmt.Errorf("media %d: no formats defined", i)
+		}
+
+		medi := &description.Media{
+			Type:    mc.Type,
+			Formats: make([]format.Format, len(mc.Formats)),
+		}
+
+		for j, fc := range mc.Formats {
+			forma, err := fc.toFormat(mc.Type)
+			if err != nil {
+				return nil, fmt.Errorf("media %d, format %d: %w", i, j, err)
+			}
+			medi.Formats[j] = forma
+		}
+
+		desc.Medias[i] = medi
+	}
+
+	return desc, nil
+}
+
+func isYAMLPath(fpath string) bool {
+	ext := strings.ToLower(filepath.Ext(fpath))
+	return ext == ".yml" || ext == ".yaml"
+}
+
+// LoadStreamConfig loads a stream description from a JSON or YAML file.
+// The format is chosen by the file extension.
+func LoadStreamConfig(fpath string) (*description.Session, error) {
+	buf, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	var c StreamConfig
+
+	if isYAMLPath(fpath) {
+		err = yamlwrapper.Unmarshal(buf, &c)
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(buf))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&c)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Description()
+}
+
+// SaveStreamConfig saves a stream description into a JSON or YAML file.
+// The format is chosen by the file extension.
+func SaveStreamConfig(fpath string, desc *description.Session) error {
+	buf, err := json.MarshalIndent(NewStreamConfig(desc), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if isYAMLPath(fpath) {
+		var temp interface{}
+		err = yaml.Unmarshal(buf, &temp)
+		if err != nil {
+			return err
+		}
+
+		buf, err = yaml.Marshal(temp)
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(fpath, buf, 0o644)
+}
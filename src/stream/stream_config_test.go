@@ -0,0 +1,126 @@
+package stream
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/formatprocessor"
+)
+
+func TestStreamConfigSaveLoad(t *testing.T) {
+	desc := &description.Session{
+		Title: "test",
+		Medias: []*description.Media{
+			{
+				Type: description.MediaTypeVideo,
+				Formats: []format.Format{&format.H264{
+					PayloadTyp:        96,
+					SPS:               formatprocessor.H264DefaultSPS,
+					PPS:               formatprocessor.H264DefaultPPS,
+					PacketizationMode: 1,
+				}},
+			},
+			{
+				Type: description.MediaTypeAudio,
+				Formats: []format.Format{&format.MPEG4Audio{
+					PayloadTyp:     97,
+					ProfileLevelID: 1,
+					Config: &mpeg4audio.AudioSpecificConfig{
+						Type:         2,
+						SampleRate:   44100,
+						ChannelCount: 2,
+					},
+					SizeLength:       13,
+					IndexLength:      3,
+					IndexDeltaLength: 3,
+				}},
+			},
+			{
+				Type: description.MediaTypeAudio,
+				Formats: []format.Format{&format.G711{
+					PayloadTyp:   8,
+					MULaw:        false,
+					SampleRate:   8000,
+					ChannelCount: 1,
+				}},
+			},
+		},
+	}
+
+	dir, err := os.MkdirTemp("", "mediamtx-stream-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, ext := range []string{"json", "yml"} {
+		t.Run(ext, func(t *testing.T) {
+			fpath := filepath.Join(dir, "stream."+ext)
+
+			err = SaveStreamConfig(fpath, desc)
+			require.NoError(t, err)
+
+			var desc2 *description.Session
+			desc2, err = LoadStreamConfig(fpath)
+			require.NoError(t, err)
+			require.Equal(t, desc, desc2)
+		})
+	}
+}
+
+func TestStreamConfigErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		conf string
+		err  string
+	}{
+		{
+			"version",
+			`{"version":2,"medias":[]}`,
+			"unsupported version: 2",
+		},
+		{
+			"no medias",
+			`{"version":1,"medias":[]}`,
+			"no medias defined",
+		},
+		{
+			"media type",
+			`{"version":1,"medias":[{"type":"other","formats":[]}]}`,
+			"media 0: invalid type 'other'",
+		},
+		{
+			"no formats",
+			`{"version":1,"medias":[{"type":"video","formats":[]}]}`,
+			"media 0: no formats defined",
+		},
+		{
+			"codec mismatch",
+			`{"version":1,"medias":[{"type":"audio","formats":[{"codec":"Opus","payloadType":8}]}]}`,
+			"media 0, format 0: codec is 'Opus', but parameters describe 'G711'",
+		},
+		{
+			"unknown field",
+			`{"version":1,"other":1}`,
+			`json: unknown field "other"`,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "mediamtx-stream-config")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			fpath := filepath.Join(dir, "stream.json")
+
+			err = os.WriteFile(fpath, []byte(ca.conf), 0o644)
+			require.NoError(t, err)
+
+			_, err = LoadStreamConfig(fpath)
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
@@ -1,16 +1,26 @@
 package stream
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"sync/atomic"
 	"time"
 
 	"github.com/bluenviron/gortsplib/v4/pkg/description"
 	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/rtpreorderer"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/av1"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4video"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/vp9"
 	"github.com/pion/rtp"
 
 	"github.com/flynnletford/mediamtx/src/counterdumper"
 	"github.com/flynnletford/mediamtx/src/formatprocessor"
 	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/tracing"
 	"github.com/flynnletford/mediamtx/src/unit"
 )
 
@@ -22,16 +32,113 @@ func unitSize(u unit.Unit) uint64 {
 	return n
 }
 
+// unitIsNonKeyframe checks whether a unit is a video frame that depends on previous frames.
+func unitIsNonKeyframe(u unit.Unit) bool {
+	switch tunit := u.(type) {
+	case *unit.H264:
+		return tunit.AU != nil && !h264.IsRandomAccess(tunit.AU)
+
+	case *unit.H265:
+		return tunit.AU != nil && !h265.IsRandomAccess(tunit.AU)
+
+	case *unit.H266:
+		return tunit.AU != nil && !formatprocessor.H266IsRandomAccess(tunit.AU)
+
+	case *unit.AV1:
+		return tunit.TU != nil && !av1.IsRandomAccess2(tunit.TU)
+
+	case *unit.VP8:
+		return len(tunit.Frame) != 0 && (tunit.Frame[0]&0x01) != 0
+
+	case *unit.VP9:
+		if tunit.Frame == nil {
+			return false
+		}
+		var h vp9.Header
+		err := h.Unmarshal(tunit.Frame)
+		return err == nil && h.NonKeyFrame
+
+	case *unit.MPEG4Video:
+		return tunit.Frame != nil &&
+			!bytes.Contains(tunit.Frame, []byte{0, 0, 1, byte(mpeg4video.GroupOfVOPStartCode)})
+	}
+
+	return false
+}
+
+// unitIsKeyframe checks whether a unit is a video frame that can be decoded independently.
+func unitIsKeyframe(u unit.Unit) bool {
+	switch tunit := u.(type) {
+	case *unit.H264:
+		return tunit.AU != nil && h264.IsRandomAccess(tunit.AU)
+
+	case *unit.H265:
+		return tunit.AU != nil && h265.IsRandomAccess(tunit.AU)
+
+	case *unit.H266:
+		return tunit.AU != nil && formatprocessor.H266IsRandomAccess(tunit.AU)
+
+	case *unit.AV1:
+		return tunit.TU != nil && av1.IsRandomAccess2(tunit.TU)
+
+	case *unit.VP8:
+		return len(tunit.Frame) != 0 && (tunit.Frame[0]&0x01) == 0
+
+	case *unit.VP9:
+		if tunit.Frame == nil {
+			return false
+		}
+		var h vp9.Header
+		err := h.Unmarshal(tunit.Frame)
+		return err == nil && !h.NonKeyFrame
+
+	case *unit.MPEG4Video:
+		return tunit.Frame != nil &&
+			bytes.Contains(tunit.Frame, []byte{0, 0, 1, byte(mpeg4video.GroupOfVOPStartCode)})
+
+	case *unit.MPEG1Video:
+		return tunit.Frame != nil && bytes.Contains(tunit.Frame, []byte{0, 0, 1, 0xB8})
+
+	case *unit.MJPEG:
+		return tunit.Frame != nil
+	}
+
+	return false
+}
+
 type streamFormat struct {
-	udpMaxPayloadSize  int
-	format             format.Format
-	generateRTPPackets bool
-	processingErrors   *counterdumper.CounterDumper
-	parent             logger.Writer
+	udpMaxPayloadSize   int
+	format              format.Format
+	generateRTPPackets  bool
+	injectParameterSets bool
+	decodeToLPCM        bool
+	dropMalformed       bool
+	media               *description.Media
+	onParametersChange  OnParametersChangeFunc
+	onMalformed         OnMalformedFunc
+	jitterBufferSize    int
+	processingErrors    *counterdumper.CounterDumper
+	packetsLost         *counterdumper.CounterDumper
+	malformedUnits      *counterdumper.CounterDumper
+	keyframes           *keyframeStore
+	parent              logger.Writer
+
+	proc             formatprocessor.Processor
+	reorderer        *rtpreorderer.Reorderer
+	packetsLostCount uint64
+	malformedCount   uint64
+	pausedReaders    map[*streamReader]ReadFunc
+	runningReaders   map[*streamReader]ReadFunc
+
+	// the format has been added after the creation of RTSP streams
+	rtspUnavailable bool
+
+	rtcpFeedback    *rtcpFeedback
+	meter           formatMeter
+	discontinuities formatDiscontinuities
 
-	proc           formatprocessor.Processor
-	pausedReaders  map[*streamReader]ReadFunc
-	runningReaders map[*streamReader]ReadFunc
+	// units of the last GOP, starting from a key frame
+	gop []unit.Unit
 }
 
 func (sf *streamFormat) initialize() error {
@@ -39,14 +146,68 @@ func (sf *streamFormat) initialize() error {
 	sf.runningReaders = make(map[*streamReader]ReadFunc)
 
 	var err error
-	sf.proc, err = formatprocessor.New(sf.udpMaxPayloadSize, sf.format, sf.generateRTPPackets, sf.parent)
+	sf.proc, err = formatprocessor.NewWithOptions(
+		sf.udpMaxPayloadSize,
+		sf.format,
+		sf.generateRTPPackets,
+		formatprocessor.Options{
+			InjectParameterSets: sf.injectParameterSets,
+			OnParametersChange:  sf.notifyParametersChange,
+			DecodeToLPCM:        sf.decodeToLPCM,
+			DropMalformed:       sf.dropMalformed,
+			OnMalformed:         sf.notifyMalformed,
+		},
+		sf.parent)
 	if err != nil {
 		return err
 	}
 
+	if sf.jitterBufferSize != 0 {
+		sf.reorderer = &rtpreorderer.Reorderer{
+			BufferSize: sf.jitterBufferSize,
+		}
+		sf.reorderer.Initialize()
+	}
+
 	return nil
 }
 
+func (sf *streamFormat) close() {
+	sf.releaseGOP()
+
+	if sf.rtcpFeedback != nil {
+		sf.rtcpFeedback.close()
+	}
+}
+
+// notifyParametersChange is called by the format processor, before the unit
+// that contains the new parameters is pushed to readers.
+func (sf *streamFormat) notifyParametersChange() {
+	if sf.onParametersChange != nil {
+		sf.onParametersChange(sf.media, sf.format)
+	}
+
+	for sr := range sf.runningReaders {
+		if sr.onParametersChange != nil {
+			cb := sr.onParametersChange
+			sr.pushControl(func() error {
+				return cb(sf.media, sf.format)
+			})
+		}
+	}
+}
+
+// notifyMalformed is called by the format processor when a malformed unit is dropped.
+func (sf *streamFormat) notifyMalformed(err error) {
+	atomic.AddUint64(&sf.malformedCount, 1)
+	sf.malformedUnits.Increase()
+	sf.discontinuities.processDiscardedData()
+
+	if sf.onMalformed != nil {
+		sf.onMalformed(sf.media, sf.format, err)
+	}
+}
+
 func (sf *streamFormat) addReader(sr *streamReader, cb ReadFunc) {
 	sf.pausedReaders[sr] = cb
 }
@@ -56,63 +217,326 @@ func (sf *streamFormat) removeReader(sr *streamReader) {
 	delete(sf.runningReaders, sr)
 }
 
-func (sf *streamFormat) startReader(sr *streamReader) {
+func (sf *streamFormat) startReader(s *Stream, sr *streamReader) {
 	if cb, ok := sf.pausedReaders[sr]; ok {
 		delete(sf.pausedReaders, sr)
 		sf.runningReaders[sr] = cb
+
+		// send the last GOP, in order to allow the reader to start decoding immediately.
+		// A GOP that doesn't fit into the queue of the reader would be truncated, therefore it is not sent.
+		if len(sf.gop) <= sr.queueSize {
+			for _, u := range sf.gop {
+				sf.pushUnit(s, sr, cb, u, unitSize(u), unitIsNonKeyframe(u))
+			}
+		}
+	}
+}
+
+// pendingEntry is an entry that has to be pushed to a reader
+// after the mutex of the stream has been released.
+type pendingEntry struct {
+	sr *streamReader
+	e  readerEntry
+}
+
+// pushPending pushes entries to readers, waiting for room in their queues.
+// It must be called without holding the mutex of the stream, since readers
+// are allowed to call methods of the stream while writers wait for them.
+func pushPending(ctx context.Context, pending []pendingEntry) error {
+	for i, p := range pending {
+		err := p.sr.pushBlocking(ctx, p.e)
+		if err != nil {
+			for _, p2 := range pending[i+1:] {
+				p2.e.discard()
+			}
+			return err
+		}
 	}
+	return nil
 }
 
-func (sf *streamFormat) writeUnit(s *Stream, medi *description.Media, u unit.Unit) {
+// writeUnit writes a unit.
+// When pending is not nil, entries of readers are appended to it instead of being pushed.
+func (sf *streamFormat) writeUnit(pending *[]pendingEntry, s *Stream, medi *description.Media, u unit.Unit) error {
+	span := sf.startIngestSpan(s, medi)
+	defer span.Finish()
+
+	// the payload allocated by the format processor, if any, is released once readers are done with it.
+	if unit.GetPayload(u) == nil {
+		defer unit.Release(u)
+	}
+
+	procSpan := span.StartChild("formatprocessor")
 	err := sf.proc.ProcessUnit(u)
+	procSpan.RecordError(err)
+	procSpan.Finish()
+
 	if err != nil {
+		span.RecordError(err)
 		sf.processingErrors.Increase()
-		return
+		sf.discontinuities.processDiscardedData()
+		return err
 	}
 
-	sf.writeUnitInner(s, medi, u)
+	return sf.writeUnitInner(pending, s, medi, u, span)
 }
 
+// startIngestSpan starts the root span of the trace of a unit, if the unit is sampled.
+func (sf *streamFormat) startIngestSpan(s *Stream, medi *description.Media) *tracing.Span {
+	span := s.Tracer.Start("ingest")
+	if span == nil {
+		return nil
+	}
+
+	span.SetAttribute("path", s.PathName)
+	span.SetAttribute("media", string(medi.Type))
+	span.SetAttribute("codec", sf.format.Codec())
+	return span
+}
+
+// writeRTPPacket writes a RTP packet.
+// When pending is not nil, entries of readers are appended to it instead of being pushed.
 func (sf *streamFormat) writeRTPPacket(
+	pending *[]pendingEntry,
 	s *Stream,
 	medi *description.Media,
 	pkt *rtp.Packet,
 	ntp time.Time,
 	pts int64,
-) {
-	hasNonRTSPReaders := len(sf.pausedReaders) > 0 || len(sf.runningReaders) > 0
+) error {
+	if sf.rtcpFeedback != nil {
+		sf.rtcpFeedback.processPacket(pkt, sf.format.PTSEqualsDTS(pkt))
+	}
+
+	if sf.reorderer == nil {
+		return sf.writeRTPPacketInner(pending, s, medi, pkt, ntp, pts)
+	}
+
+	pkts, lost := sf.reorderer.Process(pkt)
+	if lost != 0 {
+		atomic.AddUint64(&sf.packetsLostCount, uint64(lost))
+		sf.packetsLost.Add(uint64(lost))
+	}
+
+	for _, opkt := range pkts {
+		// timestamps of buffered packets are derived from the ones of the current packet
+		diff := int64(int32(opkt.Timestamp - pkt.Timestamp))
 
+		err := sf.writeRTPPacketInner(pending, s, medi, opkt,
+			ntp.Add(timestampToDuration(diff, sf.format.ClockRate())),
+			pts+diff)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sf *streamFormat) writeRTPPacketInner(
+	pending *[]pendingEntry,
+	s *Stream,
+	medi *description.Media,
+	pkt *rtp.Packet,
+	ntp time.Time,
+	pts int64,
+) error {
+	hasNonRTSPReaders := len(sf.pausedReaders) > 0 || len(sf.runningReaders) > 0 ||
+		(sf.keyframes != nil && sf.keyframes.requested.Load())
+
+	sf.discontinuities.processRTPPacket(pkt)
+
+	span := sf.startIngestSpan(s, medi)
+	defer span.Finish()
+
+	procSpan := span.StartChild("formatprocessor")
 	u, err := sf.proc.ProcessRTPPacket(pkt, ntp, pts, hasNonRTSPReaders)
+	procSpan.RecordError(err)
+	procSpan.Finish()
+
 	if err != nil {
+		span.RecordError(err)
 		sf.processingErrors.Increase()
-		return
+		sf.discontinuities.processDiscardedData()
+		return err
 	}
 
-	sf.writeUnitInner(s, medi, u)
+	// the unit has been allocated by the format processor, therefore its payload is owned by the stream.
+	defer unit.Release(u)
+
+	return sf.writeUnitInner(pending, s, medi, u, span)
 }
 
-func (sf *streamFormat) writeUnitInner(s *Stream, medi *description.Media, u unit.Unit) {
+func (sf *streamFormat) writeUnitInner(
+	pending *[]pendingEntry,
+	s *Stream,
+	medi *description.Media,
+	u unit.Unit,
+	span *tracing.Span,
+) error {
+	writeSpan := span.StartChild("stream.write")
+	defer writeSpan.Finish()
+
+	// readers and hooks receive the trace through the unit.
+	if writeSpan != nil {
+		u.SetTraceContext(writeSpan.SpanContext())
+	}
+
 	size := unitSize(u)
 
 	atomic.AddUint64(s.bytesReceived, size)
 
-	if s.rtspStream != nil {
+	isKeyframe := unitIsKeyframe(u)
+	isNonKeyframe := unitIsNonKeyframe(u)
+	isFrame := medi.Type != description.MediaTypeVideo || isKeyframe || isNonKeyframe
+	sf.meter.process(s.Clock.Now(), size, isFrame, isKeyframe)
+
+	if isFrame {
+		sf.discontinuities.processFrame(u, isKeyframe, isNonKeyframe)
+	}
+
+	if isKeyframe && sf.keyframes != nil {
+		sf.keyframes.process(u)
+	}
+
+	if len(s.unitHooks) != 0 {
+		for _, hook := range s.unitHooks {
+			u = hook(medi, sf.format, u)
+			if u == nil {
+				return nil
+			}
+		}
+
+		size = unitSize(u)
+	}
+
+	if s.rtspStream != nil && !sf.rtspUnavailable {
 		for _, pkt := range u.GetRTPPackets() {
 			s.rtspStream.WritePacketRTPWithNTP(medi, pkt, u.GetNTP()) //nolint:errcheck
 		}
 	}
 
-	if s.rtspsStream != nil {
+	if s.rtspsStream != nil && !sf.rtspUnavailable {
 		for _, pkt := range u.GetRTPPackets() {
 			s.rtspsStream.WritePacketRTPWithNTP(medi, pkt, u.GetNTP()) //nolint:errcheck
 		}
 	}
 
+	if s.CacheGOP && medi.Type == description.MediaTypeVideo {
+		sf.cacheUnit(s, u)
+	}
+
+	nonKeyframe := unitIsNonKeyframe(u)
+
+	writeSpan.SetAttribute("readers", len(sf.runningReaders))
+
 	for sr, cb := range sf.runningReaders {
-		ccb := cb
-		sr.push(func() error {
-			atomic.AddUint64(s.bytesSent, size)
-			return ccb(u)
-		})
+		if pending != nil {
+			*pending = append(*pending, pendingEntry{sr, sf.unitEntry(s, sr, cb, u, size)})
+		} else {
+			sf.pushUnit(s, sr, cb, u, size, nonKeyframe)
+		}
+	}
+
+	return nil
+}
+
+func (sf *streamFormat) cacheUnit(s *Stream, u unit.Unit) {
+	switch {
+	case unitIsKeyframe(u):
+		sf.releaseGOP()
+		unit.Retain(u)
+		sf.gop = append(sf.gop, u)
+
+	case sf.gop != nil:
+		// the GOP can't be delivered entirely to any reader, discard it
+		if len(sf.gop) >= s.maxQueueSize {
+			sf.releaseGOP()
+			return
+		}
+
+		unit.Retain(u)
+		sf.gop = append(sf.gop, u)
+	}
+}
+
+func (sf *streamFormat) releaseGOP() {
+	for i, u := range sf.gop {
+		unit.Release(u)
+		sf.gop[i] = nil
+	}
+	sf.gop = nil
+}
+
+func (sf *streamFormat) pushUnit(
+	s *Stream,
+	sr *streamReader,
+	cb ReadFunc,
+	u unit.Unit,
+	size uint64,
+	nonKeyframe bool,
+) {
+	sr.push(sf, nonKeyframe, sf.unitEntry(s, sr, cb, u, size))
+}
+
+// unitEntry creates a queue entry that delivers a unit to a reader.
+// The unit is retained until the entry is run or discarded.
+func (sf *streamFormat) unitEntry(
+	s *Stream,
+	sr *streamReader,
+	cb ReadFunc,
+	u unit.Unit,
+	size uint64,
+) readerEntry {
+	unit.Retain(u)
+
+	e := &unitEntry{
+		s:    s,
+		sf:   sf,
+		sr:   sr,
+		cb:   cb,
+		u:    u,
+		size: size,
 	}
+
+	// the span starts when the unit is queued, in order to include the time spent in the queue.
+	if u.GetTraceContext().IsValid() {
+		e.queued = time.Now()
+	}
+
+	return e
+}
+
+// unitEntry is a queue entry that delivers a unit to a reader.
+type unitEntry struct {
+	s      *Stream
+	sf     *streamFormat
+	sr     *streamReader
+	cb     ReadFunc
+	u      unit.Unit
+	size   uint64
+	queued time.Time
+}
+
+func (e *unitEntry) run() error {
+	defer unit.Release(e.u)
+
+	var span *tracing.Span
+	if traceContext := e.u.GetTraceContext(); traceContext.IsValid() {
+		span = e.s.Tracer.StartChildAt(traceContext, "stream.read", e.queued)
+		span.SetAttribute("reader", fmt.Sprintf("%T", e.sr.parent))
+		span.SetAttribute("queue_wait_ms", float64(time.Since(e.queued))/float64(time.Millisecond))
+	}
+
+	atomic.AddUint64(e.s.bytesSent, e.size)
+	err := e.cb(e.u)
+	e.sr.onSent(e.size, timestampToDuration(e.u.GetPTS(), e.sf.format.ClockRate()))
+
+	span.RecordError(err)
+	span.Finish()
+	return err
+}
+
+func (e *unitEntry) discard() {
+	unit.Release(e.u)
 }
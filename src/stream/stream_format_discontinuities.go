@@ -0,0 +1,89 @@
+package stream
+
+import (
+	"sync/atomic"
+
+	"github.com/pion/rtp"
+
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+// unitHasReorderedFrames checks whether the decoding order of frames of a unit
+// can differ from their presentation order.
+func unitHasReorderedFrames(u unit.Unit) bool {
+	switch u.(type) {
+	case *unit.H264, *unit.H265, *unit.H266, *unit.MPEG4Video, *unit.MPEG1Video:
+		return true
+	}
+	return false
+}
+
+// formatDiscontinuities detects discontinuities of a format, that are symptoms of issues
+// of the source or of the network:
+//   - frames with the same timestamp of the previous frame;
+//   - frames with a timestamp lower than the previous one. When frames can be reordered,
+//     only key frames are checked, since they must follow all previous frames;
+//   - non-key frames whose reference frames are missing, since RTP packets have been lost
+//     or data has been discarded after the last key frame.
+type formatDiscontinuities struct {
+	duplicatedTimestamps   uint64
+	nonMonotonicTimestamps uint64
+	missingReferences      uint64
+
+	ptsFilled        bool
+	lastPTS          int64
+	maxPTS           int64
+	seqNumFilled     bool
+	lastSeqNum       uint16
+	keyframeReceived bool
+	hasReference     bool
+}
+
+// processRTPPacket detects lost RTP packets through sequence numbers.
+func (d *formatDiscontinuities) processRTPPacket(pkt *rtp.Packet) {
+	if d.seqNumFilled && pkt.SequenceNumber != (d.lastSeqNum+1) {
+		d.hasReference = false
+	}
+
+	d.seqNumFilled = true
+	d.lastSeqNum = pkt.SequenceNumber
+}
+
+// processDiscardedData is called when data is discarded.
+func (d *formatDiscontinuities) processDiscardedData() {
+	d.hasReference = false
+}
+
+func (d *formatDiscontinuities) processFrame(u unit.Unit, isKeyframe bool, isNonKeyframe bool) {
+	pts := u.GetPTS()
+
+	if d.ptsFilled {
+		switch {
+		case pts == d.lastPTS:
+			atomic.AddUint64(&d.duplicatedTimestamps, 1)
+
+		case unitHasReorderedFrames(u):
+			if isKeyframe && pts <= d.maxPTS {
+				atomic.AddUint64(&d.nonMonotonicTimestamps, 1)
+			}
+
+		case pts < d.lastPTS:
+			atomic.AddUint64(&d.nonMonotonicTimestamps, 1)
+		}
+	}
+
+	if !d.ptsFilled || pts > d.maxPTS {
+		d.maxPTS = pts
+	}
+	d.ptsFilled = true
+	d.lastPTS = pts
+
+	switch {
+	case isKeyframe:
+		d.keyframeReceived = true
+		d.hasReference = true
+
+	case isNonKeyframe && d.keyframeReceived && !d.hasReference:
+		atomic.AddUint64(&d.missingReferences, 1)
+	}
+}
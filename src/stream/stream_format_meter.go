@@ -0,0 +1,104 @@
+package stream
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	formatMeterBucketDuration = 100 * time.Millisecond
+
+	// the window of the meter is 5 seconds.
+	formatMeterBucketCount = 50
+)
+
+type formatMeterBucket struct {
+	bytes  uint64
+	frames uint64
+}
+
+// formatMeter measures bitrate, frame rate and key frame interval of a format
+// on a rolling window.
+type formatMeter struct {
+	mutex            sync.Mutex
+	start            time.Time
+	buckets          [formatMeterBucketCount]formatMeterBucket
+	curBucket        int64
+	lastKeyframe     time.Time
+	keyframeInterval time.Duration
+}
+
+// advance moves the window forward, clearing expired buckets.
+func (m *formatMeter) advance(now time.Time) {
+	if m.start.IsZero() {
+		m.start = now
+	}
+
+	bucket := int64(now.Sub(m.start) / formatMeterBucketDuration)
+
+	if bucket <= m.curBucket {
+		return
+	}
+
+	if (bucket - m.curBucket) >= formatMeterBucketCount {
+		m.buckets = [formatMeterBucketCount]formatMeterBucket{}
+	} else {
+		for i := m.curBucket + 1; i <= bucket; i++ {
+			m.buckets[i%formatMeterBucketCount] = formatMeterBucket{}
+		}
+	}
+
+	m.curBucket = bucket
+}
+
+func (m *formatMeter) process(now time.Time, size uint64, isFrame bool, isKeyframe bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.advance(now)
+
+	b := &m.buckets[m.curBucket%formatMeterBucketCount]
+	b.bytes += size
+
+	if isFrame {
+		b.frames++
+	}
+
+	if isKeyframe {
+		if !m.lastKeyframe.IsZero() {
+			m.keyframeInterval = now.Sub(m.lastKeyframe)
+		}
+		m.lastKeyframe = now
+	}
+}
+
+// measure returns bitrate in bits per second, frame rate in frames per second
+// and the interval between the last two key frames.
+func (m *formatMeter) measure(now time.Time) (float64, float64, time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.start.IsZero() {
+		return 0, 0, 0
+	}
+
+	m.advance(now)
+
+	// at startup, the window is limited to the elapsed time
+	window := min(now.Sub(m.start), formatMeterBucketCount*formatMeterBucketDuration)
+	if window <= 0 {
+		return 0, 0, m.keyframeInterval
+	}
+
+	var bytes uint64
+	var frames uint64
+
+	for _, b := range m.buckets {
+		bytes += b.bytes
+		frames += b.frames
+	}
+
+	return float64(bytes*8) / window.Seconds(),
+		float64(frames) / window.Seconds(),
+		m.keyframeInterval
+}
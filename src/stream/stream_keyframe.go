@@ -0,0 +1,90 @@
+package stream
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+// keyframeStore stores the last key frame of a media
+// and delivers the next key frame to waiting callers.
+// Key frames are stored only after the first request,
+// since RTP packets are not decoded when they are not needed.
+type keyframeStore struct {
+	mutex     sync.Mutex
+	last      unit.Unit
+	waiters   map[chan unit.Unit]struct{}
+	requested atomic.Bool
+}
+
+func (k *keyframeStore) initialize() {
+	k.waiters = make(map[chan unit.Unit]struct{})
+}
+
+func (k *keyframeStore) close() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.last != nil {
+		unit.Release(k.last)
+		k.last = nil
+	}
+}
+
+func (k *keyframeStore) process(u unit.Unit) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.last != nil {
+		unit.Release(k.last)
+	}
+
+	unit.Retain(u)
+	k.last = u
+
+	for ch := range k.waiters {
+		unit.Retain(u)
+		ch <- u
+		delete(k.waiters, ch)
+	}
+}
+
+// request returns the last key frame, or waits for the next one.
+// The returned unit is retained.
+func (k *keyframeStore) request(ctx context.Context) (unit.Unit, error) {
+	k.requested.Store(true)
+
+	k.mutex.Lock()
+
+	if k.last != nil {
+		u := k.last
+		unit.Retain(u)
+		k.mutex.Unlock()
+		return u, nil
+	}
+
+	ch := make(chan unit.Unit, 1)
+	k.waiters[ch] = struct{}{}
+	k.mutex.Unlock()
+
+	select {
+	case u := <-ch:
+		return u, nil
+
+	case <-ctx.Done():
+		k.mutex.Lock()
+		delete(k.waiters, ch)
+		k.mutex.Unlock()
+
+		// a key frame may have been delivered in the meanwhile
+		select {
+		case u := <-ch:
+			unit.Release(u)
+		default:
+		}
+
+		return nil, ctx.Err()
+	}
+}
@@ -8,25 +8,49 @@ import (
 )
 
 type streamMedia struct {
-	udpMaxPayloadSize  int
-	media              *description.Media
-	generateRTPPackets bool
-	processingErrors   *counterdumper.CounterDumper
-	parent             logger.Writer
+	udpMaxPayloadSize   int
+	media               *description.Media
+	generateRTPPackets  bool
+	injectParameterSets bool
+	decodeToLPCM        bool
+	dropMalformed       bool
+	onParametersChange  OnParametersChangeFunc
+	onMalformed         OnMalformedFunc
+	jitterBufferSize    int
+	processingErrors    *counterdumper.CounterDumper
+	packetsLost         *counterdumper.CounterDumper
+	malformedUnits      *counterdumper.CounterDumper
+	parent              logger.Writer
 
-	formats map[format.Format]*streamFormat
+	formats   map[format.Format]*streamFormat
+	keyframes *keyframeStore
 }
 
 func (sm *streamMedia) initialize() error {
 	sm.formats = make(map[format.Format]*streamFormat)
 
+	if sm.media.Type == description.MediaTypeVideo {
+		sm.keyframes = &keyframeStore{}
+		sm.keyframes.initialize()
+	}
+
 	for _, forma := range sm.media.Formats {
 		sf := &streamFormat{
-			udpMaxPayloadSize:  sm.udpMaxPayloadSize,
-			format:             forma,
-			generateRTPPackets: sm.generateRTPPackets,
-			processingErrors:   sm.processingErrors,
-			parent:             sm.parent,
+			udpMaxPayloadSize:   sm.udpMaxPayloadSize,
+			format:              forma,
+			generateRTPPackets:  sm.generateRTPPackets,
+			injectParameterSets: sm.injectParameterSets,
+			decodeToLPCM:        sm.decodeToLPCM,
+			dropMalformed:       sm.dropMalformed,
+			media:               sm.media,
+			onParametersChange:  sm.onParametersChange,
+			onMalformed:         sm.onMalformed,
+			jitterBufferSize:    sm.jitterBufferSize,
+			processingErrors:    sm.processingErrors,
+			packetsLost:         sm.packetsLost,
+			malformedUnits:      sm.malformedUnits,
+			keyframes:           sm.keyframes,
+			parent:              sm.parent,
 		}
 		err := sf.initialize()
 		if err != nil {
@@ -37,3 +61,13 @@ func (sm *streamMedia) initialize() error {
 
 	return nil
 }
+
+func (sm *streamMedia) close() {
+	for _, sf := range sm.formats {
+		sf.close()
+	}
+
+	if sm.keyframes != nil {
+		sm.keyframes.close()
+	}
+}
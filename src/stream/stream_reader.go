@@ -1,34 +1,168 @@
 package stream
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/bluenviron/gortsplib/v4/pkg/ringbuffer"
+
 	"github.com/flynnletford/mediamtx/src/counterdumper"
 	"github.com/flynnletford/mediamtx/src/logger"
 )
 
+// OverflowPolicy is the policy applied when the queue of a reader is full.
+type OverflowPolicy int
+
+// overflow policies.
+const (
+	// discard incoming units.
+	OverflowPolicyDropNewest OverflowPolicy = iota
+
+	// discard the oldest queued unit in order to make room for incoming units.
+	OverflowPolicyDropOldest
+
+	// discard incoming video units that are not key frames, together with
+	// following units of the same format until the next key frame.
+	// Other units replace the oldest queued unit.
+	OverflowPolicyDropNonKeyframe
+
+	// disconnect the reader.
+	OverflowPolicyDisconnect
+)
+
+// ReaderOptions are options of a reader.
+type ReaderOptions struct {
+	// size of the queue of the reader.
+	// When zero, WriteQueueSize of the stream is used.
+	// It should be a power of two, otherwise a slower queue is used.
+	QueueSize int
+
+	// policy applied when the queue is full.
+	OverflowPolicy OverflowPolicy
+}
+
+// ReaderStats are statistics of a reader.
+type ReaderStats struct {
+	Reader Reader
+
+	// size of the queue.
+	QueueSize int
+
+	// units that are waiting in the queue.
+	QueuedUnits int
+
+	// units that have been discarded because the queue was full.
+	DroppedUnits uint64
+
+	// bytes sent per second, measured on the last second.
+	BytesPerSecond float64
+
+	// PTS of the last unit sent to the reader.
+	LastPTS time.Duration
+
+	// whether LastPTS is filled.
+	LastPTSAvailable bool
+}
+
+// period of the measurement of the byte rate.
+const readerRatePeriod = 1 * time.Second
+
+func timestampToDuration(t int64, clockRate int) time.Duration {
+	secs := t / int64(clockRate)
+	dec := t % int64(clockRate)
+	return time.Duration(secs)*time.Second + time.Duration(dec)*time.Second/time.Duration(clockRate)
+}
+
+// readerEntry is an entry of the queue of a reader.
+type readerEntry interface {
+	run() error
+
+	// discard is called when the entry is removed from the queue without being run.
+	discard()
+}
+
+// readerFunc is an entry that runs a callback.
+type readerFunc func() error
+
+func (f readerFunc) run() error {
+	return f()
+}
+
+func (readerFunc) discard() {}
+
 type streamReader struct {
-	queueSize int
-	parent    logger.Writer
+	queueSize          int
+	overflowPolicy     OverflowPolicy
+	onOverflow         func()
+	onMediaChange      MediaChangeFunc
+	onParametersChange ParametersChangeFunc
+	parent             logger.Writer
+
+	// readers that discard incoming units, or that are disconnected, use a ring buffer.
+	// Readers that discard queued units need a locked queue.
+	ring            *ringbuffer.RingBuffer
+	ringQueued      int64
+	ringWaiters     int32
+	ringPulled      chan struct{}
+	controlsPending int32
 
-	buffer          *ringbuffer.RingBuffer
+	mutex           sync.Mutex
+	cond            *sync.Cond
+	queue           readerQueue
+	controls        []readerEntry
+	closed          bool
+	done            chan struct{}
+	overflowed      bool
+	skippedFormats  map[*streamFormat]struct{}
 	started         bool
 	discardedFrames *counterdumper.CounterDumper
+	droppedUnits    uint64
+	rateStart       time.Time
+	rateBytes       uint64
+	bytesPerSecond  float64
+	lastPTS         time.Duration
+	lastPTSFilled   bool
 
 	// out
 	err chan error
 }
 
 func (w *streamReader) initialize() {
-	buffer, _ := ringbuffer.New(uint64(w.queueSize))
-	w.buffer = buffer
+	w.cond = sync.NewCond(&w.mutex)
+	w.done = make(chan struct{})
+	w.ringPulled = make(chan struct{}, 1)
+	w.skippedFormats = make(map[*streamFormat]struct{})
 	w.err = make(chan error)
 }
 
 func (w *streamReader) start() {
 	w.started = true
 
+	if w.overflowPolicy == OverflowPolicyDropNewest || w.overflowPolicy == OverflowPolicyDisconnect {
+		// the size of the ring buffer must be a power of two,
+		// otherwise the locked queue is used.
+		ring, err := ringbuffer.New(uint64(w.queueSize))
+		if err == nil {
+			w.ring = ring
+		}
+	}
+
+	w.mutex.Lock()
+	if w.ring != nil {
+		w.drainControls()
+	} else {
+		w.queue.initialize(w.queueSize)
+		for _, cb := range w.controls {
+			w.queue.pushBack(cb)
+		}
+		w.controls = nil
+		atomic.StoreInt32(&w.controlsPending, 0)
+	}
+	w.mutex.Unlock()
+
 	w.discardedFrames = &counterdumper.CounterDumper{
 		OnReport: func(val uint64) {
 			w.parent.Log(logger.Warn, "connection is too slow, discarding %d %s",
@@ -47,7 +181,14 @@ func (w *streamReader) start() {
 }
 
 func (w *streamReader) stop() {
-	w.buffer.Close()
+	w.mutex.Lock()
+	w.setClosed()
+	w.mutex.Unlock()
+	w.cond.Broadcast()
+
+	if w.ring != nil {
+		w.ring.Close()
+	}
 
 	if w.started {
 		w.discardedFrames.Stop()
@@ -55,32 +196,401 @@ func (w *streamReader) stop() {
 	}
 }
 
+// setClosed must be called with the mutex held.
+func (w *streamReader) setClosed() {
+	if !w.closed {
+		w.closed = true
+		close(w.done)
+	}
+}
+
 func (w *streamReader) error() chan error {
 	return w.err
 }
 
 func (w *streamReader) run() {
-	w.err <- w.runInner()
+	err := w.runInner()
+
+	// unblock writers that are waiting for room in the queue
+	w.mutex.Lock()
+	w.setClosed()
+	w.queue.reset()
+	w.controls = nil
+	w.mutex.Unlock()
+	w.cond.Broadcast()
+
+	// entries that are still in the ring buffer are not discarded explicitly,
+	// therefore their payloads are collected by the garbage collector instead of being reused.
+
+	if w.ring != nil {
+		w.ring.Close()
+	}
+
+	w.err <- err
 	close(w.err)
 }
 
+func (w *streamReader) pull() (readerEntry, bool) {
+	if w.ring != nil {
+		return w.pullRing()
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for w.queue.len() == 0 && !w.closed {
+		w.cond.Wait()
+	}
+
+	if w.closed {
+		return nil, false
+	}
+
+	e := w.queue.popFront()
+
+	// wake up writers that are waiting for room in the queue
+	w.cond.Broadcast()
+
+	return e, true
+}
+
+func (w *streamReader) pullRing() (readerEntry, bool) {
+	data, ok := w.ring.Pull()
+	if !ok {
+		return nil, false
+	}
+
+	// entries may have been pushed after the reader has been closed
+	select {
+	case <-w.done:
+		return nil, false
+	default:
+	}
+
+	atomic.AddInt64(&w.ringQueued, -1)
+
+	// move control entries that didn't fit into the ring buffer
+	if atomic.LoadInt32(&w.controlsPending) > 0 {
+		w.mutex.Lock()
+		w.drainControls()
+		w.mutex.Unlock()
+	}
+
+	// wake up writers that are waiting for room in the queue
+	if atomic.LoadInt32(&w.ringWaiters) > 0 {
+		select {
+		case w.ringPulled <- struct{}{}:
+		default:
+		}
+	}
+
+	return data.(readerEntry), true
+}
+
 func (w *streamReader) runInner() error {
 	for {
-		cb, ok := w.buffer.Pull()
+		e, ok := w.pull()
 		if !ok {
+			w.mutex.Lock()
+			overflowed := w.overflowed
+			w.mutex.Unlock()
+
+			if overflowed {
+				return fmt.Errorf("reader is too slow, disconnecting")
+			}
 			return fmt.Errorf("terminated")
 		}
 
-		err := cb.(func() error)()
+		err := e.run()
 		if err != nil {
 			return err
 		}
 	}
 }
 
-func (w *streamReader) push(cb func() error) {
-	ok := w.buffer.Push(cb)
-	if !ok {
+// pushRingEntry adds an entry to the ring buffer.
+// It fails when the ring buffer is full or when control entries are waiting for room,
+// in order to preserve the order of entries.
+func (w *streamReader) pushRingEntry(e readerEntry) bool {
+	if atomic.LoadInt32(&w.controlsPending) != 0 {
+		return false
+	}
+
+	if !w.ring.Push(e) {
+		return false
+	}
+
+	atomic.AddInt64(&w.ringQueued, 1)
+	return true
+}
+
+// drainControls moves control entries into the ring buffer.
+// It must be called with the mutex held.
+func (w *streamReader) drainControls() {
+	for len(w.controls) > 0 {
+		if !w.ring.Push(w.controls[0]) {
+			break
+		}
+		atomic.AddInt64(&w.ringQueued, 1)
+
+		w.controls[0] = nil
+		w.controls = w.controls[1:]
+		atomic.AddInt32(&w.controlsPending, -1)
+	}
+}
+
+// pushBlocking adds an entry to the queue.
+// When the queue is full, it waits until there's room or the context is canceled.
+func (w *streamReader) pushBlocking(ctx context.Context, e readerEntry) error {
+	if w.ring != nil {
+		return w.pushBlockingRing(ctx, e)
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		w.mutex.Lock()
+		w.mutex.Unlock() //nolint:staticcheck
+		w.cond.Broadcast()
+	})
+	defer stop()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for w.queue.len() >= w.queueSize && !w.closed {
+		if ctx.Err() != nil {
+			e.discard()
+			return ctx.Err()
+		}
+		w.cond.Wait()
+	}
+
+	// the reader has been closed, discard the entry
+	if w.closed {
+		e.discard()
+		return nil
+	}
+
+	w.queue.pushBack(e)
+	w.cond.Broadcast()
+
+	return nil
+}
+
+func (w *streamReader) pushBlockingRing(ctx context.Context, e readerEntry) error {
+	// waiters must be registered before trying to push,
+	// otherwise the reader may empty the ring buffer without waking them up.
+	atomic.AddInt32(&w.ringWaiters, 1)
+	defer atomic.AddInt32(&w.ringWaiters, -1)
+
+	for {
+		select {
+		case <-w.done:
+			// the reader has been closed, discard the entry
+			e.discard()
+			return nil
+		default:
+		}
+
+		if w.pushRingEntry(e) {
+			return nil
+		}
+
+		select {
+		case <-w.ringPulled:
+		case <-w.done:
+			e.discard()
+			return nil
+		case <-ctx.Done():
+			e.discard()
+			return ctx.Err()
+		}
+	}
+}
+
+// pushControl adds a callback to the queue, regardless of the queue size.
+// It is used for notifications that must not be discarded.
+func (w *streamReader) pushControl(cb func() error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return
+	}
+
+	if w.started && w.ring == nil {
+		w.queue.pushBack(readerFunc(cb))
+		w.cond.Broadcast()
+		return
+	}
+
+	// units are not pushed into the ring buffer while there are pending controls.
+	atomic.AddInt32(&w.controlsPending, 1)
+
+	if w.started && len(w.controls) == 0 {
+		if w.ring.Push(readerEntry(readerFunc(cb))) {
+			atomic.AddInt64(&w.ringQueued, 1)
+			atomic.AddInt32(&w.controlsPending, -1)
+			return
+		}
+	}
+
+	w.controls = append(w.controls, readerFunc(cb))
+}
+
+// push adds an entry to the queue.
+// nonKeyframe tells whether the entry contains a video unit that is not a key frame.
+func (w *streamReader) push(sf *streamFormat, nonKeyframe bool, e readerEntry) {
+	if w.ring != nil {
+		w.pushRing(e)
+		return
+	}
+
+	w.mutex.Lock()
+
+	if w.closed {
+		w.mutex.Unlock()
+		e.discard()
+		return
+	}
+
+	if w.overflowPolicy == OverflowPolicyDropNonKeyframe {
+		if _, ok := w.skippedFormats[sf]; ok {
+			if nonKeyframe {
+				atomic.AddUint64(&w.droppedUnits, 1)
+				w.mutex.Unlock()
+				e.discard()
+				w.discardedFrames.Increase()
+				return
+			}
+			delete(w.skippedFormats, sf)
+		}
+	}
+
+	if w.queue.len() < w.queueSize {
+		w.queue.pushBack(e)
+		w.mutex.Unlock()
+		w.cond.Broadcast()
+		return
+	}
+
+	// entry that is removed from the queue or not added to it
+	discarded := e
+
+	switch w.overflowPolicy {
+	case OverflowPolicyDropOldest:
+		discarded = w.queue.popFront()
+		w.queue.pushBack(e)
+
+	case OverflowPolicyDropNonKeyframe:
+		if nonKeyframe {
+			w.skippedFormats[sf] = struct{}{}
+		} else {
+			discarded = w.queue.popFront()
+			w.queue.pushBack(e)
+		}
+
+	case OverflowPolicyDisconnect:
+		w.setClosed()
+		w.overflowed = true
+	}
+
+	if w.overflowPolicy != OverflowPolicyDisconnect {
+		atomic.AddUint64(&w.droppedUnits, 1)
+	}
+
+	w.mutex.Unlock()
+	w.cond.Broadcast()
+
+	discarded.discard()
+
+	if w.overflowPolicy != OverflowPolicyDisconnect {
 		w.discardedFrames.Increase()
 	}
+
+	if w.onOverflow != nil {
+		w.onOverflow()
+	}
+}
+
+func (w *streamReader) pushRing(e readerEntry) {
+	if w.pushRingEntry(e) {
+		return
+	}
+
+	e.discard()
+
+	if w.overflowPolicy == OverflowPolicyDisconnect {
+		w.mutex.Lock()
+		if w.closed {
+			w.mutex.Unlock()
+			return
+		}
+		w.setClosed()
+		w.overflowed = true
+		w.mutex.Unlock()
+
+		w.ring.Close()
+	} else {
+		atomic.AddUint64(&w.droppedUnits, 1)
+		w.discardedFrames.Increase()
+	}
+
+	if w.onOverflow != nil {
+		w.onOverflow()
+	}
+}
+
+// onSent is called by the routine of the reader after a unit has been sent.
+func (w *streamReader) onSent(size uint64, pts time.Duration) {
+	now := time.Now()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.rateStart.IsZero() {
+		w.rateStart = now
+	}
+
+	w.rateBytes += size
+
+	if elapsed := now.Sub(w.rateStart); elapsed >= readerRatePeriod {
+		w.bytesPerSecond = float64(w.rateBytes) / elapsed.Seconds()
+		w.rateBytes = 0
+		w.rateStart = now
+	}
+
+	w.lastPTS = pts
+	w.lastPTSFilled = true
+}
+
+// queuedUnits must be called with the mutex held.
+func (w *streamReader) queuedUnits() int {
+	if w.ring != nil {
+		// the counter is decreased by the reader before it is increased by the writer
+		// when the entry is pulled immediately.
+		return max(int(atomic.LoadInt64(&w.ringQueued)), 0) + len(w.controls)
+	}
+	return w.queue.len()
+}
+
+func (w *streamReader) stats(reader Reader) ReaderStats {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	bytesPerSecond := w.bytesPerSecond
+
+	// the reader is not receiving anything
+	if !w.rateStart.IsZero() && time.Since(w.rateStart) >= 2*readerRatePeriod {
+		bytesPerSecond = 0
+	}
+
+	return ReaderStats{
+		Reader:           reader,
+		QueueSize:        w.queueSize,
+		QueuedUnits:      w.queuedUnits(),
+		DroppedUnits:     atomic.LoadUint64(&w.droppedUnits),
+		BytesPerSecond:   bytesPerSecond,
+		LastPTS:          w.lastPTS,
+		LastPTSAvailable: w.lastPTSFilled,
+	}
 }
@@ -0,0 +1,62 @@
+package stream
+
+// readerQueue is a circular buffer of entries.
+// Entries are preallocated, therefore pushing and pulling do not allocate.
+// It is not thread safe.
+type readerQueue struct {
+	entries []readerEntry
+	head    int
+	count   int
+}
+
+func (q *readerQueue) initialize(size int) {
+	if size > len(q.entries) {
+		q.resize(size)
+	}
+}
+
+func (q *readerQueue) resize(size int) {
+	entries := make([]readerEntry, size)
+	for i := 0; i < q.count; i++ {
+		entries[i] = q.entries[(q.head+i)%len(q.entries)]
+	}
+	q.entries = entries
+	q.head = 0
+}
+
+func (q *readerQueue) len() int {
+	return q.count
+}
+
+// pushBack adds an entry at the end of the queue.
+// When there's no room, the capacity is doubled. This happens only with
+// control entries, since units are not pushed when the queue is full.
+func (q *readerQueue) pushBack(e readerEntry) {
+	if q.count == len(q.entries) {
+		if len(q.entries) == 0 {
+			q.resize(1)
+		} else {
+			q.resize(2 * len(q.entries))
+		}
+	}
+
+	q.entries[(q.head+q.count)%len(q.entries)] = e
+	q.count++
+}
+
+// popFront removes the first entry of the queue.
+func (q *readerQueue) popFront() readerEntry {
+	e := q.entries[q.head]
+	q.entries[q.head] = nil
+	q.head = (q.head + 1) % len(q.entries)
+	q.count--
+	return e
+}
+
+// reset discards all entries.
+func (q *readerQueue) reset() {
+	for q.count > 0 {
+		q.popFront().discard()
+	}
+	q.head = 0
+}
@@ -0,0 +1,250 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/ringbuffer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/counterdumper"
+	"github.com/flynnletford/mediamtx/src/logger"
+)
+
+type nilLogger struct{}
+
+func (nilLogger) Log(logger.Level, string, ...interface{}) {
+}
+
+func TestStreamReaderOverflow(t *testing.T) {
+	sf := &streamFormat{}
+
+	for _, ca := range []struct {
+		name     string
+		policy   OverflowPolicy
+		keyframe []bool
+		queued   []int
+	}{
+		{
+			"drop newest",
+			OverflowPolicyDropNewest,
+			[]bool{true, false, false, false},
+			[]int{0, 1},
+		},
+		{
+			"drop oldest",
+			OverflowPolicyDropOldest,
+			[]bool{true, false, false, false},
+			[]int{2, 3},
+		},
+		{
+			"drop non-keyframe",
+			OverflowPolicyDropNonKeyframe,
+			[]bool{true, false, false, true, false},
+			[]int{1, 3},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			overflows := 0
+
+			sr := &streamReader{
+				queueSize:      2,
+				overflowPolicy: ca.policy,
+				onOverflow:     func() { overflows++ },
+				parent:         nilLogger{},
+			}
+			sr.initialize()
+			sr.start()
+			defer sr.stop()
+
+			// block the reader until units are queued
+			started := make(chan struct{})
+			block := make(chan struct{})
+			sr.push(sf, false, readerFunc(func() error {
+				close(started)
+				<-block
+				return nil
+			}))
+			<-started
+
+			var received []int
+			done := make(chan struct{})
+
+			for i, keyframe := range ca.keyframe {
+				ci := i
+				sr.push(sf, !keyframe, readerFunc(func() error {
+					received = append(received, ci)
+					if len(received) == len(ca.queued) {
+						close(done)
+					}
+					return nil
+				}))
+			}
+
+			close(block)
+			<-done
+
+			require.Equal(t, ca.queued, received)
+			require.Equal(t, len(ca.keyframe)-2, overflows)
+		})
+	}
+}
+
+func TestStreamReaderOverflowDisconnect(t *testing.T) {
+	sr := &streamReader{
+		queueSize:      1,
+		overflowPolicy: OverflowPolicyDisconnect,
+		parent:         nilLogger{},
+	}
+	sr.initialize()
+	sr.start()
+	defer sr.stop()
+
+	started := make(chan struct{})
+	block := make(chan struct{})
+	sr.push(nil, false, readerFunc(func() error {
+		close(started)
+		<-block
+		return nil
+	}))
+	<-started
+
+	sr.push(nil, false, readerFunc(func() error { return nil }))
+	sr.push(nil, false, readerFunc(func() error { return nil }))
+
+	close(block)
+
+	err := <-sr.error()
+	require.EqualError(t, err, "reader is too slow, disconnecting")
+}
+
+func TestStreamReaderControlOrder(t *testing.T) {
+	sf := &streamFormat{}
+
+	for _, ca := range []struct {
+		name     string
+		policy   OverflowPolicy
+		received []string
+	}{
+		{
+			"drop newest",
+			OverflowPolicyDropNewest,
+			[]string{"unit 0", "unit 1", "control 0", "control 1"},
+		},
+		{
+			"drop oldest",
+			OverflowPolicyDropOldest,
+			[]string{"unit 1", "control 0", "control 1", "unit 2"},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			sr := &streamReader{
+				queueSize:      2,
+				overflowPolicy: ca.policy,
+				parent:         nilLogger{},
+			}
+			sr.initialize()
+			sr.start()
+			defer sr.stop()
+
+			started := make(chan struct{})
+			block := make(chan struct{})
+			sr.push(sf, false, readerFunc(func() error {
+				close(started)
+				<-block
+				return nil
+			}))
+			<-started
+
+			var received []string
+			done := make(chan struct{})
+
+			entry := func(name string) func() error {
+				return func() error {
+					received = append(received, name)
+					if len(received) == len(ca.received) {
+						close(done)
+					}
+					return nil
+				}
+			}
+
+			sr.push(sf, false, readerFunc(entry("unit 0")))
+			sr.push(sf, false, readerFunc(entry("unit 1")))
+
+			// control entries are never discarded, even when the queue is full
+			sr.pushControl(entry("control 0"))
+			sr.pushControl(entry("control 1"))
+
+			sr.push(sf, false, readerFunc(entry("unit 2")))
+
+			close(block)
+			<-done
+
+			require.Equal(t, ca.received, received)
+		})
+	}
+}
+
+func BenchmarkStreamReader(b *testing.B) {
+	// queue used by readers before overflow policies were introduced
+	b.Run("baseline", func(b *testing.B) {
+		ring, _ := ringbuffer.New(512)
+
+		discardedFrames := &counterdumper.CounterDumper{
+			OnReport: func(uint64) {},
+		}
+		discardedFrames.Start()
+		defer discardedFrames.Stop()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				cb, ok := ring.Pull()
+				if !ok {
+					return
+				}
+				cb.(func() error)() //nolint:errcheck
+			}
+		}()
+
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			if !ring.Push(func() error { return nil }) {
+				discardedFrames.Increase()
+			}
+		}
+
+		ring.Close()
+		<-done
+	})
+
+	for _, ca := range []struct {
+		name   string
+		policy OverflowPolicy
+	}{
+		{"drop newest", OverflowPolicyDropNewest},
+		{"drop oldest", OverflowPolicyDropOldest},
+		{"drop non-keyframe", OverflowPolicyDropNonKeyframe},
+	} {
+		b.Run(ca.name, func(b *testing.B) {
+			sr := &streamReader{
+				queueSize:      512,
+				overflowPolicy: ca.policy,
+				parent:         nilLogger{},
+			}
+			sr.initialize()
+			sr.start()
+			defer sr.stop()
+
+			sf := &streamFormat{}
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				sr.push(sf, false, readerFunc(func() error { return nil }))
+			}
+		})
+	}
+}
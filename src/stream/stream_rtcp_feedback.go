@@ -0,0 +1,99 @@
+package stream
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/rtcpreceiver"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// period of RTCP feedback, when RTCPFeedbackPeriod is zero.
+const rtcpFeedbackDefaultPeriod = 1 * time.Second
+
+// WriteRTCPFeedbackFunc is the prototype of the function passed as WriteRTCPFeedback.
+// It is called by a dedicated routine of each format, therefore it must not call methods of Stream.
+type WriteRTCPFeedbackFunc = func(medi *description.Media, pkts []rtcp.Packet)
+
+// rtcpFeedback generates RTCP receiver reports and REMB packets of a format,
+// from statistics of received RTP packets.
+type rtcpFeedback struct {
+	clock     ClockSource
+	period    time.Duration
+	media     *description.Media
+	clockRate int
+	write     WriteRTCPFeedbackFunc
+
+	receiver *rtcpreceiver.RTCPReceiver
+
+	mutex     sync.Mutex
+	bytes     uint64
+	lastTime  time.Time
+	ssrc      uint32
+	ssrcFound bool
+}
+
+func (f *rtcpFeedback) initialize() error {
+	f.receiver = &rtcpreceiver.RTCPReceiver{
+		ClockRate:       f.clockRate,
+		Period:          f.period,
+		TimeNow:         f.clock.Now,
+		WritePacketRTCP: f.onReport,
+	}
+	return f.receiver.Initialize()
+}
+
+func (f *rtcpFeedback) close() {
+	f.receiver.Close()
+}
+
+func (f *rtcpFeedback) onReport(rr rtcp.Packet) {
+	now := f.clock.Now()
+
+	f.mutex.Lock()
+	bytes := f.bytes
+	elapsed := now.Sub(f.lastTime)
+	ssrc, ssrcFound := f.ssrc, f.ssrcFound
+	f.bytes = 0
+	f.lastTime = now
+	f.mutex.Unlock()
+
+	pkts := []rtcp.Packet{rr}
+
+	if ssrcFound && elapsed > 0 {
+		pkts = append(pkts, &rtcp.ReceiverEstimatedMaximumBitrate{
+			SenderSSRC: *f.receiver.LocalSSRC,
+			Bitrate:    float32(float64(bytes*8) / elapsed.Seconds()),
+			SSRCs:      []uint32{ssrc},
+		})
+	}
+
+	f.write(f.media, pkts)
+}
+
+func (f *rtcpFeedback) processPacket(pkt *rtp.Packet, ptsEqualsDTS bool) {
+	now := f.clock.Now()
+
+	// packets with an unexpected SSRC are not taken into account by receiver reports
+	f.receiver.ProcessPacket(pkt, now, ptsEqualsDTS) //nolint:errcheck
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.lastTime.IsZero() {
+		f.lastTime = now
+	}
+
+	f.bytes += uint64(pkt.MarshalSize())
+
+	if !f.ssrcFound {
+		f.ssrc = pkt.SSRC
+		f.ssrcFound = true
+	}
+}
+
+func (f *rtcpFeedback) processSenderReport(sr *rtcp.SenderReport) {
+	f.receiver.ProcessSenderReport(sr, f.clock.Now())
+}
@@ -0,0 +1,1228 @@
+package stream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/formatprocessor"
+	"github.com/flynnletford/mediamtx/src/unit"
+)
+
+func TestStreamStats(t *testing.T) {
+	desc := &description.Session{Medias: []*description.Media{
+		{
+			Type: description.MediaTypeVideo,
+			Formats: []format.Format{&format.H264{
+				PayloadTyp:        96,
+				PacketizationMode: 1,
+			}},
+		},
+		{
+			Type: description.MediaTypeAudio,
+			Formats: []format.Format{&format.G711{
+				PayloadTyp:   8,
+				MULaw:        false,
+				SampleRate:   8000,
+				ChannelCount: 1,
+			}},
+		},
+	}}
+
+	strm := &Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+		Clock:              &testClock{now: time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC)},
+		Parent:             nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	r := nilLogger{}
+	received := make(chan struct{})
+
+	strm.SetReaderOptions(r, ReaderOptions{QueueSize: 16})
+	strm.AddReader(r, desc.Medias[0], desc.Medias[0].Formats[0], func(_ unit.Unit) error {
+		close(received)
+		return nil
+	})
+	strm.StartReader(r)
+	defer strm.RemoveReader(r)
+
+	strm.WriteUnit(desc.Medias[0], desc.Medias[0].Formats[0], &unit.H264{
+		Base: unit.Base{
+			PTS: 2 * 90000,
+		},
+		AU: [][]byte{{5, 1}},
+	})
+
+	<-received
+
+	require.Eventually(t, func() bool {
+		return strm.Stats().Readers[0].LastPTSAvailable
+	}, time.Second, 5*time.Millisecond)
+
+	stats := strm.Stats()
+
+	require.Equal(t, []FormatInfo{
+		{
+			Media:     desc.Medias[0],
+			Format:    desc.Medias[0].Formats[0],
+			Codec:     "H264",
+			ClockRate: 90000,
+			Readers:   1,
+		},
+		{
+			Media:     desc.Medias[1],
+			Format:    desc.Medias[1].Formats[0],
+			Codec:     "G711",
+			ClockRate: 8000,
+			Readers:   0,
+		},
+	}, stats.Formats)
+
+	require.Equal(t, []ReaderStats{{
+		Reader:           r,
+		QueueSize:        16,
+		LastPTS:          2 * time.Second,
+		LastPTSAvailable: true,
+	}}, stats.Readers)
+}
+
+func TestStreamUnitHooks(t *testing.T) {
+	desc := &description.Session{Medias: []*description.Media{{
+		Type: description.MediaTypeVideo,
+		Formats: []format.Format{&format.H264{
+			PayloadTyp:        96,
+			PacketizationMode: 1,
+		}},
+	}}}
+
+	strm := &Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+		Parent:             nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	// drop units with PTS equal to zero
+	strm.AddUnitHook(func(_ *description.Media, _ format.Format, u unit.Unit) unit.Unit {
+		if u.GetPTS() == 0 {
+			return nil
+		}
+		return u
+	})
+
+	// remove SEI
+	strm.AddUnitHook(func(_ *description.Media, _ format.Format, u unit.Unit) unit.Unit {
+		tunit := u.(*unit.H264)
+
+		var au [][]byte
+		for _, nalu := range tunit.AU {
+			if h264.NALUType(nalu[0]&0x1F) != h264.NALUTypeSEI {
+				au = append(au, nalu)
+			}
+		}
+
+		return &unit.H264{
+			Base: tunit.Base,
+			AU:   au,
+		}
+	})
+
+	r := nilLogger{}
+	received := make(chan unit.Unit)
+
+	strm.AddReader(r, desc.Medias[0], desc.Medias[0].Formats[0], func(u unit.Unit) error {
+		received <- u
+		return nil
+	})
+	strm.StartReader(r)
+	defer strm.RemoveReader(r)
+
+	for i := 0; i < 2; i++ {
+		strm.WriteUnit(desc.Medias[0], desc.Medias[0].Formats[0], &unit.H264{
+			Base: unit.Base{
+				PTS: int64(i) * 90000,
+			},
+			AU: [][]byte{
+				{byte(h264.NALUTypeSEI), 1},
+				{5, 1},
+			},
+		})
+	}
+
+	u := <-received
+	require.Equal(t, int64(90000), u.GetPTS())
+	require.Equal(t, [][]byte{{5, 1}}, u.(*unit.H264).AU)
+}
+
+func TestStreamCacheGOP(t *testing.T) {
+	desc := &description.Session{Medias: []*description.Media{{
+		Type: description.MediaTypeVideo,
+		Formats: []format.Format{&format.H264{
+			PayloadTyp:        96,
+			PacketizationMode: 1,
+		}},
+	}}}
+
+	strm := &Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+		CacheGOP:           true,
+		Parent:             nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	writeUnit := func(pts int64, au [][]byte) {
+		strm.WriteUnit(desc.Medias[0], desc.Medias[0].Formats[0], &unit.H264{
+			Base: unit.Base{
+				PTS: pts,
+			},
+			AU: au,
+		})
+	}
+
+	idr := [][]byte{
+		{byte(h264.NALUTypeSPS), 1},
+		{byte(h264.NALUTypePPS), 1},
+		{byte(h264.NALUTypeIDR), 1},
+	}
+	nonIDR := [][]byte{{byte(h264.NALUTypeNonIDR), 1}}
+
+	writeUnit(0, nonIDR)
+	writeUnit(1, idr)
+	writeUnit(2, nonIDR)
+	writeUnit(3, idr)
+	writeUnit(4, nonIDR)
+	writeUnit(5, nonIDR)
+
+	r := nilLogger{}
+	received := make(chan unit.Unit, 10)
+
+	strm.AddReader(r, desc.Medias[0], desc.Medias[0].Formats[0], func(u unit.Unit) error {
+		received <- u
+		return nil
+	})
+	strm.StartReader(r)
+	defer strm.RemoveReader(r)
+
+	type testReader struct {
+		nilLogger
+	}
+
+	// the GOP doesn't fit into the queue of this reader
+	r2 := &testReader{}
+	received2 := make(chan unit.Unit, 10)
+
+	strm.SetReaderOptions(r2, ReaderOptions{QueueSize: 2})
+	strm.AddReader(r2, desc.Medias[0], desc.Medias[0].Formats[0], func(u unit.Unit) error {
+		received2 <- u
+		return nil
+	})
+	strm.StartReader(r2)
+	defer strm.RemoveReader(r2)
+
+	writeUnit(6, nonIDR)
+
+	for _, pts := range []int64{3, 4, 5, 6} {
+		u := <-received
+		require.Equal(t, pts, u.GetPTS())
+	}
+
+	u := <-received2
+	require.Equal(t, int64(6), u.GetPTS())
+}
+
+func TestStreamWriteUnitCtx(t *testing.T) {
+	desc := &description.Session{Medias: []*description.Media{{
+		Type: description.MediaTypeVideo,
+		Formats: []format.Format{&format.H264{
+			PayloadTyp:        96,
+			PacketizationMode: 1,
+		}},
+	}}}
+
+	strm := &Stream{
+		WriteQueueSize:     1,
+		UDPMaxPayloadSize:  1472,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+		Parent:             nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	r := nilLogger{}
+	unblock := make(chan struct{})
+	var received []int64
+
+	strm.AddReader(r, desc.Medias[0], desc.Medias[0].Formats[0], func(u unit.Unit) error {
+		<-unblock
+		received = append(received, u.GetPTS())
+		return nil
+	})
+	strm.StartReader(r)
+
+	writeUnit := func(ctx context.Context, pts int64) error {
+		return strm.WriteUnitCtx(ctx, desc.Medias[0], desc.Medias[0].Formats[0], &unit.H264{
+			Base: unit.Base{
+				PTS: pts,
+			},
+			AU: [][]byte{{5, 1}},
+		})
+	}
+
+	// the first unit is pulled by the reader, the second one fills the queue
+	for i := 0; i < 2; i++ {
+		err = writeUnit(context.Background(), int64(i))
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		return strm.Stats().Readers[0].QueuedUnits == 1
+	}, time.Second, 5*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = writeUnit(ctx, 2)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(unblock)
+
+	for i := 3; i < 6; i++ {
+		err = writeUnit(context.Background(), int64(i))
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		return strm.Stats().Readers[0].QueuedUnits == 0
+	}, time.Second, 5*time.Millisecond)
+
+	strm.RemoveReader(r)
+
+	require.Equal(t, []int64{0, 1, 3, 4, 5}, received)
+}
+
+func TestStreamWriteUnitCtxMediaChange(t *testing.T) {
+	medi1 := &description.Media{
+		Type: description.MediaTypeVideo,
+		Formats: []format.Format{&format.H264{
+			PayloadTyp:        96,
+			PacketizationMode: 1,
+		}},
+	}
+
+	desc := &description.Session{Medias: []*description.Media{medi1}}
+
+	strm := &Stream{
+		WriteQueueSize:     1,
+		UDPMaxPayloadSize:  1472,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+		Parent:             nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	medi2 := &description.Media{
+		Type: description.MediaTypeAudio,
+		Formats: []format.Format{&format.G711{
+			PayloadTyp:   8,
+			MULaw:        false,
+			SampleRate:   8000,
+			ChannelCount: 1,
+		}},
+	}
+
+	r := nilLogger{}
+	inCallback := make(chan struct{})
+	proceed := make(chan struct{})
+	added := make(chan struct{})
+	received := make(chan int64, 2)
+
+	strm.AddReader(r, medi1, medi1.Formats[0], func(u unit.Unit) error {
+		received <- u.GetPTS()
+		return nil
+	})
+	strm.SetReaderOnMediaChange(r, func(medi *description.Media, _ bool) error {
+		close(inCallback)
+		<-proceed
+		strm.AddReader(r, medi, medi.Formats[0], func(_ unit.Unit) error {
+			return nil
+		})
+		close(added)
+		return nil
+	})
+	strm.StartReader(r)
+	defer strm.RemoveReader(r)
+
+	err = strm.AddMedia(medi2)
+	require.NoError(t, err)
+	<-inCallback
+
+	writeUnit := func(ctx context.Context, pts int64) error {
+		return strm.WriteUnitCtx(ctx, medi1, medi1.Formats[0], &unit.H264{
+			Base: unit.Base{
+				PTS: pts,
+			},
+			AU: [][]byte{{5, 1}},
+		})
+	}
+
+	// fill the queue while the reader is inside the callback
+	err = writeUnit(context.Background(), 0)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- writeUnit(ctx, 1)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("writer did not wait for room in the queue")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the callback calls AddReader() while the writer is waiting
+	close(proceed)
+
+	select {
+	case <-added:
+	case <-time.After(2 * time.Second):
+		t.Fatal("AddReader() is blocked by the writer")
+	}
+
+	require.NoError(t, <-done)
+	require.Equal(t, int64(0), <-received)
+	require.Equal(t, int64(1), <-received)
+}
+
+func TestStreamJitterBuffer(t *testing.T) {
+	desc := &description.Session{Medias: []*description.Media{{
+		Type: description.MediaTypeAudio,
+		Formats: []format.Format{&format.G711{
+			PayloadTyp:   8,
+			MULaw:        false,
+			SampleRate:   8000,
+			ChannelCount: 1,
+		}},
+	}}}
+
+	strm := &Stream{
+		WriteQueueSize:    512,
+		UDPMaxPayloadSize: 1472,
+		Desc:              desc,
+		JitterBufferSize:  3,
+		Parent:            nilLogger{},
+	}
+	err := strm.Initialize()
+	require.EqualError(t, err, "jitter buffer size must be a power of two")
+
+	strm.JitterBufferSize = 4
+	err = strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	r := nilLogger{}
+	received := make(chan unit.Unit, 10)
+
+	strm.AddReader(r, desc.Medias[0], desc.Medias[0].Formats[0], func(u unit.Unit) error {
+		received <- u
+		return nil
+	})
+	strm.StartReader(r)
+	defer strm.RemoveReader(r)
+
+	ntp := time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC)
+
+	for _, seq := range []uint16{0, 2, 1, 1, 3, 9} {
+		strm.WriteRTPPacket(desc.Medias[0], desc.Medias[0].Formats[0], &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    8,
+				SequenceNumber: seq,
+				Timestamp:      uint32(seq) * 8000,
+				SSRC:           1234,
+			},
+			Payload: []byte{1, 2, 3, 4},
+		}, ntp.Add(time.Duration(seq)*time.Second), int64(seq)*8000)
+	}
+
+	for _, seq := range []int64{0, 1, 2, 3, 9} {
+		u := <-received
+		require.Equal(t, seq*8000, u.GetPTS())
+		require.Equal(t, ntp.Add(time.Duration(seq)*time.Second), u.GetNTP())
+	}
+
+	require.Equal(t, uint64(5), strm.Stats().Formats[0].PacketsLost)
+}
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func TestStreamWriteRTPPacketRaw(t *testing.T) {
+	desc := &description.Session{Medias: []*description.Media{
+		{
+			Type: description.MediaTypeAudio,
+			Formats: []format.Format{&format.G711{
+				PayloadTyp:   8,
+				MULaw:        false,
+				SampleRate:   8000,
+				ChannelCount: 1,
+			}},
+		},
+		{
+			Type: description.MediaTypeAudio,
+			Formats: []format.Format{&format.G711{
+				PayloadTyp:   8,
+				MULaw:        false,
+				SampleRate:   8000,
+				ChannelCount: 1,
+			}},
+		},
+	}}
+
+	t0 := time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC)
+	clock := &testClock{now: t0}
+
+	strm := &Stream{
+		WriteQueueSize:    512,
+		UDPMaxPayloadSize: 1472,
+		Desc:              desc,
+		Clock:             clock,
+		Parent:            nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	r := nilLogger{}
+	received := make(chan unit.Unit, 10)
+
+	for _, medi := range desc.Medias {
+		strm.AddReader(r, medi, medi.Formats[0], func(u unit.Unit) error {
+			received <- u
+			return nil
+		})
+	}
+	strm.StartReader(r)
+	defer strm.RemoveReader(r)
+
+	for _, ca := range []struct {
+		elapsed time.Duration
+		media   int
+		ts      uint32
+		sr      *rtcp.SenderReport
+		pts     int64
+		ntp     time.Time
+	}{
+		{
+			elapsed: 0,
+			media:   0,
+			ts:      0xFFFFF000,
+			pts:     0,
+			ntp:     t0,
+		},
+		{
+			elapsed: 1 * time.Second,
+			media:   1,
+			ts:      50000,
+			pts:     8000,
+			ntp:     t0.Add(1 * time.Second),
+		},
+		{
+			// unwrapping
+			elapsed: 1 * time.Second,
+			media:   0,
+			ts:      3904, // 0xFFFFF000 + 8000, wrapped
+			pts:     8000,
+			ntp:     t0.Add(1 * time.Second),
+		},
+		{
+			// discontinuity
+			elapsed: 3 * time.Second,
+			media:   0,
+			ts:      3904 + 100*8000,
+			pts:     3 * 8000,
+			ntp:     t0.Add(3 * time.Second),
+		},
+		{
+			// sender report
+			elapsed: 4 * time.Second,
+			media:   0,
+			ts:      3904 + 101*8000,
+			sr: &rtcp.SenderReport{
+				NTPTime: uint64(t0.Add(10*time.Second).Unix()+ntpEpochOffset) << 32,
+				RTPTime: 3904 + 100*8000,
+			},
+			pts: 4 * 8000,
+			ntp: t0.Add(11 * time.Second),
+		},
+		{
+			// timestamp going back
+			elapsed: 5 * time.Second,
+			media:   0,
+			ts:      3904 + 100*8000,
+			pts:     4 * 8000,
+			ntp:     t0.Add(10 * time.Second),
+		},
+		{
+			elapsed: 6 * time.Second,
+			media:   0,
+			ts:      3904 + 102*8000,
+			pts:     5 * 8000,
+			ntp:     t0.Add(12 * time.Second),
+		},
+	} {
+		clock.now = t0.Add(ca.elapsed)
+
+		if ca.sr != nil {
+			strm.WriteRTCPSenderReport(desc.Medias[ca.media], ca.sr)
+		}
+
+		strm.WriteRTPPacketRaw(desc.Medias[ca.media], desc.Medias[ca.media].Formats[0], &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    8,
+				SequenceNumber: 1,
+				Timestamp:      ca.ts,
+				SSRC:           1234,
+			},
+			Payload: []byte{1, 2, 3, 4},
+		})
+
+		u := <-received
+		require.Equal(t, ca.pts, u.GetPTS())
+		require.True(t, ca.ntp.Equal(u.GetNTP()))
+	}
+}
+
+func TestTimestampNormalizerOutOfOrder(t *testing.T) {
+	for _, ca := range []struct {
+		name  string
+		forma format.Format
+		pts   []int64
+	}{
+		{
+			"h264",
+			&format.H264{PayloadTyp: 96, PacketizationMode: 1},
+			[]int64{0, 9000, 3000, 6000, 18000},
+		},
+		{
+			"vp8",
+			&format.VP8{PayloadTyp: 96},
+			[]int64{0, 9000, 9000, 9000, 18000},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			n := &timestampNormalizer{clock: &testClock{now: time.Now()}}
+			n.initialize()
+
+			sf := &streamFormat{format: ca.forma}
+
+			for i, ts := range []uint32{1000, 10000, 4000, 7000, 19000} {
+				pts, _ := n.normalize(sf, &rtp.Packet{Header: rtp.Header{Timestamp: ts}})
+				require.Equal(t, ca.pts[i], pts)
+			}
+		})
+	}
+}
+
+func TestStreamAddRemoveMedia(t *testing.T) {
+	medi1 := &description.Media{
+		Type: description.MediaTypeVideo,
+		Formats: []format.Format{&format.H264{
+			PayloadTyp:        96,
+			PacketizationMode: 1,
+		}},
+	}
+
+	desc := &description.Session{Medias: []*description.Media{medi1}}
+
+	strm := &Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+		Parent:             nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	medi2 := &description.Media{
+		Type: description.MediaTypeAudio,
+		Formats: []format.Format{&format.G711{
+			PayloadTyp:   8,
+			MULaw:        false,
+			SampleRate:   8000,
+			ChannelCount: 1,
+		}},
+	}
+
+	r := nilLogger{}
+	changed := make(chan bool)
+	received := make(chan unit.Unit, 1)
+
+	strm.AddReader(r, medi1, medi1.Formats[0], func(_ unit.Unit) error {
+		return nil
+	})
+	strm.SetReaderOnMediaChange(r, func(medi *description.Media, added bool) error {
+		require.Equal(t, medi2, medi)
+		if added {
+			strm.AddReader(r, medi, medi.Formats[0], func(u unit.Unit) error {
+				received <- u
+				return nil
+			})
+		}
+		changed <- added
+		return nil
+	})
+	strm.StartReader(r)
+	defer strm.RemoveReader(r)
+
+	err = strm.AddMedia(medi2)
+	require.NoError(t, err)
+
+	err = strm.AddMedia(medi2)
+	require.EqualError(t, err, "media already present")
+
+	require.Equal(t, true, <-changed)
+	require.Equal(t, []*description.Media{medi1, medi2}, strm.Desc.Medias)
+	require.Equal(t, []*description.Media{medi1}, desc.Medias)
+	require.ElementsMatch(t, []format.Format{medi1.Formats[0], medi2.Formats[0]}, strm.ReaderFormats(r))
+
+	strm.WriteUnit(medi2, medi2.Formats[0], &unit.G711{
+		Base: unit.Base{
+			PTS: 30000,
+		},
+		Samples: []byte{1, 2, 3, 4},
+	})
+
+	u := <-received
+	require.Equal(t, int64(30000), u.GetPTS())
+
+	err = strm.RemoveMedia(medi2)
+	require.NoError(t, err)
+
+	err = strm.RemoveMedia(medi2)
+	require.EqualError(t, err, "media not found")
+
+	require.Equal(t, false, <-changed)
+	require.Equal(t, []*description.Media{medi1}, strm.Desc.Medias)
+	require.Equal(t, []format.Format{medi1.Formats[0]}, strm.ReaderFormats(r))
+}
+
+func TestStreamUnitPayload(t *testing.T) {
+	medi := &description.Media{
+		Type: description.MediaTypeAudio,
+		Formats: []format.Format{&format.G711{
+			PayloadTyp:   8,
+			MULaw:        false,
+			SampleRate:   8000,
+			ChannelCount: 1,
+		}},
+	}
+
+	strm := &Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               &description.Session{Medias: []*description.Media{medi}},
+		GenerateRTPPackets: true,
+		Parent:             nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	unblock := make(chan struct{})
+	received := make(chan []byte, 10)
+
+	type testReader struct {
+		nilLogger
+		id int
+	}
+
+	r1 := &testReader{id: 1}
+	strm.AddReader(r1, medi, medi.Formats[0], func(u unit.Unit) error {
+		received <- append([]byte(nil), u.(*unit.G711).Samples...)
+		return nil
+	})
+	strm.StartReader(r1)
+
+	r2 := &testReader{id: 2}
+	strm.SetReaderOptions(r2, ReaderOptions{
+		QueueSize:      1,
+		OverflowPolicy: OverflowPolicyDropOldest,
+	})
+	strm.AddReader(r2, medi, medi.Formats[0], func(_ unit.Unit) error {
+		<-unblock
+		return nil
+	})
+	strm.StartReader(r2)
+
+	var payloads []*unit.Payload
+
+	for i := 0; i < 4; i++ {
+		p := unit.NewPayload(4)
+		copy(p.Bytes(), []byte{byte(i), 2, 3, 4})
+		payloads = append(payloads, p)
+
+		strm.WriteUnit(medi, medi.Formats[0], &unit.G711{
+			Base: unit.Base{
+				PTS:     int64(i) * 8000,
+				Payload: p,
+			},
+			Samples: p.Bytes(),
+		})
+
+		p.Release()
+
+		require.Equal(t, []byte{byte(i), 2, 3, 4}, <-received)
+	}
+
+	close(unblock)
+	strm.RemoveReader(r1)
+	strm.RemoveReader(r2)
+
+	// all references have been released
+	for _, p := range payloads {
+		require.Panics(t, p.Release)
+	}
+}
+
+func TestStreamRTCPFeedback(t *testing.T) {
+	desc := &description.Session{Medias: []*description.Media{{
+		Type: description.MediaTypeAudio,
+		Formats: []format.Format{&format.G711{
+			PayloadTyp:   8,
+			MULaw:        false,
+			SampleRate:   8000,
+			ChannelCount: 1,
+		}},
+	}}}
+
+	feedback := make(chan []rtcp.Packet, 10)
+
+	strm := &Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               desc,
+		RTCPFeedbackPeriod: 100 * time.Millisecond,
+		WriteRTCPFeedback: func(medi *description.Media, pkts []rtcp.Packet) {
+			require.Equal(t, desc.Medias[0], medi)
+			feedback <- pkts
+		},
+		Parent: nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	for _, seq := range []uint16{1, 2, 4} {
+		strm.WriteRTPPacket(desc.Medias[0], desc.Medias[0].Formats[0], &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    8,
+				SequenceNumber: seq,
+				Timestamp:      uint32(seq) * 4,
+				SSRC:           1234,
+			},
+			Payload: []byte{1, 2, 3, 4},
+		}, time.Time{}, int64(seq)*4)
+	}
+
+	pkts := <-feedback
+	require.Len(t, pkts, 2)
+
+	rr, ok := pkts[0].(*rtcp.ReceiverReport)
+	require.True(t, ok)
+	require.Len(t, rr.Reports, 1)
+	require.Equal(t, uint32(1234), rr.Reports[0].SSRC)
+	require.Equal(t, uint32(4), rr.Reports[0].LastSequenceNumber)
+	require.Equal(t, uint32(1), rr.Reports[0].TotalLost)
+
+	remb, ok := pkts[1].(*rtcp.ReceiverEstimatedMaximumBitrate)
+	require.True(t, ok)
+	require.Equal(t, rr.SSRC, remb.SenderSSRC)
+	require.Equal(t, []uint32{1234}, remb.SSRCs)
+	require.Greater(t, remb.Bitrate, float32(0))
+}
+
+func TestStreamFormatMeter(t *testing.T) {
+	desc := &description.Session{Medias: []*description.Media{{
+		Type: description.MediaTypeVideo,
+		Formats: []format.Format{&format.H264{
+			PayloadTyp:        96,
+			PacketizationMode: 1,
+		}},
+	}}}
+
+	t0 := time.Date(2008, 5, 20, 22, 15, 25, 0, time.UTC)
+	clock := &testClock{now: t0}
+
+	strm := &Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+		Clock:              clock,
+		Parent:             nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	// 25 frames per second, a key frame every second
+	for i := 0; i < 100; i++ {
+		clock.now = t0.Add(time.Duration(i) * 40 * time.Millisecond)
+
+		au := [][]byte{{1, 1}}
+		if (i % 25) == 0 {
+			au = [][]byte{{5, 1}}
+		}
+
+		strm.WriteUnit(desc.Medias[0], desc.Medias[0].Formats[0], &unit.H264{
+			Base: unit.Base{
+				PTS: int64(i) * 3600,
+			},
+			AU: au,
+		})
+	}
+
+	clock.now = t0.Add(4 * time.Second)
+
+	fi := strm.Stats().Formats[0]
+	require.Equal(t, float64(100*14*8)/4, fi.Bitrate)
+	require.Equal(t, float64(25), fi.FrameRate)
+	require.Equal(t, 1*time.Second, fi.KeyframeInterval)
+
+	// measurements expire after the window
+	clock.now = t0.Add(10 * time.Second)
+
+	fi = strm.Stats().Formats[0]
+	require.Equal(t, float64(0), fi.Bitrate)
+	require.Equal(t, float64(0), fi.FrameRate)
+	require.Equal(t, 1*time.Second, fi.KeyframeInterval)
+}
+
+func TestStreamRequestKeyframeSample(t *testing.T) {
+	desc := &description.Session{Medias: []*description.Media{
+		{
+			Type: description.MediaTypeVideo,
+			Formats: []format.Format{&format.H264{
+				PayloadTyp:        96,
+				SPS:               formatprocessor.H264DefaultSPS,
+				PPS:               formatprocessor.H264DefaultPPS,
+				PacketizationMode: 1,
+			}},
+		},
+		{
+			Type: description.MediaTypeAudio,
+			Formats: []format.Format{&format.G711{
+				PayloadTyp:   8,
+				MULaw:        false,
+				SampleRate:   8000,
+				ChannelCount: 1,
+			}},
+		},
+	}}
+
+	strm := &Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+		Parent:             nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	_, err = strm.RequestKeyframeSample(context.Background(), desc.Medias[1])
+	require.EqualError(t, err, "media is not a video media")
+
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer ctxCancel()
+	_, err = strm.RequestKeyframeSample(ctx, desc.Medias[0])
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	done := make(chan unit.Unit)
+
+	go func() {
+		u, err2 := strm.RequestKeyframeSample(context.Background(), desc.Medias[0])
+		require.NoError(t, err2)
+		done <- u
+	}()
+
+	// wait for the request to be registered
+	time.Sleep(50 * time.Millisecond)
+
+	strm.WriteUnit(desc.Medias[0], desc.Medias[0].Formats[0], &unit.H264{
+		Base: unit.Base{
+			PTS: 3000,
+		},
+		AU: [][]byte{{1, 1}},
+	})
+
+	strm.WriteUnit(desc.Medias[0], desc.Medias[0].Formats[0], &unit.H264{
+		Base: unit.Base{
+			PTS: 6000,
+		},
+		AU: [][]byte{{5, 1}},
+	})
+
+	u := <-done
+	require.Equal(t, int64(6000), u.GetPTS())
+	require.Equal(t, [][]byte{
+		formatprocessor.H264DefaultSPS,
+		formatprocessor.H264DefaultPPS,
+		{5, 1},
+	}, u.(*unit.H264).AU)
+	unit.Release(u)
+
+	// the last key frame is returned immediately
+	u, err = strm.RequestKeyframeSample(context.Background(), desc.Medias[0])
+	require.NoError(t, err)
+	require.Equal(t, int64(6000), u.GetPTS())
+	unit.Release(u)
+}
+
+func TestStreamParametersChange(t *testing.T) {
+	forma := &format.H264{
+		PayloadTyp:        96,
+		SPS:               []byte{0x07, 0x01, 0x02, 0x03},
+		PPS:               []byte{0x08, 0x01},
+		PacketizationMode: 1,
+	}
+
+	medi := &description.Media{
+		Type:    description.MediaTypeVideo,
+		Formats: []format.Format{forma},
+	}
+
+	streamChanged := make(chan struct{}, 1)
+
+	strm := &Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               &description.Session{Medias: []*description.Media{medi}},
+		GenerateRTPPackets: true,
+		OnParametersChange: func(medi2 *description.Media, forma2 format.Format) {
+			require.Equal(t, medi, medi2)
+			require.Equal(t, forma, forma2)
+			streamChanged <- struct{}{}
+		},
+		Parent: nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	r := nilLogger{}
+	events := make(chan string, 2)
+
+	strm.AddReader(r, medi, forma, func(_ unit.Unit) error {
+		events <- "unit"
+		return nil
+	})
+	strm.SetReaderOnParametersChange(r, func(medi2 *description.Media, forma2 format.Format) error {
+		require.Equal(t, medi, medi2)
+		sps, pps := forma2.(*format.H264).SafeParams()
+		require.Equal(t, []byte{0x07, 0x04, 0x05, 0x06}, sps)
+		require.Equal(t, []byte{0x08, 0x02}, pps)
+		events <- "parameters"
+		return nil
+	})
+	strm.StartReader(r)
+	defer strm.RemoveReader(r)
+
+	strm.WriteUnit(medi, forma, &unit.H264{
+		AU: [][]byte{
+			{0x07, 0x04, 0x05, 0x06}, // SPS
+			{0x08, 0x02},             // PPS
+			{0x05, 0x01},             // IDR
+		},
+	})
+
+	<-streamChanged
+	require.Equal(t, "parameters", <-events)
+	require.Equal(t, "unit", <-events)
+
+	// parameters are unchanged
+	strm.WriteUnit(medi, forma, &unit.H264{
+		AU: [][]byte{
+			{0x07, 0x04, 0x05, 0x06}, // SPS
+			{0x08, 0x02},             // PPS
+			{0x05, 0x01},             // IDR
+		},
+	})
+
+	require.Equal(t, "unit", <-events)
+	require.Len(t, streamChanged, 0)
+}
+
+func TestStreamDropMalformed(t *testing.T) {
+	forma := &format.H264{
+		PayloadTyp:        96,
+		PacketizationMode: 1,
+	}
+
+	medi := &description.Media{
+		Type:    description.MediaTypeVideo,
+		Formats: []format.Format{forma},
+	}
+
+	malformed := make(chan error, 1)
+
+	strm := &Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               &description.Session{Medias: []*description.Media{medi}},
+		GenerateRTPPackets: true,
+		DropMalformed:      true,
+		OnMalformed: func(medi2 *description.Media, forma2 format.Format, err error) {
+			require.Equal(t, medi, medi2)
+			require.Equal(t, forma, forma2)
+			malformed <- err
+		},
+		Parent: nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	strm.WriteUnit(medi, forma, &unit.H264{
+		AU: [][]byte{{}},
+	})
+
+	require.EqualError(t, <-malformed, "dropped 1 malformed NAL unit")
+	require.Equal(t, uint64(1), strm.Stats().Formats[0].MalformedUnits)
+}
+
+func TestStreamDiscontinuities(t *testing.T) {
+	desc := &description.Session{Medias: []*description.Media{
+		{
+			Type: description.MediaTypeVideo,
+			Formats: []format.Format{&format.H264{
+				PayloadTyp:        96,
+				PacketizationMode: 1,
+			}},
+		},
+		{
+			Type: description.MediaTypeAudio,
+			Formats: []format.Format{&format.MPEG4Audio{
+				PayloadTyp: 97,
+				Config: &mpeg4audio.AudioSpecificConfig{
+					Type:         2,
+					SampleRate:   44100,
+					ChannelCount: 2,
+				},
+				SizeLength:       13,
+				IndexLength:      3,
+				IndexDeltaLength: 3,
+			}},
+		},
+	}}
+
+	strm := &Stream{
+		WriteQueueSize:     512,
+		UDPMaxPayloadSize:  1472,
+		Desc:               desc,
+		GenerateRTPPackets: true,
+		Parent:             nilLogger{},
+	}
+	err := strm.Initialize()
+	require.NoError(t, err)
+	defer strm.Close()
+
+	r := nilLogger{}
+	strm.AddReader(r, desc.Medias[0], desc.Medias[0].Formats[0], func(_ unit.Unit) error {
+		return nil
+	})
+	strm.StartReader(r)
+	defer strm.RemoveReader(r)
+
+	// video, written with RTP packets
+	for i, ca := range []struct {
+		nalu []byte
+		pts  int64
+	}{
+		{[]byte{0x05, 1}, 0},    // IDR
+		{[]byte{0x01, 2}, 3000}, // non-IDR
+		{[]byte{0x01, 3}, 3000}, // duplicated timestamp
+		{[]byte{0x01, 4}, 9000}, // packet lost before this frame
+		{[]byte{0x01, 5}, 6000}, // B-frame, reference is still missing
+		{[]byte{0x05, 6}, 8000}, // IDR with non-monotonic timestamp
+		{[]byte{0x01, 7}, 12000},
+	} {
+		seqNum := uint16(100 + i)
+		if i >= 3 {
+			seqNum++
+		}
+
+		strm.WriteRTPPacket(desc.Medias[0], desc.Medias[0].Formats[0], &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         true,
+				PayloadType:    96,
+				SequenceNumber: seqNum,
+				Timestamp:      uint32(ca.pts),
+				SSRC:           1234,
+			},
+			Payload: ca.nalu,
+		}, time.Time{}, ca.pts)
+	}
+
+	// audio, written with units
+	for _, pts := range []int64{0, 1024, 1024, 512, 2048} {
+		strm.WriteUnit(desc.Medias[1], desc.Medias[1].Formats[0], &unit.MPEG4Audio{
+			Base: unit.Base{
+				PTS: pts,
+			},
+			AUs: [][]byte{{1, 2}},
+		})
+	}
+
+	stats := strm.Stats()
+
+	require.Equal(t, uint64(1), stats.Formats[0].DuplicatedTimestamps)
+	require.Equal(t, uint64(1), stats.Formats[0].NonMonotonicTimestamps)
+	require.Equal(t, uint64(2), stats.Formats[0].MissingReferenceFrames)
+
+	require.Equal(t, uint64(1), stats.Formats[1].DuplicatedTimestamps)
+	require.Equal(t, uint64(1), stats.Formats[1].NonMonotonicTimestamps)
+	require.Equal(t, uint64(0), stats.Formats[1].MissingReferenceFrames)
+}
@@ -0,0 +1,157 @@
+package stream
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+const (
+	// seconds between 1900-01-01 (NTP epoch) and 1970-01-01 (Unix epoch)
+	ntpEpochOffset = 2208988800
+
+	// maximum difference between timestamps of consecutive packets,
+	// above which timestamps are considered discontinuous.
+	timestampMaxJump = 10 * time.Second
+)
+
+// ClockSource is a source of absolute time.
+type ClockSource interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func ntpToTime(v uint64) time.Time {
+	s := int64(v>>32) - ntpEpochOffset
+	nano := int64((v & 0xFFFFFFFF) * uint64(time.Second) >> 32)
+	return time.Unix(s, nano)
+}
+
+func durationToTimestamp(d time.Duration, clockRate int) int64 {
+	secs := d / time.Second
+	dec := d % time.Second
+	return int64(secs)*int64(clockRate) + int64(dec)*int64(clockRate)/int64(time.Second)
+}
+
+// formatHasOutOfOrderPictures returns whether pictures of a format can be sent out of order (B-frames),
+// and therefore RTP timestamps can go back.
+func formatHasOutOfOrderPictures(forma format.Format) bool {
+	switch forma.(type) {
+	case *format.H264, *format.H265:
+		return true
+	}
+	return false
+}
+
+type timestampNormalizerTrack struct {
+	clockRate  int
+	outOfOrder bool
+
+	initialized bool
+	prevTS      uint32
+	unwrapped   int64
+	pts         int64
+	lastArrival time.Time
+
+	// data from RTCP sender reports
+	srAvailable bool
+	srNTP       time.Time
+	srRTP       uint32
+}
+
+// timestampNormalizer computes PTS and NTP timestamps of RTP packets.
+// PTS of all tracks start from the time of arrival of the first packet of the stream,
+// RTP timestamps are unwrapped, and discontinuities are replaced with the time elapsed
+// between packets.
+// PTS are monotonic: RTP timestamps that go back are clamped to the previous PTS.
+// The only exception are formats with out-of-order pictures (H264 and H265), whose PTS
+// go back with B-frames; DTS of these formats, computed by format processors, are monotonic.
+// NTP timestamps are computed from RTCP sender reports when available, otherwise
+// they are the time of arrival of packets.
+type timestampNormalizer struct {
+	clock ClockSource
+
+	mutex  sync.Mutex
+	start  time.Time
+	tracks map[*streamFormat]*timestampNormalizerTrack
+}
+
+func (n *timestampNormalizer) initialize() {
+	n.tracks = make(map[*streamFormat]*timestampNormalizerTrack)
+}
+
+func (n *timestampNormalizer) track(sf *streamFormat) *timestampNormalizerTrack {
+	tr, ok := n.tracks[sf]
+	if !ok {
+		tr = &timestampNormalizerTrack{
+			clockRate:  sf.format.ClockRate(),
+			outOfOrder: formatHasOutOfOrderPictures(sf.format),
+		}
+		n.tracks[sf] = tr
+	}
+	return tr
+}
+
+func (n *timestampNormalizer) normalize(sf *streamFormat, pkt *rtp.Packet) (int64, time.Time) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	now := n.clock.Now()
+
+	if n.start.IsZero() {
+		n.start = now
+	}
+
+	tr := n.track(sf)
+
+	if !tr.initialized {
+		tr.initialized = true
+		tr.unwrapped = durationToTimestamp(now.Sub(n.start), tr.clockRate)
+		tr.pts = tr.unwrapped
+	} else {
+		diff := int64(int32(pkt.Timestamp - tr.prevTS))
+		maxJump := durationToTimestamp(timestampMaxJump, tr.clockRate)
+
+		if diff > maxJump || diff < -maxJump {
+			diff = durationToTimestamp(now.Sub(tr.lastArrival), tr.clockRate)
+		}
+
+		tr.unwrapped += diff
+
+		// the unwrapped timestamp is kept, in order to not shift
+		// the timestamps of following packets.
+		if tr.outOfOrder || tr.unwrapped > tr.pts {
+			tr.pts = tr.unwrapped
+		}
+	}
+
+	tr.prevTS = pkt.Timestamp
+	tr.lastArrival = now
+
+	var ntp time.Time
+	if tr.srAvailable {
+		ntp = tr.srNTP.Add(timestampToDuration(int64(int32(pkt.Timestamp-tr.srRTP)), tr.clockRate))
+	} else {
+		ntp = now
+	}
+
+	return tr.pts, ntp
+}
+
+func (n *timestampNormalizer) processSenderReport(sf *streamFormat, sr *rtcp.SenderReport) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	tr := n.track(sf)
+	tr.srAvailable = true
+	tr.srNTP = ntpToTime(sr.NTPTime)
+	tr.srRTP = sr.RTPTime
+}
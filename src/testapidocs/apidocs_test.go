@@ -94,10 +94,42 @@ func TestAPIDocs(t *testing.T) {
 			"RecordingList",
 			defs.APIRecordingList{},
 		},
+		{
+			"Logging",
+			defs.APILogging{},
+		},
+		{
+			"ClusterRecording",
+			defs.APIClusterRecording{},
+		},
+		{
+			"ClusterRecordingSegment",
+			defs.APIClusterRecordingSegment{},
+		},
 		{
 			"RecordingSegment",
 			defs.APIRecordingSegment{},
 		},
+		{
+			"CatalogSegment",
+			defs.APICatalogSegment{},
+		},
+		{
+			"CatalogSegmentList",
+			defs.APICatalogSegmentList{},
+		},
+		{
+			"CatalogSegmentTags",
+			defs.APICatalogSegmentTags{},
+		},
+		{
+			"CatalogAnnotation",
+			defs.APICatalogAnnotation{},
+		},
+		{
+			"CatalogAnnotationList",
+			defs.APICatalogAnnotationList{},
+		},
 		{
 			"RTMPConn",
 			defs.APIRTMPConn{},
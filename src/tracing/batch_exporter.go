@@ -0,0 +1,165 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/logger"
+)
+
+const (
+	batchExporterMaxBatchSize = 512
+	batchExporterFlushPeriod  = 5 * time.Second
+)
+
+// BatchExporter is an exporter that queues spans and sends them in batches,
+// encoded with the OTLP/JSON format, to an OTLP/HTTP endpoint or to the standard output.
+// When the queue is full, spans are discarded.
+type BatchExporter struct {
+	Type        conf.TracingExporter
+	Endpoint    string
+	ServiceName string
+	QueueSize   int
+	ReadTimeout conf.Duration
+	Parent      logger.Writer
+
+	ctx        context.Context
+	ctxCancel  func()
+	httpClient *http.Client
+	stdout     io.Writer
+	queue      chan *Span
+
+	done chan struct{}
+}
+
+// Initialize initializes BatchExporter.
+func (e *BatchExporter) Initialize() {
+	e.ctx, e.ctxCancel = context.WithCancel(context.Background())
+
+	e.httpClient = &http.Client{
+		Timeout: time.Duration(e.ReadTimeout),
+	}
+
+	if e.stdout == nil {
+		e.stdout = os.Stdout
+	}
+
+	e.queue = make(chan *Span, e.QueueSize)
+	e.done = make(chan struct{})
+
+	if e.Type == conf.TracingExporterStdout {
+		e.Log(logger.Info, "exporting spans to the standard output")
+	} else {
+		e.Log(logger.Info, "exporting spans to %s", e.Endpoint)
+	}
+
+	go e.run()
+}
+
+// Close closes BatchExporter.
+// Spans that are still in queue are sent before returning.
+func (e *BatchExporter) Close() {
+	e.Log(logger.Info, "closing")
+	e.ctxCancel()
+	<-e.done
+	e.httpClient.CloseIdleConnections()
+}
+
+// Log implements logger.Writer.
+func (e *BatchExporter) Log(level logger.Level, format string, args ...interface{}) {
+	e.Parent.Log(level, "[tracing] "+format, args...)
+}
+
+// Export implements Exporter.
+func (e *BatchExporter) Export(s *Span) {
+	select {
+	case e.queue <- s:
+	default:
+	}
+}
+
+func (e *BatchExporter) run() {
+	defer close(e.done)
+
+	t := time.NewTicker(batchExporterFlushPeriod)
+	defer t.Stop()
+
+	var batch []*Span
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		err := e.send(batch)
+		if err != nil {
+			e.Log(logger.Warn, "unable to export %d spans: %v", len(batch), err)
+		}
+
+		batch = nil
+	}
+
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= batchExporterMaxBatchSize {
+				flush()
+			}
+
+		case <-t.C:
+			flush()
+
+		case <-e.ctx.Done():
+			for {
+				select {
+				case s := <-e.queue:
+					batch = append(batch, s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *BatchExporter) send(spans []*Span) error {
+	buf, err := marshalOTLP(e.ServiceName, spans)
+	if err != nil {
+		return err
+	}
+
+	if e.Type == conf.TracingExporterStdout {
+		_, err = e.stdout.Write(append(buf, '\n'))
+		return err
+	}
+
+	// spans that are still in queue are sent when closing,
+	// therefore the request is not bound to e.ctx.
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, e.Endpoint, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	return nil
+}
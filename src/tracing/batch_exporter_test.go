@@ -0,0 +1,138 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/logger"
+)
+
+type nilLogger struct{}
+
+func (nilLogger) Log(logger.Level, string, ...interface{}) {}
+
+func TestBatchExporterOTLP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:9063")
+	require.NoError(t, err)
+
+	received := make(chan []byte, 1)
+
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodPost, r.Method)
+			require.Equal(t, "/v1/traces", r.URL.Path)
+			require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+			body, err2 := io.ReadAll(r.Body)
+			require.NoError(t, err2)
+
+			received <- body
+		}),
+	}
+	go s.Serve(ln)
+	defer s.Shutdown(context.Background())
+
+	e := &BatchExporter{
+		Type:        conf.TracingExporterOTLP,
+		Endpoint:    "http://127.0.0.1:9063/v1/traces",
+		ServiceName: "myservice",
+		QueueSize:   16,
+		ReadTimeout: conf.Duration(10 * time.Second),
+		Parent:      nilLogger{},
+	}
+	e.Initialize()
+
+	tr := &Tracer{}
+	tr.SetExporter(e, 1)
+
+	span := tr.Start("ingest")
+	span.SetAttribute("path", "mypath")
+	span.SetAttribute("readers", 2)
+	child := span.StartChild("stream.write")
+	child.RecordError(errors.New("test"))
+	child.Finish()
+	span.Finish()
+
+	// spans in queue are sent when closing.
+	e.Close()
+
+	var dec map[string]interface{}
+	err = json.Unmarshal(<-received, &dec)
+	require.NoError(t, err)
+
+	rs := dec["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	require.Equal(t, map[string]interface{}{
+		"attributes": []interface{}{
+			map[string]interface{}{
+				"key":   "service.name",
+				"value": map[string]interface{}{"stringValue": "myservice"},
+			},
+		},
+	}, rs["resource"])
+
+	spans := rs["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	require.Len(t, spans, 2)
+
+	s0 := spans[0].(map[string]interface{})
+	require.Equal(t, "stream.write", s0["name"])
+	require.Equal(t, span.Context.TraceParent()[3:35], s0["traceId"])
+	require.Equal(t, span.Context.TraceParent()[36:52], s0["parentSpanId"])
+	require.Equal(t, map[string]interface{}{
+		"code":    float64(2),
+		"message": "test",
+	}, s0["status"])
+
+	s1 := spans[1].(map[string]interface{})
+	require.Equal(t, "ingest", s1["name"])
+	require.Nil(t, s1["parentSpanId"])
+	require.Equal(t, []interface{}{
+		map[string]interface{}{
+			"key":   "path",
+			"value": map[string]interface{}{"stringValue": "mypath"},
+		},
+		map[string]interface{}{
+			"key":   "readers",
+			"value": map[string]interface{}{"intValue": "2"},
+		},
+	}, s1["attributes"])
+}
+
+func TestBatchExporterStdout(t *testing.T) {
+	var buf bytes.Buffer
+
+	e := &BatchExporter{
+		Type:        conf.TracingExporterStdout,
+		ServiceName: "mediamtx",
+		QueueSize:   16,
+		ReadTimeout: conf.Duration(10 * time.Second),
+		Parent:      nilLogger{},
+		stdout:      &buf,
+	}
+	e.Initialize()
+
+	tr := &Tracer{}
+	tr.SetExporter(e, 0)
+
+	span := tr.StartSegment("/recordings/mypath/1.mp4", "record.segment")
+	span.Finish()
+
+	e.Close()
+
+	require.Equal(t, byte('\n'), buf.Bytes()[buf.Len()-1])
+
+	var dec otlpTracesData
+	err := json.Unmarshal(buf.Bytes(), &dec)
+	require.NoError(t, err)
+	require.Len(t, dec.ResourceSpans[0].ScopeSpans[0].Spans, 1)
+	require.Equal(t, "record.segment", dec.ResourceSpans[0].ScopeSpans[0].Spans[0].Name)
+}
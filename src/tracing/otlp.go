@@ -0,0 +1,153 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+)
+
+// OTLP/JSON encoding of spans.
+// ref: https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusCodeError  = 2
+)
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTracesData struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func otlpValue(v interface{}) otlpAnyValue {
+	switch v := v.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &v}
+
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+
+	case int:
+		s := strconv.FormatInt(int64(v), 10)
+		return otlpAnyValue{IntValue: &s}
+
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpAnyValue{IntValue: &s}
+
+	case uint64:
+		s := strconv.FormatUint(v, 10)
+		return otlpAnyValue{IntValue: &s}
+
+	case float64:
+		return otlpAnyValue{DoubleValue: &v}
+
+	default:
+		s := toString(v)
+		return otlpAnyValue{StringValue: &s}
+	}
+}
+
+func toString(v interface{}) string {
+	if s, ok := v.(interface{ String() string }); ok {
+		return s.String()
+	}
+	buf, _ := json.Marshal(v)
+	return string(buf)
+}
+
+func marshalOTLP(serviceName string, spans []*Span) ([]byte, error) {
+	out := make([]otlpSpan, len(spans))
+
+	for i, s := range spans {
+		sp := otlpSpan{
+			TraceID:           hex.EncodeToString(s.Context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.Context.SpanID[:]),
+			Name:              s.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+
+		if s.ParentID != (SpanID{}) {
+			sp.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+		}
+
+		for _, a := range s.Attributes {
+			sp.Attributes = append(sp.Attributes, otlpKeyValue{
+				Key:   a.Key,
+				Value: otlpValue(a.Value),
+			})
+		}
+
+		if s.Err != nil {
+			sp.Status = otlpStatus{
+				Code:    otlpStatusCodeError,
+				Message: s.Err.Error(),
+			}
+		}
+
+		out[i] = sp
+	}
+
+	return json.Marshal(otlpTracesData{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpKeyValue{{
+					Key:   "service.name",
+					Value: otlpValue(serviceName),
+				}},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "mediamtx"},
+				Spans: out,
+			}},
+		}},
+	})
+}
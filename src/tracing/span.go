@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TraceID is the ID of a trace.
+type TraceID [16]byte
+
+// SpanID is the ID of a span.
+type SpanID [8]byte
+
+// SpanContext identifies a span inside a trace.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid returns whether the span context is filled.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// TraceParent returns the span context in the W3C Trace Context format.
+func (sc SpanContext) TraceParent() string {
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-01"
+}
+
+// ParseTraceParent parses a span context in the W3C Trace Context format.
+func ParseTraceParent(s string) (SpanContext, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return SpanContext{}, fmt.Errorf("invalid trace parent: '%s'", s)
+	}
+
+	var sc SpanContext
+
+	_, err := hex.Decode(sc.TraceID[:], []byte(parts[1]))
+	if err != nil {
+		return SpanContext{}, fmt.Errorf("invalid trace parent: '%s'", s)
+	}
+
+	_, err = hex.Decode(sc.SpanID[:], []byte(parts[2]))
+	if err != nil {
+		return SpanContext{}, fmt.Errorf("invalid trace parent: '%s'", s)
+	}
+
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid trace parent: '%s'", s)
+	}
+
+	return sc, nil
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:]) //nolint:errcheck
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:]) //nolint:errcheck
+	return id
+}
+
+// Attribute is a key-value pair attached to a span.
+// Value can be a string, a bool, an integer or a float.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is an operation that is part of a trace.
+// All methods can be called on a nil Span, that is returned when tracing
+// is disabled or when the trace has not been sampled, and do nothing.
+type Span struct {
+	Name       string
+	Context    SpanContext
+	ParentID   SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Err        error
+
+	tracer *Tracer
+}
+
+// SpanContext returns the span context.
+// It returns an empty span context on a nil Span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+// SetAttribute sets an attribute.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.Attributes = append(s.Attributes, Attribute{Key: key, Value: value})
+}
+
+// RecordError marks the span as failed.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Err = err
+}
+
+// StartChild starts a child span.
+func (s *Span) StartChild(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.startSpan(name, s.Context.TraceID, s.Context.SpanID, time.Now())
+}
+
+// Finish ends the span and sends it to the exporter.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	s.tracer.export(s)
+}
@@ -0,0 +1,142 @@
+// Package tracing contains a minimal OpenTelemetry-compatible tracer.
+package tracing
+
+import (
+	"crypto/sha256"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// Exporter receives completed spans.
+// Export is called by the routine that ended the span, therefore it must not block.
+type Exporter interface {
+	Export(s *Span)
+}
+
+type tracerState struct {
+	exporter    Exporter
+	sampleRatio float64
+}
+
+// Tracer creates spans and dispatches them to an exporter.
+// When no exporter is set, no spans are created.
+// Root spans of frequent operations are sampled with the ratio passed to SetExporter.
+// The state is stored atomically, since it is read by routines that write data.
+type Tracer struct {
+	state atomic.Pointer[tracerState]
+}
+
+// SetExporter sets the exporter and the ratio of root spans that are sampled.
+// Pass a nil exporter to disable tracing.
+func (t *Tracer) SetExporter(e Exporter, sampleRatio float64) {
+	if e == nil {
+		t.state.Store(nil)
+		return
+	}
+
+	t.state.Store(&tracerState{
+		exporter:    e,
+		sampleRatio: sampleRatio,
+	})
+}
+
+func (t *Tracer) enabled() (bool, float64) {
+	if t == nil {
+		return false, 0
+	}
+
+	st := t.state.Load()
+	if st == nil {
+		return false, 0
+	}
+
+	return true, st.sampleRatio
+}
+
+// Start starts the root span of a frequent operation, like the processing of a unit.
+// It returns nil when tracing is disabled or when the trace has not been sampled.
+// It can be called on a nil Tracer.
+func (t *Tracer) Start(name string) *Span {
+	ok, sampleRatio := t.enabled()
+	if !ok || sampleRatio <= 0 || (sampleRatio < 1 && rand.Float64() >= sampleRatio) {
+		return nil
+	}
+
+	return t.startSpan(name, newTraceID(), SpanID{}, time.Now())
+}
+
+// StartChild starts a span that is a child of the span identified by parent.
+// When parent is not valid, a root span that is not subject to sampling is started.
+// It returns nil when tracing is disabled.
+// It can be called on a nil Tracer.
+func (t *Tracer) StartChild(parent SpanContext, name string) *Span {
+	return t.StartChildAt(parent, name, time.Now())
+}
+
+// StartChildAt is like StartChild, but the span starts at the given time.
+// It is used to trace operations whose start is known only after they have begun,
+// like the time spent by units in a queue.
+func (t *Tracer) StartChildAt(parent SpanContext, name string, start time.Time) *Span {
+	ok, _ := t.enabled()
+	if !ok {
+		return nil
+	}
+
+	if !parent.IsValid() {
+		return t.startSpan(name, newTraceID(), SpanID{}, start)
+	}
+
+	return t.startSpan(name, parent.TraceID, parent.SpanID, start)
+}
+
+// SegmentSpanContext returns the span context of the operations on a recording segment.
+// It is derived from the path of the segment, in order to allow components that
+// process the same segment, like the recorder and the uploader, to share a trace
+// without exchanging data.
+func SegmentSpanContext(segmentPath string) SpanContext {
+	h := sha256.Sum256([]byte(segmentPath))
+
+	var sc SpanContext
+	copy(sc.TraceID[:], h[:16])
+	copy(sc.SpanID[:], h[16:24])
+	return sc
+}
+
+// StartSegment starts the root span of the trace of a recording segment,
+// whose span context is SegmentSpanContext(segmentPath).
+// It returns nil when tracing is disabled.
+// It can be called on a nil Tracer.
+func (t *Tracer) StartSegment(segmentPath string, name string) *Span {
+	ok, _ := t.enabled()
+	if !ok {
+		return nil
+	}
+
+	return &Span{
+		Name:    name,
+		Context: SegmentSpanContext(segmentPath),
+		Start:   time.Now(),
+		tracer:  t,
+	}
+}
+
+func (t *Tracer) startSpan(name string, traceID TraceID, parentID SpanID, start time.Time) *Span {
+	return &Span{
+		Name: name,
+		Context: SpanContext{
+			TraceID: traceID,
+			SpanID:  newSpanID(),
+		},
+		ParentID: parentID,
+		Start:    start,
+		tracer:   t,
+	}
+}
+
+func (t *Tracer) export(s *Span) {
+	// the exporter may have been removed in the meanwhile.
+	if st := t.state.Load(); st != nil {
+		st.exporter.Export(s)
+	}
+}
@@ -0,0 +1,127 @@
+package tracing
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testExporter struct {
+	spans []*Span
+}
+
+func (e *testExporter) Export(s *Span) {
+	e.spans = append(e.spans, s)
+}
+
+func TestTracerDisabled(t *testing.T) {
+	var tr *Tracer
+	require.Nil(t, tr.Start("ingest"))
+
+	tr = &Tracer{}
+	require.Nil(t, tr.Start("ingest"))
+	require.Nil(t, tr.StartSegment("/recordings/mypath/1.mp4", "record.segment"))
+
+	// methods of nil spans do nothing.
+	span := tr.StartChild(SpanContext{}, "upload")
+	require.Nil(t, span)
+	span.SetAttribute("key", "value")
+	span.RecordError(errors.New("test"))
+	require.Nil(t, span.StartChild("child"))
+	require.False(t, span.SpanContext().IsValid())
+	span.Finish()
+}
+
+func TestTracerSampling(t *testing.T) {
+	e := &testExporter{}
+	tr := &Tracer{}
+
+	tr.SetExporter(e, 0)
+	require.Nil(t, tr.Start("ingest"))
+
+	// spans with an explicit parent are not subject to sampling.
+	require.NotNil(t, tr.StartChild(SpanContext{}, "upload"))
+
+	tr.SetExporter(e, 1)
+	require.NotNil(t, tr.Start("ingest"))
+
+	tr.SetExporter(nil, 0)
+	require.Nil(t, tr.Start("ingest"))
+}
+
+func TestTracerChild(t *testing.T) {
+	e := &testExporter{}
+	tr := &Tracer{}
+	tr.SetExporter(e, 1)
+
+	root := tr.Start("ingest")
+	root.SetAttribute("path", "mypath")
+
+	child := root.StartChild("stream.write")
+	child.RecordError(errors.New("test"))
+	child.Finish()
+
+	remote := tr.StartChild(root.SpanContext(), "stream.read")
+	remote.Finish()
+
+	root.Finish()
+
+	require.Len(t, e.spans, 3)
+
+	require.Equal(t, "stream.write", e.spans[0].Name)
+	require.Equal(t, root.Context.TraceID, e.spans[0].Context.TraceID)
+	require.Equal(t, root.Context.SpanID, e.spans[0].ParentID)
+	require.EqualError(t, e.spans[0].Err, "test")
+
+	require.Equal(t, "stream.read", e.spans[1].Name)
+	require.Equal(t, root.Context.TraceID, e.spans[1].Context.TraceID)
+	require.Equal(t, root.Context.SpanID, e.spans[1].ParentID)
+
+	require.Equal(t, "ingest", e.spans[2].Name)
+	require.Equal(t, SpanID{}, e.spans[2].ParentID)
+	require.Equal(t, []Attribute{{Key: "path", Value: "mypath"}}, e.spans[2].Attributes)
+	require.False(t, e.spans[2].End.Before(e.spans[2].Start))
+}
+
+func TestTracerSegment(t *testing.T) {
+	e := &testExporter{}
+	tr := &Tracer{}
+	tr.SetExporter(e, 0)
+
+	segment := tr.StartSegment("/recordings/mypath/1.mp4", "record.segment")
+	segment.Finish()
+
+	upload := tr.StartChild(SegmentSpanContext("/recordings/mypath/1.mp4"), "upload")
+	upload.Finish()
+
+	require.Len(t, e.spans, 2)
+	require.Equal(t, e.spans[0].Context.TraceID, e.spans[1].Context.TraceID)
+	require.Equal(t, e.spans[0].Context.SpanID, e.spans[1].ParentID)
+
+	require.NotEqual(t, SegmentSpanContext("/recordings/mypath/1.mp4"),
+		SegmentSpanContext("/recordings/mypath/2.mp4"))
+}
+
+func TestTraceParent(t *testing.T) {
+	sc := SpanContext{
+		TraceID: TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	}
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.TraceParent())
+
+	dec, err := ParseTraceParent(sc.TraceParent())
+	require.NoError(t, err)
+	require.Equal(t, sc, dec)
+
+	for _, ca := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+	} {
+		_, err = ParseTraceParent(ca)
+		require.Error(t, err, ca)
+	}
+}
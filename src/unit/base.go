@@ -4,6 +4,8 @@ import (
 	"time"
 
 	"github.com/pion/rtp"
+
+	"github.com/flynnletford/mediamtx/src/tracing"
 )
 
 // Base contains fields shared across all units.
@@ -11,6 +13,13 @@ type Base struct {
 	RTPPackets []*rtp.Packet
 	NTP        time.Time
 	PTS        int64
+
+	// optional buffer that contains the data of the unit.
+	// See Payload for ownership rules.
+	Payload *Payload
+
+	// trace of the unit, that is filled when the unit has been sampled by the tracer.
+	TraceContext tracing.SpanContext
 }
 
 // GetRTPPackets implements Unit.
@@ -27,3 +36,17 @@ func (u *Base) GetNTP() time.Time {
 func (u *Base) GetPTS() int64 {
 	return u.PTS
 }
+
+// GetTraceContext implements Unit.
+func (u *Base) GetTraceContext() tracing.SpanContext {
+	return u.TraceContext
+}
+
+// SetTraceContext implements Unit.
+func (u *Base) SetTraceContext(sc tracing.SpanContext) {
+	u.TraceContext = sc
+}
+
+func (u *Base) getPayload() *Payload {
+	return u.Payload
+}
@@ -4,4 +4,8 @@ package unit
 type G711 struct {
 	Base
 	Samples []byte
+
+	// samples decoded into 16-bit big-endian LPCM.
+	// It is filled only when decoding into LPCM is enabled.
+	LPCM []byte
 }
@@ -0,0 +1,11 @@
+package unit
+
+// G722 is a G722 data unit.
+type G722 struct {
+	Base
+	Frame []byte
+
+	// samples decoded into 16-bit big-endian LPCM, with a sample rate of 16khz.
+	// It is filled only when decoding into LPCM is enabled.
+	LPCM []byte
+}
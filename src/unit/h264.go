@@ -4,4 +4,7 @@ package unit
 type H264 struct {
 	Base
 	AU [][]byte
+
+	// metadata extracted from SEI messages, if present.
+	SEI *SEI
 }
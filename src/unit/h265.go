@@ -4,4 +4,7 @@ package unit
 type H265 struct {
 	Base
 	AU [][]byte
+
+	// metadata extracted from SEI messages, if present.
+	SEI *SEI
 }
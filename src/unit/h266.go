@@ -0,0 +1,7 @@
+package unit
+
+// H266 is a H266 data unit.
+type H266 struct {
+	Base
+	AU [][]byte
+}
@@ -0,0 +1,7 @@
+package unit
+
+// KLV is a KLV (SMPTE 336) metadata unit.
+type KLV struct {
+	Base
+	Unit []byte
+}
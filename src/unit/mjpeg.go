@@ -4,4 +4,9 @@ package unit
 type MJPEG struct {
 	Base
 	Frame []byte
+
+	// size of the frame, extracted from the JPEG header.
+	// It is zero when the header can't be parsed.
+	Width  int
+	Height int
 }
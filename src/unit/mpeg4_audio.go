@@ -1,7 +1,15 @@
 package unit
 
+import (
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+)
+
 // MPEG4Audio is a MPEG-4 Audio data unit.
 type MPEG4Audio struct {
 	Base
 	AUs [][]byte
+
+	// configuration transmitted in-band.
+	// It is filled only by LATM streams that don't have a configuration in the format (cpresent=1).
+	Config *mpeg4audio.Config
 }
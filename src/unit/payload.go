@@ -0,0 +1,122 @@
+package unit
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// sizes of the buffers of payload pools.
+var payloadPoolSizes = []int{
+	512,
+	2 * 1024,
+	8 * 1024,
+	32 * 1024,
+	128 * 1024,
+	512 * 1024,
+}
+
+var payloadPools = func() []*sync.Pool {
+	pools := make([]*sync.Pool, len(payloadPoolSizes))
+	for i, size := range payloadPoolSizes {
+		pool := &sync.Pool{}
+		pool.New = func() interface{} {
+			return &Payload{
+				buf:  make([]byte, size),
+				pool: pool,
+			}
+		}
+		pools[i] = pool
+	}
+	return pools
+}()
+
+// Payload is a reference-counted buffer that is reused once it is not needed anymore.
+//
+// Ownership rules:
+//   - NewPayload() returns a payload with a single reference, owned by the caller.
+//     The caller fills the fields of a unit with slices of Bytes() and sets Base.Payload.
+//   - when a unit has no payload, the format processor can allocate one to store
+//     decoded data (i.e. LPCM). This payload is owned by the stream.
+//   - the stream retains the unit for every reader that receives it and for the GOP cache,
+//     and releases it when the reader is done with it or when it is discarded.
+//   - the caller releases its own reference after the unit has been written to the stream.
+//   - readers must not use the unit after their callback returns, unless they retain it with
+//     Retain() and release it with Release() when they're done.
+//
+// Once all references have been released, the buffer is returned to the pool and
+// the unit must not be accessed anymore.
+type Payload struct {
+	buf  []byte
+	pool *sync.Pool
+	len  int
+	refs int32
+}
+
+// NewPayload allocates a payload with the given size.
+// Payloads bigger than the biggest pool are allocated from scratch and are not reused.
+func NewPayload(size int) *Payload {
+	for i, poolSize := range payloadPoolSizes {
+		if size <= poolSize {
+			p := payloadPools[i].Get().(*Payload)
+			p.len = size
+			p.refs = 1
+			return p
+		}
+	}
+
+	return &Payload{
+		buf:  make([]byte, size),
+		len:  size,
+		refs: 1,
+	}
+}
+
+// Bytes returns the content of the payload.
+func (p *Payload) Bytes() []byte {
+	return p.buf[:p.len]
+}
+
+// Retain adds a reference to the payload.
+func (p *Payload) Retain() {
+	atomic.AddInt32(&p.refs, 1)
+}
+
+// Release removes a reference from the payload.
+// When there are no references left, the payload is returned to the pool.
+func (p *Payload) Release() {
+	refs := atomic.AddInt32(&p.refs, -1)
+
+	if refs < 0 {
+		panic("payload released too many times")
+	}
+
+	if refs == 0 && p.pool != nil {
+		p.pool.Put(p)
+	}
+}
+
+type payloadHolder interface {
+	getPayload() *Payload
+}
+
+// GetPayload returns the payload of a unit, if any.
+func GetPayload(u Unit) *Payload {
+	if h, ok := u.(payloadHolder); ok {
+		return h.getPayload()
+	}
+	return nil
+}
+
+// Retain adds a reference to the payload of a unit, if any.
+func Retain(u Unit) {
+	if p := GetPayload(u); p != nil {
+		p.Retain()
+	}
+}
+
+// Release removes a reference from the payload of a unit, if any.
+func Release(u Unit) {
+	if p := GetPayload(u); p != nil {
+		p.Release()
+	}
+}
@@ -0,0 +1,41 @@
+package unit
+
+// Timecode is a SMPTE timecode, embedded into a picture timing or time code SEI message.
+type Timecode struct {
+	Hours     uint8
+	Minutes   uint8
+	Seconds   uint8
+	Frames    uint16
+	DropFrame bool
+}
+
+// MasteringDisplay contains the color volume of the display used to master the content.
+// Primaries are in the same order as the SEI message (green, blue, red),
+// in increments of 0.00002. Luminances are in increments of 0.0001 cd/m2.
+type MasteringDisplay struct {
+	DisplayPrimariesX [3]uint16
+	DisplayPrimariesY [3]uint16
+	WhitePointX       uint16
+	WhitePointY       uint16
+	MaxLuminance      uint32
+	MinLuminance      uint32
+}
+
+// ContentLightLevel contains the light level of the content, in cd/m2.
+type ContentLightLevel struct {
+	MaxContentLightLevel    uint16
+	MaxPicAverageLightLevel uint16
+}
+
+// SEI contains metadata extracted from the SEI messages of an access unit.
+type SEI struct {
+	Timecode          *Timecode
+	MasteringDisplay  *MasteringDisplay
+	ContentLightLevel *ContentLightLevel
+
+	// payloads of user data registered by ITU-T T.35 messages (i.e. closed captions).
+	UserDataRegistered [][]byte
+
+	// payloads of user data unregistered messages, starting with a 16-byte UUID.
+	UserDataUnregistered [][]byte
+}
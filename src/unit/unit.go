@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/pion/rtp"
+
+	"github.com/flynnletford/mediamtx/src/tracing"
 )
 
 // Unit is the elementary data unit routed across the server.
@@ -17,4 +19,10 @@ type Unit interface {
 
 	// returns the PTS of the unit.
 	GetPTS() int64
+
+	// returns the trace of the unit.
+	GetTraceContext() tracing.SpanContext
+
+	// sets the trace of the unit.
+	SetTraceContext(sc tracing.SpanContext)
 }
@@ -0,0 +1,330 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+)
+
+const (
+	azureAPIVersion = "2021-08-06"
+)
+
+type azureBlockList struct {
+	XMLName xml.Name `xml:"BlockList"`
+	Latest  []string `xml:"Latest"`
+}
+
+// azureStorage is a minimal client of the Azure Blob Storage API.
+// Requests are authorized with the key of the storage account (Shared Key).
+type azureStorage struct {
+	endpoint    string
+	container   string
+	accountName string
+	accountKey  []byte
+	partSize    conf.StringSize
+	httpClient  *http.Client
+}
+
+func newAzureStorage(sc storageConf, partSize conf.StringSize, httpClient *http.Client) (*azureStorage, error) {
+	accountKey, err := base64.StdEncoding.DecodeString(sc.azureAccountKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid account key: %w", err)
+	}
+
+	endpoint := sc.endpoint
+	if endpoint == "" {
+		endpoint = "https://" + sc.azureAccountName + ".blob.core.windows.net"
+	}
+
+	return &azureStorage{
+		endpoint:    strings.TrimRight(endpoint, "/"),
+		container:   sc.bucket,
+		accountName: sc.azureAccountName,
+		accountKey:  accountKey,
+		partSize:    partSize,
+		httpClient:  httpClient,
+	}, nil
+}
+
+// upload implements storage.
+// Objects bigger than partSize are uploaded in blocks, that are then committed together.
+func (c *azureStorage) upload(ctx context.Context, obj *object) error {
+	if obj.size <= int64(c.partSize) {
+		body, err := io.ReadAll(obj.r)
+		if err != nil {
+			return err
+		}
+
+		header := c.header()
+		header.Set("Content-Type", obj.contentType)
+		header.Set("X-Ms-Blob-Type", "BlockBlob")
+
+		return c.do(ctx, http.MethodPut, obj.key, nil, header, body)
+	}
+
+	var p *partialUpload
+
+	if obj.partial != nil && obj.partial.PartSize == int64(c.partSize) {
+		p = obj.partial.clone()
+
+		_, err := obj.r.Seek(p.Offset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+	} else {
+		p = &partialUpload{
+			PartSize: int64(c.partSize),
+		}
+	}
+
+	buf := make([]byte, c.partSize)
+
+	for p.Offset < obj.size {
+		n, err := io.ReadFull(obj.r, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		blockID, err := c.putPart(ctx, obj.key, "", len(p.Parts)+1, buf[:n])
+		if err != nil {
+			return fmt.Errorf("unable to upload block %d: %w", len(p.Parts), err)
+		}
+
+		p.Parts = append(p.Parts, blockID)
+		p.Offset += int64(n)
+		obj.onProgress(p.clone())
+	}
+
+	return c.completeParts(ctx, obj.key, "", obj.contentType, p.Parts)
+}
+
+// abort implements storage.
+// Blocks that are not committed are discarded automatically by the storage.
+func (c *azureStorage) abort(_ context.Context, _ string, _ *partialUpload) {
+}
+
+// createParts implements partStorage.
+// Blocks don't need to be declared in advance.
+func (c *azureStorage) createParts(_ context.Context, _ string, _ string) (string, error) {
+	return "", nil
+}
+
+// putPart implements partStorage.
+func (c *azureStorage) putPart(ctx context.Context, key string, _ string, number int, body []byte) (string, error) {
+	// IDs of the blocks of a blob must have the same length.
+	blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", number-1)))
+
+	err := c.do(ctx, http.MethodPut, key, url.Values{
+		"comp":    []string{"block"},
+		"blockid": []string{blockID},
+	}, c.header(), body)
+	if err != nil {
+		return "", err
+	}
+
+	return blockID, nil
+}
+
+// completeParts implements partStorage.
+func (c *azureStorage) completeParts(
+	ctx context.Context,
+	key string,
+	_ string,
+	contentType string,
+	blockIDs []string,
+) error {
+	body, err := xml.Marshal(azureBlockList{Latest: blockIDs})
+	if err != nil {
+		return err
+	}
+
+	header := c.header()
+	header.Set("Content-Type", "application/xml")
+	header.Set("X-Ms-Blob-Content-Type", contentType)
+
+	return c.do(ctx, http.MethodPut, key, url.Values{"comp": []string{"blocklist"}},
+		header, append([]byte(xml.Header), body...))
+}
+
+// download implements storage.
+func (c *azureStorage) download(ctx context.Context, key string, w io.Writer) error {
+	res, err := c.request(ctx, http.MethodGet, key, nil, c.header(), nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+func (c *azureStorage) header() http.Header {
+	return http.Header{
+		"X-Ms-Version": []string{azureAPIVersion},
+		"X-Ms-Date":    []string{time.Now().UTC().Format(http.TimeFormat)},
+	}
+}
+
+func (c *azureStorage) do(
+	ctx context.Context,
+	method string,
+	key string,
+	query url.Values,
+	header http.Header,
+	body []byte,
+) error {
+	res, err := c.request(ctx, method, key, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+	return nil
+}
+
+func (c *azureStorage) request(
+	ctx context.Context,
+	method string,
+	key string,
+	query url.Values,
+	header http.Header,
+	body []byte,
+) (*http.Response, error) {
+	u := c.endpoint + "/" + s3Escape(c.container) + "/" + s3EscapePath(key)
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	c.sign(req, int64(len(body)))
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		return nil, readXMLError(res)
+	}
+
+	return res, nil
+}
+
+func (c *azureStorage) sign(req *http.Request, contentLength int64) {
+	var msHeaders []string
+	for k := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-ms-") {
+			msHeaders = append(msHeaders, k)
+		}
+	}
+	sort.Strings(msHeaders)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range msHeaders {
+		canonicalHeaders.WriteString(k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n")
+	}
+
+	canonicalResource := "/" + c.accountName + req.URL.EscapedPath()
+
+	query := req.URL.Query()
+	queryKeys := make([]string, 0, len(query))
+	for k := range query {
+		queryKeys = append(queryKeys, k)
+	}
+	sort.Strings(queryKeys)
+
+	for _, k := range queryKeys {
+		values := query[k]
+		sort.Strings(values)
+		canonicalResource += "\n" + strings.ToLower(k) + ":" + strings.Join(values, ",")
+	}
+
+	contentLengthStr := ""
+	if contentLength != 0 {
+		contentLengthStr = strconv.FormatInt(contentLength, 10)
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLengthStr,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, replaced by x-ms-date
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}, "\n") + "\n" + canonicalHeaders.String() + canonicalResource
+
+	h := hmac.New(sha256.New, c.accountKey)
+	h.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	req.Header.Set("Authorization", "SharedKey "+c.accountName+":"+signature)
+}
+
+// signURL implements urlSigner.
+// URLs contain a service SAS (shared access signature) that allows to read the blob.
+func (c *azureStorage) signURL(key string, expiration time.Duration, now time.Time) (string, error) {
+	expiry := now.UTC().Add(expiration).Format(time.RFC3339)
+
+	stringToSign := strings.Join([]string{
+		"r", // signedPermissions
+		"",  // signedStart
+		expiry,
+		"/blob/" + c.accountName + "/" + c.container + "/" + key,
+		"", // signedIdentifier
+		"", // signedIP
+		"", // signedProtocol
+		azureAPIVersion,
+		"b", // signedResource
+		"",  // signedSnapshotTime
+		"",  // signedEncryptionScope
+		"",  // rscc
+		"",  // rscd
+		"",  // rsce
+		"",  // rscl
+		"",  // rsct
+	}, "\n")
+
+	h := hmac.New(sha256.New, c.accountKey)
+	h.Write([]byte(stringToSign))
+
+	query := url.Values{
+		"sv":  []string{azureAPIVersion},
+		"sr":  []string{"b"},
+		"sp":  []string{"r"},
+		"se":  []string{expiry},
+		"sig": []string{base64.StdEncoding.EncodeToString(h.Sum(nil))},
+	}
+
+	return c.endpoint + "/" + s3Escape(c.container) + "/" + s3EscapePath(key) + "?" + query.Encode(), nil
+}
@@ -0,0 +1,97 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAzureStorage(t *testing.T) {
+	for _, ca := range []string{"single", "blocks"} {
+		t.Run(ca, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:9072")
+			require.NoError(t, err)
+
+			blocks := make(map[string][]byte)
+			var received []byte
+
+			s := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					require.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey myaccount:"))
+					require.Equal(t, azureAPIVersion, r.Header.Get("X-Ms-Version"))
+					require.NotEmpty(t, r.Header.Get("X-Ms-Date"))
+					require.Equal(t, http.MethodPut, r.Method)
+					require.Equal(t, "/myaccount/mycontainer/mypath/1.mp4", r.URL.Path)
+
+					body, err2 := io.ReadAll(r.Body)
+					require.NoError(t, err2)
+
+					switch r.URL.Query().Get("comp") {
+					case "":
+						require.Equal(t, "BlockBlob", r.Header.Get("X-Ms-Blob-Type"))
+						require.Equal(t, "video/mp4", r.Header.Get("Content-Type"))
+						received = body
+
+					case "block":
+						blocks[r.URL.Query().Get("blockid")] = body
+
+					case "blocklist":
+						require.Equal(t, "video/mp4", r.Header.Get("X-Ms-Blob-Content-Type"))
+
+						var bl azureBlockList
+						err2 = xml.Unmarshal(body, &bl)
+						require.NoError(t, err2)
+
+						received = nil
+						for _, id := range bl.Latest {
+							received = append(received, blocks[id]...)
+						}
+					}
+
+					w.WriteHeader(http.StatusCreated)
+				}),
+			}
+			go s.Serve(ln)
+			defer s.Shutdown(context.Background())
+
+			httpClient := &http.Client{Timeout: 10 * time.Second}
+			defer httpClient.CloseIdleConnections()
+
+			st, err := newAzureStorage(storageConf{
+				endpoint:         "http://127.0.0.1:9072/myaccount",
+				bucket:           "mycontainer",
+				azureAccountName: "myaccount",
+				azureAccountKey:  base64.StdEncoding.EncodeToString([]byte("mykey")),
+			}, 16, httpClient)
+			require.NoError(t, err)
+
+			content := bytes.Repeat([]byte{1, 2, 3, 4}, 10)
+			if ca == "single" {
+				content = content[:16]
+			}
+
+			err = st.upload(context.Background(), &object{
+				key:         "mypath/1.mp4",
+				contentType: "video/mp4",
+				r:           bytes.NewReader(content),
+				size:        int64(len(content)),
+				onProgress:  func(*partialUpload) {},
+			})
+			require.NoError(t, err)
+			require.Equal(t, content, received)
+
+			if ca == "blocks" {
+				require.Len(t, blocks, 3)
+			}
+		})
+	}
+}
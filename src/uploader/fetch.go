@@ -0,0 +1,73 @@
+package uploader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+)
+
+// download writes the content of an object of the storage of a path into w.
+func download(ctx context.Context, pathConf *conf.Path, key string, w io.Writer) error {
+	// downloads are interrupted through the context, since their duration depends on size.
+	httpClient := &http.Client{
+		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	defer httpClient.CloseIdleConnections()
+
+	s, err := newStorage(newStorageConf(pathConf), 0, httpClient)
+	if err != nil {
+		return err
+	}
+
+	if c, ok := s.(interface{ close() }); ok {
+		defer c.close()
+	}
+
+	return s.download(ctx, key, w)
+}
+
+// FetchSegment downloads a segment whose local copy has been removed
+// and restores it at its original location.
+// The content is checked against the checksum stored in the upload status, if available.
+func FetchSegment(ctx context.Context, pathConf *conf.Path, segmentPath string) error {
+	st, err := recordstore.ReadUploadStatus(segmentPath)
+	if err != nil {
+		return err
+	}
+
+	if st.State != recordstore.UploadStateUploaded {
+		return fmt.Errorf("segment has not been uploaded")
+	}
+
+	// the segment is downloaded into a temporary file, in order to never expose incomplete segments.
+	f, err := os.CreateTemp(filepath.Dir(segmentPath), filepath.Base(segmentPath)+".*.part")
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+
+	err = download(ctx, pathConf, st.Key, io.MultiWriter(f, h))
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name()) //nolint:errcheck
+		return err
+	}
+
+	if st.Checksum != "" && hex.EncodeToString(h.Sum(nil)) != st.Checksum {
+		os.Remove(f.Name()) //nolint:errcheck
+		return fmt.Errorf("checksum of remote copy mismatch")
+	}
+
+	return os.Rename(f.Name(), segmentPath)
+}
@@ -0,0 +1,71 @@
+package uploader
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+)
+
+func TestFetchSegment(t *testing.T) {
+	for _, ca := range []string{"standard", "checksum mismatch"} {
+		t.Run(ca, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "mediamtx-uploader")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			segmentPath := filepath.Join(dir, "2008-05-20_22-15-25-000125.mp4")
+
+			ln, err := net.Listen("tcp", "127.0.0.1:9076")
+			require.NoError(t, err)
+
+			s := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					require.Equal(t, http.MethodGet, r.Method)
+					require.Equal(t, "/dav/mykey.mp4", r.URL.Path)
+					if ca == "standard" {
+						w.Write([]byte("abc")) //nolint:errcheck
+					} else {
+						w.Write([]byte("abd")) //nolint:errcheck
+					}
+				}),
+			}
+			go s.Serve(ln)
+			defer s.Shutdown(context.Background())
+
+			err = recordstore.WriteUploadStatus(segmentPath, &recordstore.UploadStatus{
+				State:    recordstore.UploadStateUploaded,
+				Key:      "mykey.mp4",
+				Checksum: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+			})
+			require.NoError(t, err)
+
+			err = FetchSegment(context.Background(), &conf.Path{
+				UploadStorage:  conf.UploadStorageWebDAV,
+				UploadEndpoint: "http://127.0.0.1:9076/dav",
+			}, segmentPath)
+
+			if ca == "checksum mismatch" {
+				require.EqualError(t, err, "checksum of remote copy mismatch")
+
+				entries, err2 := os.ReadDir(dir)
+				require.NoError(t, err2)
+				require.Len(t, entries, 1)
+				return
+			}
+
+			require.NoError(t, err)
+
+			byts, err := os.ReadFile(segmentPath)
+			require.NoError(t, err)
+			require.Equal(t, []byte("abc"), byts)
+		})
+	}
+}
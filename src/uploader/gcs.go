@@ -0,0 +1,438 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+)
+
+const (
+	gcsDefaultEndpoint = "https://storage.googleapis.com"
+	gcsDefaultTokenURI = "https://oauth2.googleapis.com/token"
+	gcsScope           = "https://www.googleapis.com/auth/devstorage.read_write"
+	gcsSignAlgorithm   = "GOOG4-RSA-SHA256"
+
+	// chunks of resumable uploads must be multiple of this.
+	gcsChunkAlignment = 256 * 1024
+)
+
+type gcsCredentials struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+type gcsToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type gcsError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// gcsStorage is a minimal client of the Google Cloud Storage JSON API.
+// It authenticates with the key of a service account, that is exchanged for an access token.
+type gcsStorage struct {
+	endpoint    string
+	bucket      string
+	clientEmail string
+	privateKey  *rsa.PrivateKey
+	tokenURI    string
+	partSize    conf.StringSize
+	httpClient  *http.Client
+
+	token       string
+	tokenExpiry time.Time
+}
+
+func newGCSStorage(sc storageConf, partSize conf.StringSize, httpClient *http.Client) (*gcsStorage, error) {
+	byts, err := os.ReadFile(sc.gcsCredentials)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds gcsCredentials
+	err = json.Unmarshal(byts, &creds)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials: %w", err)
+	}
+
+	if creds.Type != "service_account" {
+		return nil, fmt.Errorf("unsupported credentials type '%s'", creds.Type)
+	}
+
+	privateKey, err := gcsParsePrivateKey(creds.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	endpoint := sc.endpoint
+	if endpoint == "" {
+		endpoint = gcsDefaultEndpoint
+	}
+
+	tokenURI := creds.TokenURI
+	if tokenURI == "" {
+		tokenURI = gcsDefaultTokenURI
+	}
+
+	return &gcsStorage{
+		endpoint:    strings.TrimRight(endpoint, "/"),
+		bucket:      sc.bucket,
+		clientEmail: creds.ClientEmail,
+		privateKey:  privateKey,
+		tokenURI:    tokenURI,
+		partSize:    partSize,
+		httpClient:  httpClient,
+	}, nil
+}
+
+func gcsParsePrivateKey(v string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(v))
+	if block == nil {
+		return nil, fmt.Errorf("PEM block not found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not a RSA key")
+	}
+
+	return rsaKey, nil
+}
+
+// upload implements storage.
+// Objects bigger than partSize are uploaded with a resumable upload.
+func (c *gcsStorage) upload(ctx context.Context, obj *object) error {
+	if obj.size <= int64(c.partSize) {
+		body, err := io.ReadAll(obj.r)
+		if err != nil {
+			return err
+		}
+
+		res, err := c.do(ctx, http.MethodPost, c.uploadURL("media", obj.key),
+			http.Header{"Content-Type": []string{obj.contentType}}, body)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		io.Copy(io.Discard, res.Body) //nolint:errcheck
+		return nil
+	}
+
+	var p *partialUpload
+
+	if obj.partial != nil && obj.partial.ID != "" && obj.partial.PartSize == int64(c.partSize) {
+		p = obj.partial.clone()
+
+		_, err := obj.r.Seek(p.Offset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+	} else {
+		sessionURL, err := c.startResumableUpload(ctx, obj.key, obj.contentType, obj.size)
+		if err != nil {
+			return err
+		}
+
+		p = &partialUpload{
+			ID:       sessionURL,
+			PartSize: int64(c.partSize),
+		}
+		obj.onProgress(p.clone())
+	}
+
+	chunkSize := int64(c.partSize) - int64(c.partSize)%gcsChunkAlignment
+	if chunkSize == 0 {
+		chunkSize = gcsChunkAlignment
+	}
+
+	buf := make([]byte, chunkSize)
+
+	for p.Offset < obj.size {
+		n, err := io.ReadFull(obj.r, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		res, err := c.do(ctx, http.MethodPut, p.ID, http.Header{
+			"Content-Range": []string{fmt.Sprintf("bytes %d-%d/%d", p.Offset, p.Offset+int64(n)-1, obj.size)},
+		}, buf[:n])
+		if err != nil {
+			return fmt.Errorf("unable to upload chunk at offset %d: %w", p.Offset, err)
+		}
+		io.Copy(io.Discard, res.Body) //nolint:errcheck
+		res.Body.Close()
+
+		p.Offset += int64(n)
+		obj.onProgress(p.clone())
+	}
+
+	return nil
+}
+
+// abort implements storage.
+func (c *gcsStorage) abort(ctx context.Context, _ string, partial *partialUpload) {
+	if partial.ID != "" {
+		res, err := c.do(ctx, http.MethodDelete, partial.ID, nil, nil)
+		if err == nil {
+			res.Body.Close()
+		}
+	}
+}
+
+// download implements storage.
+func (c *gcsStorage) download(ctx context.Context, key string, w io.Writer) error {
+	res, err := c.do(ctx, http.MethodGet, c.endpoint+"/storage/v1/b/"+url.PathEscape(c.bucket)+
+		"/o/"+url.PathEscape(key)+"?alt=media", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+func (c *gcsStorage) uploadURL(uploadType string, key string) string {
+	return c.endpoint + "/upload/storage/v1/b/" + url.PathEscape(c.bucket) + "/o?" + url.Values{
+		"uploadType": []string{uploadType},
+		"name":       []string{key},
+	}.Encode()
+}
+
+func (c *gcsStorage) startResumableUpload(
+	ctx context.Context,
+	key string,
+	contentType string,
+	size int64,
+) (string, error) {
+	res, err := c.do(ctx, http.MethodPost, c.uploadURL("resumable", key), http.Header{
+		"X-Upload-Content-Type":   []string{contentType},
+		"X-Upload-Content-Length": []string{strconv.FormatInt(size, 10)},
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+
+	sessionURL := res.Header.Get("Location")
+	if sessionURL == "" {
+		return "", fmt.Errorf("session URL is missing")
+	}
+
+	return sessionURL, nil
+}
+
+func (c *gcsStorage) do(
+	ctx context.Context,
+	method string,
+	u string,
+	header http.Header,
+	body []byte,
+) (*http.Response, error) {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to obtain access token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// 308 is returned after intermediate chunks of resumable uploads.
+	if (res.StatusCode < 200 || res.StatusCode > 299) && res.StatusCode != http.StatusPermanentRedirect {
+		defer res.Body.Close()
+
+		byts, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+
+		var ge gcsError
+		if json.Unmarshal(byts, &ge) == nil && ge.Error.Message != "" {
+			return nil, fmt.Errorf("bad status code: %d (%s)", res.StatusCode, ge.Error.Message)
+		}
+
+		return nil, fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	return res, nil
+}
+
+// accessToken returns an access token, obtained by exchanging a JWT signed
+// with the key of the service account. Tokens are reused until they expire.
+func (c *gcsStorage) accessToken(ctx context.Context) (string, error) {
+	now := time.Now()
+
+	if c.token != "" && now.Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	assertion, err := c.signJWT(now)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURI, strings.NewReader(url.Values{
+		"grant_type": []string{"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  []string{assertion},
+	}.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	var tok gcsToken
+	err = json.NewDecoder(res.Body).Decode(&tok)
+	if err != nil {
+		return "", err
+	}
+
+	if tok.AccessToken == "" {
+		return "", fmt.Errorf("access token is missing")
+	}
+
+	c.token = tok.AccessToken
+	// renew the token one minute before it expires.
+	c.tokenExpiry = now.Add(time.Duration(tok.ExpiresIn)*time.Second - time.Minute)
+
+	return c.token, nil
+}
+
+func (c *gcsStorage) signJWT(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   c.clientEmail,
+		"scope": gcsScope,
+		"aud":   c.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(claims)
+
+	h := sha256.Sum256([]byte(unsigned))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, c.privateKey, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// signURL implements urlSigner.
+// URLs are signed with the key of the service account, with the V4 signing process
+// of the XML API, that is compatible with AWS Signature Version 4.
+func (c *gcsStorage) signURL(key string, expiration time.Duration, now time.Time) (string, error) {
+	now = now.UTC()
+	date := now.Format(s3DateFormat)
+	scope := now.Format(s3ShortDateForm) + "/auto/storage/goog4_request"
+
+	query := url.Values{
+		"X-Goog-Algorithm":     []string{gcsSignAlgorithm},
+		"X-Goog-Credential":    []string{c.clientEmail + "/" + scope},
+		"X-Goog-Date":          []string{date},
+		"X-Goog-Expires":       []string{strconv.FormatInt(int64(expiration/time.Second), 10)},
+		"X-Goog-SignedHeaders": []string{"host"},
+	}
+
+	objectURL := c.endpoint + "/" + s3Escape(c.bucket) + "/" + s3EscapePath(key)
+
+	u, err := url.Parse(objectURL)
+	if err != nil {
+		return "", err
+	}
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		s3CanonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := gcsSignAlgorithm + "\n" +
+		date + "\n" +
+		scope + "\n" +
+		hex.EncodeToString(canonicalRequestHash[:])
+
+	h := sha256.Sum256([]byte(stringToSign))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, c.privateKey, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+
+	query.Set("X-Goog-Signature", hex.EncodeToString(sig))
+
+	return objectURL + "?" + s3CanonicalQuery(query), nil
+}
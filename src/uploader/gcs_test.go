@@ -0,0 +1,143 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGCSStorage(t *testing.T) {
+	for _, ca := range []string{"simple", "resumable"} {
+		t.Run(ca, func(t *testing.T) {
+			privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			require.NoError(t, err)
+
+			dir, err := os.MkdirTemp("", "mediamtx-gcs")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			credsPath := filepath.Join(dir, "creds.json")
+			creds, err := json.Marshal(gcsCredentials{
+				Type:        "service_account",
+				ClientEmail: "myaccount@myproject.iam.gserviceaccount.com",
+				PrivateKey: string(pem.EncodeToMemory(&pem.Block{
+					Type:  "RSA PRIVATE KEY",
+					Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+				})),
+				TokenURI: "http://127.0.0.1:9071/token",
+			})
+			require.NoError(t, err)
+			err = os.WriteFile(credsPath, creds, 0o644)
+			require.NoError(t, err)
+
+			ln, err := net.Listen("tcp", "127.0.0.1:9071")
+			require.NoError(t, err)
+
+			var received bytes.Buffer
+			tokenCount := 0
+
+			s := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path == "/token" {
+						require.NoError(t, r.ParseForm())
+						require.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.PostForm.Get("grant_type"))
+
+						parts := strings.Split(r.PostForm.Get("assertion"), ".")
+						require.Len(t, parts, 3)
+
+						sig, err2 := base64.RawURLEncoding.DecodeString(parts[2])
+						require.NoError(t, err2)
+						h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+						require.NoError(t, rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, h[:], sig))
+
+						tokenCount++
+						w.Write([]byte(`{"access_token":"mytoken","expires_in":3600}`)) //nolint:errcheck
+						return
+					}
+
+					require.Equal(t, "Bearer mytoken", r.Header.Get("Authorization"))
+
+					body, err2 := io.ReadAll(r.Body)
+					require.NoError(t, err2)
+
+					switch {
+					case r.Method == http.MethodPost && r.URL.Query().Get("uploadType") == "media":
+						require.Equal(t, "/upload/storage/v1/b/mybucket/o", r.URL.Path)
+						require.Equal(t, "mypath/1.mp4", r.URL.Query().Get("name"))
+						require.Equal(t, "video/mp4", r.Header.Get("Content-Type"))
+						received.Write(body)
+
+					case r.Method == http.MethodPost && r.URL.Query().Get("uploadType") == "resumable":
+						require.Equal(t, "mypath/1.mp4", r.URL.Query().Get("name"))
+						require.Equal(t, "video/mp4", r.Header.Get("X-Upload-Content-Type"))
+						w.Header().Set("Location", "http://127.0.0.1:9071/session")
+
+					case r.Method == http.MethodPut && r.URL.Path == "/session":
+						require.Equal(t, fmt.Sprintf("bytes %d-%d/%d",
+							received.Len(), received.Len()+len(body)-1, 300*1024),
+							r.Header.Get("Content-Range"))
+						received.Write(body)
+
+						if received.Len() != 300*1024 {
+							w.WriteHeader(http.StatusPermanentRedirect)
+						}
+
+					default:
+						w.WriteHeader(http.StatusBadRequest)
+					}
+				}),
+			}
+			go s.Serve(ln)
+			defer s.Shutdown(context.Background())
+
+			httpClient := &http.Client{Timeout: 10 * time.Second}
+			defer httpClient.CloseIdleConnections()
+
+			st, err := newGCSStorage(storageConf{
+				endpoint:       "http://127.0.0.1:9071",
+				bucket:         "mybucket",
+				gcsCredentials: credsPath,
+			}, 16*1024, httpClient)
+			require.NoError(t, err)
+
+			content := bytes.Repeat([]byte{1, 2, 3, 4}, 300*1024/4)
+			if ca == "simple" {
+				content = content[:1024]
+			}
+
+			for i := 0; i < 2; i++ {
+				received.Reset()
+				err = st.upload(context.Background(), &object{
+					key:         "mypath/1.mp4",
+					contentType: "video/mp4",
+					r:           bytes.NewReader(content),
+					size:        int64(len(content)),
+					onProgress:  func(*partialUpload) {},
+				})
+				require.NoError(t, err)
+				require.Equal(t, content, received.Bytes())
+			}
+
+			// access token is reused.
+			require.Equal(t, 1, tokenCount)
+		})
+	}
+}
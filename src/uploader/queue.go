@@ -0,0 +1,78 @@
+package uploader
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// partialUpload is the state of an upload that has been split into parts.
+// It allows to resume the upload after a failure or a restart.
+type partialUpload struct {
+	// ID of the upload (S3) or URL of the session (GCS).
+	ID string `json:"id,omitempty"`
+	// ETags (S3) or block IDs (Azure) of uploaded parts.
+	Parts []string `json:"parts,omitempty"`
+	// Bytes that have been uploaded.
+	Offset int64 `json:"offset"`
+	// Size of parts. Uploads can be resumed only if it doesn't change.
+	PartSize int64 `json:"partSize"`
+}
+
+func (p *partialUpload) clone() *partialUpload {
+	c := *p
+	c.Parts = append([]string(nil), p.Parts...)
+	return &c
+}
+
+// queueEntry is a segment waiting to be uploaded.
+type queueEntry struct {
+	PathName    string         `json:"pathName"`
+	SegmentPath string         `json:"segmentPath"`
+	Partial     *partialUpload `json:"partial,omitempty"`
+}
+
+type queueFile struct {
+	Entries []*queueEntry `json:"entries"`
+}
+
+func loadQueue(fpath string) ([]*queueEntry, error) {
+	byts, err := os.ReadFile(fpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var qf queueFile
+	err = json.Unmarshal(byts, &qf)
+	if err != nil {
+		return nil, err
+	}
+
+	return qf.Entries, nil
+}
+
+// saveQueue writes the queue into a temporary file, that then replaces the
+// existing one, in order to never leave a truncated queue on disk.
+func saveQueue(fpath string, entries []*queueEntry) error {
+	byts, err := json.Marshal(queueFile{Entries: entries})
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(fpath), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := fpath + ".tmp"
+
+	err = os.WriteFile(tmpPath, byts, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, fpath)
+}
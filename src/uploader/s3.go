@@ -0,0 +1,473 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+)
+
+const (
+	s3Algorithm     = "AWS4-HMAC-SHA256"
+	s3DateFormat    = "20060102T150405Z"
+	s3ShortDateForm = "20060102"
+)
+
+type s3InitiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+// s3Storage is a minimal client of the S3 API.
+// It supports path-style requests only, in order to be compatible with MinIO
+// and other S3-compatible servers. Requests are signed with AWS Signature Version 4.
+type s3Storage struct {
+	endpoint        string
+	region          string
+	bucket          string
+	accessKeyID     string
+	secretAccessKey string
+	partSize        conf.StringSize
+	httpClient      *http.Client
+}
+
+func newS3Storage(sc storageConf, partSize conf.StringSize, httpClient *http.Client) *s3Storage {
+	endpoint := sc.endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + sc.region + ".amazonaws.com"
+	}
+
+	return &s3Storage{
+		endpoint:        endpoint,
+		region:          sc.region,
+		bucket:          sc.bucket,
+		accessKeyID:     sc.accessKeyID,
+		secretAccessKey: sc.secretAccessKey,
+		partSize:        partSize,
+		httpClient:      httpClient,
+	}
+}
+
+// upload implements storage.
+// Objects bigger than partSize are uploaded with a multipart upload.
+func (c *s3Storage) upload(ctx context.Context, obj *object) error {
+	if obj.size <= int64(c.partSize) {
+		body, err := io.ReadAll(obj.r)
+		if err != nil {
+			return err
+		}
+
+		return c.putObject(ctx, obj.key, obj.contentType, body)
+	}
+
+	var p *partialUpload
+
+	if obj.partial != nil && obj.partial.ID != "" && obj.partial.PartSize == int64(c.partSize) {
+		p = obj.partial.clone()
+
+		_, err := obj.r.Seek(p.Offset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+	} else {
+		uploadID, err := c.createMultipartUpload(ctx, obj.key, obj.contentType)
+		if err != nil {
+			return err
+		}
+
+		p = &partialUpload{
+			ID:       uploadID,
+			PartSize: int64(c.partSize),
+		}
+		obj.onProgress(p.clone())
+	}
+
+	buf := make([]byte, c.partSize)
+
+	for p.Offset < obj.size {
+		n, err := io.ReadFull(obj.r, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		partNumber := len(p.Parts) + 1
+
+		etag, err := c.uploadPart(ctx, obj.key, p.ID, partNumber, buf[:n])
+		if err != nil {
+			return fmt.Errorf("unable to upload part %d: %w", partNumber, err)
+		}
+
+		p.Parts = append(p.Parts, etag)
+		p.Offset += int64(n)
+		obj.onProgress(p.clone())
+	}
+
+	return c.completeParts(ctx, obj.key, p.ID, obj.contentType, p.Parts)
+}
+
+// createParts implements partStorage.
+func (c *s3Storage) createParts(ctx context.Context, key string, contentType string) (string, error) {
+	return c.createMultipartUpload(ctx, key, contentType)
+}
+
+// putPart implements partStorage.
+func (c *s3Storage) putPart(ctx context.Context, key string, uploadID string, number int, body []byte) (string, error) {
+	return c.uploadPart(ctx, key, uploadID, number, body)
+}
+
+// completeParts implements partStorage.
+func (c *s3Storage) completeParts(
+	ctx context.Context,
+	key string,
+	uploadID string,
+	_ string,
+	etags []string,
+) error {
+	parts := make([]s3CompletedPart, len(etags))
+	for i, etag := range etags {
+		parts[i] = s3CompletedPart{
+			PartNumber: i + 1,
+			ETag:       etag,
+		}
+	}
+
+	return c.completeMultipartUpload(ctx, key, uploadID, parts)
+}
+
+// abort implements storage.
+func (c *s3Storage) abort(ctx context.Context, key string, partial *partialUpload) {
+	if partial.ID != "" {
+		c.abortMultipartUpload(ctx, key, partial.ID) //nolint:errcheck
+	}
+}
+
+// download implements storage.
+func (c *s3Storage) download(ctx context.Context, key string, w io.Writer) error {
+	res, err := c.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+func (c *s3Storage) putObject(ctx context.Context, key string, contentType string, body []byte) error {
+	res, err := c.do(ctx, http.MethodPut, key, nil, http.Header{"Content-Type": []string{contentType}}, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+	return nil
+}
+
+func (c *s3Storage) createMultipartUpload(ctx context.Context, key string, contentType string) (string, error) {
+	res, err := c.do(ctx, http.MethodPost, key, url.Values{"uploads": []string{""}},
+		http.Header{"Content-Type": []string{contentType}}, nil)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var r s3InitiateMultipartUploadResult
+	err = xml.NewDecoder(res.Body).Decode(&r)
+	if err != nil {
+		return "", err
+	}
+
+	if r.UploadID == "" {
+		return "", fmt.Errorf("upload ID is missing")
+	}
+
+	return r.UploadID, nil
+}
+
+func (c *s3Storage) uploadPart(
+	ctx context.Context,
+	key string,
+	uploadID string,
+	partNumber int,
+	body []byte,
+) (string, error) {
+	res, err := c.do(ctx, http.MethodPut, key, url.Values{
+		"partNumber": []string{strconv.Itoa(partNumber)},
+		"uploadId":   []string{uploadID},
+	}, nil, body)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("ETag is missing")
+	}
+
+	return etag, nil
+}
+
+func (c *s3Storage) completeMultipartUpload(
+	ctx context.Context,
+	key string,
+	uploadID string,
+	parts []s3CompletedPart,
+) error {
+	body, err := xml.Marshal(s3CompleteMultipartUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	res, err := c.do(ctx, http.MethodPost, key, url.Values{"uploadId": []string{uploadID}},
+		http.Header{"Content-Type": []string{"application/xml"}}, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// the server can return an error with a 200 status code
+	// after the response has started.
+	byts, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	var xe xmlError
+	if xml.Unmarshal(byts, &xe) == nil && xe.Code != "" {
+		return fmt.Errorf("%s: %s", xe.Code, xe.Message)
+	}
+
+	return nil
+}
+
+func (c *s3Storage) abortMultipartUpload(ctx context.Context, key string, uploadID string) error {
+	res, err := c.do(ctx, http.MethodDelete, key, url.Values{"uploadId": []string{uploadID}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+	return nil
+}
+
+func (c *s3Storage) objectURL(key string, query url.Values) string {
+	u := strings.TrimRight(c.endpoint, "/") + "/" + s3Escape(c.bucket) + "/" + s3EscapePath(key)
+	if len(query) != 0 {
+		u += "?" + s3CanonicalQuery(query)
+	}
+	return u
+}
+
+func (c *s3Storage) do(
+	ctx context.Context,
+	method string,
+	key string,
+	query url.Values,
+	header http.Header,
+	body []byte,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(key, query), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	c.sign(req, body, time.Now())
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		return nil, readXMLError(res)
+	}
+
+	return res, nil
+}
+
+func (c *s3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(s3DateFormat)
+	shortDate := now.Format(s3ShortDateForm)
+
+	payloadHash := sha256.Sum256(body)
+	payloadHashHex := hex.EncodeToString(payloadHash[:])
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHashHex)
+
+	signedHeaderNames := []string{"host"}
+	for k := range req.Header {
+		k = strings.ToLower(k)
+		if k == "content-type" || strings.HasPrefix(k, "x-amz-") {
+			signedHeaderNames = append(signedHeaderNames, k)
+		}
+	}
+	sort.Strings(signedHeaderNames)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range signedHeaderNames {
+		var v string
+		if k == "host" {
+			v = req.URL.Host
+		} else {
+			v = strings.TrimSpace(req.Header.Get(k))
+		}
+		canonicalHeaders.WriteString(k + ":" + v + "\n")
+	}
+
+	signedHeaders := strings.Join(signedHeaderNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHashHex,
+	}, "\n")
+
+	scope := shortDate + "/" + c.region + "/s3/aws4_request"
+
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := s3Algorithm + "\n" +
+		amzDate + "\n" +
+		scope + "\n" +
+		hex.EncodeToString(canonicalRequestHash[:])
+
+	req.Header.Set("Authorization", s3Algorithm+
+		" Credential="+c.accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+c.signature(shortDate, stringToSign))
+}
+
+func (c *s3Storage) signature(shortDate string, stringToSign string) string {
+	key := s3HMAC([]byte("AWS4"+c.secretAccessKey), shortDate)
+	key = s3HMAC(key, c.region)
+	key = s3HMAC(key, "s3")
+	key = s3HMAC(key, "aws4_request")
+	return hex.EncodeToString(s3HMAC(key, stringToSign))
+}
+
+// signURL implements urlSigner.
+// The signature is placed into the query, as described by AWS Signature Version 4.
+func (c *s3Storage) signURL(key string, expiration time.Duration, now time.Time) (string, error) {
+	now = now.UTC()
+	amzDate := now.Format(s3DateFormat)
+	shortDate := now.Format(s3ShortDateForm)
+	scope := shortDate + "/" + c.region + "/s3/aws4_request"
+
+	query := url.Values{
+		"X-Amz-Algorithm":     []string{s3Algorithm},
+		"X-Amz-Credential":    []string{c.accessKeyID + "/" + scope},
+		"X-Amz-Date":          []string{amzDate},
+		"X-Amz-Expires":       []string{strconv.FormatInt(int64(expiration/time.Second), 10)},
+		"X-Amz-SignedHeaders": []string{"host"},
+	}
+
+	u, err := url.Parse(c.objectURL(key, nil))
+	if err != nil {
+		return "", err
+	}
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		s3CanonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := s3Algorithm + "\n" +
+		amzDate + "\n" +
+		scope + "\n" +
+		hex.EncodeToString(canonicalRequestHash[:])
+
+	query.Set("X-Amz-Signature", c.signature(shortDate, stringToSign))
+
+	return c.objectURL(key, query), nil
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape escapes a string as required by AWS Signature Version 4:
+// every byte except unreserved characters is percent-encoded.
+func s3Escape(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') ||
+			ch == '-' || ch == '.' || ch == '_' || ch == '~' {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+
+	return b.String()
+}
+
+func s3EscapePath(s string) string {
+	parts := strings.Split(s, "/")
+	for i, part := range parts {
+		parts[i] = s3Escape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
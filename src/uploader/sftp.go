@@ -0,0 +1,625 @@
+package uploader
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+)
+
+const (
+	sftpVersion = 3
+
+	// maximum number of idle connections kept open.
+	sftpMaxIdleConns = 4
+
+	// maximum size of data sent with a single write request or received with a single read request.
+	sftpMaxWriteSize = 32 * 1024
+)
+
+// packet types.
+const (
+	sftpTypeInit     = 1
+	sftpTypeVersion  = 2
+	sftpTypeOpen     = 3
+	sftpTypeClose    = 4
+	sftpTypeRead     = 5
+	sftpTypeWrite    = 6
+	sftpTypeRemove   = 13
+	sftpTypeMkdir    = 14
+	sftpTypeRename   = 18
+	sftpTypeStatus   = 101
+	sftpTypeHandle   = 102
+	sftpTypeData     = 103
+	sftpTypeExtended = 200
+)
+
+// flags of open requests.
+const (
+	sftpFlagRead   = 0x01
+	sftpFlagWrite  = 0x02
+	sftpFlagCreate = 0x08
+	sftpFlagTrunc  = 0x10
+)
+
+// status codes.
+const (
+	sftpStatusEOF = 1
+)
+
+const sftpPosixRename = "posix-rename@openssh.com"
+
+// sftpStatusError is an error returned by the server.
+// It doesn't affect the connection, that can be reused.
+type sftpStatusError struct {
+	code uint32
+	msg  string
+}
+
+// Error implements error.
+func (e *sftpStatusError) Error() string {
+	if e.msg != "" {
+		return fmt.Sprintf("SFTP error %d (%s)", e.code, e.msg)
+	}
+	return fmt.Sprintf("SFTP error %d", e.code)
+}
+
+func sftpAppendUint32(b []byte, v uint32) []byte {
+	return binary.BigEndian.AppendUint32(b, v)
+}
+
+func sftpAppendString(b []byte, v string) []byte {
+	b = sftpAppendUint32(b, uint32(len(v)))
+	return append(b, v...)
+}
+
+func sftpReadUint32(b []byte) (uint32, []byte, error) {
+	if len(b) < 4 {
+		return 0, nil, fmt.Errorf("packet is too short")
+	}
+	return binary.BigEndian.Uint32(b), b[4:], nil
+}
+
+func sftpReadString(b []byte) (string, []byte, error) {
+	l, b, err := sftpReadUint32(b)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint32(len(b)) < l {
+		return "", nil, fmt.Errorf("packet is too short")
+	}
+	return string(b[:l]), b[l:], nil
+}
+
+func sftpWritePacket(w io.Writer, typ byte, payload []byte) error {
+	buf := make([]byte, 0, 5+len(payload))
+	buf = sftpAppendUint32(buf, uint32(1+len(payload)))
+	buf = append(buf, typ)
+	buf = append(buf, payload...)
+	_, err := w.Write(buf)
+	return err
+}
+
+func sftpReadPacket(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	l := binary.BigEndian.Uint32(header[:4])
+	if l == 0 || l > 256*1024 {
+		return 0, nil, fmt.Errorf("invalid packet length: %d", l)
+	}
+
+	payload := make([]byte, l-1)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return header[4], payload, nil
+}
+
+// sftpConn is a SFTP session over a SSH connection.
+// Requests are performed one at a time.
+type sftpConn struct {
+	client      *ssh.Client
+	session     *ssh.Session
+	w           io.WriteCloser
+	r           io.Reader
+	nextID      uint32
+	posixRename bool
+}
+
+func dialSFTP(ctx context.Context, address string, config *ssh.ClientConfig) (*sftpConn, error) {
+	nconn, err := (&net.Dialer{Timeout: config.Timeout}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	sconn, chans, reqs, err := ssh.NewClientConn(nconn, address, config)
+	if err != nil {
+		nconn.Close()
+		return nil, err
+	}
+
+	c := &sftpConn{
+		client: ssh.NewClient(sconn, chans, reqs),
+	}
+
+	err = c.initialize()
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *sftpConn) initialize() error {
+	var err error
+	c.session, err = c.client.NewSession()
+	if err != nil {
+		return err
+	}
+
+	c.w, err = c.session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	c.r, err = c.session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = c.session.RequestSubsystem("sftp")
+	if err != nil {
+		return err
+	}
+
+	err = sftpWritePacket(c.w, sftpTypeInit, sftpAppendUint32(nil, sftpVersion))
+	if err != nil {
+		return err
+	}
+
+	typ, payload, err := sftpReadPacket(c.r)
+	if err != nil {
+		return err
+	}
+
+	if typ != sftpTypeVersion {
+		return fmt.Errorf("unexpected packet type: %d", typ)
+	}
+
+	_, payload, err = sftpReadUint32(payload)
+	if err != nil {
+		return err
+	}
+
+	// extensions supported by the server.
+	for len(payload) != 0 {
+		var name string
+		name, payload, err = sftpReadString(payload)
+		if err != nil {
+			return err
+		}
+
+		_, payload, err = sftpReadString(payload)
+		if err != nil {
+			return err
+		}
+
+		if name == sftpPosixRename {
+			c.posixRename = true
+		}
+	}
+
+	return nil
+}
+
+func (c *sftpConn) close() {
+	if c.session != nil {
+		c.session.Close()
+	}
+	c.client.Close()
+}
+
+func (c *sftpConn) request(typ byte, payload []byte) (byte, []byte, error) {
+	c.nextID++
+	id := c.nextID
+
+	err := sftpWritePacket(c.w, typ, append(sftpAppendUint32(nil, id), payload...))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	resTyp, res, err := sftpReadPacket(c.r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	resID, res, err := sftpReadUint32(res)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if resID != id {
+		return 0, nil, fmt.Errorf("unexpected request ID: %d", resID)
+	}
+
+	if resTyp == sftpTypeStatus {
+		code, rest, err := sftpReadUint32(res)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if code != 0 {
+			msg, _, _ := sftpReadString(rest)
+			return 0, nil, &sftpStatusError{code: code, msg: msg}
+		}
+	}
+
+	return resTyp, res, nil
+}
+
+func (c *sftpConn) open(fpath string, flags uint32) (string, error) {
+	payload := sftpAppendString(nil, fpath)
+	payload = sftpAppendUint32(payload, flags)
+	payload = sftpAppendUint32(payload, 0) // attributes
+
+	typ, res, err := c.request(sftpTypeOpen, payload)
+	if err != nil {
+		return "", err
+	}
+
+	if typ != sftpTypeHandle {
+		return "", fmt.Errorf("unexpected packet type: %d", typ)
+	}
+
+	handle, _, err := sftpReadString(res)
+	return handle, err
+}
+
+func (c *sftpConn) closeHandle(handle string) error {
+	_, _, err := c.request(sftpTypeClose, sftpAppendString(nil, handle))
+	return err
+}
+
+func (c *sftpConn) write(handle string, offset int64, data []byte) error {
+	payload := sftpAppendString(nil, handle)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(offset))
+	payload = sftpAppendString(payload, string(data))
+
+	_, _, err := c.request(sftpTypeWrite, payload)
+	return err
+}
+
+// read reads data at the given offset. It returns io.EOF at the end of the file.
+func (c *sftpConn) read(handle string, offset int64, size uint32) ([]byte, error) {
+	payload := sftpAppendString(nil, handle)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(offset))
+	payload = sftpAppendUint32(payload, size)
+
+	typ, res, err := c.request(sftpTypeRead, payload)
+	if err != nil {
+		var se *sftpStatusError
+		if errors.As(err, &se) && se.code == sftpStatusEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	if typ != sftpTypeData {
+		return nil, fmt.Errorf("unexpected packet type: %d", typ)
+	}
+
+	data, _, err := sftpReadString(res)
+	return []byte(data), err
+}
+
+func (c *sftpConn) mkdir(fpath string) error {
+	_, _, err := c.request(sftpTypeMkdir, sftpAppendUint32(sftpAppendString(nil, fpath), 0))
+	return err
+}
+
+func (c *sftpConn) remove(fpath string) error {
+	_, _, err := c.request(sftpTypeRemove, sftpAppendString(nil, fpath))
+	return err
+}
+
+// rename renames a file, replacing the destination if it exists.
+func (c *sftpConn) rename(oldPath string, newPath string) error {
+	if c.posixRename {
+		payload := sftpAppendString(nil, sftpPosixRename)
+		payload = sftpAppendString(payload, oldPath)
+		payload = sftpAppendString(payload, newPath)
+		_, _, err := c.request(sftpTypeExtended, payload)
+		return err
+	}
+
+	// the standard rename fails when the destination exists.
+	c.remove(newPath) //nolint:errcheck
+
+	_, _, err := c.request(sftpTypeRename, sftpAppendString(sftpAppendString(nil, oldPath), newPath))
+	return err
+}
+
+// sftpStorage is a storage that uploads segments to a SSH server.
+// Connections are kept open and reused between uploads.
+// Segments are written into a temporary file, that is then renamed,
+// in order to never expose incomplete segments.
+type sftpStorage struct {
+	address  string
+	basePath string
+	config   *ssh.ClientConfig
+	partSize conf.StringSize
+
+	mutex   sync.Mutex
+	idle    []*sftpConn
+	created map[string]struct{}
+}
+
+func newSFTPStorage(sc storageConf, partSize conf.StringSize, timeout time.Duration) (*sftpStorage, error) {
+	u, err := url.Parse(sc.endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	basePath := u.Path
+	if basePath == "" {
+		basePath = "."
+	}
+
+	var auth []ssh.AuthMethod
+
+	if sc.sftpKey != "" {
+		byts, err := os.ReadFile(sc.sftpKey)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, err := ssh.ParsePrivateKey(byts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %w", err)
+		}
+
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	if sc.pass != "" {
+		auth = append(auth, ssh.Password(sc.pass))
+	}
+
+	fingerprint := sc.sftpFingerprint
+
+	return &sftpStorage{
+		address:  address,
+		basePath: basePath,
+		config: &ssh.ClientConfig{
+			User: sc.user,
+			Auth: auth,
+			HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+				if fp := ssh.FingerprintSHA256(key); fp != fingerprint {
+					return fmt.Errorf("fingerprint of host key (%s) doesn't match the configured one", fp)
+				}
+				return nil
+			},
+			Timeout: timeout,
+		},
+		partSize: partSize,
+		created:  make(map[string]struct{}),
+	}, nil
+}
+
+// close closes idle connections.
+func (c *sftpStorage) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, conn := range c.idle {
+		conn.close()
+	}
+	c.idle = nil
+}
+
+func (c *sftpStorage) getConn(ctx context.Context) (*sftpConn, error) {
+	c.mutex.Lock()
+	if n := len(c.idle); n != 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mutex.Unlock()
+		return conn, nil
+	}
+	c.mutex.Unlock()
+
+	return dialSFTP(ctx, c.address, c.config)
+}
+
+func (c *sftpStorage) putConn(conn *sftpConn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.idle) >= sftpMaxIdleConns {
+		conn.close()
+		return
+	}
+
+	c.idle = append(c.idle, conn)
+}
+
+// withConn calls cb with a connection, that is then returned to the pool
+// unless it has been broken.
+func (c *sftpStorage) withConn(ctx context.Context, cb func(conn *sftpConn) error) error {
+	conn, err := c.getConn(ctx)
+	if err != nil {
+		return err
+	}
+
+	// requests don't support contexts, therefore the connection is closed.
+	stop := context.AfterFunc(ctx, conn.close)
+
+	err = cb(conn)
+
+	var se *sftpStatusError
+	if stop() && (err == nil || errors.As(err, &se)) {
+		c.putConn(conn)
+	} else {
+		conn.close()
+	}
+
+	return err
+}
+
+// upload implements storage.
+// Progress is saved every partSize bytes, and uploads are resumed from the last saved offset.
+func (c *sftpStorage) upload(ctx context.Context, obj *object) error {
+	return c.withConn(ctx, func(conn *sftpConn) error {
+		fpath := path.Join(c.basePath, obj.key)
+
+		c.makeDirs(conn, path.Dir(fpath))
+
+		var p *partialUpload
+		flags := uint32(sftpFlagWrite | sftpFlagCreate)
+
+		if obj.partial != nil && obj.partial.PartSize == int64(c.partSize) {
+			p = obj.partial.clone()
+
+			_, err := obj.r.Seek(p.Offset, io.SeekStart)
+			if err != nil {
+				return err
+			}
+		} else {
+			p = &partialUpload{
+				ID:       tempKey(fpath),
+				PartSize: int64(c.partSize),
+			}
+			flags |= sftpFlagTrunc
+		}
+
+		handle, err := conn.open(p.ID, flags)
+		if err != nil {
+			// directories may have been removed in the meanwhile.
+			c.forgetDirs()
+			return err
+		}
+
+		if flags&sftpFlagTrunc != 0 {
+			obj.onProgress(p.clone())
+		}
+
+		buf := make([]byte, sftpMaxWriteSize)
+		lastProgress := p.Offset
+
+		for p.Offset < obj.size {
+			n, err := io.ReadFull(obj.r, buf[:min(int64(len(buf)), obj.size-p.Offset)])
+			if err != nil {
+				conn.closeHandle(handle) //nolint:errcheck
+				return err
+			}
+
+			err = conn.write(handle, p.Offset, buf[:n])
+			if err != nil {
+				conn.closeHandle(handle) //nolint:errcheck
+				return fmt.Errorf("unable to write at offset %d: %w", p.Offset, err)
+			}
+
+			p.Offset += int64(n)
+
+			if (p.Offset - lastProgress) >= p.PartSize {
+				lastProgress = p.Offset
+				obj.onProgress(p.clone())
+			}
+		}
+
+		err = conn.closeHandle(handle)
+		if err != nil {
+			return err
+		}
+
+		return conn.rename(p.ID, fpath)
+	})
+}
+
+// abort implements storage.
+func (c *sftpStorage) abort(ctx context.Context, _ string, partial *partialUpload) {
+	c.withConn(ctx, func(conn *sftpConn) error { //nolint:errcheck
+		return conn.remove(partial.ID)
+	})
+}
+
+func (c *sftpStorage) forgetDirs() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	clear(c.created)
+}
+
+// download implements storage.
+func (c *sftpStorage) download(ctx context.Context, key string, w io.Writer) error {
+	return c.withConn(ctx, func(conn *sftpConn) error {
+		handle, err := conn.open(path.Join(c.basePath, key), sftpFlagRead)
+		if err != nil {
+			return err
+		}
+		defer conn.closeHandle(handle) //nolint:errcheck
+
+		var offset int64
+
+		for {
+			data, err := conn.read(handle, offset, sftpMaxWriteSize)
+			if err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+
+			_, err = w.Write(data)
+			if err != nil {
+				return err
+			}
+
+			offset += int64(len(data))
+		}
+	})
+}
+
+// makeDirs creates a directory and its parents.
+// Errors are ignored, since they are returned when directories already exist.
+func (c *sftpStorage) makeDirs(conn *sftpConn, dir string) {
+	if dir == "." || dir == "/" {
+		return
+	}
+
+	c.mutex.Lock()
+	_, ok := c.created[dir]
+	c.mutex.Unlock()
+	if ok {
+		return
+	}
+
+	c.makeDirs(conn, path.Dir(dir))
+
+	conn.mkdir(dir) //nolint:errcheck
+
+	c.mutex.Lock()
+	c.created[dir] = struct{}{}
+	c.mutex.Unlock()
+}
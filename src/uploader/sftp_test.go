@@ -0,0 +1,279 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+type dummySFTPServer struct {
+	root  string
+	conns atomic.Int64
+}
+
+func (s *dummySFTPServer) serve(ln net.Listener, config *ssh.ServerConfig) {
+	for {
+		nconn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(nconn, config)
+			if err != nil {
+				return
+			}
+			s.conns.Add(1)
+
+			go ssh.DiscardRequests(reqs)
+
+			for newCh := range chans {
+				if newCh.ChannelType() != "session" {
+					newCh.Reject(ssh.UnknownChannelType, "") //nolint:errcheck
+					continue
+				}
+
+				ch, chReqs, err := newCh.Accept()
+				if err != nil {
+					return
+				}
+
+				go func() {
+					for req := range chReqs {
+						if req.Type == "subsystem" && string(req.Payload[4:]) == "sftp" {
+							req.Reply(true, nil) //nolint:errcheck
+							go s.serveSFTP(ch)
+						} else {
+							req.Reply(false, nil) //nolint:errcheck
+						}
+					}
+				}()
+			}
+		}()
+	}
+}
+
+func (s *dummySFTPServer) serveSFTP(ch ssh.Channel) {
+	defer ch.Close()
+
+	typ, _, err := sftpReadPacket(ch)
+	if err != nil || typ != sftpTypeInit {
+		return
+	}
+
+	payload := sftpAppendUint32(nil, sftpVersion)
+	payload = sftpAppendString(payload, sftpPosixRename)
+	payload = sftpAppendString(payload, "1")
+	sftpWritePacket(ch, sftpTypeVersion, payload) //nolint:errcheck
+
+	files := make(map[string]*os.File)
+
+	for {
+		typ, req, err := sftpReadPacket(ch)
+		if err != nil {
+			return
+		}
+
+		id, req, _ := sftpReadUint32(req)
+		res := sftpAppendUint32(nil, id)
+
+		writeStatus := func(err error) {
+			code := uint32(0)
+			if err != nil {
+				code = 4
+			}
+			sftpWritePacket(ch, sftpTypeStatus, sftpAppendString(sftpAppendUint32(res, code), "")) //nolint:errcheck
+		}
+
+		switch typ {
+		case sftpTypeOpen:
+			name, rest, _ := sftpReadString(req)
+			flags, _, _ := sftpReadUint32(rest)
+
+			osFlags := os.O_RDONLY
+			if flags&sftpFlagWrite != 0 {
+				osFlags = os.O_WRONLY | os.O_CREATE
+				if flags&sftpFlagTrunc != 0 {
+					osFlags |= os.O_TRUNC
+				}
+			}
+
+			f, err := os.OpenFile(filepath.Join(s.root, name), osFlags, 0o644)
+			if err != nil {
+				writeStatus(err)
+				continue
+			}
+
+			handle := name
+			files[handle] = f
+			sftpWritePacket(ch, sftpTypeHandle, sftpAppendString(res, handle)) //nolint:errcheck
+
+		case sftpTypeWrite:
+			handle, rest, _ := sftpReadString(req)
+			offset := binary.BigEndian.Uint64(rest)
+			data, _, _ := sftpReadString(rest[8:])
+			_, err := files[handle].WriteAt([]byte(data), int64(offset))
+			writeStatus(err)
+
+		case sftpTypeRead:
+			handle, rest, _ := sftpReadString(req)
+			offset := binary.BigEndian.Uint64(rest)
+			size := binary.BigEndian.Uint32(rest[8:])
+			buf := make([]byte, size)
+			n, err := files[handle].ReadAt(buf, int64(offset))
+			if n == 0 && err != nil {
+				sftpWritePacket(ch, sftpTypeStatus, sftpAppendString(sftpAppendUint32(res, sftpStatusEOF), "")) //nolint:errcheck
+				continue
+			}
+			sftpWritePacket(ch, sftpTypeData, sftpAppendString(res, string(buf[:n]))) //nolint:errcheck
+
+		case sftpTypeClose:
+			handle, _, _ := sftpReadString(req)
+			err := files[handle].Close()
+			delete(files, handle)
+			writeStatus(err)
+
+		case sftpTypeMkdir:
+			name, _, _ := sftpReadString(req)
+			writeStatus(os.Mkdir(filepath.Join(s.root, name), 0o755))
+
+		case sftpTypeRemove:
+			name, _, _ := sftpReadString(req)
+			writeStatus(os.Remove(filepath.Join(s.root, name)))
+
+		case sftpTypeExtended:
+			_, rest, _ := sftpReadString(req)
+			oldPath, rest, _ := sftpReadString(rest)
+			newPath, _, _ := sftpReadString(rest)
+			writeStatus(os.Rename(filepath.Join(s.root, oldPath), filepath.Join(s.root, newPath)))
+
+		default:
+			writeStatus(os.ErrInvalid)
+		}
+	}
+}
+
+func TestSFTPStorage(t *testing.T) {
+	for _, ca := range []string{"standard", "resume", "wrong fingerprint"} {
+		t.Run(ca, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "mediamtx-uploader")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			_, priv, err := ed25519.GenerateKey(rand.Reader)
+			require.NoError(t, err)
+
+			signer, err := ssh.NewSignerFromKey(priv)
+			require.NoError(t, err)
+
+			config := &ssh.ServerConfig{
+				PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+					require.Equal(t, "myuser", c.User())
+					require.Equal(t, "mypass", string(pass))
+					return nil, nil
+				},
+			}
+			config.AddHostKey(signer)
+
+			ln, err := net.Listen("tcp", "127.0.0.1:9074")
+			require.NoError(t, err)
+			defer ln.Close()
+
+			srv := &dummySFTPServer{root: dir}
+			go srv.serve(ln, config)
+
+			fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+			if ca == "wrong fingerprint" {
+				fingerprint = "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"
+			}
+
+			st, err := newSFTPStorage(storageConf{
+				endpoint:        "sftp://127.0.0.1:9074/upload",
+				user:            "myuser",
+				pass:            "mypass",
+				sftpFingerprint: fingerprint,
+			}, 16, 10*time.Second)
+			require.NoError(t, err)
+			defer st.close()
+
+			content := bytes.Repeat([]byte{1, 2, 3, 4}, 10)
+
+			var partial *partialUpload
+
+			if ca == "resume" {
+				err = os.MkdirAll(filepath.Join(dir, "upload", "mypath"), 0o755)
+				require.NoError(t, err)
+
+				// the first part has been uploaded before a restart.
+				err = os.WriteFile(filepath.Join(dir, "upload", "mypath", "1.mp4.part"), content[:16], 0o644)
+				require.NoError(t, err)
+
+				partial = &partialUpload{
+					ID:       "/upload/mypath/1.mp4.part",
+					Offset:   16,
+					PartSize: 16,
+				}
+			}
+
+			var progress []*partialUpload
+
+			upload := func() error {
+				return st.upload(context.Background(), &object{
+					key:         "mypath/1.mp4",
+					contentType: "video/mp4",
+					r:           bytes.NewReader(content),
+					size:        int64(len(content)),
+					partial:     partial,
+					onProgress: func(p *partialUpload) {
+						progress = append(progress, p)
+					},
+				})
+			}
+
+			err = upload()
+
+			if ca == "wrong fingerprint" {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			byts, err := os.ReadFile(filepath.Join(dir, "upload", "mypath", "1.mp4"))
+			require.NoError(t, err)
+			require.Equal(t, content, byts)
+
+			_, err = os.Stat(filepath.Join(dir, "upload", "mypath", "1.mp4.part"))
+			require.True(t, os.IsNotExist(err))
+
+			if ca == "standard" {
+				require.Equal(t, []*partialUpload{
+					{ID: "/upload/mypath/1.mp4.part", PartSize: 16},
+					{ID: "/upload/mypath/1.mp4.part", Offset: 40, PartSize: 16},
+				}, progress)
+
+				// the connection is reused.
+				err = upload()
+				require.NoError(t, err)
+
+				var buf bytes.Buffer
+				err = st.download(context.Background(), "mypath/1.mp4", &buf)
+				require.NoError(t, err)
+				require.Equal(t, content, buf.Bytes())
+
+				require.Equal(t, int64(1), srv.conns.Load())
+			}
+		})
+	}
+}
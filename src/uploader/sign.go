@@ -0,0 +1,46 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+)
+
+// MaxSignedURLExpiration is the maximum validity of signed URLs.
+const MaxSignedURLExpiration = 7 * 24 * time.Hour
+
+// ErrSignedURLsNotSupported is returned when the storage does not support signed URLs.
+var ErrSignedURLsNotSupported = errors.New("storage does not support signed URLs")
+
+// urlSigner is a storage that allows to download objects through pre-signed URLs,
+// that can be used without credentials until they expire.
+type urlSigner interface {
+	signURL(key string, expiration time.Duration, now time.Time) (string, error)
+}
+
+// SignURL returns a pre-signed URL that allows to download an object
+// of the storage of a path directly, without passing through the server.
+func SignURL(pathConf *conf.Path, key string, expiration time.Duration) (string, error) {
+	if expiration < time.Second || expiration > MaxSignedURLExpiration {
+		return "", fmt.Errorf("expiration must be between 1s and %v", MaxSignedURLExpiration)
+	}
+
+	s, err := newStorage(newStorageConf(pathConf), 0, &http.Client{})
+	if err != nil {
+		return "", err
+	}
+
+	if c, ok := s.(interface{ close() }); ok {
+		defer c.close()
+	}
+
+	sg, ok := s.(urlSigner)
+	if !ok {
+		return "", ErrSignedURLsNotSupported
+	}
+
+	return sg.signURL(key, expiration, time.Now())
+}
@@ -0,0 +1,123 @@
+package uploader
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+)
+
+// object is an object to upload.
+type object struct {
+	key         string
+	contentType string
+	r           io.ReadSeeker
+	size        int64
+	// when not nil, a previous upload is resumed.
+	partial *partialUpload
+	// called every time a part has been uploaded.
+	onProgress func(*partialUpload)
+}
+
+// storage is a remote storage that receives segments.
+type storage interface {
+	// upload uploads an object.
+	upload(ctx context.Context, obj *object) error
+
+	// abort discards a partial upload.
+	abort(ctx context.Context, key string, partial *partialUpload)
+
+	// download writes the content of an object into w.
+	download(ctx context.Context, key string, w io.Writer) error
+}
+
+// partStorage is a storage that allows to upload parts of an object
+// in any order, before the object is complete.
+// It is used to stream segments while they are written.
+type partStorage interface {
+	storage
+
+	// createParts starts an upload in parts and returns its ID.
+	createParts(ctx context.Context, key string, contentType string) (string, error)
+
+	// putPart uploads a part and returns its ID. Parts are numbered starting from 1.
+	putPart(ctx context.Context, key string, uploadID string, number int, body []byte) (string, error)
+
+	// completeParts joins uploaded parts into the object, in the given order.
+	completeParts(ctx context.Context, key string, uploadID string, contentType string, parts []string) error
+}
+
+// storageConf contains the parameters of a storage.
+// It is used to share storages among paths with the same parameters.
+type storageConf struct {
+	storage          conf.UploadStorage
+	endpoint         string
+	bucket           string
+	region           string
+	accessKeyID      string
+	secretAccessKey  string
+	gcsCredentials   string
+	azureAccountName string
+	azureAccountKey  string
+	user             string
+	pass             string
+	sftpKey          string
+	sftpFingerprint  string
+}
+
+func newStorageConf(pathConf *conf.Path) storageConf {
+	return storageConf{
+		storage:          pathConf.UploadStorage,
+		endpoint:         pathConf.UploadEndpoint,
+		bucket:           pathConf.UploadBucket,
+		region:           pathConf.UploadRegion,
+		accessKeyID:      pathConf.UploadAccessKeyID,
+		secretAccessKey:  pathConf.UploadSecretAccessKey,
+		gcsCredentials:   pathConf.UploadGCSCredentials,
+		azureAccountName: pathConf.UploadAzureAccountName,
+		azureAccountKey:  pathConf.UploadAzureAccountKey,
+		user:             pathConf.UploadUser,
+		pass:             pathConf.UploadPass,
+		sftpKey:          pathConf.UploadSFTPKey,
+		sftpFingerprint:  pathConf.UploadSFTPFingerprint,
+	}
+}
+
+func newStorage(sc storageConf, partSize conf.StringSize, httpClient *http.Client) (storage, error) {
+	switch sc.storage {
+	case conf.UploadStorageGCS:
+		return newGCSStorage(sc, partSize, httpClient)
+
+	case conf.UploadStorageAzure:
+		return newAzureStorage(sc, partSize, httpClient)
+
+	case conf.UploadStorageWebDAV:
+		return newWebDAVStorage(sc, httpClient)
+
+	case conf.UploadStorageSFTP:
+		return newSFTPStorage(sc, partSize, httpClient.Timeout)
+
+	default:
+		return newS3Storage(sc, partSize, httpClient), nil
+	}
+}
+
+// xmlError is the error returned by storages that use XML (S3 and Azure).
+type xmlError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func readXMLError(res *http.Response) error {
+	byts, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+
+	var xe xmlError
+	if xml.Unmarshal(byts, &xe) == nil && xe.Code != "" {
+		return fmt.Errorf("bad status code: %d (%s: %s)", res.StatusCode, xe.Code, xe.Message)
+	}
+
+	return fmt.Errorf("bad status code: %d", res.StatusCode)
+}
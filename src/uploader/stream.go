@@ -0,0 +1,210 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/logger"
+)
+
+// maximum number of parts that are waiting to be uploaded.
+const streamQueueSize = 2
+
+var errNotStreamed = errors.New("segment is too small to be streamed")
+
+// segmentStream uploads a segment while it is written.
+// The first part of the segment is uploaded after the segment has been finalized,
+// since its header is modified when the segment is closed.
+// When the stream can't keep up or fails, the segment is uploaded after completion
+// through the queue.
+type segmentStream struct {
+	u           *Uploader
+	pathName    string
+	segmentPath string
+	sc          storageConf
+	s           partStorage
+	key         string
+	partSize    int64
+
+	ctx       context.Context
+	ctxCancel func()
+	received  int64
+	buf       []byte
+
+	// protected by Uploader.mutex
+	pushed   bool
+	fallback bool
+
+	// in
+	chPart chan []byte
+}
+
+func (st *segmentStream) initialize() {
+	st.ctx, st.ctxCancel = context.WithCancel(st.u.ctx)
+	st.chPart = make(chan []byte, streamQueueSize)
+}
+
+// Write implements io.Writer.
+// It never returns errors, in order not to interrupt the recording.
+func (st *segmentStream) Write(p []byte) (int, error) {
+	n := len(p)
+
+	if st.ctx.Err() != nil {
+		return n, nil
+	}
+
+	// skip the first part.
+	if st.received < st.partSize {
+		skip := min(st.partSize-st.received, int64(len(p)))
+		st.received += skip
+		p = p[skip:]
+	}
+
+	st.received += int64(len(p))
+	st.buf = append(st.buf, p...)
+
+	for int64(len(st.buf)) >= st.partSize {
+		part := st.buf[:st.partSize]
+		st.buf = append([]byte(nil), st.buf[st.partSize:]...)
+
+		if !st.sendPart(part) {
+			return n, nil
+		}
+	}
+
+	return n, nil
+}
+
+// Close implements io.Closer.
+// It is called after the segment has been finalized.
+func (st *segmentStream) Close() error {
+	if st.ctx.Err() == nil && len(st.buf) != 0 {
+		st.sendPart(st.buf)
+		st.buf = nil
+	}
+
+	close(st.chPart)
+	return nil
+}
+
+func (st *segmentStream) sendPart(part []byte) bool {
+	select {
+	case st.chPart <- part:
+		return true
+
+	default:
+		st.u.Log(logger.Warn, "upload of %s is too slow, it will be uploaded after completion", st.segmentPath)
+		st.ctxCancel()
+		return false
+	}
+}
+
+func (st *segmentStream) run() {
+	defer st.u.streamWg.Done()
+	defer st.ctxCancel()
+
+	err := st.runInner()
+	if err != nil {
+		if !errors.Is(err, errNotStreamed) && st.u.ctx.Err() == nil {
+			st.u.Log(logger.Warn, "unable to stream %s: %v, it will be uploaded after completion",
+				st.segmentPath, err)
+		}
+		st.u.streamFailed(st)
+		return
+	}
+
+	st.u.Log(logger.Debug, "streamed %s to %s/%s", st.segmentPath, st.sc.bucket, st.key)
+	st.u.onUploaded(st.sc, st.pathName, st.segmentPath, st.key, 1)
+}
+
+func (st *segmentStream) runInner() error {
+	contentType := segmentContentType(st.segmentPath)
+	var uploadID string
+	// the ID of the first part is filled when the segment is complete.
+	parts := []string{""}
+
+	for {
+		select {
+		case part, ok := <-st.chPart:
+			if !ok {
+				if len(parts) == 1 {
+					return errNotStreamed
+				}
+
+				err := st.complete(uploadID, contentType, parts)
+				if err != nil {
+					st.abort(uploadID, parts)
+				}
+				return err
+			}
+
+			if len(parts) == 1 {
+				var err error
+				uploadID, err = st.s.createParts(st.ctx, st.key, contentType)
+				if err != nil {
+					return err
+				}
+			}
+
+			partID, err := st.s.putPart(st.ctx, st.key, uploadID, len(parts)+1, part)
+			if err != nil {
+				st.abort(uploadID, parts)
+				return fmt.Errorf("unable to upload part %d: %w", len(parts)+1, err)
+			}
+
+			parts = append(parts, partID)
+
+		case <-st.ctx.Done():
+			if len(parts) != 1 {
+				st.abort(uploadID, parts)
+			}
+			return st.ctx.Err()
+		}
+	}
+}
+
+// complete uploads the first part, that is read from the finalized segment, and joins all parts.
+func (st *segmentStream) complete(uploadID string, contentType string, parts []string) error {
+	f, err := os.Open(st.segmentPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if fi.Size() != st.received {
+		return fmt.Errorf("size of segment (%d) doesn't match streamed bytes (%d)", fi.Size(), st.received)
+	}
+
+	buf := make([]byte, st.partSize)
+	_, err = io.ReadFull(f, buf)
+	if err != nil {
+		return err
+	}
+
+	parts[0], err = st.s.putPart(st.ctx, st.key, uploadID, 1, buf)
+	if err != nil {
+		return fmt.Errorf("unable to upload part 1: %w", err)
+	}
+
+	return st.s.completeParts(st.ctx, st.key, uploadID, contentType, parts)
+}
+
+func (st *segmentStream) abort(uploadID string, parts []string) {
+	// the context of the stream may have been canceled.
+	ctx, ctxCancel := context.WithTimeout(st.u.ctx, time.Duration(st.u.ReadTimeout))
+	defer ctxCancel()
+
+	st.s.abort(ctx, st.key, &partialUpload{
+		ID:    uploadID,
+		Parts: parts,
+	})
+}
@@ -0,0 +1,126 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/events"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+	"github.com/flynnletford/mediamtx/src/test"
+)
+
+func TestUploaderStreaming(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-uploader")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	segmentPath := filepath.Join(dir, "mypath", "2008-05-20_22-15-25-000125.mp4")
+	err = os.Mkdir(filepath.Join(dir, "mypath"), 0o755)
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:9070")
+	require.NoError(t, err)
+
+	s3 := &dummyS3{
+		objects:   make(map[string][]byte),
+		parts:     make(map[int][]byte),
+		completed: make(chan string, 1),
+	}
+
+	s := &http.Server{Handler: s3}
+	go s.Serve(ln)
+	defer s.Shutdown(context.Background())
+
+	u := &Uploader{
+		PartSize:   16,
+		QueueSize:  16,
+		MaxRetries: 3,
+		Streaming:  true,
+		PathConfs: map[string]*conf.Path{"mypath": {
+			Name:                  "mypath",
+			RecordPath:            filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f"),
+			UploadStorage:         conf.UploadStorageS3,
+			UploadEndpoint:        "http://127.0.0.1:9070",
+			UploadRegion:          "us-east-1",
+			UploadBucket:          "mybucket",
+			UploadAccessKeyID:     "myid",
+			UploadSecretAccessKey: "mysecret",
+		}},
+		ReadTimeout: conf.Duration(10 * time.Second),
+		Parent:      test.NilLogger,
+	}
+	u.Initialize()
+	defer u.Close()
+
+	b := &events.Bus{}
+	b.AddSink(u)
+
+	w := b.NewSegmentStream("mypath", segmentPath)
+	require.NotNil(t, w)
+
+	f, err := os.Create(segmentPath)
+	require.NoError(t, err)
+
+	content := bytes.Repeat([]byte{1, 2, 3, 4}, 10)
+
+	for i := 0; i < len(content); i += 8 {
+		_, err = f.Write(content[i : i+8])
+		require.NoError(t, err)
+		_, err = w.Write(content[i : i+8])
+		require.NoError(t, err)
+	}
+
+	// the second part is uploaded before the segment is complete.
+	require.Eventually(t, func() bool {
+		s3.mutex.Lock()
+		defer s3.mutex.Unlock()
+		return len(s3.uploaded) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// simulate the update of the header.
+	_, err = f.WriteAt([]byte{5, 6, 7, 8}, 4)
+	require.NoError(t, err)
+	copy(content[4:], []byte{5, 6, 7, 8})
+
+	err = f.Close()
+	require.NoError(t, err)
+
+	err = w.Close()
+	require.NoError(t, err)
+
+	b.Publish(events.TypeSegmentComplete, "mypath", map[string]interface{}{
+		"segmentPath": segmentPath,
+	})
+
+	// the segment is not uploaded again.
+	u.mutex.RLock()
+	require.Empty(t, u.queue)
+	u.mutex.RUnlock()
+
+	key := <-s3.completed
+	require.Equal(t, "mypath/2008-05-20_22-15-25-000125.mp4", key)
+
+	s3.mutex.Lock()
+	require.Equal(t, content, s3.objects[key])
+	require.Equal(t, []int{2, 3, 1}, s3.uploaded)
+	s3.mutex.Unlock()
+
+	require.Eventually(t, func() bool {
+		st, err2 := recordstore.ReadUploadStatus(segmentPath)
+		if err2 != nil {
+			return false
+		}
+		require.Equal(t, recordstore.UploadStateUploaded, st.State)
+		require.Equal(t, 1, st.Attempts)
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,549 @@
+// Package uploader contains the segment uploader.
+package uploader
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/events"
+	"github.com/flynnletford/mediamtx/src/logger"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+	"github.com/flynnletford/mediamtx/src/tracing"
+)
+
+const (
+	retryPause    = 1 * time.Second
+	maxRetryPause = 30 * time.Second
+)
+
+// Uploader is a sink that uploads completed segments to a cloud storage.
+// The storage and its credentials are taken from the configuration of the path.
+// Segments are queued and uploaded in order, one at a time. Segments bigger than PartSize
+// are uploaded in multiple parts. When an upload fails, it is retried with an
+// exponential back-off up to MaxRetries times.
+// When QueuePath is not empty, the queue, including the progress of uploads
+// split into parts, is persisted to disk and resumed after a restart.
+// When Streaming is true, segments are uploaded in parts while they are written,
+// if the storage supports it.
+// The outcome of every upload is stored into a file next to the segment
+// and published to EventBus.
+// When closed, segments in queue are uploaded for up to ShutdownTimeout.
+type Uploader struct {
+	PartSize          conf.StringSize
+	QueueSize         int
+	QueuePath         string
+	MaxRetries        int
+	DeleteAfterUpload bool
+	Streaming         bool
+	PathConfs         map[string]*conf.Path
+	ReadTimeout       conf.Duration
+	ShutdownTimeout   conf.Duration
+	EventBus          *events.Bus
+	Tracer            *tracing.Tracer
+	Parent            logger.Writer
+
+	ctx           context.Context
+	ctxCancel     func()
+	httpClient    *http.Client
+	storages      map[storageConf]storage
+	storagesMutex sync.Mutex
+	mutex         sync.RWMutex
+	queue         []*queueEntry
+	closing       bool
+	streams       map[string]*segmentStream
+	streamWg      sync.WaitGroup
+
+	// in
+	chQueued chan struct{}
+
+	done chan struct{}
+}
+
+// Initialize initializes Uploader.
+func (u *Uploader) Initialize() {
+	u.ctx, u.ctxCancel = context.WithCancel(context.Background())
+
+	u.httpClient = &http.Client{
+		Timeout: time.Duration(u.ReadTimeout),
+		// redirects are part of the protocol of some storages.
+		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	u.storages = make(map[storageConf]storage)
+	u.streams = make(map[string]*segmentStream)
+	u.chQueued = make(chan struct{}, 1)
+	u.done = make(chan struct{})
+
+	u.Log(logger.Info, "uploader started")
+
+	if u.QueuePath != "" {
+		var err error
+		u.queue, err = loadQueue(u.QueuePath)
+		if err != nil {
+			u.Log(logger.Warn, "unable to load queue: %v", err)
+		} else if len(u.queue) != 0 {
+			u.Log(logger.Info, "resuming %d uploads", len(u.queue))
+		}
+	}
+
+	go u.run()
+}
+
+// Close closes Uploader.
+// It waits for segments in queue to be uploaded, for up to ShutdownTimeout.
+// Segments that are still in queue are uploaded after a restart, if the queue is persisted.
+func (u *Uploader) Close() {
+	u.Log(logger.Info, "closing")
+
+	u.mutex.Lock()
+	u.closing = true
+	u.mutex.Unlock()
+
+	// wake up the routine, that exits once the queue is empty.
+	select {
+	case u.chQueued <- struct{}{}:
+	default:
+	}
+
+	select {
+	case <-u.done:
+	case <-time.After(time.Duration(u.ShutdownTimeout)):
+		if n := u.QueueLen(); n != 0 {
+			u.Log(logger.Warn, "shutdown timeout exceeded, %d %s not uploaded",
+				n,
+				func() string {
+					if n == 1 {
+						return "segment was"
+					}
+					return "segments were"
+				}())
+		}
+	}
+
+	u.ctxCancel()
+	<-u.done
+	u.streamWg.Wait()
+	u.httpClient.CloseIdleConnections()
+
+	for _, s := range u.storages {
+		if c, ok := s.(interface{ close() }); ok {
+			c.close()
+		}
+	}
+}
+
+// Log implements logger.Writer.
+func (u *Uploader) Log(level logger.Level, format string, args ...interface{}) {
+	u.Parent.Log(level, "[uploader] "+format, args...)
+}
+
+// QueueLen returns the number of segments that are waiting to be uploaded.
+func (u *Uploader) QueueLen() int {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return len(u.queue)
+}
+
+// ReloadPathConfs is called by core.Core.
+func (u *Uploader) ReloadPathConfs(pathConfs map[string]*conf.Path) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.PathConfs = pathConfs
+}
+
+// Push implements events.Sink.
+func (u *Uploader) Push(e *events.Event) {
+	if e.Type != events.TypeSegmentComplete {
+		return
+	}
+
+	segmentPath, ok := e.Data["segmentPath"].(string)
+	if !ok {
+		return
+	}
+
+	u.mutex.Lock()
+
+	if st, ok := u.streams[segmentPath]; ok {
+		delete(u.streams, segmentPath)
+		st.pushed = true
+
+		// the segment is uploaded by the stream.
+		if !st.fallback {
+			u.mutex.Unlock()
+			return
+		}
+	}
+
+	u.mutex.Unlock()
+
+	u.enqueue(e.Path, segmentPath)
+}
+
+// NewSegmentStream implements events.SegmentStreamer.
+func (u *Uploader) NewSegmentStream(pathName string, segmentPath string) io.WriteCloser {
+	if !u.Streaming {
+		return nil
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.ctx.Err() != nil {
+		return nil
+	}
+
+	pathConf, _, err := conf.FindPathConf(u.PathConfs, pathName)
+	if err != nil {
+		return nil
+	}
+
+	sc := newStorageConf(pathConf)
+
+	s, err := u.getStorage(sc)
+	if err != nil {
+		return nil
+	}
+
+	// storages that don't support parts upload segments after completion.
+	ps, ok := s.(partStorage)
+	if !ok {
+		return nil
+	}
+
+	st := &segmentStream{
+		u:           u,
+		pathName:    pathName,
+		segmentPath: segmentPath,
+		sc:          sc,
+		s:           ps,
+		key:         objectKey(pathConf, &queueEntry{PathName: pathName, SegmentPath: segmentPath}),
+		partSize:    int64(u.PartSize),
+	}
+	st.initialize()
+
+	u.streams[segmentPath] = st
+
+	u.streamWg.Add(1)
+	go st.run()
+
+	return st
+}
+
+// streamFailed is called when a segment can't be streamed.
+// The segment is uploaded through the queue once it is complete.
+func (u *Uploader) streamFailed(st *segmentStream) {
+	u.mutex.Lock()
+	st.fallback = true
+	pushed := st.pushed
+	u.mutex.Unlock()
+
+	if pushed {
+		u.enqueue(st.pathName, st.segmentPath)
+	}
+}
+
+func (u *Uploader) enqueue(pathName string, segmentPath string) {
+	u.mutex.Lock()
+
+	if len(u.queue) >= u.QueueSize {
+		u.mutex.Unlock()
+		u.Log(logger.Warn, "queue is full, discarding %s", segmentPath)
+		return
+	}
+
+	u.queue = append(u.queue, &queueEntry{
+		PathName:    pathName,
+		SegmentPath: segmentPath,
+	})
+	u.saveQueue()
+
+	u.mutex.Unlock()
+
+	select {
+	case u.chQueued <- struct{}{}:
+	default:
+	}
+}
+
+// saveQueue must be called with the mutex locked.
+func (u *Uploader) saveQueue() {
+	if u.QueuePath == "" {
+		return
+	}
+
+	err := saveQueue(u.QueuePath, u.queue)
+	if err != nil {
+		u.Log(logger.Warn, "unable to save queue: %v", err)
+	}
+}
+
+func (u *Uploader) run() {
+	defer close(u.done)
+
+	for {
+		u.mutex.RLock()
+		var entry *queueEntry
+		if len(u.queue) != 0 {
+			entry = u.queue[0]
+		}
+		closing := u.closing
+		u.mutex.RUnlock()
+
+		if entry == nil {
+			if closing {
+				return
+			}
+
+			select {
+			case <-u.chQueued:
+				continue
+
+			case <-u.ctx.Done():
+				return
+			}
+		}
+
+		u.process(entry)
+
+		// keep the entry, in order to resume it after a restart.
+		if u.ctx.Err() != nil {
+			return
+		}
+
+		u.mutex.Lock()
+		u.queue = u.queue[1:]
+		u.saveQueue()
+		u.mutex.Unlock()
+	}
+}
+
+func (u *Uploader) setPartial(entry *queueEntry, p *partialUpload) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	entry.Partial = p
+	u.saveQueue()
+}
+
+func (u *Uploader) process(entry *queueEntry) {
+	u.mutex.RLock()
+	pathConf, _, err := conf.FindPathConf(u.PathConfs, entry.PathName)
+	u.mutex.RUnlock()
+	if err != nil {
+		u.Log(logger.Error, "unable to upload %s: %v", entry.SegmentPath, err)
+		return
+	}
+
+	sc := newStorageConf(pathConf)
+	key := objectKey(pathConf, entry)
+	pause := retryPause
+
+	for attempt := 0; ; attempt++ {
+		resumed := entry.Partial
+		if resumed != nil {
+			u.Log(logger.Debug, "resuming upload of %s from byte %d", entry.SegmentPath, resumed.Offset)
+		}
+
+		span := u.Tracer.StartChild(tracing.SegmentSpanContext(entry.SegmentPath), "upload")
+		span.SetAttribute("path", entry.PathName)
+		span.SetAttribute("segment", entry.SegmentPath)
+		span.SetAttribute("storage", sc.storage.String())
+		span.SetAttribute("key", key)
+		span.SetAttribute("attempt", attempt+1)
+
+		err = u.upload(sc, entry, key)
+		span.RecordError(err)
+		span.Finish()
+
+		if err == nil {
+			u.Log(logger.Debug, "uploaded %s to %s/%s", entry.SegmentPath, sc.bucket, key)
+			u.onUploaded(sc, entry.PathName, entry.SegmentPath, key, attempt+1)
+			return
+		}
+
+		if u.ctx.Err() != nil {
+			return
+		}
+
+		// a partial upload that can't be resumed is discarded and started again.
+		if resumed != nil && entry.Partial != nil && entry.Partial.Offset == resumed.Offset {
+			u.abort(sc, key, entry)
+		}
+
+		if attempt >= u.MaxRetries || os.IsNotExist(err) {
+			u.Log(logger.Error, "unable to upload %s: %v", entry.SegmentPath, err)
+			if entry.Partial != nil {
+				u.abort(sc, key, entry)
+			}
+			u.writeStatus(entry.PathName, entry.SegmentPath, &recordstore.UploadStatus{
+				State:    recordstore.UploadStateFailed,
+				Storage:  sc.storage.String(),
+				Bucket:   sc.bucket,
+				Key:      key,
+				Time:     time.Now(),
+				Attempts: attempt + 1,
+				Error:    err.Error(),
+			})
+			return
+		}
+
+		u.Log(logger.Warn, "unable to upload %s: %v, retrying in %v", entry.SegmentPath, err, pause)
+
+		select {
+		case <-time.After(pause):
+		case <-u.ctx.Done():
+			return
+		}
+
+		pause *= 2
+		if pause > maxRetryPause {
+			pause = maxRetryPause
+		}
+	}
+}
+
+func (u *Uploader) abort(sc storageConf, key string, entry *queueEntry) {
+	s, err := u.getStorage(sc)
+	if err == nil {
+		ctx, ctxCancel := context.WithTimeout(u.ctx, time.Duration(u.ReadTimeout))
+		s.abort(ctx, key, entry.Partial)
+		ctxCancel()
+	}
+
+	u.setPartial(entry, nil)
+}
+
+func (u *Uploader) onUploaded(sc storageConf, pathName string, segmentPath string, key string, attempts int) {
+	if u.DeleteAfterUpload {
+		err := recordstore.RemoveSegment(segmentPath)
+		if err != nil {
+			u.Log(logger.Warn, "unable to remove %s: %v", segmentPath, err)
+			return
+		}
+
+		u.EventBus.Publish(events.TypeSegmentDelete, pathName, map[string]interface{}{
+			"segmentPath": segmentPath,
+		})
+		return
+	}
+
+	// the checksum is available when recordChecksum is enabled.
+	checksum, _ := recordstore.ReadChecksum(segmentPath)
+
+	u.writeStatus(pathName, segmentPath, &recordstore.UploadStatus{
+		State:    recordstore.UploadStateUploaded,
+		Storage:  sc.storage.String(),
+		Bucket:   sc.bucket,
+		Key:      key,
+		Time:     time.Now(),
+		Attempts: attempts,
+		Checksum: checksum,
+	})
+}
+
+func (u *Uploader) writeStatus(pathName string, segmentPath string, s *recordstore.UploadStatus) {
+	// the segment may have been removed in the meanwhile.
+	if _, err := os.Stat(segmentPath); err != nil {
+		return
+	}
+
+	err := recordstore.WriteUploadStatus(segmentPath, s)
+	if err != nil {
+		u.Log(logger.Warn, "unable to write upload status of %s: %v", segmentPath, err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"segmentPath": segmentPath,
+		"state":       string(s.State),
+		"key":         s.Key,
+	}
+	if s.Error != "" {
+		data["error"] = s.Error
+	}
+	u.EventBus.Publish(events.TypeSegmentUpload, pathName, data)
+}
+
+// objectKey returns the key of the object that corresponds to a segment.
+// It is the path of the segment relative to the common part of recordPath,
+// in order to preserve the directory layout of recordings.
+func objectKey(pathConf *conf.Path, entry *queueEntry) string {
+	commonPath := recordstore.CommonPath(pathConf.RecordPath)
+
+	rel, err := filepath.Rel(commonPath, entry.SegmentPath)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return pathConf.UploadPrefix + filepath.ToSlash(rel)
+	}
+
+	return pathConf.UploadPrefix + path.Join(entry.PathName, filepath.Base(entry.SegmentPath))
+}
+
+// getStorage returns the storage with the given parameters.
+// Storages are reused, in order to reuse credentials and connections.
+func (u *Uploader) getStorage(sc storageConf) (storage, error) {
+	u.storagesMutex.Lock()
+	defer u.storagesMutex.Unlock()
+
+	if s, ok := u.storages[sc]; ok {
+		return s, nil
+	}
+
+	s, err := newStorage(sc, u.PartSize, u.httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	u.storages[sc] = s
+	return s, nil
+}
+
+func (u *Uploader) upload(sc storageConf, entry *queueEntry, key string) error {
+	s, err := u.getStorage(sc)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(entry.SegmentPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return s.upload(u.ctx, &object{
+		key:         key,
+		contentType: segmentContentType(entry.SegmentPath),
+		r:           f,
+		size:        fi.Size(),
+		partial:     entry.Partial,
+		onProgress: func(p *partialUpload) {
+			u.setPartial(entry, p)
+		},
+	})
+}
+
+func segmentContentType(segmentPath string) string {
+	switch strings.ToLower(filepath.Ext(segmentPath)) {
+	case ".mp4":
+		return "video/mp4"
+
+	case ".ts":
+		return "video/mp2t"
+	}
+
+	return "application/octet-stream"
+}
@@ -0,0 +1,293 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/events"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+	"github.com/flynnletford/mediamtx/src/test"
+)
+
+type dummyS3 struct {
+	mutex     sync.Mutex
+	failFirst bool
+	count     int
+	objects   map[string][]byte
+	parts     map[int][]byte
+	uploaded  []int
+	completed chan string
+}
+
+func (s *dummyS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=myid/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// first attempt fails, in order to test retries.
+	s.count++
+	if s.failFirst && s.count == 1 {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`<Error><Code>InternalError</Code><Message>test</Message></Error>`)) //nolint:errcheck
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/mybucket/")
+	q := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodPut && q.Get("partNumber") != "":
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		s.parts[n] = body
+		s.uploaded = append(s.uploaded, n)
+		w.Header().Set("ETag", `"etag`+q.Get("partNumber")+`"`)
+
+	case r.Method == http.MethodPut:
+		s.objects[key] = body
+		s.completed <- key
+
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>myid</UploadId>` + //nolint:errcheck
+			`</InitiateMultipartUploadResult>`))
+
+	case r.Method == http.MethodPost && q.Get("uploadId") == "myid":
+		var req s3CompleteMultipartUpload
+		err = xml.Unmarshal(body, &req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var buf bytes.Buffer
+		for _, p := range req.Parts {
+			buf.Write(s.parts[p.PartNumber])
+		}
+		s.objects[key] = buf.Bytes()
+		w.Write([]byte(`<CompleteMultipartUploadResult></CompleteMultipartUploadResult>`)) //nolint:errcheck
+		s.completed <- key
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestUploader(t *testing.T) {
+	for _, ca := range []string{"single", "multipart", "resume"} {
+		t.Run(ca, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "mediamtx-uploader")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			segmentPath := filepath.Join(dir, "mypath", "2008-05-20_22-15-25-000125.mp4")
+			err = os.Mkdir(filepath.Join(dir, "mypath"), 0o755)
+			require.NoError(t, err)
+
+			content := bytes.Repeat([]byte{1, 2, 3, 4}, 10)
+			err = os.WriteFile(segmentPath, content, 0o644)
+			require.NoError(t, err)
+
+			ln, err := net.Listen("tcp", "127.0.0.1:9070")
+			require.NoError(t, err)
+
+			s3 := &dummyS3{
+				failFirst: (ca == "single"),
+				objects:   make(map[string][]byte),
+				parts:     make(map[int][]byte),
+				completed: make(chan string, 1),
+			}
+
+			queuePath := filepath.Join(dir, "queue.json")
+
+			// simulate a restart that happened after the first part was uploaded.
+			if ca == "resume" {
+				s3.parts[1] = content[:16]
+
+				err = saveQueue(queuePath, []*queueEntry{{
+					PathName:    "mypath",
+					SegmentPath: segmentPath,
+					Partial: &partialUpload{
+						ID:       "myid",
+						Parts:    []string{`"etag1"`},
+						Offset:   16,
+						PartSize: 16,
+					},
+				}})
+				require.NoError(t, err)
+			}
+
+			s := &http.Server{Handler: s3}
+			go s.Serve(ln)
+			defer s.Shutdown(context.Background())
+
+			pathConf := &conf.Path{
+				Name:                  "mypath",
+				RecordPath:            filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f"),
+				UploadStorage:         conf.UploadStorageS3,
+				UploadEndpoint:        "http://127.0.0.1:9070",
+				UploadRegion:          "us-east-1",
+				UploadBucket:          "mybucket",
+				UploadAccessKeyID:     "myid",
+				UploadSecretAccessKey: "mysecret",
+				UploadPrefix:          "myprefix/",
+			}
+
+			u := &Uploader{
+				PartSize: func() conf.StringSize {
+					if ca == "single" {
+						return 1024
+					}
+					return 16
+				}(),
+				QueueSize:         16,
+				QueuePath:         queuePath,
+				MaxRetries:        3,
+				DeleteAfterUpload: (ca == "multipart"),
+				PathConfs:         map[string]*conf.Path{"mypath": pathConf},
+				ReadTimeout:       conf.Duration(10 * time.Second),
+				Parent:            test.NilLogger,
+			}
+			u.Initialize()
+			defer u.Close()
+
+			b := &events.Bus{}
+			b.AddSink(u)
+
+			if ca != "resume" {
+				b.Publish(events.TypeSourceReady, "mypath", nil)
+				b.Publish(events.TypeSegmentComplete, "mypath", map[string]interface{}{
+					"segmentPath": segmentPath,
+				})
+			}
+
+			key := <-s3.completed
+			require.Equal(t, "myprefix/mypath/2008-05-20_22-15-25-000125.mp4", key)
+
+			s3.mutex.Lock()
+			require.Equal(t, content, s3.objects[key])
+			if ca == "resume" {
+				require.Equal(t, []int{2, 3}, s3.uploaded)
+			}
+			s3.mutex.Unlock()
+
+			require.Eventually(t, func() bool {
+				entries, err2 := loadQueue(queuePath)
+				require.NoError(t, err2)
+				return len(entries) == 0
+			}, 5*time.Second, 10*time.Millisecond)
+
+			switch ca {
+			case "single":
+				require.Eventually(t, func() bool {
+					st, err2 := recordstore.ReadUploadStatus(segmentPath)
+					if err2 != nil {
+						return false
+					}
+					require.Equal(t, recordstore.UploadStateUploaded, st.State)
+					require.Equal(t, "s3", st.Storage)
+					require.Equal(t, "mybucket", st.Bucket)
+					require.Equal(t, key, st.Key)
+					require.Equal(t, 2, st.Attempts)
+					return true
+				}, 5*time.Second, 10*time.Millisecond)
+
+			case "multipart":
+				require.Eventually(t, func() bool {
+					_, err2 := os.Stat(segmentPath)
+					return os.IsNotExist(err2)
+				}, 5*time.Second, 10*time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestUploaderDrainOnClose(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-uploader")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "mypath"), 0o755)
+	require.NoError(t, err)
+
+	var segmentPaths []string
+
+	for _, name := range []string{"2008-05-20_22-15-25-000125.mp4", "2008-05-20_22-15-26-000125.mp4"} {
+		segmentPath := filepath.Join(dir, "mypath", name)
+		err = os.WriteFile(segmentPath, []byte{1, 2, 3, 4}, 0o644)
+		require.NoError(t, err)
+		segmentPaths = append(segmentPaths, segmentPath)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:9070")
+	require.NoError(t, err)
+
+	s3 := &dummyS3{
+		objects:   make(map[string][]byte),
+		parts:     make(map[int][]byte),
+		completed: make(chan string, len(segmentPaths)),
+	}
+
+	s := &http.Server{Handler: s3}
+	go s.Serve(ln)
+	defer s.Shutdown(context.Background())
+
+	pathConf := &conf.Path{
+		Name:                  "mypath",
+		RecordPath:            filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f"),
+		UploadStorage:         conf.UploadStorageS3,
+		UploadEndpoint:        "http://127.0.0.1:9070",
+		UploadRegion:          "us-east-1",
+		UploadBucket:          "mybucket",
+		UploadAccessKeyID:     "myid",
+		UploadSecretAccessKey: "mysecret",
+	}
+
+	u := &Uploader{
+		PartSize:        1024,
+		QueueSize:       16,
+		MaxRetries:      3,
+		PathConfs:       map[string]*conf.Path{"mypath": pathConf},
+		ReadTimeout:     conf.Duration(10 * time.Second),
+		ShutdownTimeout: conf.Duration(10 * time.Second),
+		Parent:          test.NilLogger,
+	}
+	u.Initialize()
+
+	for _, segmentPath := range segmentPaths {
+		u.Push(&events.Event{
+			Type: events.TypeSegmentComplete,
+			Path: "mypath",
+			Data: map[string]interface{}{
+				"segmentPath": segmentPath,
+			},
+		})
+	}
+
+	u.Close()
+
+	require.Equal(t, 0, u.QueueLen())
+	require.Len(t, s3.completed, len(segmentPaths))
+}
@@ -0,0 +1,35 @@
+package uploader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+)
+
+// RemoteChecksum downloads the remote copy of a segment and computes its SHA-256 checksum.
+// The remote copy is searched in the storage currently set in the path configuration.
+func RemoteChecksum(
+	ctx context.Context,
+	pathConf *conf.Path,
+	pathName string,
+	segmentPath string,
+) (string, error) {
+	key := objectKey(pathConf, &queueEntry{PathName: pathName, SegmentPath: segmentPath})
+
+	// use the key of the upload, in case the prefix has changed in the meanwhile.
+	if st, err := recordstore.ReadUploadStatus(segmentPath); err == nil && st.State == recordstore.UploadStateUploaded {
+		key = st.Key
+	}
+
+	h := sha256.New()
+
+	err := download(ctx, pathConf, key, h)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,49 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/flynnletford/mediamtx/src/conf"
+	"github.com/flynnletford/mediamtx/src/recordstore"
+)
+
+func TestRemoteChecksum(t *testing.T) {
+	dir, err := os.MkdirTemp("", "mediamtx-uploader")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	segmentPath := filepath.Join(dir, "mypath", "2008-05-20_22-15-25-000125.mp4")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:9075")
+	require.NoError(t, err)
+
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodGet, r.Method)
+			require.Equal(t, "/dav/myprefix/mypath/2008-05-20_22-15-25-000125.mp4", r.URL.Path)
+			w.Write([]byte("abc")) //nolint:errcheck
+		}),
+	}
+	go s.Serve(ln)
+	defer s.Shutdown(context.Background())
+
+	checksum, err := RemoteChecksum(context.Background(), &conf.Path{
+		RecordPath:     filepath.Join(dir, "%path/%Y-%m-%d_%H-%M-%S-%f"),
+		UploadStorage:  conf.UploadStorageWebDAV,
+		UploadEndpoint: "http://127.0.0.1:9075/dav",
+		UploadPrefix:   "myprefix/",
+	}, "mypath", segmentPath)
+	require.NoError(t, err)
+
+	expected, err := recordstore.ComputeChecksum(bytes.NewReader([]byte("abc")))
+	require.NoError(t, err)
+	require.Equal(t, expected, checksum)
+}
@@ -0,0 +1,206 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// webDAVStorage is a minimal client of the WebDAV protocol.
+// Objects are uploaded into a temporary file, that is then moved into
+// its final location, in order to never expose incomplete segments.
+type webDAVStorage struct {
+	endpoint   string
+	user       string
+	pass       string
+	httpClient *http.Client
+
+	mutex   sync.Mutex
+	created map[string]struct{}
+}
+
+func newWebDAVStorage(sc storageConf, httpClient *http.Client) (*webDAVStorage, error) {
+	return &webDAVStorage{
+		endpoint:   strings.TrimRight(sc.endpoint, "/"),
+		user:       sc.user,
+		pass:       sc.pass,
+		httpClient: httpClient,
+		created:    make(map[string]struct{}),
+	}, nil
+}
+
+// upload implements storage.
+func (c *webDAVStorage) upload(ctx context.Context, obj *object) error {
+	err := c.makeCollections(ctx, path.Dir(obj.key))
+	if err != nil {
+		return err
+	}
+
+	tmpKey := tempKey(obj.key)
+
+	err = c.do(ctx, http.MethodPut, tmpKey, http.Header{
+		"Content-Type": []string{obj.contentType},
+	}, io.NopCloser(obj.r), obj.size)
+	if err != nil {
+		// collections may have been removed in the meanwhile.
+		c.mutex.Lock()
+		clear(c.created)
+		c.mutex.Unlock()
+		return err
+	}
+
+	return c.do(ctx, "MOVE", tmpKey, http.Header{
+		"Destination": []string{c.objectURL(obj.key)},
+		"Overwrite":   []string{"T"},
+	}, nil, 0)
+}
+
+// abort implements storage.
+func (c *webDAVStorage) abort(ctx context.Context, key string, _ *partialUpload) {
+	c.do(ctx, http.MethodDelete, tempKey(key), nil, nil, 0) //nolint:errcheck
+}
+
+// download implements storage.
+func (c *webDAVStorage) download(ctx context.Context, key string, w io.Writer) error {
+	res, err := c.request(ctx, http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+// makeCollections creates the collection with the given key and its parents.
+func (c *webDAVStorage) makeCollections(ctx context.Context, key string) error {
+	if key == "." || key == "/" || key == "" {
+		return nil
+	}
+
+	c.mutex.Lock()
+	_, ok := c.created[key]
+	c.mutex.Unlock()
+	if ok {
+		return nil
+	}
+
+	err := c.makeCollections(ctx, path.Dir(key))
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(ctx, "MKCOL", key+"/", nil, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+
+	// 405 is returned when the collection already exists.
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusMethodNotAllowed {
+		return fmt.Errorf("unable to create collection '%s': bad status code: %d", key, res.StatusCode)
+	}
+
+	c.mutex.Lock()
+	c.created[key] = struct{}{}
+	c.mutex.Unlock()
+
+	return nil
+}
+
+func (c *webDAVStorage) objectURL(key string) string {
+	return c.endpoint + "/" + s3EscapePath(key)
+}
+
+func (c *webDAVStorage) newRequest(
+	ctx context.Context,
+	method string,
+	key string,
+	header http.Header,
+	body io.ReadCloser,
+	size int64,
+) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if body != nil {
+		if size == 0 {
+			req.Body = http.NoBody
+		} else {
+			req.Body = body
+			req.ContentLength = size
+		}
+	}
+
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+
+	return req, nil
+}
+
+func (c *webDAVStorage) do(
+	ctx context.Context,
+	method string,
+	key string,
+	header http.Header,
+	body io.ReadCloser,
+	size int64,
+) error {
+	res, err := c.request(ctx, method, key, header, body, size)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+	return nil
+}
+
+func (c *webDAVStorage) request(
+	ctx context.Context,
+	method string,
+	key string,
+	header http.Header,
+	body io.ReadCloser,
+	size int64,
+) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, key, header, body, size)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		res.Body.Close()
+		return nil, fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	return res, nil
+}
+
+// tempKey returns the key of the temporary file that is used during uploads.
+func tempKey(key string) string {
+	return key + ".part"
+}
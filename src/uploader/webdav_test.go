@@ -0,0 +1,102 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebDAVStorage(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:9073")
+	require.NoError(t, err)
+
+	var mutex sync.Mutex
+	collections := map[string]struct{}{"/dav/": {}}
+	files := make(map[string][]byte)
+
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			user, pass, ok := r.BasicAuth()
+			require.True(t, ok)
+			require.Equal(t, "myuser", user)
+			require.Equal(t, "mypass", pass)
+
+			parent := r.URL.Path[:strings.LastIndex(strings.TrimSuffix(r.URL.Path, "/"), "/")+1]
+
+			switch r.Method {
+			case "MKCOL":
+				if _, ok := collections[r.URL.Path]; ok {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				if _, ok := collections[parent]; !ok {
+					w.WriteHeader(http.StatusConflict)
+					return
+				}
+				collections[r.URL.Path] = struct{}{}
+				w.WriteHeader(http.StatusCreated)
+
+			case http.MethodPut:
+				if _, ok := collections[parent]; !ok {
+					w.WriteHeader(http.StatusConflict)
+					return
+				}
+				require.Equal(t, "video/mp4", r.Header.Get("Content-Type"))
+				body, err2 := io.ReadAll(r.Body)
+				require.NoError(t, err2)
+				files[r.URL.Path] = body
+				w.WriteHeader(http.StatusCreated)
+
+			case "MOVE":
+				require.Equal(t, "T", r.Header.Get("Overwrite"))
+				dest := strings.TrimPrefix(r.Header.Get("Destination"), "http://127.0.0.1:9073")
+				files[dest] = files[r.URL.Path]
+				delete(files, r.URL.Path)
+				w.WriteHeader(http.StatusCreated)
+
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		}),
+	}
+	go s.Serve(ln)
+	defer s.Shutdown(context.Background())
+
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	defer httpClient.CloseIdleConnections()
+
+	st, err := newWebDAVStorage(storageConf{
+		endpoint: "http://127.0.0.1:9073/dav/",
+		user:     "myuser",
+		pass:     "mypass",
+	}, httpClient)
+	require.NoError(t, err)
+
+	content := bytes.Repeat([]byte{1, 2, 3, 4}, 10)
+
+	for i := 0; i < 2; i++ {
+		err = st.upload(context.Background(), &object{
+			key:         "myprefix/mypath/1.mp4",
+			contentType: "video/mp4",
+			r:           bytes.NewReader(content),
+			size:        int64(len(content)),
+			onProgress:  func(*partialUpload) {},
+		})
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, map[string][]byte{
+		"/dav/myprefix/mypath/1.mp4": content,
+	}, files)
+}